@@ -0,0 +1,603 @@
+// Package agent wires together all of the pieces that make up a running
+// Sidecar node: the catalog, memberlist, discovery, health checking, the
+// proxies, and the HTTP API. The sidecar binary is a thin wrapper around it,
+// and it can be used to embed a Sidecar node in another Go program.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/envoy"
+	"github.com/NinesStack/sidecar/envoy/adapter"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/healthy"
+	"github.com/NinesStack/sidecar/notify"
+	"github.com/NinesStack/sidecar/objectstore"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/NinesStack/sidecar/sidecarhttp"
+	"github.com/NinesStack/sidecar/templates"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LeaveTimeout = 5 * time.Second // How long we wait to tell the cluster we're leaving
+)
+
+// An Agent is a single Sidecar node. Create one with New() and then start it
+// with Run().
+type Agent struct {
+	Config     *config.Config
+	State      *catalog.ServicesState
+	Memberlist *memberlist.Memberlist
+	Monitor    *healthy.Monitor
+	Discovery  discovery.Discoverer
+	HAproxy    *haproxy.HAproxy          // nil when HAproxy management is disabled
+	Weights    *catalog.WeightController // nil when load weighting is disabled
+	Reporter   *cluster.Reporter         // Keeps track of the cluster members
+	Gossip     *cluster.GossipTuner      // Scales the gossip with the cluster, when enabled
+	Notifier   *notify.Notifier          // nil when no notification sinks are configured
+	Templates  *templates.Writer         // nil when there are no template outputs
+	Uploader   *objectstore.Uploader     // nil when the state isn't uploaded anywhere
+
+	// The transport Memberlist gossips over. Set before Run() to use a custom
+	// one, otherwise it's picked from the config.
+	Transport memberlist.Transport
+
+	mlConfig      *memberlist.Config
+	bindAddrs     adapter.BindAddrs        // Named interfaces for Envoy listeners
+	checkDefaults []*healthy.CheckDefault  // Health checks by image
+	checkPolicy   healthy.StatusPolicy     // How checks become statuses by default
+	signer        *catalog.ListenerSigner  // Signs the posts to listeners, nil to leave them unsigned
+	listenerTLS   *http.Transport          // Posts to listeners with our client certificate, nil for the default
+	portAllocator *discovery.PortAllocator // Hands out ServicePorts, nil when there's no pool
+	envoyAuth     *envoy.ServerAuth        // Who may use the Envoy gRPC API
+	running       bool
+	started       time.Time
+}
+
+// New returns an Agent configured from the supplied config. Nothing is
+// started until Run() is called, but any configuration errors that we can
+// detect up front are returned here.
+func New(config *config.Config) (*Agent, error) {
+	if config == nil {
+		return nil, errors.New("can't create an agent without a config")
+	}
+
+	agent := &Agent{
+		Config: config,
+		State:  catalog.NewServicesState(),
+	}
+
+	if config.Sidecar.Dev {
+		configureDevMode(config)
+		agent.State.TimeScale = config.Sidecar.DevTimeScale
+	}
+
+	// Register the cluster name with the state object
+	agent.State.ClusterName = config.Sidecar.ClusterName
+	agent.State.BroadcastJitter = config.Sidecar.BroadcastJitter
+	agent.State.MaxClockSkew = config.Sidecar.MaxClockSkew
+	agent.State.CompensateClockSkew = config.Sidecar.CompensateClockSkew
+	agent.State.DrainTTL = config.Sidecar.DrainTTL
+	agent.State.CheckMaxAge = config.Sidecar.CheckMaxAge
+	agent.State.TombstoneRetention = config.Sidecar.TombstoneRetention
+	agent.State.EphemeralRetention = config.Sidecar.EphemeralRetention
+
+	var err error
+	agent.State.ValidationPolicy, err = catalog.ParseValidationPolicy(config.Sidecar.ValidationPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.mlConfig, err = configureMemberlist(config, agent.State)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reporter sees membership changes before the services delegate does
+	agent.Reporter, err = configureReporter(config)
+	if err != nil {
+		return nil, err
+	}
+	agent.Reporter.Next = agent.mlConfig.Events
+	agent.mlConfig.Events = agent.Reporter
+
+	agent.Gossip, err = configureGossipTuner(config, agent.mlConfig, agent.State)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure we're known by the same name everywhere. Discovery picks it
+	// up from the local Memberlist node.
+	hostname, err := configureHostname(config)
+	if err != nil {
+		return nil, err
+	}
+	agent.State.Hostname = hostname
+	agent.mlConfig.Name = hostname
+
+	agent.Weights, err = configureWeights(config)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.bindAddrs, err = adapter.ParseBindAddrs(config.Envoy.BindAddrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Envoy bind addresses: %w", err)
+	}
+
+	err = checkDiscoveryPrecedence(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDiscoveryPlatform(config)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.portAllocator, err = configurePortAllocator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Envoy.UseGRPCAPI {
+		agent.envoyAuth, err = envoy.LoadServerAuth(config.Envoy)
+		if err != nil {
+			return nil, err
+		}
+
+		err = envoy.CheckShutdownMode(config.Envoy.ShutdownMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Listeners.SigningKeysFile != "" {
+		agent.signer, err = catalog.NewListenerSigner(
+			config.Listeners.SigningMethod, config.Listeners.SigningKeysFile, config.Sidecar.ClusterName,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign listener posts: %w", err)
+		}
+	}
+
+	listenerTLS, err := catalog.LoadListenerTLS(
+		config.Listeners.TLSCert, config.Listeners.TLSKey, config.Listeners.TLSCA,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if listenerTLS != nil {
+		agent.listenerTLS = catalog.NewListenerTransport(listenerTLS)
+	}
+
+	for _, dir := range []string{config.Http.UiDir, config.Http.StaticDir} {
+		err = sidecarhttp.ValidateFileDir(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	agent.checkPolicy, err = healthy.ParseStatusPolicy(config.Sidecar.CheckPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check policy: %w", err)
+	}
+
+	if config.Sidecar.CheckDefaultsFile != "" {
+		agent.checkDefaults, err = healthy.LoadCheckDefaults(config.Sidecar.CheckDefaultsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var outputs []*templates.Output
+	if config.Sidecar.TemplateOutputsFile != "" {
+		outputs, err = templates.LoadOutputs(config.Sidecar.TemplateOutputsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Sidecar.PrometheusTargetsFile != "" {
+		outputs = append(outputs, templates.PrometheusOutput(config.Sidecar.PrometheusTargetsFile))
+	}
+
+	if len(outputs) > 0 {
+		agent.Templates = templates.NewWriter(outputs)
+	}
+
+	// Need to configure HAproxy before we start, otherwise it won't see the
+	// first events from discovered services.
+	if !config.HAproxy.Disable && !haproxy.Supported {
+		log.Warnf("HAproxy isn't supported on %s, disabling it", runtime.GOOS)
+	}
+
+	if !config.HAproxy.Disable && haproxy.Supported {
+		agent.HAproxy, err = configureHAproxy(config)
+		if err != nil {
+			return nil, err
+		}
+		agent.HAproxy.Weights = agent.Weights
+	}
+
+	agent.Uploader, err = configureUploader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.Notifier = configureNotifier(config, agent.State)
+	if agent.Notifier != nil {
+		agent.Notifier.Next = agent.mlConfig.Events
+		agent.mlConfig.Events = agent.Notifier
+		agent.State.OnServerExpired = agent.Notifier.ServerExpired
+
+		if agent.HAproxy != nil {
+			agent.HAproxy.OnResult = agent.Notifier.HAproxyResult
+		}
+	}
+
+	return agent, nil
+}
+
+// AdvertiseAddr returns the address we announce to the rest of the cluster
+func (a *Agent) AdvertiseAddr() string {
+	return a.mlConfig.AdvertiseAddr
+}
+
+// prepareListener signs the listener's posts and sends them with our client
+// certificate, when those are configured
+func (a *Agent) prepareListener(listener *catalog.UrlListener) {
+	listener.Signer = a.signer
+	if a.listenerTLS != nil {
+		listener.Client.Transport = a.listenerTLS
+	}
+}
+
+// Run starts the agent, joins the cluster, and blocks until the context is
+// cancelled. When that happens, we stop all of the background loops and
+// leave the cluster before returning.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.running {
+		return errors.New("agent is already running")
+	}
+	a.running = true
+
+	config := a.Config
+	state := a.State
+	a.started = time.Now().UTC()
+
+	// Everything we start in the background is tracked here so that we can
+	// wait for it all to stop when we shut down.
+	var wg sync.WaitGroup
+	background := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	// Fire up the state processor. We need this to happen early in the
+	// startup. It has its own context because it has to outlive everything
+	// that sends it updates, or they could block on shutdown.
+	msgCtx, stopMsgs := context.WithCancel(context.Background())
+	msgsDone := make(chan struct{})
+	svcMsgLooper := director.NewFreeLooper(director.FOREVER, nil)
+	go func() {
+		state.ProcessServiceMsgs(msgCtx, svcMsgLooper)
+		close(msgsDone)
+	}()
+	defer func() {
+		stopMsgs()
+		<-msgsDone
+	}()
+
+	configureListeners(config, state, a.prepareListener)
+
+	if a.Transport == nil && config.Sidecar.GossipTransport == "tcp" {
+		transport, err := NewTCPTransport(a.mlConfig.BindAddr, a.mlConfig.BindPort)
+		if err != nil {
+			return err
+		}
+		a.Transport = transport
+	}
+	a.mlConfig.Transport = a.Transport
+
+	list, err := memberlist.Create(a.mlConfig)
+	if err != nil {
+		if a.Transport != nil {
+			_ = a.Transport.Shutdown()
+		}
+		return fmt.Errorf("failed to create memberlist: %w", err)
+	}
+	a.Memberlist = list
+	state.Members = memberNames(list)
+
+	// Join an existing cluster by specifying at least one known member.
+	nodeCount, err := list.Join(config.Sidecar.Seeds)
+	if err != nil {
+		list.Shutdown()
+		return fmt.Errorf("failed to join cluster: %w", err)
+	}
+	log.Infof("Joined cluster with %d nodes contacted", nodeCount)
+
+	// Cancelled on shutdown, or if we fail part way through starting up
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err = a.runBackground(ctx, background)
+	if err != nil {
+		cancel()
+		wg.Wait()
+		list.Shutdown()
+		return err
+	}
+
+	<-ctx.Done()
+
+	log.Info("Shutting down, stopping background processing")
+	wg.Wait()
+
+	log.Info("Leaving the cluster")
+	err = list.Leave(LeaveTimeout)
+	if err != nil {
+		log.Warnf("Failed to leave the cluster cleanly: %s", err)
+	}
+
+	return list.Shutdown()
+}
+
+// runBackground starts all of the background processing that needs a running
+// Memberlist. Everything is started with background() so that it's tracked
+// and stops when the context is cancelled.
+func (a *Agent) runBackground(ctx context.Context, background func(func())) error {
+	config := a.Config
+	state := a.State
+	list := a.Memberlist
+
+	// Set up a bunch of go-director Loopers to run our
+	// background goroutines
+	servicesLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
+	)
+	tombstoneLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.TOMBSTONE_SLEEP_INTERVAL, nil,
+	)
+	trackingLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
+	)
+	discoLooper := director.NewTimedLooper(
+		director.FOREVER, config.Sidecar.DiscoverySleepInterval, nil,
+	)
+	listenLooper := director.NewTimedLooper(
+		director.FOREVER, discovery.DefaultSleepInterval, nil,
+	)
+	healthWatchLooper := director.NewTimedLooper(
+		director.FOREVER, healthy.WATCH_INTERVAL, nil,
+	)
+	healthLooper := director.NewTimedLooper(
+		director.FOREVER, healthy.HEALTH_INTERVAL, nil,
+	)
+	healthReportLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.HEALTH_REPORT_INTERVAL, nil,
+	)
+	stateMetricsLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.STATE_METRICS_INTERVAL, nil,
+	)
+	freshnessLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.FRESHNESS_INTERVAL, nil,
+	)
+
+	var err error
+	// Use the ports the cluster already has for a service, and don't hand
+	// out ports that other services already have
+	if a.portAllocator != nil {
+		a.portAllocator.Taken = state.ServicePortOwners
+		a.portAllocator.Allocated = state.AllocatedServicePorts
+	}
+
+	a.Discovery, err = configureDiscovery(config, a.AdvertiseAddr(), list.LocalNode(), a.portAllocator)
+	if err != nil {
+		return err
+	}
+	disco := a.Discovery
+	background(func() { disco.Run(ctx, discoLooper) })
+
+	// Don't tombstone our own services while discovery is failing
+	if reporter, ok := disco.(discovery.HealthReporter); ok {
+		gate := &discovery.HealthGate{
+			Reporter:  reporter,
+			Threshold: config.Sidecar.DiscoveryFailures,
+		}
+		state.DiscoveryHealthy = gate.Healthy
+	}
+
+	// Configure the monitor and use the public address as the default
+	// check address.
+	a.Monitor = healthy.NewMonitor(a.AdvertiseAddr(), config.Sidecar.DefaultCheckEndpoint)
+	a.Monitor.CheckAggregation = config.Sidecar.CheckAggregation
+	a.Monitor.CheckDefaults = a.checkDefaults
+	a.Monitor.StatusPolicy = a.checkPolicy
+	configureTcpChecks(a.Monitor, config)
+	monitor := a.Monitor
+
+	// Wrap the monitor Services function as a simple func without the
+	// receiver, tagging the services with our node labels, and taking the
+	// results of any checker nodes
+	labels := config.Sidecar.NodeLabels
+	serviceFunc := func() []service.Service {
+		return state.ApplyRemoteChecks(labelServices(monitor.Services(), labels))
+	}
+
+	// Wrap the discovery Listeners output in something the state can handle
+	listenFunc := func() []catalog.Listener {
+		listeners := disco.Listeners()
+		var result []catalog.Listener
+		for _, discovered := range listeners {
+			newLstnr := catalog.NewUrlListener(discovered.Url, true)
+			newLstnr.SetName(discovered.Name)
+			a.prepareListener(newLstnr)
+			result = append(result, newLstnr)
+		}
+		return result
+	}
+
+	if a.HAproxy != nil {
+		background(func() { a.HAproxy.Watch(ctx, state) })
+
+		if config.HAproxy.TemplateWatchInterval > 0 {
+			templateLooper := director.NewTimedLooper(
+				director.FOREVER, config.HAproxy.TemplateWatchInterval, nil,
+			)
+			background(func() { a.HAproxy.WatchTemplate(ctx, state, templateLooper) })
+		}
+	}
+
+	if a.Templates != nil {
+		background(func() { a.Templates.Watch(ctx, state) })
+	}
+
+	if a.Uploader != nil {
+		a.Uploader.IsSender = state.IsLeader
+		background(func() { a.Uploader.Watch(ctx, state) })
+	}
+
+	if a.Notifier != nil {
+		a.Notifier.IsSender = state.IsLeader
+		background(func() { a.Notifier.Run(ctx) })
+	}
+
+	if config.Sidecar.ClusterReportInterval > 0 {
+		reportLooper := director.NewTimedLooper(
+			director.FOREVER, config.Sidecar.ClusterReportInterval, nil,
+		)
+		background(func() { a.Reporter.Run(ctx, reportLooper, list, state) })
+	}
+
+	if config.Sidecar.GossipAutoScale {
+		gossipLooper := director.NewTimedLooper(
+			director.FOREVER, cluster.GOSSIP_TUNE_INTERVAL, nil,
+		)
+		background(func() { a.Gossip.Run(ctx, gossipLooper, list) })
+	}
+
+	configureLeaderListeners(ctx, config, state, a.prepareListener, background)
+
+	background(func() { state.BroadcastServices(ctx, serviceFunc, servicesLooper) })
+	background(func() { state.BroadcastTombstones(ctx, serviceFunc, tombstoneLooper) })
+	background(func() { state.TrackNewServices(ctx, serviceFunc, trackingLooper) })
+	background(func() { state.TrackLocalListeners(ctx, listenFunc, listenLooper) })
+	background(func() { state.ReportServiceHealth(ctx, healthReportLooper) })
+	background(func() { state.ReportStateMetrics(ctx, stateMetricsLooper) })
+	background(func() { state.TrackFreshness(ctx, freshnessLooper) })
+	background(func() { monitor.Watch(ctx, disco, healthWatchLooper) })
+	background(func() { monitor.Run(ctx, healthLooper) })
+
+	if config.Sidecar.CheckerNode {
+		configureRemoteChecks(ctx, config, state, background)
+	}
+
+	if a.Weights != nil {
+		weightsLooper := director.NewTimedLooper(
+			director.FOREVER, catalog.WEIGHT_UPDATE_INTERVAL, nil,
+		)
+		background(func() { a.Weights.Run(ctx, state, weightsLooper) })
+	}
+
+	// Created before the HTTP server so that it can report on it
+	var envoyServer *envoy.Server
+	if config.Envoy.UseGRPCAPI {
+		envoyServer = envoy.NewServer(state, config.Envoy)
+		envoyServer.Weights = a.Weights
+		envoyServer.BindAddrs = a.bindAddrs
+		envoyServer.Auth = a.envoyAuth
+	}
+
+	discoveryStatus, _ := disco.(discovery.StatusReporter)
+
+	background(func() {
+		sidecarhttp.ServeHttp(ctx, list, state, &sidecarhttp.HttpConfig{
+			BindIP:               config.HAproxy.BindIP,
+			UseHostnames:         config.HAproxy.UseHostnames,
+			HAproxy:              a.HAproxy,
+			ListenIP:             config.Http.BindIP,
+			ListenPort:           config.Http.Port,
+			ReadHeaderTimeout:    config.Http.ReadHeaderTimeout,
+			ReadTimeout:          config.Http.ReadTimeout,
+			WriteTimeout:         config.Http.WriteTimeout,
+			IdleTimeout:          config.Http.IdleTimeout,
+			MaxHeaderBytes:       config.Http.MaxHeaderBytes,
+			EnableH2C:            config.Http.EnableH2C,
+			AccessLog:            config.Http.AccessLog,
+			SlowRequestThreshold: config.Http.SlowRequestThreshold,
+			Build:                buildInfo(),
+			Started:              a.started,
+			ConfigFingerprint:    configFingerprint(config),
+			Discovery:            config.Sidecar.Discovery,
+			DiscoveryStatus:      discoveryStatus,
+			Envoy:                envoyServer,
+			Cluster:              a.Reporter,
+			Gossip:               a.Gossip,
+			Monitor:              a.Monitor,
+			HideTombstones:       config.Http.HideTombstones,
+			PortAllocator:        a.portAllocator,
+			UIDir:                config.Http.UiDir,
+			StaticDir:            config.Http.StaticDir,
+			CORS: &sidecarhttp.CORSConfig{
+				AllowedOrigins: config.Http.CORSAllowedOrigins,
+				AllowedMethods: config.Http.CORSAllowedMethods,
+				AllowedHeaders: config.Http.CORSAllowedHeaders,
+			},
+		})
+	})
+
+	if config.Diagnostics.Port > 0 {
+		background(func() {
+			sidecarhttp.ServeDiagnostics(ctx, &sidecarhttp.DiagnosticsConfig{
+				ListenIP:             config.Diagnostics.BindIP,
+				ListenPort:           config.Diagnostics.Port,
+				Pprof:                config.Diagnostics.Pprof,
+				Expvar:               config.Diagnostics.Expvar,
+				MutexProfileFraction: config.Diagnostics.MutexProfileFraction,
+				BlockProfileRate:     config.Diagnostics.BlockProfileRate,
+			})
+		})
+	}
+
+	if a.HAproxy != nil {
+		err := a.HAproxy.WriteAndReload(state)
+		if err != nil {
+			return fmt.Errorf("failed to reload HAProxy config: %w", err)
+		}
+	}
+
+	if envoyServer != nil {
+		envoyServerLooper := director.NewTimedLooper(
+			director.FOREVER, envoy.LooperUpdateInterval, nil,
+		)
+
+		// This listener will be owned and managed by the gRPC server
+		grpcListener, err := net.Listen("tcp", ":"+config.Envoy.GRPCPort)
+		if err != nil {
+			return fmt.Errorf("failed to listen on port %q: %w", config.Envoy.GRPCPort, err)
+		}
+
+		background(func() { envoyServer.Run(ctx, envoyServerLooper, grpcListener) })
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_New(t *testing.T) {
+	Convey("New()", t, func() {
+		cfg := &config.Config{}
+		cfg.Sidecar.AdvertiseIP = "10.0.0.1"
+		cfg.Sidecar.ClusterName = "default"
+		cfg.HAproxy.Disable = true
+
+		Convey("returns a configured agent", func() {
+			sidecar, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(sidecar.State, ShouldNotBeNil)
+			So(sidecar.State.ClusterName, ShouldEqual, "default")
+			So(sidecar.AdvertiseAddr(), ShouldEqual, "10.0.0.1")
+			So(sidecar.HAproxy, ShouldBeNil)
+		})
+
+		Convey("returns an error without a config", func() {
+			_, err := New(nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("relaxes failure detection for TCP gossip", func() {
+			cfg.Sidecar.GossipTransport = "tcp"
+			sidecar, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(sidecar.mlConfig.ProbeTimeout, ShouldEqual, 3*time.Second)
+		})
+
+		Convey("returns an error on an unknown gossip transport", func() {
+			cfg.Sidecar.GossipTransport = "carrier-pigeon"
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a bad HAproxy template", func() {
+			cfg.HAproxy.Disable = false
+			cfg.HAproxy.TemplateFile = "/does/not/exist.cfg"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a bad validation policy", func() {
+			cfg.Sidecar.ValidationPolicy = "ignore"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("puts the cluster reporter in front of the services delegate", func() {
+			sidecar, err := New(cfg)
+			So(err, ShouldBeNil)
+			So(sidecar.mlConfig.Events, ShouldEqual, sidecar.Reporter)
+			So(sidecar.Reporter.Next, ShouldNotBeNil)
+		})
+
+		Convey("only sets up notifications when there's somewhere to send them", func() {
+			sidecar, err := New(cfg)
+			So(err, ShouldBeNil)
+			So(sidecar.Notifier, ShouldBeNil)
+
+			cfg.Notify.SlackURLs = []string{"https://hooks.slack.com/services/T0/B0/x"}
+			sidecar, err = New(cfg)
+			So(err, ShouldBeNil)
+			So(sidecar.Notifier, ShouldNotBeNil)
+			So(sidecar.mlConfig.Events, ShouldEqual, sidecar.Notifier)
+			So(sidecar.Notifier.Next, ShouldEqual, sidecar.Reporter)
+			So(sidecar.State.OnServerExpired, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a bad cluster report verbosity", func() {
+			cfg.Sidecar.ClusterReportVerbosity = "chatty"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a bad Envoy bind address", func() {
+			cfg.Envoy.BindAddrs = []string{"local=localhost"}
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a missing Envoy gRPC token file", func() {
+			cfg.Envoy.UseGRPCAPI = true
+			cfg.Envoy.GRPCTokenFile = "/does/not/exist"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a missing check defaults file", func() {
+			cfg.Sidecar.CheckDefaultsFile = "/nonexistent/check-defaults.json"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a missing template outputs file", func() {
+			cfg.Sidecar.TemplateOutputsFile = "/nonexistent/outputs.json"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("uses the configured hostname everywhere", func() {
+			cfg.Sidecar.Hostname = "Shakespeare.example.com"
+			cfg.Sidecar.HostnameNormalization = "lowercase,short"
+
+			sidecar, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(sidecar.State.Hostname, ShouldEqual, "shakespeare")
+			So(sidecar.mlConfig.Name, ShouldEqual, "shakespeare")
+		})
+
+		Convey("returns an error on a bad hostname normalization", func() {
+			cfg.Sidecar.HostnameNormalization = "shouty"
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error on a discovery precedence for an unused method", func() {
+			cfg.Sidecar.Discovery = []string{"docker", "static"}
+			cfg.Sidecar.DiscoveryPrecedence = []string{"static", "kubernetes_api"}
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "kubernetes_api")
+		})
+
+		Convey("returns an error when the node labels don't fit in the metadata", func() {
+			cfg.Sidecar.NodeLabels = map[string]string{"notes": strings.Repeat("x", 600)}
+
+			_, err := New(cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "node labels")
+		})
+
+		Convey("sets up a standalone node in dev mode", func() {
+			cfg.Sidecar.Dev = true
+			cfg.Sidecar.DevTimeScale = 10
+			cfg.Sidecar.AdvertiseIP = ""
+			cfg.Sidecar.Seeds = []string{"10.0.0.2"}
+			cfg.Sidecar.PushPullInterval = 20 * time.Second
+			cfg.HAproxy.Disable = false
+
+			sidecar, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(sidecar.AdvertiseAddr(), ShouldEqual, "127.0.0.1")
+			So(sidecar.HAproxy, ShouldBeNil)
+			So(sidecar.State.TimeScale, ShouldEqual, 10)
+			So(cfg.Sidecar.Seeds, ShouldBeEmpty)
+			So(cfg.Sidecar.Discovery, ShouldResemble, []string{"dev"})
+			So(sidecar.mlConfig.PushPullInterval, ShouldEqual, 2*time.Second)
+		})
+
+		Convey("refuses to run twice", func() {
+			sidecar, _ := New(cfg)
+			sidecar.running = true
+
+			err := sidecar.Run(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/sidecarhttp"
+)
+
+// These are set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/NinesStack/sidecar/agent.Version=v1.9.0 \
+//	    -X github.com/NinesStack/sidecar/agent.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version string
+	Commit  string
+)
+
+// buildInfo describes this binary. The version injected at build time wins,
+// otherwise we use what the Go toolchain recorded.
+func buildInfo() sidecarhttp.BuildInfo {
+	info := sidecarhttp.BuildInfo{
+		Version:   "unknown",
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+
+	if Version != "" {
+		info.Version = Version
+	} else if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+
+	return info
+}
+
+// configFingerprint returns a short hash of the config, so we can tell at a
+// glance whether nodes are configured the same way. Settings that are
+// expected to differ from node to node are left out. The NodeLabels stay in,
+// even though they may differ, because they change the tags on every service
+// the node announces.
+func configFingerprint(cfg *config.Config) string {
+	shared := *cfg
+	shared.Sidecar.Hostname = ""
+	shared.Sidecar.AdvertiseIP = ""
+
+	jsonBytes, err := json.Marshal(&shared)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(sum[:])[:12]
+}
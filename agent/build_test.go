@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/NinesStack/sidecar/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_BuildInfo(t *testing.T) {
+	Convey("buildInfo()", t, func() {
+		info := buildInfo()
+
+		So(info.Version, ShouldNotBeEmpty)
+		So(info.GoVersion, ShouldEqual, runtime.Version())
+
+		Convey("prefers the version set at build time", func() {
+			Version, Commit = "v1.9.0", "abc1234"
+			defer func() { Version, Commit = "", "" }()
+
+			info := buildInfo()
+			So(info.Version, ShouldEqual, "v1.9.0")
+			So(info.Commit, ShouldEqual, "abc1234")
+		})
+	})
+
+	Convey("configFingerprint()", t, func() {
+		cfg := &config.Config{}
+		cfg.Sidecar.ClusterName = "default"
+		cfg.Sidecar.Hostname = "chaucer"
+
+		fingerprint := configFingerprint(cfg)
+		So(fingerprint, ShouldHaveLength, 12)
+
+		Convey("ignores settings that differ by node", func() {
+			other := *cfg
+			other.Sidecar.Hostname = "petrarch"
+			other.Sidecar.AdvertiseIP = "10.0.0.2"
+
+			So(configFingerprint(&other), ShouldEqual, fingerprint)
+		})
+
+		Convey("changes with the shared settings", func() {
+			other := *cfg
+			other.Sidecar.ClusterName = "staging"
+
+			So(configFingerprint(&other), ShouldNotEqual, fingerprint)
+		})
+
+		Convey("changes with the node labels", func() {
+			other := *cfg
+			other.Sidecar.NodeLabels = map[string]string{"rack": "r12"}
+			labeled := configFingerprint(&other)
+			So(labeled, ShouldNotEqual, fingerprint)
+
+			other.Sidecar.NodeLabels = map[string]string{"rack": "r13"}
+			So(configFingerprint(&other), ShouldNotEqual, labeled)
+		})
+
+		Convey("doesn't modify the config", func() {
+			So(cfg.Sidecar.Hostname, ShouldEqual, "chaucer")
+		})
+	})
+}
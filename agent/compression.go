@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/snappy"
+)
+
+// A BroadcastCodec compresses the service broadcasts we gossip. Each
+// compressed message starts with the codec's ID, so that receivers can tell
+// which codec to decode it with. Uncompressed messages are JSON, and always
+// start with '{', so IDs must not.
+type BroadcastCodec interface {
+	Name() string // What nodes advertise in their metadata, and operators configure
+	ID() byte
+	Encode(message []byte) []byte
+	Decode(message []byte) ([]byte, error)
+}
+
+// broadcastCodecs are the codecs we can decode, by name
+var broadcastCodecs = map[string]BroadcastCodec{}
+
+// RegisterBroadcastCodec adds a codec that broadcasts can be compressed
+// with. Every node in the cluster must register it before it is used.
+func RegisterBroadcastCodec(codec BroadcastCodec) {
+	broadcastCodecs[codec.Name()] = codec
+}
+
+// BroadcastCodecNames returns the names of the registered codecs, sorted
+func BroadcastCodecNames() []string {
+	names := make([]string, 0, len(broadcastCodecs))
+	for name := range broadcastCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeBroadcast undoes the compression of a message, if it's compressed
+func decodeBroadcast(message []byte) ([]byte, error) {
+	if len(message) < 1 || message[0] == '{' {
+		return message, nil
+	}
+
+	for _, codec := range broadcastCodecs {
+		if codec.ID() == message[0] {
+			return codec.Decode(message[1:])
+		}
+	}
+
+	return nil, fmt.Errorf("unknown broadcast compression %#x", message[0])
+}
+
+// encodeBroadcast compresses the message with the codec. It returns the
+// message as it is when that wouldn't make it any smaller.
+func encodeBroadcast(codec BroadcastCodec, message []byte) []byte {
+	encoded := codec.Encode(message)
+	if len(encoded)+1 >= len(message) {
+		return message
+	}
+
+	return append([]byte{codec.ID()}, encoded...)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+func (snappyCodec) ID() byte     { return 0x01 }
+
+func (snappyCodec) Encode(message []byte) []byte {
+	return snappy.Encode(nil, message)
+}
+
+func (snappyCodec) Decode(message []byte) ([]byte, error) {
+	return snappy.Decode(nil, message)
+}
+
+func init() {
+	RegisterBroadcastCodec(snappyCodec{})
+}
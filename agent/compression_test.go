@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_BroadcastCompression(t *testing.T) {
+	Convey("Compressing broadcasts", t, func() {
+		message := []byte(`{"ID":"d419fa7ad1a7","Name":"/dockercon-6adfe629eebc91","Image":"nginx:latest","Created":"2015-02-25T19:04:46Z","Hostname":"docker2","Ports":[{"Type":"tcp","Port":10234},{"Type":"tcp","Port":10235}],"Updated":"2015-03-04T01:12:46.669648453Z","Status":0}`)
+		codec := broadcastCodecs["snappy"]
+
+		Convey("snappy is registered", func() {
+			So(codec, ShouldNotBeNil)
+			So(BroadcastCodecNames(), ShouldContain, "snappy")
+		})
+
+		Convey("round trips a message", func() {
+			encoded := encodeBroadcast(codec, message)
+			So(encoded[0], ShouldEqual, codec.ID())
+			So(len(encoded), ShouldBeLessThan, len(message))
+
+			decoded, err := decodeBroadcast(encoded)
+			So(err, ShouldBeNil)
+			So(string(decoded), ShouldEqual, string(message))
+		})
+
+		Convey("leaves messages alone when they don't get smaller", func() {
+			short := []byte(`{"ID":"a"}`)
+			So(string(encodeBroadcast(codec, short)), ShouldEqual, string(short))
+		})
+
+		Convey("passes uncompressed messages through", func() {
+			decoded, err := decodeBroadcast(message)
+			So(err, ShouldBeNil)
+			So(string(decoded), ShouldEqual, string(message))
+		})
+
+		Convey("errors on an unknown codec", func() {
+			_, err := decodeBroadcast([]byte{0x7f, 'a', 'b'})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "0x7f")
+		})
+
+		Convey("in the delegate", func() {
+			state := catalog.NewServicesState()
+			delegate := NewServicesDelegate(state)
+			delegate.Compression = codec
+
+			addPeer := func(name string, meta NodeMetadata) {
+				encoded, _ := json.Marshal(meta)
+				delegate.peers.update(&memberlist.Node{Name: name, Meta: encoded}, delegate.Metadata)
+			}
+
+			Convey("compresses when every peer can decode it", func() {
+				addPeer("beowulf", NodeMetadata{Compression: []string{"snappy"}})
+				delegate.pendingBroadcasts = [][]byte{message}
+
+				result := delegate.GetBroadcasts(3, 1398)
+				So(len(result), ShouldEqual, 1)
+				So(result[0][0], ShouldEqual, codec.ID())
+				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
+
+				decoded, err := decodeBroadcast(result[0])
+				So(err, ShouldBeNil)
+				So(string(decoded), ShouldEqual, string(message))
+			})
+
+			Convey("doesn't compress when a peer can't decode it", func() {
+				addPeer("beowulf", NodeMetadata{Compression: []string{"snappy"}})
+				addPeer("grendel", NodeMetadata{})
+				delegate.pendingBroadcasts = [][]byte{message}
+
+				result := delegate.GetBroadcasts(3, 1398)
+				So(len(result), ShouldEqual, 1)
+				So(string(result[0]), ShouldEqual, string(message))
+			})
+
+			Convey("doesn't compress without a codec", func() {
+				delegate.Compression = nil
+				addPeer("beowulf", NodeMetadata{Compression: []string{"snappy"}})
+				delegate.pendingBroadcasts = [][]byte{message}
+
+				result := delegate.GetBroadcasts(3, 1398)
+				So(string(result[0]), ShouldEqual, string(message))
+			})
+
+			Convey("keeps leftovers uncompressed", func() {
+				addPeer("beowulf", NodeMetadata{Compression: []string{"snappy"}})
+				// Random bytes don't compress, so this won't fit
+				big := make([]byte, 2000)
+				rand.New(rand.NewSource(1)).Read(big)
+				big[0] = '{'
+				delegate.pendingBroadcasts = [][]byte{message, big}
+
+				result := delegate.GetBroadcasts(3, 1398)
+				So(len(result), ShouldEqual, 1)
+				So(len(delegate.pendingBroadcasts), ShouldEqual, 1)
+				So(string(delegate.pendingBroadcasts[0]), ShouldEqual, string(big))
+			})
+		})
+	})
+}
@@ -0,0 +1,523 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/healthy"
+	"github.com/NinesStack/sidecar/notify"
+	"github.com/NinesStack/sidecar/objectstore"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+// configureReporter sets up the cluster reporter. Debug mode always gets
+// the full report.
+func configureReporter(config *config.Config) (*cluster.Reporter, error) {
+	verbosity := config.Sidecar.ClusterReportVerbosity
+	if config.Sidecar.Debug {
+		verbosity = cluster.VerbosityFull
+	}
+
+	return cluster.NewReporter(verbosity)
+}
+
+// configureNotifier sets up the sinks for notifications. Returns nil when
+// none are configured.
+func configureNotifier(config *config.Config, state *catalog.ServicesState) *notify.Notifier {
+	var sinks []notify.Sink
+	for _, url := range config.Notify.WebhookURLs {
+		sinks = append(sinks, notify.NewWebhookSink(url))
+	}
+	for _, url := range config.Notify.SlackURLs {
+		sinks = append(sinks, notify.NewSlackSink(url))
+	}
+	if config.Notify.PagerDutyKey != "" {
+		sinks = append(sinks, notify.NewPagerDutySink(config.Notify.PagerDutyKey))
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	notifier := notify.NewNotifier(state, sinks...)
+	notifier.ServiceDownDelay = config.Notify.ServiceDownDelay
+	notifier.HAproxyFailures = config.Notify.HAproxyFailures
+	notifier.Timeout = config.Notify.Timeout
+
+	return notifier
+}
+
+// configureUploader sets up uploading snapshots of the state to an object
+// store. Returns nil when there's nowhere to upload to.
+func configureUploader(config *config.Config) (*objectstore.Uploader, error) {
+	cfg := config.StateUpload
+	if cfg.URL == "" {
+		return nil, nil
+	}
+
+	store, key, err := objectstore.ParseURL(cfg.URL, cfg.Endpoint, cfg.Region, objectstore.EnvCredentials())
+	if err != nil {
+		return nil, err
+	}
+
+	uploader := objectstore.NewUploader(store, key)
+	uploader.MinInterval = cfg.MinInterval
+	uploader.RefreshInterval = cfg.RefreshInterval
+	uploader.Timeout = cfg.Timeout
+
+	return uploader, nil
+}
+
+// memberNames returns the names of the cluster members, for electing a
+// leader
+func memberNames(list *memberlist.Memberlist) func() []string {
+	return func() []string {
+		members := list.Members()
+		names := make([]string, 0, len(members))
+		for _, member := range members {
+			names = append(names, member.Name)
+		}
+		return names
+	}
+}
+
+func configureHAproxy(config *config.Config) (*haproxy.HAproxy, error) {
+	proxy := haproxy.New(config.HAproxy.ConfigFile, config.HAproxy.PidFile)
+
+	if len(config.HAproxy.BindIP) > 0 {
+		proxy.BindIP = config.HAproxy.BindIP
+	}
+
+	if len(config.HAproxy.ReloadCmd) > 0 {
+		proxy.ReloadCmd = config.HAproxy.ReloadCmd
+	}
+
+	if len(config.HAproxy.VerifyCmd) > 0 {
+		proxy.VerifyCmd = config.HAproxy.VerifyCmd
+	}
+
+	if len(config.HAproxy.TemplateFile) > 0 {
+		proxy.Template = config.HAproxy.TemplateFile
+	}
+
+	if len(config.HAproxy.TemplateDir) > 0 {
+		proxy.TemplateDir = config.HAproxy.TemplateDir
+	}
+
+	if len(config.HAproxy.User) > 0 {
+		proxy.User = config.HAproxy.User
+	}
+
+	if len(config.HAproxy.Group) > 0 {
+		proxy.Group = config.HAproxy.Group
+	}
+
+	proxy.UseHostnames = config.HAproxy.UseHostnames
+
+	err := proxy.ValidateTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("unable to use HAproxy template: %w", err)
+	}
+
+	return proxy, nil
+}
+
+// configureHostname works out the name this node is known by. An explicit
+// setting wins, then the OS hostname, then the HOSTNAME environment variable.
+// The result is normalized if we've been asked to do that.
+func configureHostname(config *config.Config) (string, error) {
+	hostname := config.Sidecar.Hostname
+
+	if hostname == "" {
+		var err error
+		hostname, err = os.Hostname()
+		if err != nil {
+			log.Warnf("Unable to get the hostname from the OS: %s", err)
+		}
+	}
+
+	if hostname == "" {
+		hostname = os.Getenv("HOSTNAME")
+	}
+
+	normalize, err := discovery.ParseHostnameNormalizer(config.Sidecar.HostnameNormalization)
+	if err != nil {
+		return "", err
+	}
+	if normalize != nil {
+		hostname = normalize(hostname)
+	}
+
+	if hostname == "" {
+		return "", errors.New("unable to work out our hostname, please set SIDECAR_HOSTNAME")
+	}
+
+	return hostname, nil
+}
+
+// configureDevMode turns this into a standalone node for trying Sidecar out.
+// It doesn't join anyone, discovers only the made up example services, and
+// doesn't touch HAproxy. The catalog's clock is sped up separately.
+func configureDevMode(config *config.Config) {
+	log.Warn("Running in dev mode, services are not real!")
+
+	config.Sidecar.Seeds = nil
+	config.Sidecar.Discovery = []string{"dev"}
+	config.HAproxy.Disable = true
+
+	if config.Sidecar.AdvertiseIP == "" {
+		config.Sidecar.AdvertiseIP = "127.0.0.1"
+	}
+
+	if config.Sidecar.DevTimeScale > 1 {
+		config.Sidecar.PushPullInterval = time.Duration(
+			float64(config.Sidecar.PushPullInterval) / config.Sidecar.DevTimeScale,
+		)
+	}
+}
+
+// configureWeights returns a WeightController when load weighting is enabled,
+// otherwise nil.
+func configureWeights(config *config.Config) (*catalog.WeightController, error) {
+	if config.Sidecar.LoadWeighting == "" {
+		return nil, nil
+	}
+
+	metric, err := catalog.ParseLoadMetric(config.Sidecar.LoadWeighting)
+	if err != nil {
+		return nil, err
+	}
+
+	min, max := config.Sidecar.LoadWeightingMin, config.Sidecar.LoadWeightingMax
+	if min < 1 || max < min || max > 256 {
+		return nil, fmt.Errorf("invalid load weighting bounds %d-%d, must be within 1-256", min, max)
+	}
+
+	damping := config.Sidecar.LoadWeightingDamping
+	if damping <= 0 || damping > 1 {
+		return nil, fmt.Errorf("invalid load weighting damping %g, must be greater than 0 and at most 1", damping)
+	}
+
+	weights := catalog.NewWeightController(metric)
+	weights.MinWeight = min
+	weights.MaxWeight = max
+	weights.Damping = damping
+
+	return weights, nil
+}
+
+// checkDiscoveryPrecedence makes sure the precedence only names discovery
+// methods that are in use. It ranks services by their Source, which is named
+// after the method that found them.
+func checkDiscoveryPrecedence(config *config.Config) error {
+	for _, source := range config.Sidecar.DiscoveryPrecedence {
+		var configured bool
+		for _, method := range config.Sidecar.Discovery {
+			configured = configured || method == source
+		}
+		if !configured {
+			return fmt.Errorf("unable to configure discovery precedence! Not a configured discovery method: %q", source)
+		}
+	}
+	return nil
+}
+
+// checkDiscoveryPlatform makes sure the configured discovery methods work on
+// this platform, so we fail before joining the cluster
+func checkDiscoveryPlatform(config *config.Config) error {
+	for _, method := range config.Sidecar.Discovery {
+		if !discovery.IsSupported(method) {
+			return fmt.Errorf("unable to configure discovery! %q isn't supported on %s", method, runtime.GOOS)
+		}
+	}
+	return nil
+}
+
+// configurePortAllocator returns the allocator for the ServicePort pool, or
+// nil when there isn't one
+func configurePortAllocator(config *config.Config) (*discovery.PortAllocator, error) {
+	pool, err := discovery.ParsePortRanges(config.Sidecar.ServicePortPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ServicePort pool: %w", err)
+	}
+
+	if len(pool) == 0 {
+		return nil, nil
+	}
+
+	allowed, err := discovery.ParsePortRanges(config.Sidecar.ServicePortRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	// Allocated ports would just be rejected
+	for _, portRange := range pool {
+		if !allowed.Allows(portRange.Min) || !allowed.Allows(portRange.Max) {
+			return nil, fmt.Errorf("ServicePort pool %s is outside the allowed ranges %s", pool, allowed)
+		}
+	}
+
+	return discovery.NewPortAllocator(pool, config.Sidecar.ServicePortFile)
+}
+
+func configureDiscovery(config *config.Config, publishedIP string, localNode *memberlist.Node, allocator *discovery.PortAllocator) (discovery.Discoverer, error) {
+	disco := new(discovery.MultiDiscovery)
+
+	var err error
+
+	disco.NormalizeHostname, err = discovery.ParseHostnameNormalizer(config.Sidecar.HostnameNormalization)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Sidecar.Discovery) < 1 {
+		log.Warn("No discovery method configured! Sidecar running in passive mode")
+	}
+	disco.Precedence = config.Sidecar.DiscoveryPrecedence
+	disco.Timeout = config.Sidecar.DiscoveryTimeout
+
+	disco.AllowedServicePorts, err = discovery.ParsePortRanges(config.Sidecar.ServicePortRanges)
+	if err != nil {
+		return nil, err
+	}
+	disco.PortAllocator = allocator
+
+	// Only used by discoverers whose services don't have their own stable ID
+	var idStrategy discovery.IDStrategy
+	switch config.Services.IDStrategy {
+	case "hash":
+		idStrategy = &discovery.HashIDStrategy{}
+	case "native", "":
+	default:
+		return nil, fmt.Errorf("unable to configure ID strategy! Not a valid entry: %q", config.Services.IDStrategy)
+	}
+
+	for _, method := range config.Sidecar.Discovery {
+		switch method {
+		case "docker", "compose":
+			containerDisco, err := configureContainerDiscovery(method, config, publishedIP, localNode.Name, idStrategy)
+			if err != nil {
+				return nil, err
+			}
+			disco.Add(method, containerDisco)
+		case "static":
+			staticDisco := discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP)
+			staticDisco.Hostname = localNode.Name
+			staticDisco.DefaultTTL = config.StaticDiscovery.DefaultTTL
+			if idStrategy != nil {
+				staticDisco.IDStrategy = idStrategy
+			}
+			disco.Add(method, staticDisco)
+		case "dev":
+			devDisco := discovery.NewDevDiscovery(publishedIP)
+			devDisco.Hostname = localNode.Name
+			devDisco.ChurnInterval = config.Sidecar.DevChurnInterval
+			disco.Add(method, devDisco)
+		case "kubernetes_api":
+			k8sDisco := discovery.NewK8sAPIDiscoverer(
+				config.K8sAPIDiscovery.KubeAPIIP, config.K8sAPIDiscovery.KubeAPIPort,
+				config.K8sAPIDiscovery.Namespace, config.K8sAPIDiscovery.KubeTimeout,
+				config.K8sAPIDiscovery.CredsPath, config.K8sAPIDiscovery.AnnounceAllNodes,
+				localNode.Name,
+			)
+			k8sDisco.IDStrategy = idStrategy
+			disco.Add(method, k8sDisco)
+		default:
+		}
+	}
+
+	return disco, nil
+}
+
+// configureDelegate sets up the Memberlist delegate we'll use
+func configureDelegate(state *catalog.ServicesState, config *config.Config) *servicesDelegate {
+	delegate := NewServicesDelegate(state)
+	delegate.Metadata = NodeMetadata{
+		ClusterName:        config.Sidecar.ClusterName,
+		State:              "Running",
+		Version:            buildInfo().Version,
+		ProtocolVersion:    PROTOCOL_VERSION,
+		MinProtocolVersion: MIN_PROTOCOL_VERSION,
+		Labels:             config.Sidecar.NodeLabels,
+		Compression:        BroadcastCodecNames(),
+	}
+
+	delegate.Start()
+
+	return delegate
+}
+
+func configureMemberlist(config *config.Config, state *catalog.ServicesState) (*memberlist.Config, error) {
+	delegate := configureDelegate(state, config)
+
+	if config.Sidecar.GossipCompression != "" {
+		codec, ok := broadcastCodecs[config.Sidecar.GossipCompression]
+		if !ok {
+			return nil, fmt.Errorf("unknown gossip compression %q, expected one of %v",
+				config.Sidecar.GossipCompression, BroadcastCodecNames(),
+			)
+		}
+		delegate.Compression = codec
+	}
+
+	// Memberlist panics on metadata that's too big, and labels could be
+	meta := delegate.NodeMeta(memberlist.MetaMaxSize)
+	if len(meta) > memberlist.MetaMaxSize {
+		return nil, fmt.Errorf(
+			"node metadata is %d bytes, more than the %d allowed. Use fewer or shorter node labels",
+			len(meta), memberlist.MetaMaxSize,
+		)
+	}
+
+	// Use a LAN config but add our delegate
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Delegate = delegate
+	mlConfig.Events = delegate
+
+	// Set some memberlist settings
+	mlConfig.LogOutput = &LoggingBridge{} // Use logrus as backend for Memberlist
+	mlConfig.PreferTCPDNS = false
+
+	// Set up the push pull interval for Memberlist
+	if config.Sidecar.PushPullInterval == 0 {
+		mlConfig.PushPullInterval = catalog.ALIVE_LIFESPAN - 1*time.Second
+	} else {
+		mlConfig.PushPullInterval = config.Sidecar.PushPullInterval
+	}
+	if config.Sidecar.GossipMessages != 0 {
+		mlConfig.GossipMessages = config.Sidecar.GossipMessages
+	}
+	mlConfig.GossipInterval = config.Sidecar.GossipInterval
+	mlConfig.HandoffQueueDepth = config.Sidecar.HandoffQueueDepth
+
+	// Make sure we pass on the cluster name to Memberlist
+	mlConfig.ClusterName = config.Sidecar.ClusterName
+
+	// Figure out our IP address from the CLI or by inspecting the network interfaces
+	publishedIP, err := getPublishedIP(config.Sidecar.ExcludeIPs, config.Sidecar.AdvertiseIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find private IP address: %w", err)
+	}
+	mlConfig.BindPort = config.Sidecar.BindPort
+	mlConfig.AdvertiseAddr = publishedIP
+	mlConfig.AdvertisePort = config.Sidecar.BindPort
+
+	switch config.Sidecar.GossipTransport {
+	case "", "udp":
+		// Memberlist's own transport
+	case "tcp":
+		configureTCPGossip(mlConfig)
+	default:
+		return nil, fmt.Errorf("unknown gossip transport %q", config.Sidecar.GossipTransport)
+	}
+
+	return mlConfig, nil
+}
+
+// configureGossipTuner sets up the scaling of the gossip settings with the
+// size of the cluster. It's only run when GossipAutoScale is on, but always
+// reports the settings in effect.
+func configureGossipTuner(config *config.Config, mlConfig *memberlist.Config,
+	state *catalog.ServicesState) (*cluster.GossipTuner, error) {
+
+	tuner := cluster.NewGossipTuner(mlConfig, state)
+	tuner.MinScale = config.Sidecar.GossipMinScale
+	tuner.MaxScale = config.Sidecar.GossipMaxScale
+
+	if config.Sidecar.GossipAutoScale {
+		err := tuner.ValidateBounds()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tuner, nil
+}
+
+// configureTCPGossip relaxes the failure detection timings for the TCP-only
+// transport, where every probe has to set up a connection. These match the
+// Memberlist WAN defaults.
+func configureTCPGossip(mlConfig *memberlist.Config) {
+	wan := memberlist.DefaultWANConfig()
+	mlConfig.ProbeInterval = wan.ProbeInterval
+	mlConfig.ProbeTimeout = wan.ProbeTimeout
+	mlConfig.SuspicionMult = wan.SuspicionMult
+	mlConfig.TCPTimeout = wan.TCPTimeout
+
+	log.Warnf("Gossiping over TCP only. Failed nodes will take longer to detect, "+
+		"probing every %s with a %s timeout", mlConfig.ProbeInterval, mlConfig.ProbeTimeout,
+	)
+}
+
+// configureRemoteChecks sets up a second Monitor that health checks the
+// services hosted on other nodes and feeds the results back into the state.
+// Only used when this node has been configured as a checker node.
+func configureRemoteChecks(ctx context.Context, config *config.Config,
+	state *catalog.ServicesState, background func(func())) {
+
+	log.Info("Running as a checker node, remote services will be health checked")
+
+	remoteMonitor := healthy.NewMonitor("", config.Sidecar.DefaultCheckEndpoint)
+	configureTcpChecks(remoteMonitor, config)
+
+	remoteWatchLooper := director.NewTimedLooper(
+		director.FOREVER, healthy.WATCH_INTERVAL, nil,
+	)
+	remoteHealthLooper := director.NewTimedLooper(
+		director.FOREVER, healthy.HEALTH_INTERVAL, nil,
+	)
+	remoteTrackingLooper := director.NewTimedLooper(
+		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
+	)
+
+	background(func() { remoteMonitor.WatchRemote(ctx, state.RemoteServices, remoteWatchLooper) })
+	background(func() { remoteMonitor.Run(ctx, remoteHealthLooper) })
+	background(func() { state.TrackRemoteChecks(ctx, remoteMonitor.Services, remoteTrackingLooper) })
+}
+
+// configureTcpChecks applies the settings for TcpConnect checks to a Monitor
+func configureTcpChecks(monitor *healthy.Monitor, config *config.Config) {
+	monitor.TcpCheckTimeout = config.Sidecar.TcpCheckTimeout
+	monitor.HalfOpenWait = config.Sidecar.HalfOpenWait
+	monitor.HalfOpenThreshold = config.Sidecar.HalfOpenThreshold
+}
+
+// configureListeners sets up any statically configured state change event listeners.
+func configureListeners(config *config.Config, state *catalog.ServicesState, prepare func(*catalog.UrlListener)) {
+	for _, url := range config.Listeners.Urls {
+		listener := catalog.NewUrlListener(url, false)
+		prepare(listener)
+		listener.Watch(state)
+	}
+}
+
+// configureLeaderListeners sets up the statically configured listeners that
+// only the cluster leader posts to. They're stopped when the context is done.
+func configureLeaderListeners(ctx context.Context, config *config.Config, state *catalog.ServicesState,
+	prepare func(*catalog.UrlListener), background func(func())) {
+
+	for _, url := range config.Listeners.LeaderUrls {
+		listener := catalog.NewUrlListener(url, false)
+		prepare(listener)
+		listener.Start(state)
+
+		leader := catalog.NewLeaderListener(listener)
+		leader.Watch(state)
+		background(func() {
+			<-ctx.Done()
+			leader.Stop()
+			listener.Stop()
+		})
+	}
+}
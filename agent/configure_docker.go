@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import (
+	"fmt"
+
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/discovery"
+)
+
+// configureContainerDiscovery sets up the "docker" or "compose" discoverer,
+// which both name their services with the configured ServiceNamer
+func configureContainerDiscovery(method string, config *config.Config, publishedIP string,
+	hostname string, idStrategy discovery.IDStrategy) (discovery.Discoverer, error) {
+
+	svcNamer, err := configureServiceNamer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "compose" {
+		composeDisco := discovery.NewComposeDiscovery(config.ComposeDiscovery.File, svcNamer, publishedIP)
+		composeDisco.ProjectName = config.ComposeDiscovery.ProjectName
+		composeDisco.Hostname = hostname
+		composeDisco.IDStrategy = idStrategy
+		return composeDisco, nil
+	}
+
+	switch config.DockerDiscovery.Client {
+	case "", discovery.DockerClientSDK, discovery.DockerClientLegacy:
+	default:
+		return nil, fmt.Errorf("unable to configure Docker discovery! Not a valid client: %q", config.DockerDiscovery.Client)
+	}
+
+	dockerDisco := discovery.NewDockerDiscovery(config.DockerDiscovery.DockerURL, svcNamer, publishedIP)
+	dockerDisco.StatsInterval = config.DockerDiscovery.StatsInterval
+	dockerDisco.Networks = config.DockerDiscovery.Networks
+	dockerDisco.Client = config.DockerDiscovery.Client
+	dockerDisco.APIVersion = config.DockerDiscovery.ApiVersion
+	dockerDisco.Hostname = hostname
+	dockerDisco.SetCacheLimits(config.DockerDiscovery.CacheSize, config.DockerDiscovery.CacheTTL)
+	return dockerDisco, nil
+}
+
+func configureServiceNamer(config *config.Config) (discovery.ServiceNamer, error) {
+	switch config.Services.ServiceNamer {
+	case "docker_label":
+		return &discovery.DockerLabelNamer{
+			Label: config.Services.NameLabel,
+		}, nil
+	case "regex":
+		svcNamer, err := discovery.NewRegexpNamer(config.Services.NameMatch)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use RegexpNamer: %w", err)
+		}
+		return svcNamer, nil
+	default:
+		return nil, fmt.Errorf("unable to configure service namer! Not a valid entry: %q", config.Services.ServiceNamer)
+	}
+}
@@ -0,0 +1,16 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/discovery"
+)
+
+// configureContainerDiscovery fails, because the Docker client doesn't build
+// on Windows. checkDiscoveryPlatform should have caught this already.
+func configureContainerDiscovery(method string, config *config.Config, publishedIP string,
+	hostname string, idStrategy discovery.IDStrategy) (discovery.Discoverer, error) {
+
+	return nil, fmt.Errorf("unable to configure discovery! %q isn't supported on windows", method)
+}
@@ -1,11 +1,15 @@
-package main
+package agent
 
 import (
 	"bytes"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/NinesStack/sidecar/logging"
 )
 
+// Memberlist and our gossip delegate log here, so that their level can be
+// turned up on its own
+var gossipLog = logging.Module("gossip")
+
 // This is a bridge to take the output of Memberlist, which uses a standard
 // Go logger and reformat them into properly leveled logrus lines. If
 // only the stdlib log.Logger were an interface and not a type...
@@ -40,14 +44,14 @@ func (l *LoggingBridge) logMessageAtLevel(message []byte, level []byte) {
 	}
 	switch string(level) {
 	case "[INFO]":
-		log.Info(string(message))
+		gossipLog.Info(string(message))
 	case "[WARN]":
-		log.Warn(string(message))
+		gossipLog.Warn(string(message))
 	case "[ERR]":
-		log.Error(string(message))
+		gossipLog.Error(string(message))
 	case "[DEBUG]":
-		log.Debug(string(message))
+		gossipLog.Debug(string(message))
 	default:
-		log.Infof("%s %s", string(level), string(message))
+		gossipLog.Infof("%s %s", string(level), string(message))
 	}
 }
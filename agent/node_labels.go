@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"github.com/NinesStack/sidecar/service"
+)
+
+// labelServices adds the node labels to the tags of each of our services, so
+// that policies downstream can pick services by e.g. rack without knowing
+// where each host is. A service's own tags win over the labels. The Tags maps
+// are replaced rather than changed, because discovery may still hold them.
+func labelServices(services []service.Service, labels map[string]string) []service.Service {
+	if len(labels) == 0 {
+		return services
+	}
+
+	for i, svc := range services {
+		tags := make(map[string]string, len(svc.Tags)+len(labels))
+		for key, value := range labels {
+			tags[key] = value
+		}
+		for key, value := range svc.Tags {
+			tags[key] = value
+		}
+		services[i].Tags = tags
+	}
+
+	return services
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_labelServices(t *testing.T) {
+	Convey("labelServices()", t, func() {
+		labels := map[string]string{"rack": "r12", "gen": "g5"}
+		ownTags := map[string]string{"gen": "g4", "team": "search"}
+
+		services := []service.Service{
+			{ID: "deadbeef0001", Tags: ownTags},
+			{ID: "deadbeef0002"},
+		}
+
+		Convey("adds the labels to the services' tags", func() {
+			labeled := labelServices(services, labels)
+
+			So(labeled[1].Tags, ShouldResemble, labels)
+		})
+
+		Convey("lets the services' own tags win", func() {
+			labeled := labelServices(services, labels)
+
+			So(labeled[0].Tags, ShouldResemble, map[string]string{
+				"rack": "r12", "gen": "g4", "team": "search",
+			})
+		})
+
+		Convey("doesn't change the original tags", func() {
+			labelServices(services, labels)
+
+			So(ownTags, ShouldResemble, map[string]string{"gen": "g4", "team": "search"})
+		})
+
+		Convey("leaves the services alone without labels", func() {
+			labeled := labelServices(services, nil)
+
+			So(labeled[1].Tags, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/NinesStack/memberlist"
+	metrics "github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PROTOCOL_VERSION is bumped whenever what we gossip changes in a way
+	// that older peers can't understand.
+	PROTOCOL_VERSION = 1
+	// MIN_PROTOCOL_VERSION is the oldest peer protocol we can still work with
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// peerVersions keeps track of what the other members of the cluster are
+// running, so that we can complain about the ones we can't work with.
+type peerVersions struct {
+	peers map[string]NodeMetadata
+	sync.Mutex
+}
+
+// compatible tells whether we can work with a peer. Peers from before we
+// advertised a protocol version speak protocol 1.
+func compatible(meta NodeMetadata) bool {
+	protocol := meta.ProtocolVersion
+	minProtocol := meta.MinProtocolVersion
+	if protocol == 0 {
+		protocol, minProtocol = 1, 1
+	}
+
+	return protocol >= MIN_PROTOCOL_VERSION && minProtocol <= PROTOCOL_VERSION
+}
+
+// update records the metadata for a peer, and logs when it has changed to
+// something notable. Returns whether we can work with the peer.
+func (p *peerVersions) update(node *memberlist.Node, ours NodeMetadata) bool {
+	var meta NodeMetadata
+	if len(node.Meta) > 0 {
+		err := json.Unmarshal(node.Meta, &meta)
+		if err != nil {
+			log.Warnf("Unable to decode metadata from %s: %s", node.Name, err)
+			return true
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.peers == nil {
+		p.peers = make(map[string]NodeMetadata)
+	}
+
+	previous, seen := p.peers[node.Name]
+	p.peers[node.Name] = meta
+	p.setGauge()
+
+	ok := compatible(meta)
+
+	if seen && previous.Version == meta.Version && previous.ProtocolVersion == meta.ProtocolVersion {
+		return ok
+	}
+
+	version := meta.Version
+	if version == "" {
+		version = "unknown"
+	}
+
+	if !ok {
+		metrics.IncrCounter([]string{"delegate", "incompatible_peer"}, 1)
+		log.Warnf("Peer %s is running Sidecar %s (protocol %d), which is incompatible with ours, %s (protocol %d)",
+			node.Name, version, meta.ProtocolVersion, ours.Version, PROTOCOL_VERSION,
+		)
+	} else if meta.Version != ours.Version {
+		log.Infof("Peer %s is running Sidecar %s, we are running %s", node.Name, version, ours.Version)
+	}
+
+	return ok
+}
+
+// support tells whether every peer we know of can decode broadcasts
+// compressed with the codec
+func (p *peerVersions) support(codec string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, meta := range p.peers {
+		if !meta.decodes(codec) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodes tells whether the node can decode broadcasts compressed with the
+// codec
+func (meta NodeMetadata) decodes(codec string) bool {
+	for _, name := range meta.Compression {
+		if name == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// remove forgets about a peer that has left the cluster
+func (p *peerVersions) remove(name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.peers, name)
+	p.setGauge()
+}
+
+// setGauge reports the number of peers we can't work with. Expects the
+// caller to hold the lock.
+func (p *peerVersions) setGauge() {
+	var incompatible int
+	for _, meta := range p.peers {
+		if !compatible(meta) {
+			incompatible++
+		}
+	}
+
+	metrics.SetGauge([]string{"delegate", "incompatible_peers"}, float32(incompatible))
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/NinesStack/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PeerVersions(t *testing.T) {
+	Convey("compatible()", t, func() {
+		Convey("accepts peers speaking our protocol", func() {
+			meta := NodeMetadata{ProtocolVersion: PROTOCOL_VERSION, MinProtocolVersion: MIN_PROTOCOL_VERSION}
+			So(compatible(meta), ShouldBeTrue)
+		})
+
+		Convey("accepts peers from before versioning", func() {
+			So(compatible(NodeMetadata{ClusterName: "default"}), ShouldBeTrue)
+		})
+
+		Convey("rejects peers that we are too old for", func() {
+			meta := NodeMetadata{ProtocolVersion: PROTOCOL_VERSION + 2, MinProtocolVersion: PROTOCOL_VERSION + 1}
+			So(compatible(meta), ShouldBeFalse)
+		})
+	})
+
+	Convey("peerVersions", t, func() {
+		peers := &peerVersions{}
+		ours := NodeMetadata{Version: "v1.9.0", ProtocolVersion: PROTOCOL_VERSION}
+
+		Convey("tracks the metadata of each peer", func() {
+			ok := peers.update(&memberlist.Node{
+				Name: "chaucer",
+				Meta: []byte(`{"ClusterName":"default","Version":"v1.8.0","ProtocolVersion":1,"MinProtocolVersion":1}`),
+			}, ours)
+
+			So(ok, ShouldBeTrue)
+			So(peers.peers["chaucer"].Version, ShouldEqual, "v1.8.0")
+		})
+
+		Convey("flags incompatible peers", func() {
+			ok := peers.update(&memberlist.Node{
+				Name: "chaucer",
+				Meta: []byte(`{"ClusterName":"default","Version":"v3.0.0","ProtocolVersion":3,"MinProtocolVersion":3}`),
+			}, ours)
+
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("doesn't choke on bad metadata", func() {
+			ok := peers.update(&memberlist.Node{Name: "chaucer", Meta: []byte(`garbage`)}, ours)
+
+			So(ok, ShouldBeTrue)
+			So(peers.peers, ShouldNotContainKey, "chaucer")
+		})
+
+		Convey("forgets peers that leave", func() {
+			peers.update(&memberlist.Node{Name: "chaucer"}, ours)
+			So(peers.peers, ShouldContainKey, "chaucer")
+
+			peers.remove("chaucer")
+			So(peers.peers, ShouldNotContainKey, "chaucer")
+		})
+	})
+}
@@ -1,4 +1,4 @@
-package main
+package agent
 
 import (
 	"encoding/json"
@@ -10,7 +10,6 @@ import (
 	"github.com/NinesStack/sidecar/service"
 	metrics "github.com/armon/go-metrics"
 	"github.com/pquerna/ffjson/ffjson"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -24,11 +23,18 @@ type servicesDelegate struct {
 	Started           bool
 	StartedAt         time.Time
 	Metadata          NodeMetadata
+	Compression       BroadcastCodec // nil to send broadcasts as they are
+	peers             peerVersions
 }
 
 type NodeMetadata struct {
-	ClusterName string
-	State       string
+	ClusterName        string
+	State              string
+	Version            string            `json:",omitempty"`
+	ProtocolVersion    int               `json:",omitempty"`
+	MinProtocolVersion int               `json:",omitempty"`
+	Labels             map[string]string `json:",omitempty"` // Set by the operator, e.g. the rack
+	Compression        []string          `json:",omitempty"` // The broadcast codecs the node can decode
 }
 
 func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
@@ -46,9 +52,26 @@ func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
 func (d *servicesDelegate) Start() {
 	go func() {
 		for message := range d.notifications {
+			message, err := decodeBroadcast(message)
+			if err != nil {
+				gossipLog.Errorf("Start(): error decompressing message: %s", err)
+				continue
+			}
+
+			// Checker nodes' results aren't service records
+			check, err := catalog.DecodeRemoteCheck(message)
+			if err != nil {
+				gossipLog.Errorf("Start(): error decoding remote check: %s", err)
+				continue
+			}
+			if check != nil {
+				d.state.AddRemoteCheck(*check)
+				continue
+			}
+
 			entry, err := service.Decode(message)
 			if err != nil {
-				log.Errorf("Start(): error decoding message: %s", err)
+				gossipLog.Errorf("Start(): error decoding message: %s", err)
 				continue
 			}
 			d.state.UpdateService(*entry)
@@ -60,10 +83,10 @@ func (d *servicesDelegate) Start() {
 }
 
 func (d *servicesDelegate) NodeMeta(limit int) []byte {
-	log.Debugf("NodeMeta(): %d", limit)
+	gossipLog.Debugf("NodeMeta(): %d", limit)
 	data, err := json.Marshal(d.Metadata)
 	if err != nil {
-		log.Error("Error encoding Node metadata!")
+		gossipLog.Error("Error encoding Node metadata!")
 		data = []byte("{}")
 	}
 	return data
@@ -73,11 +96,11 @@ func (d *servicesDelegate) NotifyMsg(message []byte) {
 	defer metrics.MeasureSince([]string{"delegate", "NotifyMsg"}, time.Now())
 
 	if len(message) < 1 {
-		log.Debug("NotifyMsg(): empty")
+		gossipLog.Debug("NotifyMsg(): empty")
 		return
 	}
 
-	log.Debugf("NotifyMsg(): %s", string(message))
+	gossipLog.Debugf("NotifyMsg(): %s", string(message))
 
 	d.notifications <- message
 }
@@ -86,7 +109,7 @@ func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 	defer metrics.MeasureSince([]string{"delegate", "GetBroadcasts"}, time.Now())
 	metrics.SetGauge([]string{"delegate", "pendingBroadcasts"}, float32(len(d.pendingBroadcasts)))
 
-	log.Debugf("GetBroadcasts(): %d %d", overhead, limit)
+	gossipLog.Debugf("GetBroadcasts(): %d %d", overhead, limit)
 
 	var broadcast [][]byte
 
@@ -104,7 +127,12 @@ func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 	if len(d.pendingBroadcasts) > 0 {
 		broadcast = append(broadcast, d.pendingBroadcasts...)
 	}
-	broadcast, leftover := d.packPacket(broadcast, limit, overhead)
+
+	// Pending messages are kept as they are, and compressed again each time,
+	// in case a peer that can't decode them joins in the meantime
+	messages := d.compress(broadcast)
+	packet, _ := d.packPacket(messages, limit, overhead)
+	sent, leftover := broadcast[:len(packet)], broadcast[len(packet):]
 
 	if len(leftover) > 0 {
 		// We don't want to store old messages forever, or starve ourselves to death
@@ -114,37 +142,62 @@ func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 			d.pendingBroadcasts = leftover
 		}
 
-		log.Debugf("Leaving %d messages unsent", len(leftover))
+		gossipLog.Debugf("Leaving %d messages unsent", len(leftover))
 	} else {
 		d.pendingBroadcasts = [][]byte{}
 	}
 
-	if broadcast == nil || len(broadcast) < 1 {
-		log.Debug("Note: Not enough space to fit any messages or message was nil")
+	if len(packet) < 1 {
+		gossipLog.Debug("Note: Not enough space to fit any messages or message was nil")
 		return nil
 	}
 
-	log.Debugf("Sending broadcast %d msgs %d 1st length",
-		len(broadcast), len(broadcast[0]),
+	gossipLog.Debugf("Sending broadcast %d msgs %d 1st length",
+		len(packet), len(packet[0]),
 	)
 
+	var size, uncompressed int
+	for i, message := range packet {
+		size += len(message)
+		uncompressed += len(sent[i])
+	}
+	d.state.RecordBroadcast(size)
+	if uncompressed > size {
+		metrics.IncrCounter([]string{"delegate", "compression", "saved"}, float32(uncompressed-size))
+	}
+
 	// Unfortunately Memberlist does not provide a callback after broadcasts were
 	// accepted so we have no direct way to return these to the pool. However, it
 	// immediately copies what we return into a new buffer. So, it's not perfectly,
 	// but is reasonably safe to wait awhile and then re-add our buffer to the
 	// ffjson pool.
-	go func(broadcast [][]byte) {
+	go func(sent [][]byte) {
 		time.Sleep(25 * time.Millisecond) // Lots of safety margin in this number
-		for i := 0; i < len(broadcast); i++ {
-			ffjson.Pool(broadcast[i])
+		for i := 0; i < len(sent); i++ {
+			ffjson.Pool(sent[i])
 		}
-	}(broadcast)
+	}(sent)
+
+	return packet
+}
+
+// compress returns the messages compressed with our codec, or as they are
+// when we have no codec or a peer can't decode it
+func (d *servicesDelegate) compress(broadcast [][]byte) [][]byte {
+	if d.Compression == nil || !d.peers.support(d.Compression.Name()) {
+		return broadcast
+	}
+
+	messages := make([][]byte, len(broadcast))
+	for i, message := range broadcast {
+		messages[i] = encodeBroadcast(d.Compression, message)
+	}
 
-	return broadcast
+	return messages
 }
 
 func (d *servicesDelegate) LocalState(join bool) []byte {
-	log.Debugf("LocalState(): %t", join)
+	gossipLog.Debugf("LocalState(): %t", join)
 	d.state.RLock()
 	defer d.state.RUnlock()
 	return d.state.Encode()
@@ -153,30 +206,33 @@ func (d *servicesDelegate) LocalState(join bool) []byte {
 func (d *servicesDelegate) MergeRemoteState(buf []byte, join bool) {
 	defer metrics.MeasureSince([]string{"delegate", "MergeRemoteState"}, time.Now())
 
-	log.Debugf("MergeRemoteState(): %s %t", string(buf), join)
+	gossipLog.Debugf("MergeRemoteState(): %s %t", string(buf), join)
 
 	otherState, err := catalog.Decode(buf)
 	if err != nil {
-		log.Errorf("Failed to MergeRemoteState(): %s", err.Error())
+		gossipLog.Errorf("Failed to MergeRemoteState(): %s", err.Error())
 		return
 	}
 
-	log.Debugf("Merging state: %s", otherState.Format(nil))
+	gossipLog.Debugf("Merging state: %s", otherState.Format(nil))
 
 	d.state.Merge(otherState)
 }
 
 func (d *servicesDelegate) NotifyJoin(node *memberlist.Node) {
-	log.Debugf("NotifyJoin(): %s %s", node.Name, string(node.Meta))
+	gossipLog.Debugf("NotifyJoin(): %s %s", node.Name, string(node.Meta))
+	d.peers.update(node, d.Metadata)
 }
 
 func (d *servicesDelegate) NotifyLeave(node *memberlist.Node) {
-	log.Debugf("NotifyLeave(): %s", node.Name)
+	gossipLog.Debugf("NotifyLeave(): %s", node.Name)
+	d.peers.remove(node.Name)
 	go d.state.ExpireServer(node.Name)
 }
 
 func (d *servicesDelegate) NotifyUpdate(node *memberlist.Node) {
-	log.Debugf("NotifyUpdate(): %s", node.Name)
+	gossipLog.Debugf("NotifyUpdate(): %s", node.Name)
+	d.peers.update(node, d.Metadata)
 }
 
 // Try to pack as many messages into the packet as we can. Note that this
@@ -204,7 +260,7 @@ func (d *servicesDelegate) packPacket(broadcasts [][]byte, limit int, overhead i
 			// Sample this so that we don't go apeshit logging when there is something
 			// a bit blocked up. We'll log 1/50th of the time.
 			if rand.Intn(50) == 1 {
-				log.Warnf("All messages were too long to fit! No broadcasts!")
+				gossipLog.Warnf("All messages were too long to fit! No broadcasts!")
 			}
 		}
 
@@ -1,9 +1,11 @@
-package main
+package agent
 
 import (
 	"testing"
+	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -26,6 +28,27 @@ func Test_GetBroadcasts(t *testing.T) {
 				delegate.Start()
 				So(delegate.Started, ShouldBeTrue)
 			})
+
+			Convey("Hands remote check results to the state", func() {
+				state.Hostname = "docker1"
+				check := catalog.RemoteCheck{
+					ID: "1b3295bf300f", Hostname: "docker1", Checker: "docker2",
+					Status: service.ALIVE, Time: time.Now().UTC(),
+				}
+				encoded, err := check.Encode()
+				So(err, ShouldBeNil)
+
+				delegate.Start()
+				delegate.NotifyMsg(encoded)
+
+				local := []service.Service{{ID: "1b3295bf300f", Hostname: "docker1", Status: service.UNHEALTHY}}
+				for i := 0; i < 100 && local[0].Status != service.ALIVE; i++ {
+					time.Sleep(time.Millisecond)
+					state.ApplyRemoteChecks(local)
+				}
+				So(local[0].Status, ShouldEqual, service.ALIVE)
+				So(len(state.ServiceMsgs), ShouldEqual, 0)
+			})
 		})
 
 		Convey("NotifyMsg()", func() {
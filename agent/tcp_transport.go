@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TCPTransportMaxPacket   = 64 * 1024        // The largest packet we'll accept
+	TCPTransportDialTimeout = 10 * time.Second // How long we wait to connect when sending a packet
+	TCPTransportReadTimeout = 5 * time.Second  // How long a peer gets to send the packet header
+
+	tcpPacketMarker byte = 'p'
+	tcpStreamMarker byte = 's'
+)
+
+// A TCPTransport is a Memberlist Transport that sends everything over TCP,
+// for networks where UDP between hosts is blocked. Each packet is sent on its
+// own short-lived connection, framed with the address the sender advertises,
+// so that replies reach it rather than the address the connection came from.
+// That also keeps it working when peers are behind NAT.
+type TCPTransport struct {
+	listener   *net.TCPListener
+	packetCh   chan *memberlist.Packet
+	streamCh   chan net.Conn
+	advertise  string // Our advertised host:port, sent with each packet
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+	sync.RWMutex
+}
+
+// NewTCPTransport starts listening on the bind address and port and returns
+// a transport that's ready to hand to Memberlist
+func NewTCPTransport(bindAddr string, bindPort int) (*TCPTransport, error) {
+	ip := net.ParseIP(bindAddr)
+	if bindAddr != "" && ip == nil {
+		return nil, fmt.Errorf("invalid bind address %q", bindAddr)
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: bindPort})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start TCP transport: %w", err)
+	}
+
+	t := &TCPTransport{
+		listener:   listener,
+		packetCh:   make(chan *memberlist.Packet),
+		streamCh:   make(chan net.Conn),
+		shutdownCh: make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.accept()
+
+	return t, nil
+}
+
+// FinalAdvertiseAddr returns the address we advertise to the cluster and
+// remembers it so that we can send it along with our packets
+func (t *TCPTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	var advertiseIP net.IP
+	if ip != "" {
+		advertiseIP = net.ParseIP(ip)
+		if advertiseIP == nil {
+			return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+		}
+	} else {
+		advertiseIP = t.listener.Addr().(*net.TCPAddr).IP
+		if advertiseIP.IsUnspecified() {
+			return nil, 0, errors.New("no advertise address configured for the TCP transport")
+		}
+	}
+
+	if ip4 := advertiseIP.To4(); ip4 != nil {
+		advertiseIP = ip4
+	}
+
+	if port == 0 {
+		port = t.listener.Addr().(*net.TCPAddr).Port
+	}
+
+	t.Lock()
+	t.advertise = net.JoinHostPort(advertiseIP.String(), strconv.Itoa(port))
+	t.Unlock()
+
+	return advertiseIP, port, nil
+}
+
+// WriteTo connects to the peer and sends it a single packet
+func (t *TCPTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	if len(b) > TCPTransportMaxPacket {
+		return time.Time{}, fmt.Errorf("packet of %d bytes is too large", len(b))
+	}
+
+	t.RLock()
+	from := t.advertise
+	t.RUnlock()
+
+	conn, err := net.DialTimeout("tcp", addr, TCPTransportDialTimeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	// Marker, sender address length, sender address, packet length, packet
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+
+	buf := make([]byte, 0, 6+len(from)+len(b))
+	buf = append(buf, tcpPacketMarker, byte(len(from)))
+	buf = append(buf, from...)
+	buf = append(buf, size[:]...)
+	buf = append(buf, b...)
+
+	_, err = conn.Write(buf)
+	return time.Now(), err
+}
+
+// PacketCh returns the channel that incoming packets are delivered on
+func (t *TCPTransport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetCh
+}
+
+// DialTimeout opens a stream connection to a peer
+func (t *TCPTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write([]byte{tcpStreamMarker})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// StreamCh returns the channel that incoming streams are delivered on
+func (t *TCPTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown stops listening and waits for the accept loop to finish
+func (t *TCPTransport) Shutdown() error {
+	select {
+	case <-t.shutdownCh:
+		return nil // Already shut down
+	default:
+	}
+
+	close(t.shutdownCh)
+	err := t.listener.Close()
+	t.wg.Wait()
+
+	return err
+}
+
+// accept hands each incoming connection off to be handled until we shut down
+func (t *TCPTransport) accept() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-t.shutdownCh:
+				return
+			default:
+			}
+
+			log.Errorf("TCP transport failed to accept a connection: %s", err)
+			time.Sleep(100 * time.Millisecond) // Don't spin on a broken listener
+			continue
+		}
+
+		go t.handleConn(conn)
+	}
+}
+
+// handleConn works out whether a connection is carrying a packet or is a
+// stream, and passes it on to Memberlist
+func (t *TCPTransport) handleConn(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(TCPTransportReadTimeout))
+
+	var marker [1]byte
+	if _, err := io.ReadFull(conn, marker[:]); err != nil {
+		log.Debugf("TCP transport failed to read from %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	switch marker[0] {
+	case tcpStreamMarker:
+		_ = conn.SetReadDeadline(time.Time{})
+		select {
+		case t.streamCh <- conn:
+		case <-t.shutdownCh:
+			conn.Close()
+		}
+
+	case tcpPacketMarker:
+		defer conn.Close()
+
+		packet, err := t.readPacket(conn)
+		if err != nil {
+			log.Warnf("TCP transport got a bad packet from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		select {
+		case t.packetCh <- packet:
+		case <-t.shutdownCh:
+		}
+
+	default:
+		log.Warnf("TCP transport got unknown message type %d from %s", marker[0], conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// readPacket reads the rest of a packet once we've seen the marker
+func (t *TCPTransport) readPacket(conn net.Conn) (*memberlist.Packet, error) {
+	var addrLen [1]byte
+	if _, err := io.ReadFull(conn, addrLen[:]); err != nil {
+		return nil, err
+	}
+
+	addr := make([]byte, addrLen[0])
+	if _, err := io.ReadFull(conn, addr); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(size[:])
+	if length > TCPTransportMaxPacket {
+		return nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	timestamp := time.Now()
+
+	// Replies go to where the sender listens, not where it connected from
+	var from net.Addr = conn.RemoteAddr()
+	if len(addr) > 0 {
+		resolved, err := net.ResolveTCPAddr("tcp", string(addr))
+		if err != nil {
+			return nil, fmt.Errorf("bad sender address %q: %s", addr, err)
+		}
+		from = resolved
+	}
+
+	return &memberlist.Packet{Buf: buf, From: from, Timestamp: timestamp}, nil
+}
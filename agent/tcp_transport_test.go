@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TCPTransport(t *testing.T) {
+	Convey("TCPTransport", t, func() {
+		sender, err := NewTCPTransport("127.0.0.1", 0)
+		So(err, ShouldBeNil)
+		defer sender.Shutdown()
+
+		receiver, err := NewTCPTransport("127.0.0.1", 0)
+		So(err, ShouldBeNil)
+		defer receiver.Shutdown()
+
+		senderIP, senderPort, err := sender.FinalAdvertiseAddr("", 0)
+		So(err, ShouldBeNil)
+		So(senderIP.String(), ShouldEqual, "127.0.0.1")
+
+		receiverAddr := receiver.listener.Addr().String()
+
+		Convey("delivers packets from the advertised address", func() {
+			_, err := sender.WriteTo([]byte("ping"), receiverAddr)
+			So(err, ShouldBeNil)
+
+			select {
+			case packet := <-receiver.PacketCh():
+				So(string(packet.Buf), ShouldEqual, "ping")
+				So(packet.From.String(), ShouldEqual, fmt.Sprintf("127.0.0.1:%d", senderPort))
+			case <-time.After(time.Second):
+				So("timed out", ShouldBeEmpty)
+			}
+		})
+
+		Convey("delivers streams", func() {
+			conn, err := sender.DialTimeout(receiverAddr, time.Second)
+			So(err, ShouldBeNil)
+			_, _ = conn.Write([]byte("hello"))
+			conn.Close()
+
+			select {
+			case incoming := <-receiver.StreamCh():
+				body, err := ioutil.ReadAll(incoming)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "hello")
+				incoming.Close()
+			case <-time.After(time.Second):
+				So("timed out", ShouldBeEmpty)
+			}
+		})
+
+		Convey("drops connections it doesn't understand", func() {
+			conn, err := net.Dial("tcp", receiverAddr)
+			So(err, ShouldBeNil)
+			_, _ = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+			// Closed on us without a reply
+			n, _ := conn.Read(make([]byte, 1))
+			So(n, ShouldEqual, 0)
+			conn.Close()
+		})
+
+		Convey("refuses oversized packets", func() {
+			_, err := sender.WriteTo(make([]byte, TCPTransportMaxPacket+1), receiverAddr)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("lets Memberlist form a cluster", func() {
+			newList := func(name string) *memberlist.Memberlist {
+				transport, err := NewTCPTransport("127.0.0.1", 0)
+				So(err, ShouldBeNil)
+
+				mlConfig := memberlist.DefaultLocalConfig()
+				mlConfig.Name = name
+				mlConfig.Transport = transport
+				mlConfig.AdvertiseAddr = "127.0.0.1"
+				mlConfig.AdvertisePort = transport.listener.Addr().(*net.TCPAddr).Port
+				mlConfig.LogOutput = ioutil.Discard
+
+				list, err := memberlist.Create(mlConfig)
+				So(err, ShouldBeNil)
+				return list
+			}
+
+			first := newList("chaucer")
+			defer first.Shutdown()
+			second := newList("spenser")
+			defer second.Shutdown()
+
+			_, err := second.Join([]string{first.LocalNode().Address()})
+			So(err, ShouldBeNil)
+			So(first.NumMembers(), ShouldEqual, 2)
+			So(second.NumMembers(), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"sort"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// AliasOwners works out which service each alias refers to, from the
+// services grouped by their own names. A service's real name always wins
+// over an alias, and when more than one service claims the same alias, the
+// first one by name gets it. That keeps the answer the same on every host.
+func AliasOwners(byName map[string][]*service.Service) map[string]string {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	owners := make(map[string]string)
+	for _, name := range names {
+		for _, svc := range byName[name] {
+			for _, alias := range svc.Aliases {
+				if _, ok := byName[alias]; ok {
+					continue
+				}
+
+				if _, ok := owners[alias]; !ok {
+					owners[alias] = name
+				}
+			}
+		}
+	}
+
+	return owners
+}
+
+// Aliases returns the service name that each alias refers to. The state
+// must be locked by the caller.
+func (state *ServicesState) Aliases() map[string]string {
+	return AliasOwners(state.ByServiceWithoutAliases())
+}
+
+// AliasesFor inverts the result of Aliases(), returning the sorted aliases
+// that belong to each service
+func AliasesFor(owners map[string]string) map[string][]string {
+	aliases := make(map[string][]string)
+	for alias, name := range owners {
+		aliases[name] = append(aliases[name], alias)
+	}
+
+	for _, list := range aliases {
+		sort.Strings(list)
+	}
+
+	return aliases
+}
@@ -0,0 +1,66 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Aliases(t *testing.T) {
+	Convey("Listing services under their aliases", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		svc1 := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: hostname,
+			Updated: baseTime, Status: service.ALIVE, Aliases: []string{"grendel", "api-v1"},
+		}
+		svc2 := service.Service{
+			ID: "deadbeef456", Name: "hrunting", Hostname: hostname,
+			Updated: baseTime, Status: service.ALIVE, Aliases: []string{"api-v1"},
+		}
+		svc3 := service.Service{
+			ID: "deadbeef789", Name: "grendel", Hostname: anotherHostname,
+			Updated: baseTime, Status: service.ALIVE,
+		}
+
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		Convey("lists each service under its aliases", func() {
+			byService := state.ByService()
+			So(len(byService["grendel"]), ShouldEqual, 1)
+			So(byService["grendel"][0].Name, ShouldEqual, "beowulf")
+		})
+
+		Convey("gives a contested alias to the first service by name", func() {
+			So(state.Aliases()["api-v1"], ShouldEqual, "beowulf")
+			So(state.ByService()["api-v1"][0].ID, ShouldEqual, "deadbeef123")
+		})
+
+		Convey("never lets an alias hide a real service", func() {
+			state.AddServiceEntry(svc3)
+
+			byService := state.ByService()
+			So(len(byService["grendel"]), ShouldEqual, 1)
+			So(byService["grendel"][0].ID, ShouldEqual, "deadbeef789")
+			So(state.Aliases(), ShouldNotContainKey, "grendel")
+		})
+
+		Convey("leaves the aliases out of ByServiceWithoutAliases()", func() {
+			byService := state.ByServiceWithoutAliases()
+			So(byService, ShouldNotContainKey, "grendel")
+			So(byService, ShouldNotContainKey, "api-v1")
+		})
+
+		Convey("inverts the aliases for each service", func() {
+			So(AliasesFor(state.Aliases()), ShouldResemble, map[string][]string{
+				"beowulf": {"api-v1", "grendel"},
+			})
+		})
+	})
+}
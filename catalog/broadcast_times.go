@@ -0,0 +1,41 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// LastBroadcast returns when this node last put the service on the wire, or
+// false if it hasn't since it started. Services we only hear about from
+// other hosts are never broadcast by us, unless we tombstone them.
+func (state *ServicesState) LastBroadcast(id string) (time.Time, bool) {
+	state.broadcastLock.Lock()
+	defer state.broadcastLock.Unlock()
+
+	broadcast, ok := state.broadcastTimes[id]
+	return broadcast, ok
+}
+
+// recordBroadcastTimes notes that we're sending the services now, and
+// forgets about the ones we haven't sent for longer than a tombstone lasts
+func (state *ServicesState) recordBroadcastTimes(services []service.Service) {
+	now := state.now()
+
+	state.broadcastLock.Lock()
+	defer state.broadcastLock.Unlock()
+
+	if state.broadcastTimes == nil {
+		state.broadcastTimes = make(map[string]time.Time, len(services))
+	}
+
+	for id, broadcast := range state.broadcastTimes {
+		if now.Sub(broadcast) > TOMBSTONE_LIFESPAN {
+			delete(state.broadcastTimes, id)
+		}
+	}
+
+	for _, svc := range services {
+		state.broadcastTimes[svc.ID] = now
+	}
+}
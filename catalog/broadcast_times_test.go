@@ -0,0 +1,43 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_LastBroadcast(t *testing.T) {
+	Convey("LastBroadcast()", t, func() {
+		state := NewServicesState()
+		frozen := clock.NewFrozen(time.Now().UTC())
+		state.Clock = frozen
+
+		svc := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: hostname}
+
+		Convey("is unknown for services we haven't sent", func() {
+			_, ok := state.LastBroadcast(svc.ID)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("records when we send a service", func() {
+			state.recordBroadcastTimes([]service.Service{svc})
+
+			broadcast, ok := state.LastBroadcast(svc.ID)
+			So(ok, ShouldBeTrue)
+			So(broadcast, ShouldEqual, frozen.Now())
+		})
+
+		Convey("forgets services we stopped sending long ago", func() {
+			state.recordBroadcastTimes([]service.Service{svc})
+
+			frozen.Advance(TOMBSTONE_LIFESPAN + time.Second)
+			state.recordBroadcastTimes([]service.Service{{ID: "deadbeef456"}})
+
+			_, ok := state.LastBroadcast(svc.ID)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// A ServicePortKey identifies a service as exposed on one of its ServicePorts
+type ServicePortKey struct {
+	Name        string
+	ServicePort int64
+}
+
+func (k ServicePortKey) String() string {
+	return fmt.Sprintf("%s:%d", k.Name, k.ServicePort)
+}
+
+// A PortConflict is a ServicePort that more than one service is exposed on
+type PortConflict struct {
+	ServicePort int64
+	Names       []string
+}
+
+func (c PortConflict) String() string {
+	return fmt.Sprintf("port %d claimed by %v", c.ServicePort, c.Names)
+}
+
+// ByServiceAndPort groups the services by name and ServicePort. Unlike
+// ByService(), instances of the same service don't have to agree on their
+// ServicePorts: each instance is listed under every ServicePort it exposes.
+// This keeps both versions of a service routable while its ports are being
+// migrated. Ports without a ServicePort are left out.
+func (state *ServicesState) ByServiceAndPort() map[ServicePortKey][]*service.Service {
+	serviceMap := make(map[ServicePortKey][]*service.Service)
+
+	state.EachServiceSorted(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			seen := make(map[int64]bool, len(svc.Ports))
+			for _, port := range svc.Ports {
+				if port.ServicePort == 0 || seen[port.ServicePort] {
+					continue
+				}
+				seen[port.ServicePort] = true
+
+				key := ServicePortKey{Name: svc.Name, ServicePort: port.ServicePort}
+				serviceMap[key] = append(serviceMap[key], svc)
+			}
+		},
+	)
+
+	return serviceMap
+}
+
+// ServicePortOwners returns the name of the service exposed on each
+// ServicePort in the cluster. Tombstones are left out. Where several
+// services claim a port, the least recently updated one is returned.
+// Handles locking the state.
+func (state *ServicesState) ServicePortOwners() map[int64]string {
+	state.RLock()
+	defer state.RUnlock()
+
+	owners := make(map[int64]string)
+	state.EachServiceSorted(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			if svc.IsTombstone() {
+				return
+			}
+
+			for _, port := range svc.Ports {
+				if _, ok := owners[port.ServicePort]; !ok && port.ServicePort > 0 {
+					owners[port.ServicePort] = svc.Name
+				}
+			}
+		},
+	)
+
+	return owners
+}
+
+// AllocatedServicePorts returns the ServicePorts that nodes allocated from
+// their pools, by service name and container port. Tombstones are left out.
+// Where nodes allocated different ones, the least recently updated one is
+// returned, as with ServicePortOwners(). Handles locking the state.
+func (state *ServicesState) AllocatedServicePorts() map[string]map[int64]int64 {
+	state.RLock()
+	defer state.RUnlock()
+
+	allocated := make(map[string]map[int64]int64)
+	state.EachServiceSorted(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			if svc.IsTombstone() {
+				return
+			}
+
+			for _, port := range svc.Ports {
+				if port.ContainerPort == 0 || port.ServicePort <= 0 {
+					continue
+				}
+				if allocated[svc.Name] == nil {
+					allocated[svc.Name] = make(map[int64]int64)
+				}
+				if _, ok := allocated[svc.Name][port.ContainerPort]; !ok {
+					allocated[svc.Name][port.ContainerPort] = port.ServicePort
+				}
+			}
+		},
+	)
+
+	return allocated
+}
+
+// PortConflicts returns the ServicePorts that more than one service claims,
+// sorted by port. The proxies can only send each port to one service.
+func PortConflicts(byPort map[ServicePortKey][]*service.Service) []PortConflict {
+	namesByPort := make(map[int64][]string)
+	for key, instances := range byPort {
+		if len(instances) == 0 {
+			continue
+		}
+		namesByPort[key.ServicePort] = append(namesByPort[key.ServicePort], key.Name)
+	}
+
+	var conflicts []PortConflict
+	for port, names := range namesByPort {
+		if len(names) < 2 {
+			continue
+		}
+
+		sort.Strings(names)
+		conflicts = append(conflicts, PortConflict{ServicePort: port, Names: names})
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].ServicePort < conflicts[j].ServicePort
+	})
+
+	return conflicts
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ByServiceAndPort(t *testing.T) {
+	Convey("ByServiceAndPort()", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC()
+
+		oldVersion := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 8080},
+				{Type: "tcp", Port: 10001},
+			},
+		}
+		newVersion := service.Service{
+			ID: "deadbeef456", Name: "bocaccio", Hostname: "petrarch", Updated: baseTime,
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10002, ServicePort: 8080},
+				{Type: "tcp", Port: 10003, ServicePort: 8081},
+			},
+		}
+		state.AddServiceEntry(oldVersion)
+		state.AddServiceEntry(newVersion)
+
+		Convey("keeps instances with different ports", func() {
+			byPort := state.ByServiceAndPort()
+
+			So(len(byPort), ShouldEqual, 2)
+			So(len(byPort[ServicePortKey{"bocaccio", 8080}]), ShouldEqual, 2)
+			So(len(byPort[ServicePortKey{"bocaccio", 8081}]), ShouldEqual, 1)
+			So(byPort[ServicePortKey{"bocaccio", 8081}][0].ID, ShouldEqual, "deadbeef456")
+		})
+
+		Convey("reports ports claimed by more than one service", func() {
+			So(PortConflicts(state.ByServiceAndPort()), ShouldBeEmpty)
+
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef789", Name: "dante", Hostname: "petrarch", Updated: baseTime,
+				Ports: []service.Port{{Type: "tcp", Port: 10004, ServicePort: 8081}},
+			})
+
+			conflicts := PortConflicts(state.ByServiceAndPort())
+			So(conflicts, ShouldResemble, []PortConflict{
+				{ServicePort: 8081, Names: []string{"bocaccio", "dante"}},
+			})
+			So(conflicts[0].String(), ShouldEqual, "port 8081 claimed by [bocaccio dante]")
+		})
+	})
+}
+
+func Test_ServicePortOwners(t *testing.T) {
+	Convey("ServicePortOwners()", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 8080},
+				{Type: "tcp", Port: 10001},
+			},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "dante", Hostname: "petrarch", Updated: baseTime.Add(time.Second),
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10002, ServicePort: 8080},
+				{Type: "tcp", Port: 10003, ServicePort: 8081},
+			},
+		})
+
+		Convey("maps each ServicePort to a service name", func() {
+			So(state.ServicePortOwners(), ShouldResemble, map[int64]string{
+				8080: "bocaccio",
+				8081: "dante",
+			})
+		})
+
+		Convey("leaves out tombstones", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef789", Name: "virgil", Hostname: "petrarch",
+				Updated: baseTime, Status: service.TOMBSTONE,
+				Ports: []service.Port{{Type: "tcp", Port: 10004, ServicePort: 8082}},
+			})
+
+			So(state.ServicePortOwners(), ShouldNotContainKey, int64(8082))
+		})
+	})
+}
+
+func Test_AllocatedServicePorts(t *testing.T) {
+	Convey("AllocatedServicePorts()", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 9000, ContainerPort: 80},
+				{Type: "tcp", Port: 10001, ServicePort: 8080},
+			},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "bocaccio", Hostname: "petrarch", Updated: baseTime.Add(time.Second),
+			Ports: []service.Port{{Type: "tcp", Port: 10002, ServicePort: 9001, ContainerPort: 80}},
+		})
+
+		Convey("maps the allocated ports by service and container port, oldest first", func() {
+			So(state.AllocatedServicePorts(), ShouldResemble, map[string]map[int64]int64{
+				"bocaccio": {80: 9000},
+			})
+		})
+	})
+}
@@ -0,0 +1,160 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	CLOCK_SKEW_WINDOW = 1 * time.Minute // How long each window of skew samples covers
+)
+
+// skewEstimate tracks the clock skew for a single peer. Records are only ever
+// delayed in transit, which makes them look older than they are, so the best
+// estimate is the largest skew we have seen recently. We keep the maximum for
+// the current and previous window so that the estimate can recover when a
+// peer's clock is corrected.
+type skewEstimate struct {
+	windowStart time.Time
+	current     time.Duration
+	previous    time.Duration
+	hasCurrent  bool
+	hasPrevious bool
+	warned      bool
+}
+
+// rotate moves on to a new window if the current one has finished
+func (e *skewEstimate) rotate(now time.Time) {
+	elapsed := now.Sub(e.windowStart)
+	if elapsed < CLOCK_SKEW_WINDOW {
+		return
+	}
+
+	if elapsed < 2*CLOCK_SKEW_WINDOW {
+		e.previous, e.hasPrevious = e.current, e.hasCurrent
+	} else {
+		// We haven't heard anything for more than a whole window
+		e.previous, e.hasPrevious = 0, false
+	}
+
+	e.current, e.hasCurrent = 0, false
+	e.windowStart = now
+}
+
+// add records a new sample
+func (e *skewEstimate) add(sample time.Duration, now time.Time) {
+	e.rotate(now)
+
+	if !e.hasCurrent || sample > e.current {
+		e.current = sample
+		e.hasCurrent = true
+	}
+}
+
+// estimate returns the skew and whether we have a recent enough sample. It
+// works out which windows have finished without moving on to them, so it only
+// needs the read lock.
+func (e *skewEstimate) estimate(now time.Time) (time.Duration, bool) {
+	current, hasCurrent := e.current, e.hasCurrent
+	previous, hasPrevious := e.previous, e.hasPrevious
+
+	elapsed := now.Sub(e.windowStart)
+	if elapsed >= 2*CLOCK_SKEW_WINDOW {
+		hasCurrent, hasPrevious = false, false
+	} else if elapsed >= CLOCK_SKEW_WINDOW {
+		previous, hasPrevious = current, hasCurrent
+		hasCurrent = false
+	}
+
+	switch {
+	case hasCurrent && hasPrevious:
+		if previous > current {
+			return previous, true
+		}
+		return current, true
+	case hasCurrent:
+		return current, true
+	case hasPrevious:
+		return previous, true
+	}
+
+	return 0, false
+}
+
+// ClockSkews returns the estimated clock skew for each peer that we have
+// heard from recently. Positive values mean the peer's clock is ahead of
+// ours.
+func (state *ServicesState) ClockSkews() map[string]time.Duration {
+	state.RLock()
+	defer state.RUnlock()
+
+	now := state.now()
+	skews := make(map[string]time.Duration, len(state.clockSkews))
+	for hostname, estimate := range state.clockSkews {
+		if skew, ok := estimate.estimate(now); ok {
+			skews[hostname] = skew
+		}
+	}
+
+	return skews
+}
+
+// recordSkew takes a sample of the clock skew for the host that announced
+// this service. Note: not synchronized!
+func (state *ServicesState) recordSkew(svc *service.Service, now time.Time) {
+	if svc.Hostname == state.Hostname || svc.Hostname == "" {
+		return
+	}
+
+	estimate, ok := state.clockSkews[svc.Hostname]
+	if !ok {
+		estimate = &skewEstimate{windowStart: now}
+		state.clockSkews[svc.Hostname] = estimate
+	}
+
+	estimate.add(svc.Updated.Sub(now), now)
+
+	skew, _ := estimate.estimate(now)
+	metrics.SetGauge(
+		[]string{"services_state", "clock_skew", svc.Hostname},
+		float32(skew/time.Millisecond),
+	)
+
+	if state.MaxClockSkew <= 0 {
+		return
+	}
+
+	tooFar := skew > state.MaxClockSkew || skew < -state.MaxClockSkew
+	if tooFar && !estimate.warned {
+		log.Warnf("Clock on %s appears to be skewed by %s", svc.Hostname, skew)
+		estimate.warned = true
+	} else if !tooFar && estimate.warned {
+		log.Infof("Clock on %s is back in sync (skew %s)", svc.Hostname, skew)
+		estimate.warned = false
+	}
+}
+
+// skewFor returns the skew to compensate for when looking at timestamps from
+// this host. Always zero unless CompensateClockSkew is enabled. Note: not
+// synchronized!
+func (state *ServicesState) skewFor(hostname string) time.Duration {
+	if !state.CompensateClockSkew {
+		return 0
+	}
+
+	estimate, ok := state.clockSkews[hostname]
+	if !ok {
+		return 0
+	}
+
+	skew, _ := estimate.estimate(state.now())
+	return skew
+}
+
+// localUpdated returns the service's Updated time translated into our own
+// clock, when compensating for skew. Note: not synchronized!
+func (state *ServicesState) localUpdated(svc *service.Service) time.Time {
+	return svc.Updated.Add(0 - state.skewFor(svc.Hostname))
+}
@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ClockSkew(t *testing.T) {
+	Convey("Estimating clock skew", t, func() {
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		Convey("keeps the largest sample in the window", func() {
+			estimate := &skewEstimate{windowStart: baseTime}
+			estimate.add(2*time.Second, baseTime)
+			estimate.add(5*time.Second, baseTime.Add(time.Second))
+			estimate.add(1*time.Second, baseTime.Add(2*time.Second))
+
+			skew, ok := estimate.estimate(baseTime.Add(3 * time.Second))
+			So(ok, ShouldBeTrue)
+			So(skew, ShouldEqual, 5*time.Second)
+		})
+
+		Convey("forgets old samples after two windows", func() {
+			estimate := &skewEstimate{windowStart: baseTime}
+			estimate.add(5*time.Second, baseTime)
+
+			later := baseTime.Add(CLOCK_SKEW_WINDOW + time.Second)
+			estimate.add(1*time.Second, later)
+			skew, _ := estimate.estimate(later)
+			So(skew, ShouldEqual, 5*time.Second)
+
+			later = later.Add(CLOCK_SKEW_WINDOW + time.Second)
+			estimate.add(1*time.Second, later)
+			skew, _ = estimate.estimate(later)
+			So(skew, ShouldEqual, 1*time.Second)
+		})
+
+		Convey("has no estimate when we haven't heard from a peer", func() {
+			estimate := &skewEstimate{windowStart: baseTime}
+			estimate.add(5*time.Second, baseTime)
+
+			_, ok := estimate.estimate(baseTime.Add(3 * CLOCK_SKEW_WINDOW))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("doesn't change the estimate when reading it", func() {
+			estimate := &skewEstimate{windowStart: baseTime}
+			estimate.add(5*time.Second, baseTime)
+			before := *estimate
+
+			skew, ok := estimate.estimate(baseTime.Add(CLOCK_SKEW_WINDOW + time.Second))
+			So(ok, ShouldBeTrue)
+			So(skew, ShouldEqual, 5*time.Second)
+			So(*estimate, ShouldResemble, before)
+		})
+	})
+
+	Convey("Tracking clock skew in the state", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: anotherHostname,
+			Updated:  time.Now().UTC().Add(30 * time.Second),
+			Ports:    []service.Port{{Type: "tcp", Port: 1234}},
+		}
+
+		Convey("records the skew of peers", func() {
+			state.AddServiceEntry(svc)
+
+			skews := state.ClockSkews()
+			So(skews[anotherHostname], ShouldBeGreaterThan, 29*time.Second)
+			So(skews[anotherHostname], ShouldBeLessThanOrEqualTo, 30*time.Second)
+		})
+
+		Convey("ignores our own services", func() {
+			svc.Hostname = hostname
+			state.AddServiceEntry(svc)
+
+			So(state.ClockSkews(), ShouldBeEmpty)
+		})
+
+		Convey("doesn't touch timestamps by default", func() {
+			state.AddServiceEntry(svc)
+
+			So(state.localUpdated(&svc), ShouldEqual, svc.Updated)
+		})
+
+		Convey("compensates for the skew when asked to", func() {
+			state.CompensateClockSkew = true
+			state.AddServiceEntry(svc)
+
+			diff := time.Now().UTC().Sub(state.localUpdated(&svc))
+			So(diff, ShouldBeGreaterThanOrEqualTo, 0)
+			So(diff, ShouldBeLessThan, time.Second)
+		})
+
+		Convey("accepts records from a peer with a fast clock when compensating", func() {
+			state.CompensateClockSkew = true
+			state.ValidationPolicy = ValidationReject
+			svc.Updated = time.Now().UTC().Add(5 * time.Minute)
+			state.AddServiceEntry(svc)
+
+			So(state.HasServer(anotherHostname), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// The types of ServiceCondition
+const (
+	ConditionReady          = "Ready"          // The proxies would send it traffic
+	ConditionChecksPassing  = "ChecksPassing"  // Its health checks pass
+	ConditionProxyPublished = "ProxyPublished" // This node's proxies have it. Only the HTTP API knows.
+	ConditionDraining       = "Draining"       // It's being drained of traffic
+)
+
+// The statuses of a ServiceCondition
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// A ServiceCondition is one aspect of the state of a service, in the shape
+// of the conditions on Kubernetes objects, so that clients don't have to
+// work them out from the Status
+type ServiceCondition struct {
+	Type               string
+	Status             string    // ConditionTrue, ConditionFalse, or ConditionUnknown
+	LastTransitionTime time.Time // When the Status last changed, as far as we know
+	Reason             string    // Why it has the Status, in CamelCase, e.g. "Unhealthy"
+	Message            string    `json:",omitempty"` // More detail for people
+}
+
+// ServiceConditions returns the Ready, ChecksPassing, and Draining conditions
+// of the service. The transition times come from its status history. When
+// the history doesn't go back far enough, the time the service was created
+// stands in. Note: not synchronized!
+func (state *ServicesState) ServiceConditions(svc *service.Service) []ServiceCondition {
+	ready := ServiceCondition{
+		Type:               ConditionReady,
+		Status:             readyStatus(svc.Status),
+		LastTransitionTime: state.conditionChanged(svc, readyStatus),
+		Reason:             svc.StatusString(),
+	}
+	if svc.IsAlive() && !state.IsServable(svc) {
+		ready.Status = ConditionFalse
+		ready.LastTransitionTime = svc.LastCheck.Time.Add(state.CheckMaxAge)
+		ready.Reason = "StaleCheck"
+		ready.Message = "The last health check was at " + svc.LastCheck.Time.Format(time.RFC3339)
+	}
+
+	checks := ServiceCondition{
+		Type:               ConditionChecksPassing,
+		Status:             checksStatus(svc.Status),
+		LastTransitionTime: state.conditionChanged(svc, checksStatus),
+		Reason:             svc.StatusString(),
+	}
+	switch svc.Status {
+	case service.ALIVE:
+		checks.Reason = "Healthy"
+	case service.UNKNOWN:
+		checks.Reason = "CheckUnknown"
+	}
+	if svc.LastCheck != nil {
+		checks.Message = "Last checked at " + svc.LastCheck.Time.Format(time.RFC3339)
+	}
+
+	draining := ServiceCondition{
+		Type:               ConditionDraining,
+		Status:             drainingStatus(svc.Status),
+		LastTransitionTime: state.conditionChanged(svc, drainingStatus),
+		Reason:             svc.StatusString(),
+	}
+
+	return []ServiceCondition{ready, checks, draining}
+}
+
+// conditionChanged returns when the service last went into the condition
+// status that it's in now
+func (state *ServicesState) conditionChanged(svc *service.Service, conditionStatus func(int) string) time.Time {
+	current := conditionStatus(svc.Status)
+
+	transitions := state.history[svc.ID]
+	for i := len(transitions) - 1; i >= 0; i-- {
+		transition := transitions[i]
+		if conditionStatus(transition.Status) == current && conditionStatus(transition.PreviousStatus) != current {
+			return transition.Time
+		}
+	}
+
+	if svc.Created.IsZero() {
+		return svc.Updated
+	}
+	return svc.Created
+}
+
+func readyStatus(status int) string {
+	if status == service.ALIVE {
+		return ConditionTrue
+	}
+	return ConditionFalse
+}
+
+// checksStatus is Unknown for draining services and tombstones, because
+// their checks no longer decide their status
+func checksStatus(status int) string {
+	switch status {
+	case service.ALIVE:
+		return ConditionTrue
+	case service.UNHEALTHY:
+		return ConditionFalse
+	default:
+		return ConditionUnknown
+	}
+}
+
+func drainingStatus(status int) string {
+	if status == service.DRAINING {
+		return ConditionTrue
+	}
+	return ConditionFalse
+}
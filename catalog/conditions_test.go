@@ -0,0 +1,105 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServiceConditions(t *testing.T) {
+	Convey("The conditions of a service", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(-1 * time.Hour)
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: anotherHostname,
+			Created:  baseTime.Add(-time.Minute),
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		}
+
+		// flip sends the service to a new status one second after the last
+		flip := func(status int) {
+			svc.Status = status
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+		}
+
+		byType := func() map[string]ServiceCondition {
+			entry, err := state.GetServiceByID(svc.ID)
+			So(err, ShouldBeNil)
+
+			result := make(map[string]ServiceCondition)
+			for _, condition := range state.ServiceConditions(&entry) {
+				result[condition.Type] = condition
+			}
+			return result
+		}
+
+		Convey("are ready and passing for live services", func() {
+			state.AddServiceEntry(svc)
+			conditions := byType()
+
+			So(conditions, ShouldHaveLength, 3)
+			So(conditions[ConditionReady].Status, ShouldEqual, ConditionTrue)
+			So(conditions[ConditionReady].LastTransitionTime, ShouldEqual, baseTime)
+			So(conditions[ConditionChecksPassing].Status, ShouldEqual, ConditionTrue)
+			So(conditions[ConditionChecksPassing].Reason, ShouldEqual, "Healthy")
+			So(conditions[ConditionDraining].Status, ShouldEqual, ConditionFalse)
+			So(conditions[ConditionDraining].Reason, ShouldEqual, "Alive")
+		})
+
+		Convey("follow the changes of status", func() {
+			state.AddServiceEntry(svc)
+			flip(service.UNHEALTHY)
+			unhealthyAt := svc.Updated
+			flip(service.ALIVE)
+			flip(service.DRAINING)
+			conditions := byType()
+
+			So(conditions[ConditionReady].Status, ShouldEqual, ConditionFalse)
+			So(conditions[ConditionReady].Reason, ShouldEqual, "Draining")
+			So(conditions[ConditionReady].LastTransitionTime, ShouldEqual, svc.Updated)
+			So(conditions[ConditionChecksPassing].Status, ShouldEqual, ConditionUnknown)
+			So(conditions[ConditionDraining].Status, ShouldEqual, ConditionTrue)
+			So(conditions[ConditionDraining].LastTransitionTime, ShouldEqual, svc.Updated)
+
+			Convey("and only change when their status does", func() {
+				flip(service.UNHEALTHY)
+				flip(service.TOMBSTONE)
+				conditions := byType()
+
+				So(conditions[ConditionReady].LastTransitionTime, ShouldEqual, unhealthyAt.Add(2*time.Second))
+				So(conditions[ConditionDraining].Status, ShouldEqual, ConditionFalse)
+				So(conditions[ConditionDraining].Reason, ShouldEqual, "Tombstone")
+			})
+		})
+
+		Convey("fall back to when the service was created", func() {
+			svc.Status = service.UNKNOWN
+			state.AddServiceEntry(svc)
+			conditions := byType()
+
+			So(conditions[ConditionReady].LastTransitionTime, ShouldEqual, svc.Created)
+			So(conditions[ConditionChecksPassing].Status, ShouldEqual, ConditionUnknown)
+			So(conditions[ConditionChecksPassing].Reason, ShouldEqual, "CheckUnknown")
+		})
+
+		Convey("aren't ready when the last check is too old", func() {
+			state.CheckMaxAge = time.Minute
+			svc.LastCheck = &service.CheckInfo{Time: baseTime}
+			state.AddServiceEntry(svc)
+			conditions := byType()
+
+			So(conditions[ConditionReady].Status, ShouldEqual, ConditionFalse)
+			So(conditions[ConditionReady].Reason, ShouldEqual, "StaleCheck")
+			So(conditions[ConditionReady].LastTransitionTime, ShouldEqual, baseTime.Add(time.Minute))
+			So(conditions[ConditionChecksPassing].Message, ShouldContainSubstring, "Last checked at")
+		})
+	})
+}
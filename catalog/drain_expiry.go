@@ -0,0 +1,115 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+// SetDrainDeadline makes a local service's drain expire after ttl, at which
+// point the service is tombstoned. A zero ttl uses the DrainTTL, and if
+// that's zero too, the drain never expires. Handles locking the state.
+func (state *ServicesState) SetDrainDeadline(id string, ttl time.Duration) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.setDrainDeadline(id, ttl)
+}
+
+// setDrainDeadline does the work of SetDrainDeadline(). Note: not
+// synchronized!
+func (state *ServicesState) setDrainDeadline(id string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = state.DrainTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	if state.drainDeadlines == nil {
+		state.drainDeadlines = make(map[string]time.Time)
+	}
+	state.drainDeadlines[id] = state.now().Add(ttl)
+}
+
+// isDrainExpired tells us whether a service is one of ours whose drain ran
+// out. These stay tombstoned for as long as discovery keeps finding them.
+// Note: not synchronized!
+func (state *ServicesState) isDrainExpired(svc *service.Service) bool {
+	return svc.Hostname == state.Hostname && state.drainExpired[svc.ID]
+}
+
+// forgetExpiredDrains stops holding down the services whose drain expired
+// once discovery no longer finds them. Only call it when discovery is
+// working. Note: not synchronized!
+func (state *ServicesState) forgetExpiredDrains(containerList []service.Service) {
+	running := makeServiceMapping(containerList)
+	for id := range state.drainExpired {
+		if _, ok := running[id]; !ok {
+			delete(state.drainExpired, id)
+		}
+	}
+}
+
+// expireDrains tombstones the local services that have been DRAINING for
+// longer than their deadline. Services drained without one, e.g. before a
+// restart, get one from the DrainTTL. Returns the tombstones to broadcast.
+// Note: not synchronized! Expects the caller to hold the state lock.
+func (state *ServicesState) expireDrains() []service.Service {
+	if state.drainDeadlines == nil {
+		state.drainDeadlines = make(map[string]time.Time)
+	}
+	if state.drainExpired == nil {
+		state.drainExpired = make(map[string]bool)
+	}
+
+	server, ok := state.Servers[state.Hostname]
+	if !ok {
+		return nil
+	}
+
+	for id := range state.drainDeadlines {
+		if svc, ok := server.Services[id]; !ok || svc.IsTombstone() {
+			delete(state.drainDeadlines, id)
+		}
+	}
+
+	now := state.now()
+	var result []service.Service
+
+	for id, svc := range server.Services {
+		if !svc.IsDraining() {
+			continue
+		}
+
+		deadline, ok := state.drainDeadlines[id]
+		if !ok {
+			if state.DrainTTL > 0 {
+				state.drainDeadlines[id] = now.Add(state.DrainTTL)
+			}
+			continue
+		}
+
+		if now.Before(deadline) {
+			continue
+		}
+
+		log.Warnf("Drain of %s (%s) expired, tombstoning it", svc.Name, svc.ID)
+		metrics.IncrCounterWithLabels([]string{"services_state", "drain_expired"}, 1, state.metricLabels())
+
+		previousStatus := svc.Status
+		svc.TombstoneAt(now)
+		state.ServiceChanged(svc, previousStatus, svc.Updated)
+
+		delete(state.drainDeadlines, id)
+		state.drainExpired[id] = true
+
+		// Tombstone each record twice to help with receipt
+		for i := 0; i < 2; i++ {
+			result = append(result, *svc)
+		}
+	}
+
+	return result
+}
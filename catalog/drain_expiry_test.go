@@ -0,0 +1,118 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_DrainExpiry(t *testing.T) {
+	Convey("Drain expiry", t, func() {
+		hostname := "grendel"
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: hostname,
+			Updated:  time.Now().UTC().Add(-time.Minute),
+			Status:   service.ALIVE,
+			Tags:     map[string]string{"env": "staging"},
+		}
+		state.AddServiceEntry(svc)
+
+		selector, err := service.ParseSelector("env=staging")
+		So(err, ShouldBeNil)
+
+		drain := func(ttl time.Duration) {
+			state.DrainLocalServices(selector, ttl)
+			state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+		}
+
+		expire := func() []service.Service {
+			state.Lock()
+			defer state.Unlock()
+			return state.expireDrains()
+		}
+
+		status := func() int {
+			return state.Servers[hostname].Services[svc.ID].Status
+		}
+
+		Convey("tombstones services once their drain expires", func() {
+			drain(time.Hour)
+			So(status(), ShouldEqual, service.DRAINING)
+			So(expire(), ShouldBeEmpty)
+
+			state.drainDeadlines[svc.ID] = time.Now().UTC().Add(-time.Second)
+			tombstones := expire()
+
+			So(len(tombstones), ShouldEqual, 2)
+			So(tombstones[0].ID, ShouldEqual, svc.ID)
+			So(status(), ShouldEqual, service.TOMBSTONE)
+			So(state.drainDeadlines, ShouldNotContainKey, svc.ID)
+
+			Convey("and keeps them tombstoned while discovery finds them", func() {
+				svc.Updated = time.Now().UTC()
+				state.AddServiceEntry(svc)
+				So(status(), ShouldEqual, service.TOMBSTONE)
+
+				state.forgetExpiredDrains([]service.Service{svc})
+				state.AddServiceEntry(svc)
+				So(status(), ShouldEqual, service.TOMBSTONE)
+			})
+
+			Convey("until discovery stops finding them", func() {
+				state.forgetExpiredDrains(nil)
+
+				svc.Updated = time.Now().UTC()
+				state.AddServiceEntry(svc)
+				So(status(), ShouldEqual, service.ALIVE)
+			})
+		})
+
+		Convey("never expires drains without a TTL", func() {
+			drain(0)
+			So(state.drainDeadlines, ShouldBeEmpty)
+			So(expire(), ShouldBeEmpty)
+			So(status(), ShouldEqual, service.DRAINING)
+		})
+
+		Convey("gives drains without a deadline the DrainTTL", func() {
+			drain(0)
+			state.DrainTTL = time.Hour
+
+			So(expire(), ShouldBeEmpty)
+			So(state.drainDeadlines, ShouldContainKey, svc.ID)
+			So(state.drainDeadlines[svc.ID], ShouldHappenAfter, time.Now().UTC().Add(59*time.Minute))
+		})
+
+		Convey("expires drains when a frozen clock passes the deadline", func() {
+			frozen := clock.NewFrozen(time.Now().UTC())
+			state.Clock = frozen
+
+			drain(time.Hour)
+			frozen.Advance(59 * time.Minute)
+			So(expire(), ShouldBeEmpty)
+
+			frozen.Advance(time.Minute)
+			So(len(expire()), ShouldEqual, 2)
+			So(status(), ShouldEqual, service.TOMBSTONE)
+			So(state.Servers[hostname].Services[svc.ID].Updated, ShouldEqual, frozen.Now())
+		})
+
+		Convey("forgets deadlines for services that went away", func() {
+			drain(time.Hour)
+			delete(state.Servers[hostname].Services, svc.ID)
+
+			expire()
+			So(state.drainDeadlines, ShouldBeEmpty)
+		})
+	})
+}
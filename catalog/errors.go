@@ -0,0 +1,15 @@
+package catalog
+
+import (
+	"errors"
+)
+
+// Errors that embedders can check for with errors.Is(). The errors returned
+// wrap them with the details.
+var (
+	ErrServiceNotFound  = errors.New("service not found")
+	ErrServerNotFound   = errors.New("server not found")
+	ErrListenerNotFound = errors.New("listener not found")
+	ErrListenerExists   = errors.New("listener already exists")
+	ErrInvalidListener  = errors.New("invalid listener")
+)
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"context"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+)
+
+const (
+	FRESHNESS_INTERVAL = 5 * time.Second // How often we look for checks that have gone stale
+)
+
+// IsServable tells whether consumers of the state, like the proxies, should
+// send traffic to a service: it must be ALIVE, and its last health check must
+// have run within CheckMaxAge. All the consumers use this so that they agree
+// about which instances are up. The check times from peers are on their
+// clocks, so they're compensated for the skew like the Updated times. Does
+// not lock the state.
+func (state *ServicesState) IsServable(svc *service.Service) bool {
+	if !svc.IsAlive() {
+		return false
+	}
+
+	// Rather than move the check time back by the skew, move now forward
+	now := state.now().Add(state.checkSkew(svc.Hostname))
+	return svc.CheckIsFresh(state.CheckMaxAge, now)
+}
+
+// checkSkew returns the clock skew for the host, as skewFor() does. Read-only
+// copies of the state carry the skews from when they were made. Note: not
+// synchronized!
+func (state *ServicesState) checkSkew(hostname string) time.Duration {
+	if state.checkSkews != nil {
+		return state.checkSkews[hostname]
+	}
+
+	return state.skewFor(hostname)
+}
+
+// TrackFreshness looks for ALIVE services whose last check has become too old
+// to serve, or fresh again, and tells the listeners about them. Nothing else
+// changes when a check goes stale, so without this the proxies would only
+// pull the service when something unrelated changed. Does nothing without a
+// CheckMaxAge.
+func (state *ServicesState) TrackFreshness(ctx context.Context, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
+	looper.Loop(func() error {
+		if state.CheckMaxAge <= 0 {
+			return nil
+		}
+
+		state.Lock()
+		defer state.Unlock()
+
+		seen := make(map[string]bool, len(state.staleChecks))
+		state.EachService(func(hostname *string, id *string, svc *service.Service) {
+			seen[freshnessKey(svc)] = true
+			if state.updateFreshness(svc) {
+				state.freshnessChanged(svc)
+			}
+		})
+
+		for key := range state.staleChecks {
+			if !seen[key] {
+				delete(state.staleChecks, key)
+			}
+		}
+
+		return nil
+	})
+}
+
+func freshnessKey(svc *service.Service) string {
+	return svc.Hostname + "/" + svc.ID
+}
+
+// updateFreshness remembers whether the service is ALIVE but has a stale
+// check, and returns true when that changed. Note: not synchronized!
+func (state *ServicesState) updateFreshness(svc *service.Service) bool {
+	stale := svc.IsAlive() && !state.IsServable(svc)
+	key := freshnessKey(svc)
+	if stale == state.staleChecks[key] {
+		return false
+	}
+
+	if !stale {
+		delete(state.staleChecks, key)
+		log.Infof("Last check on %s (%s) on %s is fresh again", svc.Name, svc.ID, svc.Hostname)
+		return true
+	}
+
+	if state.staleChecks == nil {
+		state.staleChecks = make(map[string]bool)
+	}
+	state.staleChecks[key] = true
+
+	log.Warnf("Last check on %s (%s) on %s is older than %s, not serving it",
+		svc.Name, svc.ID, svc.Hostname, state.CheckMaxAge,
+	)
+	metrics.IncrCounter([]string{"services_state", "stale_checks"}, 1)
+
+	return true
+}
+
+// freshnessChanged tells the listeners, and everything that watches the
+// version or LastChanged, that a service changed whether it can be served,
+// without changing its status. Note: not synchronized!
+func (state *ServicesState) freshnessChanged(svc *service.Service) {
+	now := state.now()
+	if server, ok := state.Servers[svc.Hostname]; ok {
+		server.LastChanged = now
+	}
+	state.LastChanged = now
+	state.bumpVersion(svc.Hostname)
+
+	state.NotifyListeners(svc, svc.Status, state.LastChanged)
+}
@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_IsServable(t *testing.T) {
+	Convey("IsServable()", t, func() {
+		state := NewServicesState()
+		frozen := clock.NewFrozen(time.Now().UTC())
+		state.Clock = frozen
+
+		svc := &service.Service{
+			ID: "deadbeef123", Name: "hrunting", Hostname: "beowulf", Status: service.ALIVE,
+			LastCheck: &service.CheckInfo{Time: frozen.Now().Add(-5 * time.Minute)},
+		}
+
+		Convey("only serves ALIVE services", func() {
+			So(state.IsServable(svc), ShouldBeTrue)
+
+			svc.Status = service.UNHEALTHY
+			So(state.IsServable(svc), ShouldBeFalse)
+		})
+
+		Convey("ignores the age of the last check without a CheckMaxAge", func() {
+			So(state.IsServable(svc), ShouldBeTrue)
+		})
+
+		Convey("doesn't serve services whose last check is too old", func() {
+			state.CheckMaxAge = 2 * time.Minute
+			So(state.IsServable(svc), ShouldBeFalse)
+
+			svc.LastCheck.Time = frozen.Now().Add(-time.Minute)
+			So(state.IsServable(svc), ShouldBeTrue)
+		})
+
+		Convey("compensates the check times from peers for their clock skew", func() {
+			state.CheckMaxAge = 2 * time.Minute
+			state.CompensateClockSkew = true
+
+			// The peer's clock is ten minutes behind ours, and it checked a minute ago
+			state.clockSkews["beowulf"] = &skewEstimate{
+				windowStart: frozen.Now(), current: -10 * time.Minute, hasCurrent: true,
+			}
+			svc.LastCheck.Time = frozen.Now().Add(-11 * time.Minute)
+			So(state.IsServable(svc), ShouldBeTrue)
+
+			state.Servers["beowulf"] = NewServer("beowulf")
+			So(state.SnapshotServices().IsServable(svc), ShouldBeTrue)
+
+			state.CompensateClockSkew = false
+			So(state.IsServable(svc), ShouldBeFalse)
+		})
+
+		Convey("serves services that don't report their checks", func() {
+			state.CheckMaxAge = 2 * time.Minute
+			svc.LastCheck = nil
+			So(state.IsServable(svc), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_TrackFreshness(t *testing.T) {
+	Convey("TrackFreshness()", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.CheckMaxAge = 2 * time.Minute
+		frozen := clock.NewFrozen(time.Now().UTC().Round(time.Second))
+		state.Clock = frozen
+
+		listener := &mockListener{name: "renderer", events: make(chan ChangeEvent, 10)}
+		state.AddListener(listener)
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "hrunting", Hostname: anotherHostname, Status: service.ALIVE,
+			Updated:   frozen.Now(),
+			LastCheck: &service.CheckInfo{Time: frozen.Now()},
+		}
+		state.AddServiceEntry(svc)
+		<-listener.events
+
+		track := func() {
+			state.TrackFreshness(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+		}
+
+		Convey("tells the listeners when a check goes stale", func() {
+			track()
+			So(len(listener.events), ShouldEqual, 0)
+
+			version := state.Version()
+			frozen.Advance(3 * time.Minute)
+			track()
+
+			So(len(listener.events), ShouldEqual, 1)
+			event := <-listener.events
+			So(event.Service.ID, ShouldEqual, svc.ID)
+			So(event.Service.Status, ShouldEqual, service.ALIVE)
+			So(state.Version(), ShouldBeGreaterThan, version)
+			So(state.LastChanged, ShouldEqual, frozen.Now())
+
+			// Only once
+			track()
+			So(len(listener.events), ShouldEqual, 0)
+
+			Convey("and when a new check makes it fresh again", func() {
+				svc.Updated = frozen.Now()
+				svc.LastCheck = &service.CheckInfo{Time: frozen.Now()}
+				state.AddServiceEntry(svc)
+
+				So(len(listener.events), ShouldEqual, 1)
+				So(state.staleChecks, ShouldBeEmpty)
+			})
+		})
+
+		Convey("forgets services that are gone", func() {
+			frozen.Advance(3 * time.Minute)
+			track()
+			So(state.staleChecks, ShouldNotBeEmpty)
+
+			delete(state.Servers, anotherHostname)
+			track()
+			So(state.staleChecks, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	// The service tag that declares what a service depends on: a comma
+	// separated list of service names or aliases
+	DependsOnTag = "depends_on"
+)
+
+// The formats WriteGraph can render
+const (
+	GraphDot = "dot" // Graphviz
+	GraphD2  = "d2"
+)
+
+// The colors services are filled with in the graphs, by status
+var graphColors = map[int]string{
+	service.ALIVE:     "#c8e6c9",
+	service.UNHEALTHY: "#ffcdd2",
+	service.UNKNOWN:   "#fff9c4",
+	service.DRAINING:  "#bbdefb",
+}
+
+const graphMissingColor = "#ef9a9a" // For dependencies that nothing provides
+
+// A graphHost is one host and the services on it, for rendering
+type graphHost struct {
+	Name     string
+	Services []*service.Service
+}
+
+// A graphEdge is a dependency of one service instance on a service
+type graphEdge struct {
+	From *service.Service
+	To   string
+}
+
+// WriteGraph renders the hosts and the services running on them, colored by
+// status, as a Graphviz DOT or D2 diagram. Tombstones are left out. With
+// dependencies, the services that declare what they depend on with the
+// DependsOnTag get an edge to each of those services, which are drawn
+// outside the hosts. Dependencies that have no instances are highlighted.
+// Handles locking the state.
+func (state *ServicesState) WriteGraph(output io.Writer, format string, dependencies bool) error {
+	state.RLock()
+	hosts, edges, provided := state.graphContents(dependencies)
+	clusterName := state.ClusterName
+	state.RUnlock()
+
+	switch format {
+	case GraphDot:
+		return writeDot(output, clusterName, hosts, edges, provided)
+	case GraphD2:
+		return writeD2(output, clusterName, hosts, edges, provided)
+	default:
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+}
+
+// graphContents collects what goes in the graph: the hosts sorted by name,
+// their services sorted by name and ID, the dependency edges, and which of
+// the dependencies have instances. Note: not synchronized!
+func (state *ServicesState) graphContents(dependencies bool) ([]graphHost, []graphEdge, map[string]bool) {
+	var hosts []graphHost
+	state.EachServer(func(hostname *string, server *Server) {
+		host := graphHost{Name: *hostname}
+		for _, svc := range server.Services {
+			if !svc.IsTombstone() {
+				host.Services = append(host.Services, svc)
+			}
+		}
+
+		sort.Slice(host.Services, func(i, j int) bool {
+			a, b := host.Services[i], host.Services[j]
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.ID < b.ID
+		})
+		hosts = append(hosts, host)
+	})
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+
+	if !dependencies {
+		return hosts, nil, nil
+	}
+
+	provided := make(map[string]bool)
+	for name, instances := range state.ByService() {
+		for _, svc := range instances {
+			if !svc.IsTombstone() {
+				provided[name] = true
+				break
+			}
+		}
+	}
+
+	var edges []graphEdge
+	for _, host := range hosts {
+		for _, svc := range host.Services {
+			for _, name := range strings.Split(svc.Tags[DependsOnTag], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					edges = append(edges, graphEdge{From: svc, To: name})
+				}
+			}
+		}
+	}
+
+	return hosts, edges, provided
+}
+
+// dependencyNames returns the services depended on, sorted
+func dependencyNames(edges []graphEdge) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, edge := range edges {
+		if !seen[edge.To] {
+			seen[edge.To] = true
+			names = append(names, edge.To)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// graphLabel is the text shown for a service instance, quoted
+func graphLabel(svc *service.Service) string {
+	lines := []string{svc.Name, svc.StatusString()}
+	if version := svc.Version(); version != "" {
+		lines = append(lines, version)
+	}
+
+	for i, line := range lines {
+		lines[i] = graphEscape(line)
+	}
+	return `"` + strings.Join(lines, `\n`) + `"`
+}
+
+// graphQuote quotes a string for either format, which escape the same way
+func graphQuote(str string) string {
+	return `"` + graphEscape(str) + `"`
+}
+
+func graphEscape(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(str)
+}
+
+func writeDot(output io.Writer, clusterName string, hosts []graphHost, edges []graphEdge, provided map[string]bool) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %s {\n", graphQuote(clusterName))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+
+	for i, host := range hosts {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", graphQuote(host.Name))
+		for _, svc := range host.Services {
+			fmt.Fprintf(&b, "    %s [label=%s, fillcolor=%s];\n",
+				graphQuote(svc.ID), graphLabel(svc), graphQuote(graphColors[svc.Status]),
+			)
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, name := range dependencyNames(edges) {
+		color := graphColors[service.ALIVE]
+		if !provided[name] {
+			color = graphMissingColor
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=ellipse, fillcolor=%s];\n",
+			graphQuote("service:"+name), graphQuote(name), graphQuote(color),
+		)
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", graphQuote(edge.From.ID), graphQuote("service:"+edge.To))
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(output, b.String())
+	return err
+}
+
+func writeD2(output io.Writer, clusterName string, hosts []graphHost, edges []graphEdge, provided map[string]bool) error {
+	var b strings.Builder
+
+	// D2 addresses nested shapes by their path, so the edges need the host
+	hostOf := make(map[*service.Service]string)
+
+	fmt.Fprintf(&b, "# Cluster %s\n", clusterName)
+	b.WriteString("direction: right\n")
+
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "%s: {\n", graphQuote(host.Name))
+		for _, svc := range host.Services {
+			hostOf[svc] = host.Name
+			fmt.Fprintf(&b, "  %s: %s {style.fill: %s}\n",
+				graphQuote(svc.ID), graphLabel(svc), graphQuote(graphColors[svc.Status]),
+			)
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, name := range dependencyNames(edges) {
+		color := graphColors[service.ALIVE]
+		if !provided[name] {
+			color = graphMissingColor
+		}
+		fmt.Fprintf(&b, "%s: %s {shape: oval; style.fill: %s}\n",
+			graphQuote("service:"+name), graphQuote(name), graphQuote(color),
+		)
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "%s.%s -> %s\n",
+			graphQuote(hostOf[edge.From]), graphQuote(edge.From.ID), graphQuote("service:"+edge.To),
+		)
+	}
+
+	_, err := io.WriteString(output, b.String())
+	return err
+}
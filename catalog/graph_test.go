@@ -0,0 +1,74 @@
+package catalog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_WriteGraph(t *testing.T) {
+	Convey("WriteGraph()", t, func() {
+		state := NewServicesState()
+		state.ClusterName = "default"
+		baseTime := time.Now().UTC().Round(time.Second)
+		ports := []service.Port{{Type: "tcp", Port: 1234}}
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "beowulf", Image: "beowulf:1.2", Hostname: hostname,
+			Updated: baseTime, Ports: ports,
+			Tags: map[string]string{DependsOnTag: "hrothgar, grendel"},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef105", Name: "hrothgar", Image: "hrothgar:7", Hostname: anotherHostname,
+			Updated: baseTime, Ports: ports, Status: service.UNHEALTHY,
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef999", Name: "wiglaf", Hostname: anotherHostname,
+			Updated: baseTime, Ports: ports, Status: service.TOMBSTONE,
+		})
+
+		var output bytes.Buffer
+
+		Convey("draws the hosts and their services in DOT", func() {
+			So(state.WriteGraph(&output, GraphDot, false), ShouldBeNil)
+
+			dot := output.String()
+			So(dot, ShouldStartWith, `digraph "default" {`)
+			So(dot, ShouldContainSubstring, `label="`+hostname+`";`)
+			So(dot, ShouldContainSubstring, `"deadbeef123" [label="beowulf\nAlive\n1.2", fillcolor="#c8e6c9"];`)
+			So(dot, ShouldContainSubstring, `"deadbeef105" [label="hrothgar\nUnhealthy\n7", fillcolor="#ffcdd2"];`)
+			So(dot, ShouldNotContainSubstring, "wiglaf")
+			So(dot, ShouldNotContainSubstring, "->")
+		})
+
+		Convey("draws the declared dependencies", func() {
+			So(state.WriteGraph(&output, GraphDot, true), ShouldBeNil)
+
+			dot := output.String()
+			So(dot, ShouldContainSubstring, `"deadbeef123" -> "service:hrothgar";`)
+			So(dot, ShouldContainSubstring, `"deadbeef123" -> "service:grendel";`)
+			So(dot, ShouldContainSubstring, `"service:grendel" [label="grendel", shape=ellipse, fillcolor="#ef9a9a"];`)
+			So(dot, ShouldContainSubstring, `"service:hrothgar" [label="hrothgar", shape=ellipse, fillcolor="#c8e6c9"];`)
+		})
+
+		Convey("draws D2, with edges from inside the hosts", func() {
+			So(state.WriteGraph(&output, GraphD2, true), ShouldBeNil)
+
+			d2 := output.String()
+			So(d2, ShouldContainSubstring, `"`+hostname+`": {`)
+			So(d2, ShouldContainSubstring, `  "deadbeef123": "beowulf\nAlive\n1.2" {style.fill: "#c8e6c9"}`)
+			So(d2, ShouldContainSubstring, `"`+hostname+`"."deadbeef123" -> "service:grendel"`)
+		})
+
+		Convey("escapes quotes", func() {
+			So(graphQuote(`say "hi"`), ShouldEqual, `"say \"hi\""`)
+		})
+
+		Convey("rejects unknown formats", func() {
+			So(state.WriteGraph(&output, "svg", false), ShouldNotBeNil)
+		})
+	})
+}
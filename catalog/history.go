@@ -0,0 +1,62 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	HISTORY_LENGTH = 20 // How many status transitions we keep for each service
+)
+
+// A StatusTransition records one change in the status of a service, when it
+// happened, and which host announced it.
+type StatusTransition struct {
+	Time           time.Time
+	Hostname       string
+	PreviousStatus int
+	Status         int
+}
+
+// ServiceHistory returns the most recent status transitions for the service
+// with this ID, oldest first. Note: not synchronized!
+func (state *ServicesState) ServiceHistory(id string) []StatusTransition {
+	transitions := state.history[id]
+	if len(transitions) == 0 {
+		return nil
+	}
+
+	result := make([]StatusTransition, len(transitions))
+	copy(result, transitions)
+
+	return result
+}
+
+// recordTransition adds a status change to the history for the service,
+// dropping the oldest entry when we already have HISTORY_LENGTH of them.
+// Note: not synchronized!
+func (state *ServicesState) recordTransition(svc *service.Service, previousStatus int, changed time.Time) {
+	if previousStatus == svc.Status {
+		return
+	}
+
+	transitions := append(state.history[svc.ID], StatusTransition{
+		Time:           changed,
+		Hostname:       svc.Hostname,
+		PreviousStatus: previousStatus,
+		Status:         svc.Status,
+	})
+
+	if len(transitions) > HISTORY_LENGTH {
+		transitions = append(transitions[:0], transitions[len(transitions)-HISTORY_LENGTH:]...)
+	}
+
+	state.history[svc.ID] = transitions
+}
+
+// forgetHistory throws away the history for a service we no longer know
+// about. Note: not synchronized!
+func (state *ServicesState) forgetHistory(id string) {
+	delete(state.history, id)
+}
@@ -0,0 +1,91 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServiceHistory(t *testing.T) {
+	Convey("Tracking the history of a service", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(-1 * time.Hour)
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: anotherHostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+			Ports:    []service.Port{{Type: "tcp", Port: 1234}},
+		}
+
+		// flip sends the service to a new status one second after the last
+		flip := func(status int) {
+			svc.Status = status
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+		}
+
+		Convey("is empty for services we don't know", func() {
+			So(state.ServiceHistory("unknown"), ShouldBeEmpty)
+		})
+
+		Convey("records each change of status", func() {
+			state.AddServiceEntry(svc)
+			flip(service.UNHEALTHY)
+
+			history := state.ServiceHistory(svc.ID)
+			So(len(history), ShouldEqual, 2)
+			So(history[0].PreviousStatus, ShouldEqual, service.UNKNOWN)
+			So(history[0].Status, ShouldEqual, service.ALIVE)
+			So(history[0].Time, ShouldEqual, baseTime)
+			So(history[1].PreviousStatus, ShouldEqual, service.ALIVE)
+			So(history[1].Status, ShouldEqual, service.UNHEALTHY)
+			So(history[1].Hostname, ShouldEqual, anotherHostname)
+		})
+
+		Convey("ignores updates that don't change the status", func() {
+			state.AddServiceEntry(svc)
+			flip(service.ALIVE)
+
+			So(len(state.ServiceHistory(svc.ID)), ShouldEqual, 1)
+		})
+
+		Convey("only keeps the most recent transitions", func() {
+			state.AddServiceEntry(svc)
+			for i := 0; i < HISTORY_LENGTH; i++ {
+				flip(service.UNHEALTHY)
+				flip(service.ALIVE)
+			}
+
+			history := state.ServiceHistory(svc.ID)
+			So(len(history), ShouldEqual, HISTORY_LENGTH)
+			So(history[len(history)-1].Status, ShouldEqual, service.ALIVE)
+			So(history[len(history)-1].Time, ShouldEqual, svc.Updated)
+		})
+
+		Convey("returns a copy", func() {
+			state.AddServiceEntry(svc)
+			history := state.ServiceHistory(svc.ID)
+			history[0].Status = service.TOMBSTONE
+
+			So(state.ServiceHistory(svc.ID)[0].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("is forgotten along with expired tombstones", func() {
+			svc.Updated = time.Now().UTC().Add(-TOMBSTONE_LIFESPAN - time.Minute)
+			svc.Status = service.TOMBSTONE
+			state.Servers[anotherHostname] = NewServer(anotherHostname)
+			state.Servers[anotherHostname].Services[svc.ID] = &svc
+			state.recordTransition(&svc, service.ALIVE, svc.Updated)
+
+			state.TombstoneOthersServices()
+
+			So(state.ServiceHistory(svc.ID), ShouldBeEmpty)
+		})
+	})
+}
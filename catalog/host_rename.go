@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NinesStack/sidecar/service"
+	director "github.com/relistan/go-director"
+)
+
+// A HostRename is a pair of servers that look like the same host under two
+// names, because they are announcing services with the same IDs. This
+// happens when a host is renamed, or its hostname flaps between the FQDN
+// and the short form. From is the name we heard from least recently.
+type HostRename struct {
+	From       string
+	To         string
+	ServiceIDs []string
+}
+
+// DetectHostRenames returns all the pairs of servers that are announcing the
+// same live services. Handles locking the state.
+func (state *ServicesState) DetectHostRenames() []HostRename {
+	state.RLock()
+	defer state.RUnlock()
+
+	hostsByID := make(map[string][]string)
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.IsTombstone() {
+			return
+		}
+		hostsByID[*id] = append(hostsByID[*id], *hostname)
+	})
+
+	renames := make(map[[2]string]*HostRename)
+	for id, hostnames := range hostsByID {
+		sort.Strings(hostnames)
+		for i := 0; i < len(hostnames); i++ {
+			for j := i + 1; j < len(hostnames); j++ {
+				from, to := hostnames[i], hostnames[j]
+				if state.Servers[to].LastUpdated.Before(state.Servers[from].LastUpdated) {
+					from, to = to, from
+				}
+
+				key := [2]string{from, to}
+				if _, ok := renames[key]; !ok {
+					renames[key] = &HostRename{From: from, To: to}
+				}
+				renames[key].ServiceIDs = append(renames[key].ServiceIDs, id)
+			}
+		}
+	}
+
+	result := make([]HostRename, 0, len(renames))
+	for _, rename := range renames {
+		sort.Strings(rename.ServiceIDs)
+		result = append(result, *rename)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].From == result[j].From {
+			return result[i].To < result[j].To
+		}
+		return result[i].From < result[j].From
+	})
+
+	return result
+}
+
+// MigrateServer moves the services announced under one hostname over to
+// another. Services that the new server doesn't have yet are copied over,
+// then all of the old records are tombstoned and the tombstones are
+// announced to the cluster. Returns the number of services copied. We
+// refuse to migrate our own services, since we'd just announce them again.
+func (state *ServicesState) MigrateServer(from string, to string) (int, error) {
+	state.Lock()
+	defer state.Unlock()
+
+	if from == "" || to == "" || from == to {
+		return 0, fmt.Errorf("can't migrate from %q to %q", from, to)
+	}
+
+	if from == state.Hostname {
+		return 0, fmt.Errorf("can't migrate services away from this host (%s)", from)
+	}
+
+	if !state.HasServer(from) {
+		return 0, fmt.Errorf("no such server %q: %w", from, ErrServerNotFound)
+	}
+
+	if !state.HasServer(to) {
+		state.Servers[to] = NewServer(to)
+	}
+
+	now := state.now()
+	copied := 0
+	var tombstones []service.Service
+
+	for id, svc := range state.Servers[from].Services {
+		if svc.IsTombstone() {
+			continue
+		}
+
+		if !state.Servers[to].HasService(id) {
+			newSvc := *svc
+			newSvc.Hostname = to
+			newSvc.Updated = now
+			state.strings.internService(&newSvc)
+			state.Servers[to].Services[id] = &newSvc
+			state.ServiceChanged(&newSvc, service.UNKNOWN, now)
+			copied++
+		}
+
+		previousStatus := svc.Status
+		svc.TombstoneAt(now)
+		state.ServiceChanged(svc, previousStatus, svc.Updated)
+		tombstones = append(tombstones, *svc)
+	}
+
+	log.Warnf("Migrated %d services from %s to %s, tombstoned %d",
+		copied, from, to, len(tombstones),
+	)
+
+	if len(tombstones) > 0 {
+		state.SendServices(
+			tombstones,
+			director.NewTimedLooper(state.Retransmits().Tombstone, state.tombstoneRetransmit, nil),
+		)
+	}
+
+	return copied, nil
+}
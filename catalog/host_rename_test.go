@@ -0,0 +1,66 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HostRenames(t *testing.T) {
+	Convey("When a host shows up under two names", t, func() {
+		state := NewServicesState()
+		state.Hostname = anotherHostname
+		baseTime := time.Now().UTC().Round(time.Second)
+		ports := []service.Port{{Type: "tcp", Port: 1234}}
+
+		oldName := hostname + ".example.com"
+
+		svc1 := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: oldName, Updated: baseTime, Ports: ports}
+		svc2 := service.Service{ID: "deadbeef101", Name: "grendel", Hostname: oldName, Updated: baseTime, Ports: ports}
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		svc1.Hostname = hostname
+		svc1.Updated = baseTime.Add(time.Second)
+		state.AddServiceEntry(svc1)
+
+		Convey("detects the services they share", func() {
+			renames := state.DetectHostRenames()
+
+			So(len(renames), ShouldEqual, 1)
+			So(renames[0].From, ShouldEqual, oldName)
+			So(renames[0].To, ShouldEqual, hostname)
+			So(renames[0].ServiceIDs, ShouldResemble, []string{svc1.ID})
+		})
+
+		Convey("ignores tombstones", func() {
+			state.Servers[oldName].Services[svc1.ID].Tombstone()
+
+			So(state.DetectHostRenames(), ShouldBeEmpty)
+		})
+
+		Convey("migrates the old server to the new name", func() {
+			copied, err := state.MigrateServer(oldName, hostname)
+
+			So(err, ShouldBeNil)
+			So(copied, ShouldEqual, 1)
+			So(state.Servers[hostname].HasService(svc2.ID), ShouldBeTrue)
+			So(state.Servers[hostname].Services[svc2.ID].Hostname, ShouldEqual, hostname)
+			So(state.Servers[oldName].Services[svc1.ID].IsTombstone(), ShouldBeTrue)
+			So(state.Servers[oldName].Services[svc2.ID].IsTombstone(), ShouldBeTrue)
+			So(state.DetectHostRenames(), ShouldBeEmpty)
+		})
+
+		Convey("refuses to migrate our own services", func() {
+			_, err := state.MigrateServer(anotherHostname, hostname)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("refuses to migrate a server we don't know", func() {
+			_, err := state.MigrateServer("marlowe", hostname)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,201 @@
+package catalog
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	HEAP_STATS_INTERVAL = 10 * time.Second // How long we reuse the heap figures, since reading them stops the world
+)
+
+// A stringPool hands back one shared copy of each string it has seen. Every
+// record we decode from gossip comes with its own copies of the name, image,
+// hostname and so on, which adds up to a lot of duplicates in big clusters.
+// Interning them when they go into the state means we only keep one of each.
+// Note: not synchronized! It's protected by the state's lock.
+type stringPool struct {
+	strings map[string]string
+	bytes   int
+}
+
+// intern returns the pooled copy of s, adding it if we haven't seen it
+func (p *stringPool) intern(s string) string {
+	if s == "" {
+		return s
+	}
+
+	if p.strings == nil {
+		p.strings = make(map[string]string)
+	}
+
+	if pooled, ok := p.strings[s]; ok {
+		return pooled
+	}
+
+	p.strings[s] = s
+	p.bytes += len(s)
+	return s
+}
+
+// internService swaps the strings in a service for the pooled copies. The
+// slices and maps are replaced rather than changed in place, because the
+// caller may still share them with the record it was handed.
+func (p *stringPool) internService(svc *service.Service) {
+	svc.Name = p.intern(svc.Name)
+	svc.Image = p.intern(svc.Image)
+	svc.Hostname = p.intern(svc.Hostname)
+	svc.ProxyMode = p.intern(svc.ProxyMode)
+	svc.TLSCert = p.intern(svc.TLSCert)
+	svc.Source = p.intern(svc.Source)
+
+	if svc.Ports != nil {
+		ports := make([]service.Port, len(svc.Ports))
+		for i, port := range svc.Ports {
+			port.Type = p.intern(port.Type)
+			port.IP = p.intern(port.IP)
+			port.ProxyMode = p.intern(port.ProxyMode)
+			ports[i] = port
+		}
+		svc.Ports = ports
+	}
+
+	if svc.Tags != nil {
+		tags := make(map[string]string, len(svc.Tags))
+		for key, value := range svc.Tags {
+			tags[p.intern(key)] = p.intern(value)
+		}
+		svc.Tags = tags
+	}
+
+	svc.Aliases = p.internList(svc.Aliases)
+	svc.ListenOn = p.internList(svc.ListenOn)
+}
+
+func (p *stringPool) internList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+
+	interned := make([]string, len(list))
+	for i, s := range list {
+		interned[i] = p.intern(s)
+	}
+	return interned
+}
+
+// compactStrings throws away the pool and rebuilds it from the services that
+// are still in the state, so that strings from expired services don't stay
+// around forever. The services already hold the pooled copies, so they are
+// left alone. Note: not synchronized!
+func (state *ServicesState) compactStrings() {
+	state.strings = stringPool{}
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		eachString(svc, func(s string) { state.strings.intern(s) })
+	})
+
+	metrics.SetGauge([]string{"services_state", "interned_strings"}, float32(len(state.strings.strings)))
+}
+
+// A MemoryReport describes how much memory the catalog is using
+type MemoryReport struct {
+	Services        int    // Including tombstones
+	InternedStrings int    // Distinct strings shared between the services
+	InternedBytes   int    // How much space those strings take up
+	SavedBytes      int    // How much more they would take without interning
+	HeapAlloc       uint64 // Bytes allocated on the heap by the whole process
+	HeapInuse       uint64
+	HeapObjects     uint64
+}
+
+// heapStats keeps the last memory stats from the Go runtime, so that every
+// request for a MemoryReport doesn't stop the world to read them
+type heapStats struct {
+	stats runtime.MemStats
+	read  time.Time // Zero until we've read them
+	sync.Mutex
+}
+
+// get returns the memory stats, reading them again when they're more than
+// HEAP_STATS_INTERVAL older than now
+func (h *heapStats) get(now time.Time) runtime.MemStats {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.read.IsZero() || now.Sub(h.read) >= HEAP_STATS_INTERVAL || now.Before(h.read) {
+		runtime.ReadMemStats(&h.stats)
+		h.read = now
+	}
+
+	return h.stats
+}
+
+// MemoryReport works out the MemoryReport for the state. The heap figures
+// are for the whole process, as reported by the Go runtime, and may be up to
+// HEAP_STATS_INTERVAL old. Handles locking the state.
+func (state *ServicesState) MemoryReport() MemoryReport {
+	state.RLock()
+	report := MemoryReport{
+		InternedStrings: len(state.strings.strings),
+		InternedBytes:   state.strings.bytes,
+	}
+
+	var total int
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		report.Services++
+		total += internedBytes(svc)
+	})
+	state.RUnlock()
+
+	if total > report.InternedBytes {
+		report.SavedBytes = total - report.InternedBytes
+	}
+
+	stats := state.heapStats.get(state.now())
+	report.HeapAlloc = stats.HeapAlloc
+	report.HeapInuse = stats.HeapInuse
+	report.HeapObjects = stats.HeapObjects
+
+	return report
+}
+
+// internedBytes adds up the length of the strings in a service that we
+// intern, i.e. what it would hold on its own
+func internedBytes(svc *service.Service) int {
+	var total int
+	eachString(svc, func(s string) { total += len(s) })
+	return total
+}
+
+// eachString calls fn with each of the strings in a service that we intern
+func eachString(svc *service.Service, fn func(string)) {
+	fn(svc.Name)
+	fn(svc.Image)
+	fn(svc.Hostname)
+	fn(svc.ProxyMode)
+	fn(svc.TLSCert)
+	fn(svc.Source)
+
+	for _, port := range svc.Ports {
+		fn(port.Type)
+		fn(port.IP)
+		fn(port.ProxyMode)
+	}
+
+	for key, value := range svc.Tags {
+		fn(key)
+		fn(value)
+	}
+
+	for _, s := range svc.Aliases {
+		fn(s)
+	}
+
+	for _, s := range svc.ListenOn {
+		fn(s)
+	}
+}
@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sameString tells whether two strings share their storage
+func sameString(a, b string) bool {
+	headerA := (*reflect.StringHeader)(unsafe.Pointer(&a))
+	headerB := (*reflect.StringHeader)(unsafe.Pointer(&b))
+	return headerA.Len == headerB.Len && headerA.Data == headerB.Data
+}
+
+func Test_StringInterning(t *testing.T) {
+	Convey("Interning the strings in the state", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		// Build each record from fresh copies, like the decoder would
+		newService := func(id string) service.Service {
+			return service.Service{
+				ID: id, Name: string([]byte("beowulf")), Image: string([]byte("beowulf:1.2.3")),
+				Hostname: string([]byte(anotherHostname)), Updated: baseTime, Status: service.ALIVE,
+				Ports: []service.Port{{Type: string([]byte("tcp")), Port: 1234, ServicePort: 8080}},
+				Tags:  map[string]string{string([]byte("team")): string([]byte("geats"))},
+			}
+		}
+
+		svc1 := newService("deadbeef123")
+		svc2 := newService("deadbeef456")
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		stored1 := state.Servers[anotherHostname].Services["deadbeef123"]
+		stored2 := state.Servers[anotherHostname].Services["deadbeef456"]
+
+		Convey("shares the strings between records", func() {
+			So(sameString(stored1.Name, stored2.Name), ShouldBeTrue)
+			So(sameString(stored1.Image, stored2.Image), ShouldBeTrue)
+			So(sameString(stored1.Hostname, stored2.Hostname), ShouldBeTrue)
+			So(sameString(stored1.Ports[0].Type, stored2.Ports[0].Type), ShouldBeTrue)
+			So(sameString(stored1.Tags["team"], stored2.Tags["team"]), ShouldBeTrue)
+		})
+
+		Convey("doesn't change the records that were passed in", func() {
+			So(&stored2.Ports[0], ShouldNotEqual, &svc2.Ports[0])
+			So(sameString(stored2.Image, svc2.Image), ShouldBeFalse)
+			So(stored2.Image, ShouldEqual, svc2.Image)
+		})
+
+		Convey("reports how much it saved", func() {
+			report := state.MemoryReport()
+
+			So(report.Services, ShouldEqual, 2)
+			So(report.InternedStrings, ShouldEqual, 6)
+			So(report.InternedBytes, ShouldEqual, internedBytes(stored1))
+			So(report.SavedBytes, ShouldEqual, internedBytes(stored2))
+			So(report.HeapAlloc, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("reuses the heap figures for a while", func() {
+			frozen := clock.NewFrozen(baseTime)
+			state.Clock = frozen
+
+			first := state.MemoryReport()
+			So(state.heapStats.read, ShouldEqual, baseTime)
+
+			frozen.Advance(HEAP_STATS_INTERVAL - time.Second)
+			So(state.MemoryReport().HeapObjects, ShouldEqual, first.HeapObjects)
+			So(state.heapStats.read, ShouldEqual, baseTime)
+
+			frozen.Advance(time.Second)
+			state.MemoryReport()
+			So(state.heapStats.read, ShouldEqual, baseTime.Add(HEAP_STATS_INTERVAL))
+		})
+
+		Convey("drops strings once the services have expired", func() {
+			svc3 := newService("deadbeef789")
+			svc3.Image = "grendel:6.6.6"
+			svc3.Status = service.TOMBSTONE
+			svc3.Updated = baseTime.Add(-TOMBSTONE_LIFESPAN + time.Second)
+			state.AddServiceEntry(svc3)
+			So(state.strings.strings, ShouldContainKey, "grendel:6.6.6")
+
+			state.Servers[anotherHostname].Services["deadbeef789"].Updated =
+				baseTime.Add(-TOMBSTONE_LIFESPAN - time.Second)
+			state.TombstoneOthersServices()
+
+			So(state.strings.strings, ShouldNotContainKey, "grendel:6.6.6")
+			So(state.strings.strings, ShouldContainKey, "beowulf:1.2.3")
+			So(state.MemoryReport().InternedStrings, ShouldEqual, 6)
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	LEADER_CHECK_INTERVAL = 5 * time.Second // How often a LeaderListener looks for a change of leader
+)
+
+// Leader returns the name of the node that runs the singleton tasks for the
+// cluster. It's the first of the live members by name, so every node works
+// out the same answer from the membership without talking to the others.
+// When a leader dies, Memberlist drops it from the members and the next node
+// takes over. While the membership is settling, e.g. during a partition, two
+// nodes can briefly both think they lead, so singleton tasks must tolerate
+// running twice. Without Members, we're on our own and lead.
+func (state *ServicesState) Leader() string {
+	if state.Members == nil {
+		return state.Hostname
+	}
+
+	members := append(state.Members(), state.Hostname)
+	sort.Strings(members)
+
+	return members[0]
+}
+
+// IsLeader tells whether this node is the leader. See Leader().
+func (state *ServicesState) IsLeader() bool {
+	return state.Leader() == state.Hostname
+}
+
+// A LeaderListener wraps a Listener so that it only gets events while this
+// node is the leader. The events that arrive while we're not are dropped.
+// When we take over, the wrapped listener is sent an event for every ALIVE
+// and DRAINING service, as though it had just been added with WithReplay(),
+// so it catches up with what it missed. The wrapped listener must already be
+// consuming from its channel, and must not be added to the state itself.
+type LeaderListener struct {
+	Listener
+	CheckInterval time.Duration
+
+	eventChannel chan ChangeEvent
+	leading      bool
+	quit         chan struct{}
+	stopOnce     sync.Once
+}
+
+func NewLeaderListener(listener Listener) *LeaderListener {
+	return &LeaderListener{
+		Listener:      listener,
+		CheckInterval: LEADER_CHECK_INTERVAL,
+		eventChannel:  make(chan ChangeEvent, LISTENER_EVENT_BUFFER_SIZE),
+		quit:          make(chan struct{}),
+	}
+}
+
+// Chan is part of the Listener interface. The state sends us the events,
+// and we pass them on to the wrapped listener.
+func (l *LeaderListener) Chan() chan ChangeEvent {
+	return l.eventChannel
+}
+
+// Watch adds the listener to the state and starts passing on events until
+// Stop() is called
+func (l *LeaderListener) Watch(state *ServicesState) {
+	state.AddListener(l)
+
+	go func() {
+		ticker := time.NewTicker(l.CheckInterval)
+		defer ticker.Stop()
+
+		l.checkLeader(state)
+
+		for {
+			select {
+			case event := <-l.eventChannel:
+				if l.checkLeader(state) {
+					l.Listener.Chan() <- event
+				}
+			case <-ticker.C:
+				l.checkLeader(state)
+			case <-l.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops passing on events. The listener stays on the state until it's
+// removed.
+func (l *LeaderListener) Stop() {
+	l.stopOnce.Do(func() { close(l.quit) })
+}
+
+// checkLeader notices when we take over or lose the leadership, and catches
+// the wrapped listener up when we take over. Returns whether we lead.
+func (l *LeaderListener) checkLeader(state *ServicesState) bool {
+	leading := state.IsLeader()
+
+	if leading {
+		metrics.SetGauge([]string{"listeners", "leader"}, 1)
+	} else {
+		metrics.SetGauge([]string{"listeners", "leader"}, 0)
+	}
+
+	if leading == l.leading {
+		return leading
+	}
+	l.leading = leading
+
+	if !leading {
+		log.Infof("No longer the leader (%s is), pausing %s", state.Leader(), l.Name())
+		// The leader is the one passing on the changes now
+		state.ForgetListenerAcks(l.Name())
+		return false
+	}
+
+	log.Infof("Now the leader, activating %s", l.Name())
+	metrics.IncrCounter([]string{"listeners", "leader_changes"}, 1)
+
+	state.RLock()
+	events := state.replayEvents()
+	state.RUnlock()
+	state.replayToListener(l.Listener, events)
+
+	return true
+}
@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Leader(t *testing.T) {
+	Convey("Leader()", t, func() {
+		state := NewServicesState()
+		state.Hostname = anotherHostname
+
+		Convey("is us when we're on our own", func() {
+			So(state.Leader(), ShouldEqual, anotherHostname)
+			So(state.IsLeader(), ShouldBeTrue)
+		})
+
+		Convey("is the first member by name", func() {
+			state.Members = func() []string { return []string{"zola", anotherHostname, "austen"} }
+
+			So(state.Leader(), ShouldEqual, "austen")
+			So(state.IsLeader(), ShouldBeFalse)
+		})
+
+		Convey("counts us even before we're in the members", func() {
+			state.Members = func() []string { return []string{"zola"} }
+
+			So(state.Leader(), ShouldEqual, anotherHostname)
+			So(state.IsLeader(), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_LeaderListener(t *testing.T) {
+	Convey("LeaderListener", t, func() {
+		state := NewServicesState()
+		state.Hostname = anotherHostname
+		baseTime := time.Now().UTC()
+		ports := []service.Port{{Type: "tcp", Port: 1234}}
+
+		var lock sync.Mutex
+		members := []string{"austen"}
+		state.Members = func() []string {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]string{}, members...)
+		}
+		setMembers := func(names ...string) {
+			lock.Lock()
+			defer lock.Unlock()
+			members = names
+		}
+
+		svc1 := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: hostname, Updated: baseTime, Ports: ports}
+		state.AddServiceEntry(svc1)
+
+		inner := &mockListener{"listener1", make(chan ChangeEvent, 10), false}
+		listener := NewLeaderListener(inner)
+		listener.CheckInterval = 5 * time.Millisecond
+		listener.Watch(state)
+		Reset(func() { listener.Stop() })
+
+		receive := func() *ChangeEvent {
+			select {
+			case event := <-inner.Chan():
+				return &event
+			case <-time.After(200 * time.Millisecond):
+				return nil
+			}
+		}
+
+		Convey("uses the name of the listener it wraps", func() {
+			So(listener.Name(), ShouldEqual, "listener1")
+			So(state.GetListeners(), ShouldContain, listener)
+		})
+
+		Convey("drops events when we're not the leader", func() {
+			svc2 := service.Service{ID: "deadbeef456", Name: "grendel", Hostname: hostname, Updated: baseTime, Ports: ports}
+			state.AddServiceEntry(svc2)
+
+			So(receive(), ShouldBeNil)
+		})
+
+		Convey("catches up when we take over, then passes events on", func() {
+			setMembers("zola")
+
+			event := receive()
+			So(event, ShouldNotBeNil)
+			So(event.Service.ID, ShouldEqual, svc1.ID)
+			So(event.PreviousStatus, ShouldEqual, service.UNKNOWN)
+
+			svc2 := service.Service{ID: "deadbeef456", Name: "grendel", Hostname: hostname, Updated: baseTime, Ports: ports}
+			state.AddServiceEntry(svc2)
+
+			event = receive()
+			So(event, ShouldNotBeNil)
+			So(event.Service.ID, ShouldEqual, svc2.ID)
+
+			Convey("and stops again when someone else does", func() {
+				setMembers("austen")
+				time.Sleep(20 * time.Millisecond)
+
+				svc3 := service.Service{ID: "deadbeef789", Name: "hrothgar", Hostname: hostname, Updated: baseTime, Ports: ports}
+				state.AddServiceEntry(svc3)
+
+				So(receive(), ShouldBeNil)
+			})
+		})
+	})
+}
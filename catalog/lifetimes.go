@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"sort"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	LIFETIME_SAMPLES = 100 // How many of the most recent instances we keep timings for, per service name
+)
+
+// DurationStats summarizes a set of durations
+type DurationStats struct {
+	Count  int
+	Mean   time.Duration
+	Median time.Duration
+	P90    time.Duration
+	Max    time.Duration
+}
+
+// ServiceLifetimes describes how long the recent instances of a service took
+// to start, and how long they lived
+type ServiceLifetimes struct {
+	Startup  DurationStats // From when we first saw an instance until it was ALIVE
+	Lifetime DurationStats // From when an instance was Created until it was tombstoned
+}
+
+// lifetimeSamples are the most recent timings for the instances of a service
+type lifetimeSamples struct {
+	startups  []time.Duration
+	lifetimes []time.Duration
+}
+
+// recordLifetime keeps track of how long instances take to come up and how
+// long they live, from their status changes. We can only time startups for
+// instances we saw before they were ALIVE. Timings for our own services are
+// also sent as metrics, so that each one is only counted once across the
+// cluster. Must be called before the transition is added to the history.
+// Note: not synchronized!
+func (state *ServicesState) recordLifetime(svc *service.Service, previousStatus int, changed time.Time) {
+	if state.firstSeen == nil {
+		state.firstSeen = make(map[string]time.Time)
+	}
+	if state.lifetimes == nil {
+		state.lifetimes = make(map[string]*lifetimeSamples)
+	}
+
+	// Without any history, this is the first we've heard of it. New services
+	// come in as changes from UNKNOWN, even when they are UNKNOWN.
+	_, hasHistory := state.history[svc.ID]
+	if !hasHistory && svc.Status != service.ALIVE && svc.Status != service.TOMBSTONE {
+		if _, ok := state.firstSeen[svc.ID]; !ok {
+			state.firstSeen[svc.ID] = changed
+		}
+	}
+
+	if previousStatus == svc.Status {
+		return
+	}
+
+	switch svc.Status {
+	case service.ALIVE:
+		seen, ok := state.firstSeen[svc.ID]
+		if !ok {
+			return
+		}
+		delete(state.firstSeen, svc.ID)
+
+		startup := changed.Sub(seen)
+		state.samplesFor(svc.Name).addStartup(startup)
+		if svc.Hostname == state.Hostname {
+			metrics.AddSampleWithLabels(
+				[]string{"services_state", "startup_seconds"}, float32(startup.Seconds()),
+				append(state.metricLabels(), metrics.Label{Name: "service", Value: svc.Name}),
+			)
+		}
+
+	case service.TOMBSTONE:
+		delete(state.firstSeen, svc.ID)
+		if svc.Created.IsZero() || changed.Before(svc.Created) {
+			return
+		}
+
+		lifetime := changed.Sub(svc.Created)
+		state.samplesFor(svc.Name).addLifetime(lifetime)
+		if svc.Hostname == state.Hostname {
+			metrics.AddSampleWithLabels(
+				[]string{"services_state", "lifetime_seconds"}, float32(lifetime.Seconds()),
+				append(state.metricLabels(), metrics.Label{Name: "service", Value: svc.Name}),
+			)
+		}
+	}
+}
+
+// forgetLifetime stops waiting for a service we no longer know about to come
+// up. Note: not synchronized!
+func (state *ServicesState) forgetLifetime(id string) {
+	delete(state.firstSeen, id)
+}
+
+// samplesFor returns the timings for a service name. Note: not synchronized!
+func (state *ServicesState) samplesFor(name string) *lifetimeSamples {
+	samples, ok := state.lifetimes[name]
+	if !ok {
+		samples = &lifetimeSamples{}
+		state.lifetimes[name] = samples
+	}
+	return samples
+}
+
+// Lifetimes returns startup and lifetime statistics for each service name,
+// over its most recent instances. Services we haven't timed any instances of
+// are left out. Handles locking the state.
+func (state *ServicesState) Lifetimes() map[string]ServiceLifetimes {
+	state.RLock()
+	defer state.RUnlock()
+
+	result := make(map[string]ServiceLifetimes, len(state.lifetimes))
+	for name, samples := range state.lifetimes {
+		result[name] = ServiceLifetimes{
+			Startup:  summarizeDurations(samples.startups),
+			Lifetime: summarizeDurations(samples.lifetimes),
+		}
+	}
+
+	return result
+}
+
+func (s *lifetimeSamples) addStartup(duration time.Duration) {
+	s.startups = appendSample(s.startups, duration)
+}
+
+func (s *lifetimeSamples) addLifetime(duration time.Duration) {
+	s.lifetimes = appendSample(s.lifetimes, duration)
+}
+
+// appendSample adds a sample, dropping the oldest beyond LIFETIME_SAMPLES
+func appendSample(samples []time.Duration, duration time.Duration) []time.Duration {
+	samples = append(samples, duration)
+	if len(samples) > LIFETIME_SAMPLES {
+		samples = append(samples[:0], samples[len(samples)-LIFETIME_SAMPLES:]...)
+	}
+	return samples
+}
+
+// summarizeDurations works out the statistics for a set of samples
+func summarizeDurations(samples []time.Duration) DurationStats {
+	if len(samples) == 0 {
+		return DurationStats{}
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+
+	return DurationStats{
+		Count:  len(sorted),
+		Mean:   total / time.Duration(len(sorted)),
+		Median: sorted[len(sorted)/2],
+		P90:    sorted[(len(sorted)*9)/10],
+		Max:    sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Lifetimes(t *testing.T) {
+	Convey("Lifetimes()", t, func() {
+		state := NewServicesState()
+		state.Hostname = "chaucer"
+		baseTime := time.Now().UTC().Add(-5 * time.Minute)
+
+		instance := func(id string, status int, updated time.Time) service.Service {
+			return service.Service{
+				ID: id, Name: "bocaccio", Hostname: "chaucer", Status: status,
+				Created: baseTime.Add(-time.Minute), Updated: updated,
+				Ports: []service.Port{{Type: "tcp", Port: 1234}},
+			}
+		}
+
+		Convey("times instances from when we first saw them until they're ALIVE", func() {
+			state.AddServiceEntry(instance("deadbeef001", service.UNKNOWN, baseTime))
+			state.AddServiceEntry(instance("deadbeef001", service.UNHEALTHY, baseTime.Add(2*time.Second)))
+			state.AddServiceEntry(instance("deadbeef001", service.ALIVE, baseTime.Add(10*time.Second)))
+
+			state.AddServiceEntry(instance("deadbeef002", service.UNKNOWN, baseTime))
+			state.AddServiceEntry(instance("deadbeef002", service.ALIVE, baseTime.Add(20*time.Second)))
+
+			startup := state.Lifetimes()["bocaccio"].Startup
+			So(startup.Count, ShouldEqual, 2)
+			So(startup.Mean, ShouldEqual, 15*time.Second)
+			So(startup.Max, ShouldEqual, 20*time.Second)
+			So(state.firstSeen, ShouldBeEmpty)
+		})
+
+		Convey("doesn't time startups for instances that were already ALIVE", func() {
+			state.AddServiceEntry(instance("deadbeef001", service.ALIVE, baseTime))
+			state.AddServiceEntry(instance("deadbeef001", service.UNHEALTHY, baseTime.Add(time.Second)))
+			state.AddServiceEntry(instance("deadbeef001", service.ALIVE, baseTime.Add(2*time.Second)))
+
+			So(state.Lifetimes()["bocaccio"].Startup.Count, ShouldEqual, 0)
+		})
+
+		Convey("times instances from when they were created until they're tombstoned", func() {
+			state.AddServiceEntry(instance("deadbeef001", service.ALIVE, baseTime))
+			state.AddServiceEntry(instance("deadbeef001", service.TOMBSTONE, baseTime.Add(time.Minute)))
+
+			lifetime := state.Lifetimes()["bocaccio"].Lifetime
+			So(lifetime.Count, ShouldEqual, 1)
+			So(lifetime.Max, ShouldEqual, 2*time.Minute)
+		})
+
+		Convey("keeps only the most recent samples", func() {
+			samples := &lifetimeSamples{}
+			for i := 1; i <= LIFETIME_SAMPLES+10; i++ {
+				samples.addStartup(time.Duration(i) * time.Second)
+			}
+
+			So(len(samples.startups), ShouldEqual, LIFETIME_SAMPLES)
+			So(samples.startups[0], ShouldEqual, 11*time.Second)
+		})
+	})
+}
+
+func Test_summarizeDurations(t *testing.T) {
+	Convey("summarizeDurations()", t, func() {
+		Convey("is empty without any samples", func() {
+			So(summarizeDurations(nil), ShouldResemble, DurationStats{})
+		})
+
+		Convey("works out the statistics", func() {
+			var samples []time.Duration
+			for i := 10; i >= 1; i-- {
+				samples = append(samples, time.Duration(i)*time.Second)
+			}
+
+			stats := summarizeDurations(samples)
+			So(stats.Count, ShouldEqual, 10)
+			So(stats.Mean, ShouldEqual, 5500*time.Millisecond)
+			So(stats.Median, ShouldEqual, 6*time.Second)
+			So(stats.P90, ShouldEqual, 10*time.Second)
+			So(stats.Max, ShouldEqual, 10*time.Second)
+			So(samples[0], ShouldEqual, 10*time.Second) // Left alone
+		})
+	})
+}
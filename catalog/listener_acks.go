@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"sort"
+)
+
+// AckListener records that the named listener has passed on every change up
+// to the state version, e.g. because its receiver accepted a post. Only
+// listeners that ack are tracked. Anything that wants to know whether a
+// change has made it out of this node, like a rolling drain, can then ask
+// UnackedListeners().
+func (state *ServicesState) AckListener(name string, version uint64) {
+	state.Lock()
+	defer state.Unlock()
+
+	if state.listenerAcks == nil {
+		state.listenerAcks = make(map[string]uint64)
+	}
+
+	if version > state.listenerAcks[name] {
+		state.listenerAcks[name] = version
+	}
+}
+
+// ForgetListenerAcks stops tracking the listener's acks, e.g. when it stops
+// passing on events. It's done for us by RemoveListener().
+func (state *ServicesState) ForgetListenerAcks(name string) {
+	state.Lock()
+	defer state.Unlock()
+
+	delete(state.listenerAcks, name)
+}
+
+// UnackedListeners returns the names of the listeners that ack, but haven't
+// yet acked the state version, sorted
+func (state *ServicesState) UnackedListeners(version uint64) []string {
+	state.RLock()
+	defer state.RUnlock()
+
+	var names []string
+	for name, acked := range state.listenerAcks {
+		if acked < version {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AckingListeners returns how many listeners ack the changes they pass on
+func (state *ServicesState) AckingListeners() int {
+	state.RLock()
+	defer state.RUnlock()
+
+	return len(state.listenerAcks)
+}
@@ -0,0 +1,217 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	RESYNC_RETRY_INTERVAL = 1 * time.Second // How often we check that a dirty listener still exists
+)
+
+// An OverflowPolicy decides what happens to a ChangeEvent when a listener's
+// channel is full.
+type OverflowPolicy int
+
+const (
+	// Throw away the new event. This is the default.
+	OverflowDropNewest OverflowPolicy = iota
+	// Throw away the oldest event in the channel to make room for the new one.
+	OverflowDropOldest
+	// Wait for room in the channel, up to a timeout, then drop the event.
+	OverflowBlock
+	// Mark the listener dirty, and deliver the most recent event as soon as
+	// there is room. For listeners that re-read the whole state on every
+	// event, this guarantees they end up consistent.
+	OverflowResync
+)
+
+// ListenerStats counts what happened when a listener's channel was full
+type ListenerStats struct {
+	Delivered int64 // Events that went straight into the channel
+	Dropped   int64 // Events thrown away, including those displaced by newer ones
+	TimedOut  int64 // Times we blocked on the listener and gave up
+	Resyncs   int64 // Times a dirty listener was resynced
+}
+
+// listenerState tracks the overflow policy and what has happened for a
+// single listener. Protected by the ServicesState lock.
+type listenerState struct {
+	options    listenerOptions
+	stats      ListenerStats
+	dirty      bool
+	pending    ChangeEvent
+	pendingSeq int64
+}
+
+// WithOverflowBlock makes NotifyListeners() wait up to timeout for room in
+// the listener's channel before dropping the event. This holds up all state
+// updates while waiting, so keep the timeout short.
+func WithOverflowBlock(timeout time.Duration) ListenerOption {
+	return func(opts *listenerOptions) {
+		opts.overflow = OverflowBlock
+		opts.blockTimeout = timeout
+	}
+}
+
+// WithOverflowDropOldest makes room for new events in a full channel by
+// throwing away the oldest one.
+func WithOverflowDropOldest() ListenerOption {
+	return func(opts *listenerOptions) {
+		opts.overflow = OverflowDropOldest
+	}
+}
+
+// WithOverflowResync marks the listener dirty when its channel is full and
+// then delivers the latest event in the background once there is room.
+func WithOverflowResync() ListenerOption {
+	return func(opts *listenerOptions) {
+		opts.overflow = OverflowResync
+	}
+}
+
+// ListenerStats returns the overflow counters for the named listener. The
+// second return value is false if there is no such listener.
+func (state *ServicesState) ListenerStats(name string) (ListenerStats, bool) {
+	state.RLock()
+	defer state.RUnlock()
+
+	lState, ok := state.listenerStates[name]
+	if !ok {
+		return ListenerStats{}, false
+	}
+
+	return lState.stats, true
+}
+
+// AllListenerStats returns the overflow counters for every listener, by name
+func (state *ServicesState) AllListenerStats() map[string]ListenerStats {
+	state.RLock()
+	defer state.RUnlock()
+
+	result := make(map[string]ListenerStats, len(state.listenerStates))
+	for name, lState := range state.listenerStates {
+		result[name] = lState.stats
+	}
+
+	return result
+}
+
+// notifyListener delivers an event to a single listener, applying its
+// overflow policy if the channel is full. Note: not synchronized! Expects
+// the caller to hold the state lock.
+func (state *ServicesState) notifyListener(listener Listener, event ChangeEvent) {
+	lState := state.listenerStates[listener.Name()]
+	if lState == nil {
+		// Not added with AddListener(), use the defaults
+		lState = &listenerState{}
+		state.listenerStates[listener.Name()] = lState
+	}
+
+	// A resync is already on its way, just make sure it's the latest event.
+	// This supersedes the event that was pending.
+	if lState.dirty {
+		lState.pending = event
+		lState.pendingSeq++
+		lState.stats.Dropped++
+		return
+	}
+
+	select {
+	case listener.Chan() <- event:
+		lState.stats.Delivered++
+		return
+	default:
+	}
+
+	switch lState.options.overflow {
+	case OverflowDropOldest:
+		// The listener may have read from the channel in the meantime, so
+		// none of this is allowed to block.
+		select {
+		case <-listener.Chan():
+			lState.stats.Dropped++
+			metrics.IncrCounter([]string{"services_state", "listeners", "dropped"}, 1)
+		default:
+		}
+
+		select {
+		case listener.Chan() <- event:
+			lState.stats.Delivered++
+		default:
+			lState.stats.Dropped++
+			logLimiter.Warnf("listener-drop:"+listener.Name(),
+				"Can't notify listener (%s) even after dropping an event", listener.Name(),
+			)
+		}
+
+	case OverflowBlock:
+		select {
+		case listener.Chan() <- event:
+			lState.stats.Delivered++
+		case <-time.After(lState.options.blockTimeout):
+			lState.stats.TimedOut++
+			lState.stats.Dropped++
+			metrics.IncrCounter([]string{"services_state", "listeners", "timed_out"}, 1)
+			logLimiter.Warnf("listener-timeout:"+listener.Name(),
+				"Timed out notifying listener (%s), dropping event", listener.Name(),
+			)
+		}
+
+	case OverflowResync:
+		lState.dirty = true
+		lState.pending = event
+		lState.pendingSeq++
+		metrics.IncrCounter([]string{"services_state", "listeners", "resync"}, 1)
+		logLimiter.Warnf("listener-resync:"+listener.Name(),
+			"Listener (%s) is falling behind, will resync it", listener.Name(),
+		)
+
+		go state.resyncListener(listener, lState)
+
+	default:
+		lState.stats.Dropped++
+		metrics.IncrCounter([]string{"services_state", "listeners", "dropped"}, 1)
+		logLimiter.Warnf("listener-blocked:"+listener.Name(),
+			"Can't notify listener (%s). May not be ready yet.", listener.Name(),
+		)
+	}
+}
+
+// resyncListener runs in the background and delivers the pending event to a
+// dirty listener once its channel has room. If more events arrive while we
+// are waiting, only the latest is delivered. Gives up if the listener is
+// removed.
+func (state *ServicesState) resyncListener(listener Listener, lState *listenerState) {
+	for {
+		state.RLock()
+		// Removed, or replaced by a new listener with the same name
+		if state.listenerStates[listener.Name()] != lState {
+			state.RUnlock()
+			return
+		}
+		event := lState.pending
+		seq := lState.pendingSeq
+		state.RUnlock()
+
+		select {
+		case listener.Chan() <- event:
+		case <-time.After(RESYNC_RETRY_INTERVAL):
+			continue
+		}
+
+		state.Lock()
+		// Something newer came in while we were sending, so go around again
+		if lState.pendingSeq != seq {
+			state.Unlock()
+			continue
+		}
+		lState.dirty = false
+		lState.stats.Resyncs++
+		state.Unlock()
+
+		log.Infof("Resynced listener (%s)", listener.Name())
+		return
+	}
+}
@@ -0,0 +1,125 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ListenerOverflow(t *testing.T) {
+	Convey("When a listener's channel is full", t, func() {
+		state := NewServicesState()
+		listener := &mockListener{"listener1", make(chan ChangeEvent, 1), false}
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		svc1 := service.Service{ID: "deadbeef123", Hostname: hostname, Updated: baseTime}
+		svc2 := service.Service{ID: "deadbeef101", Hostname: hostname, Updated: baseTime}
+		svc3 := service.Service{ID: "deadbeef105", Hostname: hostname, Updated: baseTime}
+
+		notify := func(svc service.Service) {
+			state.Lock()
+			state.NotifyListeners(&svc, service.UNKNOWN, baseTime)
+			state.Unlock()
+		}
+
+		Convey("the new event is dropped by default", func() {
+			state.AddListener(listener)
+			notify(svc1)
+			notify(svc2)
+
+			event := <-listener.Chan()
+			So(event.Service.ID, ShouldEqual, svc1.ID)
+
+			stats, ok := state.ListenerStats(listener.Name())
+			So(ok, ShouldBeTrue)
+			So(stats.Delivered, ShouldEqual, 1)
+			So(stats.Dropped, ShouldEqual, 1)
+		})
+
+		Convey("the oldest event can be dropped instead", func() {
+			state.AddListener(listener, WithOverflowDropOldest())
+			notify(svc1)
+			notify(svc2)
+
+			event := <-listener.Chan()
+			So(event.Service.ID, ShouldEqual, svc2.ID)
+
+			stats, _ := state.ListenerStats(listener.Name())
+			So(stats.Delivered, ShouldEqual, 2)
+			So(stats.Dropped, ShouldEqual, 1)
+		})
+
+		Convey("we can block for a while before dropping", func() {
+			state.AddListener(listener, WithOverflowBlock(10*time.Millisecond))
+			notify(svc1)
+
+			Convey("and deliver if the listener catches up", func() {
+				go func() {
+					time.Sleep(1 * time.Millisecond)
+					<-listener.Chan()
+				}()
+				notify(svc2)
+
+				event := <-listener.Chan()
+				So(event.Service.ID, ShouldEqual, svc2.ID)
+
+				stats, _ := state.ListenerStats(listener.Name())
+				So(stats.TimedOut, ShouldEqual, 0)
+			})
+
+			Convey("and time out if it doesn't", func() {
+				notify(svc2)
+
+				stats, _ := state.ListenerStats(listener.Name())
+				So(stats.TimedOut, ShouldEqual, 1)
+				So(stats.Dropped, ShouldEqual, 1)
+			})
+		})
+
+		Convey("a resyncing listener is sent the latest event once it has room", func() {
+			state.AddListener(listener, WithOverflowResync())
+			notify(svc1)
+			notify(svc2)
+			notify(svc3)
+
+			first := <-listener.Chan()
+			So(first.Service.ID, ShouldEqual, svc1.ID)
+
+			var resync ChangeEvent
+			select {
+			case resync = <-listener.Chan():
+			case <-time.After(1 * time.Second):
+			}
+			So(resync.Service.ID, ShouldEqual, svc3.ID)
+
+			// Give the resync time to finish up
+			var stats ListenerStats
+			for i := 0; i < 100; i++ {
+				stats, _ = state.ListenerStats(listener.Name())
+				if stats.Resyncs > 0 {
+					break
+				}
+				time.Sleep(1 * time.Millisecond)
+			}
+			So(stats.Resyncs, ShouldEqual, 1)
+			So(stats.Dropped, ShouldEqual, 1)
+
+			Convey("and goes back to normal afterward", func() {
+				notify(svc1)
+				event := <-listener.Chan()
+				So(event.Service.ID, ShouldEqual, svc1.ID)
+			})
+		})
+
+		Convey("stats are removed along with the listener", func() {
+			state.AddListener(listener)
+			err := state.RemoveListener(listener.Name())
+			So(err, ShouldBeNil)
+
+			_, ok := state.ListenerStats(listener.Name())
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,392 @@
+package catalog
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How UrlListener posts can be signed
+const (
+	SigningJWT  = "jwt"  // An HS256 JWT in the Authorization header
+	SigningHMAC = "hmac" // An HMAC-SHA256 in the SignatureHeader
+)
+
+const (
+	// Carries the HMAC signature of a post, as "t=<unix time>,kid=<key id>,v1=<hex>"
+	SignatureHeader = "X-Sidecar-Signature"
+	// How old a signature can be before receivers reject it
+	MaxSignatureAge = 5 * time.Minute
+)
+
+// A SigningKey is one of the shared secrets posts are signed with
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// A ListenerSigner signs the posts of UrlListeners, so that receivers can
+// tell they came from this cluster, and checks those signatures for
+// receivers. The keys are read from a file with one "<key id> <secret>"
+// per line. The first key signs, and all of them are accepted, so keys can
+// be rotated by adding the new one at the top, then removing the old one
+// once every receiver has it. The file is read again when it changes.
+type ListenerSigner struct {
+	Method   string // SigningJWT or SigningHMAC
+	Issuer   string // Who the JWTs say they're from, usually the cluster name
+	KeysFile string
+
+	keys     []SigningKey
+	modTime  time.Time
+	keysLock sync.Mutex
+	now      func() time.Time
+}
+
+// NewListenerSigner returns a ListenerSigner with the keys from the file
+func NewListenerSigner(method string, keysFile string, issuer string) (*ListenerSigner, error) {
+	method = strings.ToLower(strings.TrimSpace(method))
+	if method == "" {
+		method = SigningJWT
+	}
+
+	if method != SigningJWT && method != SigningHMAC {
+		return nil, fmt.Errorf("unknown signing method %q", method)
+	}
+
+	signer := &ListenerSigner{
+		Method:   method,
+		Issuer:   issuer,
+		KeysFile: keysFile,
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+
+	_, err := signer.currentKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// Sign adds the signature for the body to the request. The body is what
+// goes over the wire, so it's signed after gzipping.
+func (s *ListenerSigner) Sign(req *http.Request, body []byte) error {
+	keys, err := s.currentKeys()
+	if err != nil {
+		return err
+	}
+	key := keys[0]
+	now := s.now()
+
+	if s.Method == SigningHMAC {
+		req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,kid=%s,v1=%s",
+			now.Unix(), key.ID, hex.EncodeToString(hmacBody(key.Secret, now.Unix(), body)),
+		))
+		return nil
+	}
+
+	token, err := s.signJWT(key, now, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Verify checks the signature on a request against the body it came with
+func (s *ListenerSigner) Verify(req *http.Request, body []byte) error {
+	keys, err := s.currentKeys()
+	if err != nil {
+		return err
+	}
+
+	if s.Method == SigningHMAC {
+		return s.verifyHMAC(keys, req.Header.Get(SignatureHeader), body)
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return errors.New("no bearer token")
+	}
+	return s.verifyJWT(keys, token, body)
+}
+
+// KeyID returns the ID of the key the request says it was signed with, or
+// "" when it isn't signed. It doesn't check the signature, so it's only
+// worth trusting after Verify().
+func (s *ListenerSigner) KeyID(req *http.Request) string {
+	if s.Method == SigningHMAC {
+		for _, field := range strings.Split(req.Header.Get(SignatureHeader), ",") {
+			name, value := splitField(field)
+			if name == "kid" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	var header jwtHeader
+	if decodeJWTPart(parts[0], &header) != nil {
+		return ""
+	}
+	return header.Kid
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer     string `json:"iss,omitempty"`
+	IssuedAt   int64  `json:"iat"`
+	Expires    int64  `json:"exp"`
+	BodySHA256 string `json:"body_sha256"` // Hex encoded
+}
+
+func (s *ListenerSigner) signJWT(key SigningKey, now time.Time, body []byte) (string, error) {
+	digest := sha256.Sum256(body)
+
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:     s.Issuer,
+		IssuedAt:   now.Unix(),
+		Expires:    now.Add(MaxSignatureAge).Unix(),
+		BodySHA256: hex.EncodeToString(digest[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *ListenerSigner) verifyJWT(keys []SigningKey, token string, body []byte) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return err
+	}
+	if header.Alg != "HS256" {
+		return fmt.Errorf("unexpected JWT algorithm %q", header.Alg)
+	}
+
+	key, ok := findKey(keys, header.Kid)
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed JWT signature")
+	}
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("bad JWT signature")
+	}
+
+	var claims jwtClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return err
+	}
+
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return fmt.Errorf("JWT is from %q", claims.Issuer)
+	}
+
+	now := s.now().Unix()
+	if now >= claims.Expires || claims.IssuedAt > now+int64(MaxSignatureAge.Seconds()) {
+		return errors.New("JWT has expired")
+	}
+
+	digest := sha256.Sum256(body)
+	if !hmac.Equal([]byte(claims.BodySHA256), []byte(hex.EncodeToString(digest[:]))) {
+		return errors.New("JWT doesn't match the body")
+	}
+
+	return nil
+}
+
+func (s *ListenerSigner) verifyHMAC(keys []SigningKey, header string, body []byte) error {
+	var timestamp, keyID, signature string
+	for _, field := range strings.Split(header, ",") {
+		name, value := splitField(field)
+		switch name {
+		case "t":
+			timestamp = value
+		case "kid":
+			keyID = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	if timestamp == "" || signature == "" {
+		return errors.New("no signature")
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+
+	age := s.now().Sub(time.Unix(signedAt, 0))
+	if age > MaxSignatureAge || age < -MaxSignatureAge {
+		return errors.New("signature has expired")
+	}
+
+	key, ok := findKey(keys, keyID)
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	expected := hex.EncodeToString(hmacBody(key.Secret, signedAt, body))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("bad signature")
+	}
+
+	return nil
+}
+
+// currentKeys returns the keys, reading the file again if it has changed
+func (s *ListenerSigner) currentKeys() ([]SigningKey, error) {
+	s.keysLock.Lock()
+	defer s.keysLock.Unlock()
+
+	info, err := os.Stat(s.KeysFile)
+	if err != nil {
+		if len(s.keys) > 0 {
+			// Keep signing with what we have, the file may be being replaced
+			log.Warnf("Unable to check the signing keys, using the old ones: %s", err)
+			return s.keys, nil
+		}
+		return nil, fmt.Errorf("unable to read the signing keys: %w", err)
+	}
+
+	if len(s.keys) > 0 && info.ModTime().Equal(s.modTime) {
+		return s.keys, nil
+	}
+
+	keys, err := readSigningKeys(s.KeysFile)
+	if err != nil {
+		if len(s.keys) > 0 {
+			log.Errorf("Bad signing keys, using the old ones: %s", err)
+			return s.keys, nil
+		}
+		return nil, err
+	}
+
+	if len(s.keys) > 0 {
+		log.Infof("Reloaded %d signing keys from %s", len(keys), s.KeysFile)
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return s.keys, nil
+}
+
+// readSigningKeys reads one "<key id> <secret>" per line, skipping blank
+// lines and comments
+func readSigningKeys(filename string) ([]SigningKey, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the signing keys: %w", err)
+	}
+	defer file.Close()
+
+	var keys []SigningKey
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("signing keys in %s must be \"<key id> <secret>\"", filename)
+		}
+		keys = append(keys, SigningKey{ID: fields[0], Secret: []byte(fields[1])})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the signing keys: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found in %s", filename)
+	}
+
+	return keys, nil
+}
+
+func findKey(keys []SigningKey, id string) (SigningKey, bool) {
+	for _, key := range keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// hmacBody signs the time along with the body, so that old posts can't be
+// replayed
+func hmacBody(secret []byte, signedAt int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(signedAt, 10) + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func decodeJWTPart(part string, into interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err == nil {
+		err = json.Unmarshal(data, into)
+	}
+	if err != nil {
+		return errors.New("malformed JWT")
+	}
+	return nil
+}
+
+func splitField(field string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
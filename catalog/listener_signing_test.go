@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ListenerSigner(t *testing.T) {
+	Convey("ListenerSigner", t, func() {
+		dir, err := ioutil.TempDir("", "signing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		keysFile := filepath.Join(dir, "keys")
+		writeKeys := func(contents string) {
+			So(ioutil.WriteFile(keysFile, []byte(contents), 0600), ShouldBeNil)
+		}
+		writeKeys("# The first key signs\nnew s3cret\nold 0ldsecret\n")
+
+		body := []byte(`{"Changes": []}`)
+		newRequest := func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/update", nil)
+		}
+
+		for _, method := range []string{SigningJWT, SigningHMAC} {
+			method := method
+
+			Convey("signs and verifies with "+method, func() {
+				signer, err := NewListenerSigner(method, keysFile, "default")
+				So(err, ShouldBeNil)
+
+				req := newRequest()
+				So(signer.Sign(req, body), ShouldBeNil)
+				So(signer.Verify(req, body), ShouldBeNil)
+
+				Convey("and reports the key it was signed with", func() {
+					So(signer.KeyID(req), ShouldEqual, "new")
+					So(signer.KeyID(newRequest()), ShouldEqual, "")
+				})
+
+				Convey("and rejects changed bodies", func() {
+					So(signer.Verify(req, []byte(`{"Changes": null}`)), ShouldNotBeNil)
+				})
+
+				Convey("and rejects old signatures", func() {
+					signer.now = func() time.Time { return time.Now().UTC().Add(MaxSignatureAge + time.Second) }
+					So(signer.Verify(req, body), ShouldNotBeNil)
+				})
+
+				Convey("and rejects unsigned requests", func() {
+					So(signer.Verify(newRequest(), body), ShouldNotBeNil)
+				})
+
+				Convey("and rejects keys it doesn't have", func() {
+					writeKeys("other s3cret\n")
+					os.Chtimes(keysFile, time.Now(), time.Now().Add(time.Minute))
+					So(signer.Verify(req, body), ShouldNotBeNil)
+				})
+			})
+		}
+
+		Convey("accepts signatures from any of the keys", func() {
+			writeKeys("old 0ldsecret\n")
+			oldSigner, err := NewListenerSigner(SigningJWT, keysFile, "default")
+			So(err, ShouldBeNil)
+
+			req := newRequest()
+			So(oldSigner.Sign(req, body), ShouldBeNil)
+
+			writeKeys("new s3cret\nold 0ldsecret\n")
+			signer, err := NewListenerSigner(SigningJWT, keysFile, "default")
+			So(err, ShouldBeNil)
+			So(signer.Verify(req, body), ShouldBeNil)
+		})
+
+		Convey("rejects JWTs from other clusters", func() {
+			other, _ := NewListenerSigner(SigningJWT, keysFile, "other")
+			signer, _ := NewListenerSigner(SigningJWT, keysFile, "default")
+
+			req := newRequest()
+			So(other.Sign(req, body), ShouldBeNil)
+			So(signer.Verify(req, body), ShouldNotBeNil)
+		})
+
+		Convey("rejects bad config", func() {
+			_, err := NewListenerSigner("rot13", keysFile, "default")
+			So(err, ShouldNotBeNil)
+
+			_, err = NewListenerSigner(SigningJWT, filepath.Join(dir, "missing"), "default")
+			So(err, ShouldNotBeNil)
+
+			writeKeys("# Nothing here\n")
+			_, err = NewListenerSigner(SigningJWT, keysFile, "default")
+			So(err, ShouldNotBeNil)
+
+			writeKeys("no-secret\n")
+			_, err = NewListenerSigner(SigningJWT, keysFile, "default")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("signs the posts of an UrlListener", func() {
+			signer, err := NewListenerSigner(SigningHMAC, keysFile, "default")
+			So(err, ShouldBeNil)
+
+			var lock sync.Mutex
+			var verified []error
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method != http.MethodPost {
+					return
+				}
+				data, _ := ioutil.ReadAll(req.Body)
+				lock.Lock()
+				verified = append(verified, signer.Verify(req, data))
+				lock.Unlock()
+			}))
+			defer server.Close()
+
+			hostname := "grendel"
+			state := NewServicesState()
+			state.Hostname = hostname
+
+			listener := NewUrlListener(server.URL, false)
+			listener.Signer = signer
+			listener.Retries = 0
+			listener.looper = director.NewFreeLooper(director.ONCE, make(chan error))
+			listener.eventChannel <- ChangeEvent{Service: service.Service{ID: "deadbeef123", Hostname: hostname}}
+			listener.Watch(state)
+			listener.looper.Wait()
+
+			lock.Lock()
+			defer lock.Unlock()
+			So(len(verified), ShouldEqual, 1)
+			So(verified[0], ShouldBeNil)
+		})
+
+		Convey("keeps the old keys when the file goes bad", func() {
+			signer, err := NewListenerSigner(SigningHMAC, keysFile, "default")
+			So(err, ShouldBeNil)
+
+			writeKeys("junk\n")
+			os.Chtimes(keysFile, time.Now(), time.Now().Add(time.Minute))
+
+			req := newRequest()
+			So(signer.Sign(req, body), ShouldBeNil)
+			So(strings.Contains(req.Header.Get(SignatureHeader), "kid=new"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// LoadListenerTLS reads the client certificate UrlListeners present to
+// receivers, and the CA that receivers' certificates must be signed by.
+// Either can be left empty: without a CA the system roots are trusted, and
+// without a certificate we only check the receiver. Returns nil when none of
+// them are set.
+func LoadListenerTLS(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("a listener client certificate needs both the certificate and the key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the listener client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the listener CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the listener CA %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// NewListenerTransport returns a transport like the one UrlListeners share,
+// but that connects with the TLS config. Share it between the listeners too,
+// so they reuse their connections.
+func NewListenerTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := newListenerTransport()
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCert writes a certificate and key for the name, signed by the
+// parent, or self-signed as a CA when it's nil. Returns the certificate,
+// key, and the paths they were written to.
+func writeTestCert(dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (
+	*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), ShouldBeNil)
+
+	return cert, key, certFile, keyFile
+}
+
+func Test_ListenerTLS(t *testing.T) {
+	Convey("Listener TLS", t, func() {
+		dir, err := ioutil.TempDir("", "listener-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca, caKey, caFile, _ := writeTestCert(dir, "ca", nil, nil)
+		_, _, serverCert, serverKey := writeTestCert(dir, "receiver", ca, caKey)
+		_, _, clientCert, clientKey := writeTestCert(dir, "sidecar", ca, caKey)
+
+		Convey("is off when nothing is configured", func() {
+			tlsConfig, err := LoadListenerTLS("", "", "")
+			So(err, ShouldBeNil)
+			So(tlsConfig, ShouldBeNil)
+		})
+
+		Convey("rejects bad config", func() {
+			_, err := LoadListenerTLS(clientCert, "", "")
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadListenerTLS(clientCert, clientKey, filepath.Join(dir, "missing"))
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadListenerTLS(clientCert, clientKey, clientKey)
+			So(err.Error(), ShouldContainSubstring, "no certificates found")
+		})
+
+		Convey("posts to receivers that want a client certificate", func() {
+			pem, err := ioutil.ReadFile(caFile)
+			So(err, ShouldBeNil)
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(pem)
+
+			cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+			So(err, ShouldBeNil)
+
+			peers := make(chan string, 10)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					peers <- r.TLS.PeerCertificates[0].Subject.CommonName
+				}
+			}))
+			server.TLS = &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			tlsConfig, err := LoadListenerTLS(clientCert, clientKey, caFile)
+			So(err, ShouldBeNil)
+
+			hostname := "grendel"
+			state := NewServicesState()
+			state.Hostname = hostname
+
+			listener := NewUrlListener(server.URL, false)
+			listener.Client.Transport = NewListenerTransport(tlsConfig)
+			listener.Retries = 0
+			listener.looper = director.NewFreeLooper(director.ONCE, make(chan error))
+			listener.eventChannel <- ChangeEvent{Service: service.Service{ID: "deadbeef123", Hostname: hostname}}
+			listener.Watch(state)
+			listener.looper.Wait()
+
+			So(len(peers), ShouldEqual, 1)
+			So(<-peers, ShouldEqual, "sidecar")
+
+			Convey("and are refused without one", func() {
+				tlsConfig, err := LoadListenerTLS("", "", caFile)
+				So(err, ShouldBeNil)
+
+				client := &http.Client{Transport: NewListenerTransport(tlsConfig)}
+				_, err = client.Post(server.URL, "application/json", nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package catalog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	MAX_DEGRADED_DURATION = 10 * time.Minute // How long we hold on to the last good backends
+)
+
+// An InstanceGuard stops the proxies from dropping all the backends of a
+// service when a burst of tombstones or failed checks takes out most of its
+// instances at once. Services opt in with MinInstances. When fewer than that
+// are ALIVE, the guard hands back the last set of instances that met the
+// minimum and marks the service as degraded. After MaxDegraded it gives up
+// and lets the live instances through, so a service that really went away
+// doesn't keep receiving traffic forever. Each proxy keeps its own guard.
+type InstanceGuard struct {
+	MaxDegraded time.Duration
+	lastGood    map[string][]*service.Service
+	since       map[string]time.Time // When each degraded service fell short
+	now         func() time.Time
+	sync.Mutex
+}
+
+func NewInstanceGuard() *InstanceGuard {
+	return &InstanceGuard{
+		MaxDegraded: MAX_DEGRADED_DURATION,
+		lastGood:    make(map[string][]*service.Service),
+		since:       make(map[string]time.Time),
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Degraded looks at every service in the state and returns the last good
+// instances for each one that has fallen below its minimum. Callers should
+// use these in place of the instances in the state for those services. The
+// state must be locked by the caller. A nil guard never degrades anything.
+func (g *InstanceGuard) Degraded(state *ServicesState) map[string][]*service.Service {
+	if g == nil {
+		return nil
+	}
+
+	byService := make(map[string][]*service.Service)
+	minimums := make(map[string]int)
+	state.EachServiceSorted(func(hostname *string, id *string, svc *service.Service) {
+		// Tombstones still tell us what the minimum was
+		if svc.MinInstances > minimums[svc.Name] {
+			minimums[svc.Name] = svc.MinInstances
+		}
+
+		if state.IsServable(svc) {
+			byService[svc.Name] = append(byService[svc.Name], svc)
+		}
+	})
+
+	g.Lock()
+	defer g.Unlock()
+
+	now := g.now()
+	degraded := make(map[string][]*service.Service)
+
+	for name, min := range minimums {
+		alive := byService[name]
+
+		if len(alive) >= min {
+			g.remember(name, alive)
+			continue
+		}
+
+		lastGood, ok := g.lastGood[name]
+		if !ok {
+			continue // Never healthy, nothing better to offer
+		}
+
+		since, ok := g.since[name]
+		if !ok {
+			since = now
+			g.since[name] = since
+			log.Warnf("Service %s has %d of a minimum %d instances alive, keeping its last %d backends",
+				name, len(alive), min, len(lastGood),
+			)
+		}
+
+		if g.MaxDegraded > 0 && now.Sub(since) > g.MaxDegraded {
+			if len(lastGood) > 0 {
+				log.Warnf("Service %s has been degraded for %s, dropping its last good backends",
+					name, now.Sub(since).Round(time.Second),
+				)
+			}
+			g.lastGood[name] = nil // Stay given up until it recovers
+			continue
+		}
+
+		if len(lastGood) > 0 {
+			degraded[name] = lastGood
+		}
+	}
+
+	// Forget services that have gone away or no longer set a minimum
+	for name := range g.lastGood {
+		if minimums[name] == 0 {
+			delete(g.lastGood, name)
+			delete(g.since, name)
+		}
+	}
+
+	metrics.SetGauge([]string{"services_state", "degraded_services"}, float32(len(degraded)))
+
+	return degraded
+}
+
+// remember stores copies of a healthy set of instances. Note: not
+// synchronized!
+func (g *InstanceGuard) remember(name string, alive []*service.Service) {
+	if _, ok := g.since[name]; ok {
+		log.Infof("Service %s has recovered with %d instances alive", name, len(alive))
+		delete(g.since, name)
+	}
+
+	copies := make([]*service.Service, 0, len(alive))
+	for _, svc := range alive {
+		svcCopy := *svc
+		copies = append(copies, &svcCopy)
+	}
+	g.lastGood[name] = copies
+}
+
+// DegradedNames returns the sorted names of the degraded services
+func DegradedNames(degraded map[string][]*service.Service) []string {
+	names := make([]string, 0, len(degraded))
+	for name := range degraded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
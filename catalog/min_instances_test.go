@@ -0,0 +1,98 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_InstanceGuard(t *testing.T) {
+	Convey("Guarding services below their minimum instances", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Round(time.Second)
+		now := baseTime
+
+		guard := NewInstanceGuard()
+		guard.now = func() time.Time { return now }
+
+		svc1 := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: anotherHostname,
+			Updated: baseTime, Status: service.ALIVE, MinInstances: 2,
+			Ports: []service.Port{{Type: "tcp", Port: 1234, ServicePort: 8080}},
+		}
+		svc2 := svc1
+		svc2.ID = "deadbeef456"
+
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		// kill sends a newer record for the service with a new status
+		kill := func(svc service.Service, status int) {
+			svc.Status = status
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+		}
+
+		Convey("doesn't degrade services that meet their minimum", func() {
+			So(guard.Degraded(state), ShouldBeEmpty)
+		})
+
+		Convey("hands back the last good instances below the minimum", func() {
+			guard.Degraded(state)
+			kill(svc2, service.TOMBSTONE)
+
+			degraded := guard.Degraded(state)
+			So(len(degraded["beowulf"]), ShouldEqual, 2)
+			So(degraded["beowulf"][0].Status, ShouldEqual, service.ALIVE)
+			So(degraded["beowulf"][1].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("recovers when enough instances are alive again", func() {
+			guard.Degraded(state)
+			kill(svc2, service.UNHEALTHY)
+			So(guard.Degraded(state), ShouldNotBeEmpty)
+
+			svc2.Updated = svc2.Updated.Add(2 * time.Second)
+			state.AddServiceEntry(svc2)
+			So(guard.Degraded(state), ShouldBeEmpty)
+			So(guard.since, ShouldBeEmpty)
+		})
+
+		Convey("gives up after MaxDegraded", func() {
+			guard.Degraded(state)
+			kill(svc1, service.TOMBSTONE)
+			kill(svc2, service.TOMBSTONE)
+			So(guard.Degraded(state), ShouldNotBeEmpty)
+
+			now = now.Add(MAX_DEGRADED_DURATION + time.Second)
+			So(guard.Degraded(state), ShouldBeEmpty)
+		})
+
+		Convey("does nothing for services that were never healthy", func() {
+			kill(svc2, service.UNHEALTHY)
+			So(guard.Degraded(state), ShouldBeEmpty)
+		})
+
+		Convey("ignores services without a minimum", func() {
+			guard.Degraded(state)
+
+			svc1.MinInstances = 0
+			svc1.Updated = svc1.Updated.Add(time.Second)
+			state.AddServiceEntry(svc1)
+			svc2.MinInstances = 0
+			kill(svc2, service.TOMBSTONE)
+
+			So(guard.Degraded(state), ShouldBeEmpty)
+			So(guard.lastGood, ShouldBeEmpty)
+		})
+
+		Convey("is a no-op when nil", func() {
+			var nilGuard *InstanceGuard
+			So(nilGuard.Degraded(state), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// The service tags that tell Prometheus how to scrape a service. Only
+// services tagged with PrometheusPortTag are scraped.
+const (
+	PrometheusPortTag   = "prometheus_port"   // The ServicePort the metrics are on
+	PrometheusPathTag   = "prometheus_path"   // Defaults to Prometheus' own, /metrics
+	PrometheusSchemeTag = "prometheus_scheme" // http or https
+)
+
+var invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// A PrometheusTargetGroup is one entry in the list Prometheus reads from
+// http_sd and file_sd service discovery
+type PrometheusTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// PrometheusTargets returns a target group for each alive instance of a
+// service that asks to be scraped. The other tags on the service become
+// labels, along with the job, which is the service name, and where it's
+// running. Handles locking the state.
+func (state *ServicesState) PrometheusTargets() []PrometheusTargetGroup {
+	state.RLock()
+	defer state.RUnlock()
+
+	var instances []*service.Service
+	state.EachService(func(hostname *string, serviceId *string, svc *service.Service) {
+		if state.IsServable(svc) && svc.Tags[PrometheusPortTag] != "" {
+			instances = append(instances, svc)
+		}
+	})
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Name != instances[j].Name {
+			return instances[i].Name < instances[j].Name
+		}
+		if instances[i].Hostname != instances[j].Hostname {
+			return instances[i].Hostname < instances[j].Hostname
+		}
+		return instances[i].ID < instances[j].ID
+	})
+
+	groups := make([]PrometheusTargetGroup, 0, len(instances))
+	for _, svc := range instances {
+		target, ok := prometheusTarget(svc)
+		if !ok {
+			continue
+		}
+
+		groups = append(groups, PrometheusTargetGroup{
+			Targets: []string{target},
+			Labels:  prometheusLabels(svc, state.ClusterName),
+		})
+	}
+
+	return groups
+}
+
+// prometheusTarget returns the address of the metrics port, if the service
+// has the port it asks to be scraped on
+func prometheusTarget(svc *service.Service) (string, bool) {
+	servicePort, err := strconv.ParseInt(svc.Tags[PrometheusPortTag], 10, 64)
+	if err != nil {
+		return "", false
+	}
+
+	for _, port := range svc.Ports {
+		if port.ServicePort != servicePort || port.Type != "tcp" {
+			continue
+		}
+
+		address := port.IP
+		if address == "" {
+			address = svc.Hostname
+		}
+		return net.JoinHostPort(address, strconv.FormatInt(port.Port, 10)), true
+	}
+
+	return "", false
+}
+
+func prometheusLabels(svc *service.Service, clusterName string) map[string]string {
+	labels := make(map[string]string, len(svc.Tags)+4)
+
+	for key, value := range svc.Tags {
+		// The address is what identifies the target
+		if strings.HasPrefix(key, "prometheus_") || key == "instance" {
+			continue
+		}
+		labels[prometheusLabelName(key)] = value
+	}
+
+	if path := svc.Tags[PrometheusPathTag]; path != "" {
+		labels["__metrics_path__"] = path
+	}
+	if scheme := svc.Tags[PrometheusSchemeTag]; scheme != "" {
+		labels["__scheme__"] = scheme
+	}
+
+	labels["job"] = svc.Name
+	labels["hostname"] = svc.Hostname
+	labels["service_id"] = svc.ID
+	if clusterName != "" {
+		labels["cluster"] = clusterName
+	}
+
+	return labels
+}
+
+// prometheusLabelName turns a tag name into a valid label name. Labels that
+// start with "__" are reserved by Prometheus, so tags can't set them.
+func prometheusLabelName(key string) string {
+	name := strings.TrimLeft(invalidLabelChars.ReplaceAllString(key, "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "tag_" + name
+	}
+	return name
+}
@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PrometheusTargets(t *testing.T) {
+	Convey("PrometheusTargets()", t, func() {
+		state := NewServicesState()
+		state.ClusterName = "cantebury"
+		baseTime := time.Now().UTC()
+
+		scraped := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Status: service.ALIVE,
+			Tags: map[string]string{
+				PrometheusPortTag: "9090",
+				PrometheusPathTag: "/status/metrics",
+				"env":             "staging",
+				"team.name":       "tales",
+				"__address__":     "nope",
+				"instance":        "nope",
+			},
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "10.0.0.1"},
+				{Type: "tcp", Port: 10001, ServicePort: 9090, IP: "10.0.0.1"},
+			},
+		}
+		state.AddServiceEntry(scraped)
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "dante", Hostname: "petrarch", Updated: baseTime,
+			Status: service.ALIVE,
+			Ports:  []service.Port{{Type: "tcp", Port: 10002, ServicePort: 9090}},
+		})
+
+		Convey("returns the services that ask to be scraped", func() {
+			So(state.PrometheusTargets(), ShouldResemble, []PrometheusTargetGroup{
+				{
+					Targets: []string{"10.0.0.1:10001"},
+					Labels: map[string]string{
+						"__metrics_path__": "/status/metrics",
+						"job":              "bocaccio",
+						"hostname":         "chaucer",
+						"service_id":       "deadbeef123",
+						"cluster":          "cantebury",
+						"env":              "staging",
+						"team_name":        "tales",
+						"address__":        "nope",
+					},
+				},
+			})
+		})
+
+		Convey("leaves out instances that aren't alive", func() {
+			scraped.Status = service.UNHEALTHY
+			scraped.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(scraped)
+
+			So(state.PrometheusTargets(), ShouldBeEmpty)
+		})
+
+		Convey("leaves out instances without the metrics port", func() {
+			scraped.Tags = map[string]string{PrometheusPortTag: "9999"}
+			scraped.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(scraped)
+
+			So(state.PrometheusTargets(), ShouldBeEmpty)
+		})
+	})
+}
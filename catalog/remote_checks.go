@@ -0,0 +1,147 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	REMOTE_CHECK_REFRESH  = ALIVE_BROADCAST_INTERVAL // How often checker nodes repeat a result that hasn't changed
+	REMOTE_CHECK_LIFESPAN = 3 * REMOTE_CHECK_REFRESH // How long the owner trusts a result it hasn't heard again
+)
+
+// A RemoteCheck is the result of a health check that a checker node ran on a
+// service hosted by another node. The owner stays authoritative for the
+// service record: checker nodes gossip their results as these, and the owner
+// announces the status with its own timestamps. See ApplyRemoteChecks().
+type RemoteCheck struct {
+	ID       string
+	Hostname string    // Where the service runs
+	Checker  string    // The checker node that ran the check
+	Status   int       // ALIVE or UNHEALTHY
+	Time     time.Time // When the checker reported it, by the checker's clock
+}
+
+// remoteCheckEntry is a RemoteCheck and when we heard about it, by our clock
+type remoteCheckEntry struct {
+	check    RemoteCheck
+	received time.Time
+}
+
+// remoteCheckMessage is how a RemoteCheck goes on the wire. Older nodes read
+// it as a service record with no timestamp, and drop it as stale.
+type remoteCheckMessage struct {
+	RemoteCheck *RemoteCheck
+}
+
+var remoteCheckPrefix = []byte(`{"RemoteCheck":`)
+
+// Encode returns the RemoteCheck as a gossip message
+func (check *RemoteCheck) Encode() ([]byte, error) {
+	return json.Marshal(remoteCheckMessage{RemoteCheck: check})
+}
+
+// DecodeRemoteCheck returns the RemoteCheck in a gossip message, or nil when
+// the message is something else, e.g. a service record
+func DecodeRemoteCheck(message []byte) (*RemoteCheck, error) {
+	if !bytes.HasPrefix(message, remoteCheckPrefix) {
+		return nil, nil
+	}
+
+	var decoded remoteCheckMessage
+	err := json.Unmarshal(message, &decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded.RemoteCheck == nil {
+		return nil, errors.New("empty remote check")
+	}
+
+	return decoded.RemoteCheck, nil
+}
+
+// AddRemoteCheck records a remote check result, from a checker node or from
+// gossip. Results for other nodes' services are passed on to our peers the
+// first time we see them, so that they reach the owner.
+func (state *ServicesState) AddRemoteCheck(check RemoteCheck) {
+	now := state.now()
+
+	state.remoteCheckLock.Lock()
+	if state.remoteChecks == nil {
+		state.remoteChecks = make(map[string]remoteCheckEntry)
+	}
+
+	for id, entry := range state.remoteChecks {
+		if now.Sub(entry.received) > state.scaled(REMOTE_CHECK_LIFESPAN) {
+			delete(state.remoteChecks, id)
+		}
+	}
+
+	previous, ok := state.remoteChecks[check.ID]
+	if ok && !check.Time.After(previous.check.Time) {
+		state.remoteCheckLock.Unlock()
+		return
+	}
+	state.remoteChecks[check.ID] = remoteCheckEntry{check: check, received: now}
+	state.remoteCheckLock.Unlock()
+
+	if !ok || previous.check.Status != check.Status {
+		log.Infof("Remote check from %s marked %s on %s as %s",
+			check.Checker, check.ID, check.Hostname, service.StatusString(check.Status),
+		)
+	}
+
+	// The owner announces the result in its own service records
+	if check.Hostname == state.Hostname {
+		return
+	}
+
+	metrics.IncrCounter([]string{"services_state", "remote_checks", "broadcast"}, 1)
+	go func() {
+		encoded, err := check.Encode()
+		if err != nil {
+			log.Errorf("ERROR encoding remote check: (%s)", err.Error())
+			return
+		}
+		state.Broadcasts <- [][]byte{encoded}
+	}()
+}
+
+// ApplyRemoteChecks sets the status of our own services to the result of the
+// latest remote check on them, where there is one we've heard recently. We
+// leave services alone that are DRAINING or tombstoned. Modifies the services
+// in place, and returns them. Doesn't take the state lock, so it can be used
+// in the functions we pass to the Broadcast*() loops.
+func (state *ServicesState) ApplyRemoteChecks(services []service.Service) []service.Service {
+	now := state.now()
+
+	state.remoteCheckLock.Lock()
+	defer state.remoteCheckLock.Unlock()
+
+	if len(state.remoteChecks) == 0 {
+		return services
+	}
+
+	for i := range services {
+		svc := &services[i]
+		if svc.Hostname != state.Hostname || svc.IsDraining() || svc.IsTombstone() {
+			continue
+		}
+
+		entry, ok := state.remoteChecks[svc.ID]
+		if !ok || entry.check.Hostname != svc.Hostname ||
+			now.Sub(entry.received) > state.scaled(REMOTE_CHECK_LIFESPAN) {
+			continue
+		}
+
+		svc.Status = entry.check.Status
+	}
+
+	return services
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RemoteCheckResults(t *testing.T) {
+	Convey("Remote check results", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 5)
+		baseTime := time.Now().UTC().Round(time.Second)
+		frozen := clock.NewFrozen(baseTime)
+		state.Clock = frozen
+
+		check := RemoteCheck{
+			ID:       "deadbeef123",
+			Hostname: hostname,
+			Checker:  anotherHostname,
+			Status:   service.ALIVE,
+			Time:     baseTime,
+		}
+
+		local := []service.Service{
+			{ID: "deadbeef123", Hostname: hostname, Status: service.UNHEALTHY},
+			{ID: "deadbeef456", Hostname: hostname, Status: service.UNHEALTHY},
+		}
+
+		Convey("survive the trip over the wire", func() {
+			encoded, err := check.Encode()
+			So(err, ShouldBeNil)
+
+			decoded, err := DecodeRemoteCheck(encoded)
+			So(err, ShouldBeNil)
+			So(*decoded, ShouldResemble, check)
+
+			Convey("and aren't mistaken for service records", func() {
+				svc := service.Service{ID: "deadbeef123", Hostname: hostname, Updated: baseTime}
+				encoded, err := svc.Encode()
+				So(err, ShouldBeNil)
+
+				decoded, err := DecodeRemoteCheck(encoded)
+				So(err, ShouldBeNil)
+				So(decoded, ShouldBeNil)
+
+				_, err = DecodeRemoteCheck([]byte(`{"RemoteCheck":null}`))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("and are dropped by nodes that read them as service records", func() {
+				svc, err := service.Decode(encoded)
+				So(err, ShouldBeNil)
+
+				state.AddServiceEntry(*svc)
+				So(state.Servers, ShouldBeEmpty)
+			})
+		})
+
+		Convey("set the status of our own services", func() {
+			state.AddRemoteCheck(check)
+
+			services := state.ApplyRemoteChecks(local)
+			So(services[0].Status, ShouldEqual, service.ALIVE)
+			So(services[1].Status, ShouldEqual, service.UNHEALTHY)
+
+			// We don't pass on results for our own services
+			So(len(state.Broadcasts), ShouldEqual, 0)
+		})
+
+		Convey("leave draining services alone", func() {
+			state.AddRemoteCheck(check)
+			local[0].Status = service.DRAINING
+
+			services := state.ApplyRemoteChecks(local)
+			So(services[0].Status, ShouldEqual, service.DRAINING)
+		})
+
+		Convey("expire when we stop hearing them", func() {
+			state.AddRemoteCheck(check)
+			frozen.Advance(REMOTE_CHECK_LIFESPAN + time.Second)
+
+			services := state.ApplyRemoteChecks(local)
+			So(services[0].Status, ShouldEqual, service.UNHEALTHY)
+		})
+
+		Convey("only take newer results", func() {
+			state.AddRemoteCheck(check)
+
+			older := check
+			older.Status = service.UNHEALTHY
+			older.Time = baseTime.Add(-time.Second)
+			state.AddRemoteCheck(older)
+
+			services := state.ApplyRemoteChecks(local)
+			So(services[0].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("for other nodes' services are passed on once", func() {
+			check.Hostname = anotherHostname
+			state.AddRemoteCheck(check)
+			state.AddRemoteCheck(check)
+
+			message := <-state.Broadcasts
+			decoded, err := DecodeRemoteCheck(message[0])
+			So(err, ShouldBeNil)
+			So(decoded.ID, ShouldEqual, check.ID)
+
+			time.Sleep(10 * time.Millisecond)
+			So(len(state.Broadcasts), ShouldEqual, 0)
+
+			// And don't touch services with the same ID on our host
+			services := state.ApplyRemoteChecks(local)
+			So(services[0].Status, ShouldEqual, service.UNHEALTHY)
+		})
+	})
+}
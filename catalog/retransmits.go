@@ -0,0 +1,39 @@
+package catalog
+
+// Retransmits are how many times we send the records of our new services,
+// and of the services we tombstone, so that they reach the whole cluster
+type Retransmits struct {
+	Alive     int
+	Tombstone int
+}
+
+// DefaultRetransmits are the counts we use unless told otherwise
+var DefaultRetransmits = Retransmits{Alive: ALIVE_COUNT, Tombstone: TOMBSTONE_COUNT}
+
+// Retransmits returns the counts in use. Safe to call with or without the
+// state lock held.
+func (state *ServicesState) Retransmits() Retransmits {
+	state.retransmitLock.Lock()
+	defer state.retransmitLock.Unlock()
+
+	if state.retransmits == nil {
+		return DefaultRetransmits
+	}
+	return *state.retransmits
+}
+
+// SetRetransmits changes the counts for the broadcasts that start from now
+// on, e.g. when the cluster grows. Counts below one are raised to one. Safe
+// to call with or without the state lock held.
+func (state *ServicesState) SetRetransmits(retransmits Retransmits) {
+	if retransmits.Alive < 1 {
+		retransmits.Alive = 1
+	}
+	if retransmits.Tombstone < 1 {
+		retransmits.Tombstone = 1
+	}
+
+	state.retransmitLock.Lock()
+	state.retransmits = &retransmits
+	state.retransmitLock.Unlock()
+}
@@ -0,0 +1,25 @@
+package catalog
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Retransmits(t *testing.T) {
+	Convey("Retransmits", t, func() {
+		state := NewServicesState()
+
+		Convey("uses the defaults until they're changed", func() {
+			So(state.Retransmits(), ShouldResemble, DefaultRetransmits)
+
+			state.SetRetransmits(Retransmits{Alive: 3, Tombstone: 20})
+			So(state.Retransmits(), ShouldResemble, Retransmits{Alive: 3, Tombstone: 20})
+		})
+
+		Convey("always sends at least once", func() {
+			state.SetRetransmits(Retransmits{Alive: 0, Tombstone: -1})
+			So(state.Retransmits(), ShouldResemble, Retransmits{Alive: 1, Tombstone: 1})
+		})
+	})
+}
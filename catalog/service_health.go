@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"context"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+)
+
+const (
+	HEALTH_REPORT_INTERVAL = 10 * time.Second // How often we report service health metrics
+)
+
+// How bad each status is, for picking the worst one. Tombstones aren't
+// counted as instances at all.
+var statusSeverity = map[int]int{
+	service.ALIVE:     0,
+	service.DRAINING:  1,
+	service.UNKNOWN:   2,
+	service.UNHEALTHY: 3,
+}
+
+// A ServiceHealth summarizes the instances of one service across the whole
+// cluster, so that alerting doesn't have to work it out from the instances.
+type ServiceHealth struct {
+	Instances     int            // Not counting tombstones
+	Alive         int            // How many of them are ALIVE
+	ByStatus      map[string]int // Instance counts by status name
+	WorstStatus   string         // "Tombstone" when there are no instances
+	OldestUpdated time.Time      // Zero when there are no instances
+}
+
+// SummarizeHealth works out the ServiceHealth of each service from the
+// instances grouped by name, as returned by ByService()
+func SummarizeHealth(byService map[string][]*service.Service) map[string]*ServiceHealth {
+	result := make(map[string]*ServiceHealth, len(byService))
+
+	for name, instances := range byService {
+		health := &ServiceHealth{
+			ByStatus:    make(map[string]int),
+			WorstStatus: service.StatusString(service.TOMBSTONE),
+		}
+
+		worst := -1
+		for _, svc := range instances {
+			if svc.IsTombstone() {
+				continue
+			}
+
+			health.Instances++
+			health.ByStatus[svc.StatusString()]++
+			if svc.Status == service.ALIVE {
+				health.Alive++
+			}
+
+			if severity := statusSeverity[svc.Status]; severity > worst {
+				worst = severity
+				health.WorstStatus = svc.StatusString()
+			}
+
+			if health.OldestUpdated.IsZero() || svc.Updated.Before(health.OldestUpdated) {
+				health.OldestUpdated = svc.Updated
+			}
+		}
+
+		result[name] = health
+	}
+
+	return result
+}
+
+// ServiceHealth returns the health summary for every service we know about,
+// not counting aliases. Handles locking the state.
+func (state *ServicesState) ServiceHealth() map[string]*ServiceHealth {
+	state.RLock()
+	defer state.RUnlock()
+
+	return SummarizeHealth(state.ByServiceWithoutAliases())
+}
+
+// ReportServiceHealth publishes the instance counts of each service as
+// gauges, tagged with the service name, until the looper quits or the context
+// is cancelled
+func (state *ServicesState) ReportServiceHealth(ctx context.Context, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
+	looper.Loop(func() error {
+		for name, health := range state.ServiceHealth() {
+			labels := append(state.metricLabels(), metrics.Label{Name: "service", Value: name})
+			metrics.SetGaugeWithLabels([]string{"services_state", "health", "instances"}, float32(health.Instances), labels)
+			metrics.SetGaugeWithLabels([]string{"services_state", "health", "alive"}, float32(health.Alive), labels)
+		}
+		return nil
+	})
+}
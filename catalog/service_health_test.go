@@ -0,0 +1,63 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServiceHealth(t *testing.T) {
+	Convey("Summarizing service health", t, func() {
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		instance := func(id string, status int, age time.Duration) *service.Service {
+			return &service.Service{ID: id, Name: "beowulf", Status: status, Updated: baseTime.Add(-age)}
+		}
+
+		Convey("counts the instances of each service", func() {
+			summary := SummarizeHealth(map[string][]*service.Service{
+				"beowulf": {
+					instance("1", service.ALIVE, 0),
+					instance("2", service.ALIVE, time.Minute),
+					instance("3", service.UNHEALTHY, 2*time.Second),
+					instance("4", service.DRAINING, time.Second),
+					instance("5", service.TOMBSTONE, time.Hour),
+				},
+			})
+
+			health := summary["beowulf"]
+			So(health, ShouldNotBeNil)
+			So(health.Instances, ShouldEqual, 4)
+			So(health.Alive, ShouldEqual, 2)
+			So(health.ByStatus, ShouldResemble, map[string]int{"Alive": 2, "Unhealthy": 1, "Draining": 1})
+			So(health.WorstStatus, ShouldEqual, "Unhealthy")
+			So(health.OldestUpdated, ShouldEqual, baseTime.Add(-time.Minute))
+		})
+
+		Convey("reports services with only tombstones as having no instances", func() {
+			summary := SummarizeHealth(map[string][]*service.Service{
+				"beowulf": {instance("1", service.TOMBSTONE, 0)},
+			})
+
+			health := summary["beowulf"]
+			So(health.Instances, ShouldEqual, 0)
+			So(health.Alive, ShouldEqual, 0)
+			So(health.WorstStatus, ShouldEqual, "Tombstone")
+			So(health.OldestUpdated.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("works from the state", func() {
+			state := NewServicesState()
+			state.Hostname = hostname
+
+			svc := *instance("deadbeef123", service.ALIVE, 0)
+			svc.Hostname = anotherHostname
+			state.AddServiceEntry(svc)
+
+			health := state.ServiceHealth()
+			So(health["beowulf"].Alive, ShouldEqual, 1)
+		})
+	})
+}
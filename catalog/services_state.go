@@ -3,20 +3,23 @@ package catalog
 //go:generate ffjson $GOFILE
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/logging"
 	"github.com/NinesStack/sidecar/output"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/armon/go-metrics"
 	"github.com/relistan/go-director"
-	log "github.com/sirupsen/logrus"
 )
 
 // catalog handles all of the eventual-consistency mechanisms for
@@ -36,6 +39,13 @@ const (
 	LISTENER_EVENT_BUFFER_SIZE = 20                             // The number of events that can be buffered in the listener eventChannel
 )
 
+var (
+	log = logging.Module("catalog")
+
+	// Gossip repeats itself, and so would the warnings about it
+	logLimiter = logging.NewLimiter(logging.DEFAULT_LIMIT_INTERVAL).WithLogger(log)
+)
+
 // A ChangeEvent represents the time and hostname that was modified and signals a major
 // state change event. It is passed to listeners over the listeners channel in the
 // state object.
@@ -43,6 +53,7 @@ type ChangeEvent struct {
 	Service        service.Service
 	PreviousStatus int
 	Time           time.Time
+	Version        uint64 `json:"-"` // The state version that includes the change, 0 for replays
 }
 
 // Holds the state about one server in our cluster
@@ -72,10 +83,49 @@ type ServicesState struct {
 	LastChanged         time.Time
 	ClusterName         string
 	Hostname            string
-	Broadcasts          chan [][]byte        `json:"-"`
-	ServiceMsgs         chan service.Service `json:"-"`
+	Broadcasts          chan [][]byte            `json:"-"`
+	ServiceMsgs         chan service.Service     `json:"-"`
+	BroadcastJitter     time.Duration            `json:"-"` // Max random delay added to broadcasts
+	ValidationPolicy    ValidationPolicy         `json:"-"` // What to do with invalid service records
+	MaxClockSkew        time.Duration            `json:"-"` // Warn about peers whose clocks are further off than this
+	CompensateClockSkew bool                     `json:"-"` // Adjust peers' timestamps by their estimated skew
+	TimeScale           float64                  `json:"-"` // Shortens lifespans and refreshes by this factor, for dev mode
+	DiscoveryHealthy    func() bool              `json:"-"` // When false, don't tombstone local services missing from discovery. May be nil.
+	DrainTTL            time.Duration            `json:"-"` // Tombstone local services DRAINING for longer than this, 0 for never
+	CheckMaxAge         time.Duration            `json:"-"` // Don't serve ALIVE services whose last check is older than this, 0 for no limit
+	TombstoneRetention  map[string]time.Duration `json:"-"` // How long to keep tombstones, by service name, see tombstoneLifespan()
+	EphemeralRetention  time.Duration            `json:"-"` // How long to keep the tombstones of ephemeral services, 0 for EPHEMERAL_TOMBSTONE_LIFESPAN
+	OnServerExpired     func(string, int)        `json:"-"` // Called with the hostname and count of live services expired, with the lock held. May be nil.
+	Members             func() []string          `json:"-"` // Names of the live cluster members, to elect a Leader(). May be nil.
+	Clock               clock.Clock              `json:"-"` // Where we get the time from. nil for the wall clock.
 	listeners           map[string]Listener
+	listenerStates      map[string]*listenerState
+	listenerAcks        map[string]uint64 // The newest version each listener has confirmed, see AckListener()
+	validationStats     ValidationStats
+	clockSkews          map[string]*skewEstimate
+	checkSkews          map[string]time.Duration // The skews a read-only copy was made with, see checkSkew()
+	staleChecks         map[string]bool          // ALIVE services whose last check is too old, see updateFreshness()
+	version             uint64
+	serverVersions      map[string]uint64
+	records             uint64            // Counts every record we store, see recordReplaced()
+	serverRecords       map[string]uint64 // The records count when each server last got a record
+	history             map[string][]StatusTransition
+	firstSeen           map[string]time.Time        // When we first saw services that aren't ALIVE yet, by ID
+	lifetimes           map[string]*lifetimeSamples // Timings of recent instances, by service name
+	strings             stringPool
 	tombstoneRetransmit time.Duration
+	churn               churnCounters
+	drainDeadlines      map[string]time.Time        // When the drains of local services expire, by ID
+	drainExpired        map[string]bool             // Local services tombstoned when their drain expired, by ID
+	view                *servicesView               // Shared by the renderers, see ServicesView()
+	viewLock            sync.Mutex                  // Held while building the view, before the state lock
+	retransmits         *Retransmits                // nil for DefaultRetransmits
+	retransmitLock      sync.Mutex                  // Guards retransmits, which we read with or without the state lock
+	broadcastTimes      map[string]time.Time        // When we last put each service on the wire, by ID
+	broadcastLock       sync.Mutex                  // Guards broadcastTimes, which SendServices() updates without the state lock
+	remoteChecks        map[string]remoteCheckEntry // The latest remote check results, by service ID
+	remoteCheckLock     sync.Mutex                  // Guards remoteChecks, which ApplyRemoteChecks() reads with or without the state lock
+	heapStats           heapStats                   // The last heap figures, see MemoryReport()
 	sync.RWMutex
 }
 
@@ -96,6 +146,18 @@ func NewServicesState() *ServicesState {
 		tombstoneRetransmit: TOMBSTONE_RETRANSMIT,
 		ServiceMsgs:         make(chan service.Service, 25),
 		listeners:           make(map[string]Listener),
+		listenerStates:      make(map[string]*listenerState),
+		ValidationPolicy:    ValidationClamp,
+		clockSkews:          make(map[string]*skewEstimate),
+		version:             initialVersion(),
+		serverVersions:      make(map[string]uint64),
+		serverRecords:       make(map[string]uint64),
+		history:             make(map[string][]StatusTransition),
+		firstSeen:           make(map[string]time.Time),
+		lifetimes:           make(map[string]*lifetimeSamples),
+		drainDeadlines:      make(map[string]time.Time),
+		drainExpired:        make(map[string]bool),
+		Clock:               clock.Real,
 	}
 	state.Hostname, err = os.Hostname()
 	if err != nil {
@@ -125,15 +187,28 @@ func (state *ServicesState) Encode() []byte {
 }
 
 // ProcessNewServiceMsgs is to be run in a goroutine, and processes incoming
-// service notices.
-func (state *ServicesState) ProcessServiceMsgs(looper director.Looper) {
+// service notices. It returns when the looper stops or the context is
+// cancelled.
+func (state *ServicesState) ProcessServiceMsgs(ctx context.Context, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
-		service := <-state.ServiceMsgs
-		state.AddServiceEntry(service)
+		select {
+		case service := <-state.ServiceMsgs:
+			state.AddServiceEntry(service)
+		case <-ctx.Done():
+		}
 		return nil
 	})
 }
 
+// quitOnDone stops the looper when the context is cancelled. Used by all of
+// the background loops so that they can be shut down from one place.
+func quitOnDone(ctx context.Context, looper director.Looper) {
+	<-ctx.Done()
+	looper.Quit()
+}
+
 // UpdateService enqueues a state update for a given service
 func (state *ServicesState) UpdateService(svc service.Service) {
 	state.ServiceMsgs <- svc
@@ -172,14 +247,22 @@ func (state *ServicesState) ExpireServer(hostname string) {
 	log.Infof("Expiring %s", hostname)
 
 	var tombstones []service.Service
+	var expired int
 
 	for _, svc := range state.Servers[hostname].Services {
 		previousStatus := svc.Status
-		svc.Tombstone()
+		if previousStatus != service.TOMBSTONE {
+			expired++
+		}
+		svc.TombstoneAt(state.now())
 		state.ServiceChanged(svc, previousStatus, svc.Updated)
 		tombstones = append(tombstones, *svc)
 	}
 
+	if state.OnServerExpired != nil {
+		state.OnServerExpired(hostname, expired)
+	}
+
 	if len(tombstones) < 1 {
 		log.Warn("Tried to announce a zero length list of tombstones")
 		return
@@ -187,13 +270,16 @@ func (state *ServicesState) ExpireServer(hostname string) {
 
 	state.SendServices(
 		tombstones,
-		director.NewTimedLooper(TOMBSTONE_COUNT, state.tombstoneRetransmit, nil),
+		director.NewTimedLooper(state.Retransmits().Tombstone, state.tombstoneRetransmit, nil),
 	)
 }
 
 // Tell the state that a particular service transitioned from one state to another.
 func (state *ServicesState) ServiceChanged(svc *service.Service, previousStatus int, updated time.Time) {
 	state.serverChanged(svc.Hostname, updated)
+	state.recordLifetime(svc, previousStatus, updated)
+	state.recordTransition(svc, previousStatus, updated)
+	state.recordChange(svc, previousStatus)
 	state.NotifyListeners(svc, previousStatus, state.LastChanged)
 }
 
@@ -209,11 +295,13 @@ func (state *ServicesState) serverChanged(hostname string, updated time.Time) {
 	state.Servers[hostname].LastUpdated = updated
 	state.Servers[hostname].LastChanged = updated
 	state.LastChanged = updated
+	state.bumpVersion(hostname)
 }
 
 // Tell all of our listeners that something changed for a host at
 // set timestamp. See AddListener() for information about how channels
-// must be configured.
+// must be configured. Listeners whose channels are full are handled
+// according to their OverflowPolicy.
 func (state *ServicesState) NotifyListeners(svc *service.Service, previousStatus int, changedTime time.Time) {
 	listeners := state.listeners
 
@@ -224,39 +312,145 @@ func (state *ServicesState) NotifyListeners(svc *service.Service, previousStatus
 
 	log.Debugf("Notifying listeners of change at %s", changedTime.String())
 
-	event := ChangeEvent{Service: *svc, PreviousStatus: previousStatus, Time: changedTime}
+	event := ChangeEvent{Service: *svc, PreviousStatus: previousStatus, Time: changedTime, Version: state.version}
 	for _, listener := range listeners {
 		if listener == nil {
 			continue
 		}
 
-		select {
-		case listener.Chan() <- event:
-			continue
-		default:
-			log.Warnf("Can't notify listener (%s). May not be ready yet.", listener.Name())
-		}
+		state.notifyListener(listener, event)
+	}
+}
+
+// A ListenerOption modifies the behavior of AddListener()
+type ListenerOption func(*listenerOptions)
+
+type listenerOptions struct {
+	replay       bool
+	overflow     OverflowPolicy
+	blockTimeout time.Duration
+}
+
+// WithReplay tells AddListener() to synchronously deliver a synthetic
+// ChangeEvent for every service that is currently ALIVE or DRAINING, so that
+// a new listener converges on the current state without having to bootstrap
+// itself separately. The events have a PreviousStatus of UNKNOWN. Because
+// delivery blocks, the listener must already be consuming from its channel,
+// or have a buffer large enough to hold the whole replay.
+func WithReplay() ListenerOption {
+	return func(opts *listenerOptions) {
+		opts.replay = true
 	}
 }
 
 // Add an event listener channel to the list that will be notified on
 // major state change events. Channels must be buffered by at least 1
-// or they will block. Channels must be ready to receive input.
-func (state *ServicesState) AddListener(listener Listener) {
+// or they will block. Channels must be ready to receive input. A listener
+// with the same name is replaced.
+func (state *ServicesState) AddListener(listener Listener, options ...ListenerOption) {
+	err := state.addListener(listener, true, options)
+	if err != nil {
+		log.Errorf("Refusing to add listener %s: %s", listener.Name(), err)
+	}
+}
+
+// AddUniqueListener is like AddListener(), but returns ErrListenerExists
+// rather than replacing a listener that has the same name, and returns an
+// error wrapping ErrInvalidListener when the listener can't be added.
+func (state *ServicesState) AddUniqueListener(listener Listener, options ...ListenerOption) error {
+	return state.addListener(listener, false, options)
+}
+
+// addListener adds the listener, replacing one with the same name when
+// replace is set
+func (state *ServicesState) addListener(listener Listener, replace bool, options []ListenerOption) error {
 	if listener.Chan() == nil {
-		log.Errorf("Refusing to add listener %s with nil channel!", listener.Name())
-		return
+		return fmt.Errorf("nil channel: %w", ErrInvalidListener)
 	}
 
 	if cap(listener.Chan()) < 1 {
-		log.Errorf("Refusing to add blocking channel as listener: %s", listener.Name())
-		return
+		return fmt.Errorf("blocking channel: %w", ErrInvalidListener)
 	}
+
+	var opts listenerOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	var replay []ChangeEvent
+
 	state.Lock()
-	defer state.Unlock()
+	if _, ok := state.listeners[listener.Name()]; ok && !replace {
+		state.Unlock()
+		return fmt.Errorf("%w: %s", ErrListenerExists, listener.Name())
+	}
 
 	state.listeners[listener.Name()] = listener
+	state.listenerStates[listener.Name()] = &listenerState{options: opts}
 	log.Debugf("AddListener(): added %s, new count %d", listener.Name(), len(state.listeners))
+
+	// Take the snapshot while we hold the lock so that no events can be
+	// missed between the replay and the live events.
+	if opts.replay {
+		replay = state.replayEvents()
+	}
+	state.Unlock()
+
+	if len(replay) > 0 {
+		state.replayToListener(listener, replay)
+	}
+
+	return nil
+}
+
+// replayEvents builds a synthetic ChangeEvent for each service that is ALIVE
+// or DRAINING. Note: not synchronized!
+func (state *ServicesState) replayEvents() []ChangeEvent {
+	var events []ChangeEvent
+
+	state.EachServiceSorted(func(hostname *string, id *string, svc *service.Service) {
+		if !svc.IsAlive() && !svc.IsDraining() {
+			return
+		}
+
+		events = append(events, ChangeEvent{
+			Service:        *svc,
+			PreviousStatus: service.UNKNOWN,
+			Time:           svc.Updated,
+		})
+	})
+
+	return events
+}
+
+// replayToListener delivers the replay events to the listener, in order.
+// Events for records that changed since the snapshot was taken are stale. A
+// service that is gone, or whose status changed, is skipped because the
+// listener already received a live event that supersedes it. When only the
+// timestamp moved on, there was no live event, so we send the current record
+// in place of the snapshot.
+func (state *ServicesState) replayToListener(listener Listener, events []ChangeEvent) {
+	log.Debugf("Replaying %d events to listener %s", len(events), listener.Name())
+
+	for _, event := range events {
+		state.RLock()
+		var current *service.Service
+		if state.HasServer(event.Service.Hostname) {
+			current = state.Servers[event.Service.Hostname].Services[event.Service.ID]
+		}
+		superseded := current == nil || current.Status != event.Service.Status
+		if !superseded && !current.Updated.Equal(event.Service.Updated) {
+			event.Service = *current
+			event.Time = current.Updated
+		}
+		state.RUnlock()
+
+		if superseded {
+			continue
+		}
+
+		listener.Chan() <- event
+	}
 }
 
 // Remove an event listener channel by name. This will find the first
@@ -266,10 +460,12 @@ func (state *ServicesState) RemoveListener(name string) error {
 	defer state.Unlock()
 
 	if _, ok := state.listeners[name]; !ok {
-		return fmt.Errorf("no listener found with the name %q", name)
+		return fmt.Errorf("no listener found with the name %q: %w", name, ErrListenerNotFound)
 	}
 
 	delete(state.listeners, name)
+	delete(state.listenerStates, name)
+	delete(state.listenerAcks, name)
 	log.Debugf("RemoveListener(): removed %s, new count %d", name, len(state.listeners))
 
 	return nil
@@ -291,22 +487,45 @@ func (state *ServicesState) GetListeners() []Listener {
 // timestamps so we only add things newer than what we already
 // know about. Retransmits updates to cluster peers.
 func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
-	defer metrics.MeasureSince([]string{"services_state", "AddServiceEntry"}, time.Now())
+	defer metrics.MeasureSinceWithLabels([]string{"services_state", "AddServiceEntry"}, time.Now(), state.metricLabels())
 
 	state.Lock()
 	defer state.Unlock()
 
+	state.addServiceEntry(newSvc, false)
+}
+
+// addServiceEntry does the work of AddServiceEntry(). Discovery keeps
+// finding our DRAINING services running, so their status sticks unless
+// undrain is set. Note: not synchronized!
+func (state *ServicesState) addServiceEntry(newSvc service.Service, undrain bool) {
+	// Our own services stay tombstoned once their drain expires, even though
+	// discovery still finds them
+	if !newSvc.IsTombstone() && state.isDrainExpired(&newSvc) {
+		return
+	}
+
+	state.recordSkew(&newSvc, state.now())
+
 	// Some weird edge cases can cause very old stuff to get broadcast.  This
 	// can end up in a broadcast/tombstone/broadcast loop. We'll attempt to
 	// prevent that by dropping anything older than the tombstone window.
-	if newSvc.IsStale(TOMBSTONE_LIFESPAN) {
-		log.Warnf(
+	adjusted := newSvc
+	adjusted.Updated = state.localUpdated(&newSvc)
+	if adjusted.IsStaleAt(state.tombstoneLifespan(&newSvc), state.now()) {
+		logLimiter.Warnf("stale:"+newSvc.Hostname+":"+newSvc.ID,
 			"Dropping stale service received on gossip: %s:%s (%s)",
 			newSvc.Hostname, newSvc.Name, newSvc.ID,
 		)
 		return
 	}
 
+	// Don't let malformed records, e.g. with timestamps in the future, into
+	// the state. They can wedge the Invalidates() logic.
+	if !state.validateEntry(&newSvc) {
+		return
+	}
+
 	if !state.HasServer(newSvc.Hostname) {
 		state.Servers[newSvc.Hostname] = NewServer(newSvc.Hostname)
 	}
@@ -315,33 +534,51 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 
 	// Only apply changes that are newer or services are missing
 	if !server.HasService(newSvc.ID) {
+		// Share the strings with the records we already have
+		state.strings.internService(&newSvc)
 		server.Services[newSvc.ID] = &newSvc
+		state.recordReplaced(newSvc.Hostname)
+		state.updateFreshness(&newSvc)
 		state.ServiceChanged(&newSvc, service.UNKNOWN, newSvc.Updated)
 		state.retransmit(newSvc)
-	} else if newSvc.Invalidates(server.Services[newSvc.ID]) {
+	} else if newSvc.Invalidates(server.Services[newSvc.ID]) || state.winsTie(&newSvc, server.Services[newSvc.ID]) {
 		// We have to set these even if the status did not change
 		server.LastUpdated = newSvc.Updated
 
 		// Store the previous newSvc so we can compare it
 		oldEntry := server.Services[newSvc.ID]
 
-		// Make sure we preserve the DRAINING status for services
-		if oldEntry.Status == service.DRAINING && newSvc.Status == service.ALIVE {
+		// Make sure we preserve the DRAINING status for our services.
+		// Other hosts' services are ALIVE again when their owner says so.
+		if oldEntry.Status == service.DRAINING && newSvc.Status == service.ALIVE &&
+			newSvc.Hostname == state.Hostname && !undrain {
 			newSvc.Status = oldEntry.Status
 		}
 
+		// Discovery doesn't know about annotations, so keep the ones that
+		// haven't expired
+		if newSvc.Annotations == nil {
+			newSvc.Annotations = service.ActiveAnnotations(oldEntry.Annotations, state.now())
+		}
+
 		// Update the new one
+		state.strings.internService(&newSvc)
 		server.Services[newSvc.ID] = &newSvc
+		state.recordReplaced(newSvc.Hostname)
 
 		// When the status changes, the SeviceChanged() method will
 		// update all the accounting fields in the state and Server newSvc.
+		// A new check can also make the service servable again, or not.
+		freshnessChanged := state.updateFreshness(&newSvc)
 		if oldEntry.Status != newSvc.Status {
 			state.ServiceChanged(&newSvc, oldEntry.Status, newSvc.Updated)
+		} else if freshnessChanged {
+			state.freshnessChanged(&newSvc)
 		}
 
 		// We tell our gossip peers about the updated service
 		// by sending them the record. We're saved from an endless
-		// retransmit loop by the Invalidates() call above.
+		// retransmit loop by the Invalidates() and winsTie() calls above.
 		state.retransmit(newSvc)
 	}
 }
@@ -359,7 +596,189 @@ func (state *ServicesState) GetLocalServiceByID(id string) (service.Service, err
 	}
 
 	return service.Service{},
-		fmt.Errorf("service with ID %q not found on host %q", id, state.Hostname)
+		fmt.Errorf("service with ID %q not found on host %q: %w", id, state.Hostname, ErrServiceNotFound)
+}
+
+// GetServiceByID returns the service with the given ID from whichever host
+// has it. If more than one does, e.g. after a host was renamed, the most
+// recently updated wins. Returns an error when no host has it.
+func (state *ServicesState) GetServiceByID(id string) (service.Service, error) {
+	state.RLock()
+	defer state.RUnlock()
+
+	var found *service.Service
+	for _, server := range state.Servers {
+		if svc, ok := server.Services[id]; ok {
+			if found == nil || svc.Updated.After(found.Updated) {
+				found = svc
+			}
+		}
+	}
+
+	if found == nil {
+		return service.Service{}, fmt.Errorf("service with ID %q not found: %w", id, ErrServiceNotFound)
+	}
+
+	return *found, nil
+}
+
+// AnnotateLocalService adds an annotation to a service on the current host,
+// which is then gossiped along with the service. It expires after ttl.
+// Returns the annotated service.
+func (state *ServicesState) AnnotateLocalService(id string, note string, author string, ttl time.Duration) (service.Service, error) {
+	svc, err := state.GetLocalServiceByID(id)
+	if err != nil {
+		return service.Service{}, err
+	}
+
+	now := state.now()
+	svc.Annotate(service.Annotation{
+		Note:    note,
+		Author:  author,
+		Created: now,
+		Expires: now.Add(ttl),
+	})
+	svc.Updated = now
+	state.UpdateService(svc)
+
+	return svc, nil
+}
+
+// DrainLocalServices sets every service on the current host that matches the
+// selector, and isn't already on its way out, to DRAINING. The drains expire
+// after ttl, see SetDrainDeadline(). Returns the services that were drained.
+func (state *ServicesState) DrainLocalServices(selector service.Selector, ttl time.Duration) []service.Service {
+	var drained []service.Service
+
+	state.RLock()
+	if server, ok := state.Servers[state.Hostname]; ok {
+		for _, svc := range server.Services {
+			if svc.IsTombstone() || svc.IsDraining() || !selector.Matches(svc) {
+				continue
+			}
+			drained = append(drained, *svc)
+		}
+	}
+	state.RUnlock()
+
+	now := state.now()
+	for i := range drained {
+		drained[i].Updated = now
+		drained[i].Status = service.DRAINING
+		state.SetDrainDeadline(drained[i].ID, ttl)
+		state.UpdateService(drained[i])
+	}
+
+	sort.Slice(drained, func(i, j int) bool { return drained[i].ID < drained[j].ID })
+
+	return drained
+}
+
+// DrainableLocalServices returns the IDs of the services on the current host
+// that DrainLocalServices() would drain for the selector, sorted
+func (state *ServicesState) DrainableLocalServices(selector service.Selector) []string {
+	var ids []string
+
+	state.RLock()
+	if server, ok := state.Servers[state.Hostname]; ok {
+		for _, svc := range server.Services {
+			if svc.IsTombstone() || svc.IsDraining() || !selector.Matches(svc) {
+				continue
+			}
+			ids = append(ids, svc.ID)
+		}
+	}
+	state.RUnlock()
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// DrainLocalService sets one service on the current host to DRAINING, unless
+// it's already on its way out. The drain expires after ttl, see
+// SetDrainDeadline(). Returns the drained service. Unlike the other drains,
+// this applies the change before it returns, under the lock, so nothing can
+// change the service between the check and the drain, and Version() already
+// includes it.
+func (state *ServicesState) DrainLocalService(id string, ttl time.Duration) (service.Service, error) {
+	state.Lock()
+	defer state.Unlock()
+
+	server, ok := state.Servers[state.Hostname]
+	if !ok || server.Services[id] == nil {
+		return service.Service{},
+			fmt.Errorf("service with ID %q not found on host %q: %w", id, state.Hostname, ErrServiceNotFound)
+	}
+
+	svc := *server.Services[id]
+	if svc.IsTombstone() || svc.IsDraining() {
+		return service.Service{}, fmt.Errorf("service with ID %q is already %s", id, svc.StatusString())
+	}
+
+	svc.Updated = state.now()
+	svc.Status = service.DRAINING
+	state.setDrainDeadline(svc.ID, ttl)
+	state.addServiceEntry(svc, false)
+
+	return svc, nil
+}
+
+// UndrainLocalServices sets every DRAINING service on the current host that
+// matches the selector back to ALIVE, and cancels their drain deadlines.
+// Returns the services that were undrained, sorted by ID.
+func (state *ServicesState) UndrainLocalServices(selector service.Selector) []service.Service {
+	state.Lock()
+	defer state.Unlock()
+
+	var undrained []service.Service
+	if server, ok := state.Servers[state.Hostname]; ok {
+		for _, svc := range server.Services {
+			if !svc.IsDraining() || !selector.Matches(svc) {
+				continue
+			}
+			undrained = append(undrained, *svc)
+		}
+	}
+
+	for i := range undrained {
+		undrained[i] = state.undrain(undrained[i])
+	}
+
+	sort.Slice(undrained, func(i, j int) bool { return undrained[i].ID < undrained[j].ID })
+
+	return undrained
+}
+
+// UndrainLocalService sets one DRAINING service on the current host back to
+// ALIVE, and cancels its drain deadline. Returns the undrained service.
+func (state *ServicesState) UndrainLocalService(id string) (service.Service, error) {
+	state.Lock()
+	defer state.Unlock()
+
+	server, ok := state.Servers[state.Hostname]
+	if !ok || server.Services[id] == nil {
+		return service.Service{},
+			fmt.Errorf("service with ID %q not found on host %q: %w", id, state.Hostname, ErrServiceNotFound)
+	}
+
+	svc := *server.Services[id]
+	if !svc.IsDraining() {
+		return service.Service{}, fmt.Errorf("service with ID %q is %s, not Draining", id, svc.StatusString())
+	}
+
+	return state.undrain(svc), nil
+}
+
+// undrain does the work of undraining a local service. Note: not
+// synchronized!
+func (state *ServicesState) undrain(svc service.Service) service.Service {
+	svc.Updated = state.now()
+	svc.Status = service.ALIVE
+	delete(state.drainDeadlines, svc.ID)
+	state.addServiceEntry(svc, true)
+
+	return svc
 }
 
 // Merge a complete state struct into this one. Usually used on
@@ -396,7 +815,7 @@ func (state *ServicesState) retransmit(svc service.Service) {
 func (state *ServicesState) Format(list *memberlist.Memberlist) string {
 	var outStr string
 
-	refTime := time.Now().UTC()
+	refTime := state.now()
 
 	var servers []*Server
 	for _, svr := range state.Servers {
@@ -443,7 +862,9 @@ func (state *ServicesState) Print(list *memberlist.Memberlist) {
 
 // TrackNewServices talks to the discovery mechanism and tracks any services we
 // don't already know about.
-func (state *ServicesState) TrackNewServices(fn func() []service.Service, looper director.Looper) {
+func (state *ServicesState) TrackNewServices(ctx context.Context, fn func() []service.Service, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
 		for _, svc := range fn() {
 			state.UpdateService(svc)
@@ -452,11 +873,84 @@ func (state *ServicesState) TrackNewServices(fn func() []service.Service, looper
 	})
 }
 
+// RemoteServices returns a copy of all the services hosted on other nodes
+// which are currently eligible for remote health checking. That means they
+// are either ALIVE or UNHEALTHY. We don't interfere with services that are
+// DRAINING or have been tombstoned by their owner.
+func (state *ServicesState) RemoteServices() []service.Service {
+	state.RLock()
+	defer state.RUnlock()
+
+	var services []service.Service
+	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if *hostname == state.Hostname {
+			return
+		}
+
+		if svc.IsAlive() || svc.Status == service.UNHEALTHY {
+			services = append(services, *svc)
+		}
+	})
+
+	return services
+}
+
+// TrackRemoteChecks is run on checker nodes. It repeatedly calls fn to get
+// the results of health checks on services we don't host, and announces them
+// as RemoteChecks: right away when the status changes, and again every
+// REMOTE_CHECK_REFRESH so that the owner keeps trusting them. We never touch
+// the owner's record ourselves. See ApplyRemoteChecks().
+func (state *ServicesState) TrackRemoteChecks(ctx context.Context, fn func() []service.Service, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
+	lastSent := make(map[string]RemoteCheck) // By service ID
+
+	looper.Loop(func() error {
+		services := fn()
+		now := state.now()
+		checked := make(map[string]bool)
+
+		for _, svc := range services {
+			// Not checked yet, nothing to report
+			if svc.Status != service.ALIVE && svc.Status != service.UNHEALTHY {
+				continue
+			}
+			checked[svc.ID] = true
+
+			previous, ok := lastSent[svc.ID]
+			if ok && previous.Status == svc.Status && now.Sub(previous.Time) < state.scaled(REMOTE_CHECK_REFRESH) {
+				continue
+			}
+
+			check := RemoteCheck{
+				ID:       svc.ID,
+				Hostname: svc.Hostname,
+				Checker:  state.Hostname,
+				Status:   svc.Status,
+				Time:     now,
+			}
+			lastSent[svc.ID] = check
+			state.AddRemoteCheck(check)
+		}
+
+		// Forget the services we no longer check
+		for id := range lastSent {
+			if !checked[id] {
+				delete(lastSent, id)
+			}
+		}
+
+		return nil
+	})
+}
+
 // TrackLocalListeners runs in the background and repeatedly calls
 // a discovery function to return a list of event listeners. These will
 // then be added to to the listener list. Managed listeners no longer
 // reported from discovery will be removed.
-func (state *ServicesState) TrackLocalListeners(fn func() []Listener, looper director.Looper) {
+func (state *ServicesState) TrackLocalListeners(ctx context.Context, fn func() []Listener, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
 		discovered := fn()
 		// Add new listeners
@@ -470,8 +964,8 @@ func (state *ServicesState) TrackLocalListeners(fn func() []Listener, looper dir
 				urlListener, ok := listener.(*UrlListener)
 				if ok {
 					urlListener.Watch(state)
-				} else {
-					state.AddListener(listener)
+				} else if err := state.AddUniqueListener(listener); err != nil {
+					log.Warnf("Failed to add discovered listener %s: %s", listener.Name(), err)
 				}
 			}
 		}
@@ -520,10 +1014,15 @@ func (state *ServicesState) IsNewService(svc *service.Service) bool {
 	return false
 }
 
-// BroadcastServices loops forever, transmitting info about our containers on the
-// broadcast channel. Intended to run as a background goroutine.
-func (state *ServicesState) BroadcastServices(fn func() []service.Service, looper director.Looper) {
+// BroadcastServices loops until the context is cancelled, transmitting info
+// about our containers on the broadcast channel. Intended to run as a
+// background goroutine.
+func (state *ServicesState) BroadcastServices(ctx context.Context, fn func() []service.Service, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	lastTime := time.Unix(0, 0)
+	broadcastInterval := state.scaled(ALIVE_BROADCAST_INTERVAL)
+	refreshInterval := broadcastInterval
 
 	looper.Loop(func() error {
 		defer metrics.MeasureSince([]string{"services_state", "BroadcastServices"}, time.Now())
@@ -536,6 +1035,10 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 		defer state.RUnlock()
 
 		for _, svc := range servicesList {
+			if state.isDrainExpired(&svc) {
+				continue
+			}
+
 			isNew := state.IsNewService(&svc)
 
 			// We'll broadcast it now if it's new or we've hit refresh window
@@ -544,7 +1047,7 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 				haveNewServices = true
 				services = append(services, svc)
 				// Check that refresh window... is it time?
-			} else if time.Now().UTC().Add(0 - ALIVE_BROADCAST_INTERVAL).After(lastTime) {
+			} else if state.now().Add(0 - refreshInterval).After(lastTime) {
 				services = append(services, svc)
 			}
 		}
@@ -554,10 +1057,13 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 			// Figure out how many times to announce the service. New services get more announcements.
 			runCount := 1
 			if haveNewServices {
-				runCount = ALIVE_COUNT
+				runCount = state.Retransmits().Alive
 			}
 
-			lastTime = time.Now().UTC()
+			lastTime = state.now()
+			// Pull the next refresh in by a random amount so that the whole
+			// cluster doesn't end up refreshing at the same moment.
+			refreshInterval = broadcastInterval - state.jitter(broadcastInterval/2)
 			state.SendServices(
 				services,
 				director.NewTimedLooper(runCount, state.tombstoneRetransmit, nil),
@@ -583,6 +1089,10 @@ func (state *ServicesState) SendServices(services []service.Service, looper dire
 
 		additionalTime := 0 * time.Second
 		looper.Loop(func() error {
+			// Spread the retransmissions out over the window rather than
+			// sending them on the same boundary as everyone else.
+			time.Sleep(state.jitter(state.tombstoneRetransmit))
+
 			var prepared [][]byte
 
 			for _, svc := range services {
@@ -594,6 +1104,8 @@ func (state *ServicesState) SendServices(services []service.Service, looper dire
 				prepared = append(prepared, encoded)
 			}
 
+			state.recordBroadcastTimes(services)
+
 			// We add time to make sure that these get retransmitted by peers.
 			// Otherwise they aren't "new" messages and don't get retransmitted.
 			additionalTime = additionalTime + 50*time.Nanosecond
@@ -603,8 +1115,17 @@ func (state *ServicesState) SendServices(services []service.Service, looper dire
 	}()
 }
 
-func (state *ServicesState) BroadcastTombstones(fn func() []service.Service, looper director.Looper) {
+// BroadcastTombstones loops until the context is cancelled, tombstoning any
+// of our services which have gone away and expiring old records from other
+// hosts. Each pass is delayed by a random amount, up to the BroadcastJitter.
+func (state *ServicesState) BroadcastTombstones(ctx context.Context, fn func() []service.Service, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
+		if !state.sleepJitter(ctx, TOMBSTONE_SLEEP_INTERVAL) {
+			return nil // We're shutting down
+		}
+
 		defer metrics.MeasureSince([]string{"services_state", "BroadcastTombstones"}, time.Now())
 
 		state.Lock()
@@ -613,14 +1134,23 @@ func (state *ServicesState) BroadcastTombstones(fn func() []service.Service, loo
 		containerList := fn()
 		// Tell people about our dead services
 		otherTombstones := state.TombstoneOthersServices()
-		tombstones := state.TombstoneServices(state.Hostname, containerList)
+
+		// If discovery is broken, our services only look like they're gone
+		var tombstones []service.Service
+		if state.DiscoveryHealthy == nil || state.DiscoveryHealthy() {
+			tombstones = state.TombstoneServices(state.Hostname, containerList)
+			state.forgetExpiredDrains(containerList)
+		}
+
+		// And the ones left DRAINING for too long
+		tombstones = append(tombstones, state.expireDrains()...)
 
 		tombstones = append(tombstones, otherTombstones...)
 
 		if len(tombstones) > 0 {
 			state.SendServices(
 				tombstones,
-				director.NewTimedLooper(TOMBSTONE_COUNT, state.tombstoneRetransmit, nil),
+				director.NewTimedLooper(state.Retransmits().Tombstone, state.tombstoneRetransmit, nil),
 			)
 		} else {
 			// We expect there to always be _something_ in the channel
@@ -632,19 +1162,81 @@ func (state *ServicesState) BroadcastTombstones(fn func() []service.Service, loo
 	})
 }
 
+// jitter returns a random duration between zero and the BroadcastJitter,
+// capped at max. Returns zero when no jitter is configured.
+func (state *ServicesState) jitter(max time.Duration) time.Duration {
+	limit := state.BroadcastJitter
+	if limit > max {
+		limit = max
+	}
+
+	if limit <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// sleepJitter waits for a random time picked by jitter(), unless the context
+// is cancelled first. Returns false when it was.
+func (state *ServicesState) sleepJitter(ctx context.Context, max time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	delay := state.jitter(max)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// now returns the current time from the state's Clock
+func (state *ServicesState) now() time.Time {
+	if state.Clock == nil {
+		return clock.Real.Now()
+	}
+	return state.Clock.Now()
+}
+
+// scaled shortens a lifespan or interval by the TimeScale. Anything below
+// 1 leaves it alone, we never slow things down.
+func (state *ServicesState) scaled(duration time.Duration) time.Duration {
+	if state.TimeScale <= 1 {
+		return duration
+	}
+
+	return time.Duration(float64(duration) / state.TimeScale)
+}
+
 func (state *ServicesState) TombstoneOthersServices() []service.Service {
 	defer metrics.MeasureSince([]string{"services_state", "TombstoneOthersServices"}, time.Now())
 
 	var result []service.Service
+	var expired int
 
 	// Manage tombstone life so we don't keep them forever. We have to do this
 	// even for hosts that aren't running services now, because they might have
 	// been. Make sure we don't keep alive services around for very much
 	// time at all.
 	state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		updated := state.localUpdated(svc)
+
 		if svc.IsTombstone() &&
-			svc.Updated.Before(time.Now().UTC().Add(0-TOMBSTONE_LIFESPAN)) {
+			updated.Before(state.now().Add(0-state.tombstoneLifespan(svc))) {
 			delete(state.Servers[*hostname].Services, *id)
+			state.forgetHistory(*id)
+			state.forgetLifetime(*id)
+			expired++
 
 			// If this is the last service, remove the server
 			if len(state.Servers[*hostname].Services) < 1 {
@@ -652,14 +1244,14 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 			}
 		}
 
-		svcLifespan := ALIVE_LIFESPAN
+		svcLifespan := state.scaled(ALIVE_LIFESPAN)
 		if svc.IsDraining() {
-			svcLifespan = DRAINING_LIFESPAN
+			svcLifespan = state.scaled(DRAINING_LIFESPAN)
 		}
 		// Everything that is not tombstoned needs to be considered for
 		// removal if it exceeds the allowed ALIVE_TIMESPAN
 		if !svc.IsTombstone() &&
-			svc.Updated.Before(time.Now().UTC().Add(0-svcLifespan)) {
+			updated.Before(state.now().Add(0-svcLifespan)) {
 			log.Warnf("Found expired service %s ID %s from %s, tombstoning",
 				svc.Name, svc.ID, svc.Hostname,
 			)
@@ -679,6 +1271,10 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 		}
 	})
 
+	if expired > 0 {
+		state.compactStrings()
+	}
+
 	return result
 }
 
@@ -701,7 +1297,7 @@ func (state *ServicesState) TombstoneServices(hostname string, containerList []s
 		if _, ok := mapping[id]; !ok && !svc.IsTombstone() {
 			log.Warnf("Tombstoning %s", svc.ID)
 			previousStatus := svc.Status
-			svc.Tombstone()
+			svc.TombstoneAt(state.now())
 			state.ServiceChanged(svc, previousStatus, svc.Updated)
 
 			// Tombstone each record twice to help with receipt
@@ -734,8 +1330,20 @@ func (state *ServicesState) EachService(fn func(hostname *string, serviceId *str
 }
 
 // Group the services into a map by service name rather than by the
-// hosts they run on.
+// hosts they run on. Services are also listed under their aliases.
 func (state *ServicesState) ByService() map[string][]*service.Service {
+	serviceMap := state.ByServiceWithoutAliases()
+
+	for alias, name := range AliasOwners(serviceMap) {
+		serviceMap[alias] = append([]*service.Service(nil), serviceMap[name]...)
+	}
+
+	return serviceMap
+}
+
+// Like ByService() but only lists services under their own names. Use this
+// when each instance should only be counted once.
+func (state *ServicesState) ByServiceWithoutAliases() map[string][]*service.Service {
 	serviceMap := make(map[string][]*service.Service)
 
 	state.EachServiceSorted(
@@ -2,7 +2,9 @@ package catalog
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -10,9 +12,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
-	log "github.com/sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -175,7 +177,7 @@ func Test_ServicesStateWithData(t *testing.T) {
 			})
 
 			Convey("Updates the LastUpdated time for the server", func() {
-				newDate := svc.Updated.AddDate(0, 0, 5)
+				newDate := svc.Updated.Add(5 * time.Second)
 				svc.Updated = newDate
 				state.AddServiceEntry(svc)
 
@@ -256,6 +258,7 @@ func Test_ServicesStateWithData(t *testing.T) {
 			})
 
 			Convey("Doesn't mark a DRAINING service as ALIVE", func() {
+				state.Hostname = anotherHostname
 				svc.Status = service.DRAINING
 				state.AddServiceEntry(svc)
 
@@ -268,6 +271,46 @@ func Test_ServicesStateWithData(t *testing.T) {
 				So(state.Servers[anotherHostname].Services[svc.ID].Status,
 					ShouldEqual, service.DRAINING)
 			})
+
+			Convey("Marks another host's DRAINING service ALIVE when it's undrained", func() {
+				svc.Status = service.DRAINING
+				state.AddServiceEntry(svc)
+
+				svc.Status = service.ALIVE
+				svc.Updated = time.Now().UTC()
+
+				state.AddServiceEntry(svc)
+
+				So(state.Servers[anotherHostname].Services[svc.ID].Status,
+					ShouldEqual, service.ALIVE)
+			})
+		})
+
+		Convey("GetServiceByID()", func() {
+			Convey("Returns a service from any host", func() {
+				state.AddServiceEntry(svc)
+
+				returnedSvc, err := state.GetServiceByID(svc.ID)
+				So(err, ShouldBeNil)
+				So(returnedSvc.Hostname, ShouldEqual, svc.Hostname)
+			})
+
+			Convey("Prefers the most recently updated copy", func() {
+				state.AddServiceEntry(svc)
+				moved := svc
+				moved.Hostname = "beowulf"
+				moved.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(moved)
+
+				returnedSvc, err := state.GetServiceByID(svc.ID)
+				So(err, ShouldBeNil)
+				So(returnedSvc.Hostname, ShouldEqual, "beowulf")
+			})
+
+			Convey("Returns an error for a non-existent service ID", func() {
+				_, err := state.GetServiceByID("missing")
+				So(err, ShouldNotBeNil)
+			})
 		})
 
 		Convey("GetLocalServiceByID()", func() {
@@ -293,6 +336,7 @@ func Test_ServicesStateWithData(t *testing.T) {
 				_, err := state.GetLocalServiceByID("missing")
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, "not found")
+				So(errors.Is(err, ErrServiceNotFound), ShouldBeTrue)
 			})
 		})
 
@@ -301,12 +345,26 @@ func Test_ServicesStateWithData(t *testing.T) {
 			secondState := NewServicesState()
 			firstState.AddServiceEntry(svc)
 			secondState.Merge(firstState)
-			secondState.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+			secondState.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 
 			So(len(secondState.Servers), ShouldEqual, len(firstState.Servers))
 			So(secondState.Servers[svcId], ShouldEqual, firstState.Servers[svcId])
 		})
 
+		Convey("ProcessServiceMsgs() returns when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+
+			go func() {
+				state.ProcessServiceMsgs(ctx, director.NewFreeLooper(director.FOREVER, nil))
+				close(done)
+			}()
+
+			cancel()
+
+			So(func() { <-done }, ShouldNotPanic)
+		})
+
 		Convey("Format() pretty-prints the state even without a Memberlist", func() {
 			formatted := state.Format(nil)
 
@@ -352,10 +410,55 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			So(len(state.Broadcasts), ShouldEqual, 5)
 		})
 
+		Convey("Retransmissions are still all sent with jitter", func() {
+			looper := director.NewFreeLooper(5, make(chan error))
+			state.Broadcasts = make(chan [][]byte, 5)
+			state.BroadcastJitter = 1 * time.Millisecond
+			state.tombstoneRetransmit = 1 * time.Millisecond
+			state.SendServices(services, looper)
+			err := looper.Wait()
+			So(err, ShouldBeNil)
+
+			So(len(state.Broadcasts), ShouldEqual, 5)
+		})
+
+		Convey("sleepJitter() stops waiting when the context is cancelled", func() {
+			state.BroadcastJitter = time.Hour
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(time.Millisecond, cancel)
+
+			started := time.Now()
+			So(state.sleepJitter(ctx, time.Hour), ShouldBeFalse)
+			So(time.Since(started), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("BroadcastTombstones() doesn't run a pass once it's cancelled", func() {
+			state.BroadcastJitter = time.Hour
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			called := false
+			looper := director.NewFreeLooper(director.ONCE, nil)
+			state.BroadcastTombstones(ctx, func() []service.Service { called = true; return nil }, looper)
+
+			So(called, ShouldBeFalse)
+		})
+
+		Convey("jitter() stays within the configured bounds", func() {
+			So(state.jitter(time.Second), ShouldEqual, 0)
+
+			state.BroadcastJitter = 10 * time.Millisecond
+			for i := 0; i < 100; i++ {
+				So(state.jitter(time.Second), ShouldBeLessThan, 10*time.Millisecond)
+				So(state.jitter(time.Millisecond), ShouldBeLessThan, time.Millisecond)
+				So(state.jitter(time.Millisecond), ShouldBeGreaterThanOrEqualTo, 0)
+			}
+		})
+
 		Convey("All of the services are added to state", func() {
 			looper := director.NewFreeLooper(1, make(chan error))
-			go state.TrackNewServices(containerFn, looper)
-			state.ProcessServiceMsgs(director.NewFreeLooper(2, nil))
+			go state.TrackNewServices(context.Background(), containerFn, looper)
+			state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(2, nil))
 			err := looper.Wait()
 			So(err, ShouldBeNil)
 
@@ -366,10 +469,10 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 		})
 
 		Convey("New services are serialized into the channel", func() {
-			go state.BroadcastServices(containerFn, looper)
+			go state.BroadcastServices(context.Background(), containerFn, looper)
 
-			json1, _ := json.Marshal(service1)
-			json2, _ := json.Marshal(service2)
+			json1, _ := service1.Encode()
+			json2, _ := service2.Encode()
 
 			readBroadcasts := <-state.Broadcasts
 			So(len(readBroadcasts), ShouldEqual, 2)
@@ -379,7 +482,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 
 		Convey("Puts a nil into the broadcasts channel when no services", func() {
 			emptyList := func() []service.Service { return []service.Service{} }
-			go state.BroadcastServices(emptyList, looper)
+			go state.BroadcastServices(context.Background(), emptyList, looper)
 			broadcast := <-state.Broadcasts
 
 			So(broadcast, ShouldBeNil)
@@ -390,13 +493,13 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			state.AddServiceEntry(junk)
 			state.AddServiceEntry(service1)
 			state.AddServiceEntry(service2)
-			go state.BroadcastTombstones(containerFn, looper)
+			go state.BroadcastTombstones(context.Background(), containerFn, looper)
 
 			readBroadcasts := <-state.Broadcasts
 			So(len(readBroadcasts), ShouldEqual, 2) // 2 per service
 			// Match with regexes since the timestamp changes during tombstoning
-			So(readBroadcasts[0], ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
-			So(readBroadcasts[1], ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
+			So(readBroadcasts[0], ShouldMatch, "^{ ?\"ID\":\"runs\".*\"Status\":1}$")
+			So(readBroadcasts[1], ShouldMatch, "^{ ?\"ID\":\"runs\".*\"Status\":1}$")
 		})
 
 		Convey("The timestamp is incremented on each subsequent service broadcast background run", func() {
@@ -427,7 +530,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			lastChanged := state.LastChanged
 			junk := service.Service{ID: "runs", Hostname: hostname, Updated: baseTime}
 			state.AddServiceEntry(junk)
-			go state.BroadcastTombstones(containerFn, looper)
+			go state.BroadcastTombstones(context.Background(), containerFn, looper)
 
 			<-state.Broadcasts
 			So(state.LastChanged.After(lastChanged), ShouldBeTrue)
@@ -437,15 +540,26 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 		Convey("Services that are still alive are not tombstoned", func() {
 			state.AddServiceEntry(service1)
 			state.AddServiceEntry(service2)
-			go state.BroadcastTombstones(containerFn, looper)
+			go state.BroadcastTombstones(context.Background(), containerFn, looper)
 
 			readBroadcasts := <-state.Broadcasts
 			So(len(readBroadcasts), ShouldEqual, 0)
 		})
 
+		Convey("Local services are not tombstoned while discovery is unhealthy", func() {
+			junk := service.Service{ID: "runs", Hostname: hostname, Updated: baseTime}
+			state.AddServiceEntry(junk)
+			state.DiscoveryHealthy = func() bool { return false }
+			go state.BroadcastTombstones(context.Background(), containerFn, looper)
+
+			broadcast := <-state.Broadcasts
+			So(broadcast, ShouldBeNil)
+			So(state.Servers[hostname].Services["runs"].IsAlive(), ShouldBeTrue)
+		})
+
 		Convey("Puts a nil into the broadcasts channel when no tombstones", func() {
 			emptyList := func() []service.Service { return []service.Service{} }
-			go state.BroadcastTombstones(emptyList, looper)
+			go state.BroadcastTombstones(context.Background(), emptyList, looper)
 			broadcast := <-state.Broadcasts
 
 			So(broadcast, ShouldBeNil)
@@ -458,7 +572,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			state.AddServiceEntry(service2)
 			So(state.Servers[hostname].Services[service1.ID], ShouldNotBeNil)
 
-			go state.BroadcastTombstones(containerFn, looper)
+			go state.BroadcastTombstones(context.Background(), containerFn, looper)
 			<-state.Broadcasts
 
 			So(state.Servers[hostname].Services[service1.ID], ShouldBeNil)
@@ -506,6 +620,17 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			So(state.Servers[hostname].LastChanged.After(lastChanged), ShouldBeTrue)
 		})
 
+		Convey("Lifespans are shortened by the TimeScale", func() {
+			state.TimeScale = 10
+			state.AddServiceEntry(service1)
+			svc := state.Servers[hostname].Services[service1.ID]
+			svc.Updated = service1.Updated.Add(0 - ALIVE_LIFESPAN/10 - 5*time.Second)
+
+			state.TombstoneOthersServices()
+
+			So(svc.Status, ShouldEqual, service.TOMBSTONE)
+		})
+
 		Convey("Draining services are not tombstoned before their lifespan expires", func() {
 			lastChanged := state.Servers[hostname].LastChanged
 			service1.Status = service.DRAINING
@@ -603,6 +728,23 @@ func Test_Listeners(t *testing.T) {
 			err := state.RemoveListener("dummyListener")
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "no listener found with the name")
+			So(errors.Is(err, ErrListenerNotFound), ShouldBeTrue)
+		})
+
+		Convey("AddUniqueListener() doesn't replace a listener with the same name", func() {
+			So(state.AddUniqueListener(listener), ShouldBeNil)
+
+			replacement := &mockListener{"listener1", make(chan ChangeEvent, 1), false}
+			err := state.AddUniqueListener(replacement)
+			So(errors.Is(err, ErrListenerExists), ShouldBeTrue)
+			So(state.listeners["listener1"], ShouldEqual, listener)
+		})
+
+		Convey("AddUniqueListener() returns an error for non-buffered channels", func() {
+			badListener := &mockListener{"badListener", make(chan ChangeEvent), false}
+			err := state.AddUniqueListener(badListener)
+			So(errors.Is(err, ErrInvalidListener), ShouldBeTrue)
+			So(len(state.listeners), ShouldEqual, 0)
 		})
 
 		Convey("A major state change event notifies all listeners", func() {
@@ -625,6 +767,64 @@ func Test_Listeners(t *testing.T) {
 			So(result2.Service.Hostname, ShouldEqual, hostname)
 		})
 
+		Convey("AddListener() can replay the current state", func() {
+			svc2 := service.Service{ID: "deadbeef101", Hostname: hostname, Updated: baseTime, Status: service.DRAINING}
+			svc3 := service.Service{ID: "deadbeef105", Hostname: hostname, Updated: baseTime, Status: service.TOMBSTONE}
+			state.AddServiceEntry(svc1)
+			state.AddServiceEntry(svc2)
+			state.AddServiceEntry(svc3)
+
+			replayListener := &mockListener{"replay", make(chan ChangeEvent, 5), false}
+			state.AddListener(replayListener, WithReplay())
+
+			So(len(state.listeners), ShouldEqual, 1)
+			So(len(replayListener.Chan()), ShouldEqual, 2)
+
+			event := <-replayListener.Chan()
+			So(event.PreviousStatus, ShouldEqual, service.UNKNOWN)
+			So(event.Service.ID, ShouldBeIn, []string{svc1.ID, svc2.ID})
+		})
+
+		Convey("AddListener() doesn't replay records that changed since the snapshot", func() {
+			svc2 := service.Service{ID: "deadbeef101", Hostname: hostname, Updated: baseTime}
+			svc3 := service.Service{ID: "deadbeef105", Hostname: hostname, Updated: baseTime}
+			state.AddServiceEntry(svc1)
+			state.AddServiceEntry(svc2)
+			state.AddServiceEntry(svc3)
+			events := state.replayEvents()
+
+			// Flipped and flipped back, refreshed, and tombstoned
+			svc1.Updated = baseTime.Add(time.Second)
+			svc1.Status = service.UNHEALTHY
+			state.AddServiceEntry(svc1)
+			svc1.Updated = baseTime.Add(2 * time.Second)
+			svc1.Status = service.ALIVE
+			state.AddServiceEntry(svc1)
+			svc2.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc2)
+			svc3.Updated = baseTime.Add(time.Second)
+			svc3.Status = service.TOMBSTONE
+			state.AddServiceEntry(svc3)
+
+			replayListener := &mockListener{"replay", make(chan ChangeEvent, 5), false}
+			state.replayToListener(replayListener, events)
+
+			So(len(replayListener.Chan()), ShouldEqual, 2)
+			for i := 0; i < 2; i++ {
+				event := <-replayListener.Chan()
+				So(event.Service.ID, ShouldBeIn, []string{svc1.ID, svc2.ID})
+				So(event.Service.Updated, ShouldBeTheSameTimeAs, state.Servers[hostname].Services[event.Service.ID].Updated)
+				So(event.Time, ShouldBeTheSameTimeAs, event.Service.Updated)
+			}
+		})
+
+		Convey("AddListener() doesn't replay by default", func() {
+			state.AddServiceEntry(svc1)
+			state.AddListener(listener)
+
+			So(len(listener.Chan()), ShouldEqual, 0)
+		})
+
 		Convey("GetListeners() returns all the listeners", func() {
 			state.AddListener(listener)
 			state.AddListener(listener2)
@@ -641,7 +841,7 @@ func Test_Listeners(t *testing.T) {
 			Convey("Adds new listeners that are discovered", func() {
 				looper := director.NewFreeLooper(director.ONCE, nil)
 				listeners := []Listener{listener, listener2}
-				state.TrackLocalListeners(func() []Listener { return listeners }, looper)
+				state.TrackLocalListeners(context.Background(), func() []Listener { return listeners }, looper)
 
 				So(len(state.listeners), ShouldEqual, 2)
 			})
@@ -654,14 +854,14 @@ func Test_Listeners(t *testing.T) {
 				listener.managed = true
 				listener2.managed = true
 
-				state.TrackLocalListeners(listenFunc, looper)
+				state.TrackLocalListeners(context.Background(), listenFunc, looper)
 				So(len(state.listeners), ShouldEqual, 2)
 
 				// Discovery now returns only the first one
 				listeners = []Listener{listener}
 				looper = director.NewFreeLooper(director.ONCE, nil)
 
-				state.TrackLocalListeners(listenFunc, looper)
+				state.TrackLocalListeners(context.Background(), listenFunc, looper)
 				So(len(state.listeners), ShouldEqual, 1)
 
 				found, ok := state.listeners[listener.Name()]
@@ -702,8 +902,8 @@ func Test_ClusterMembershipManagement(t *testing.T) {
 
 				So(len(expired), ShouldEqual, 2)
 				// Timestamps chagne when tombstoning, so regex match
-				So(expired[0], ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
-				So(expired[1], ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
+				So(expired[0], ShouldMatch, "^{ ?\"ID\":\"deadbeef.*\"Status\":1}$")
+				So(expired[1], ShouldMatch, "^{ ?\"ID\":\"deadbeef.*\"Status\":1}$")
 
 				Convey("and sends the tombstones to any listener", func() {
 					for i := 0; i < len(state.Servers[hostname].Services); i++ {
@@ -832,7 +1032,7 @@ func ExampleServicesState_BroadcastTombstones() {
 	looper := director.NewTimedLooper(1, 1*time.Nanosecond, nil)
 
 	go func() { <-state.Broadcasts }()
-	state.BroadcastTombstones(func() []service.Service { return []service.Service{} }, looper)
+	state.BroadcastTombstones(context.Background(), func() []service.Service { return []service.Service{} }, looper)
 
 	// TODO go test seems broken. It should match this, but can't for some reason:
 	// XXX it can't see output generated _by_ the test code itself
@@ -873,3 +1073,180 @@ func LogCapture(fn func()) string {
 
 	return capture.String()
 }
+
+func Test_RemoteChecks(t *testing.T) {
+	Convey("When merging remote check results", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.Broadcasts = make(chan [][]byte, 10) // Holds the retransmits of the remote services, too
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		local := service.Service{ID: "deadbeef123", Hostname: hostname, Updated: baseTime}
+		remote := service.Service{ID: "deadbeef101", Hostname: anotherHostname, Updated: baseTime}
+		draining := service.Service{
+			ID: "deadbeef105", Hostname: anotherHostname, Updated: baseTime, Status: service.DRAINING,
+		}
+
+		state.AddServiceEntry(local)
+		state.AddServiceEntry(remote)
+		state.AddServiceEntry(draining)
+
+		Convey("RemoteServices() only returns checkable services from other hosts", func() {
+			services := state.RemoteServices()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldEqual, remote.ID)
+		})
+
+		Convey("Results are gossiped rather than amending the owner's record", func() {
+			checked := remote
+			checked.Status = service.UNHEALTHY
+
+			looper := director.NewFreeLooper(director.ONCE, nil)
+			state.TrackRemoteChecks(context.Background(), func() []service.Service { return []service.Service{checked} }, looper)
+
+			So(len(state.ServiceMsgs), ShouldEqual, 0)
+
+			var check *RemoteCheck
+			for check == nil {
+				message := <-state.Broadcasts
+				var err error
+				check, err = DecodeRemoteCheck(message[0])
+				So(err, ShouldBeNil)
+			}
+			So(check.ID, ShouldEqual, remote.ID)
+			So(check.Hostname, ShouldEqual, anotherHostname)
+			So(check.Checker, ShouldEqual, hostname)
+			So(check.Status, ShouldEqual, service.UNHEALTHY)
+
+			stored, _ := state.GetServiceByID(remote.ID)
+			So(stored.Status, ShouldEqual, service.ALIVE)
+			So(stored.Updated, ShouldBeTheSameTimeAs, baseTime)
+		})
+
+		Convey("Unchecked services are left alone", func() {
+			unknown := remote
+			unknown.Status = service.UNKNOWN
+
+			looper := director.NewFreeLooper(director.ONCE, nil)
+			state.TrackRemoteChecks(context.Background(), func() []service.Service {
+				return []service.Service{unknown}
+			}, looper)
+
+			So(len(state.ServiceMsgs), ShouldEqual, 0)
+			So(state.remoteChecks, ShouldBeEmpty)
+		})
+
+		Convey("Unchanged results are only repeated every REMOTE_CHECK_REFRESH", func() {
+			frozen := clock.NewFrozen(baseTime)
+			state.Clock = frozen
+
+			looper := director.NewFreeLooper(3, nil)
+			passes := 0
+			state.TrackRemoteChecks(context.Background(), func() []service.Service {
+				passes++
+				if passes == 3 {
+					frozen.Advance(REMOTE_CHECK_REFRESH)
+				}
+				return []service.Service{remote}
+			}, looper)
+
+			So(state.remoteChecks[remote.ID].check.Time, ShouldBeTheSameTimeAs, baseTime.Add(REMOTE_CHECK_REFRESH))
+		})
+	})
+}
+
+func Test_DrainLocalService(t *testing.T) {
+	Convey("When draining local services one at a time", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		baseTime := time.Now().UTC().Add(-time.Minute).Round(time.Second)
+
+		staging := map[string]string{"env": "staging"}
+		state.AddServiceEntry(service.Service{ID: "deadbeef002", Hostname: hostname, Updated: baseTime, Tags: staging})
+		state.AddServiceEntry(service.Service{ID: "deadbeef001", Hostname: hostname, Updated: baseTime, Tags: staging})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef003", Hostname: hostname, Updated: baseTime, Tags: staging, Status: service.DRAINING,
+		})
+		state.AddServiceEntry(service.Service{ID: "deadbeef101", Hostname: anotherHostname, Updated: baseTime, Tags: staging})
+
+		selector, err := service.ParseSelector("env=staging")
+		So(err, ShouldBeNil)
+
+		Convey("DrainableLocalServices() lists the local ones that aren't on their way out", func() {
+			So(state.DrainableLocalServices(selector), ShouldResemble, []string{"deadbeef001", "deadbeef002"})
+		})
+
+		Convey("DrainLocalService() drains the service", func() {
+			version := state.Version()
+
+			drained, err := state.DrainLocalService("deadbeef001", time.Hour)
+			So(err, ShouldBeNil)
+			So(drained.Status, ShouldEqual, service.DRAINING)
+
+			// It's applied before we return, not queued
+			So(len(state.ServiceMsgs), ShouldEqual, 0)
+			stored, err := state.GetLocalServiceByID("deadbeef001")
+			So(err, ShouldBeNil)
+			So(stored.Status, ShouldEqual, service.DRAINING)
+			So(state.Version(), ShouldBeGreaterThan, version)
+			So(state.drainDeadlines, ShouldContainKey, "deadbeef001")
+		})
+
+		Convey("DrainLocalService() errors on services that are gone or already draining", func() {
+			_, err := state.DrainLocalService("deadbeef101", time.Hour)
+			So(errors.Is(err, ErrServiceNotFound), ShouldBeTrue)
+
+			_, err = state.DrainLocalService("deadbeef003", time.Hour)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "already Draining")
+			So(len(state.ServiceMsgs), ShouldEqual, 0)
+		})
+
+		Convey("UndrainLocalService() puts the service back to ALIVE", func() {
+			_, err := state.DrainLocalService("deadbeef001", time.Hour)
+			So(err, ShouldBeNil)
+
+			undrained, err := state.UndrainLocalService("deadbeef001")
+			So(err, ShouldBeNil)
+			So(undrained.Status, ShouldEqual, service.ALIVE)
+
+			stored, err := state.GetLocalServiceByID("deadbeef001")
+			So(err, ShouldBeNil)
+			So(stored.Status, ShouldEqual, service.ALIVE)
+			So(state.drainDeadlines, ShouldNotContainKey, "deadbeef001")
+
+			Convey("and it can be drained again", func() {
+				_, err := state.DrainLocalService("deadbeef001", time.Hour)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("UndrainLocalService() errors on services that aren't draining", func() {
+			_, err := state.UndrainLocalService("deadbeef101")
+			So(errors.Is(err, ErrServiceNotFound), ShouldBeTrue)
+
+			_, err = state.UndrainLocalService("deadbeef002")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not Draining")
+		})
+
+		Convey("UndrainLocalServices() undrains the matching local services", func() {
+			undrained := state.UndrainLocalServices(selector)
+			So(undrained, ShouldHaveLength, 1)
+			So(undrained[0].ID, ShouldEqual, "deadbeef003")
+
+			stored, err := state.GetLocalServiceByID("deadbeef003")
+			So(err, ShouldBeNil)
+			So(stored.Status, ShouldEqual, service.ALIVE)
+
+			Convey("and discovery doesn't put it back to DRAINING", func() {
+				svc := stored
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+
+				stored, _ := state.GetLocalServiceByID("deadbeef003")
+				So(stored.Status, ShouldEqual, service.ALIVE)
+			})
+		})
+	})
+}
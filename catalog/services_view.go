@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"github.com/armon/go-metrics"
+)
+
+// A servicesView is the copy of the state that ServicesView() hands out,
+// along with the versions it was built from
+type servicesView struct {
+	state          *ServicesState
+	version        uint64
+	records        uint64
+	serverVersions map[string]uint64 // The version each server copy was made at
+	serverRecords  map[string]uint64 // And the records count, see recordReplaced()
+}
+
+// ServicesView returns a read-only copy of the servers and services, like
+// SnapshotServices(), for the proxies and templates that render the whole
+// catalog on every change. Rather than copying everything each time, the
+// same copy is shared between callers until the state changes, and then only
+// the servers that changed are copied again. That keeps the time
+// we hold the state lock down to the servers that changed, and rendering
+// never holds it at all.
+//
+// Because it is shared, the view must never be changed. Use
+// SnapshotServices() for a copy you can modify. A service announced again
+// with a new Updated time or LastCheck doesn't bump the version, but still
+// gets its server copied again. Handles locking the state.
+func (state *ServicesState) ServicesView() *ServicesState {
+	state.viewLock.Lock()
+	defer state.viewLock.Unlock()
+
+	state.RLock()
+	defer state.RUnlock()
+
+	previous := state.view
+	if previous != nil && previous.version == state.version && previous.records == state.records {
+		metrics.IncrCounter([]string{"services_state", "view", "hits"}, 1)
+		return previous.state
+	}
+
+	view := &servicesView{
+		version:        state.version,
+		records:        state.records,
+		serverVersions: make(map[string]uint64, len(state.Servers)),
+		serverRecords:  make(map[string]uint64, len(state.Servers)),
+	}
+	servers := make(map[string]*Server, len(state.Servers))
+
+	copied := 0
+	for hostname, server := range state.Servers {
+		version := state.serverVersions[hostname]
+		records := state.serverRecords[hostname]
+		view.serverVersions[hostname] = version
+		view.serverRecords[hostname] = records
+
+		if previous != nil {
+			old, ok := previous.state.Servers[hostname]
+			if ok && previous.serverVersions[hostname] == version && previous.serverRecords[hostname] == records {
+				servers[hostname] = old
+				continue
+			}
+		}
+
+		servers[hostname] = copyServer(server)
+		copied++
+	}
+
+	view.state = state.readOnlyCopy(servers)
+	state.view = view
+
+	metrics.IncrCounter([]string{"services_state", "view", "servers_copied"}, float32(copied))
+
+	return view.state
+}
@@ -0,0 +1,79 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServicesView(t *testing.T) {
+	Convey("ServicesView()", t, func() {
+		state := NewServicesState()
+		state.Hostname = anotherHostname
+		state.ClusterName = "default"
+		state.CheckMaxAge = time.Minute
+		baseTime := time.Now().UTC().Round(time.Second)
+		ports := []service.Port{{Type: "tcp", Port: 1234}}
+
+		svc1 := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: hostname, Updated: baseTime, Ports: ports}
+		svc2 := service.Service{ID: "deadbeef105", Name: "hrothgar", Hostname: anotherHostname, Updated: baseTime, Ports: ports}
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		view := state.ServicesView()
+
+		Convey("copies the services and the settings for reading them", func() {
+			So(view, ShouldNotEqual, state)
+			So(view.ClusterName, ShouldEqual, "default")
+			So(view.CheckMaxAge, ShouldEqual, time.Minute)
+			So(len(view.ByService()), ShouldEqual, 2)
+			So(view.Servers[hostname].Services[svc1.ID], ShouldNotEqual, state.Servers[hostname].Services[svc1.ID])
+		})
+
+		Convey("is shared until the state changes", func() {
+			So(state.ServicesView(), ShouldEqual, view)
+
+			svc1.Status = service.UNHEALTHY
+			svc1.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc1)
+
+			changed := state.ServicesView()
+			So(changed, ShouldNotEqual, view)
+			So(changed.Servers[hostname].Services[svc1.ID].Status, ShouldEqual, service.UNHEALTHY)
+			So(view.Servers[hostname].Services[svc1.ID].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("only copies the servers that changed", func() {
+			svc1.Status = service.UNHEALTHY
+			svc1.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc1)
+
+			changed := state.ServicesView()
+			So(changed.Servers[hostname], ShouldNotEqual, view.Servers[hostname])
+			So(changed.Servers[anotherHostname], ShouldEqual, view.Servers[anotherHostname])
+		})
+
+		Convey("picks up services announced again with the same status", func() {
+			svc1.Updated = baseTime.Add(time.Second)
+			svc1.LastCheck = &service.CheckInfo{Time: baseTime}
+			state.AddServiceEntry(svc1)
+
+			live := state.Servers[hostname].Services[svc1.ID]
+			changed := state.ServicesView()
+			So(changed, ShouldNotEqual, view)
+			So(changed.Servers[hostname].Services[svc1.ID].Updated, ShouldEqual, live.Updated)
+			So(changed.Servers[hostname].Services[svc1.ID].LastCheck.Time, ShouldEqual, baseTime)
+			So(changed.IsServable(changed.Servers[hostname].Services[svc1.ID]), ShouldBeTrue)
+			So(changed.Servers[anotherHostname], ShouldEqual, view.Servers[anotherHostname])
+		})
+
+		Convey("picks up new servers", func() {
+			svc3 := service.Service{ID: "deadbeef999", Name: "wiglaf", Hostname: "heorot", Updated: baseTime, Ports: ports}
+			state.AddServiceEntry(svc3)
+
+			So(state.ServicesView().ByService(), ShouldContainKey, "wiglaf")
+		})
+	})
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"errors"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// A Snapshot is a copy of the whole catalog, taken so that the topology can
+// be restored into a replacement cluster if we lose this one.
+type Snapshot struct {
+	ClusterName string
+	Hostname    string // The node the snapshot was taken on
+	Taken       time.Time
+	Servers     map[string]*Server
+}
+
+// Snapshot returns a copy of the catalog that shares nothing with the state.
+// Handles locking the state.
+func (state *ServicesState) Snapshot() *Snapshot {
+	state.RLock()
+	defer state.RUnlock()
+
+	return &Snapshot{
+		ClusterName: state.ClusterName,
+		Hostname:    state.Hostname,
+		Taken:       state.now(),
+		Servers:     state.copyServers(),
+	}
+}
+
+// SnapshotServices returns a copy of the servers and services in the state,
+// for callers that do expensive work with them like rendering templates or
+// encoding JSON. Only the state lock is held while copying, so the work can
+// be done without it and doesn't hold up gossip. The copy is a ServicesState
+// so that ByService() and friends work on it as usual, but it only has the
+// Servers and the settings that reading them needs filled in. It belongs to
+// the caller and needs no locking. See ServicesView() for a copy that's
+// shared. Handles locking the state.
+func (state *ServicesState) SnapshotServices() *ServicesState {
+	state.RLock()
+	defer state.RUnlock()
+
+	return state.readOnlyCopy(state.copyServers())
+}
+
+// readOnlyCopy wraps copied servers in a ServicesState with the settings that
+// IsServable() and the other read-only methods need. Note: not synchronized!
+func (state *ServicesState) readOnlyCopy(servers map[string]*Server) *ServicesState {
+	checkSkews := make(map[string]time.Duration, len(servers))
+	for hostname := range servers {
+		checkSkews[hostname] = state.checkSkew(hostname)
+	}
+
+	return &ServicesState{
+		Servers:     servers,
+		Hostname:    state.Hostname,
+		ClusterName: state.ClusterName,
+		LastChanged: state.LastChanged,
+		CheckMaxAge: state.CheckMaxAge,
+		Clock:       state.Clock,
+		checkSkews:  checkSkews,
+	}
+}
+
+// copyServers copies the servers and services so that changes to the state
+// don't show through. Note: not synchronized!
+func (state *ServicesState) copyServers() map[string]*Server {
+	servers := make(map[string]*Server, len(state.Servers))
+	for hostname, server := range state.Servers {
+		servers[hostname] = copyServer(server)
+	}
+
+	return servers
+}
+
+// copyServer copies a server and its services. The services share their
+// ports, tags and other slices and maps with the state, which are replaced
+// and never changed in place.
+func copyServer(server *Server) *Server {
+	copied := *server
+	copied.Services = make(map[string]*service.Service, len(server.Services))
+	for id, svc := range server.Services {
+		svcCopy := *svc
+		copied.Services[id] = &svcCopy
+	}
+
+	return &copied
+}
+
+// ImportSnapshot adds the services from a snapshot to the catalog, which
+// then announces them to the cluster. Their timestamps are moved forward by
+// the age of the snapshot, so they are as fresh as when it was taken rather
+// than expired on arrival. Anything the owner doesn't announce again will
+// expire normally. Tombstones and our own services are skipped, because
+// discovery is the authority on what runs here. Returns how many services
+// were imported. Handles locking the state.
+func (state *ServicesState) ImportSnapshot(snapshot *Snapshot) (int, error) {
+	if snapshot == nil || snapshot.Taken.IsZero() {
+		return 0, errors.New("snapshot has no timestamp")
+	}
+
+	age := state.now().Sub(snapshot.Taken)
+	if age < 0 {
+		age = 0 // Taken on a node whose clock is ahead
+	}
+
+	imported := 0
+	for hostname, server := range snapshot.Servers {
+		if server == nil || hostname == state.Hostname {
+			continue
+		}
+
+		for _, svc := range server.Services {
+			if svc == nil || svc.IsTombstone() || svc.Hostname != hostname {
+				continue
+			}
+
+			adjusted := *svc
+			adjusted.Updated = svc.Updated.Add(age)
+
+			state.RLock()
+			var found *service.Service
+			if state.HasServer(hostname) {
+				found = state.Servers[hostname].Services[svc.ID]
+			}
+			stale := found != nil && !adjusted.Invalidates(found)
+			state.RUnlock()
+
+			if stale {
+				continue // We already know something newer
+			}
+
+			state.AddServiceEntry(adjusted)
+			imported++
+		}
+	}
+
+	log.Infof("Imported %d services from a snapshot of %s taken %s ago",
+		imported, snapshot.Hostname, age.Round(time.Second),
+	)
+
+	return imported, nil
+}
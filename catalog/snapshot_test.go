@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Snapshots(t *testing.T) {
+	Convey("When working with snapshots", t, func() {
+		state := NewServicesState()
+		state.Hostname = anotherHostname
+		state.ClusterName = "default"
+		baseTime := time.Now().UTC().Round(time.Second)
+		ports := []service.Port{{Type: "tcp", Port: 1234}}
+
+		svc1 := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: hostname, Updated: baseTime, Ports: ports}
+		svc2 := service.Service{ID: "deadbeef101", Name: "grendel", Hostname: hostname, Updated: baseTime, Ports: ports}
+		svc3 := service.Service{ID: "deadbeef105", Name: "hrothgar", Hostname: anotherHostname, Updated: baseTime, Ports: ports}
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+		state.AddServiceEntry(svc3)
+
+		Convey("Snapshot() copies the whole catalog", func() {
+			snapshot := state.Snapshot()
+
+			So(snapshot.ClusterName, ShouldEqual, "default")
+			So(snapshot.Hostname, ShouldEqual, anotherHostname)
+			So(snapshot.Taken.IsZero(), ShouldBeFalse)
+			So(len(snapshot.Servers), ShouldEqual, 2)
+			So(snapshot.Servers[hostname].Services[svc1.ID].Name, ShouldEqual, "beowulf")
+
+			snapshot.Servers[hostname].Services[svc1.ID].Name = "changed"
+			So(state.Servers[hostname].Services[svc1.ID].Name, ShouldEqual, "beowulf")
+		})
+
+		Convey("SnapshotServices() copies the services for lock-free reading", func() {
+			snapshot := state.SnapshotServices()
+
+			So(snapshot.Hostname, ShouldEqual, anotherHostname)
+			So(snapshot.ClusterName, ShouldEqual, "default")
+			So(snapshot.LastChanged, ShouldEqual, state.LastChanged)
+			So(len(snapshot.ByService()), ShouldEqual, 3)
+
+			// Changes to the state don't show through
+			state.Servers[hostname].Services[svc1.ID].Tombstone()
+			svc4 := service.Service{ID: "deadbeef999", Name: "wiglaf", Hostname: hostname, Updated: baseTime, Ports: ports}
+			state.AddServiceEntry(svc4)
+
+			So(snapshot.Servers[hostname].Services[svc1.ID].IsAlive(), ShouldBeTrue)
+			So(snapshot.ByService(), ShouldNotContainKey, "wiglaf")
+		})
+
+		Convey("ImportSnapshot()", func() {
+			snapshot := state.Snapshot()
+			// As though it were taken an hour ago
+			snapshot.Taken = snapshot.Taken.Add(-1 * time.Hour)
+			for _, svc := range snapshot.Servers[hostname].Services {
+				svc.Updated = svc.Updated.Add(-1 * time.Hour)
+			}
+			snapshot.Servers[hostname].Services[svc2.ID].Tombstone()
+
+			replacement := NewServicesState()
+			replacement.Hostname = anotherHostname
+
+			Convey("moves timestamps forward by the age of the snapshot", func() {
+				imported, err := replacement.ImportSnapshot(snapshot)
+
+				So(err, ShouldBeNil)
+				So(imported, ShouldEqual, 1)
+
+				restored := replacement.Servers[hostname].Services[svc1.ID]
+				So(restored, ShouldNotBeNil)
+				So(restored.Updated, ShouldHappenWithin, time.Second, baseTime)
+			})
+
+			Convey("skips tombstones and our own services", func() {
+				replacement.ImportSnapshot(snapshot)
+
+				So(replacement.Servers[hostname].HasService(svc2.ID), ShouldBeFalse)
+				So(replacement.HasServer(anotherHostname), ShouldBeFalse)
+			})
+
+			Convey("doesn't replace newer records", func() {
+				newer := svc1
+				newer.Updated = baseTime.Add(30 * time.Second)
+				newer.Image = "newer"
+				replacement.AddServiceEntry(newer)
+
+				imported, _ := replacement.ImportSnapshot(snapshot)
+
+				So(imported, ShouldEqual, 0)
+				So(replacement.Servers[hostname].Services[svc1.ID].Image, ShouldEqual, "newer")
+			})
+
+			Convey("refuses a snapshot without a timestamp", func() {
+				snapshot.Taken = time.Time{}
+
+				_, err := replacement.ImportSnapshot(snapshot)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
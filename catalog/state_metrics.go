@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+)
+
+const (
+	STATE_METRICS_INTERVAL = 10 * time.Second // How often we report the size and churn of the state
+)
+
+// churnCounters add up what happened to the state since the last report.
+// Updated atomically, so they don't need the state lock.
+type churnCounters struct {
+	changes        int64
+	tombstones     int64
+	broadcastBytes int64
+}
+
+// StateMetrics describes the size of the state, and how fast it's changing
+type StateMetrics struct {
+	Servers                 int
+	Services                map[string]int // By status
+	ChangesPerMinute        float64
+	TombstonesPerMinute     float64
+	BroadcastBytesPerSecond float64
+}
+
+// metricLabels tags the state's metrics with the cluster they came from
+func (state *ServicesState) metricLabels() []metrics.Label {
+	return []metrics.Label{{Name: "cluster", Value: state.ClusterName}}
+}
+
+// recordChange counts a ChangeEvent, and a new tombstone if that's what it is
+func (state *ServicesState) recordChange(svc *service.Service, previousStatus int) {
+	labels := state.metricLabels()
+
+	atomic.AddInt64(&state.churn.changes, 1)
+	metrics.IncrCounterWithLabels([]string{"services_state", "changes"}, 1, labels)
+
+	if svc.Status == service.TOMBSTONE && previousStatus != service.TOMBSTONE {
+		atomic.AddInt64(&state.churn.tombstones, 1)
+		metrics.IncrCounterWithLabels([]string{"services_state", "tombstones_created"}, 1, labels)
+	}
+}
+
+// RecordBroadcast counts the bytes of service records handed to gossip
+func (state *ServicesState) RecordBroadcast(bytes int) {
+	atomic.AddInt64(&state.churn.broadcastBytes, int64(bytes))
+	metrics.IncrCounterWithLabels([]string{"services_state", "broadcast_bytes"}, float32(bytes), state.metricLabels())
+}
+
+// takeMetrics counts the servers and services, and works out the churn
+// rates over the interval since the last call, resetting the counters
+func (state *ServicesState) takeMetrics(interval time.Duration) StateMetrics {
+	result := StateMetrics{Services: make(map[string]int)}
+	for status := service.ALIVE; status <= service.DRAINING; status++ {
+		result.Services[service.StatusString(status)] = 0
+	}
+
+	state.RLock()
+	result.Servers = len(state.Servers)
+	for _, server := range state.Servers {
+		for _, svc := range server.Services {
+			result.Services[svc.StatusString()]++
+		}
+	}
+	state.RUnlock()
+
+	changes := atomic.SwapInt64(&state.churn.changes, 0)
+	tombstones := atomic.SwapInt64(&state.churn.tombstones, 0)
+	broadcastBytes := atomic.SwapInt64(&state.churn.broadcastBytes, 0)
+
+	if interval > 0 {
+		result.ChangesPerMinute = float64(changes) / interval.Minutes()
+		result.TombstonesPerMinute = float64(tombstones) / interval.Minutes()
+		result.BroadcastBytesPerSecond = float64(broadcastBytes) / interval.Seconds()
+	}
+
+	return result
+}
+
+// ReportStateMetrics publishes gauges on the size and churn of the state,
+// tagged with the cluster name, until the looper quits or the context is
+// cancelled. The counters behind the rates are also sent as they happen.
+func (state *ServicesState) ReportStateMetrics(ctx context.Context, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
+	last := time.Now()
+	looper.Loop(func() error {
+		now := time.Now()
+		stats := state.takeMetrics(now.Sub(last))
+		last = now
+
+		labels := state.metricLabels()
+		metrics.SetGaugeWithLabels([]string{"services_state", "servers"}, float32(stats.Servers), labels)
+		for status, count := range stats.Services {
+			metrics.SetGaugeWithLabels(
+				[]string{"services_state", "services"}, float32(count),
+				append(labels, metrics.Label{Name: "status", Value: status}),
+			)
+		}
+		metrics.SetGaugeWithLabels([]string{"services_state", "changes_per_minute"}, float32(stats.ChangesPerMinute), labels)
+		metrics.SetGaugeWithLabels([]string{"services_state", "tombstones_per_minute"}, float32(stats.TombstonesPerMinute), labels)
+		metrics.SetGaugeWithLabels([]string{"services_state", "broadcast_bytes_per_second"}, float32(stats.BroadcastBytesPerSecond), labels)
+
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_StateMetrics(t *testing.T) {
+	Convey("State metrics", t, func() {
+		state := NewServicesState()
+		state.Hostname = "grendel"
+		state.ClusterName = "beowulf"
+
+		now := time.Now().UTC()
+		state.AddServiceEntry(service.Service{ID: "deadbeef123", Hostname: "grendel", Updated: now, Status: service.ALIVE})
+		state.AddServiceEntry(service.Service{ID: "deadbeef456", Hostname: "grendel", Updated: now, Status: service.ALIVE})
+		state.AddServiceEntry(service.Service{ID: "cafebabe789", Hostname: "hrothgar", Updated: now, Status: service.UNHEALTHY})
+
+		Convey("are tagged with the cluster name", func() {
+			labels := state.metricLabels()
+			So(len(labels), ShouldEqual, 1)
+			So(labels[0].Name, ShouldEqual, "cluster")
+			So(labels[0].Value, ShouldEqual, "beowulf")
+		})
+
+		Convey("count the servers and the services by status", func() {
+			stats := state.takeMetrics(time.Minute)
+
+			So(stats.Servers, ShouldEqual, 2)
+			So(stats.Services["Alive"], ShouldEqual, 2)
+			So(stats.Services["Unhealthy"], ShouldEqual, 1)
+			So(stats.Services["Tombstone"], ShouldEqual, 0)
+			So(stats.Services, ShouldContainKey, "Draining")
+		})
+
+		Convey("work out the churn rates and reset them", func() {
+			state.Lock()
+			state.TombstoneServices("grendel", []service.Service{})
+			state.Unlock()
+			state.RecordBroadcast(600)
+
+			stats := state.takeMetrics(30 * time.Second)
+
+			// Three new services and two tombstones
+			So(stats.ChangesPerMinute, ShouldEqual, 10)
+			So(stats.TombstonesPerMinute, ShouldEqual, 4)
+			So(stats.BroadcastBytesPerSecond, ShouldEqual, 20)
+			So(stats.Services["Tombstone"], ShouldEqual, 2)
+
+			stats = state.takeMetrics(30 * time.Second)
+			So(stats.ChangesPerMinute, ShouldEqual, 0)
+			So(stats.TombstonesPerMinute, ShouldEqual, 0)
+			So(stats.BroadcastBytesPerSecond, ShouldEqual, 0)
+		})
+	})
+}
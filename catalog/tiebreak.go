@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+// plainService is a Service without the ffjson methods, so encoding/json
+// encodes it with the map keys sorted and the same content always gives the
+// same bytes
+type plainService service.Service
+
+// contentHash returns a hash of everything in the record
+func contentHash(svc *service.Service) []byte {
+	data, err := json.Marshal(plainService(*svc))
+	if err != nil {
+		// Can't happen with the types in a Service
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// sameContent tells whether two records are the same but for their Updated
+// times
+func sameContent(a *service.Service, b *service.Service) bool {
+	other := *b
+	other.Updated = a.Updated
+	return bytes.Equal(contentHash(a), contentHash(&other))
+}
+
+// sameRecord tells whether two records are identical, without the cost of
+// hashing them. It may miss that records with equal times in different
+// locations are the same, but never says that different records are.
+// Compares every field of a Service, so it needs updating when they change.
+func sameRecord(a *service.Service, b *service.Service) bool {
+	if a.ID != b.ID || a.Name != b.Name || a.Image != b.Image ||
+		a.Hostname != b.Hostname || a.ProxyMode != b.ProxyMode ||
+		a.Status != b.Status || a.TLSCert != b.TLSCert ||
+		a.MinInstances != b.MinInstances || a.Source != b.Source ||
+		a.Created != b.Created || a.Updated != b.Updated {
+		return false
+	}
+
+	if len(a.Ports) != len(b.Ports) || len(a.Tags) != len(b.Tags) ||
+		len(a.Aliases) != len(b.Aliases) || len(a.ListenOn) != len(b.ListenOn) ||
+		len(a.Annotations) != len(b.Annotations) {
+		return false
+	}
+
+	for i := range a.Ports {
+		if a.Ports[i] != b.Ports[i] {
+			return false
+		}
+	}
+
+	for key, value := range a.Tags {
+		if other, ok := b.Tags[key]; !ok || other != value {
+			return false
+		}
+	}
+
+	for i := range a.Aliases {
+		if a.Aliases[i] != b.Aliases[i] {
+			return false
+		}
+	}
+
+	for i := range a.ListenOn {
+		if a.ListenOn[i] != b.ListenOn[i] {
+			return false
+		}
+	}
+
+	for i := range a.Annotations {
+		if a.Annotations[i] != b.Annotations[i] {
+			return false
+		}
+	}
+
+	if (a.Resources == nil) != (b.Resources == nil) ||
+		(a.Resources != nil && *a.Resources != *b.Resources) {
+		return false
+	}
+
+	if (a.LastCheck == nil) != (b.LastCheck == nil) ||
+		(a.LastCheck != nil && *a.LastCheck != *b.LastCheck) {
+		return false
+	}
+
+	return true
+}
+
+// winsTie decides between two different records for the same service with
+// the same Updated time. Neither Invalidates() the other, so without a
+// tiebreaker each node would keep whichever arrived first, and they'd never
+// agree. The record with the greater content hash wins on every node.
+// Identical records never win, so this can't start a retransmit loop.
+//
+// Note: not synchronized!
+func (state *ServicesState) winsTie(newSvc *service.Service, oldSvc *service.Service) bool {
+	if oldSvc == nil || !newSvc.Updated.Equal(oldSvc.Updated) {
+		return false
+	}
+
+	// Nearly every tie is the same record arriving again from another peer,
+	// so we only hash the ones that differ
+	if sameRecord(newSvc, oldSvc) {
+		return false
+	}
+
+	if bytes.Compare(contentHash(newSvc), contentHash(oldSvc)) <= 0 {
+		return false
+	}
+
+	metrics.IncrCounterWithLabels([]string{"services_state", "tiebreaks"}, 1, state.metricLabels())
+	return true
+}
@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Tiebreak(t *testing.T) {
+	Convey("Records with the same Updated time", t, func() {
+		updated := time.Now().UTC().Add(-time.Minute)
+
+		first := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Image:    "beowulf:1",
+			Hostname: "heorot",
+			Updated:  updated,
+			Status:   service.ALIVE,
+			Tags:     map[string]string{"env": "staging", "team": "geats"},
+		}
+		second := first
+		second.Image = "beowulf:2"
+
+		stored := func(records ...service.Service) service.Service {
+			state := NewServicesState()
+			state.Hostname = "grendel"
+			for _, record := range records {
+				state.AddServiceEntry(record)
+			}
+			return *state.Servers["heorot"].Services["deadbeef123"]
+		}
+
+		Convey("end up the same whichever order they arrive in", func() {
+			So(stored(first, second), ShouldResemble, stored(second, first))
+		})
+
+		Convey("are resolved by content, not Status", func() {
+			tombstone := first
+			tombstone.Status = service.TOMBSTONE
+
+			So(stored(first, tombstone), ShouldResemble, stored(tombstone, first))
+		})
+
+		Convey("hash the same however the Tags are built", func() {
+			copied := first
+			copied.Tags = map[string]string{"team": "geats", "env": "staging"}
+
+			So(contentHash(&copied), ShouldResemble, contentHash(&first))
+		})
+
+		Convey("don't replace an identical record", func() {
+			state := NewServicesState()
+			state.AddServiceEntry(first)
+			before := state.Servers["heorot"].Services["deadbeef123"]
+
+			state.AddServiceEntry(first)
+			So(state.Servers["heorot"].Services["deadbeef123"], ShouldEqual, before)
+			So(state.winsTie(&first, before), ShouldBeFalse)
+		})
+
+		Convey("are spotted as identical without hashing", func() {
+			copied := first
+			copied.Tags = map[string]string{"team": "geats", "env": "staging"}
+			copied.Ports = []service.Port{{Type: "tcp", Port: 10234}}
+			copied.LastCheck = &service.CheckInfo{Time: updated}
+			another := copied
+			another.Ports = []service.Port{{Type: "tcp", Port: 10234}}
+			another.LastCheck = &service.CheckInfo{Time: updated}
+
+			So(sameRecord(&copied, &another), ShouldBeTrue)
+
+			state := NewServicesState()
+			So(testing.AllocsPerRun(10, func() { state.winsTie(&copied, &another) }), ShouldEqual, 0)
+
+			for _, change := range []func(*service.Service){
+				func(svc *service.Service) { svc.Image = "beowulf:2" },
+				func(svc *service.Service) { svc.Tags = map[string]string{"env": "prod", "team": "geats"} },
+				func(svc *service.Service) { svc.Ports = []service.Port{{Type: "tcp", Port: 10235}} },
+				func(svc *service.Service) { svc.LastCheck = &service.CheckInfo{Time: updated.Add(time.Second)} },
+				func(svc *service.Service) { svc.LastCheck = nil },
+				func(svc *service.Service) { svc.Aliases = []string{"hrothgar"} },
+			} {
+				changed := another
+				change(&changed)
+				So(sameRecord(&copied, &changed), ShouldBeFalse)
+			}
+
+			// sameRecord() compares each of these
+			So(reflect.TypeOf(service.Service{}).NumField(), ShouldEqual, 18)
+		})
+
+		Convey("still lose to a newer record", func() {
+			newer := first
+			newer.Updated = updated.Add(time.Second)
+
+			So(stored(second, newer).Image, ShouldEqual, "beowulf:1")
+			So(stored(newer, second).Image, ShouldEqual, "beowulf:1")
+		})
+	})
+}
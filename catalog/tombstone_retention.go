@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	TombstoneRetentionTag = "tombstone_retention" // How long the service's tombstones are kept, e.g. "30m"
+	EphemeralTag          = "ephemeral"           // "true" for short-lived services, whose tombstones go sooner
+
+	EPHEMERAL_TOMBSTONE_LIFESPAN = 10 * time.Minute  // How long we keep the tombstones of ephemeral services
+	MIN_TOMBSTONE_LIFESPAN       = DRAINING_LIFESPAN // Any shorter and late gossip about a live instance could bring it back
+)
+
+// tombstoneLifespan is how long we keep the service's tombstones. In order,
+// the service's tombstone_retention tag, the TombstoneRetention for its
+// name, or the EphemeralRetention when it's tagged ephemeral override
+// TOMBSTONE_LIFESPAN. Never shorter than MIN_TOMBSTONE_LIFESPAN. Every node
+// has to agree on this, or the ones that keep tombstones longer will send
+// them to the others on every push/pull, only for them to be dropped.
+func (state *ServicesState) tombstoneLifespan(svc *service.Service) time.Duration {
+	lifespan := TOMBSTONE_LIFESPAN
+
+	if retention, ok := state.TombstoneRetention[svc.Name]; ok {
+		lifespan = retention
+	} else if svc.Tags[EphemeralTag] == "true" {
+		lifespan = state.EphemeralRetention
+		if lifespan == 0 {
+			lifespan = EPHEMERAL_TOMBSTONE_LIFESPAN
+		}
+	}
+
+	if value, ok := svc.Tags[TombstoneRetentionTag]; ok {
+		retention, err := time.ParseDuration(value)
+		if err != nil {
+			logLimiter.Warnf("tombstone-retention:"+svc.Name,
+				"Ignoring invalid %s tag %q on %s", TombstoneRetentionTag, value, svc.Name,
+			)
+		} else {
+			lifespan = retention
+		}
+	}
+
+	if lifespan < MIN_TOMBSTONE_LIFESPAN {
+		lifespan = MIN_TOMBSTONE_LIFESPAN
+	}
+
+	return state.scaled(lifespan)
+}
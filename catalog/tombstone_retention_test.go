@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TombstoneRetention(t *testing.T) {
+	Convey("Tombstone retention", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.TombstoneRetention = map[string]time.Duration{"reports": 30 * time.Minute}
+
+		svc := func(name string, tags map[string]string) *service.Service {
+			return &service.Service{ID: "deadbeef123", Name: name, Hostname: anotherHostname, Tags: tags}
+		}
+
+		Convey("defaults to TOMBSTONE_LIFESPAN", func() {
+			So(state.tombstoneLifespan(svc("bocaccio", nil)), ShouldEqual, TOMBSTONE_LIFESPAN)
+		})
+
+		Convey("is overridden by name", func() {
+			So(state.tombstoneLifespan(svc("reports", nil)), ShouldEqual, 30*time.Minute)
+		})
+
+		Convey("is shorter for ephemeral services", func() {
+			ephemeral := map[string]string{EphemeralTag: "true"}
+			So(state.tombstoneLifespan(svc("batch", ephemeral)), ShouldEqual, EPHEMERAL_TOMBSTONE_LIFESPAN)
+
+			state.EphemeralRetention = 15 * time.Minute
+			So(state.tombstoneLifespan(svc("batch", ephemeral)), ShouldEqual, 15*time.Minute)
+
+			Convey("unless they're overridden by name", func() {
+				So(state.tombstoneLifespan(svc("reports", ephemeral)), ShouldEqual, 30*time.Minute)
+			})
+		})
+
+		Convey("takes the tag over everything else", func() {
+			tags := map[string]string{TombstoneRetentionTag: "20m", EphemeralTag: "true"}
+			So(state.tombstoneLifespan(svc("reports", tags)), ShouldEqual, 20*time.Minute)
+		})
+
+		Convey("ignores invalid tags", func() {
+			tags := map[string]string{TombstoneRetentionTag: "a while"}
+			So(state.tombstoneLifespan(svc("reports", tags)), ShouldEqual, 30*time.Minute)
+		})
+
+		Convey("is never shorter than MIN_TOMBSTONE_LIFESPAN", func() {
+			tags := map[string]string{TombstoneRetentionTag: "1s"}
+			So(state.tombstoneLifespan(svc("bocaccio", tags)), ShouldEqual, MIN_TOMBSTONE_LIFESPAN)
+		})
+
+		Convey("is shortened by the TimeScale", func() {
+			state.TimeScale = 10
+			So(state.tombstoneLifespan(svc("reports", nil)), ShouldEqual, 3*time.Minute)
+		})
+
+		Convey("expires tombstones", func() {
+			batch := *svc("batch", map[string]string{EphemeralTag: "true"})
+			batch.Updated = time.Now().UTC().Add(0 - EPHEMERAL_TOMBSTONE_LIFESPAN + time.Minute)
+			batch.Status = service.TOMBSTONE
+			state.AddServiceEntry(batch)
+
+			state.TombstoneOthersServices()
+			So(state.Servers[anotherHostname].HasService(batch.ID), ShouldBeTrue)
+
+			state.Servers[anotherHostname].Services[batch.ID].Updated =
+				time.Now().UTC().Add(0 - EPHEMERAL_TOMBSTONE_LIFESPAN - time.Minute)
+			state.TombstoneOthersServices()
+			So(state.Servers[anotherHostname], ShouldBeNil)
+		})
+
+		Convey("drops stale gossip about the service", func() {
+			batch := *svc("batch", map[string]string{EphemeralTag: "true"})
+			batch.Updated = time.Now().UTC().Add(0 - EPHEMERAL_TOMBSTONE_LIFESPAN - time.Minute)
+			batch.Status = service.TOMBSTONE
+			state.AddServiceEntry(batch)
+
+			So(state.Servers[anotherHostname], ShouldBeNil)
+		})
+	})
+}
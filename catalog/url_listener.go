@@ -2,33 +2,83 @@ package catalog
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/relistan/go-director"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
-	ClientTimeout  = 3 * time.Second
-	DefaultRetries = 5
+	ClientTimeout        = 3 * time.Second
+	DefaultRetries       = 5
+	DefaultBatchInterval = 250 * time.Millisecond // How long we gather events for one post
+	DefaultFullInterval  = 5 * time.Minute        // How often delta receivers get the whole state anyway
+	MaxRetryAfter        = 30 * time.Second       // The longest we'll honor a receiver's Retry-After
 )
 
+// errResyncRequested is returned when a receiver can't apply a delta and
+// wants the whole state
+var errResyncRequested = errors.New("receiver asked for the full state")
+
+// A backoffError is returned when the receiver is overloaded and asked us
+// to slow down
+type backoffError struct {
+	status int
+	wait   time.Duration
+}
+
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("Receiver is overloaded (%d), backing off for %s", e.status, e.wait)
+}
+
+// listenerTransport is shared by the UrlListeners so that they reuse their
+// connections. Posts to receivers served over TLS use HTTP/2 where they
+// support it, and so share a single connection.
+var listenerTransport = newListenerTransport()
+
+func newListenerTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = 4
+	return transport
+}
+
 // An UrlListener is an event listener that receives updates over an
-// HTTP POST to an endpoint.
+// HTTP POST to an endpoint. Receivers that support the handshake get the
+// events batched up, as delta or full payloads, and gzipped if they like.
+// Others get the whole state on every event.
 type UrlListener struct {
-	Url          string
-	Retries      int
-	Client       *http.Client
-	looper       director.Looper
-	eventChannel chan ChangeEvent
-	managed      bool // Is this to be auto-managed by ServicesState?
-	name         string
+	Url           string
+	Retries       int
+	Client        *http.Client
+	Payloads      []string        // The payload shapes we offer, by preference
+	BatchInterval time.Duration   // How long to gather events into one post
+	MaxBatch      int             // The most events in one post
+	FullInterval  time.Duration   // How often delta receivers get the whole state anyway
+	Signer        *ListenerSigner // Signs each post, nil to send them unsigned
+	looper        director.Looper
+	eventChannel  chan ChangeEvent
+	managed       bool // Is this to be auto-managed by ServicesState?
+	name          string
+
+	// Only touched from the Watch() goroutine
+	negotiated  bool      // Have we done the handshake?
+	payload     string    // The payload shape agreed in the handshake, "" for none
+	gzip        bool      // Does the receiver take gzipped posts?
+	needFull    bool      // Does the receiver need the whole state next time?
+	lastFull    time.Time // When we last sent the whole state
+	lastDropped int64     // Events the state had dropped for us at the last post
 }
 
 // A StateChangedEvent is sent to UrlListeners when a significant
@@ -68,13 +118,17 @@ func NewUrlListener(listenurl string, managed bool) *UrlListener {
 	cookieJar := prepareCookieJar(listenurl)
 
 	return &UrlListener{
-		Url:          listenurl,
-		looper:       director.NewFreeLooper(director.FOREVER, errorChan),
-		Client:       &http.Client{Timeout: ClientTimeout, Jar: cookieJar},
-		eventChannel: make(chan ChangeEvent, LISTENER_EVENT_BUFFER_SIZE),
-		Retries:      DefaultRetries,
-		managed:      managed,
-		name:         "UrlListener(" + listenurl + ")",
+		Url:           listenurl,
+		looper:        director.NewFreeLooper(director.FOREVER, errorChan),
+		Client:        &http.Client{Timeout: ClientTimeout, Jar: cookieJar, Transport: listenerTransport},
+		eventChannel:  make(chan ChangeEvent, LISTENER_EVENT_BUFFER_SIZE),
+		Retries:       DefaultRetries,
+		Payloads:      []string{PayloadDelta, PayloadFull},
+		BatchInterval: DefaultBatchInterval,
+		MaxBatch:      LISTENER_EVENT_BUFFER_SIZE,
+		FullInterval:  DefaultFullInterval,
+		managed:       managed,
+		name:          "UrlListener(" + listenurl + ")",
 	}
 }
 
@@ -86,7 +140,15 @@ func withRetries(count int, fn func() error) error {
 		if result == nil {
 			return nil
 		}
-		time.Sleep(100 * time.Duration(i) * time.Millisecond)
+
+		wait := 100 * time.Duration(i) * time.Millisecond
+		// Overloaded receivers tell us how long to wait. Meanwhile the events
+		// pile up and go out together in the next batch.
+		var backoff *backoffError
+		if errors.As(result, &backoff) {
+			wait = backoff.wait
+		}
+		time.Sleep(wait)
 	}
 
 	log.Warnf("Failed after %d retries", count)
@@ -115,47 +177,220 @@ func (u *UrlListener) Stop() {
 
 func (u *UrlListener) Watch(state *ServicesState) {
 	state.AddListener(u)
+	u.Start(state)
+}
 
+// Start posts the events that arrive on the channel, without adding the
+// listener to the state. Use it when something else, like a LeaderListener,
+// passes the events on.
+func (u *UrlListener) Start(state *ServicesState) {
 	go func() {
 		u.looper.Loop(func() error {
 			changedServiceEvent := <-u.eventChannel
 
-			state.RLock()
-			event := StateChangedEvent{
-				State:       state,
-				ChangeEvent: changedServiceEvent,
+			if !u.negotiated {
+				u.negotiate()
 			}
 
-			data, err := json.Marshal(event)
-			state.RUnlock()
-
-			// Check for some kind of junk JSON being generated by state.Encode()
-			if err != nil {
-				log.Warnf("Skipping post to '%s' because of bad state encoding! (%s)", u.Url, err.Error())
+			if u.payload == "" {
+				u.postEvent(state, changedServiceEvent)
 				return nil
 			}
 
-			buf := bytes.NewBuffer(data)
+			u.postBatch(state, u.gather(changedServiceEvent))
+			return nil
+		})
+	}()
+}
+
+// gather collects the events that arrive within the BatchInterval, up to
+// MaxBatch of them
+func (u *UrlListener) gather(first ChangeEvent) []ChangeEvent {
+	events := []ChangeEvent{first}
+	timeout := time.After(u.BatchInterval)
+
+	for len(events) < u.MaxBatch {
+		select {
+		case event := <-u.eventChannel:
+			events = append(events, event)
+		case <-timeout:
+			return events
+		}
+	}
 
-			err = withRetries(u.Retries, func() error {
-				resp, err := u.Client.Post(u.Url, "application/json", buf)
+	return events
+}
 
-				if err != nil {
-					return err
-				}
+// postEvent sends the whole state for a single event, for receivers that
+// don't support the handshake
+func (u *UrlListener) postEvent(state *ServicesState, changedServiceEvent ChangeEvent) {
+	state.RLock()
+	event := StateChangedEvent{
+		State:       state,
+		ChangeEvent: changedServiceEvent,
+	}
 
-				if resp.StatusCode > 299 || resp.StatusCode < 200 {
-					return fmt.Errorf("Bad status code returned (%d)", resp.StatusCode)
-				}
+	data, err := json.Marshal(event)
+	version := state.version
+	state.RUnlock()
 
-				return nil
-			})
+	// Check for some kind of junk JSON being generated by state.Encode()
+	if err != nil {
+		log.Warnf("Skipping post to '%s' because of bad state encoding! (%s)", u.Url, err.Error())
+		return
+	}
+
+	err = u.post(data, "")
+	if err != nil {
+		log.Warnf("Failed posting state to '%s' %s: %s", u.Url, u.Name(), err.Error())
+		// The receiver may have been replaced by one that does the handshake
+		u.negotiated = false
+		return
+	}
+
+	state.AckListener(u.Name(), version)
+}
+
+// postBatch sends a batch of events in the agreed payload shape. We fall
+// back to the whole state when the receiver may have missed something: after
+// a failed post, when the state dropped events for us, or when it's been
+// FullInterval since the last one.
+func (u *UrlListener) postBatch(state *ServicesState, events []ChangeEvent) {
+	stats, _ := state.ListenerStats(u.Name())
+
+	payload := u.payload
+	if u.needFull || stats.Dropped != u.lastDropped || time.Since(u.lastFull) > u.FullInterval {
+		payload = PayloadFull
+	}
+
+	// The version the receiver is up to once it has the batch
+	var version uint64
+	for _, event := range events {
+		if event.Version > version {
+			version = event.Version
+		}
+	}
+
+	state.RLock()
+	batch := StateBatch{Changes: events, LastChanged: state.LastChanged}
+	if payload == PayloadFull {
+		batch.State = state
+		version = state.version
+	}
 
+	data, err := json.Marshal(batch)
+	state.RUnlock()
+
+	if err != nil {
+		log.Warnf("Skipping post to '%s' because of bad state encoding! (%s)", u.Url, err.Error())
+		return
+	}
+
+	err = u.post(data, payload)
+	if err == errResyncRequested && payload != PayloadFull {
+		u.needFull = true
+		u.postBatch(state, events)
+		return
+	}
+
+	if err != nil {
+		log.Warnf("Failed posting %d events to '%s' %s: %s", len(events), u.Url, u.Name(), err.Error())
+		// They're lost, so the receiver needs the whole state. And it may
+		// have been replaced by one that wants something else.
+		u.negotiated = false
+		u.needFull = true
+		return
+	}
+
+	u.lastDropped = stats.Dropped
+	if payload == PayloadFull {
+		u.needFull = false
+		u.lastFull = time.Now()
+	}
+
+	state.AckListener(u.Name(), version)
+}
+
+// post sends the data to the receiver, gzipped if it takes that, retrying
+// on failure. Payload names the shape of a StateBatch, and is empty for a
+// StateChangedEvent.
+func (u *UrlListener) post(data []byte, payload string) error {
+	body := data
+	if u.gzip {
+		var buf bytes.Buffer
+		zipper := gzip.NewWriter(&buf)
+		zipper.Write(data)
+		zipper.Close()
+		body = buf.Bytes()
+	}
+
+	metrics.AddSample([]string{"url_listener", "payload_bytes"}, float32(len(body)))
+
+	var resync bool
+	err := withRetries(u.Retries, func() error {
+		req, err := http.NewRequest(http.MethodPost, u.Url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if payload != "" {
+			req.Header.Set(PayloadHeader, payload)
+		}
+		if u.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if u.Signer != nil {
+			err = u.Signer.Sign(req, body)
 			if err != nil {
-				log.Warnf("Failed posting state to '%s' %s: %s", u.Url, u.Name(), err.Error())
+				return err
 			}
+		}
+
+		resp, err := u.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer drainAndClose(resp)
 
+		switch {
+		case resp.StatusCode == http.StatusConflict && payload != "":
+			resync = true
 			return nil
-		})
-	}()
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+			return &backoffError{status: resp.StatusCode, wait: retryAfter(resp)}
+		case resp.StatusCode > 299 || resp.StatusCode < 200:
+			return fmt.Errorf("Bad status code returned (%d)", resp.StatusCode)
+		}
+
+		return nil
+	})
+
+	if resync {
+		return errResyncRequested
+	}
+
+	return err
+}
+
+// retryAfter reads how long the receiver wants us to wait, in seconds, up to
+// MaxRetryAfter. One second if it didn't say.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > MaxRetryAfter {
+		return MaxRetryAfter
+	}
+	return wait
+}
+
+// drainAndClose reads what's left of a response body so that the connection
+// can be reused, then closes it
+func drainAndClose(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
 }
@@ -1,8 +1,13 @@
 package catalog
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -58,8 +63,8 @@ func Test_Listen(t *testing.T) {
 			},
 		)
 
-		httpmock.Activate()
 		listener := NewUrlListener(url, false)
+		httpmock.ActivateNonDefault(listener.Client)
 		errors := make(chan error)
 		listener.looper = director.NewFreeLooper(1, errors)
 
@@ -88,3 +93,173 @@ func Test_Listen(t *testing.T) {
 		})
 	})
 }
+
+// A receivedPost is what a test receiver got from an UrlListener
+type receivedPost struct {
+	payload string
+	gzipped bool
+	batch   StateBatch
+	event   StateChangedEvent
+}
+
+// testReceiver answers the handshake with the payloads and records posts,
+// replying to delta posts with deltaStatus
+type testReceiver struct {
+	payloads    string
+	deltaStatus int
+	posts       []receivedPost
+	sync.Mutex
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodOptions {
+		if r.payloads == "" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set(PayloadsHeader, r.payloads)
+		w.Header().Set("Accept-Encoding", "gzip")
+		return
+	}
+
+	post := receivedPost{
+		payload: req.Header.Get(PayloadHeader),
+		gzipped: req.Header.Get("Content-Encoding") == "gzip",
+	}
+
+	body := req.Body
+	if post.gzipped {
+		body, _ = gzip.NewReader(req.Body)
+	}
+	data, _ := ioutil.ReadAll(body)
+
+	if post.payload == "" {
+		json.Unmarshal(data, &post.event)
+	} else {
+		json.Unmarshal(data, &post.batch)
+	}
+
+	r.Lock()
+	r.posts = append(r.posts, post)
+	r.Unlock()
+
+	if post.payload == PayloadDelta && r.deltaStatus != 0 {
+		w.WriteHeader(r.deltaStatus)
+	}
+}
+
+func Test_UrlListenerPayloads(t *testing.T) {
+	Convey("UrlListener payloads", t, func() {
+		hostname := "grendel"
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.AddServiceEntry(service.Service{ID: "deadbeef123", Hostname: hostname, Updated: time.Now().UTC()})
+
+		rcvr := &testReceiver{payloads: "delta, full"}
+		server := httptest.NewServer(rcvr)
+		defer server.Close()
+
+		listener := NewUrlListener(server.URL, false)
+		listener.BatchInterval = 10 * time.Millisecond
+		listener.MaxBatch = 3
+		listener.Retries = 0
+
+		// Run the listener for a number of posts, with 4 events to send
+		run := func(posts int) []receivedPost {
+			listener.looper = director.NewFreeLooper(posts, make(chan error))
+			for i := 0; i < 4; i++ {
+				listener.eventChannel <- ChangeEvent{
+					Service: service.Service{ID: "deadbeef123", Hostname: hostname, Status: i % 2},
+				}
+			}
+			listener.Watch(state)
+			listener.looper.Wait()
+
+			rcvr.Lock()
+			defer rcvr.Unlock()
+			return rcvr.posts
+		}
+
+		Convey("batches events, sending the whole state first and then deltas", func() {
+			posts := run(2)
+
+			So(len(posts), ShouldEqual, 2)
+			So(posts[0].payload, ShouldEqual, PayloadFull)
+			So(posts[0].gzipped, ShouldBeTrue)
+			So(posts[0].batch.State, ShouldNotBeNil)
+			So(posts[0].batch.State.Servers[hostname], ShouldNotBeNil)
+			So(len(posts[0].batch.Changes), ShouldEqual, 3)
+
+			So(posts[1].payload, ShouldEqual, PayloadDelta)
+			So(posts[1].batch.State, ShouldBeNil)
+			So(len(posts[1].batch.Changes), ShouldEqual, 1)
+		})
+
+		Convey("acks the version the receiver has once it takes a post", func() {
+			version := state.Version()
+			So(state.UnackedListeners(version), ShouldBeEmpty)
+
+			run(2)
+
+			So(state.listenerAcks[listener.Name()], ShouldEqual, version)
+			So(state.UnackedListeners(version), ShouldBeEmpty)
+			So(state.UnackedListeners(version+1), ShouldResemble, []string{listener.Name()})
+		})
+
+		Convey("sends the whole state when the receiver can't apply a delta", func() {
+			rcvr.deltaStatus = http.StatusConflict
+			posts := run(2)
+
+			So(len(posts), ShouldEqual, 3)
+			So(posts[1].payload, ShouldEqual, PayloadDelta)
+			So(posts[2].payload, ShouldEqual, PayloadFull)
+			So(len(posts[2].batch.Changes), ShouldEqual, 1)
+		})
+
+		Convey("sends the whole state to receivers that prefer it", func() {
+			rcvr.payloads = "full"
+			posts := run(2)
+
+			So(len(posts), ShouldEqual, 2)
+			So(posts[1].payload, ShouldEqual, PayloadFull)
+		})
+
+		Convey("sends only the payloads it offers", func() {
+			listener.Payloads = []string{PayloadFull}
+			posts := run(2)
+
+			So(listener.payload, ShouldEqual, PayloadFull)
+			So(posts[1].payload, ShouldEqual, PayloadFull)
+		})
+
+		Convey("posts every event to receivers without the handshake", func() {
+			rcvr.payloads = ""
+			posts := run(2)
+
+			So(len(posts), ShouldEqual, 2)
+			So(posts[0].payload, ShouldBeEmpty)
+			So(posts[0].gzipped, ShouldBeFalse)
+			So(posts[0].event.State, ShouldNotBeNil)
+			So(posts[0].event.ChangeEvent.Service.ID, ShouldEqual, "deadbeef123")
+		})
+	})
+}
+
+func Test_retryAfter(t *testing.T) {
+	Convey("retryAfter()", t, func() {
+		resp := &http.Response{Header: http.Header{}}
+
+		Convey("defaults to a second", func() {
+			So(retryAfter(resp), ShouldEqual, time.Second)
+			resp.Header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+			So(retryAfter(resp), ShouldEqual, time.Second)
+		})
+
+		Convey("honors the receiver, up to MaxRetryAfter", func() {
+			resp.Header.Set("Retry-After", "5")
+			So(retryAfter(resp), ShouldEqual, 5*time.Second)
+			resp.Header.Set("Retry-After", "3600")
+			So(retryAfter(resp), ShouldEqual, MaxRetryAfter)
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The payload shapes an UrlListener can post
+const (
+	PayloadFull  = "full"  // The whole state, with the changes since the last post
+	PayloadDelta = "delta" // Only the changes since the last post
+)
+
+const (
+	// Lists the payload shapes each side supports, by preference. Sent by the
+	// UrlListener on the OPTIONS handshake, and by the receiver in reply.
+	PayloadsHeader = "X-Sidecar-Payloads"
+	// The shape of a posted StateBatch
+	PayloadHeader = "X-Sidecar-Payload"
+)
+
+// A StateBatch is posted to receivers that agreed a payload shape in the
+// handshake. It carries every event since the last post and, for full
+// payloads, the whole state. The Service in each event is the latest record
+// at the time of the change, which is all a delta receiver needs to apply it.
+type StateBatch struct {
+	State       *ServicesState `json:",omitempty"` // Full payloads only
+	Changes     []ChangeEvent
+	LastChanged time.Time
+}
+
+// negotiate asks the receiver, with an OPTIONS request, which payload shapes
+// and encodings it takes. Receivers that don't know about the handshake get
+// one StateChangedEvent per post, as they always have. When the receiver
+// can't be reached we try again before the next post.
+func (u *UrlListener) negotiate() {
+	u.payload = ""
+	u.gzip = false
+
+	req, err := http.NewRequest(http.MethodOptions, u.Url, nil)
+	if err != nil {
+		log.Warnf("Unable to build handshake for %s: %s", u.Name(), err)
+		return
+	}
+	req.Header.Set(PayloadsHeader, strings.Join(u.Payloads, ", "))
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		log.Warnf("Handshake with %s failed, will try again: %s", u.Name(), err)
+		return
+	}
+	defer drainAndClose(resp)
+
+	u.negotiated = true
+	u.needFull = true
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Infof("%s doesn't support the handshake (%d), posting every event", u.Name(), resp.StatusCode)
+		return
+	}
+
+	u.payload = pickPayload(u.Payloads, headerList(resp.Header.Get(PayloadsHeader)))
+	for _, encoding := range headerList(resp.Header.Get("Accept-Encoding")) {
+		if encoding == "gzip" {
+			u.gzip = true
+		}
+	}
+
+	if u.payload == "" {
+		log.Infof("%s doesn't take any payloads we offer, posting every event", u.Name())
+		return
+	}
+
+	log.Infof("%s agreed to %s payloads (gzip: %t)", u.Name(), u.payload, u.gzip)
+}
+
+// pickPayload returns the receiver's favourite payload shape that we offer,
+// or "" if there isn't one
+func pickPayload(offered []string, accepted []string) string {
+	for _, payload := range accepted {
+		for _, offer := range offered {
+			if payload == offer {
+				return payload
+			}
+		}
+	}
+	return ""
+}
+
+// headerList splits a comma separated header value, like "delta, full"
+func headerList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,155 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	MAX_FUTURE_SKEW = 1 * time.Minute // How far in the future a service timestamp may be
+)
+
+// A ValidationPolicy decides what AddServiceEntry() does with a service
+// record that fails validation.
+type ValidationPolicy int
+
+const (
+	// Log the problem and accept the record as it is
+	ValidationWarn ValidationPolicy = iota
+	// Pull timestamps in the future back to now. Otherwise like ValidationWarn.
+	ValidationClamp
+	// Drop the record
+	ValidationReject
+)
+
+// ParseValidationPolicy returns the ValidationPolicy with the given name.
+// An empty name gets the default, ValidationClamp.
+func ParseValidationPolicy(name string) (ValidationPolicy, error) {
+	switch name {
+	case "warn":
+		return ValidationWarn, nil
+	case "clamp", "":
+		return ValidationClamp, nil
+	case "reject":
+		return ValidationReject, nil
+	default:
+		return ValidationWarn, fmt.Errorf("unknown validation policy %q", name)
+	}
+}
+
+// ValidationStats counts the invalid service records we've seen
+type ValidationStats struct {
+	Invalid  int64            // Records which failed validation
+	Clamped  int64            // Records whose timestamps were clamped
+	Rejected int64            // Records which were dropped
+	ByHost   map[string]int64 // Invalid records by the host that announced them
+}
+
+// ValidateService returns a list of everything that's wrong with the service
+// record. An empty list means the record is fine.
+func ValidateService(svc *service.Service, now time.Time) []string {
+	var problems []string
+
+	if svc.ID == "" {
+		problems = append(problems, "empty ID")
+	}
+
+	if svc.Name == "" {
+		problems = append(problems, "empty name")
+	}
+
+	if svc.Hostname == "" {
+		problems = append(problems, "empty hostname")
+	}
+
+	if len(svc.Ports) == 0 && !svc.IsTombstone() {
+		problems = append(problems, "no ports")
+	}
+
+	if svc.Updated.After(now.Add(MAX_FUTURE_SKEW)) {
+		problems = append(problems, "updated timestamp in the future")
+	}
+
+	return problems
+}
+
+// ValidationStats returns a copy of the counters for invalid service records
+func (state *ServicesState) ValidationStats() ValidationStats {
+	state.RLock()
+	defer state.RUnlock()
+
+	stats := state.validationStats
+	stats.ByHost = make(map[string]int64, len(state.validationStats.ByHost))
+	for host, count := range state.validationStats.ByHost {
+		stats.ByHost[host] = count
+	}
+
+	return stats
+}
+
+// validateEntry applies the ValidationPolicy to a service that is about to be
+// added to the state. It may modify the service. Returns false if the service
+// should be dropped. Note: not synchronized! Expects the caller to hold the
+// state lock.
+func (state *ServicesState) validateEntry(svc *service.Service) bool {
+	// Judge the timestamps by the peer's clock if we're compensating for skew
+	now := state.now().Add(state.skewFor(svc.Hostname))
+
+	problems := ValidateService(svc, now)
+	if len(problems) == 0 {
+		return true
+	}
+
+	state.validationStats.Invalid++
+	if state.validationStats.ByHost == nil {
+		state.validationStats.ByHost = make(map[string]int64)
+	}
+	state.validationStats.ByHost[svc.Hostname]++
+	metrics.IncrCounter([]string{"services_state", "validation", "invalid"}, 1)
+
+	// Only complain the first time we see a record, or we'd do it on every
+	// broadcast.
+	known := state.HasServer(svc.Hostname) && state.Servers[svc.Hostname].HasService(svc.ID)
+	description := fmt.Sprintf("%s:%s (%s): %s",
+		svc.Hostname, svc.Name, svc.ID, strings.Join(problems, ", "),
+	)
+
+	switch state.ValidationPolicy {
+	case ValidationReject:
+		state.validationStats.Rejected++
+		metrics.IncrCounter([]string{"services_state", "validation", "rejected"}, 1)
+		log.Warnf("Rejecting invalid service %s", description)
+		return false
+
+	case ValidationClamp:
+		if svc.Updated.After(now.Add(MAX_FUTURE_SKEW)) {
+			// Each retransmit of a record we already clamped would get a
+			// newer timestamp than the last, and look like a change. So we
+			// only clamp the ones that changed, and are newer once clamped.
+			if known {
+				stored := state.Servers[svc.Hostname].Services[svc.ID]
+				if sameContent(svc, stored) || !now.After(stored.Updated) {
+					return false
+				}
+			}
+
+			state.validationStats.Clamped++
+			metrics.IncrCounter([]string{"services_state", "validation", "clamped"}, 1)
+			if !known {
+				log.Warnf("Clamping timestamp on invalid service %s", description)
+			}
+			svc.Updated = now
+			return true
+		}
+	}
+
+	if !known {
+		log.Warnf("Accepting invalid service %s", description)
+	}
+
+	return true
+}
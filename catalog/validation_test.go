@@ -0,0 +1,138 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Validation(t *testing.T) {
+	Convey("Validating services", t, func() {
+		state := NewServicesState()
+		now := time.Now().UTC()
+
+		good := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: hostname,
+			Updated:  now,
+			Ports:    []service.Port{{Type: "tcp", Port: 10234, ServicePort: 9999}},
+		}
+
+		future := good
+		future.Updated = now.Add(1 * time.Hour)
+
+		nameless := good
+		nameless.Name = ""
+
+		Convey("ValidateService()", func() {
+			Convey("passes a good service", func() {
+				So(ValidateService(&good, now), ShouldBeEmpty)
+			})
+
+			Convey("finds all the problems", func() {
+				svc := service.Service{Updated: now.Add(1 * time.Hour)}
+				So(len(ValidateService(&svc, now)), ShouldEqual, 5)
+			})
+
+			Convey("doesn't require ports on tombstones", func() {
+				svc := good
+				svc.Ports = nil
+				So(ValidateService(&svc, now), ShouldNotBeEmpty)
+
+				svc.Tombstone()
+				So(ValidateService(&svc, now), ShouldBeEmpty)
+			})
+
+			Convey("allows a little clock skew", func() {
+				svc := good
+				svc.Updated = now.Add(MAX_FUTURE_SKEW - time.Second)
+				So(ValidateService(&svc, now), ShouldBeEmpty)
+			})
+		})
+
+		Convey("ParseValidationPolicy()", func() {
+			policy, err := ParseValidationPolicy("reject")
+			So(err, ShouldBeNil)
+			So(policy, ShouldEqual, ValidationReject)
+
+			policy, err = ParseValidationPolicy("")
+			So(err, ShouldBeNil)
+			So(policy, ShouldEqual, ValidationClamp)
+
+			_, err = ParseValidationPolicy("ignore")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("AddServiceEntry()", func() {
+			Convey("clamps future timestamps by default", func() {
+				state.AddServiceEntry(future)
+
+				added := state.Servers[hostname].Services[future.ID]
+				So(added, ShouldNotBeNil)
+				So(added.Updated.Before(now.Add(MAX_FUTURE_SKEW)), ShouldBeTrue)
+
+				stats := state.ValidationStats()
+				So(stats.Invalid, ShouldEqual, 1)
+				So(stats.Clamped, ShouldEqual, 1)
+				So(stats.ByHost[hostname], ShouldEqual, 1)
+			})
+
+			Convey("clamps each record only once", func() {
+				state.AddServiceEntry(future)
+				clamped := state.Servers[hostname].Services[future.ID].Updated
+
+				// As SendServices() retransmits it
+				retransmit := future
+				retransmit.Updated = future.Updated.Add(50 * time.Nanosecond)
+				output := LogCapture(func() { state.AddServiceEntry(retransmit) })
+
+				So(state.Servers[hostname].Services[future.ID].Updated, ShouldEqual, clamped)
+				So(state.ValidationStats().Clamped, ShouldEqual, 1)
+				So(output, ShouldNotContainSubstring, "Clamping")
+
+				Convey("but clamps the changes to it", func() {
+					changed := retransmit
+					changed.Status = service.UNHEALTHY
+					time.Sleep(time.Millisecond)
+					state.AddServiceEntry(changed)
+
+					stored := state.Servers[hostname].Services[future.ID]
+					So(stored.Status, ShouldEqual, service.UNHEALTHY)
+					So(stored.Updated.After(clamped), ShouldBeTrue)
+					So(state.ValidationStats().Clamped, ShouldEqual, 2)
+				})
+			})
+
+			Convey("accepts invalid records with the warn policy", func() {
+				state.ValidationPolicy = ValidationWarn
+				state.AddServiceEntry(future)
+				state.AddServiceEntry(nameless)
+
+				So(state.Servers[hostname].Services[future.ID].Updated, ShouldEqual, future.Updated)
+				So(state.ValidationStats().Invalid, ShouldEqual, 2)
+			})
+
+			Convey("drops invalid records with the reject policy", func() {
+				state.ValidationPolicy = ValidationReject
+				state.AddServiceEntry(nameless)
+
+				So(state.HasServer(hostname), ShouldBeFalse)
+
+				stats := state.ValidationStats()
+				So(stats.Rejected, ShouldEqual, 1)
+				So(stats.ByHost[hostname], ShouldEqual, 1)
+			})
+
+			Convey("doesn't count good records", func() {
+				state.ValidationPolicy = ValidationReject
+				state.AddServiceEntry(good)
+
+				So(state.Servers[hostname].Services[good.ID], ShouldNotBeNil)
+				So(state.ValidationStats().Invalid, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package catalog
+
+import (
+	"time"
+)
+
+// initialVersion seeds the change counter from the clock, so that versions
+// keep increasing across restarts and a client never mistakes a new state
+// for one it has already seen.
+func initialVersion() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// Version returns a counter that increases every time the state changes.
+// Clients can compare it with a version they saw earlier to find out cheaply
+// whether anything is new. The numbers are only meaningful for this node.
+func (state *ServicesState) Version() uint64 {
+	state.RLock()
+	defer state.RUnlock()
+
+	return state.version
+}
+
+// ServerVersions returns the state version at which each server last
+// changed.
+func (state *ServicesState) ServerVersions() map[string]uint64 {
+	state.RLock()
+	defer state.RUnlock()
+
+	versions := make(map[string]uint64, len(state.serverVersions))
+	for hostname, version := range state.serverVersions {
+		versions[hostname] = version
+	}
+
+	return versions
+}
+
+// bumpVersion records that the state changed, and which server it changed
+// on. An empty hostname means no one server in particular. Note: not
+// synchronized!
+func (state *ServicesState) bumpVersion(hostname string) {
+	state.version++
+
+	if hostname != "" {
+		state.serverVersions[hostname] = state.version
+	}
+}
+
+// recordReplaced records that a server got a new record. A record announced
+// again with the same status doesn't bump the version, but its Updated time
+// and LastCheck are new, and ServicesView() must not keep the old copy. Note:
+// not synchronized!
+func (state *ServicesState) recordReplaced(hostname string) {
+	state.records++
+
+	if state.serverRecords == nil {
+		state.serverRecords = make(map[string]uint64)
+	}
+	state.serverRecords[hostname] = state.records
+}
@@ -0,0 +1,60 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Version(t *testing.T) {
+	Convey("Tracking the state version", t, func() {
+		state := NewServicesState()
+		state.Hostname = hostname
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: anotherHostname,
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+			Ports:    []service.Port{{Type: "tcp", Port: 1234}},
+		}
+
+		Convey("starts from a non-zero version", func() {
+			So(state.Version(), ShouldBeGreaterThan, 0)
+			So(state.ServerVersions(), ShouldBeEmpty)
+		})
+
+		Convey("increases when a service changes", func() {
+			before := state.Version()
+			state.AddServiceEntry(svc)
+
+			So(state.Version(), ShouldBeGreaterThan, before)
+			So(state.ServerVersions()[anotherHostname], ShouldEqual, state.Version())
+		})
+
+		Convey("doesn't change when nothing new arrives", func() {
+			state.AddServiceEntry(svc)
+			before := state.Version()
+
+			state.AddServiceEntry(svc)
+			So(state.Version(), ShouldEqual, before)
+		})
+
+		Convey("tracks the version for each server", func() {
+			state.AddServiceEntry(svc)
+			first := state.Version()
+
+			other := svc
+			other.ID = "abba"
+			other.Hostname = hostname
+			state.AddServiceEntry(other)
+
+			versions := state.ServerVersions()
+			So(versions[anotherHostname], ShouldEqual, first)
+			So(versions[hostname], ShouldBeGreaterThan, first)
+		})
+	})
+}
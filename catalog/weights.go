@@ -0,0 +1,255 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	director "github.com/relistan/go-director"
+)
+
+const (
+	DEFAULT_WEIGHT           = 100              // The weight of an endpoint we know nothing about
+	DEFAULT_MIN_WEIGHT       = 10               // Hot endpoints never get less than this
+	DEFAULT_MAX_WEIGHT       = 200              // Cold endpoints never get more than this
+	DEFAULT_WEIGHT_DAMPING   = 0.3              // How far we move towards the target weight each update
+	DEFAULT_WEIGHT_THRESHOLD = 5                // Smallest change that's worth reconfiguring the proxies
+	WEIGHT_UPDATE_INTERVAL   = 10 * time.Second // How often we recalculate weights
+)
+
+// A LoadMetric returns the current load on a service instance. The second
+// return value is false when we don't know.
+type LoadMetric func(svc *service.Service) (float64, bool)
+
+// CPULoad uses the CPU usage reported by discovery as the load
+func CPULoad(svc *service.Service) (float64, bool) {
+	if svc.Resources == nil || svc.Resources.Sampled.IsZero() {
+		return 0, false
+	}
+
+	return svc.Resources.CPUPercent, true
+}
+
+// CheckLatencyLoad uses the time the last health check took as the load
+func CheckLatencyLoad(svc *service.Service) (float64, bool) {
+	if svc.Resources == nil || svc.Resources.CheckLatency <= 0 {
+		return 0, false
+	}
+
+	return float64(svc.Resources.CheckLatency), true
+}
+
+// ParseLoadMetric returns the LoadMetric with the given name
+func ParseLoadMetric(name string) (LoadMetric, error) {
+	switch name {
+	case "cpu":
+		return CPULoad, nil
+	case "latency":
+		return CheckLatencyLoad, nil
+	default:
+		return nil, fmt.Errorf("unknown load metric %q", name)
+	}
+}
+
+// A WeightController adjusts the proxy weight of each endpoint so that hot
+// instances of a service shed traffic to their cooler siblings. Each update
+// it works out a target weight from the instance's load relative to the mean
+// for the service, then moves part of the way there. Weights stay within
+// MinWeight and MaxWeight. A nil WeightController gives no weights at all, so
+// the proxies use their defaults.
+type WeightController struct {
+	Metric    LoadMetric
+	MinWeight int
+	MaxWeight int
+	Damping   float64 // Between 0 and 1. Lower values respond more slowly.
+	Threshold int     // Changes smaller than this aren't applied
+
+	smoothed map[string]float64 // Where we are heading, by service ID
+	applied  map[string]int     // What the proxies are using, by service ID
+	sync.RWMutex
+}
+
+// NewWeightController returns a WeightController using the supplied metric
+// and the default bounds and damping.
+func NewWeightController(metric LoadMetric) *WeightController {
+	return &WeightController{
+		Metric:    metric,
+		MinWeight: DEFAULT_MIN_WEIGHT,
+		MaxWeight: DEFAULT_MAX_WEIGHT,
+		Damping:   DEFAULT_WEIGHT_DAMPING,
+		Threshold: DEFAULT_WEIGHT_THRESHOLD,
+		smoothed:  make(map[string]float64),
+		applied:   make(map[string]int),
+	}
+}
+
+// Weight returns the weight the proxies should give this endpoint. Returns
+// zero when the controller is nil, meaning the proxy default should be used.
+func (c *WeightController) Weight(svc *service.Service) int {
+	if c == nil {
+		return 0
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if weight, ok := c.applied[svc.ID]; ok {
+		return weight
+	}
+
+	return c.clamp(DEFAULT_WEIGHT)
+}
+
+// Update recalculates the weights from the current state and returns the
+// services whose applied weight changed. Handles locking the state.
+func (c *WeightController) Update(state *ServicesState) []service.Service {
+	state.RLock()
+	byName := state.ByServiceWithoutAliases()
+
+	c.Lock()
+	defer c.Unlock()
+
+	seen := make(map[string]bool)
+	var changed []service.Service
+
+	for _, instances := range byName {
+		targets := c.targets(instances)
+
+		for _, svc := range instances {
+			target, ok := targets[svc.ID]
+			if !ok {
+				continue
+			}
+			seen[svc.ID] = true
+
+			smoothed, ok := c.smoothed[svc.ID]
+			if !ok {
+				smoothed = DEFAULT_WEIGHT
+			}
+			smoothed += c.Damping * (target - smoothed)
+			c.smoothed[svc.ID] = smoothed
+
+			weight := c.clamp(int(math.Round(smoothed)))
+			applied, ok := c.applied[svc.ID]
+			if !ok {
+				applied = c.clamp(DEFAULT_WEIGHT)
+			}
+
+			if abs(weight-applied) >= c.Threshold {
+				c.applied[svc.ID] = weight
+				changed = append(changed, *svc)
+			}
+		}
+	}
+	state.RUnlock()
+
+	// Forget about anything that has gone away
+	for id := range c.smoothed {
+		if !seen[id] {
+			delete(c.smoothed, id)
+			delete(c.applied, id)
+		}
+	}
+
+	// Stable order, mostly for logging and tests
+	sort.Slice(changed, func(i, j int) bool { return changed[i].ID < changed[j].ID })
+
+	return changed
+}
+
+// targets works out the weight each ALIVE instance of a service should end
+// up with. Instances without a load reading are left out.
+func (c *WeightController) targets(instances []*service.Service) map[string]float64 {
+	loads := make(map[string]float64, len(instances))
+	var total float64
+
+	for _, svc := range instances {
+		if !svc.IsAlive() {
+			continue
+		}
+
+		load, ok := c.Metric(svc)
+		if !ok || load < 0 {
+			continue
+		}
+
+		loads[svc.ID] = load
+		total += load
+	}
+
+	targets := make(map[string]float64, len(loads))
+	if len(loads) == 0 {
+		return targets
+	}
+
+	mean := total / float64(len(loads))
+	for id, load := range loads {
+		switch {
+		case mean == 0:
+			// Nothing is doing anything, so everyone is equal
+			targets[id] = DEFAULT_WEIGHT
+		case load == 0:
+			targets[id] = float64(c.MaxWeight)
+		default:
+			targets[id] = math.Max(
+				float64(c.MinWeight),
+				math.Min(float64(c.MaxWeight), DEFAULT_WEIGHT*mean/load),
+			)
+		}
+	}
+
+	return targets
+}
+
+// clamp keeps a weight within the bounds
+func (c *WeightController) clamp(weight int) int {
+	if weight < c.MinWeight {
+		return c.MinWeight
+	}
+
+	if weight > c.MaxWeight {
+		return c.MaxWeight
+	}
+
+	return weight
+}
+
+// Run recalculates the weights on each loop until the looper quits or the
+// context is cancelled. When weights change, it marks the state as changed
+// and notifies the listeners so that the proxies pick up the new weights.
+func (c *WeightController) Run(ctx context.Context, state *ServicesState, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
+	looper.Loop(func() error {
+		changed := c.Update(state)
+		if len(changed) == 0 {
+			return nil
+		}
+
+		log.Infof("Load weighting changed weights for %d endpoints", len(changed))
+
+		// The proxies rebuild their whole config on any event, so one is
+		// enough for all the changes.
+		svc := changed[0]
+		now := time.Now().UTC()
+
+		state.Lock()
+		state.LastChanged = now
+		state.bumpVersion("")
+		state.NotifyListeners(&svc, svc.Status, now)
+		state.Unlock()
+
+		return nil
+	})
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_WeightController(t *testing.T) {
+	Convey("Load-aware weighting", t, func() {
+		state := NewServicesState()
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		newSvc := func(id string, cpu float64) service.Service {
+			return service.Service{
+				ID:       id,
+				Name:     "beowulf",
+				Hostname: hostname,
+				Updated:  baseTime,
+				Status:   service.ALIVE,
+				Ports:    []service.Port{{Type: "tcp", Port: 1234}},
+				Resources: &service.Resources{
+					CPUPercent: cpu,
+					Sampled:    baseTime,
+				},
+			}
+		}
+
+		hot := newSvc("deadbeef123", 150)
+		cold := newSvc("deadbeef101", 50)
+		unknown := newSvc("deadbeef105", 0)
+		unknown.Resources = nil
+
+		state.AddServiceEntry(hot)
+		state.AddServiceEntry(cold)
+		state.AddServiceEntry(unknown)
+
+		weights := NewWeightController(CPULoad)
+
+		Convey("starts everyone at the default weight", func() {
+			So(weights.Weight(&hot), ShouldEqual, DEFAULT_WEIGHT)
+			So(weights.Weight(&cold), ShouldEqual, DEFAULT_WEIGHT)
+		})
+
+		Convey("moves weight from hot to cold instances gradually", func() {
+			changed := weights.Update(state)
+
+			So(len(changed), ShouldEqual, 2)
+			// Targets are 67 for the hot one and 200 for the cold one, damped
+			So(weights.Weight(&hot), ShouldEqual, 90)
+			So(weights.Weight(&cold), ShouldEqual, 130)
+			So(weights.Weight(&unknown), ShouldEqual, DEFAULT_WEIGHT)
+
+			for i := 0; i < 20; i++ {
+				weights.Update(state)
+			}
+			So(weights.Weight(&hot), ShouldBeBetweenOrEqual, 67, 72)
+			So(weights.Weight(&cold), ShouldBeBetweenOrEqual, 195, 200)
+		})
+
+		Convey("keeps weights within the bounds", func() {
+			weights.MaxWeight = 120
+			weights.Damping = 1
+
+			weights.Update(state)
+
+			So(weights.Weight(&cold), ShouldEqual, 120)
+		})
+
+		Convey("ignores changes below the threshold", func() {
+			weights.Threshold = 50
+
+			changed := weights.Update(state)
+
+			So(changed, ShouldBeEmpty)
+			So(weights.Weight(&hot), ShouldEqual, DEFAULT_WEIGHT)
+		})
+
+		Convey("forgets services that have gone away", func() {
+			weights.Update(state)
+			delete(state.Servers[hostname].Services, cold.ID)
+			weights.Update(state)
+
+			_, ok := weights.applied[cold.ID]
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("gives no weights when it's nil", func() {
+			var nilWeights *WeightController
+			So(nilWeights.Weight(&hot), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Parsing load metrics", t, func() {
+		_, err := ParseLoadMetric("cpu")
+		So(err, ShouldBeNil)
+
+		_, err = ParseLoadMetric("latency")
+		So(err, ShouldBeNil)
+
+		_, err = ParseLoadMetric("vibes")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -12,8 +12,20 @@ type CliOpts struct {
 	ClusterIPs   *[]string
 	ClusterName  *string
 	CpuProfile   *bool
+	Dev          *bool
 	Discover     *[]string
 	LoggingLevel *string
+	Hostname     *string
+
+	Command   string  // The subcommand we were given, e.g. "state export"
+	StateAddr *string // Where to find the API of the Sidecar to export from or import to
+	StateFile *string
+
+	RenderTemplate *string // The HAproxy template to render with, overriding HAPROXY_TEMPLATE_FILE
+	RenderEnvoy    *bool   // Render the Envoy resources rather than the HAproxy config
+
+	GraphFormat       *string // "dot" or "d2"
+	GraphDependencies *bool   // Draw the dependencies the services declare
 }
 
 func exitWithError(err error, message string) {
@@ -31,11 +43,43 @@ func parseCommandLine() *CliOpts {
 	opts.ClusterIPs = app.Flag("cluster-ip", "The cluster seed addresses").Short('c').NoEnvar().Strings()
 	opts.ClusterName = app.Flag("cluster-name", "The cluster we're part of").Short('n').String()
 	opts.CpuProfile = app.Flag("cpuprofile", "Enable CPU profiling").Short('p').Bool()
+	opts.Dev = app.Flag("dev", "Run a standalone node with example services").Bool()
 	opts.Discover = app.Flag("discover", "Method of discovery").Short('d').NoEnvar().Strings()
 	opts.LoggingLevel = app.Flag("logging-level", "Set the logging level").Short('l').String()
+	opts.Hostname = app.Flag("hostname", "The name this host is known by in the cluster").String()
+
+	app.Command("run", "Run Sidecar").Default()
 
-	_, err := app.Parse(os.Args[1:])
+	state := app.Command("state", "Manage the catalog of a running Sidecar")
+	opts.StateAddr = state.Flag("address", "The Sidecar API to talk to").Default("http://localhost:7777").String()
+	export := state.Command("export", "Save a snapshot of the catalog, gzipped when the file ends in .gz")
+	exportFile := export.Flag("out", "The file to save it to").Short('o').Required().String()
+	restore := state.Command("import", "Restore a snapshot of the catalog")
+	restoreFile := restore.Flag("in", "The file to restore it from").Short('i').Required().String()
+	graph := state.Command("graph", "Draw the hosts and their services as a Graphviz or D2 diagram")
+	graphFile := graph.Flag("state", "Draw a saved state snapshot rather than the running Sidecar").Short('s').String()
+	opts.GraphFormat = graph.Flag("format", "dot or d2").Short('f').Default("dot").Enum("dot", "d2")
+	opts.GraphDependencies = graph.Flag("dependencies", "Draw the dependencies the services declare").Bool()
+
+	render := app.Command("render", "Render the proxy config for a saved state, without running Sidecar")
+	renderFile := render.Flag("state", "The state snapshot or /state.json to render from").Short('s').Required().String()
+	opts.RenderTemplate = render.Flag("template", "The HAproxy template to use").Short('t').String()
+	opts.RenderEnvoy = render.Flag("envoy", "Render the Envoy resources as JSON instead").Bool()
+
+	var err error
+	opts.Command, err = app.Parse(os.Args[1:])
 	exitWithError(err, "Failed to parse CLI opts")
 
+	switch opts.Command {
+	case export.FullCommand():
+		opts.StateFile = exportFile
+	case restore.FullCommand():
+		opts.StateFile = restoreFile
+	case graph.FullCommand():
+		opts.StateFile = graphFile
+	case render.FullCommand():
+		opts.StateFile = renderFile
+	}
+
 	return &opts
 }
@@ -0,0 +1,95 @@
+// Package clock lets the catalog and the health checker tell the time from
+// somewhere other than the wall clock, so that tests can freeze it and move
+// it along by hand instead of sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and waits on it
+type Clock interface {
+	// The current time, in UTC
+	Now() time.Time
+	// Sends the time on the channel once the duration has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now().UTC() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// A Frozen clock only moves when it's told to. Anything waiting on After()
+// fires once the clock is advanced past its deadline.
+type Frozen struct {
+	now     time.Time
+	waiters []frozenWaiter
+	sync.Mutex
+}
+
+type frozenWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFrozen returns a Frozen clock stopped at the given time
+func NewFrozen(now time.Time) *Frozen {
+	return &Frozen{now: now.UTC()}
+}
+
+func (f *Frozen) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.now
+}
+
+func (f *Frozen) After(d time.Duration) <-chan time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, frozenWaiter{deadline: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires any waiters that are due
+func (f *Frozen) Advance(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.set(f.now.Add(d))
+}
+
+// Set stops the clock at a new time. Going backwards is allowed, but doesn't
+// un-fire any waiters.
+func (f *Frozen) Set(now time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.set(now.UTC())
+}
+
+func (f *Frozen) set(now time.Time) {
+	f.now = now
+
+	pending := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if now.Before(waiter.deadline) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.ch <- now
+	}
+	f.waiters = pending
+}
@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Frozen(t *testing.T) {
+	Convey("Frozen", t, func() {
+		start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		clock := NewFrozen(start)
+
+		Convey("stays put until advanced", func() {
+			So(clock.Now(), ShouldEqual, start)
+
+			clock.Advance(time.Minute)
+			So(clock.Now(), ShouldEqual, start.Add(time.Minute))
+
+			clock.Set(start)
+			So(clock.Now(), ShouldEqual, start)
+		})
+
+		Convey("fires waiters once they're due", func() {
+			ch := clock.After(10 * time.Second)
+
+			clock.Advance(9 * time.Second)
+			So(ch, ShouldBeEmpty)
+
+			clock.Advance(time.Second)
+			So(<-ch, ShouldEqual, start.Add(10*time.Second))
+		})
+
+		Convey("fires right away without a duration", func() {
+			So(<-clock.After(0), ShouldEqual, start)
+		})
+	})
+}
+
+func Test_Real(t *testing.T) {
+	Convey("Real tells the time in UTC", t, func() {
+		So(Real.Now().Location(), ShouldEqual, time.UTC)
+		So(Real.Now(), ShouldHappenWithin, time.Second, time.Now())
+	})
+}
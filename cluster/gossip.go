@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	metrics "github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The configured gossip settings are meant for a cluster of 10 to 99
+	// members, which is where the scale is 1
+	GOSSIP_REFERENCE_FACTOR = 2
+
+	DEFAULT_MIN_GOSSIP_SCALE = 0.5
+	DEFAULT_MAX_GOSSIP_SCALE = 2.0
+
+	GOSSIP_TUNE_INTERVAL = 10 * time.Second // How often we look at the member count
+)
+
+// GossipSettings are the gossip and retransmit factors in effect
+type GossipSettings struct {
+	Members              int     // The live member count they were worked out for
+	Scale                float64 // What the configured values were multiplied by
+	GossipNodes          int     // Nodes sent gossip each GossipInterval, never scaled
+	GossipMessages       int     // Sets of messages gathered each GossipInterval, never scaled
+	AliveRetransmits     int     // Times we announce new services
+	TombstoneRetransmits int     // Times we announce tombstones
+}
+
+// A GossipTuner scales how hard we gossip with the size of the cluster.
+// Small clusters don't need to send every message as many times, and big
+// ones need more to get messages all the way round. The scale grows with
+// log10 of the member count, the same way Memberlist scales retransmits of
+// its own messages, and is kept between MinScale and MaxScale. The settings
+// we start with are the scale 1 values. Only our own retransmits are scaled:
+// Memberlist reads GossipNodes and GossipMessages from its config without a
+// lock, so they can't be changed once it's running.
+type GossipTuner struct {
+	MinScale float64
+	MaxScale float64
+
+	state    *catalog.ServicesState
+	base     GossipSettings
+	settings GossipSettings
+	sync.Mutex
+}
+
+// NewGossipTuner returns a tuner for the Memberlist config and state, taking
+// the settings they have now as the ones to scale. Nothing changes until
+// Run() is called.
+func NewGossipTuner(config *memberlist.Config, state *catalog.ServicesState) *GossipTuner {
+	retransmits := state.Retransmits()
+	base := GossipSettings{
+		Scale:                1,
+		GossipNodes:          config.GossipNodes,
+		GossipMessages:       config.GossipMessages,
+		AliveRetransmits:     retransmits.Alive,
+		TombstoneRetransmits: retransmits.Tombstone,
+	}
+
+	return &GossipTuner{
+		MinScale: DEFAULT_MIN_GOSSIP_SCALE,
+		MaxScale: DEFAULT_MAX_GOSSIP_SCALE,
+		state:    state,
+		base:     base,
+		settings: base,
+	}
+}
+
+// ValidateBounds makes sure the scale can't go to zero and the bounds are
+// the right way round
+func (t *GossipTuner) ValidateBounds() error {
+	if t.MinScale <= 0 {
+		return fmt.Errorf("minimum gossip scale must be above 0, got %g", t.MinScale)
+	}
+
+	if t.MaxScale < t.MinScale {
+		return fmt.Errorf("maximum gossip scale %g is below the minimum %g", t.MaxScale, t.MinScale)
+	}
+
+	return nil
+}
+
+// Settings returns the settings in effect
+func (t *GossipTuner) Settings() GossipSettings {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.settings
+}
+
+// Run rescales the settings to the member count until the looper quits or
+// the context is cancelled
+func (t *GossipTuner) Run(ctx context.Context, looper director.Looper, list *memberlist.Memberlist) {
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
+	looper.Loop(func() error {
+		t.tune(list.NumMembers())
+		return nil
+	})
+}
+
+// tune applies the settings for the member count, if it's changed
+func (t *GossipTuner) tune(members int) {
+	t.Lock()
+	if members == t.settings.Members {
+		t.Unlock()
+		return
+	}
+
+	settings := t.settingsFor(members)
+	t.settings = settings
+	t.Unlock()
+
+	t.state.SetRetransmits(catalog.Retransmits{
+		Alive:     settings.AliveRetransmits,
+		Tombstone: settings.TombstoneRetransmits,
+	})
+
+	metrics.SetGauge([]string{"cluster", "gossip", "scale"}, float32(settings.Scale))
+
+	log.Infof("Cluster has %d members, gossip scale is now %.2f: "+
+		"%d alive and %d tombstone retransmits",
+		members, settings.Scale, settings.AliveRetransmits, settings.TombstoneRetransmits,
+	)
+}
+
+// settingsFor works out the settings for the member count. Note: not
+// synchronized!
+func (t *GossipTuner) settingsFor(members int) GossipSettings {
+	factor := math.Ceil(math.Log10(float64(members + 1)))
+	scale := math.Max(t.MinScale, math.Min(t.MaxScale, factor/GOSSIP_REFERENCE_FACTOR))
+
+	return GossipSettings{
+		Members:              members,
+		Scale:                scale,
+		GossipNodes:          t.base.GossipNodes,
+		GossipMessages:       t.base.GossipMessages,
+		AliveRetransmits:     scaled(t.base.AliveRetransmits, scale),
+		TombstoneRetransmits: scaled(t.base.TombstoneRetransmits, scale),
+	}
+}
+
+// scaled multiplies a setting by the scale, never going below one
+func scaled(value int, scale float64) int {
+	result := int(math.Ceil(float64(value) * scale))
+	if result < 1 {
+		return 1
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_GossipTuner(t *testing.T) {
+	Convey("GossipTuner", t, func() {
+		config := memberlist.DefaultLANConfig()
+		config.GossipNodes = 4
+		config.GossipMessages = 16
+		state := catalog.NewServicesState()
+
+		tuner := NewGossipTuner(config, state)
+
+		Convey("starts out with the configured settings", func() {
+			So(tuner.Settings(), ShouldResemble, GossipSettings{
+				Scale:                1,
+				GossipNodes:          4,
+				GossipMessages:       16,
+				AliveRetransmits:     catalog.ALIVE_COUNT,
+				TombstoneRetransmits: catalog.TOMBSTONE_COUNT,
+			})
+		})
+
+		Convey("keeps the configured settings for mid-sized clusters", func() {
+			tuner.tune(50)
+
+			So(tuner.Settings().Scale, ShouldEqual, 1)
+			So(config.GossipNodes, ShouldEqual, 4)
+			So(config.GossipMessages, ShouldEqual, 16)
+			So(state.Retransmits(), ShouldResemble, catalog.DefaultRetransmits)
+		})
+
+		Convey("gossips less in small clusters", func() {
+			tuner.tune(3)
+
+			So(tuner.Settings(), ShouldResemble, GossipSettings{
+				Members:              3,
+				Scale:                0.5,
+				GossipNodes:          4,
+				GossipMessages:       16,
+				AliveRetransmits:     3,
+				TombstoneRetransmits: 5,
+			})
+			So(config.GossipNodes, ShouldEqual, 4)
+			So(config.GossipMessages, ShouldEqual, 16)
+			So(state.Retransmits(), ShouldResemble, catalog.Retransmits{Alive: 3, Tombstone: 5})
+		})
+
+		Convey("gossips more in big clusters", func() {
+			tuner.tune(500)
+
+			So(tuner.Settings().Scale, ShouldEqual, 1.5)
+			So(state.Retransmits(), ShouldResemble, catalog.Retransmits{Alive: 8, Tombstone: 15})
+		})
+
+		Convey("stays within the bounds", func() {
+			tuner.MinScale = 0.75
+			tuner.MaxScale = 1.25
+
+			tuner.tune(2)
+			So(tuner.Settings().Scale, ShouldEqual, 0.75)
+
+			tuner.tune(5000)
+			So(tuner.Settings().Scale, ShouldEqual, 1.25)
+		})
+
+		Convey("scales from the configured settings, not the last ones", func() {
+			tuner.tune(3)
+			tuner.tune(500)
+
+			So(state.Retransmits(), ShouldResemble, catalog.Retransmits{Alive: 8, Tombstone: 15})
+		})
+
+		Convey("leaves Memberlist's config alone, since it reads it without a lock", func() {
+			tuner.tune(500)
+
+			So(config.GossipNodes, ShouldEqual, 4)
+			So(config.GossipMessages, ShouldEqual, 16)
+			So(tuner.Settings().GossipNodes, ShouldEqual, 4)
+		})
+
+		Convey("validates the bounds", func() {
+			So(tuner.ValidateBounds(), ShouldBeNil)
+
+			tuner.MinScale = 0
+			So(tuner.ValidateBounds(), ShouldNotBeNil)
+
+			tuner.MinScale = 2
+			tuner.MaxScale = 1
+			So(tuner.ValidateBounds(), ShouldNotBeNil)
+		})
+	})
+}
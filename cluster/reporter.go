@@ -0,0 +1,207 @@
+// Package cluster reports on the members of the Sidecar cluster: it keeps a
+// log of members joining, leaving, and changing, publishes them as metrics,
+// and logs the membership as configured.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	metrics "github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EVENT_LOG_LENGTH = 100 // How many member events we keep
+
+	// Verbosity settings for the periodic report
+	VerbosityNone    = "none"    // Only record events, never log
+	VerbosityChanges = "changes" // Log events, and the members when they change
+	VerbosityFull    = "full"    // Log every member and the state on every report
+
+	// Member event types
+	MemberJoin   = "join"
+	MemberLeave  = "leave"
+	MemberUpdate = "update"
+)
+
+// A MemberEvent records a member joining, leaving, or updating its metadata
+type MemberEvent struct {
+	Time    time.Time
+	Type    string
+	Name    string
+	Address string
+}
+
+// A Reporter keeps track of the members of the cluster. It sits in front of
+// Memberlist's event delegate so it sees every change as it happens, passing
+// the events on to Next.
+type Reporter struct {
+	Verbosity string
+	Next      memberlist.EventDelegate // Where events go after we've recorded them, may be nil
+
+	events      []MemberEvent
+	lastMembers string // The membership at the last report, to spot changes
+	now         func() time.Time
+	sync.Mutex
+}
+
+// NewReporter returns a Reporter with the verbosity, which must be one of
+// VerbosityNone, VerbosityChanges, or VerbosityFull. Empty means
+// VerbosityChanges.
+func NewReporter(verbosity string) (*Reporter, error) {
+	switch verbosity {
+	case "":
+		verbosity = VerbosityChanges
+	case VerbosityNone, VerbosityChanges, VerbosityFull:
+	default:
+		return nil, fmt.Errorf("unknown cluster report verbosity %q", verbosity)
+	}
+
+	return &Reporter{
+		Verbosity: verbosity,
+		now:       func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// NotifyJoin is part of the memberlist.EventDelegate interface
+func (r *Reporter) NotifyJoin(node *memberlist.Node) {
+	r.record(MemberJoin, node)
+	if r.Next != nil {
+		r.Next.NotifyJoin(node)
+	}
+}
+
+// NotifyLeave is part of the memberlist.EventDelegate interface
+func (r *Reporter) NotifyLeave(node *memberlist.Node) {
+	r.record(MemberLeave, node)
+	if r.Next != nil {
+		r.Next.NotifyLeave(node)
+	}
+}
+
+// NotifyUpdate is part of the memberlist.EventDelegate interface
+func (r *Reporter) NotifyUpdate(node *memberlist.Node) {
+	r.record(MemberUpdate, node)
+	if r.Next != nil {
+		r.Next.NotifyUpdate(node)
+	}
+}
+
+// Events returns the most recent member events, oldest first
+func (r *Reporter) Events() []MemberEvent {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.events) == 0 {
+		return nil
+	}
+
+	result := make([]MemberEvent, len(r.events))
+	copy(result, r.events)
+
+	return result
+}
+
+// Run reports on the members of the cluster until the looper quits or the
+// context is cancelled
+func (r *Reporter) Run(ctx context.Context, looper director.Looper,
+	list *memberlist.Memberlist, state *catalog.ServicesState) {
+
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
+	looper.Loop(func() error {
+		r.report(list.Members(), state)
+		return nil
+	})
+}
+
+// record adds an event to the log, dropping the oldest entry when we
+// already have EVENT_LOG_LENGTH of them
+func (r *Reporter) record(eventType string, node *memberlist.Node) {
+	event := MemberEvent{
+		Time:    r.now(),
+		Type:    eventType,
+		Name:    node.Name,
+		Address: node.Address(),
+	}
+
+	metrics.IncrCounter([]string{"cluster", "member_events", eventType}, 1)
+
+	r.Lock()
+	r.events = append(r.events, event)
+	if len(r.events) > EVENT_LOG_LENGTH {
+		r.events = append(r.events[:0], r.events[len(r.events)-EVENT_LOG_LENGTH:]...)
+	}
+	r.Unlock()
+
+	// Updates happen all the time, e.g. when a member restarts its services
+	switch {
+	case r.Verbosity == VerbosityNone:
+	case eventType == MemberUpdate:
+		log.Debugf("Cluster member %s (%s) updated", event.Name, event.Address)
+	default:
+		log.Infof("Cluster member %s (%s) %s", event.Name, event.Address, pastTense(eventType))
+	}
+}
+
+// report publishes the member count and logs the members as configured
+func (r *Reporter) report(members []*memberlist.Node, state *catalog.ServicesState) {
+	metrics.SetGauge([]string{"cluster", "members"}, float32(len(members)))
+
+	if r.Verbosity == VerbosityNone {
+		return
+	}
+
+	sort.Sort(catalog.ListByName(members))
+
+	descriptions := make([]string, 0, len(members))
+	for _, member := range members {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", member.Name, member.Address()))
+	}
+	current := strings.Join(descriptions, ", ")
+
+	r.Lock()
+	changed := current != r.lastMembers
+	r.lastMembers = current
+	r.Unlock()
+
+	if r.Verbosity == VerbosityChanges {
+		if changed {
+			log.Infof("Cluster has %d members: %s", len(members), current)
+		}
+		return
+	}
+
+	for _, member := range members {
+		log.Infof("Member: %s %s", member.Name, member.Address())
+		log.Infof("Meta: %s", string(member.Meta))
+	}
+
+	if state != nil {
+		state.RLock()
+		log.Info(state.Format(nil))
+		state.RUnlock()
+	}
+}
+
+func pastTense(eventType string) string {
+	switch eventType {
+	case MemberJoin:
+		return "joined"
+	case MemberLeave:
+		return "left"
+	default:
+		return eventType
+	}
+}
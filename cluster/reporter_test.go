@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingDelegate struct {
+	joined, left, updated []string
+}
+
+func (d *recordingDelegate) NotifyJoin(node *memberlist.Node)   { d.joined = append(d.joined, node.Name) }
+func (d *recordingDelegate) NotifyLeave(node *memberlist.Node)  { d.left = append(d.left, node.Name) }
+func (d *recordingDelegate) NotifyUpdate(node *memberlist.Node) { d.updated = append(d.updated, node.Name) }
+
+func Test_Reporter(t *testing.T) {
+	Convey("Reporter", t, func() {
+		next := &recordingDelegate{}
+
+		reporter, err := NewReporter(VerbosityChanges)
+		So(err, ShouldBeNil)
+		reporter.Next = next
+
+		now := time.Now().UTC().Round(time.Second)
+		reporter.now = func() time.Time { return now }
+
+		beowulf := &memberlist.Node{Name: "beowulf", Addr: net.ParseIP("10.0.0.1"), Port: 7946}
+		grendel := &memberlist.Node{Name: "grendel", Addr: net.ParseIP("10.0.0.2"), Port: 7946}
+
+		Convey("records member events and passes them on", func() {
+			reporter.NotifyJoin(beowulf)
+			reporter.NotifyUpdate(beowulf)
+			reporter.NotifyLeave(beowulf)
+
+			So(reporter.Events(), ShouldResemble, []MemberEvent{
+				{Time: now, Type: MemberJoin, Name: "beowulf", Address: "10.0.0.1:7946"},
+				{Time: now, Type: MemberUpdate, Name: "beowulf", Address: "10.0.0.1:7946"},
+				{Time: now, Type: MemberLeave, Name: "beowulf", Address: "10.0.0.1:7946"},
+			})
+
+			So(next.joined, ShouldResemble, []string{"beowulf"})
+			So(next.updated, ShouldResemble, []string{"beowulf"})
+			So(next.left, ShouldResemble, []string{"beowulf"})
+		})
+
+		Convey("works without a next delegate", func() {
+			reporter.Next = nil
+			reporter.NotifyJoin(beowulf)
+			So(len(reporter.Events()), ShouldEqual, 1)
+		})
+
+		Convey("only keeps the most recent events", func() {
+			for i := 0; i < EVENT_LOG_LENGTH+5; i++ {
+				reporter.NotifyUpdate(beowulf)
+			}
+			reporter.NotifyJoin(grendel)
+
+			events := reporter.Events()
+			So(len(events), ShouldEqual, EVENT_LOG_LENGTH)
+			So(events[len(events)-1].Name, ShouldEqual, "grendel")
+		})
+
+		Convey("notices when the members change", func() {
+			reporter.report([]*memberlist.Node{beowulf}, nil)
+			So(reporter.lastMembers, ShouldEqual, "beowulf (10.0.0.1:7946)")
+
+			reporter.report([]*memberlist.Node{grendel, beowulf}, nil)
+			So(reporter.lastMembers, ShouldEqual, "beowulf (10.0.0.1:7946), grendel (10.0.0.2:7946)")
+		})
+
+		Convey("rejects an unknown verbosity", func() {
+			_, err := NewReporter("chatty")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -9,60 +9,202 @@ import (
 )
 
 type ListenerUrlsConfig struct {
-	Urls []string `envconfig:"URLS"`
+	Urls            []string `envconfig:"URLS"`
+	LeaderUrls      []string `envconfig:"LEADER_URLS"`       // Only posted to by the cluster leader
+	SigningKeysFile string   `envconfig:"SIGNING_KEYS_FILE"` // Sign each post with the first of these keys
+	SigningMethod   string   `envconfig:"SIGNING_METHOD" default:"jwt"`
+	TLSCert         string   `envconfig:"TLS_CERT"` // Client certificate presented to receivers
+	TLSKey          string   `envconfig:"TLS_KEY"`
+	TLSCA           string   `envconfig:"TLS_CA"` // Receivers' certificates must be signed by this
 }
 
 type HAproxyConfig struct {
 	ReloadCmd    string `envconfig:"RELOAD_COMMAND"`
 	VerifyCmd    string `envconfig:"VERIFY_COMMAND"`
 	BindIP       string `envconfig:"BIND_IP" default:"192.168.168.168"`
-	TemplateFile string `envconfig:"TEMPLATE_FILE" default:"views/haproxy.cfg"`
+	TemplateFile string `envconfig:"TEMPLATE_FILE"`
+	TemplateDir  string `envconfig:"TEMPLATE_DIR"`
 	ConfigFile   string `envconfig:"CONFIG_FILE" default:"/etc/haproxy.cfg"`
 	PidFile      string `envconfig:"PID_FILE" default:"/var/run/haproxy.pid"`
 	Disable      bool   `envconfig:"DISABLE"`
 	User         string `envconfig:"USER" default:"haproxy"`
 	Group        string `envconfig:"GROUP" default:""`
 	UseHostnames bool   `envconfig:"USE_HOSTNAMES"`
+
+	TemplateWatchInterval time.Duration `envconfig:"TEMPLATE_WATCH_INTERVAL" default:"5s"`
 }
 
 type EnvoyConfig struct {
-	UseGRPCAPI   bool   `envconfig:"USE_GRPC_API" default:"true"`
-	BindIP       string `envconfig:"BIND_IP" default:"192.168.168.168"`
-	UseHostnames bool   `envconfig:"USE_HOSTNAMES"`
-	GRPCPort     string `envconfig:"GRPC_PORT" default:"7776"`
+	UseGRPCAPI    bool   `envconfig:"USE_GRPC_API" default:"true"`
+	BindIP        string `envconfig:"BIND_IP" default:"192.168.168.168"`
+	UseHostnames  bool   `envconfig:"USE_HOSTNAMES"`
+	UseRDS        bool   `envconfig:"USE_RDS" default:"false"`
+	GRPCPort      string `envconfig:"GRPC_PORT" default:"7776"`
+	TLSCertDir    string `envconfig:"TLS_CERT_DIR"`
+	TLSSdsCluster string `envconfig:"TLS_SDS_CLUSTER"`
+
+	// How long Envoy keeps upstream connections open. Services can override these with tags.
+	IdleTimeout           time.Duration `envconfig:"IDLE_TIMEOUT" default:"60s"`
+	MaxConnectionDuration time.Duration `envconfig:"MAX_CONNECTION_DURATION" default:"0s"`
+
+	BindAddrs []string `envconfig:"BIND_ADDRS"` // name=address entries services can listen on
+
+	// What Envoy is left with when we shut down, and how long we take about it
+	ShutdownMode       string        `envconfig:"SHUTDOWN_MODE" default:"keep"` // keep or drain
+	ShutdownDrainDelay time.Duration `envconfig:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	ShutdownTimeout    time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"10s"`
+
+	// Who may connect to the gRPC API. All empty leaves it open.
+	GRPCTLSCert   string `envconfig:"GRPC_TLS_CERT"`   // PEM certificate to serve TLS with
+	GRPCTLSKey    string `envconfig:"GRPC_TLS_KEY"`    // and its key
+	GRPCClientCA  string `envconfig:"GRPC_CLIENT_CA"`  // Require client certificates signed by this CA
+	GRPCTokenFile string `envconfig:"GRPC_TOKEN_FILE"` // Require one of these bearer tokens, one per line
+}
+
+// BindIP, Port, and the dirs have no envconfig tag, which would also make
+// envconfig read the bare $BIND_IP, $PORT, etc. Only HTTP_BIND_IP,
+// HTTP_PORT, etc. set them.
+type HttpConfig struct {
+	BindIP               string        `split_words:"true" default:"0.0.0.0"`
+	Port                 int           `default:"7777"`
+	ReadHeaderTimeout    time.Duration `envconfig:"READ_HEADER_TIMEOUT" default:"10s"`
+	ReadTimeout          time.Duration `envconfig:"READ_TIMEOUT" default:"0s"`
+	WriteTimeout         time.Duration `envconfig:"WRITE_TIMEOUT" default:"0s"`
+	IdleTimeout          time.Duration `envconfig:"IDLE_TIMEOUT" default:"2m"`
+	MaxHeaderBytes       int           `envconfig:"MAX_HEADER_BYTES" default:"1048576"`
+	EnableH2C            bool          `envconfig:"ENABLE_H2C" default:"true"`
+	AccessLog            bool          `envconfig:"ACCESS_LOG" default:"false"`
+	SlowRequestThreshold time.Duration `envconfig:"SLOW_REQUEST_THRESHOLD" default:"1s"`
+	HideTombstones       bool          `envconfig:"HIDE_TOMBSTONES" default:"false"`
+	CORSAllowedOrigins   []string      `envconfig:"CORS_ALLOWED_ORIGINS" default:"*"`
+	CORSAllowedMethods   []string      `envconfig:"CORS_ALLOWED_METHODS" default:"GET"`
+	CORSAllowedHeaders   []string      `envconfig:"CORS_ALLOWED_HEADERS"`
+	UiDir                string        `split_words:"true"` // Serve the UI from here, empty for the copy in the binary. Ui so it splits to UI_DIR.
+	StaticDir            string        `split_words:"true"` // Likewise for the static files
+}
+
+// Untagged like HttpConfig's, so that a bare $PORT can't put both servers on
+// the same port
+type DiagnosticsConfig struct {
+	BindIP               string `split_words:"true" default:"127.0.0.1"`
+	Port                 int    `default:"7779"` // 0 disables the diagnostics server
+	Pprof                bool   `envconfig:"PPROF" default:"true"`
+	Expvar               bool   `envconfig:"EXPVAR" default:"true"`
+	MutexProfileFraction int    `envconfig:"MUTEX_PROFILE_FRACTION" default:"0"`
+	BlockProfileRate     int    `envconfig:"BLOCK_PROFILE_RATE" default:"0"`
+}
+
+// Timeout is untagged, so that a bare $TIMEOUT can't change it
+type NotifyConfig struct {
+	WebhookURLs      []string      `envconfig:"WEBHOOK_URLS"`
+	SlackURLs        []string      `envconfig:"SLACK_URLS"`
+	PagerDutyKey     string        `envconfig:"PAGERDUTY_ROUTING_KEY"`
+	ServiceDownDelay time.Duration `envconfig:"SERVICE_DOWN_DELAY" default:"30s"`
+	HAproxyFailures  int           `envconfig:"HAPROXY_FAILURES" default:"3"`
+	Timeout          time.Duration `default:"10s"`
 }
 
 type ServicesConfig struct {
 	NameMatch    string `envconfig:"NAME_MATCH"`
 	ServiceNamer string `envconfig:"NAMER" default:"docker_label"`
 	NameLabel    string `envconfig:"NAME_LABEL" default:"ServiceName"`
+	IDStrategy   string `envconfig:"ID_STRATEGY" default:"native"`
 }
 
 type SidecarConfig struct {
 	ExcludeIPs             []string      `envconfig:"EXCLUDE_IPS" default:"192.168.168.168"`
+	Hostname               string        // No tag, so only SIDECAR_HOSTNAME sets it, not $HOSTNAME
 	Discovery              []string      `envconfig:"DISCOVERY" default:"docker"`
 	StatsAddr              string        `envconfig:"STATS_ADDR"`
 	PushPullInterval       time.Duration `envconfig:"PUSH_PULL_INTERVAL" default:"20s"`
 	GossipMessages         int           `envconfig:"GOSSIP_MESSAGES" default:"15"`
 	GossipInterval         time.Duration `envconfig:"GOSSIP_INTERVAL" default:"200ms"`
+	GossipAutoScale        bool          `envconfig:"GOSSIP_AUTO_SCALE" default:"false"`
+	GossipMinScale         float64       `envconfig:"GOSSIP_MIN_SCALE" default:"0.5"`
+	GossipMaxScale         float64       `envconfig:"GOSSIP_MAX_SCALE" default:"2.0"`
 	HandoffQueueDepth      int           `envconfig:"HANDOFF_QUEUE_DEPTH" default:"1024"`
 	LoggingFormat          string        `envconfig:"LOGGING_FORMAT"`
 	LoggingLevel           string        `envconfig:"LOGGING_LEVEL" default:"info"`
+	LoggingOutput          []string      `envconfig:"LOGGING_OUTPUT" default:"stdout"`
+	LoggingFile            string        `envconfig:"LOGGING_FILE" default:"/var/log/sidecar.log"`
+	LoggingFileMaxSize     int64         `envconfig:"LOGGING_FILE_MAX_SIZE" default:"104857600"`
+	LoggingFileMaxAge      time.Duration `envconfig:"LOGGING_FILE_MAX_AGE" default:"24h"`
+	LoggingFileMaxBackups  int           `envconfig:"LOGGING_FILE_MAX_BACKUPS" default:"5"`
+	LoggingSyslogAddr      string        `envconfig:"LOGGING_SYSLOG_ADDR"`
 	DefaultCheckEndpoint   string        `envconfig:"DEFAULT_CHECK_ENDPOINT" default:"/version"`
+	CheckAggregation       string        `envconfig:"CHECK_AGGREGATION" default:"all"`
+	CheckPolicy            string        `envconfig:"CHECK_POLICY" default:"threshold"`
+	CheckDefaultsFile      string        `envconfig:"CHECK_DEFAULTS_FILE"`
+	TcpCheckTimeout        time.Duration `envconfig:"TCP_CHECK_TIMEOUT" default:"2s"`
+	HalfOpenWait           time.Duration `envconfig:"HALF_OPEN_WAIT" default:"100ms"`
+	HalfOpenThreshold      int           `envconfig:"HALF_OPEN_THRESHOLD" default:"1"`
+	TemplateOutputsFile    string        `envconfig:"TEMPLATE_OUTPUTS_FILE"`
+	PrometheusTargetsFile  string        `envconfig:"PROMETHEUS_TARGETS_FILE"`
 	Seeds                  []string      `envconfig:"SEEDS"`
 	ClusterName            string        `envconfig:"CLUSTER_NAME" default:"default"`
 	AdvertiseIP            string        `envconfig:"ADVERTISE_IP"`
 	BindPort               int           `envconfig:"BIND_PORT" default:"7946"`
+	GossipTransport        string        `envconfig:"GOSSIP_TRANSPORT" default:"udp"`
+	GossipCompression      string        `envconfig:"GOSSIP_COMPRESSION"`
 	Debug                  bool          `envconfig:"DEBUG" default:"false"`
+	ClusterReportInterval  time.Duration `envconfig:"CLUSTER_REPORT_INTERVAL" default:"10s"`
+	ClusterReportVerbosity string        `envconfig:"CLUSTER_REPORT_VERBOSITY" default:"changes"`
 	DiscoverySleepInterval time.Duration `envconfig:"DISCOVERY_SLEEP_INTERVAL" default:"1s"`
+	DiscoveryFailures      int           `envconfig:"DISCOVERY_FAILURES" default:"3"`
+	DiscoveryTimeout       time.Duration `envconfig:"DISCOVERY_TIMEOUT" default:"5s"`
+	DiscoveryPrecedence    []string      `envconfig:"DISCOVERY_PRECEDENCE"`
+	ServicePortRanges      []string      `envconfig:"SERVICE_PORT_RANGES"`
+	ServicePortPool        []string      `envconfig:"SERVICE_PORT_POOL"`
+	ServicePortFile        string        `envconfig:"SERVICE_PORT_FILE" default:"/var/lib/sidecar-service-ports.json"`
+	CheckerNode            bool          `envconfig:"CHECKER_NODE" default:"false"`
+	DrainTTL               time.Duration `envconfig:"DRAIN_TTL" default:"0s"`
+	CheckMaxAge            time.Duration `envconfig:"CHECK_MAX_AGE" default:"0s"`
+	EphemeralRetention     time.Duration `envconfig:"EPHEMERAL_TOMBSTONE_RETENTION" default:"10m"`
+	BroadcastJitter        time.Duration `envconfig:"BROADCAST_JITTER" default:"5s"`
+	ValidationPolicy       string        `envconfig:"VALIDATION_POLICY" default:"clamp"`
+	MaxClockSkew           time.Duration `envconfig:"MAX_CLOCK_SKEW" default:"5s"`
+	CompensateClockSkew    bool          `envconfig:"COMPENSATE_CLOCK_SKEW" default:"false"`
+	HostnameNormalization  string        `envconfig:"HOSTNAME_NORMALIZATION"`
+	LoadWeighting          string        `envconfig:"LOAD_WEIGHTING"`
+	LoadWeightingMin       int           `envconfig:"LOAD_WEIGHTING_MIN" default:"10"`
+	LoadWeightingMax       int           `envconfig:"LOAD_WEIGHTING_MAX" default:"200"`
+	LoadWeightingDamping   float64       `envconfig:"LOAD_WEIGHTING_DAMPING" default:"0.3"`
+	Dev                    bool          `default:"false"` // Untagged, so that a bare $DEV can't fake the services
+	DevTimeScale           float64       `envconfig:"DEV_TIME_SCALE" default:"10"`
+	DevChurnInterval       time.Duration `envconfig:"DEV_CHURN_INTERVAL" default:"30s"`
+
+	// Advertised with the node and added to its services' tags, e.g. "rack:r12"
+	NodeLabels map[string]string `envconfig:"NODE_LABELS"`
+
+	// How long to keep tombstones, by service name, e.g. "batch-job:15m"
+	TombstoneRetention map[string]time.Duration `envconfig:"TOMBSTONE_RETENTION"`
+
+	// Garbage collector tuning for nodes with a big state. Zero leaves each
+	// alone. GOGC is untagged, so that only SIDECAR_GOGC sets it, and the
+	// runtime's own $GOGC, which may be "off", is left to the runtime.
+	GOGC             int // GC target percentage, overrides $GOGC
+	HeapBallastMB    int `envconfig:"HEAP_BALLAST_MB"`   // Size of the heap ballast
+	ExpectedServices int `envconfig:"EXPECTED_SERVICES"` // Size the ballast for this many services instead
 }
 
 type DockerConfig struct {
-	DockerURL string `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	DockerURL     string        `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	StatsInterval time.Duration `envconfig:"STATS_INTERVAL" default:"0s"`
+	Client        string        `default:"legacy"`              // Untagged, so that a bare $CLIENT can't change it
+	ApiVersion    string        `split_words:"true" default:""` // Api so it splits to API_VERSION
+	CacheSize     int           `envconfig:"CACHE_SIZE" default:"512"`
+	CacheTTL      time.Duration `envconfig:"CACHE_TTL" default:"10m"`
+	Networks      []string      `envconfig:"NETWORKS"` // Advertise container IPs on these networks, in order
 }
 
 type StaticConfig struct {
-	ConfigFile string `envconfig:"CONFIG_FILE" default:"static.json"`
+	ConfigFile string        `envconfig:"CONFIG_FILE" default:"static.json"`
+	DefaultTTL time.Duration `envconfig:"DEFAULT_TTL" default:"0s"` // For targets without a TTL, 0 for forever
+}
+
+type ComposeConfig struct {
+	File        string `default:"docker-compose.yml"` // Untagged, so that a bare $FILE can't change it
+	ProjectName string `envconfig:"PROJECT_NAME"`
 }
 
 type K8sAPIConfig struct {
@@ -74,15 +216,31 @@ type K8sAPIConfig struct {
 	AnnounceAllNodes bool          `envconfig:"ANNOUNCE_ALL_NODES" default:"false"`
 }
 
+// The fields are untagged, so that only the STATE_UPLOAD_ variables set them,
+// and a bare $URL, $REGION, or $TIMEOUT meant for something else can't
+type StateUploadConfig struct {
+	URL             string        // s3://bucket/key or gs://bucket/key, empty to disable
+	Endpoint        string        // Overrides where requests go, e.g. for MinIO
+	Region          string        `default:"us-east-1"`
+	MinInterval     time.Duration `split_words:"true" default:"1m"`
+	RefreshInterval time.Duration `split_words:"true" default:"10m"`
+	Timeout         time.Duration `default:"30s"`
+}
+
 type Config struct {
-	Sidecar         SidecarConfig      // SIDECAR_
-	DockerDiscovery DockerConfig       // DOCKER_
-	StaticDiscovery StaticConfig       // STATIC_
-	K8sAPIDiscovery K8sAPIConfig       // K8S_
-	Services        ServicesConfig     // SERVICES_
-	HAproxy         HAproxyConfig      // HAPROXY_
-	Envoy           EnvoyConfig        // ENVOY_
-	Listeners       ListenerUrlsConfig // LISTENERS_
+	Sidecar          SidecarConfig      // SIDECAR_
+	DockerDiscovery  DockerConfig       // DOCKER_
+	StaticDiscovery  StaticConfig       // STATIC_
+	ComposeDiscovery ComposeConfig      // COMPOSE_
+	K8sAPIDiscovery  K8sAPIConfig       // K8S_
+	Services         ServicesConfig     // SERVICES_
+	HAproxy          HAproxyConfig      // HAPROXY_
+	Envoy            EnvoyConfig        // ENVOY_
+	Http             HttpConfig         // HTTP_
+	Listeners        ListenerUrlsConfig // LISTENERS_
+	Diagnostics      DiagnosticsConfig  // DIAGNOSTICS_
+	Notify           NotifyConfig       // NOTIFY_
+	StateUpload      StateUploadConfig  // STATE_UPLOAD_
 }
 
 func ParseConfig() *Config {
@@ -92,11 +250,16 @@ func ParseConfig() *Config {
 		envconfig.Process("sidecar", &config.Sidecar),
 		envconfig.Process("docker", &config.DockerDiscovery),
 		envconfig.Process("static", &config.StaticDiscovery),
+		envconfig.Process("compose", &config.ComposeDiscovery),
 		envconfig.Process("k8s", &config.K8sAPIDiscovery),
 		envconfig.Process("services", &config.Services),
 		envconfig.Process("haproxy", &config.HAproxy),
 		envconfig.Process("envoy", &config.Envoy),
+		envconfig.Process("http", &config.Http),
 		envconfig.Process("listeners", &config.Listeners),
+		envconfig.Process("diagnostics", &config.Diagnostics),
+		envconfig.Process("notify", &config.Notify),
+		envconfig.Process("state_upload", &config.StateUpload),
 	}
 
 	for _, err := range errs {
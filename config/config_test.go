@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// withEnv sets the environment variables for the duration of fn, putting
+// back what was there before
+func withEnv(vars map[string]string, fn func()) {
+	for name, value := range vars {
+		old, wasSet := os.LookupEnv(name)
+		os.Setenv(name, value)
+		if wasSet {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	fn()
+}
+
+func Test_ParseConfig(t *testing.T) {
+	Convey("ParseConfig()", t, func() {
+		Convey("doesn't take the hostname from $HOSTNAME", func() {
+			withEnv(map[string]string{"HOSTNAME": "from-the-shell"}, func() {
+				So(ParseConfig().Sidecar.Hostname, ShouldBeEmpty)
+			})
+
+			withEnv(map[string]string{"SIDECAR_HOSTNAME": "chaucer"}, func() {
+				So(ParseConfig().Sidecar.Hostname, ShouldEqual, "chaucer")
+			})
+		})
+
+		Convey("only runs in dev mode when SIDECAR_DEV is set", func() {
+			withEnv(map[string]string{"DEV": "true"}, func() {
+				So(ParseConfig().Sidecar.Dev, ShouldBeFalse)
+			})
+
+			withEnv(map[string]string{"SIDECAR_DEV": "true"}, func() {
+				So(ParseConfig().Sidecar.Dev, ShouldBeTrue)
+			})
+		})
+
+		Convey("takes ports and bind IPs only from their own prefixed variables", func() {
+			withEnv(map[string]string{"PORT": "8080", "BIND_IP": "10.0.0.1"}, func() {
+				config := ParseConfig()
+				So(config.Http.Port, ShouldEqual, 7777)
+				So(config.Http.BindIP, ShouldEqual, "0.0.0.0")
+			})
+
+			withEnv(map[string]string{"PORT": "8080"}, func() {
+				config := ParseConfig()
+				So(config.Diagnostics.Port, ShouldEqual, 7779)
+				So(config.Diagnostics.Port, ShouldNotEqual, config.Http.Port)
+			})
+
+			withEnv(map[string]string{"HTTP_PORT": "8080", "HTTP_BIND_IP": "10.0.0.1"}, func() {
+				config := ParseConfig()
+				So(config.Http.Port, ShouldEqual, 8080)
+				So(config.Http.BindIP, ShouldEqual, "10.0.0.1")
+			})
+
+			withEnv(map[string]string{"DIAGNOSTICS_PORT": "9090"}, func() {
+				So(ParseConfig().Diagnostics.Port, ShouldEqual, 9090)
+			})
+		})
+
+		Convey("takes the UI and static dirs from HTTP_UI_DIR and HTTP_STATIC_DIR", func() {
+			withEnv(map[string]string{"UI_DIR": "/tmp", "STATIC_DIR": "/tmp"}, func() {
+				config := ParseConfig()
+				So(config.Http.UiDir, ShouldBeEmpty)
+				So(config.Http.StaticDir, ShouldBeEmpty)
+			})
+
+			withEnv(map[string]string{"HTTP_UI_DIR": "/sidecar/ui/app", "HTTP_STATIC_DIR": "/sidecar/views/static"}, func() {
+				config := ParseConfig()
+				So(config.Http.UiDir, ShouldEqual, "/sidecar/ui/app")
+				So(config.Http.StaticDir, ShouldEqual, "/sidecar/views/static")
+			})
+		})
+
+		Convey("takes the GC target only from SIDECAR_GOGC", func() {
+			withEnv(map[string]string{"GOGC": "off"}, func() {
+				So(ParseConfig().Sidecar.GOGC, ShouldEqual, 0)
+			})
+
+			withEnv(map[string]string{"GOGC": "off", "SIDECAR_GOGC": "200"}, func() {
+				So(ParseConfig().Sidecar.GOGC, ShouldEqual, 200)
+			})
+		})
+
+		Convey("takes the notification timeout only from NOTIFY_TIMEOUT", func() {
+			withEnv(map[string]string{"TIMEOUT": "1ms"}, func() {
+				So(ParseConfig().Notify.Timeout, ShouldEqual, 10*time.Second)
+			})
+
+			withEnv(map[string]string{"NOTIFY_TIMEOUT": "3s"}, func() {
+				So(ParseConfig().Notify.Timeout, ShouldEqual, 3*time.Second)
+			})
+		})
+
+		Convey("only uploads the state when STATE_UPLOAD_URL is set", func() {
+			withEnv(map[string]string{"URL": "http://example.com/x"}, func() {
+				So(ParseConfig().StateUpload.URL, ShouldBeEmpty)
+			})
+
+			withEnv(map[string]string{"STATE_UPLOAD_URL": "s3://bucket/state.json.gz"}, func() {
+				So(ParseConfig().StateUpload.URL, ShouldEqual, "s3://bucket/state.json.gz")
+			})
+		})
+
+		Convey("takes the rest of the upload settings only from STATE_UPLOAD_", func() {
+			bare := map[string]string{
+				"ENDPOINT": "http://example.com", "REGION": "eu-west-1", "TIMEOUT": "1s",
+				"MIN_INTERVAL": "1s", "REFRESH_INTERVAL": "1s",
+			}
+			withEnv(bare, func() {
+				upload := ParseConfig().StateUpload
+				So(upload.Endpoint, ShouldBeEmpty)
+				So(upload.Region, ShouldEqual, "us-east-1")
+				So(upload.Timeout, ShouldEqual, 30*time.Second)
+				So(upload.MinInterval, ShouldEqual, time.Minute)
+				So(upload.RefreshInterval, ShouldEqual, 10*time.Minute)
+			})
+
+			prefixed := map[string]string{
+				"STATE_UPLOAD_ENDPOINT": "http://example.com", "STATE_UPLOAD_REGION": "eu-west-1",
+				"STATE_UPLOAD_TIMEOUT": "1s", "STATE_UPLOAD_MIN_INTERVAL": "2s",
+				"STATE_UPLOAD_REFRESH_INTERVAL": "3s",
+			}
+			withEnv(prefixed, func() {
+				upload := ParseConfig().StateUpload
+				So(upload.Endpoint, ShouldEqual, "http://example.com")
+				So(upload.Region, ShouldEqual, "eu-west-1")
+				So(upload.Timeout, ShouldEqual, time.Second)
+				So(upload.MinInterval, ShouldEqual, 2*time.Second)
+				So(upload.RefreshInterval, ShouldEqual, 3*time.Second)
+			})
+		})
+
+		Convey("takes the Compose file only from COMPOSE_FILE", func() {
+			withEnv(map[string]string{"FILE": "/tmp/other.yml"}, func() {
+				So(ParseConfig().ComposeDiscovery.File, ShouldEqual, "docker-compose.yml")
+			})
+
+			withEnv(map[string]string{"COMPOSE_FILE": "/tmp/compose.yml"}, func() {
+				So(ParseConfig().ComposeDiscovery.File, ShouldEqual, "/tmp/compose.yml")
+			})
+		})
+
+		Convey("uses go-dockerclient unless DOCKER_CLIENT asks for the SDK", func() {
+			withEnv(map[string]string{"CLIENT": "sdk", "API_VERSION": "1.40"}, func() {
+				docker := ParseConfig().DockerDiscovery
+				So(docker.Client, ShouldEqual, "legacy")
+				So(docker.ApiVersion, ShouldBeEmpty)
+			})
+
+			withEnv(map[string]string{"DOCKER_CLIENT": "sdk", "DOCKER_API_VERSION": "1.40"}, func() {
+				docker := ParseConfig().DockerDiscovery
+				So(docker.Client, ShouldEqual, "sdk")
+				So(docker.ApiVersion, ShouldEqual, "1.40")
+			})
+		})
+	})
+}
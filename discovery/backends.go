@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// BackendStatus describes how one of the Discoverers in a MultiDiscovery is
+// answering
+type BackendStatus struct {
+	Name                string
+	Services            int           // How many it found last time it answered
+	LastSuccess         time.Time     // When it last answered, zero if never
+	LastDuration        time.Duration // How long that took
+	ConsecutiveTimeouts int           // Calls since then that it didn't answer in time
+	Timeouts            int           // Calls it didn't answer in time, ever
+	Health              *Health       `json:",omitempty"` // nil unless it reports its own
+	Expired             []string      `json:",omitempty"` // IDs of the services whose registration ran out
+}
+
+// An ExpiryReporter is a Discoverer whose registrations can run out, and
+// which stops announcing them when they do
+type ExpiryReporter interface {
+	Expired() []string
+}
+
+// A StatusReporter is a Discoverer made up of other Discoverers that can say
+// how each of them is doing
+type StatusReporter interface {
+	BackendStatuses() []BackendStatus
+}
+
+// A backend is where we keep the last answers from one of the Discoverers,
+// to use when it doesn't answer in time
+type backend struct {
+	status    BackendStatus
+	services  []service.Service
+	listeners []ChangeListener
+	inflight  map[string]chan struct{} // Calls that haven't returned yet, by kind
+}
+
+// Add appends a Discoverer, with the name it is reported under
+func (d *MultiDiscovery) Add(name string, disco Discoverer) {
+	d.Discoverers = append(d.Discoverers, disco)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for len(d.names) < len(d.Discoverers)-1 {
+		d.names = append(d.names, "")
+	}
+	d.names = append(d.names, name)
+}
+
+// BackendStatuses returns the status of each of the Discoverers, in order
+func (d *MultiDiscovery) BackendStatuses() []BackendStatus {
+	backends := d.getBackends()
+
+	statuses := make([]BackendStatus, len(backends))
+	d.lock.Lock()
+	for i, b := range backends {
+		statuses[i] = b.status
+	}
+	d.lock.Unlock()
+
+	for i, disco := range d.Discoverers {
+		if reporter, ok := disco.(HealthReporter); ok {
+			health := reporter.Health()
+			statuses[i].Health = &health
+		}
+		if reporter, ok := disco.(ExpiryReporter); ok {
+			statuses[i].Expired = reporter.Expired()
+		}
+	}
+
+	return statuses
+}
+
+// getBackends returns the backend for each of the Discoverers, setting them
+// up the first time
+func (d *MultiDiscovery) getBackends() []*backend {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i := len(d.backends); i < len(d.Discoverers); i++ {
+		name := fmt.Sprintf("%T", d.Discoverers[i])
+		if i < len(d.names) && d.names[i] != "" {
+			name = d.names[i]
+		}
+
+		d.backends = append(d.backends, &backend{
+			status:   BackendStatus{Name: name},
+			inflight: make(map[string]chan struct{}),
+		})
+	}
+
+	return d.backends
+}
+
+// callAll makes the call on all the Discoverers at once, and waits up to
+// Timeout for each of them. The call stores what the Discoverer returned in
+// its backend, holding the lock. A call that doesn't return in time carries
+// on in the background, so one that hangs leaves the last answer in place,
+// and later callers wait on it rather than starting another.
+func (d *MultiDiscovery) callAll(kind string, call func(disco Discoverer, b *backend)) {
+	backends := d.getBackends()
+
+	results := make(chan struct{}, len(backends))
+	for i, disco := range d.Discoverers {
+		go func(disco Discoverer, b *backend) {
+			d.await(kind, b, d.start(kind, disco, b, call))
+			results <- struct{}{}
+		}(disco, backends[i])
+	}
+
+	for range backends {
+		<-results
+	}
+}
+
+// start makes the call on one Discoverer, unless there's one going already,
+// and returns the channel that is closed when it returns
+func (d *MultiDiscovery) start(kind string, disco Discoverer, b *backend, call func(Discoverer, *backend)) chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if done, ok := b.inflight[kind]; ok {
+		return done
+	}
+
+	done := make(chan struct{})
+	b.inflight[kind] = done
+
+	go func() {
+		started := time.Now()
+		call(disco, b)
+
+		d.lock.Lock()
+		delete(b.inflight, kind)
+		b.status.LastSuccess = time.Now().UTC()
+		b.status.LastDuration = time.Since(started)
+		b.status.ConsecutiveTimeouts = 0
+		d.lock.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+// await waits up to Timeout for a call to return, and counts it when it
+// doesn't
+func (d *MultiDiscovery) await(kind string, b *backend, done chan struct{}) {
+	if d.Timeout <= 0 {
+		<-done
+		return
+	}
+
+	timer := time.NewTimer(d.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	d.lock.Lock()
+	b.status.ConsecutiveTimeouts++
+	b.status.Timeouts++
+	name := b.status.Name
+	first := b.status.ConsecutiveTimeouts == 1
+	d.lock.Unlock()
+
+	metrics.IncrCounter([]string{"discovery", "timeouts"}, 1)
+	if first {
+		log.Warnf("Discovery backend %s didn't return its %s within %s, using the last ones it returned",
+			name, kind, d.Timeout,
+		)
+	}
+}
@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A Discoverer that doesn't answer until it's released
+type stuckDiscoverer struct {
+	mockDiscoverer
+	release chan struct{}
+	calls   chan struct{}
+}
+
+func (s *stuckDiscoverer) Services() []service.Service {
+	s.calls <- struct{}{}
+	<-s.release
+	return s.ServicesList
+}
+
+func Test_MultiDiscoveryBackends(t *testing.T) {
+	Convey("MultiDiscovery backends", t, func() {
+		quick := &mockDiscoverer{ServicesList: []service.Service{{ID: "deadbeef123", Name: "bocaccio"}}}
+		stuck := &stuckDiscoverer{
+			mockDiscoverer: mockDiscoverer{ServicesList: []service.Service{{ID: "deadbeef456", Name: "dante"}}},
+			release:        make(chan struct{}),
+			calls:          make(chan struct{}, 10),
+		}
+
+		disco := &MultiDiscovery{Timeout: 20 * time.Millisecond}
+		disco.Add("static", quick)
+		disco.Add("kubernetes_api", stuck)
+
+		Convey("don't hold each other up", func() {
+			start := time.Now()
+			services := disco.Services()
+
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldEqual, "deadbeef123")
+
+			statuses := disco.BackendStatuses()
+			So(len(statuses), ShouldEqual, 2)
+			So(statuses[0].Name, ShouldEqual, "static")
+			So(statuses[0].Services, ShouldEqual, 1)
+			So(statuses[0].LastSuccess.IsZero(), ShouldBeFalse)
+			So(statuses[1].Name, ShouldEqual, "kubernetes_api")
+			So(statuses[1].LastSuccess.IsZero(), ShouldBeTrue)
+			So(statuses[1].ConsecutiveTimeouts, ShouldEqual, 1)
+
+			close(stuck.release)
+		})
+
+		Convey("wait on a call that's still going rather than making another", func() {
+			disco.Services()
+			disco.Services()
+
+			So(len(stuck.calls), ShouldEqual, 1)
+			So(disco.BackendStatuses()[1].Timeouts, ShouldEqual, 2)
+
+			close(stuck.release)
+		})
+
+		Convey("use the last answer from a backend that times out", func() {
+			close(stuck.release)
+			So(len(disco.Services()), ShouldEqual, 2)
+
+			stuck.release = make(chan struct{})
+			services := disco.Services()
+			So(len(services), ShouldEqual, 2)
+
+			status := disco.BackendStatuses()[1]
+			So(status.ConsecutiveTimeouts, ShouldEqual, 1)
+			So(status.Services, ShouldEqual, 1)
+
+			Convey("until it answers again", func() {
+				close(stuck.release)
+				So(func() bool {
+					for i := 0; i < 100; i++ {
+						if disco.BackendStatuses()[1].ConsecutiveTimeouts == 0 {
+							return true
+						}
+						time.Sleep(5 * time.Millisecond)
+					}
+					return false
+				}(), ShouldBeTrue)
+			})
+		})
+
+		Convey("are named by type when they weren't added with a name", func() {
+			disco := &MultiDiscovery{Discoverers: []Discoverer{quick}}
+			So(disco.BackendStatuses()[0].Name, ShouldEqual, "*discovery.mockDiscoverer")
+		})
+	})
+}
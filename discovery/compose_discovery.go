@@ -0,0 +1,428 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Compose drops these from project names
+var invalidProjectChars = regexp.MustCompile("[^a-z0-9_-]")
+
+// A ComposeDiscovery announces the services defined in a Docker Compose file
+// without looking at what is actually running. The services carry the same
+// labels they would as containers, so they get the same names, ports, health
+// checks, and listeners. The file is read on startup and never again.
+type ComposeDiscovery struct {
+	ComposeFile  string
+	ProjectName  string // Defaults to the name in the file, then its directory
+	Hostname     string
+	DefaultIP    string
+	IDStrategy   IDStrategy // When nil, IDs are derived from the project and service names
+	serviceNamer ServiceNamer
+	targets      []*composeTarget
+}
+
+type composeTarget struct {
+	Service service.Service
+	Labels  map[string]string
+}
+
+// The parts of a Compose file that we care about
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image         string        `yaml:"image"`
+	ContainerName string        `yaml:"container_name"`
+	Ports         []composePort `yaml:"ports"`
+	Labels        composeLabels `yaml:"labels"`
+}
+
+// Labels may be written either as a map or as a list of "key=value" strings
+type composeLabels map[string]string
+
+func (l *composeLabels) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	labels := make(map[string]string)
+
+	var asMap map[string]string
+	if err := unmarshal(&asMap); err == nil {
+		for key, value := range asMap {
+			labels[key] = value
+		}
+		*l = labels
+		return nil
+	}
+
+	var asList []string
+	if err := unmarshal(&asList); err != nil {
+		return fmt.Errorf("labels must be a map or a list: %w", err)
+	}
+
+	for _, entry := range asList {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		} else {
+			labels[parts[0]] = ""
+		}
+	}
+	*l = labels
+
+	return nil
+}
+
+// A composePort holds either the short ("8080:80/tcp") or the long syntax
+// of a port mapping
+type composePort struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published"`
+	Protocol  string `yaml:"protocol"`
+	HostIP    string `yaml:"host_ip"`
+}
+
+func (p *composePort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err == nil {
+		return p.parseShort(short)
+	}
+
+	type long composePort // Avoid recursing back into this method
+	return unmarshal((*long)(p))
+}
+
+// parseShort splits "[host_ip:][published:]target[/protocol]"
+func (p *composePort) parseShort(mapping string) error {
+	if mapping == "" {
+		return fmt.Errorf("empty port mapping")
+	}
+
+	if idx := strings.LastIndex(mapping, "/"); idx != -1 {
+		p.Protocol = mapping[idx+1:]
+		mapping = mapping[:idx]
+	}
+
+	idx := strings.LastIndex(mapping, ":")
+	if idx == -1 {
+		p.Target = mapping
+		return nil
+	}
+	p.Target = mapping[idx+1:]
+	mapping = mapping[:idx]
+
+	idx = strings.LastIndex(mapping, ":")
+	if idx == -1 {
+		p.Published = mapping
+		return nil
+	}
+	p.Published = mapping[idx+1:]
+	p.HostIP = strings.Trim(mapping[:idx], "[]")
+
+	return nil
+}
+
+// apiPorts expands the mapping into Docker ports. Ports that aren't published
+// on the host, or are published on a random port, can't be announced and are
+// left out.
+func (p *composePort) apiPorts() ([]docker.APIPort, error) {
+	if p.Published == "" {
+		return nil, nil
+	}
+
+	targetFirst, targetLast, err := parsePortRange(p.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target port '%s': %w", p.Target, err)
+	}
+
+	publishedFirst, publishedLast, err := parsePortRange(p.Published)
+	if err != nil {
+		return nil, fmt.Errorf("invalid published port '%s': %w", p.Published, err)
+	}
+
+	if publishedLast-publishedFirst != targetLast-targetFirst {
+		return nil, fmt.Errorf("port ranges '%s' and '%s' differ in size", p.Published, p.Target)
+	}
+
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	var ports []docker.APIPort
+	for offset := int64(0); offset <= targetLast-targetFirst; offset++ {
+		ports = append(ports, docker.APIPort{
+			PrivatePort: targetFirst + offset,
+			PublicPort:  publishedFirst + offset,
+			Type:        protocol,
+			IP:          p.HostIP,
+		})
+	}
+
+	return ports, nil
+}
+
+// parsePortRange parses "8080" or "8080-8081"
+func parsePortRange(portStr string) (int64, int64, error) {
+	parts := strings.SplitN(portStr, "-", 2)
+
+	first, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if last < first {
+		return 0, 0, fmt.Errorf("range ends before it starts")
+	}
+
+	return first, last, nil
+}
+
+func NewComposeDiscovery(filename string, svcNamer ServiceNamer, defaultIP string) *ComposeDiscovery {
+	return &ComposeDiscovery{
+		ComposeFile:  filename,
+		DefaultIP:    defaultIP,
+		serviceNamer: svcNamer,
+	}
+}
+
+// HealthCheck looks up the health check in the service's labels, the same
+// way DockerDiscovery does
+func (d *ComposeDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	target := d.findTarget(svc.ID)
+	if target == nil {
+		return "", ""
+	}
+
+	return target.Labels["HealthCheck"], target.Labels["HealthCheckArgs"]
+}
+
+// HealthCheckOptions looks up extra settings for health checks in the
+// service's labels
+func (d *ComposeDiscovery) HealthCheckOptions(svc *service.Service) CheckOptions {
+	target := d.findTarget(svc.ID)
+	if target == nil {
+		return CheckOptions{}
+	}
+
+	return CheckOptions{
+		StatusCodes: target.Labels["HealthCheckStatusCodes"],
+		BodyMatch:   target.Labels["HealthCheckBodyMatch"],
+		MaxLatency:  target.Labels["HealthCheckMaxLatency"],
+		DependsOn:   target.Labels["HealthCheckDependsOn"],
+		Aggregation: target.Labels["HealthCheckAggregation"],
+		Policy:      target.Labels["HealthCheckPolicy"],
+	}
+}
+
+// PortHealthChecks looks up the per-port health checks in the service's
+// labels
+func (d *ComposeDiscovery) PortHealthChecks(svc *service.Service) []PortCheck {
+	target := d.findTarget(svc.ID)
+	if target == nil {
+		return nil
+	}
+
+	return portChecksFromLabels(target.Labels)
+}
+
+// Services returns the services defined in the Compose file
+func (d *ComposeDiscovery) Services() []service.Service {
+	var services []service.Service
+	for _, target := range d.targets {
+		target.Service.Updated = time.Now().UTC()
+		services = append(services, target.Service)
+	}
+	return services
+}
+
+// Listeners returns the services with the SidecarListener label set to a
+// valid ServicePort
+func (d *ComposeDiscovery) Listeners() []ChangeListener {
+	var listeners []ChangeListener
+	for _, target := range d.targets {
+		svcPortStr, ok := target.Labels["SidecarListener"]
+		if !ok {
+			continue
+		}
+
+		listenPort := portForServicePort(&target.Service, svcPortStr, "tcp")
+		if listenPort == nil {
+			log.Warnf(
+				"SidecarListener label found on %s, but no matching ServicePort! '%s'",
+				target.Service.ID, svcPortStr,
+			)
+			continue
+		}
+
+		listeners = append(listeners, ChangeListener{
+			Name: target.Service.ListenerName(),
+			Url:  fmt.Sprintf("http://%s:%d/sidecar/update", listenPort.IP, listenPort.Port),
+		})
+	}
+	return listeners
+}
+
+// Causes the Compose file to be parsed and loaded. There is no background
+// processing needed on an ongoing basis, so the context is not used.
+func (d *ComposeDiscovery) Run(_ context.Context, looper director.Looper) {
+	var err error
+
+	d.targets, err = d.ParseComposeFile(d.ComposeFile)
+	if err != nil {
+		log.Errorf("ComposeDiscovery cannot parse: %s", err.Error())
+		looper.Done(nil)
+	}
+}
+
+// ParseComposeFile turns each service in the Compose file into a placeholder
+// container and then into a service, just as DockerDiscovery would if it
+// were running.
+func (d *ComposeDiscovery) ParseComposeFile(filename string) ([]*composeTarget, error) {
+	file, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read Compose file: %w", err)
+	}
+
+	var compose composeFile
+	err = yaml.Unmarshal(file, &compose)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal Compose file: %w", err)
+	}
+
+	project := d.projectName(filename, &compose)
+
+	// Go randomizes map order, keep the services in a predictable one
+	var names []string
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var targets []*composeTarget
+	for _, name := range names {
+		target, err := d.targetFor(project, name, compose.Services[name])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to configure service '%s': %w", name, err)
+		}
+
+		log.Printf("Discovered service: %s, ID: %s",
+			target.Service.Name,
+			target.Service.ID,
+		)
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+func (d *ComposeDiscovery) targetFor(project string, name string, composeSvc composeService) (*composeTarget, error) {
+	containerName := composeSvc.ContainerName
+	if containerName == "" {
+		containerName = fmt.Sprintf("%s_%s_1", project, name)
+	}
+
+	// Services that are only built locally get an image named by Compose
+	image := composeSvc.Image
+	if image == "" {
+		image = fmt.Sprintf("%s_%s", project, name)
+	}
+
+	labels := map[string]string(composeSvc.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	// A stand-in for a real container ID, stable for this project and service
+	hash := sha1.Sum([]byte(project + "\x00" + name))
+
+	container := docker.APIContainers{
+		ID:      hex.EncodeToString(hash[:]),
+		Names:   []string{"/" + containerName},
+		Image:   image,
+		Created: time.Now().UTC().Unix(),
+		Labels:  labels,
+	}
+
+	for _, port := range composeSvc.Ports {
+		apiPorts, err := port.apiPorts()
+		if err != nil {
+			return nil, err
+		}
+		container.Ports = append(container.Ports, apiPorts...)
+	}
+
+	svc := service.ToService(&container, d.DefaultIP)
+	svc.Hostname = d.Hostname
+	svc.Source = SourceCompose
+
+	if d.serviceNamer != nil {
+		svc.Name = d.serviceNamer.ServiceName(&container)
+	} else {
+		svc.Name = name
+	}
+
+	if d.IDStrategy != nil {
+		var err error
+		svc.ID, err = d.IDStrategy.ServiceID(&svc)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to generate a service ID: %w", err)
+		}
+	}
+
+	return &composeTarget{Service: svc, Labels: labels}, nil
+}
+
+// projectName works out the project name the way Compose does, so containers
+// are named the same
+func (d *ComposeDiscovery) projectName(filename string, compose *composeFile) string {
+	name := d.ProjectName
+	if name == "" {
+		name = compose.Name
+	}
+	if name == "" {
+		absPath, err := filepath.Abs(filename)
+		if err == nil {
+			name = filepath.Base(filepath.Dir(absPath))
+		}
+	}
+
+	return invalidProjectChars.ReplaceAllString(strings.ToLower(name), "")
+}
+
+func (d *ComposeDiscovery) findTarget(id string) *composeTarget {
+	for _, target := range d.targets {
+		if target.Service.ID == id {
+			return target
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	COMPOSE_YML = "../fixtures/docker-compose.yml"
+)
+
+func Test_ComposeDiscovery(t *testing.T) {
+	Convey("ComposeDiscovery", t, func() {
+		ip := "127.0.0.1"
+		disco := NewComposeDiscovery(COMPOSE_YML, &DockerLabelNamer{Label: "ServiceName"}, ip)
+		disco.Hostname = hostname
+
+		Convey("Errors when there is a problem with the file", func() {
+			_, err := disco.ParseComposeFile("!!!!")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Returns a target for each service, in order", func() {
+			parsed, err := disco.ParseComposeFile(COMPOSE_YML)
+			So(err, ShouldBeNil)
+			So(len(parsed), ShouldEqual, 2)
+			So(parsed[0].Service.Name, ShouldEqual, "web")
+			So(parsed[0].Service.Hostname, ShouldEqual, hostname)
+			So(parsed[0].Service.Image, ShouldEqual, "bookshop/web:1.2.3")
+		})
+
+		Convey("Maps ports and service ports from the labels", func() {
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			web := parsed[0].Service
+
+			So(len(web.Ports), ShouldEqual, 2)
+			So(web.Ports[0].Port, ShouldEqual, 10100)
+			So(web.Ports[0].ServicePort, ShouldEqual, 8080)
+			So(web.Ports[0].IP, ShouldEqual, ip)
+			So(web.Ports[1].Port, ShouldEqual, 10101)
+			So(web.Ports[1].ServicePort, ShouldEqual, 9090)
+		})
+
+		Convey("Expands port ranges and skips unpublished ports", func() {
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			worker := parsed[1].Service
+
+			So(len(worker.Ports), ShouldEqual, 2)
+			So(worker.Ports[0].Port, ShouldEqual, 10200)
+			So(worker.Ports[0].Type, ShouldEqual, "udp")
+			So(worker.Ports[0].IP, ShouldEqual, "127.0.0.1")
+			So(worker.Ports[0].ServicePort, ShouldEqual, 7000)
+			So(worker.Ports[1].Port, ShouldEqual, 10201)
+		})
+
+		Convey("Handles labels in list form", func() {
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			So(parsed[1].Service.ProxyMode, ShouldEqual, "tcp")
+			So(parsed[0].Service.ProxyMode, ShouldEqual, "http")
+			So(parsed[0].Service.Tags, ShouldResemble, map[string]string{"env": "dev"})
+		})
+
+		Convey("Names images for services that are only built", func() {
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			So(parsed[1].Service.Image, ShouldEqual, "bookshop_worker")
+		})
+
+		Convey("Derives stable IDs from the project and service", func() {
+			first, _ := disco.ParseComposeFile(COMPOSE_YML)
+			second, _ := disco.ParseComposeFile(COMPOSE_YML)
+			So(len(first[0].Service.ID), ShouldEqual, 12)
+			So(first[0].Service.ID, ShouldEqual, second[0].Service.ID)
+			So(first[0].Service.ID, ShouldNotEqual, first[1].Service.ID)
+
+			disco.ProjectName = "other"
+			renamed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			So(renamed[0].Service.ID, ShouldNotEqual, first[0].Service.ID)
+		})
+
+		Convey("Uses the IDStrategy when there is one", func() {
+			disco.IDStrategy = &HashIDStrategy{}
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+
+			expected, _ := (&HashIDStrategy{}).ServiceID(&parsed[0].Service)
+			So(parsed[0].Service.ID, ShouldEqual, expected)
+		})
+
+		Convey("Falls back to the Compose service name without a namer", func() {
+			disco.serviceNamer = nil
+			parsed, _ := disco.ParseComposeFile(COMPOSE_YML)
+			So(parsed[1].Service.Name, ShouldEqual, "worker")
+		})
+
+		Convey("After running", func() {
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+			services := disco.Services()
+
+			Convey("Returns the services", func() {
+				So(len(services), ShouldEqual, 2)
+				So(services[0].Updated.IsZero(), ShouldBeFalse)
+				So(services[0].Source, ShouldEqual, SourceCompose)
+			})
+
+			Convey("Looks up health checks in the labels", func() {
+				check, args := disco.HealthCheck(&services[0])
+				So(check, ShouldEqual, "HttpGet")
+				So(args, ShouldEqual, "http://{{ host }}:{{ tcp 8080 }}/health")
+
+				check, _ = disco.HealthCheck(&services[1])
+				So(check, ShouldBeEmpty)
+			})
+
+			Convey("Returns listeners for the SidecarListener label", func() {
+				listeners := disco.Listeners()
+				So(len(listeners), ShouldEqual, 1)
+				So(listeners[0].Url, ShouldEqual, "http://127.0.0.1:10101/sidecar/update")
+			})
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NinesStack/sidecar/logging"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+var conflictLogs = logging.NewLimiter(logging.DEFAULT_LIMIT_INTERVAL)
+
+// A discovered service, with the discoverer that found it and that
+// discoverer's rank. Lower ranks win.
+type candidate struct {
+	svc   *service.Service
+	index int
+	rank  int
+}
+
+// rank works out the precedence of a service from the discoverer at index.
+// Sources named in Precedence come first, in that order, and then the rest
+// in the order of the Discoverers.
+func (d *MultiDiscovery) rank(index int, svc *service.Service) int {
+	for i, source := range d.Precedence {
+		if svc.Source == source {
+			return i
+		}
+	}
+	return len(d.Precedence) + index
+}
+
+// resolveConflicts drops services that clash with one from a discoverer
+// with higher precedence: those with the same ID, or the same name and port,
+// on the same host. Services from the same discoverer never clash. The winner
+// doesn't depend on the order the services were discovered in. The rest are
+// returned in their original order.
+func (d *MultiDiscovery) resolveConflicts(candidates []candidate) []service.Service {
+	byRank := make([]int, len(candidates))
+	for i := range byRank {
+		byRank[i] = i
+	}
+	sort.SliceStable(byRank, func(i, j int) bool {
+		return candidates[byRank[i]].rank < candidates[byRank[j]].rank
+	})
+
+	winners := make(map[string]*candidate, len(candidates))
+	keep := make([]bool, len(candidates))
+	var conflicts int
+
+	for _, i := range byRank {
+		svc := candidates[i].svc
+		keys := conflictKeys(svc)
+
+		var winner *service.Service
+		for _, key := range keys {
+			if found, ok := winners[key]; ok && found.index != candidates[i].index {
+				winner = found.svc
+				break
+			}
+		}
+
+		if winner != nil {
+			conflicts++
+			conflictLogs.Warnf("conflict:"+svc.Hostname+":"+svc.ID,
+				"Discovery conflict: dropping %s (%s) from %s, which clashes with %s (%s) from %s",
+				svc.Name, svc.ID, sourceName(svc), winner.Name, winner.ID, sourceName(winner),
+			)
+			continue
+		}
+
+		keep[i] = true
+		for _, key := range keys {
+			if _, ok := winners[key]; !ok {
+				winners[key] = &candidates[i]
+			}
+		}
+	}
+
+	metrics.SetGauge([]string{"discovery", "conflicts"}, float32(conflicts))
+
+	services := make([]service.Service, 0, len(candidates))
+	for i, candidate := range candidates {
+		if keep[i] {
+			services = append(services, *candidate.svc)
+		}
+	}
+	return services
+}
+
+// conflictKeys returns the keys that no two services may share
+func conflictKeys(svc *service.Service) []string {
+	keys := []string{"id:" + svc.Hostname + ":" + svc.ID}
+	for _, port := range svc.Ports {
+		keys = append(keys,
+			fmt.Sprintf("port:%s:%s:%s:%d", svc.Hostname, svc.Name, port.Type, port.Port),
+		)
+	}
+	return keys
+}
+
+func sourceName(svc *service.Service) string {
+	if svc.Source == "" {
+		return "unknown discoverer"
+	}
+	return svc.Source
+}
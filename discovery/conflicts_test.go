@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_MultiDiscoveryConflicts(t *testing.T) {
+	Convey("Resolving conflicts between discoverers", t, func() {
+		port := service.Port{Type: "tcp", Port: 10234, ServicePort: 9999}
+
+		fromDocker := service.Service{
+			ID: "deadbeef0001", Name: "beowulf", Hostname: "heorot",
+			Ports: []service.Port{port}, Source: SourceDocker,
+		}
+		fromStatic := service.Service{
+			ID: "deadbeef0001", Name: "beowulf", Hostname: "heorot",
+			Source: SourceStatic,
+		}
+		other := service.Service{
+			ID: "cafebabe0001", Name: "grendel", Hostname: "heorot",
+			Source: SourceStatic,
+		}
+
+		docker := &mockDiscoverer{ServicesList: []service.Service{fromDocker}}
+		static := &mockDiscoverer{ServicesList: []service.Service{fromStatic, other}}
+		multi := &MultiDiscovery{Discoverers: []Discoverer{docker, static}}
+
+		Convey("keeps the service from the first discoverer by default", func() {
+			services := multi.Services()
+
+			So(len(services), ShouldEqual, 2)
+			So(services[0].Source, ShouldEqual, SourceDocker)
+			So(services[1].ID, ShouldEqual, "cafebabe0001")
+		})
+
+		Convey("keeps the service from the source with precedence", func() {
+			multi.Precedence = []string{SourceStatic}
+			services := multi.Services()
+
+			So(len(services), ShouldEqual, 2)
+			So(services[0].ID, ShouldEqual, "deadbeef0001")
+			So(services[0].Source, ShouldEqual, SourceStatic)
+			So(services[1].ID, ShouldEqual, "cafebabe0001")
+		})
+
+		Convey("picks the same winner whatever order they're discovered in", func() {
+			multi.Precedence = []string{SourceStatic}
+			multi.Discoverers = []Discoverer{static, docker}
+			first := multi.Services()
+
+			multi.Discoverers = []Discoverer{docker, static}
+			second := multi.Services()
+
+			So(len(first), ShouldEqual, 2)
+			So(len(second), ShouldEqual, 2)
+			So(first[0].Source, ShouldEqual, SourceStatic)
+			So(second[0].Source, ShouldEqual, SourceStatic)
+		})
+
+		Convey("finds conflicts on the same name and port on one host", func() {
+			fromStatic.ID = "deadbeef0002"
+			fromStatic.Ports = []service.Port{port}
+			static.ServicesList = []service.Service{fromStatic}
+
+			services := multi.Services()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].Source, ShouldEqual, SourceDocker)
+
+			fromStatic.Hostname = "hrothgar"
+			static.ServicesList = []service.Service{fromStatic}
+			So(len(multi.Services()), ShouldEqual, 2)
+		})
+
+		Convey("doesn't drop duplicates from the same discoverer", func() {
+			onOtherHost := fromDocker
+			onOtherHost.Hostname = "hrothgar"
+			docker.ServicesList = []service.Service{fromDocker, fromDocker, onOtherHost}
+			static.ServicesList = nil
+
+			So(len(multi.Services()), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	DefaultContainerCacheSize = 512              // The most containers we keep inspected
+	DefaultContainerCacheTTL  = 10 * time.Minute // How long before we inspect a container again
+)
+
+// A ContainerCache keeps a history of the containers we've inspected
+// in order to do fast lookups of container info when needed. It holds at
+// most MaxSize containers, dropping the least recently used, and forgets
+// them after TTL so that we don't hold on to stale labels forever. Inspects
+// for the same container that happen at the same time are only sent to
+// Docker once.
+type ContainerCache struct {
+	MaxSize int           // Zero for no limit
+	TTL     time.Duration // Zero to keep containers until they're pruned or evicted
+
+	entries  map[string]*list.Element // Of *cacheEntry, by service ID
+	order    *list.List               // Most recently used at the front
+	inflight map[string]*inspectCall  // Inspects under way, by service ID
+	now      func() time.Time
+	sync.Mutex
+}
+
+type cacheEntry struct {
+	id        string
+	container *docker.Container
+	added     time.Time
+}
+
+// An inspectCall is an inspect that others can wait on
+type inspectCall struct {
+	done      chan struct{}
+	container *docker.Container
+	err       error
+}
+
+func NewContainerCache() *ContainerCache {
+	return &ContainerCache{
+		MaxSize:  DefaultContainerCacheSize,
+		TTL:      DefaultContainerCacheTTL,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*inspectCall),
+		now:      time.Now,
+	}
+}
+
+// Loop through the current cache and remove anything that has disappeared
+func (c *ContainerCache) Prune(liveContainers map[string]interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	for id, elem := range c.entries {
+		if _, ok := liveContainers[id]; !ok {
+			c.remove(elem)
+		}
+	}
+
+	c.reportSize()
+}
+
+// Get locks the cache, try to get a service if we have it
+func (c *ContainerCache) Get(svcID string) *docker.Container {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.get(svcID)
+}
+
+func (c *ContainerCache) Set(svc *service.Service, container *docker.Container) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.set(svc.ID, container)
+}
+
+func (c *ContainerCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.order.Len()
+}
+
+// GetOrInspect returns the cached container, or calls inspect to fetch it.
+// When an inspect for the container is already under way, we wait for that
+// one rather than asking Docker again. Errors aren't cached.
+func (c *ContainerCache) GetOrInspect(svcID string, inspect func() (*docker.Container, error)) (*docker.Container, error) {
+	c.Lock()
+	if container := c.get(svcID); container != nil {
+		metrics.IncrCounter([]string{"discovery", "docker", "cache", "hits"}, 1)
+		c.Unlock()
+		return container, nil
+	}
+
+	if call, ok := c.inflight[svcID]; ok {
+		metrics.IncrCounter([]string{"discovery", "docker", "cache", "deduplicated"}, 1)
+		c.Unlock()
+		<-call.done
+		return call.container, call.err
+	}
+
+	metrics.IncrCounter([]string{"discovery", "docker", "cache", "misses"}, 1)
+	call := &inspectCall{done: make(chan struct{})}
+	c.inflight[svcID] = call
+	c.Unlock()
+
+	call.container, call.err = inspect()
+
+	c.Lock()
+	delete(c.inflight, svcID)
+	if call.err == nil {
+		c.set(svcID, call.container)
+	}
+	c.Unlock()
+
+	close(call.done)
+
+	return call.container, call.err
+}
+
+// get returns the container if it's cached and fresh. Not synchronized!
+func (c *ContainerCache) get(svcID string) *docker.Container {
+	elem, ok := c.entries[svcID]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if c.TTL > 0 && c.now().Sub(entry.added) > c.TTL {
+		c.remove(elem)
+		c.evicted("expired")
+		return nil
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.container
+}
+
+// set caches the container, evicting the least recently used ones to make
+// room. Not synchronized!
+func (c *ContainerCache) set(svcID string, container *docker.Container) {
+	if elem, ok := c.entries[svcID]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[svcID] = c.order.PushFront(&cacheEntry{id: svcID, container: container, added: c.now()})
+
+	for c.MaxSize > 0 && c.order.Len() > c.MaxSize {
+		c.remove(c.order.Back())
+		c.evicted("full")
+	}
+
+	c.reportSize()
+}
+
+// remove drops an entry. Not synchronized!
+func (c *ContainerCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).id)
+}
+
+func (c *ContainerCache) evicted(reason string) {
+	metrics.IncrCounterWithLabels([]string{"discovery", "docker", "cache", "evictions"}, 1,
+		[]metrics.Label{{Name: "reason", Value: reason}},
+	)
+}
+
+func (c *ContainerCache) reportSize() {
+	metrics.SetGauge([]string{"discovery", "docker", "cache", "size"}, float32(c.order.Len()))
+}
@@ -0,0 +1,115 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ContainerCache(t *testing.T) {
+	Convey("ContainerCache", t, func() {
+		cache := NewContainerCache()
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		svc := func(id string) *service.Service { return &service.Service{ID: id} }
+
+		Convey("evicts the least recently used containers when it's full", func() {
+			cache.MaxSize = 2
+			cache.Set(svc("deadbeef0001"), &docker.Container{Path: "one"})
+			cache.Set(svc("deadbeef0002"), &docker.Container{Path: "two"})
+
+			So(cache.Get("deadbeef0001"), ShouldNotBeNil) // Now the most recent
+			cache.Set(svc("deadbeef0003"), &docker.Container{Path: "three"})
+
+			So(cache.Len(), ShouldEqual, 2)
+			So(cache.Get("deadbeef0002"), ShouldBeNil)
+			So(cache.Get("deadbeef0001").Path, ShouldEqual, "one")
+			So(cache.Get("deadbeef0003").Path, ShouldEqual, "three")
+		})
+
+		Convey("forgets containers after the TTL", func() {
+			cache.TTL = time.Minute
+			cache.Set(svc("deadbeef0001"), &docker.Container{Path: "one"})
+
+			now = now.Add(30 * time.Second)
+			So(cache.Get("deadbeef0001"), ShouldNotBeNil)
+
+			now = now.Add(time.Minute)
+			So(cache.Get("deadbeef0001"), ShouldBeNil)
+			So(cache.Len(), ShouldEqual, 0)
+		})
+
+		Convey("GetOrInspect()", func() {
+			var inspects int32
+			inspect := func() (*docker.Container, error) {
+				atomic.AddInt32(&inspects, 1)
+				return &docker.Container{Path: "inspected"}, nil
+			}
+
+			Convey("only inspects on a miss", func() {
+				container, err := cache.GetOrInspect("deadbeef0001", inspect)
+				So(err, ShouldBeNil)
+				So(container.Path, ShouldEqual, "inspected")
+
+				_, err = cache.GetOrInspect("deadbeef0001", inspect)
+				So(err, ShouldBeNil)
+				So(atomic.LoadInt32(&inspects), ShouldEqual, 1)
+			})
+
+			Convey("doesn't cache errors", func() {
+				_, err := cache.GetOrInspect("deadbeef0001", func() (*docker.Container, error) {
+					return nil, errors.New("intentional")
+				})
+				So(err, ShouldNotBeNil)
+				So(cache.Len(), ShouldEqual, 0)
+			})
+
+			Convey("sends concurrent inspects for a container to Docker once", func() {
+				release := make(chan struct{})
+				slowInspect := func() (*docker.Container, error) {
+					<-release
+					return inspect()
+				}
+
+				var wg sync.WaitGroup
+				results := make([]*docker.Container, 5)
+				for i := range results {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						results[i], _ = cache.GetOrInspect("deadbeef0001", slowInspect)
+					}(i)
+				}
+
+				// Let them all queue up behind the first
+				for {
+					cache.Lock()
+					_, waiting := cache.inflight["deadbeef0001"]
+					cache.Unlock()
+					if waiting {
+						break
+					}
+					time.Sleep(time.Millisecond)
+				}
+				time.Sleep(10 * time.Millisecond)
+				close(release)
+				wg.Wait()
+
+				So(atomic.LoadInt32(&inspects), ShouldEqual, 1)
+				for _, container := range results {
+					So(container.Path, ShouldEqual, "inspected")
+				}
+			})
+		})
+	})
+}
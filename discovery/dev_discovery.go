@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultChurnInterval = 30 * time.Second
+)
+
+// The example services announced in dev mode
+var devServices = []struct {
+	Name        string
+	ProxyMode   string
+	Port        int64
+	ServicePort int64
+}{
+	{"web", "http", 32100, 10100},
+	{"api", "http", 32101, 10101},
+	{"users", "http", 32102, 10102},
+	{"cache", "tcp", 32103, 10103},
+}
+
+// A DevDiscovery makes up a handful of example services so that Sidecar can
+// be tried out without Docker. Every ChurnInterval, one of them is redeployed
+// with a new ID and version, which gives listeners and the UI some changes to
+// look at.
+type DevDiscovery struct {
+	Hostname      string
+	DefaultIP     string
+	ChurnInterval time.Duration // How often to redeploy a service, zero disables
+	services      []*service.Service
+	versions      []int
+	next          int // The next service to redeploy
+	sync.RWMutex
+}
+
+func NewDevDiscovery(defaultIP string) *DevDiscovery {
+	return &DevDiscovery{
+		DefaultIP:     defaultIP,
+		ChurnInterval: DefaultChurnInterval,
+	}
+}
+
+// HealthCheck returns a check that always passes, there is nothing to check
+func (d *DevDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, devSvc := range d.services {
+		if devSvc != nil && devSvc.ID == svc.ID {
+			return "AlwaysSuccessful", ""
+		}
+	}
+	return "", ""
+}
+
+// Services returns the example services
+func (d *DevDiscovery) Services() []service.Service {
+	d.RLock()
+	defer d.RUnlock()
+
+	var services []service.Service
+	for _, svc := range d.services {
+		if svc == nil {
+			continue // Failed to deploy
+		}
+		announced := *svc
+		announced.Updated = time.Now().UTC()
+		services = append(services, announced)
+	}
+	return services
+}
+
+// Listeners returns nothing, the example services don't listen
+func (d *DevDiscovery) Listeners() []ChangeListener {
+	return nil
+}
+
+// Run creates the example services, then redeploys them in turn until the
+// looper quits or the context is cancelled
+func (d *DevDiscovery) Run(ctx context.Context, looper director.Looper) {
+	d.Lock()
+	d.services = make([]*service.Service, len(devServices))
+	d.versions = make([]int, len(devServices))
+	for i := range devServices {
+		d.deploy(i)
+	}
+	d.Unlock()
+
+	if d.ChurnInterval <= 0 {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
+	go looper.Loop(func() error {
+		select {
+		case <-ctx.Done():
+			// Shutting down, the looper will pick up the Quit()
+		case <-time.After(d.ChurnInterval):
+			d.Lock()
+			d.deploy(d.next)
+			d.next = (d.next + 1) % len(d.services)
+			d.Unlock()
+		}
+
+		return nil
+	})
+}
+
+// deploy replaces the example service at this index with a new version.
+// Note: Not synchronized!
+func (d *DevDiscovery) deploy(index int) {
+	id, err := RandomHex(HashIDLength / 2)
+	if err != nil {
+		log.Errorf("DevDiscovery can't generate a service ID: %s", err)
+		return
+	}
+
+	example := devServices[index]
+	d.versions[index]++
+
+	now := time.Now().UTC()
+	d.services[index] = &service.Service{
+		ID:        string(id),
+		Name:      example.Name,
+		Image:     fmt.Sprintf("example/%s:%d", example.Name, d.versions[index]),
+		Created:   now,
+		Updated:   now,
+		Hostname:  d.Hostname,
+		ProxyMode: example.ProxyMode,
+		Status:    service.ALIVE,
+		Ports: []service.Port{
+			{Type: "tcp", Port: example.Port, ServicePort: example.ServicePort, IP: d.DefaultIP},
+		},
+		Tags:   map[string]string{"env": "dev"},
+		Source: SourceDev,
+	}
+
+	log.Infof("Deployed example service: %s, ID: %s", example.Name, d.services[index].ID)
+}
@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_DevDiscovery(t *testing.T) {
+	Convey("DevDiscovery", t, func() {
+		disco := NewDevDiscovery("127.0.0.1")
+		disco.Hostname = hostname
+		disco.ChurnInterval = 0
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		disco.Run(ctx, director.NewFreeLooper(director.FOREVER, nil))
+
+		Convey("Makes up the example services", func() {
+			services := disco.Services()
+
+			So(len(services), ShouldEqual, len(devServices))
+			So(services[0].Name, ShouldEqual, "web")
+			So(services[0].Hostname, ShouldEqual, hostname)
+			So(services[0].Ports[0].IP, ShouldEqual, "127.0.0.1")
+			So(services[0].Version(), ShouldEqual, "1")
+		})
+
+		Convey("Gives them a check that always passes", func() {
+			services := disco.Services()
+
+			check, _ := disco.HealthCheck(&services[0])
+			So(check, ShouldEqual, "AlwaysSuccessful")
+		})
+
+		Convey("Redeploys them in turn", func() {
+			churning := NewDevDiscovery("127.0.0.1")
+			churning.ChurnInterval = time.Millisecond
+			churning.Run(ctx, director.NewFreeLooper(1, nil))
+			time.Sleep(50 * time.Millisecond)
+
+			services := churning.Services()
+			So(services[0].Version(), ShouldEqual, "2")
+			So(services[1].Version(), ShouldEqual, "1")
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package discovery
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/NinesStack/sidecar/service"
@@ -11,6 +13,26 @@ const (
 	DefaultSleepInterval = 1 * time.Second
 )
 
+// The Source each discoverer sets on its services. They match the names used
+// to configure them in SIDECAR_DISCOVERY.
+const (
+	SourceDocker  = "docker"
+	SourceStatic  = "static"
+	SourceCompose = "compose"
+	SourceK8sAPI  = "kubernetes_api"
+	SourceDev     = "dev"
+)
+
+// IsSupported returns true when the discovery method works on this platform
+func IsSupported(method string) bool {
+	for _, supported := range SupportedMethods {
+		if method == supported {
+			return true
+		}
+	}
+	return false
+}
+
 // A ChangeListener is a service that will receive service change events
 // over the HTTP interface.
 type ChangeListener struct {
@@ -32,14 +54,64 @@ type Discoverer interface {
 	// Sidecar service change events
 	Listeners() []ChangeListener
 	// A non-blocking method that runs a discovery loop.
-	// The controlling process kicks it off to start discovery.
-	Run(director.Looper)
+	// The controlling process kicks it off to start discovery
+	// and cancels the context to stop it.
+	Run(context.Context, director.Looper)
+}
+
+// CheckOptions are extra settings for health checks. Empty values get the
+// defaults. All but DependsOn only apply to HTTP checks.
+type CheckOptions struct {
+	StatusCodes string // Healthy status codes, e.g. "200,204" or "200-299"
+	BodyMatch   string // A regexp that the response body must match
+	MaxLatency  string // A duration the response must arrive within, e.g. "500ms"
+	DependsOn   string // Only check when this local service is healthy
+	Aggregation string // How to combine port checks: "all" or "any"
+	Policy      string // How check results become a status, e.g. "quorum"
+}
+
+// A PortCheck is one of several health checks for a service, each labeled
+// with the ServicePort it checks
+type PortCheck struct {
+	Port int64
+	Type string
+	Args string // May be empty for HTTP checks
+}
+
+// A PortCheckProvider is a Discoverer that can supply a health check for
+// each of a service's ports, in addition to the one from HealthCheck()
+type PortCheckProvider interface {
+	PortHealthChecks(svc *service.Service) []PortCheck
+}
+
+// A CheckOptionsProvider is a Discoverer that can also supply settings for
+// the health checks of the services it finds
+type CheckOptionsProvider interface {
+	HealthCheckOptions(svc *service.Service) CheckOptions
 }
 
 // A MultiDiscovery is a wrapper around zero or more Discoverers.
 // It allows the use of potentially multiple Discoverers in place of one.
 type MultiDiscovery struct {
 	Discoverers []Discoverer
+	// Rewrites the hostnames of discovered services. May be nil.
+	NormalizeHostname HostnameNormalizer
+	// Which Source wins when discoverers find the same service. Those not
+	// listed rank below, in the order of the Discoverers.
+	Precedence []string
+	// The ServicePorts services may announce. Empty allows any.
+	AllowedServicePorts PortRanges
+	// Hands out ServicePorts to services that ask for one. nil leaves them
+	// without.
+	PortAllocator *PortAllocator
+	// How long we wait on each of the Discoverers for its services or
+	// listeners before using the last ones it returned. 0 waits forever.
+	Timeout time.Duration
+
+	names         []string        // The name of each of the Discoverers, see Add()
+	backends      []*backend      // The last answers from each of the Discoverers
+	rejectedNames map[string]bool // The services we last reported rejected ServicePorts for
+	lock          sync.Mutex
 }
 
 // Get the health check and health check args for a service
@@ -53,50 +125,133 @@ func (d *MultiDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
-// Aggregates all the service slices from the discoverers
+// HealthCheckOptions returns the check options from the same discoverer that
+// supplied the health checks
+func (d *MultiDiscovery) HealthCheckOptions(svc *service.Service) CheckOptions {
+	for _, disco := range d.Discoverers {
+		if healthCheck, _ := disco.HealthCheck(svc); healthCheck != "" || len(portChecksFrom(disco, svc)) > 0 {
+			if provider, ok := disco.(CheckOptionsProvider); ok {
+				return provider.HealthCheckOptions(svc)
+			}
+			return CheckOptions{}
+		}
+	}
+	return CheckOptions{}
+}
+
+// PortHealthChecks returns the port checks from the first discoverer that
+// has any for this service
+func (d *MultiDiscovery) PortHealthChecks(svc *service.Service) []PortCheck {
+	for _, disco := range d.Discoverers {
+		if checks := portChecksFrom(disco, svc); len(checks) > 0 {
+			return checks
+		}
+	}
+	return nil
+}
+
+// portChecksFrom returns the port checks for a service when the discoverer
+// supports them
+func portChecksFrom(disco Discoverer, svc *service.Service) []PortCheck {
+	provider, ok := disco.(PortCheckProvider)
+	if !ok {
+		return nil
+	}
+	return provider.PortHealthChecks(svc)
+}
+
+// Aggregates all the service slices from the discoverers, which are asked
+// all at once. When more than one finds the same service, only the one with
+// the highest precedence is kept.
 func (d *MultiDiscovery) Services() []service.Service {
 	var aggregate []service.Service
+	var indexes []int
 
-	for _, disco := range d.Discoverers {
+	d.callAll("services", func(disco Discoverer, b *backend) {
 		services := disco.Services()
-		if len(services) > 0 {
-			aggregate = append(aggregate, services...)
+
+		d.lock.Lock()
+		b.services = services
+		b.status.Services = len(services)
+		d.lock.Unlock()
+	})
+
+	d.lock.Lock()
+	for i, b := range d.backends {
+		if len(b.services) > 0 {
+			aggregate = append(aggregate, b.services...)
+			for range b.services {
+				indexes = append(indexes, i)
+			}
 		}
 	}
+	d.lock.Unlock()
 
-	return aggregate
+	normalizeServices(aggregate, d.NormalizeHostname)
+	d.PortAllocator.Allocate(aggregate)
+	aggregate, rejected := rejectServicePorts(aggregate, d.AllowedServicePorts)
+
+	d.lock.Lock()
+	d.reportRejected(rejected)
+	d.lock.Unlock()
+
+	if len(d.Discoverers) < 2 {
+		return aggregate
+	}
+
+	candidates := make([]candidate, len(aggregate))
+	for i := range aggregate {
+		candidates[i] = candidate{
+			svc:   &aggregate[i],
+			index: indexes[i],
+			rank:  d.rank(indexes[i], &aggregate[i]),
+		}
+	}
+
+	return d.resolveConflicts(candidates)
 }
 
-// Aggreates all the Listeners() output from the discoverers
+// Aggreates all the Listeners() output from the discoverers, which are asked
+// all at once
 func (d *MultiDiscovery) Listeners() []ChangeListener {
 	var aggregate []ChangeListener
 
-	for _, disco := range d.Discoverers {
-		subscribers := disco.Listeners()
-		if len(subscribers) > 0 {
-			aggregate = append(aggregate, subscribers...)
+	d.callAll("listeners", func(disco Discoverer, b *backend) {
+		listeners := disco.Listeners()
+
+		d.lock.Lock()
+		b.listeners = listeners
+		d.lock.Unlock()
+	})
+
+	d.lock.Lock()
+	for _, b := range d.backends {
+		if len(b.listeners) > 0 {
+			aggregate = append(aggregate, b.listeners...)
 		}
 	}
+	d.lock.Unlock()
 
 	return aggregate
 }
 
-// Kicks off the Run() method for all the discoverers.
-func (d *MultiDiscovery) Run(looper director.Looper) {
-	var loopers []director.Looper
+// Kicks off the Run() method for all the discoverers. They are stopped when
+// the looper quits or the context is cancelled.
+func (d *MultiDiscovery) Run(ctx context.Context, looper director.Looper) {
+	discoCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	for _, disco := range d.Discoverers {
-		l := director.NewFreeLooper(director.FOREVER, make(chan error))
-		loopers = append(loopers, l)
-		disco.Run(l)
+		disco.Run(discoCtx, director.NewFreeLooper(director.FOREVER, nil))
 	}
 
+	go func() {
+		<-discoCtx.Done()
+		looper.Quit()
+	}()
+
 	// Waiting for a quit on the Looper's channel
 	looper.Loop(func() error {
 		return nil
 	})
-
-	for _, l := range loopers {
-		l.Quit()
-	}
 }
@@ -1,13 +1,17 @@
 package discovery
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+var hostname = "shakespeare"
+
 type mockDiscoverer struct {
 	ServicesList     []service.Service
 	RunInvoked       bool
@@ -16,6 +20,7 @@ type mockDiscoverer struct {
 	CheckName        string
 	ListenersInvoked bool
 	ListenersList    []ChangeListener
+	RunContext       context.Context
 }
 
 func (m *mockDiscoverer) Services() []service.Service {
@@ -28,8 +33,9 @@ func (m *mockDiscoverer) Listeners() []ChangeListener {
 	return m.ListenersList
 }
 
-func (m *mockDiscoverer) Run(looper director.Looper) {
+func (m *mockDiscoverer) Run(ctx context.Context, looper director.Looper) {
 	m.RunInvoked = true
+	m.RunContext = ctx
 }
 
 func (m *mockDiscoverer) HealthCheck(svc *service.Service) (string, string) {
@@ -54,22 +60,38 @@ func Test_MultiDiscovery(t *testing.T) {
 
 		disco1 := &mockDiscoverer{
 			[]service.Service{svc1}, false, false, done1, "one",
-			false, []ChangeListener{{Name: "svc1-1", Url: "http://localhost:10000"}},
+			false, []ChangeListener{{Name: "svc1-1", Url: "http://localhost:10000"}}, nil,
 		}
 		disco2 := &mockDiscoverer{
 			[]service.Service{svc2}, false, false, done2, "two",
-			false, []ChangeListener{{Name: "svc2-2", Url: "http://localhost:10000"}},
+			false, []ChangeListener{{Name: "svc2-2", Url: "http://localhost:10000"}}, nil,
 		}
 
-		multi := &MultiDiscovery{[]Discoverer{disco1, disco2}}
+		multi := &MultiDiscovery{Discoverers: []Discoverer{disco1, disco2}}
 
 		Convey("Run() invokes the Run() method for all the discoverers", func() {
-			multi.Run(looper)
+			multi.Run(context.Background(), looper)
 
 			So(disco1.RunInvoked, ShouldBeTrue)
 			So(disco2.RunInvoked, ShouldBeTrue)
 		})
 
+		Convey("Run() cancels the discoverers when it returns", func() {
+			multi.Run(context.Background(), looper)
+
+			So(disco1.RunContext.Err(), ShouldNotBeNil)
+			So(disco2.RunContext.Err(), ShouldNotBeNil)
+		})
+
+		Convey("Run() returns when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			multi.Run(ctx, director.NewTimedLooper(director.FOREVER, 1*time.Hour, nil))
+
+			So(disco1.RunContext.Err(), ShouldNotBeNil)
+		})
+
 		Convey("Services() invokes the Services() method for all the discoverers", func() {
 			multi.Services()
 
@@ -1,8 +1,14 @@
+//go:build !windows
+// +build !windows
+
 package discovery
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,10 +19,6 @@ import (
 	"github.com/fsouza/go-dockerclient"
 )
 
-const (
-	CacheDrainInterval = 10 * time.Minute // Drain the cache every 10 mins
-)
-
 type DockerClient interface {
 	InspectContainer(id string) (*docker.Container, error)
 	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
@@ -26,15 +28,28 @@ type DockerClient interface {
 }
 
 type DockerDiscovery struct {
-	events         chan *docker.APIEvents       // Where events are announced to us
-	endpoint       string                       // The Docker endpoint to talk to
-	services       []*service.Service           // The list of services we know about
-	ClientProvider func() (DockerClient, error) // Return the client we'll use to connect
-	serviceNamer   ServiceNamer                 // The service namer implementation
-	advertiseIp    string                       // The address we'll advertise for services
-	containerCache *ContainerCache              // Stores full container data for fast lookups
-	sleepInterval  time.Duration                // The sleep interval for event processing and reconnection
-	sync.RWMutex                                // Reader/Writer lock
+	events         chan *docker.APIEvents        // Where events are announced to us
+	endpoint       string                        // The Docker endpoint to talk to
+	services       []*service.Service            // The list of services we know about
+	ClientProvider func() (DockerClient, error)  // Return the client we'll use to connect
+	serviceNamer   ServiceNamer                  // The service namer implementation
+	advertiseIp    string                        // The address we'll advertise for services
+	containerCache *ContainerCache               // Stores full container data for fast lookups
+	sleepInterval  time.Duration                 // The sleep interval for event processing and reconnection
+	Hostname       string                        // The hostname to announce services with, defaults to the OS hostname
+	StatsInterval  time.Duration                 // How often to snapshot container resources, zero disables
+	Networks       []string                      // Advertise containers on their address in the first of these networks they're on
+	resources      map[string]*service.Resources // The latest resource snapshots, by service ID
+	health         healthTracker                 // How our calls to Docker are going
+	sync.RWMutex                                 // Reader/Writer lock
+
+	// Which Docker client to use, DockerClientLegacy (the default) or
+	// DockerClientSDK, and the API version to ask the SDK client for.
+	// Without one, it negotiates the version with the daemon.
+	Client     string
+	APIVersion string
+	sdk        *sdkClient // Kept between calls so it reuses its connections
+	sdkLock    sync.Mutex
 }
 
 func NewDockerDiscovery(endpoint string, svcNamer ServiceNamer, ip string) *DockerDiscovery {
@@ -54,6 +69,10 @@ func NewDockerDiscovery(endpoint string, svcNamer ServiceNamer, ip string) *Dock
 }
 
 func (d *DockerDiscovery) getDockerClient() (DockerClient, error) {
+	if d.Client == DockerClientSDK {
+		return d.getSDKClient()
+	}
+
 	if d.endpoint != "" {
 		client, err := docker.NewClient(d.endpoint)
 		if err != nil {
@@ -70,6 +89,22 @@ func (d *DockerDiscovery) getDockerClient() (DockerClient, error) {
 	return client, nil
 }
 
+// getSDKClient returns the official Docker client, connecting the first time
+func (d *DockerDiscovery) getSDKClient() (DockerClient, error) {
+	d.sdkLock.Lock()
+	defer d.sdkLock.Unlock()
+
+	if d.sdk == nil {
+		client, err := newSDKClient(d.endpoint, d.APIVersion)
+		if err != nil {
+			return nil, err
+		}
+		d.sdk = client
+	}
+
+	return d.sdk, nil
+}
+
 // HealthCheck looks up a health check using Docker container labels to
 // pass the type of check and the arguments to pass to it.
 func (d *DockerDiscovery) HealthCheck(svc *service.Service) (string, string) {
@@ -81,43 +116,117 @@ func (d *DockerDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return container.Config.Labels["HealthCheck"], container.Config.Labels["HealthCheckArgs"]
 }
 
-func (d *DockerDiscovery) inspectContainer(svc *service.Service) (*docker.Container, error) {
-	// If we have it cached, return it!
-	container := d.containerCache.Get(svc.ID)
-	if container != nil {
-		return container, nil
+// HealthCheckOptions looks up extra settings for health checks in the
+// container labels
+func (d *DockerDiscovery) HealthCheckOptions(svc *service.Service) CheckOptions {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		return CheckOptions{}
 	}
 
-	// New connection every time
-	client, err := d.ClientProvider()
-	if err != nil {
-		log.Errorf("Error when creating Docker client: %s\n", err.Error())
-		return nil, err
+	return CheckOptions{
+		StatusCodes: container.Config.Labels["HealthCheckStatusCodes"],
+		BodyMatch:   container.Config.Labels["HealthCheckBodyMatch"],
+		MaxLatency:  container.Config.Labels["HealthCheckMaxLatency"],
+		DependsOn:   container.Config.Labels["HealthCheckDependsOn"],
+		Aggregation: container.Config.Labels["HealthCheckAggregation"],
+		Policy:      container.Config.Labels["HealthCheckPolicy"],
 	}
+}
 
-	container, err = client.InspectContainer(svc.ID)
+// PortHealthChecks looks up the per-port health checks in the container
+// labels
+func (d *DockerDiscovery) PortHealthChecks(svc *service.Service) []PortCheck {
+	container, err := d.inspectContainer(svc)
 	if err != nil {
-		log.Errorf("Error inspecting container : %v\n", svc.ID)
-		return nil, err
+		return nil
 	}
 
-	// Cache it for next time
-	d.containerCache.Set(svc, container)
+	return portChecksFromLabels(container.Config.Labels)
+}
 
-	return container, nil
+// portChecksFromLabels finds the HealthCheck_<port> labels, and the matching
+// HealthCheckArgs_<port> labels, and returns them sorted by port
+func portChecksFromLabels(labels map[string]string) []PortCheck {
+	var checks []PortCheck
+	for label, checkType := range labels {
+		if !strings.HasPrefix(label, "HealthCheck_") || checkType == "" {
+			continue
+		}
+
+		portStr := strings.TrimPrefix(label, "HealthCheck_")
+		port, err := strconv.ParseInt(portStr, 10, 64)
+		if err != nil {
+			log.Warnf("Ignoring health check label %s, %q is not a port", label, portStr)
+			continue
+		}
+
+		checks = append(checks, PortCheck{
+			Port: port,
+			Type: checkType,
+			Args: labels["HealthCheckArgs_"+portStr],
+		})
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Port < checks[j].Port })
+
+	return checks
+}
+
+// inspectContainer returns the container from the cache, asking Docker for
+// it when it isn't there
+func (d *DockerDiscovery) inspectContainer(svc *service.Service) (*docker.Container, error) {
+	return d.containerCache.GetOrInspect(svc.ID, func() (*docker.Container, error) {
+		// The legacy client makes a new connection every time
+		client, err := d.ClientProvider()
+		if err != nil {
+			log.Errorf("Error when creating Docker client: %s\n", err.Error())
+			return nil, err
+		}
+
+		container, err := client.InspectContainer(svc.ID)
+		if err != nil {
+			log.Errorf("Error inspecting container : %v\n", svc.ID)
+			return nil, err
+		}
+
+		return container, nil
+	})
+}
+
+// SetCacheLimits bounds the number of inspected containers we keep, and how
+// long we keep them for. Zero means no limit.
+func (d *DockerDiscovery) SetCacheLimits(maxSize int, ttl time.Duration) {
+	d.containerCache.Lock()
+	defer d.containerCache.Unlock()
+
+	d.containerCache.MaxSize = maxSize
+	d.containerCache.TTL = ttl
 }
 
-// The main loop, poll for containers continuously.
-func (d *DockerDiscovery) Run(looper director.Looper) {
+// The main loop, poll for containers continuously until the looper quits or
+// the context is cancelled.
+func (d *DockerDiscovery) Run(ctx context.Context, looper director.Looper) {
 	connQuitChan := make(chan bool)
 
 	go d.manageConnection(connQuitChan)
 
+	if d.StatsInterval > 0 {
+		go d.collectStats(ctx)
+	}
+
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
 	go func() {
 		// Loop around, process any events which came in, and
 		// periodically fetch the whole container list
 		looper.Loop(func() error {
 			select {
+			case <-ctx.Done():
+				// Shutting down, the looper will pick up the Quit()
 			case event := <-d.events:
 				if event == nil {
 					// This usually happens because of a Docker restart.
@@ -128,8 +237,6 @@ func (d *DockerDiscovery) Run(looper director.Looper) {
 				d.handleEvent(*event)
 			case <-time.After(d.sleepInterval):
 				d.getContainers()
-			case <-time.After(CacheDrainInterval):
-				d.containerCache.Drain(len(d.services))
 			}
 
 			return nil
@@ -149,6 +256,12 @@ func (d *DockerDiscovery) Services() []service.Service {
 
 	for i, svc := range d.services {
 		svcList[i] = *svc
+
+		// Hand out a copy so nobody shares the snapshot with us
+		if resources, ok := d.resources[svc.ID]; ok {
+			snapshot := *resources
+			svcList[i].Resources = &snapshot
+		}
 	}
 
 	return svcList
@@ -245,18 +358,37 @@ func portForServicePort(svc *service.Service, portStr string, pType string) *ser
 }
 
 func (d *DockerDiscovery) getContainers() {
-	// New connection every time
-	client, err := d.ClientProvider()
+	containers, err := d.listContainers()
 	if err != nil {
-		log.Errorf("Error when creating Docker client: %s\n", err.Error())
 		return
 	}
 
-	containers, err := client.ListContainers(docker.ListContainersOptions{All: false})
+	d.setServices(containers)
+}
+
+// Health reports whether we've been able to list the containers lately
+func (d *DockerDiscovery) Health() Health {
+	return d.health.Health()
+}
+
+// listContainers asks Docker for the running containers, and records
+// whether that worked in our Health
+func (d *DockerDiscovery) listContainers() ([]docker.APIContainers, error) {
+	// The legacy client makes a new connection every time
+	client, err := d.ClientProvider()
 	if err != nil {
-		return
+		log.Errorf("Error when creating Docker client: %s\n", err.Error())
+		d.health.record(err)
+		return nil, err
 	}
 
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: false})
+	d.health.record(err)
+	return containers, err
+}
+
+// setServices replaces the service list with the running containers
+func (d *DockerDiscovery) setServices(containers []docker.APIContainers) {
 	d.Lock()
 	defer d.Unlock()
 
@@ -271,8 +403,12 @@ func (d *DockerDiscovery) getContainers() {
 			continue
 		}
 
-		svc := service.ToService(&container, d.advertiseIp)
+		svc := service.ToServiceOnNetworks(&container, d.advertiseIp, d.Networks)
 		svc.Name = d.serviceNamer.ServiceName(&container)
+		svc.Source = SourceDocker
+		if d.Hostname != "" {
+			svc.Hostname = d.Hostname
+		}
 		d.services = append(d.services, &svc)
 		containerMap[svc.ID] = true
 	}
@@ -280,6 +416,58 @@ func (d *DockerDiscovery) getContainers() {
 	d.containerCache.Prune(containerMap)
 }
 
+// reconcile runs when we reconnect to Docker. Any events sent while we were
+// disconnected are lost, e.g. when dockerd restarts, so we compare what is
+// running now with what we knew about and replay the difference. Returns how
+// many services were added and removed.
+func (d *DockerDiscovery) reconcile() (int, int) {
+	containers, err := d.listContainers()
+	if err != nil {
+		log.Warnf("Unable to reconcile containers after reconnecting to Docker: %s", err)
+		return 0, 0
+	}
+
+	running := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		if len(container.ID) < 12 || container.Labels["SidecarDiscover"] == "false" {
+			continue
+		}
+		running[container.ID[:12]] = true
+	}
+
+	d.RLock()
+	known := make(map[string]bool, len(d.services))
+	var gone []string
+	for _, svc := range d.services {
+		known[svc.ID] = true
+		if !running[svc.ID] {
+			gone = append(gone, svc.ID)
+		}
+	}
+	d.RUnlock()
+
+	// Replay the stop events we missed
+	for _, id := range gone {
+		d.handleEvent(docker.APIEvents{ID: id, Status: "die"})
+	}
+
+	added := 0
+	for id := range running {
+		if !known[id] {
+			added++
+		}
+	}
+
+	// Pick up anything that started while we were away
+	d.setServices(containers)
+
+	log.Infof("Reconciled containers after reconnecting to Docker: %d added, %d removed",
+		added, len(gone),
+	)
+
+	return added, len(gone)
+}
+
 func (d *DockerDiscovery) configureDockerConnection() DockerClient {
 	client, err := d.ClientProvider()
 	if err != nil {
@@ -311,6 +499,11 @@ func (d *DockerDiscovery) manageConnection(quit chan bool) {
 			d.events = make(chan *docker.APIEvents) // RemoveEventListener closes it
 
 			client = d.configureDockerConnection()
+
+			// We may have missed events while disconnected
+			if client != nil {
+				d.reconcile()
+			}
 		}
 
 		select {
@@ -345,60 +538,3 @@ func (d *DockerDiscovery) handleEvent(event docker.APIEvents) {
 		}
 	}
 }
-
-// A ContainerCache keeps a history of the containers we've inspected
-// in order to do fast lookups of container info when needed.
-type ContainerCache struct {
-	cache map[string]*docker.Container // Cache of inspected containers
-	sync.RWMutex
-}
-
-func NewContainerCache() *ContainerCache {
-	return &ContainerCache{
-		cache: make(map[string]*docker.Container),
-	}
-}
-
-// On a timed basis, drain the containerCache
-func (c *ContainerCache) Drain(newSize int) {
-	c.Lock()
-	defer c.Unlock()
-	// Make a new one, leave the old one for GC
-	c.cache = make(map[string]*docker.Container, newSize)
-}
-
-// Loop through the current cache and remove anything that has disappeared
-func (c *ContainerCache) Prune(liveContainers map[string]interface{}) {
-	c.Lock()
-	defer c.Unlock()
-
-	for id := range c.cache {
-		if _, ok := liveContainers[id]; !ok {
-			delete(c.cache, id)
-		}
-	}
-}
-
-// Get locks the cache, try to get a service if we have it
-func (c *ContainerCache) Get(svcID string) *docker.Container {
-	c.RLock()
-	defer c.RUnlock()
-
-	if container, ok := c.cache[svcID]; ok {
-		return container
-	}
-
-	return nil
-}
-
-func (c *ContainerCache) Set(svc *service.Service, container *docker.Container) {
-	c.Lock()
-	defer c.Unlock()
-	c.cache[svc.ID] = container
-}
-
-func (c *ContainerCache) Len() int {
-	c.RLock()
-	defer c.RUnlock()
-	return len(c.cache)
-}
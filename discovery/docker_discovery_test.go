@@ -1,6 +1,10 @@
+//go:build !windows
+// +build !windows
+
 package discovery
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -10,13 +14,13 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-var hostname = "shakespeare"
-
 // Define a stubDockerClient that we can use to test the discovery
 type stubDockerClient struct {
 	ErrorOnInspectContainer bool
 	ErrorOnPing             bool
 	PingChan                chan struct{}
+	Stats                   map[string]*docker.Stats
+	Containers              []docker.APIContainers
 }
 
 func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error) {
@@ -34,6 +38,11 @@ func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error
 					"HealthCheckArgs": "service1 check arguments",
 					"ServicePort_80":  "10000",
 					"SidecarListener": "10000",
+
+					"HealthCheck_10001":     "External",
+					"HealthCheckArgs_10001": "check-it",
+					"HealthCheck_10000":     "HttpGet",
+					"HealthCheck_admin":     "HttpGet",
 				},
 			},
 		}, nil
@@ -48,7 +57,7 @@ func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error
 }
 
 func (s *stubDockerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
-	return nil, nil
+	return s.Containers, nil
 }
 
 func (s *stubDockerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
@@ -69,6 +78,23 @@ func (s *stubDockerClient) Ping() error {
 	return nil
 }
 
+// statsDockerClient also supports fetching container stats
+type statsDockerClient struct {
+	stubDockerClient
+}
+
+func (s *statsDockerClient) Stats(opts docker.StatsOptions) error {
+	defer close(opts.Stats)
+
+	stats, ok := s.stubDockerClient.Stats[opts.ID]
+	if !ok {
+		return errors.New("no such container")
+	}
+
+	opts.Stats <- stats
+	return nil
+}
+
 type dummyLooper struct{}
 
 // Loop will block for enough time to prevent the event loop in DockerDiscovery.Run()
@@ -172,6 +198,20 @@ func Test_DockerDiscovery(t *testing.T) {
 			})
 		})
 
+		Convey("PortHealthChecks()", func() {
+			Convey("returns the checks for each port in order", func() {
+				checks := disco.PortHealthChecks(&service1)
+				So(checks, ShouldResemble, []PortCheck{
+					{Port: 10000, Type: "HttpGet"},
+					{Port: 10001, Type: "External", Args: "check-it"},
+				})
+			})
+
+			Convey("returns nothing when there are none", func() {
+				So(disco.PortHealthChecks(&service2), ShouldBeEmpty)
+			})
+		})
+
 		Convey("inspectContainer()", func() {
 			Convey("looks in the cache first", func() {
 				disco.containerCache.Set(&service1, &docker.Container{Path: "cached"})
@@ -219,11 +259,60 @@ func Test_DockerDiscovery(t *testing.T) {
 			})
 		})
 
+		Convey("reconcile()", func() {
+			disco.services = services
+			client.Containers = []docker.APIContainers{
+				{ID: svcId1 + "abcdef", Names: []string{"/beowulf-deadbeef1231"}},
+				{ID: "cafebabe0001abcdef", Names: []string{"/grendel-cafebabe0001"}},
+				{ID: "cafebabe0002abcdef", Names: []string{"/ignored-cafebabe0002"},
+					Labels: map[string]string{"SidecarDiscover": "false"}},
+			}
+
+			Convey("replays what changed while we were disconnected", func() {
+				added, removed := disco.reconcile()
+				So(added, ShouldEqual, 1)
+				So(removed, ShouldEqual, 1)
+
+				result := disco.Services()
+				So(len(result), ShouldEqual, 2)
+				So(result[0].ID, ShouldEqual, svcId1)
+				So(result[1].ID, ShouldEqual, "cafebabe0001")
+				So(result[1].Source, ShouldEqual, SourceDocker)
+			})
+
+			Convey("leaves the services alone when Docker can't be reached", func() {
+				disco.ClientProvider = func() (DockerClient, error) {
+					return nil, errors.New("still down")
+				}
+
+				added, removed := disco.reconcile()
+				So(added, ShouldEqual, 0)
+				So(removed, ShouldEqual, 0)
+				So(len(disco.Services()), ShouldEqual, 2)
+			})
+
+			Convey("records failures to reach Docker in the Health", func() {
+				disco.ClientProvider = func() (DockerClient, error) {
+					return nil, errors.New("permission denied")
+				}
+
+				disco.reconcile()
+				disco.getContainers()
+				So(disco.Health().ConsecutiveFailures, ShouldEqual, 2)
+				So(disco.Health().LastError, ShouldEqual, "permission denied")
+
+				disco.ClientProvider = stubClientProvider
+				disco.getContainers()
+				So(disco.Health().ConsecutiveFailures, ShouldEqual, 0)
+				So(disco.Health().LastSuccess.IsZero(), ShouldBeFalse)
+			})
+		})
+
 		Convey("Run()", func() {
 			disco.sleepInterval = 1 * time.Millisecond
 
 			Convey("pings Docker", func() {
-				disco.Run(&dummyLooper{})
+				disco.Run(context.Background(), &dummyLooper{})
 
 				// Check a few times that it tries to ping Docker
 				for i := 0; i < 3; i++ {
@@ -246,7 +335,7 @@ func Test_DockerDiscovery(t *testing.T) {
 				}
 
 				client.ErrorOnPing = true
-				disco.Run(&dummyLooper{})
+				disco.Run(context.Background(), &dummyLooper{})
 
 				// Check a few times that it tries to reconnect to Docker
 				for i := 0; i < 3; i++ {
@@ -263,3 +352,71 @@ func Test_DockerDiscovery(t *testing.T) {
 		})
 	})
 }
+
+func Test_DockerStats(t *testing.T) {
+	Convey("Collecting Docker container stats", t, func() {
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		stats := &docker.Stats{Read: baseTime}
+		stats.MemoryStats.Usage = 1024
+		stats.MemoryStats.Limit = 4096
+		stats.CPUStats.CPUUsage.TotalUsage = 300
+		stats.CPUStats.SystemCPUUsage = 2000
+		stats.CPUStats.OnlineCPUs = 2
+		stats.PreCPUStats.CPUUsage.TotalUsage = 100
+		stats.PreCPUStats.SystemCPUUsage = 1000
+
+		Convey("works out the CPU and memory usage", func() {
+			resources := resourcesFromStats(stats)
+
+			So(resources.CPUPercent, ShouldEqual, 40)
+			So(resources.MemoryUsage, ShouldEqual, 1024)
+			So(resources.MemoryLimit, ShouldEqual, 4096)
+			So(resources.Sampled, ShouldEqual, baseTime)
+		})
+
+		Convey("counts the CPUs when Docker doesn't say how many are online", func() {
+			stats.CPUStats.OnlineCPUs = 0
+			stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1, 2, 3, 4}
+
+			So(resourcesFromStats(stats).CPUPercent, ShouldEqual, 80)
+		})
+
+		Convey("doesn't report CPU usage without a previous sample", func() {
+			stats.PreCPUStats = docker.CPUStats{}
+			stats.CPUStats.SystemCPUUsage = 0
+
+			So(resourcesFromStats(stats).CPUPercent, ShouldEqual, 0)
+		})
+
+		Convey("attaches the snapshots to services", func() {
+			client := &statsDockerClient{
+				stubDockerClient{Stats: map[string]*docker.Stats{"deadbeef1231": stats}},
+			}
+
+			disco := NewDockerDiscovery("", &RegexpNamer{}, "127.0.0.1")
+			disco.ClientProvider = func() (DockerClient, error) { return client, nil }
+			disco.services = []*service.Service{
+				{ID: "deadbeef1231", Hostname: hostname},
+				{ID: "deadbeef1011", Hostname: hostname},
+			}
+
+			disco.refreshStats(context.Background())
+			services := disco.Services()
+
+			So(services[0].Resources, ShouldNotBeNil)
+			So(services[0].Resources.MemoryUsage, ShouldEqual, 1024)
+			So(services[1].Resources, ShouldBeNil)
+		})
+
+		Convey("skips clients that can't fetch stats", func() {
+			disco := NewDockerDiscovery("", &RegexpNamer{}, "127.0.0.1")
+			disco.ClientProvider = func() (DockerClient, error) { return &stubDockerClient{}, nil }
+			disco.services = []*service.Service{{ID: "deadbeef1231", Hostname: hostname}}
+
+			disco.refreshStats(context.Background())
+
+			So(disco.Services()[0].Resources, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,298 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DockerClientSDK    = "sdk"    // The official Docker client, opt-in
+	DockerClientLegacy = "legacy" // go-dockerclient, the default
+
+	DockerRequestTimeout = 10 * time.Second // How long we wait on Docker for a single request
+	DockerIdleConns      = 4                // Connections to Docker we keep open between requests
+)
+
+// An sdkClient is a DockerClient that uses the official Docker client. It
+// negotiates the API version with the daemon, so it keeps working with new
+// daemons, and it's kept around between calls, so it reuses its connections.
+// It turns what Docker returns into the go-dockerclient types the rest of
+// discovery uses.
+type sdkClient struct {
+	client       *client.Client
+	streams      map[chan<- *docker.APIEvents]*eventStream
+	streamFailed int32 // Set when an event stream dies, so Ping() reports it
+	sync.Mutex
+}
+
+// An eventStream delivers events from Docker to one listener
+type eventStream struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newSDKClient connects to Docker at the endpoint, or wherever DOCKER_HOST
+// says when it's empty. Without an apiVersion, it uses the newest version
+// both we and the daemon support, so the daemon has to be up.
+func newSDKClient(endpoint string, apiVersion string) (*sdkClient, error) {
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: DockerIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	opts := []func(*client.Client) error{
+		client.WithHTTPClient(&http.Client{Transport: transport, CheckRedirect: client.CheckRedirect}),
+	}
+
+	if endpoint != "" {
+		opts = append(opts, client.WithHost(endpoint))
+	} else {
+		opts = append(opts, client.FromEnv)
+	}
+
+	if apiVersion != "" {
+		opts = append(opts, client.WithVersion(apiVersion))
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DockerRequestTimeout)
+	defer cancel()
+
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+
+	if apiVersion == "" {
+		cli.NegotiateAPIVersionPing(ping)
+	}
+
+	info, err := cli.Info(ctx)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+
+	log.Infof("Connected to Docker %s on %s/%s, using API version %s",
+		info.ServerVersion, info.OSType, info.Architecture, cli.ClientVersion(),
+	)
+
+	return &sdkClient{
+		client:  cli,
+		streams: make(map[chan<- *docker.APIEvents]*eventStream),
+	}, nil
+}
+
+func (c *sdkClient) InspectContainer(id string) (*docker.Container, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DockerRequestTimeout)
+	defer cancel()
+
+	_, raw, err := c.client.ContainerInspectWithRaw(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var container docker.Container
+	err = decodeDockerType(raw, &container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &container, nil
+}
+
+func (c *sdkClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DockerRequestTimeout)
+	defer cancel()
+
+	found, err := c.client.ContainerList(ctx, types.ContainerListOptions{All: opts.All})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(found)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []docker.APIContainers
+	err = decodeDockerType(data, &containers)
+	if err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// AddEventListener streams container events to the listener until it's
+// removed or the stream fails
+func (c *sdkClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &eventStream{cancel: cancel, done: make(chan struct{})}
+
+	c.Lock()
+	if _, ok := c.streams[listener]; ok {
+		c.Unlock()
+		cancel()
+		return docker.ErrListenerAlreadyExists
+	}
+	c.streams[listener] = stream
+	atomic.StoreInt32(&c.streamFailed, 0)
+	c.Unlock()
+
+	messages, errs := c.client.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", "container")),
+	})
+
+	go func() {
+		defer close(stream.done)
+
+		for {
+			select {
+			case msg := <-messages:
+				select {
+				case listener <- toAPIEvent(msg):
+				case <-ctx.Done():
+					return
+				}
+			case err := <-errs:
+				if ctx.Err() == nil {
+					log.Warnf("Docker event stream ended: %s", err)
+					atomic.StoreInt32(&c.streamFailed, 1)
+				}
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RemoveEventListener stops the events to the listener and closes it
+func (c *sdkClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	c.Lock()
+	stream, ok := c.streams[listener]
+	delete(c.streams, listener)
+	c.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	stream.cancel()
+	<-stream.done
+	close(listener)
+
+	return nil
+}
+
+// Ping fails when Docker is unreachable, or when we've lost the event
+// stream, so that we reconnect either way
+func (c *sdkClient) Ping() error {
+	if atomic.LoadInt32(&c.streamFailed) != 0 {
+		return ErrEventStreamLost
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DockerRequestTimeout)
+	defer cancel()
+
+	_, err := c.client.Ping(ctx)
+	return err
+}
+
+// Stats fetches a single stats snapshot. Like go-dockerclient, it closes the
+// Stats channel when it's done.
+func (c *sdkClient) Stats(opts docker.StatsOptions) error {
+	defer close(opts.Stats)
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	resp, err := c.client.ContainerStats(ctx, opts.ID, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var stats docker.Stats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case opts.Stats <- &stats:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// toAPIEvent turns an event into the go-dockerclient type. Newer daemons
+// leave out the old Status and ID fields, so we fill them in from the Action
+// and Actor.
+func toAPIEvent(msg events.Message) *docker.APIEvents {
+	event := &docker.APIEvents{
+		Action:   msg.Action,
+		Type:     msg.Type,
+		Actor:    docker.APIActor{ID: msg.Actor.ID, Attributes: msg.Actor.Attributes},
+		Status:   msg.Status,
+		ID:       msg.ID,
+		From:     msg.From,
+		Time:     msg.Time,
+		TimeNano: msg.TimeNano,
+	}
+
+	if event.Status == "" {
+		event.Status = msg.Action
+	}
+
+	if event.ID == "" {
+		event.ID = msg.Actor.ID
+	}
+
+	return event
+}
+
+// decodeDockerType decodes Docker's JSON into a go-dockerclient type. A few
+// rarely used fields have changed type between API versions, and are left
+// out rather than failing the whole thing.
+func decodeDockerType(data []byte, to interface{}) error {
+	err := json.Unmarshal(data, to)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		log.Debugf("Skipped Docker field %s when decoding: %s", typeErr.Field, err)
+		return nil
+	}
+
+	return err
+}
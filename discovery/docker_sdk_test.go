@@ -0,0 +1,149 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A fakeDockerd answers just enough of the Docker API for the SDK client
+type fakeDockerd struct {
+	versions []string // The API versions requests asked for
+	sync.Mutex
+}
+
+func (f *fakeDockerd) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/_ping" {
+		response.Header().Set("API-Version", "1.30")
+		response.Write([]byte("OK"))
+		return
+	}
+
+	// Strip off and record the version, e.g. /v1.30/info
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	f.Lock()
+	f.versions = append(f.versions, parts[0])
+	f.Unlock()
+
+	response.Header().Set("Content-Type", "application/json")
+
+	switch "/" + parts[1] {
+	case "/info":
+		response.Write([]byte(`{"ServerVersion": "17.06.0", "OSType": "linux", "Architecture": "x86_64"}`))
+	case "/containers/json":
+		response.Write([]byte(`[{
+			"Id": "deadbeef1231abcd", "Names": ["/sad_wozniak"], "Image": "gonito",
+			"Created": 1500000000, "Labels": {"ServicePort_80": "10000"},
+			"Ports": [{"IP": "0.0.0.0", "PrivatePort": 80, "PublicPort": 32768, "Type": "tcp"}]
+		}]`))
+	case "/containers/deadbeef1231/json":
+		response.Write([]byte(`{
+			"Id": "deadbeef1231abcd", "Created": "2017-07-14T02:40:00Z",
+			"State": {"Running": true, "Health": {"Status": "healthy", "FailingStreak": 0}},
+			"Config": {"Labels": {"HealthCheck": "HttpGet"}},
+			"HostConfig": {"BlkioWeightDevice": [{"Path": "/dev/sda", "Weight": 10}]}
+		}`))
+	case "/containers/deadbeef1231/stats":
+		response.Write([]byte(`{"memory_stats": {"usage": 1024, "limit": 4096}}`))
+	case "/events":
+		// Newer daemons only send the Action and Actor
+		response.Write([]byte(`{"Type": "container", "Action": "die", "Actor": {"ID": "deadbeef1231abcd"}}` + "\n"))
+		response.(http.Flusher).Flush()
+		<-req.Context().Done()
+	default:
+		http.NotFound(response, req)
+	}
+}
+
+func (f *fakeDockerd) lastVersion() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.versions[len(f.versions)-1]
+}
+
+func Test_SDKClient(t *testing.T) {
+	Convey("The SDK Docker client", t, func() {
+		dockerd := &fakeDockerd{}
+		server := httptest.NewServer(dockerd)
+		defer server.Close()
+
+		endpoint := "tcp://" + strings.TrimPrefix(server.URL, "http://")
+
+		Convey("negotiates the API version with the daemon", func() {
+			client, err := newSDKClient(endpoint, "")
+			So(err, ShouldBeNil)
+			So(dockerd.lastVersion(), ShouldEqual, "v1.30")
+			So(client.Ping(), ShouldBeNil)
+		})
+
+		Convey("uses the API version it's given", func() {
+			_, err := newSDKClient(endpoint, "1.25")
+			So(err, ShouldBeNil)
+			So(dockerd.lastVersion(), ShouldEqual, "v1.25")
+		})
+
+		Convey("fails when Docker isn't there", func() {
+			_, err := newSDKClient("tcp://127.0.0.1:1", "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("lists and inspects containers", func() {
+			client, err := newSDKClient(endpoint, "")
+			So(err, ShouldBeNil)
+
+			containers, err := client.ListContainers(docker.ListContainersOptions{})
+			So(err, ShouldBeNil)
+			So(len(containers), ShouldEqual, 1)
+			So(containers[0].ID, ShouldEqual, "deadbeef1231abcd")
+			So(containers[0].Names, ShouldResemble, []string{"/sad_wozniak"})
+			So(containers[0].Labels["ServicePort_80"], ShouldEqual, "10000")
+			So(containers[0].Ports[0].PublicPort, ShouldEqual, 32768)
+
+			container, err := client.InspectContainer("deadbeef1231")
+			So(err, ShouldBeNil)
+			So(container.Config.Labels["HealthCheck"], ShouldEqual, "HttpGet")
+			So(container.State.Health.Status, ShouldEqual, "healthy")
+		})
+
+		Convey("fetches stats", func() {
+			client, err := newSDKClient(endpoint, "")
+			So(err, ShouldBeNil)
+
+			stats, err := fetchStats(context.Background(), client, "deadbeef1231")
+			So(err, ShouldBeNil)
+			So(stats.MemoryStats.Usage, ShouldEqual, 1024)
+		})
+
+		Convey("streams events, filling in the old fields", func() {
+			client, err := newSDKClient(endpoint, "")
+			So(err, ShouldBeNil)
+
+			listener := make(chan *docker.APIEvents)
+			So(client.AddEventListener(listener), ShouldBeNil)
+
+			var event *docker.APIEvents
+			select {
+			case event = <-listener:
+			case <-time.After(5 * time.Second):
+			}
+
+			So(event, ShouldNotBeNil)
+			So(event.Status, ShouldEqual, "die")
+			So(event.ID, ShouldEqual, "deadbeef1231abcd")
+
+			So(client.RemoveEventListener(listener), ShouldBeNil)
+			_, ok := <-listener
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
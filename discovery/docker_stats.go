@@ -0,0 +1,135 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StatsTimeout = 10 * time.Second // How long we wait on Docker for a stats snapshot
+)
+
+// DockerStatsClient is implemented by Docker clients that can fetch container
+// resource stats. It's separate from DockerClient so that stats collection
+// stays optional.
+type DockerStatsClient interface {
+	Stats(opts docker.StatsOptions) error
+}
+
+// collectStats periodically takes a snapshot of the resources used by each
+// container we know about, until the context is cancelled.
+func (d *DockerDiscovery) collectStats(ctx context.Context) {
+	ticker := time.NewTicker(d.StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.refreshStats(ctx)
+		}
+	}
+}
+
+// refreshStats fetches a stats snapshot for each running container and
+// throws away anything we had for containers that have gone away.
+func (d *DockerDiscovery) refreshStats(ctx context.Context) {
+	client, err := d.ClientProvider()
+	if err != nil {
+		log.Errorf("Error when creating Docker client: %s", err)
+		return
+	}
+
+	statsClient, ok := client.(DockerStatsClient)
+	if !ok {
+		log.Warn("Docker client doesn't support stats, not collecting them")
+		return
+	}
+
+	d.RLock()
+	ids := make([]string, 0, len(d.services))
+	for _, svc := range d.services {
+		ids = append(ids, svc.ID)
+	}
+	d.RUnlock()
+
+	resources := make(map[string]*service.Resources, len(ids))
+	for _, id := range ids {
+		stats, err := fetchStats(ctx, statsClient, id)
+		if err != nil {
+			log.Debugf("Unable to fetch stats for container %s: %s", id, err)
+			continue
+		}
+
+		resources[id] = resourcesFromStats(stats)
+	}
+
+	d.Lock()
+	d.resources = resources
+	d.Unlock()
+}
+
+// fetchStats gets a single, non-streaming, stats snapshot from Docker
+func fetchStats(ctx context.Context, client DockerStatsClient, id string) (*docker.Stats, error) {
+	statsChan := make(chan *docker.Stats, 1)
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- client.Stats(docker.StatsOptions{
+			ID:      id,
+			Stats:   statsChan,
+			Stream:  false,
+			Timeout: StatsTimeout,
+			Context: ctx,
+		})
+	}()
+
+	// The client closes statsChan when it's done, so we get either a
+	// snapshot or nil, followed by any error.
+	stats := <-statsChan
+	err := <-errChan
+	if err != nil {
+		return nil, err
+	}
+
+	if stats == nil {
+		return nil, errors.New("no stats returned")
+	}
+
+	return stats, nil
+}
+
+// resourcesFromStats turns Docker's stats into Resources. CPU usage is
+// calculated the same way the Docker CLI does it.
+func resourcesFromStats(stats *docker.Stats) *service.Resources {
+	resources := &service.Resources{
+		MemoryUsage: stats.MemoryStats.Usage,
+		MemoryLimit: stats.MemoryStats.Limit,
+		Sampled:     stats.Read.UTC(),
+	}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) -
+		float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) -
+		float64(stats.PreCPUStats.SystemCPUUsage)
+
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		resources.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	return resources
+}
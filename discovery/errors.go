@@ -0,0 +1,21 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEventStreamLost is returned by the Docker client's Ping() when the event
+// stream has broken, so it must reconnect
+var ErrEventStreamLost = errors.New("lost the Docker event stream")
+
+// An APIStatusError is returned when the Kubernetes API answers with a
+// status other than 2xx
+type APIStatusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("got unexpected response code from %s: %d", e.Path, e.StatusCode)
+}
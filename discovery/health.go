@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Health describes how the recent discovery runs went
+type Health struct {
+	ConsecutiveFailures int       // Runs that failed since the last success
+	LastError           string    // The error from the last failed run
+	LastSuccess         time.Time // When a run last worked, zero if never
+}
+
+// A HealthReporter is a Discoverer that knows whether its discovery runs
+// are working. When they aren't, its service list can't be trusted.
+type HealthReporter interface {
+	Health() Health
+}
+
+// A healthTracker records the results of discovery runs for a Discoverer
+type healthTracker struct {
+	health Health
+	sync.Mutex
+}
+
+// record notes the outcome of one discovery run
+func (t *healthTracker) record(err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if err != nil {
+		t.health.ConsecutiveFailures++
+		t.health.LastError = err.Error()
+		metrics.IncrCounter([]string{"discovery", "failures"}, 1)
+		return
+	}
+
+	t.health.ConsecutiveFailures = 0
+	t.health.LastError = ""
+	t.health.LastSuccess = time.Now().UTC()
+}
+
+// Health returns the current Health
+func (t *healthTracker) Health() Health {
+	t.Lock()
+	defer t.Unlock()
+	return t.health
+}
+
+// Health returns the worst Health of the discoverers that report it, i.e.
+// the one with the most failures in a row
+func (d *MultiDiscovery) Health() Health {
+	var worst Health
+	for _, disco := range d.Discoverers {
+		reporter, ok := disco.(HealthReporter)
+		if !ok {
+			continue
+		}
+
+		health := reporter.Health()
+		if health.ConsecutiveFailures > worst.ConsecutiveFailures {
+			worst = health
+		}
+	}
+	return worst
+}
+
+// A HealthGate decides whether discovery is working well enough to trust
+// that a service it no longer finds has really gone away. If a discoverer
+// breaks, e.g. it lost access to the Docker socket, every local service
+// looks like it's gone, and we don't want to tombstone them all.
+type HealthGate struct {
+	Reporter  HealthReporter
+	Threshold int // Failures in a row before we stop trusting discovery, 0 disables
+	failing   bool
+	sync.Mutex
+}
+
+// Healthy returns false when discovery has failed at least Threshold times
+// in a row. Logs and sets the discovery.unhealthy gauge when that changes.
+func (g *HealthGate) Healthy() bool {
+	health := g.Reporter.Health()
+	failing := g.Threshold > 0 && health.ConsecutiveFailures >= g.Threshold
+
+	g.Lock()
+	defer g.Unlock()
+
+	if failing != g.failing {
+		if failing {
+			log.Warnf(
+				"Discovery has failed %d times in a row, not tombstoning local services until it recovers: %s",
+				health.ConsecutiveFailures, health.LastError,
+			)
+		} else {
+			log.Info("Discovery has recovered, tombstoning local services again")
+		}
+		g.failing = failing
+	}
+
+	var gauge float32
+	if failing {
+		gauge = 1
+	}
+	metrics.SetGauge([]string{"discovery", "unhealthy"}, gauge)
+
+	return !failing
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type healthyDiscoverer struct {
+	mockDiscoverer
+	health Health
+}
+
+func (h *healthyDiscoverer) Health() Health {
+	return h.health
+}
+
+func Test_DiscoveryHealth(t *testing.T) {
+	Convey("Discovery health", t, func() {
+		Convey("healthTracker", func() {
+			tracker := &healthTracker{}
+
+			Convey("counts failures in a row", func() {
+				tracker.record(errors.New("oops"))
+				tracker.record(errors.New("oops again"))
+
+				health := tracker.Health()
+				So(health.ConsecutiveFailures, ShouldEqual, 2)
+				So(health.LastError, ShouldEqual, "oops again")
+				So(health.LastSuccess.IsZero(), ShouldBeTrue)
+			})
+
+			Convey("starts over after a success", func() {
+				tracker.record(errors.New("oops"))
+				tracker.record(nil)
+
+				health := tracker.Health()
+				So(health.ConsecutiveFailures, ShouldEqual, 0)
+				So(health.LastError, ShouldBeEmpty)
+				So(health.LastSuccess.IsZero(), ShouldBeFalse)
+			})
+		})
+
+		Convey("MultiDiscovery returns the worst health", func() {
+			failing := &healthyDiscoverer{health: Health{ConsecutiveFailures: 4, LastError: "no socket"}}
+			working := &healthyDiscoverer{}
+			disco := &MultiDiscovery{
+				Discoverers: []Discoverer{working, &mockDiscoverer{}, failing},
+			}
+
+			health := disco.Health()
+			So(health.ConsecutiveFailures, ShouldEqual, 4)
+			So(health.LastError, ShouldEqual, "no socket")
+
+			disco.Discoverers = []Discoverer{working, &mockDiscoverer{}}
+			So(disco.Health().ConsecutiveFailures, ShouldEqual, 0)
+		})
+
+		Convey("HealthGate", func() {
+			reporter := &healthyDiscoverer{}
+			gate := &HealthGate{Reporter: reporter, Threshold: 3}
+
+			Convey("is healthy below the threshold", func() {
+				reporter.health.ConsecutiveFailures = 2
+				So(gate.Healthy(), ShouldBeTrue)
+			})
+
+			Convey("is unhealthy at the threshold, until discovery recovers", func() {
+				reporter.health.ConsecutiveFailures = 3
+				So(gate.Healthy(), ShouldBeFalse)
+				So(gate.Healthy(), ShouldBeFalse)
+
+				reporter.health.ConsecutiveFailures = 0
+				So(gate.Healthy(), ShouldBeTrue)
+			})
+
+			Convey("is always healthy when the threshold is zero", func() {
+				gate.Threshold = 0
+				reporter.health.ConsecutiveFailures = 100
+				So(gate.Healthy(), ShouldBeTrue)
+			})
+		})
+	})
+}
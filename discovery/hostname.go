@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// A HostnameNormalizer rewrites hostnames into a canonical form so that a
+// host is always known by the same name, e.g. when its hostname flaps
+// between the FQDN and the short form.
+type HostnameNormalizer func(hostname string) string
+
+// ParseHostnameNormalizer returns a HostnameNormalizer that applies each of
+// the comma separated steps in the spec, in order. Valid steps are
+// "lowercase" and "short", which strips everything after the first dot. An
+// empty spec returns nil, meaning hostnames are left alone.
+func ParseHostnameNormalizer(spec string) (HostnameNormalizer, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var steps []HostnameNormalizer
+	for _, step := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(step) {
+		case "lowercase":
+			steps = append(steps, strings.ToLower)
+		case "short":
+			steps = append(steps, shortHostname)
+		default:
+			return nil, fmt.Errorf("unknown hostname normalization %q", step)
+		}
+	}
+
+	return func(hostname string) string {
+		for _, step := range steps {
+			hostname = step(hostname)
+		}
+		return hostname
+	}, nil
+}
+
+// shortHostname strips the domain from a hostname. IP addresses are left
+// alone.
+func shortHostname(hostname string) string {
+	if net.ParseIP(hostname) != nil {
+		return hostname
+	}
+
+	if idx := strings.Index(hostname, "."); idx > 0 {
+		return hostname[:idx]
+	}
+
+	return hostname
+}
+
+// normalizeServices applies the normalizer to the hostname of each service
+func normalizeServices(services []service.Service, normalize HostnameNormalizer) {
+	if normalize == nil {
+		return
+	}
+
+	for i := range services {
+		services[i].Hostname = normalize(services[i].Hostname)
+	}
+}
@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HostnameNormalizer(t *testing.T) {
+	Convey("Normalizing hostnames", t, func() {
+		Convey("does nothing without a spec", func() {
+			normalize, err := ParseHostnameNormalizer("")
+			So(err, ShouldBeNil)
+			So(normalize, ShouldBeNil)
+		})
+
+		Convey("lowercases and shortens in order", func() {
+			normalize, err := ParseHostnameNormalizer("lowercase, short")
+			So(err, ShouldBeNil)
+			So(normalize("Shakespeare.Example.COM"), ShouldEqual, "shakespeare")
+			So(normalize("chaucer"), ShouldEqual, "chaucer")
+		})
+
+		Convey("leaves IP addresses alone", func() {
+			normalize, _ := ParseHostnameNormalizer("short")
+			So(normalize("10.0.0.1"), ShouldEqual, "10.0.0.1")
+		})
+
+		Convey("rejects unknown steps", func() {
+			_, err := ParseHostnameNormalizer("short,shouty")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is applied to discovered services", func() {
+			normalize, _ := ParseHostnameNormalizer("short")
+			disco := &mockDiscoverer{
+				ServicesList: []service.Service{{ID: "deadbeef123", Hostname: "shakespeare.example.com"}},
+			}
+			multi := &MultiDiscovery{Discoverers: []Discoverer{disco}, NormalizeHostname: normalize}
+
+			services := multi.Services()
+
+			So(services[0].Hostname, ShouldEqual, "shakespeare")
+			So(disco.ServicesList[0].Hostname, ShouldEqual, "shakespeare.example.com")
+		})
+	})
+}
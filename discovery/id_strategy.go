@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	HashIDLength = 12 // Same length as the random IDs we generate
+)
+
+// An IDStrategy assigns IDs to services which don't come with one of their
+// own, e.g. those from static discovery. Containers always have a stable ID.
+type IDStrategy interface {
+	ServiceID(*service.Service) (string, error)
+}
+
+// A RandomIDStrategy generates a new random ID every time it's asked. This
+// means the ID changes whenever Sidecar is restarted.
+type RandomIDStrategy struct{}
+
+func (r *RandomIDStrategy) ServiceID(svc *service.Service) (string, error) {
+	idBytes, err := RandomHex(HashIDLength / 2)
+	if err != nil {
+		return "", err
+	}
+
+	return string(idBytes), nil
+}
+
+// A HashIDStrategy derives the ID from the service name, hostname, and ports
+// so that the same service gets the same ID across Sidecar restarts.
+type HashIDStrategy struct{}
+
+func (h *HashIDStrategy) ServiceID(svc *service.Service) (string, error) {
+	if svc == nil {
+		return "", fmt.Errorf("can't generate an ID for a nil service")
+	}
+
+	ports := make([]string, 0, len(svc.Ports))
+	for _, port := range svc.Ports {
+		ports = append(ports, fmt.Sprintf("%s/%d", port.Type, port.Port))
+	}
+	sort.Strings(ports)
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%v", svc.Name, svc.Hostname, ports)
+
+	return hex.EncodeToString(hash.Sum(nil))[:HashIDLength], nil
+}
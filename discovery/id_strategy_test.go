@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_IDStrategies(t *testing.T) {
+	Convey("IDStrategies", t, func() {
+		svc := &service.Service{
+			Name:     "beowulf",
+			Hostname: "heorot",
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10234, ServicePort: 9999},
+				{Type: "udp", Port: 10235, ServicePort: 9998},
+			},
+		}
+
+		Convey("RandomIDStrategy generates a new ID each time", func() {
+			strategy := &RandomIDStrategy{}
+			first, err := strategy.ServiceID(svc)
+			So(err, ShouldBeNil)
+			second, _ := strategy.ServiceID(svc)
+
+			So(len(first), ShouldEqual, HashIDLength)
+			So(first, ShouldNotEqual, second)
+		})
+
+		Convey("HashIDStrategy", func() {
+			strategy := &HashIDStrategy{}
+			id, err := strategy.ServiceID(svc)
+			So(err, ShouldBeNil)
+			So(len(id), ShouldEqual, HashIDLength)
+
+			Convey("generates the same ID for the same service", func() {
+				again, _ := strategy.ServiceID(svc)
+				So(again, ShouldEqual, id)
+			})
+
+			Convey("doesn't care about port order or IPs", func() {
+				reordered := *svc
+				reordered.Ports = []service.Port{svc.Ports[1], svc.Ports[0]}
+				reordered.Ports[0].IP = "10.10.10.10"
+
+				again, _ := strategy.ServiceID(&reordered)
+				So(again, ShouldEqual, id)
+			})
+
+			Convey("generates a different ID on another host", func() {
+				other := *svc
+				other.Hostname = "hrothgar"
+
+				otherID, _ := strategy.ServiceID(&other)
+				So(otherID, ShouldNotEqual, id)
+			})
+
+			Convey("returns an error for a nil service", func() {
+				_, err := strategy.ServiceID(nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
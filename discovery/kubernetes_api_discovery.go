@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
 	"time"
@@ -19,11 +20,16 @@ type K8sAPIDiscoverer struct {
 
 	Command K8sDiscoveryAdapter
 
+	// IDStrategy is optional. When nil, the K8s object UID is used as
+	// the service ID.
+	IDStrategy IDStrategy
+
 	discoveredSvcs   *K8sServices
 	discoveredNodes  *K8sNodes
 	lock             sync.RWMutex
 	announceAllNodes bool
 	hostname         string
+	health           healthTracker
 }
 
 // NewK8sAPIDiscoverer returns a properly configured K8sAPIDiscoverer
@@ -64,6 +70,7 @@ func (k *K8sAPIDiscoverer) servicesForNode(hostname, ip string) []service.Servic
 			ProxyMode: "http",
 			Status:    service.ALIVE,
 			Updated:   time.Now().UTC(),
+			Source:    SourceK8sAPI,
 		}
 
 		for _, port := range item.Spec.Ports {
@@ -78,6 +85,16 @@ func (k *K8sAPIDiscoverer) servicesForNode(hostname, ip string) []service.Servic
 				IP:          ip,
 			})
 		}
+
+		if k.IDStrategy != nil {
+			id, err := k.IDStrategy.ServiceID(&svc)
+			if err != nil {
+				log.Errorf("Unable to generate an ID for %s, using the UID: %s", svc.Name, err)
+			} else {
+				svc.ID = id
+			}
+		}
+
 		services = append(services, svc)
 	}
 
@@ -141,23 +158,39 @@ func (k *K8sAPIDiscoverer) Listeners() []ChangeListener {
 }
 
 // Run is part of the Discoverer interface and calls the Command in a loop,
-// which is injected as a Looper.
-func (k *K8sAPIDiscoverer) Run(looper director.Looper) {
+// which is injected as a Looper. It stops when the context is cancelled.
+func (k *K8sAPIDiscoverer) Run(ctx context.Context, looper director.Looper) {
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
 	looper.Loop(func() error {
-		data, err := k.getServices()
-		if err != nil {
-			log.Errorf("Failed to unmarshal services json: %s, %s", err, string(data))
+		data, svcErr := k.getServices()
+		if svcErr != nil {
+			log.Errorf("Failed to unmarshal services json: %s, %s", svcErr, string(data))
+		}
+
+		data, nodeErr := k.getNodes()
+		if nodeErr != nil {
+			log.Errorf("Failed to unmarshal nodes json: %s, %s", nodeErr, string(data))
 		}
 
-		data, err = k.getNodes()
-		if err != nil {
-			log.Errorf("Failed to unmarshal nodes json: %s, %s", err, string(data))
+		if svcErr != nil {
+			k.health.record(svcErr)
+		} else {
+			k.health.record(nodeErr)
 		}
 
 		return nil
 	})
 }
 
+// Health reports whether we've been able to query the K8s API lately
+func (k *K8sAPIDiscoverer) Health() Health {
+	return k.health.Health()
+}
+
 func (k *K8sAPIDiscoverer) getServices() ([]byte, error) {
 	data, err := k.Command.GetServices()
 	if err != nil {
@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os"
 	"testing"
@@ -174,7 +175,7 @@ func Test_K8sGetServices(t *testing.T) {
 
 		Convey("calls the command and unmarshals the result", func() {
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetServicesWasCalled, ShouldBeTrue)
@@ -188,7 +189,7 @@ func Test_K8sGetServices(t *testing.T) {
 		Convey("call the command and logs errors", func() {
 			mock.GetServicesShouldError = true
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetServicesWasCalled, ShouldBeTrue)
@@ -198,7 +199,7 @@ func Test_K8sGetServices(t *testing.T) {
 		Convey("call the command and logs errors from the JSON output", func() {
 			mock.GetServicesShouldReturnJunk = true
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetServicesWasCalled, ShouldBeTrue)
@@ -217,7 +218,7 @@ func Test_K8sGetNodes(t *testing.T) {
 
 		Convey("calls the command and unmarshals the result", func() {
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetNodesWasCalled, ShouldBeTrue)
@@ -231,7 +232,7 @@ func Test_K8sGetNodes(t *testing.T) {
 		Convey("call the command and logs errors", func() {
 			mock.GetNodesShouldError = true
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetNodesWasCalled, ShouldBeTrue)
@@ -241,7 +242,7 @@ func Test_K8sGetNodes(t *testing.T) {
 		Convey("call the command and logs errors from the JSON output", func() {
 			mock.GetNodesShouldReturnJunk = true
 			log.SetOutput(capture)
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			log.SetOutput(os.Stdout)
 
 			So(mock.GetNodesWasCalled, ShouldBeTrue)
@@ -267,7 +268,7 @@ func Test_K8sServices(t *testing.T) {
 			disco.Command = mock
 
 			Convey("returns the list of cached services", func() {
-				disco.Run(director.NewFreeLooper(director.ONCE, nil))
+				disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 				services := disco.Services()
 
 				So(len(services), ShouldEqual, 2)
@@ -279,6 +280,7 @@ func Test_K8sServices(t *testing.T) {
 				So(svc.Hostname, ShouldEqual, "beowulf.example.com")
 				So(svc.ProxyMode, ShouldEqual, "http")
 				So(svc.Status, ShouldEqual, service.ALIVE)
+				So(svc.Source, ShouldEqual, SourceK8sAPI)
 				So(svc.Updated.Unix(), ShouldBeGreaterThan, time.Now().UTC().Add(-2*time.Second).Unix())
 				So(len(svc.Ports), ShouldEqual, 1)
 				So(svc.Ports[0].IP, ShouldEqual, "10.100.69.136")
@@ -289,7 +291,7 @@ func Test_K8sServices(t *testing.T) {
 			disco := NewK8sAPIDiscoverer("127.0.0.1", 443, "heorot", 3*time.Second, credsPath, false, "heorot.example.com")
 			disco.Command = mock
 
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			services := disco.Services()
 
 			So(len(services), ShouldEqual, 1)
@@ -305,5 +307,18 @@ func Test_K8sServices(t *testing.T) {
 			So(len(svc.Ports), ShouldEqual, 1)
 			So(svc.Ports[0].IP, ShouldEqual, "10.100.69.147")
 		})
+
+		Convey("uses the IDStrategy when there is one", func() {
+			disco := NewK8sAPIDiscoverer("127.0.0.1", 443, "heorot", 3*time.Second, credsPath, false, "heorot.example.com")
+			disco.Command = mock
+			disco.IDStrategy = &HashIDStrategy{}
+
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+			services := disco.Services()
+
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldNotEqual, "107b5bbf-9640-4fd0-b5de-1e898e8ae9f7")
+			So(len(services[0].ID), ShouldEqual, HashIDLength)
+		})
 	})
 }
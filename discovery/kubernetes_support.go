@@ -183,7 +183,7 @@ func (d *KubeAPIDiscoveryCommand) makeRequest(path string) ([]byte, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode > 299 || resp.StatusCode < 200 {
-		return []byte{}, fmt.Errorf("got unexpected response code from %s: %d", path, resp.StatusCode)
+		return []byte{}, &APIStatusError{Path: path, StatusCode: resp.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
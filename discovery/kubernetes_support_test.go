@@ -98,6 +98,10 @@ func Test_makeRequest(t *testing.T) {
 			So(auth, ShouldContainSubstring, "this would be a token")
 
 			So(err.Error(), ShouldContainSubstring, "got unexpected response code from /nowhere: 403")
+
+			var statusErr *APIStatusError
+			So(errors.As(err, &statusErr), ShouldBeTrue)
+			So(statusErr.StatusCode, ShouldEqual, 403)
 			So(body, ShouldBeEmpty)
 		})
 
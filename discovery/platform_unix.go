@@ -0,0 +1,7 @@
+//go:build !windows
+// +build !windows
+
+package discovery
+
+// SupportedMethods are the discovery methods that work on this platform
+var SupportedMethods = []string{SourceDocker, SourceStatic, SourceCompose, SourceK8sAPI, SourceDev}
@@ -0,0 +1,6 @@
+package discovery
+
+// SupportedMethods are the discovery methods that work on this platform. The
+// Docker client doesn't build on Windows, so that rules out "docker" and
+// "compose".
+var SupportedMethods = []string{SourceStatic, SourceK8sAPI, SourceDev}
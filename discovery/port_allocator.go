@@ -0,0 +1,326 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// A PortAssignment is a ServicePort handed out to one port of a service
+type PortAssignment struct {
+	Service       string
+	ContainerPort int64
+	ServicePort   int64
+}
+
+// A PortAllocator hands out ServicePorts from a pool to the services that
+// ask for one with service.AutoServicePort(). Each service name and
+// container port keeps the same ServicePort for as long as the assignment
+// is around, and the assignments are saved to File so they survive
+// restarts. When another node already allocated one for the same service and
+// container port, we use that one, so every instance gets the same
+// ServicePort. Ports that Taken reports as used by another service are
+// skipped, so allocations don't collide with the rest of the cluster.
+type PortAllocator struct {
+	Pool PortRanges
+	File string // Where the assignments are kept, empty to keep them in memory
+
+	// Returns the ServicePorts in use in the cluster, and who has them. May
+	// be nil.
+	Taken func() map[int64]string
+
+	// Returns the ServicePorts other nodes allocated, by service name and
+	// container port. May be nil.
+	Allocated func() map[string]map[int64]int64
+
+	lock        sync.Mutex
+	assignments map[string]int64 // ServicePorts by assignmentKey()
+}
+
+// NewPortAllocator returns a PortAllocator for the pool, with any
+// assignments that were saved to the file
+func NewPortAllocator(pool PortRanges, file string) (*PortAllocator, error) {
+	if len(pool) == 0 {
+		return nil, fmt.Errorf("can't allocate ServicePorts from an empty pool")
+	}
+
+	allocator := &PortAllocator{
+		Pool:        pool,
+		File:        file,
+		assignments: make(map[string]int64),
+	}
+
+	if file == "" {
+		return allocator, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return allocator, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ServicePort assignments: %w", err)
+	}
+
+	var saved []PortAssignment
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode ServicePort assignments from %s: %w", file, err)
+	}
+
+	for _, assignment := range saved {
+		// The pool may have changed since these were made
+		if !pool.Allows(assignment.ServicePort) {
+			log.Warnf("Dropping ServicePort %d for %s, it's no longer in the pool %s",
+				assignment.ServicePort, assignment.Service, pool,
+			)
+			continue
+		}
+		allocator.assignments[assignmentKey(assignment.Service, assignment.ContainerPort)] = assignment.ServicePort
+	}
+
+	return allocator, nil
+}
+
+// Allocate replaces the auto ServicePorts of the services with ones from the
+// pool. A nil PortAllocator leaves those ports without a ServicePort.
+func (a *PortAllocator) Allocate(services []service.Service) {
+	if a == nil {
+		clearAutoPorts(services)
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var taken map[int64]string
+	if a.Taken != nil {
+		taken = a.Taken()
+	}
+
+	var allocated map[string]map[int64]int64
+	if a.Allocated != nil {
+		allocated = a.Allocated()
+	}
+
+	var changed bool
+	for i := range services {
+		svc := &services[i]
+		if !hasAutoPorts(svc) {
+			continue
+		}
+
+		svc.Ports = copyPorts(svc.Ports)
+		for j := range svc.Ports {
+			port := &svc.Ports[j]
+			if port.ServicePort >= 0 {
+				continue
+			}
+
+			containerPort := 0 - port.ServicePort
+			servicePort, isNew := a.assign(svc.Name, containerPort, taken, allocated[svc.Name][containerPort])
+			if servicePort == 0 {
+				conflictLogs.Warnf("service-port-pool:"+svc.Name,
+					"Unable to allocate a ServicePort for %s (%s), the pool %s is full",
+					svc.Name, svc.ID, a.Pool,
+				)
+			}
+			port.ServicePort = servicePort
+			if servicePort != 0 {
+				port.ContainerPort = containerPort
+			}
+			changed = changed || isNew
+		}
+	}
+
+	metrics.SetGauge([]string{"discovery", "allocated_service_ports"}, float32(len(a.assignments)))
+
+	if changed {
+		err := a.save()
+		if err != nil {
+			log.Errorf("Unable to save ServicePort assignments: %s", err)
+		}
+	}
+}
+
+// assign returns the ServicePort for a service's port, and whether it was
+// just allocated. The one the cluster already has for it, if any, wins over a
+// new one. Returns zero when the pool is full.
+func (a *PortAllocator) assign(name string, containerPort int64, taken map[int64]string,
+	existing int64) (int64, bool) {
+
+	key := assignmentKey(name, containerPort)
+	if servicePort, ok := a.assignments[key]; ok {
+		return servicePort, false
+	}
+
+	used := make(map[int64]bool, len(a.assignments))
+	for _, servicePort := range a.assignments {
+		used[servicePort] = true
+	}
+
+	if existing != 0 && a.Pool.Allows(existing) && !used[existing] {
+		if owner, ok := taken[existing]; !ok || owner == name {
+			a.assignments[key] = existing
+			log.Infof("Using ServicePort %d for %s port %d, as allocated elsewhere in the cluster",
+				existing, name, containerPort,
+			)
+			return existing, true
+		}
+	}
+
+	// Start somewhere that depends on the name, so that nodes allocating
+	// for different services at the same time are unlikely to collide
+	size := a.poolSize()
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	start := int64(hash.Sum64() % uint64(size))
+
+	for i := int64(0); i < size; i++ {
+		servicePort := a.portAt((start + i) % size)
+		if used[servicePort] {
+			continue
+		}
+		if owner, ok := taken[servicePort]; ok && owner != name {
+			continue
+		}
+
+		a.assignments[key] = servicePort
+		log.Infof("Allocated ServicePort %d to %s port %d", servicePort, name, containerPort)
+		return servicePort, true
+	}
+
+	return 0, false
+}
+
+// Assignments returns the ServicePorts that have been handed out, sorted by
+// service and container port
+func (a *PortAllocator) Assignments() []PortAssignment {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.assignmentList()
+}
+
+func (a *PortAllocator) assignmentList() []PortAssignment {
+	list := make([]PortAssignment, 0, len(a.assignments))
+	for key, servicePort := range a.assignments {
+		name, containerPort := splitAssignmentKey(key)
+		list = append(list, PortAssignment{
+			Service: name, ContainerPort: containerPort, ServicePort: servicePort,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].ContainerPort < list[j].ContainerPort
+	})
+
+	return list
+}
+
+// save writes the assignments to a temporary file and moves it into place,
+// so a crash can't leave a half written file behind
+func (a *PortAllocator) save() error {
+	if a.File == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(a.assignmentList(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(a.File), filepath.Base(a.File)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), a.File)
+}
+
+func (a *PortAllocator) poolSize() int64 {
+	var size int64
+	for _, portRange := range a.Pool {
+		size += portRange.Max - portRange.Min + 1
+	}
+	return size
+}
+
+// portAt returns the nth port of the pool
+func (a *PortAllocator) portAt(n int64) int64 {
+	for _, portRange := range a.Pool {
+		if n <= portRange.Max-portRange.Min {
+			return portRange.Min + n
+		}
+		n -= portRange.Max - portRange.Min + 1
+	}
+	return 0
+}
+
+func assignmentKey(name string, containerPort int64) string {
+	return name + ":" + strconv.FormatInt(containerPort, 10)
+}
+
+func splitAssignmentKey(key string) (string, int64) {
+	idx := strings.LastIndex(key, ":")
+	containerPort, _ := strconv.ParseInt(key[idx+1:], 10, 64)
+	return key[:idx], containerPort
+}
+
+// clearAutoPorts leaves ports that asked for a ServicePort without one, when
+// there's no pool to allocate from
+func clearAutoPorts(services []service.Service) {
+	for i := range services {
+		if !hasAutoPorts(&services[i]) {
+			continue
+		}
+
+		services[i].Ports = copyPorts(services[i].Ports)
+		for j := range services[i].Ports {
+			if services[i].Ports[j].ServicePort < 0 {
+				conflictLogs.Warnf("service-port-pool:"+services[i].Name,
+					"%s (%s) asked for a ServicePort, but there's no pool to allocate from",
+					services[i].Name, services[i].ID,
+				)
+				services[i].Ports[j].ServicePort = 0
+			}
+		}
+	}
+}
+
+func hasAutoPorts(svc *service.Service) bool {
+	for _, port := range svc.Ports {
+		if port.ServicePort < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// copyPorts lets us change the ports without touching the discoverer's copy
+func copyPorts(ports []service.Port) []service.Port {
+	return append([]service.Port(nil), ports...)
+}
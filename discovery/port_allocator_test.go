@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PortAllocator(t *testing.T) {
+	Convey("PortAllocator", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-ports")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		file := filepath.Join(dir, "service-ports.json")
+		pool := PortRanges{{Min: 9000, Max: 9001}, {Min: 9100, Max: 9100}}
+
+		allocator, err := NewPortAllocator(pool, file)
+		So(err, ShouldBeNil)
+
+		newService := func(id string, name string) service.Service {
+			return service.Service{
+				ID: id, Name: name, Hostname: "heorot",
+				Ports: []service.Port{
+					{Type: "tcp", Port: 32768, ServicePort: service.AutoServicePort(8080)},
+					{Type: "tcp", Port: 32769, ServicePort: 10000},
+				},
+			}
+		}
+
+		Convey("hands out ports from the pool", func() {
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			servicePort := services[0].Ports[0].ServicePort
+			So(pool.Allows(servicePort), ShouldBeTrue)
+			So(services[0].Ports[1].ServicePort, ShouldEqual, 10000)
+			So(allocator.Assignments(), ShouldResemble, []PortAssignment{
+				{Service: "beowulf", ContainerPort: 8080, ServicePort: servicePort},
+			})
+		})
+
+		Convey("keeps the same port for every instance of a service", func() {
+			first := []service.Service{newService("deadbeef0001", "beowulf")}
+			second := []service.Service{newService("deadbeef0002", "beowulf")}
+			allocator.Allocate(first)
+			allocator.Allocate(second)
+
+			So(second[0].Ports[0].ServicePort, ShouldEqual, first[0].Ports[0].ServicePort)
+			So(len(allocator.Assignments()), ShouldEqual, 1)
+		})
+
+		Convey("gives each service its own port", func() {
+			services := []service.Service{
+				newService("deadbeef0001", "beowulf"),
+				newService("deadbeef0002", "grendel"),
+				newService("deadbeef0003", "wiglaf"),
+			}
+			allocator.Allocate(services)
+
+			seen := make(map[int64]bool)
+			for _, svc := range services {
+				So(seen[svc.Ports[0].ServicePort], ShouldBeFalse)
+				seen[svc.Ports[0].ServicePort] = true
+			}
+			So(seen, ShouldResemble, map[int64]bool{9000: true, 9001: true, 9100: true})
+		})
+
+		Convey("skips ports that other services have in the cluster", func() {
+			allocator.Taken = func() map[int64]string {
+				return map[int64]string{9000: "hrothgar", 9001: "hrothgar"}
+			}
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			So(services[0].Ports[0].ServicePort, ShouldEqual, 9100)
+		})
+
+		Convey("uses the port the cluster already has for the service", func() {
+			allocator.Taken = func() map[int64]string {
+				return map[int64]string{9100: "beowulf"}
+			}
+			allocator.Allocated = func() map[string]map[int64]int64 {
+				return map[string]map[int64]int64{"beowulf": {8080: 9100}}
+			}
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			So(services[0].Ports[0].ServicePort, ShouldEqual, 9100)
+			So(services[0].Ports[0].ContainerPort, ShouldEqual, 8080)
+			So(allocator.Assignments(), ShouldResemble, []PortAssignment{
+				{Service: "beowulf", ContainerPort: 8080, ServicePort: 9100},
+			})
+		})
+
+		Convey("doesn't use the cluster's port when another service has it", func() {
+			allocator.Taken = func() map[int64]string {
+				return map[int64]string{9100: "grendel"}
+			}
+			allocator.Allocated = func() map[string]map[int64]int64 {
+				return map[string]map[int64]int64{"beowulf": {8080: 9100}}
+			}
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			So(services[0].Ports[0].ServicePort, ShouldNotEqual, 9100)
+			So(pool.Allows(services[0].Ports[0].ServicePort), ShouldBeTrue)
+		})
+
+		Convey("leaves the port unpublished when the pool is full", func() {
+			services := []service.Service{
+				newService("deadbeef0001", "beowulf"),
+				newService("deadbeef0002", "grendel"),
+				newService("deadbeef0003", "wiglaf"),
+				newService("deadbeef0004", "hrothgar"),
+			}
+			allocator.Allocate(services)
+
+			So(services[3].Ports[0].ServicePort, ShouldEqual, 0)
+		})
+
+		Convey("doesn't change the discoverer's copy of the ports", func() {
+			original := newService("deadbeef0001", "beowulf")
+			services := []service.Service{original}
+			allocator.Allocate(services)
+
+			So(original.Ports[0].ServicePort, ShouldEqual, service.AutoServicePort(8080))
+		})
+
+		Convey("remembers the assignments across restarts", func() {
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			reloaded, err := NewPortAllocator(pool, file)
+			So(err, ShouldBeNil)
+			So(reloaded.Assignments(), ShouldResemble, allocator.Assignments())
+		})
+
+		Convey("drops saved assignments that are no longer in the pool", func() {
+			services := []service.Service{newService("deadbeef0001", "beowulf")}
+			allocator.Allocate(services)
+
+			reloaded, err := NewPortAllocator(PortRanges{{Min: 9500, Max: 9500}}, file)
+			So(err, ShouldBeNil)
+			So(reloaded.Assignments(), ShouldBeEmpty)
+		})
+
+		Convey("returns an error for a corrupt file", func() {
+			So(ioutil.WriteFile(file, []byte("junk"), 0644), ShouldBeNil)
+
+			_, err := NewPortAllocator(pool, file)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("requires a pool", func() {
+			_, err := NewPortAllocator(nil, file)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is used by MultiDiscovery", func() {
+			disco := &mockDiscoverer{ServicesList: []service.Service{newService("deadbeef0001", "beowulf")}}
+			multi := &MultiDiscovery{Discoverers: []Discoverer{disco}}
+
+			Convey("which clears auto ports without one", func() {
+				So(multi.Services()[0].Ports[0].ServicePort, ShouldEqual, 0)
+			})
+
+			Convey("which allocates them with one", func() {
+				multi.PortAllocator = allocator
+				So(pool.Allows(multi.Services()[0].Ports[0].ServicePort), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+// A PortRange is an inclusive range of ServicePorts
+type PortRange struct {
+	Min int64
+	Max int64
+}
+
+func (r PortRange) String() string {
+	if r.Min == r.Max {
+		return strconv.FormatInt(r.Min, 10)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// PortRanges are the ServicePorts that services may announce. An empty set
+// allows them all.
+type PortRanges []PortRange
+
+// ParsePortRanges reads entries like "8000-8999" or "443". An empty list
+// returns nil, meaning any port is allowed.
+func ParsePortRanges(entries []string) (PortRanges, error) {
+	var ranges PortRanges
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		minStr, maxStr := entry, entry
+		if idx := strings.Index(entry, "-"); idx >= 0 {
+			minStr, maxStr = entry[:idx], entry[idx+1:]
+		}
+
+		min, errMin := strconv.ParseInt(strings.TrimSpace(minStr), 10, 64)
+		max, errMax := strconv.ParseInt(strings.TrimSpace(maxStr), 10, 64)
+		if errMin != nil || errMax != nil || min < 1 || max > 65535 || min > max {
+			return nil, fmt.Errorf("invalid service port range %q", entry)
+		}
+
+		ranges = append(ranges, PortRange{Min: min, Max: max})
+	}
+
+	return ranges, nil
+}
+
+// Allows returns true when the port is in one of the ranges, or when there
+// are no ranges at all
+func (r PortRanges) Allows(port int64) bool {
+	if len(r) == 0 {
+		return true
+	}
+
+	for _, portRange := range r {
+		if port >= portRange.Min && port <= portRange.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PortRanges) String() string {
+	parts := make([]string, 0, len(r))
+	for _, portRange := range r {
+		parts = append(parts, portRange.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// rejectServicePorts drops services that announce a ServicePort outside the
+// allowed ranges, so that a mislabeled container can't take over a port
+// that the proxies on every host serve for something else. Ports without a
+// ServicePort aren't published on the proxies, so they're always allowed.
+// Also returns how many instances were dropped, by service name.
+func rejectServicePorts(services []service.Service, allowed PortRanges) ([]service.Service, map[string]int) {
+	rejected := make(map[string]int)
+	if len(allowed) == 0 {
+		return services, rejected
+	}
+
+	kept := services[:0]
+
+	for _, svc := range services {
+		badPort := disallowedServicePort(&svc, allowed)
+		if badPort == 0 {
+			kept = append(kept, svc)
+			continue
+		}
+
+		rejected[svc.Name]++
+		conflictLogs.Warnf("service-port:"+svc.Hostname+":"+svc.ID,
+			"Rejecting %s (%s) from %s: ServicePort %d is outside the allowed ranges %s",
+			svc.Name, svc.ID, sourceName(&svc), badPort, allowed,
+		)
+	}
+
+	return kept, rejected
+}
+
+// reportRejected publishes the rejected instance counts, tagged with the
+// service name. Services that were rejected before and aren't any more go
+// back to zero, rather than keeping their last count. Note: not synchronized!
+func (d *MultiDiscovery) reportRejected(rejected map[string]int) {
+	for name := range d.rejectedNames {
+		if rejected[name] == 0 {
+			metrics.SetGaugeWithLabels([]string{"discovery", "rejected_service_ports"}, 0,
+				[]metrics.Label{{Name: "service", Value: name}},
+			)
+		}
+	}
+
+	d.rejectedNames = make(map[string]bool, len(rejected))
+	for name, count := range rejected {
+		d.rejectedNames[name] = true
+		metrics.SetGaugeWithLabels([]string{"discovery", "rejected_service_ports"}, float32(count),
+			[]metrics.Label{{Name: "service", Value: name}},
+		)
+	}
+}
+
+// disallowedServicePort returns the first ServicePort that isn't allowed, or
+// zero when they all are
+func disallowedServicePort(svc *service.Service, allowed PortRanges) int64 {
+	for _, port := range svc.Ports {
+		if port.ServicePort != 0 && !allowed.Allows(port.ServicePort) {
+			return port.ServicePort
+		}
+	}
+	return 0
+}
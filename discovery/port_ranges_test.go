@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ParsePortRanges(t *testing.T) {
+	Convey("ParsePortRanges()", t, func() {
+		Convey("parses ranges and single ports", func() {
+			ranges, err := ParsePortRanges([]string{"8000-8999", " 10443 "})
+
+			So(err, ShouldBeNil)
+			So(ranges, ShouldResemble, PortRanges{{8000, 8999}, {10443, 10443}})
+			So(ranges.String(), ShouldEqual, "8000-8999,10443")
+		})
+
+		Convey("returns nil for no ranges", func() {
+			ranges, err := ParsePortRanges(nil)
+
+			So(err, ShouldBeNil)
+			So(ranges, ShouldBeNil)
+			So(ranges.Allows(443), ShouldBeTrue)
+		})
+
+		Convey("rejects bad ranges", func() {
+			for _, entry := range []string{"junk", "9000-8000", "0-10", "1-70000", "80-"} {
+				_, err := ParsePortRanges([]string{entry})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_AllowedServicePorts(t *testing.T) {
+	Convey("Restricting the announced ServicePorts", t, func() {
+		allowed := service.Service{
+			ID: "deadbeef0001", Name: "beowulf", Hostname: "heorot",
+			Ports: []service.Port{{Type: "tcp", Port: 10234, ServicePort: 8080}},
+		}
+		squatter := service.Service{
+			ID: "deadbeef0002", Name: "grendel", Hostname: "heorot",
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10235, ServicePort: 8081},
+				{Type: "tcp", Port: 10236, ServicePort: 443},
+			},
+		}
+		unpublished := service.Service{
+			ID: "deadbeef0003", Name: "wiglaf", Hostname: "heorot",
+			Ports: []service.Port{{Type: "tcp", Port: 10237}},
+		}
+
+		disco := &mockDiscoverer{ServicesList: []service.Service{allowed, squatter, unpublished}}
+		multi := &MultiDiscovery{Discoverers: []Discoverer{disco}}
+
+		Convey("keeps everything without any ranges", func() {
+			So(len(multi.Services()), ShouldEqual, 3)
+		})
+
+		Convey("drops services announcing ports outside the ranges", func() {
+			multi.AllowedServicePorts = PortRanges{{8000, 8999}}
+			services := multi.Services()
+
+			So(len(services), ShouldEqual, 2)
+			So(services[0].ID, ShouldEqual, "deadbeef0001")
+			So(services[1].ID, ShouldEqual, "deadbeef0003")
+		})
+	})
+}
@@ -1,17 +1,22 @@
 package discovery
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/service"
 )
 
@@ -19,21 +24,40 @@ type Target struct {
 	Service    service.Service
 	Check      StaticCheck
 	ListenPort int64
+	TTL        string `json:",omitempty"` // How long the registration lasts without a refresh, e.g. "24h"
+
+	ttl    time.Duration
+	autoID bool // The ID came from the IDStrategy, not the file
 }
 
 // A StaticDiscovery is an instance of a configuration file based discovery
-// mechanism. It is read on startup and never again, currently, so there
-// is no need for any locking or synchronization mechanism.
+// mechanism. It is read on startup, and again whenever its modification
+// time changes. Targets with a TTL are only announced for that long after
+// the file was last loaded, so touching or rewriting the file refreshes
+// them. That way registrations nobody looks after don't stay ALIVE forever.
 type StaticDiscovery struct {
 	Targets    []*Target
 	ConfigFile string
 	Hostname   string
 	DefaultIP  string
+	IDStrategy IDStrategy    // Used for targets without an ID, defaults to random
+	DefaultTTL time.Duration // For targets without a TTL, 0 to announce them forever
+	Clock      clock.Clock   // Where we get the time from, nil for the wall clock
+
+	modTime time.Time       // The modification time of the file when we last read it
+	loaded  time.Time       // When we last loaded the file, which refreshes the TTLs
+	expired map[string]bool // Targets whose registration has run out, by service ID
+	sync.Mutex
 }
 
 type StaticCheck struct {
-	Type string
-	Args string
+	Type        string
+	Args        string
+	StatusCodes string `json:",omitempty"`
+	BodyMatch   string `json:",omitempty"`
+	MaxLatency  string `json:",omitempty"`
+	DependsOn   string `json:",omitempty"`
+	Policy      string `json:",omitempty"`
 }
 
 func NewStaticDiscovery(filename string, defaultIP string) *StaticDiscovery {
@@ -45,10 +69,15 @@ func NewStaticDiscovery(filename string, defaultIP string) *StaticDiscovery {
 		ConfigFile: filename,
 		Hostname:   hostname,
 		DefaultIP:  defaultIP,
+		IDStrategy: &RandomIDStrategy{},
+		expired:    make(map[string]bool),
 	}
 }
 
 func (d *StaticDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	d.Lock()
+	defer d.Unlock()
+
 	for _, target := range d.Targets {
 		if svc.ID == target.Service.ID {
 			return target.Check.Type, target.Check.Args
@@ -57,12 +86,42 @@ func (d *StaticDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+// HealthCheckOptions returns the extra check settings for the target
+func (d *StaticDiscovery) HealthCheckOptions(svc *service.Service) CheckOptions {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, target := range d.Targets {
+		if svc.ID == target.Service.ID {
+			return CheckOptions{
+				StatusCodes: target.Check.StatusCodes,
+				BodyMatch:   target.Check.BodyMatch,
+				MaxLatency:  target.Check.MaxLatency,
+				DependsOn:   target.Check.DependsOn,
+				Policy:      target.Check.Policy,
+			}
+		}
+	}
+	return CheckOptions{}
+}
+
 // Returns the list of services derived from the targets that were parsed
-// out of the config file.
+// out of the config file, leaving out those whose registration has expired.
+// Sidecar then tombstones them like any other service that has gone away.
+// Loads the file again first if it has changed.
 func (d *StaticDiscovery) Services() []service.Service {
+	d.reloadIfChanged()
+	now := d.now()
+
+	d.Lock()
+	defer d.Unlock()
+
 	var services []service.Service
 	for _, target := range d.Targets {
-		target.Service.Updated = time.Now().UTC()
+		if d.hasExpired(target, now) {
+			continue
+		}
+		target.Service.Updated = now
 		services = append(services, target.Service)
 	}
 	return services
@@ -70,9 +129,14 @@ func (d *StaticDiscovery) Services() []service.Service {
 
 // Listeners returns the list of services configured to be ChangeEvent listeners
 func (d *StaticDiscovery) Listeners() []ChangeListener {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.now()
+
 	var listeners []ChangeListener
 	for _, target := range d.Targets {
-		if target.ListenPort > 0 {
+		if target.ListenPort > 0 && !d.hasExpired(target, now) {
 			listener := ChangeListener{
 				Name: target.Service.ListenerName(),
 				Url:  fmt.Sprintf("http://%s:%d/sidecar/update", d.Hostname, target.ListenPort),
@@ -83,21 +147,170 @@ func (d *StaticDiscovery) Listeners() []ChangeListener {
 	return listeners
 }
 
-// Causes the configuration to be parsed and loaded. There is no background
-// processing needed on an ongoing basis.
-func (d *StaticDiscovery) Run(looper director.Looper) {
-	var err error
+// Causes the configuration to be parsed and loaded. Services() picks up
+// later changes to the file, so there is no background processing needed on
+// an ongoing basis, and the context is not used.
+func (d *StaticDiscovery) Run(_ context.Context, looper director.Looper) {
+	info, err := os.Stat(d.ConfigFile)
+	if err == nil {
+		err = d.load(info.ModTime())
+	}
 
-	d.Targets, err = d.ParseConfig(d.ConfigFile)
 	if err != nil {
 		log.Errorf("StaticDiscovery cannot parse: %s", err.Error())
 		looper.Done(nil)
 	}
 }
 
-// Parses a JSON config file containing an array of Targets. These are
-// then augmented with a random hex ID and stamped with the current
-// UTC time as the creation time. The same hex ID is applied to the Check
+// reloadIfChanged loads the config file again when its modification time is
+// not the one we last read. When it can't be read or parsed, we keep
+// announcing the targets we have, and their TTLs keep running. When the file
+// has gone, or Run() never read it, nothing changes.
+func (d *StaticDiscovery) reloadIfChanged() {
+	info, err := os.Stat(d.ConfigFile)
+	if err != nil {
+		return
+	}
+
+	d.Lock()
+	changed := !d.modTime.IsZero() && !info.ModTime().Equal(d.modTime)
+	d.Unlock()
+
+	if !changed {
+		return
+	}
+
+	err = d.load(info.ModTime())
+	if err != nil {
+		metrics.IncrCounter([]string{"discovery", "static", "load_errors"}, 1)
+		log.Errorf("Unable to reload %s, keeping the targets we have: %s", d.ConfigFile, err)
+		return
+	}
+
+	log.Infof("Reloaded %s", d.ConfigFile)
+}
+
+// load parses the config file, last modified at modTime, and replaces the
+// targets with the ones in it. That refreshes their TTLs.
+func (d *StaticDiscovery) load(modTime time.Time) error {
+	targets, err := d.ParseConfig(d.ConfigFile)
+
+	d.Lock()
+	defer d.Unlock()
+
+	// Don't read a broken file again until it changes
+	d.modTime = modTime
+	if err != nil {
+		return err
+	}
+
+	d.keepIDs(targets)
+	d.Targets = targets
+	d.loaded = d.now()
+
+	// Forget the expiry of targets that are gone from the file
+	ids := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		ids[target.Service.ID] = true
+	}
+	for id := range d.expired {
+		if !ids[id] {
+			delete(d.expired, id)
+		}
+	}
+	metrics.SetGauge([]string{"discovery", "static", "expired_registrations"}, float32(len(d.expired)))
+
+	return nil
+}
+
+// keepIDs gives the reloaded targets that don't have an ID in the file the
+// ones they had before, matching them up by service name in the order of the
+// file. Otherwise, with random IDs, every reload would replace the services.
+// Expects the lock to be held.
+func (d *StaticDiscovery) keepIDs(targets []*Target) {
+	previous := make(map[string][]*Target)
+	for _, target := range d.Targets {
+		if target.autoID {
+			previous[target.Service.Name] = append(previous[target.Service.Name], target)
+		}
+	}
+
+	for _, target := range targets {
+		old := previous[target.Service.Name]
+		if !target.autoID || len(old) == 0 {
+			continue
+		}
+
+		target.Service.ID = old[0].Service.ID
+		target.Service.Created = old[0].Service.Created
+		previous[target.Service.Name] = old[1:]
+	}
+}
+
+// Expired returns the IDs of the targets whose registration has run out,
+// sorted
+func (d *StaticDiscovery) Expired() []string {
+	d.Lock()
+	defer d.Unlock()
+
+	var ids []string
+	for id := range d.expired {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (d *StaticDiscovery) now() time.Time {
+	if d.Clock == nil {
+		return clock.Real.Now()
+	}
+	return d.Clock.Now()
+}
+
+// hasExpired tells whether the target's registration has run out, and says
+// so when that changes. Expects the lock to be held.
+func (d *StaticDiscovery) hasExpired(target *Target, now time.Time) bool {
+	ttl := target.ttl
+	if ttl == 0 {
+		ttl = d.DefaultTTL
+	}
+	if ttl <= 0 || d.loaded.IsZero() {
+		return false
+	}
+
+	if d.expired == nil {
+		d.expired = make(map[string]bool)
+	}
+
+	id := target.Service.ID
+	expired := now.Sub(d.loaded) > ttl
+	if expired == d.expired[id] {
+		return expired
+	}
+
+	defer func() {
+		metrics.SetGauge([]string{"discovery", "static", "expired_registrations"}, float32(len(d.expired)))
+	}()
+
+	if expired {
+		d.expired[id] = true
+		metrics.IncrCounter([]string{"discovery", "static", "expired"}, 1)
+		log.Errorf("Static registration of %s (%s) expired: %s wasn't refreshed within its TTL of %s",
+			target.Service.Name, id, d.ConfigFile, ttl,
+		)
+		return true
+	}
+
+	delete(d.expired, id)
+	log.Infof("Static registration of %s (%s) was refreshed", target.Service.Name, id)
+	return false
+}
+
+// Parses a JSON config file containing an array of Targets. Any without an
+// ID are then given one by the IDStrategy, and all are stamped with the
+// current UTC time as the creation time. The same ID is applied to the Check
 // and the Service to make sure that they are matched by the healthy
 // package later on.
 func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
@@ -110,19 +323,25 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 	var targets []*Target
 	err = json.Unmarshal(file, &targets)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to unmarshal Target: %s", err)
+		return nil, fmt.Errorf("Unable to unmarshal Target: %w", err)
+	}
+
+	idStrategy := d.IDStrategy
+	if idStrategy == nil {
+		idStrategy = &RandomIDStrategy{}
 	}
 
 	// Have to loop with traditional 'for' loop so we can modify entries
 	for _, target := range targets {
-		idBytes, err := RandomHex(6)
-		if err != nil {
-			log.Errorf("ParseConfig(): Unable to get random bytes (%s)", err.Error())
-			return nil, err
+		if target.TTL != "" {
+			target.ttl, err = time.ParseDuration(target.TTL)
+			if err != nil || target.ttl <= 0 {
+				return nil, fmt.Errorf("Invalid TTL %q for %s", target.TTL, target.Service.Name)
+			}
 		}
 
-		target.Service.ID = string(idBytes)
 		target.Service.Created = time.Now().UTC()
+		target.Service.Source = SourceStatic
 		// We _can_ export services for a 3rd party. If we don't specify
 		// the hostname, then it's for this host.
 		if target.Service.Hostname == "" {
@@ -136,6 +355,16 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 			}
 		}
 
+		// IDs provided in the config file are kept as they are
+		if target.Service.ID == "" {
+			target.autoID = true
+			target.Service.ID, err = idStrategy.ServiceID(&target.Service)
+			if err != nil {
+				log.Errorf("ParseConfig(): Unable to generate a service ID (%s)", err.Error())
+				return nil, err
+			}
+		}
+
 		log.Printf("Discovered service: %s, ID: %s",
 			target.Service.Name,
 			target.Service.ID,
@@ -1,9 +1,14 @@
 package discovery
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
@@ -12,6 +17,7 @@ import (
 const (
 	STATIC_JSON           = "../fixtures/static.json"
 	STATIC_HOSTNAMED_JSON = "../fixtures/static-hostnamed.json"
+	STATIC_WITH_ID_JSON   = "../fixtures/static-with-id.json"
 )
 
 func Test_ParseConfig(t *testing.T) {
@@ -39,6 +45,12 @@ func Test_ParseConfig(t *testing.T) {
 			So(parsed[0].Service.Hostname, ShouldEqual, hostname)
 		})
 
+		Convey("Marks services as coming from static discovery", func() {
+			parsed, err := disco.ParseConfig(STATIC_JSON)
+			So(err, ShouldBeNil)
+			So(parsed[0].Service.Source, ShouldEqual, SourceStatic)
+		})
+
 		Convey("Uses the given hostname when specified", func() {
 			parsed, _ := disco.ParseConfig(STATIC_HOSTNAMED_JSON)
 			So(len(parsed), ShouldEqual, 1)
@@ -50,6 +62,37 @@ func Test_ParseConfig(t *testing.T) {
 			So(len(parsed), ShouldEqual, 1)
 			So(parsed[0].Service.Ports[0].IP, ShouldEqual, ip)
 		})
+
+		Convey("Generates random IDs by default", func() {
+			first, _ := disco.ParseConfig(STATIC_JSON)
+			second, _ := disco.ParseConfig(STATIC_JSON)
+			So(first[0].Service.ID, ShouldNotBeEmpty)
+			So(first[0].Service.ID, ShouldNotEqual, second[0].Service.ID)
+		})
+
+		Convey("Generates stable IDs with the HashIDStrategy", func() {
+			disco.IDStrategy = &HashIDStrategy{}
+			first, _ := disco.ParseConfig(STATIC_JSON)
+			second, _ := disco.ParseConfig(STATIC_JSON)
+			So(first[0].Service.ID, ShouldNotBeEmpty)
+			So(first[0].Service.ID, ShouldEqual, second[0].Service.ID)
+		})
+
+		Convey("Keeps the ID when one is provided", func() {
+			parsed, err := disco.ParseConfig(STATIC_WITH_ID_JSON)
+			So(err, ShouldBeNil)
+			So(parsed[0].Service.ID, ShouldEqual, "beowulf01")
+		})
+
+		Convey("Returns the check options for a target", func() {
+			parsed, err := disco.ParseConfig(STATIC_WITH_ID_JSON)
+			So(err, ShouldBeNil)
+			disco.Targets = parsed
+
+			opts := disco.HealthCheckOptions(&parsed[0].Service)
+			So(opts.StatusCodes, ShouldEqual, "200,204")
+			So(opts.BodyMatch, ShouldBeEmpty)
+		})
 	})
 }
 
@@ -91,7 +134,7 @@ func Test_Listeners(t *testing.T) {
 		disco := NewStaticDiscovery(STATIC_JSON, ip)
 
 		Convey("Loads targets from the config", func() {
-			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+			disco.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 			So(len(disco.Targets), ShouldEqual, 1)
 		})
 
@@ -132,8 +175,136 @@ func Test_Run(t *testing.T) {
 
 		Convey("Parses the specified config file", func() {
 			So(len(disco.Targets), ShouldEqual, 0)
-			disco.Run(looper)
+			disco.Run(context.Background(), looper)
 			So(len(disco.Targets), ShouldEqual, 1)
 		})
 	})
 }
+
+func Test_RegistrationTTL(t *testing.T) {
+	Convey("Registration TTLs", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-static")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		configFile := filepath.Join(dir, "static.json")
+		writeConfig := func(ttl string) {
+			contents := `[{"Service": {"Name": "beowulf", "ID": "beowulf01"}, "ListenPort": 9999, "TTL": "` + ttl + `"}]`
+			So(ioutil.WriteFile(configFile, []byte(contents), 0644), ShouldBeNil)
+		}
+		touch := func(by time.Duration) {
+			then := time.Now().Add(by)
+			So(os.Chtimes(configFile, then, then), ShouldBeNil)
+		}
+
+		frozen := clock.NewFrozen(time.Now().UTC())
+		disco := NewStaticDiscovery(configFile, "127.0.0.1")
+		disco.Clock = frozen
+		looper := director.NewFreeLooper(director.ONCE, nil)
+
+		Convey("announces targets until they were loaded longer ago than their TTL", func() {
+			writeConfig("1h")
+			disco.Run(context.Background(), looper)
+			So(len(disco.Services()), ShouldEqual, 1)
+			So(disco.Expired(), ShouldBeEmpty)
+
+			frozen.Advance(2 * time.Hour)
+			So(disco.Services(), ShouldBeEmpty)
+			So(disco.Listeners(), ShouldBeEmpty)
+			So(disco.Expired(), ShouldResemble, []string{"beowulf01"})
+
+			Convey("and again once the file is touched", func() {
+				touch(time.Minute)
+				So(len(disco.Services()), ShouldEqual, 1)
+				So(len(disco.Listeners()), ShouldEqual, 1)
+				So(disco.Expired(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("doesn't go by the file's modification time", func() {
+			writeConfig("1h")
+			touch(-2 * time.Hour)
+			disco.Run(context.Background(), looper)
+
+			So(len(disco.Services()), ShouldEqual, 1)
+		})
+
+		Convey("uses the DefaultTTL for targets without one", func() {
+			So(ioutil.WriteFile(configFile, []byte(`[{"Service": {"Name": "beowulf"}}]`), 0644), ShouldBeNil)
+			disco.DefaultTTL = time.Hour
+			disco.Run(context.Background(), looper)
+			frozen.Advance(2 * time.Hour)
+
+			So(disco.Services(), ShouldBeEmpty)
+		})
+
+		Convey("announces targets forever without a TTL", func() {
+			So(ioutil.WriteFile(configFile, []byte(`[{"Service": {"Name": "beowulf"}}]`), 0644), ShouldBeNil)
+			disco.Run(context.Background(), looper)
+			frozen.Advance(24 * time.Hour)
+
+			So(len(disco.Services()), ShouldEqual, 1)
+		})
+
+		Convey("rejects TTLs that don't parse", func() {
+			writeConfig("soon")
+			_, err := disco.ParseConfig(configFile)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is reported by MultiDiscovery", func() {
+			writeConfig("1h")
+			disco.Run(context.Background(), looper)
+			frozen.Advance(2 * time.Hour)
+			disco.Services()
+
+			multi := &MultiDiscovery{}
+			multi.Add("static", disco)
+			So(multi.BackendStatuses()[0].Expired, ShouldResemble, []string{"beowulf01"})
+		})
+	})
+}
+
+func Test_Reload(t *testing.T) {
+	Convey("Reloading the config file", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-static")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		configFile := filepath.Join(dir, "static.json")
+		writeConfig := func(contents string, by time.Duration) {
+			So(ioutil.WriteFile(configFile, []byte(contents), 0644), ShouldBeNil)
+			then := time.Now().Add(by)
+			So(os.Chtimes(configFile, then, then), ShouldBeNil)
+		}
+
+		disco := NewStaticDiscovery(configFile, "127.0.0.1")
+		looper := director.NewFreeLooper(director.ONCE, nil)
+
+		writeConfig(`[{"Service": {"Name": "beowulf"}}]`, 0)
+		disco.Run(context.Background(), looper)
+		services := disco.Services()
+		So(len(services), ShouldEqual, 1)
+
+		Convey("picks up changes to the file", func() {
+			writeConfig(`[{"Service": {"Name": "beowulf"}}, {"Service": {"Name": "grendel"}}]`, time.Minute)
+
+			reloaded := disco.Services()
+			So(len(reloaded), ShouldEqual, 2)
+			So(reloaded[1].Name, ShouldEqual, "grendel")
+
+			Convey("keeping the IDs that were generated before", func() {
+				So(reloaded[0].ID, ShouldEqual, services[0].ID)
+				So(reloaded[0].Created, ShouldEqual, services[0].Created)
+			})
+		})
+
+		Convey("keeps the targets it has when the file doesn't parse", func() {
+			writeConfig(`[{"Service": `, time.Minute)
+
+			reloaded := disco.Services()
+			So(len(reloaded), ShouldEqual, 1)
+			So(reloaded[0].ID, ShouldEqual, services[0].ID)
+		})
+	})
+}
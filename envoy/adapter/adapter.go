@@ -8,12 +8,12 @@ import (
 	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/logging"
 	"github.com/NinesStack/sidecar/service"
 	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
-	route "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	tcpp "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
@@ -22,7 +22,6 @@ import (
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/golang/protobuf/ptypes/wrappers"
-	log "github.com/sirupsen/logrus"
 	anypb "google.golang.org/protobuf/types/known/anypb"
 )
 
@@ -34,10 +33,21 @@ const (
 
 	// PortCollisionLoggingBackoff is how long we wait between logging about
 	// port collisions.
+	//
+	// Deprecated: port collisions go through the rate-limited logger, which
+	// logs each one once per logging.DEFAULT_LIMIT_INTERVAL.
 	PortCollisionLoggingBackoff = 1 * time.Minute
 )
 
 var (
+	// Problems with a service tend to repeat on every snapshot, so we only
+	// log them now and then
+	logLimiter = logging.NewLimiter(logging.DEFAULT_LIMIT_INTERVAL).WithLogger(logging.Module("envoy"))
+
+	// LastLoggedPortCollision was when we last logged a port collision.
+	//
+	// Deprecated: nothing sets this any more. Port collisions are rate
+	// limited for each service and port rather than all together.
 	LastLoggedPortCollision time.Time
 )
 
@@ -46,6 +56,26 @@ type EnvoyResources struct {
 	Endpoints []cache_types.Resource
 	Clusters  []cache_types.Resource
 	Listeners []cache_types.Resource
+	Routes    []cache_types.Resource
+	Secrets   []cache_types.Resource
+}
+
+// Drained returns a copy of the resources with no endpoints in any cluster.
+// Envoy keeps the listeners and clusters, but stops sending them traffic.
+func (r EnvoyResources) Drained() EnvoyResources {
+	drained := r
+	drained.Endpoints = make([]cache_types.Resource, 0, len(r.Endpoints))
+	for _, resource := range r.Endpoints {
+		assignment, ok := resource.(*api.ClusterLoadAssignment)
+		if !ok {
+			continue
+		}
+		drained.Endpoints = append(drained.Endpoints, &api.ClusterLoadAssignment{
+			ClusterName: assignment.ClusterName,
+		})
+	}
+
+	return drained
 }
 
 // SvcName formats an Envoy service name from our service name and port
@@ -81,6 +111,24 @@ func LookupHost(hostname string) (string, error) {
 	return addrs[0], nil
 }
 
+// EndpointAddress returns the address we send Envoy for a port on the
+// service: its IP, or with useHostnames, what its host resolves to. That
+// lookup is NOT recommended... it's very slow, and only useful in dev modes
+// where you need to resolve to a different IP address.
+func EndpointAddress(svc *service.Service, port service.Port, useHostnames bool) string {
+	if !useHostnames {
+		return port.IP
+	}
+
+	host, err := LookupHost(svc.Hostname)
+	if err != nil {
+		logLimiter.Warnf("resolve:"+svc.Hostname, "Unable to resolve %s, using IP address", svc.Hostname)
+		return port.IP
+	}
+
+	return host
+}
+
 // isPortCollision will make sure we don't tell Envoy about more than one
 // service on the same port. This leads to it going completely apeshit both
 // with CPU usage and logging.
@@ -104,24 +152,70 @@ func isPortCollision(portsMap map[int64]string, svc *service.Service, port servi
 
 // EnvoyResourcesFromState creates a set of Enovy API resource definitions from
 // all the ServicePorts in the Sidecar state. The Sidecar state needs to be
-// locked by the caller before calling this function.
+// locked by the caller, unless it's from SnapshotServices() or ServicesView().
+// Endpoint weights come from the WeightController, which may be nil.
+// Listeners for services with a TLSCert terminate TLS when there is a
+// CertSource. Services that have fallen below their MinInstances get the last
+// good endpoints from the guard, which may also be nil, marked as DEGRADED.
+// Aliases are left out: they share their service's ServicePorts, so nothing
+// could reach clusters of their own. Services that list interfaces in
+// ListenOn get a listener on each of their BindAddrs rather than one on the
+// bindIP. Unless routes is InlineRoutes, HTTP listeners don't carry their
+// routes, but name a route configuration that Envoy fetches over RDS.
+// Clusters close their connections according to the timeouts, which
+// services can override with tags.
 func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
-	useHostnames bool) EnvoyResources {
+	useHostnames bool, weights *catalog.WeightController, certs *CertSource,
+	guard *catalog.InstanceGuard, bindAddrs BindAddrs, routes RouteDiscovery,
+	timeouts ConnectionTimeouts) EnvoyResources {
 
 	endpointMap := make(map[string]*api.ClusterLoadAssignment)
 	clusterMap := make(map[string]*api.Cluster)
 	listenerMap := make(map[string]cache_types.Resource)
+	routeMap := make(map[string]cache_types.Resource)
+	secretMap := make(map[string]cache_types.Resource)
+
+	// Which services already have their listeners, keyed by Envoy service name
+	listened := make(map[string]bool)
 
 	// Used to make sure we don't map the same port to more than one service
 	portsMap := make(map[int64]string)
 
-	// We use the more expensive EachServiceSorted to make sure we make a stable
-	// port mapping allocation in the event of port collisions.
-	state.EachServiceSorted(func(hostname *string, id *string, svc *service.Service) {
-		if svc == nil || !svc.IsAlive() {
+	degraded := guard.Degraded(state)
+
+	addEndpoints := func(envoyServiceName string, svc *service.Service, lbEndpoints []*endpoint.LbEndpoint) {
+		if assignment, ok := endpointMap[envoyServiceName]; ok {
+			assignment.Endpoints[0].LbEndpoints =
+				append(assignment.Endpoints[0].LbEndpoints, lbEndpoints...)
 			return
 		}
 
+		endpointMap[envoyServiceName] = &api.ClusterLoadAssignment{
+			ClusterName: envoyServiceName,
+			Endpoints: []*endpoint.LocalityLbEndpoints{{
+				LbEndpoints: lbEndpoints,
+			}},
+		}
+
+		clusterMap[envoyServiceName] = &api.Cluster{
+			Name:                 envoyServiceName,
+			ConnectTimeout:       &duration.Duration{Nanos: 500000000}, // 500ms
+			ClusterDiscoveryType: &api.Cluster_Type{Type: api.Cluster_EDS},
+			EdsClusterConfig: &api.Cluster_EdsClusterConfig{
+				EdsConfig: &core.ConfigSource{
+					ConfigSourceSpecifier: &core.ConfigSource_Ads{
+						Ads: &core.AggregatedConfigSource{},
+					},
+				},
+			},
+			// The first instance we see decides the timeouts for the cluster
+			CommonHttpProtocolOptions: timeouts.forService(svc).protocolOptions(),
+			// If this needs to be enabled, we might also need to set `ProtocolSelection: api.USE_DOWNSTREAM_PROTOCOL`.
+			// Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+		}
+	}
+
+	addService := func(svc *service.Service, isDegraded bool) {
 		// Loop over the ports and generate a named listener for each port
 		for _, port := range svc.Ports {
 			// Only listen on ServicePorts
@@ -131,64 +225,81 @@ func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
 
 			// Make sure we don't make Envoy go nuts by reporting the same port twice
 			if isPortCollision(portsMap, svc, port) {
-				// This happens A LOT when it happens, so let's back off to once a minute-ish
-				if time.Now().UTC().Sub(LastLoggedPortCollision) > PortCollisionLoggingBackoff {
-					log.Warnf(
-						"Port collision! %s is attempting to squat on port %d owned by %s",
-						svc.Name, port.ServicePort, portsMap[port.ServicePort],
-					)
-					LastLoggedPortCollision = time.Now().UTC()
-				}
+				logLimiter.Warnf(
+					fmt.Sprintf("port-collision:%s:%d", svc.Name, port.ServicePort),
+					"Port collision! %s is attempting to squat on port %d owned by %s",
+					svc.Name, port.ServicePort, portsMap[port.ServicePort],
+				)
 				continue
 			}
 
 			envoyServiceName := SvcName(svc.Name, port.ServicePort)
 
-			if assignment, ok := endpointMap[envoyServiceName]; ok {
-				assignment.Endpoints[0].LbEndpoints =
-					append(assignment.Endpoints[0].LbEndpoints,
-						envoyServiceFromService(svc, port.ServicePort, useHostnames)...)
-			} else {
-				endpointMap[envoyServiceName] = &api.ClusterLoadAssignment{
-					ClusterName: envoyServiceName,
-					Endpoints: []*endpoint.LocalityLbEndpoints{{
-						LbEndpoints: envoyServiceFromService(svc, port.ServicePort, useHostnames),
-					}},
+			lbEndpoints := envoyServiceFromService(svc, port.ServicePort, useHostnames, weights.Weight(svc))
+			if isDegraded {
+				for _, lbEndpoint := range lbEndpoints {
+					lbEndpoint.HealthStatus = core.HealthStatus_DEGRADED
 				}
+			}
 
-				clusterMap[envoyServiceName] = &api.Cluster{
-					Name:                 envoyServiceName,
-					ConnectTimeout:       &duration.Duration{Nanos: 500000000}, // 500ms
-					ClusterDiscoveryType: &api.Cluster_Type{Type: api.Cluster_EDS},
-					EdsClusterConfig: &api.Cluster_EdsClusterConfig{
-						EdsConfig: &core.ConfigSource{
-							ConfigSourceSpecifier: &core.ConfigSource_Ads{
-								Ads: &core.AggregatedConfigSource{},
-							},
-						},
-					},
-					// Contour believes the IdleTimeout should be set to 60s. Not sure if we also need to enable these.
-					// See here: https://github.com/projectcontour/contour/blob/2858fec20d26f56cc75a19d91b61d625a86f36de/internal/envoy/listener.go#L102-L106
-					// CommonHttpProtocolOptions: &core.HttpProtocolOptions{
-					// 	IdleTimeout:           &duration.Duration{Seconds: 60},
-					// 	MaxConnectionDuration: &duration.Duration{Seconds: 60},
-					// },
-					// If this needs to be enabled, we might also need to set `ProtocolSelection: api.USE_DOWNSTREAM_PROTOCOL`.
-					// Http2ProtocolOptions: &core.Http2ProtocolOptions{},
-				}
+			addEndpoints(envoyServiceName, svc, lbEndpoints)
+
+			if listened[envoyServiceName] {
+				continue
 			}
 
-			if _, ok := listenerMap[envoyServiceName]; !ok {
-				listener, err := envoyListenerFromService(svc, envoyServiceName, port.ServicePort, bindIP)
-				if err != nil {
-					log.Errorf("Failed to create Envoy listener for service %q and port %d: %s", svc.Name, port.ServicePort, err)
-					continue
-				}
-				listenerMap[envoyServiceName] = listener
+			listeners, err := envoyListenersFromService(svc, envoyServiceName, port.ServicePort,
+				bindAddrs.forService(svc, bindIP), certs, routes)
+			if err != nil {
+				logLimiter.Errorf("listener:"+envoyServiceName,
+					"Failed to create Envoy listener for service %q and port %d: %s", svc.Name, port.ServicePort, err,
+				)
+				continue
+			}
+
+			if len(listeners) == 0 {
+				continue // None of the interfaces it asked for are configured
+			}
+
+			// Without the certificate, a TLS listener would refuse every connection
+			if err := addSecret(secretMap, certs, svc); err != nil {
+				logLimiter.Errorf("listener:"+envoyServiceName,
+					"Failed to create Envoy listener for service %q and port %d: %s", svc.Name, port.ServicePort, err,
+				)
+				continue
+			}
+
+			for name, listener := range listeners {
+				listenerMap[name] = listener
 			}
+			// Listeners on every interface share the one route configuration
+			if routes != InlineRoutes && usesRoutes(svc.ProxyModeFor(port.ServicePort)) {
+				routeMap[envoyServiceName] = routeConfigForService(svc, envoyServiceName)
+			}
+			listened[envoyServiceName] = true
 		}
+	}
+
+	// We use the more expensive EachServiceSorted to make sure we make a stable
+	// port mapping allocation in the event of port collisions.
+	state.EachServiceSorted(func(hostname *string, id *string, svc *service.Service) {
+		if svc == nil || !state.IsServable(svc) {
+			return
+		}
+
+		if _, ok := degraded[svc.Name]; ok {
+			return // Replaced by the last good instances below
+		}
+
+		addService(svc, false)
 	})
 
+	for _, name := range catalog.DegradedNames(degraded) {
+		for _, svc := range degraded[name] {
+			addService(svc, true)
+		}
+	}
+
 	endpoints := make([]cache_types.Resource, 0, len(endpointMap))
 	for _, endpoint := range endpointMap {
 		endpoints = append(endpoints, endpoint)
@@ -204,50 +315,63 @@ func EnvoyResourcesFromState(state *catalog.ServicesState, bindIP string,
 		listeners = append(listeners, listener)
 	}
 
+	routeConfigs := make([]cache_types.Resource, 0, len(routeMap))
+	for _, routeConfig := range routeMap {
+		routeConfigs = append(routeConfigs, routeConfig)
+	}
+
+	secrets := make([]cache_types.Resource, 0, len(secretMap))
+	for _, secret := range secretMap {
+		secrets = append(secrets, secret)
+	}
+
 	return EnvoyResources{
 		Endpoints: endpoints,
 		Clusters:  clusters,
 		Listeners: listeners,
+		Routes:    routeConfigs,
+		Secrets:   secrets,
+	}
+}
+
+// addSecret loads the certificate the service wants, if we haven't already
+func addSecret(secretMap map[string]cache_types.Resource, certs *CertSource, svc *service.Service) error {
+	if certs == nil || svc.TLSCert == "" {
+		return nil
+	}
+
+	if _, ok := secretMap[svc.TLSCert]; ok {
+		return nil
+	}
+
+	secret, err := certs.loadSecret(svc.TLSCert)
+	if err != nil {
+		return err
 	}
+
+	if secret != nil {
+		secretMap[svc.TLSCert] = secret
+	}
+
+	return nil
 }
 
 // connectionManagerForService returns a ConnectionManager configured
-// appropriately for the Sidecar service
-func connectionManagerForService(svc *service.Service, envoyServiceName string) (managerName string, manager proto.Message, err error) {
-	switch svc.ProxyMode {
+// appropriately for the Sidecar service, in the proxy mode of the port
+func connectionManagerForService(svc *service.Service, mode string, envoyServiceName string,
+	routes RouteDiscovery) (managerName string, manager proto.Message, err error) {
+	switch mode {
 	case "http":
 		managerName = wellknown.HTTPConnectionManager
 
-		manager = &hcm.HttpConnectionManager{
+		httpManager := &hcm.HttpConnectionManager{
 			StatPrefix: "ingress_http",
 			HttpFilters: []*hcm.HttpFilter{{
 				Name: wellknown.Router,
 			}},
-			RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
-				RouteConfig: &api.RouteConfiguration{
-					ValidateClusters: &wrappers.BoolValue{Value: false},
-					VirtualHosts: []*route.VirtualHost{{
-						Name:    svc.Name,
-						Domains: []string{"*"},
-						Routes: []*route.Route{{
-							Match: &route.RouteMatch{
-								PathSpecifier: &route.RouteMatch_Prefix{
-									Prefix: "/",
-								},
-							},
-							Action: &route.Route_Route{
-								Route: &route.RouteAction{
-									ClusterSpecifier: &route.RouteAction_Cluster{
-										Cluster: envoyServiceName,
-									},
-									Timeout: &duration.Duration{},
-								},
-							},
-						}},
-					}},
-				},
-			},
 		}
+		setRoutes(httpManager, svc, envoyServiceName, routes)
+		manager = httpManager
 	case "tcp":
 		managerName = wellknown.TCPProxy
 
@@ -260,43 +384,21 @@ func connectionManagerForService(svc *service.Service, envoyServiceName string)
 	case "ws":
 		managerName = wellknown.HTTPConnectionManager
 
-		manager = &hcm.HttpConnectionManager{
+		wsManager := &hcm.HttpConnectionManager{
 			StatPrefix: "ingress_http",
 			HttpFilters: []*hcm.HttpFilter{{
 				Name: wellknown.Router,
 			}},
-			RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
-				RouteConfig: &api.RouteConfiguration{
-					ValidateClusters: &wrappers.BoolValue{Value: false},
-					VirtualHosts: []*route.VirtualHost{{
-						Name:    svc.Name,
-						Domains: []string{"*"},
-						Routes: []*route.Route{{
-							Match: &route.RouteMatch{
-								PathSpecifier: &route.RouteMatch_Prefix{
-									Prefix: "/",
-								},
-							},
-							Action: &route.Route_Route{
-								Route: &route.RouteAction{
-									ClusterSpecifier: &route.RouteAction_Cluster{
-										Cluster: envoyServiceName,
-									},
-									Timeout: &duration.Duration{},
-								},
-							},
-						}},
-					}},
-				},
-			},
 			UpgradeConfigs: []*hcm.HttpConnectionManager_UpgradeConfig{
 				{
 					UpgradeType: "websocket",
 				},
 			},
 		}
+		setRoutes(wsManager, svc, envoyServiceName, routes)
+		manager = wsManager
 	default:
-		return "", nil, fmt.Errorf("unrecognised proxy mode: %s", svc.ProxyMode)
+		return "", nil, fmt.Errorf("unrecognised proxy mode: %s", mode)
 	}
 
 	// If it was a supported type, return the result
@@ -316,11 +418,32 @@ func filterChainsForService(svc *service.Service, managerName string, serialized
 	}}
 }
 
+// envoyListenersFromService creates an Envoy listener from a service instance
+// for each of the addresses it should be bound to, keyed by listener name
+func envoyListenersFromService(svc *service.Service, envoyServiceName string,
+	servicePort int64, binds []bindAddr, certs *CertSource, routes RouteDiscovery) (map[string]cache_types.Resource, error) {
+
+	listeners := make(map[string]cache_types.Resource, len(binds))
+	for _, bind := range binds {
+		name := ListenerName(envoyServiceName, bind.Name)
+
+		listener, err := envoyListenerFromService(svc, envoyServiceName, name, servicePort, bind.IP, certs, routes)
+		if err != nil {
+			return nil, err
+		}
+		listeners[name] = listener
+	}
+
+	return listeners, nil
+}
+
 // envoyListenerFromService creates an Envoy listener from a service instance
-func envoyListenerFromService(svc *service.Service, envoyServiceName string,
-	servicePort int64, bindIP string) (cache_types.Resource, error) {
+func envoyListenerFromService(svc *service.Service, envoyServiceName string, listenerName string,
+	servicePort int64, bindIP string, certs *CertSource, routes RouteDiscovery) (cache_types.Resource, error) {
+
+	mode := svc.ProxyModeFor(servicePort)
 
-	managerName, manager, err := connectionManagerForService(svc, envoyServiceName)
+	managerName, manager, err := connectionManagerForService(svc, mode, envoyServiceName, routes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create the connection manager: %w", err)
 	}
@@ -332,8 +455,15 @@ func envoyListenerFromService(svc *service.Service, envoyServiceName string,
 
 	filterChains := filterChainsForService(svc, managerName, serializedManager)
 
+	if certs != nil && svc.TLSCert != "" {
+		err = certs.addTLS(filterChains, svc.TLSCert, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &api.Listener{
-		Name: envoyServiceName,
+		Name: listenerName,
 		Address: &core.Address{
 			Address: &core.Address_SocketAddress{
 				SocketAddress: &core.SocketAddress{
@@ -349,25 +479,16 @@ func envoyListenerFromService(svc *service.Service, envoyServiceName string,
 }
 
 // envoyServiceFromService converts a Sidecar service to an Envoy API service for
-// reporting to the proxy
-func envoyServiceFromService(svc *service.Service, svcPort int64, useHostnames bool) []*endpoint.LbEndpoint {
+// reporting to the proxy. A weight of zero leaves the Envoy default in place.
+func envoyServiceFromService(svc *service.Service, svcPort int64, useHostnames bool,
+	weight int) []*endpoint.LbEndpoint {
 	var endpoints []*endpoint.LbEndpoint
 	for _, port := range svc.Ports {
 		// No sense worrying about unexposed ports
 		if port.ServicePort == svcPort {
-			address := port.IP
-
-			// NOT recommended... this is very slow. Useful in dev modes where you
-			// need to resolve to a different IP address only.
-			if useHostnames {
-				if host, err := LookupHost(svc.Hostname); err == nil {
-					address = host
-				} else {
-					log.Warnf("Unable to resolve %s, using IP address", svc.Hostname)
-				}
-			}
+			address := EndpointAddress(svc, port, useHostnames)
 
-			endpoints = append(endpoints, &endpoint.LbEndpoint{
+			lbEndpoint := &endpoint.LbEndpoint{
 				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
 					Endpoint: &endpoint.Endpoint{
 						Address: &core.Address{
@@ -382,7 +503,13 @@ func envoyServiceFromService(svc *service.Service, svcPort int64, useHostnames b
 						},
 					},
 				},
-			})
+			}
+
+			if weight > 0 {
+				lbEndpoint.LoadBalancingWeight = &wrappers.UInt32Value{Value: uint32(weight)}
+			}
+
+			endpoints = append(endpoints, lbEndpoint)
 		}
 	}
 
@@ -2,8 +2,16 @@ package adapter
 
 import (
 	"testing"
+	"time"
 
+	"github.com/NinesStack/sidecar/catalog"
 	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/ptypes"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -44,3 +52,174 @@ func Test_isPortCollision(t *testing.T) {
 		})
 	})
 }
+
+func Test_DegradedEndpoints(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with an InstanceGuard", t, func() {
+		state := catalog.NewServicesState()
+		guard := catalog.NewInstanceGuard()
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		svc1 := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: baseTime, ProxyMode: "http", MinInstances: 2,
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"}},
+		}
+		svc2 := svc1
+		svc2.ID = "deadbeef456"
+		svc2.Hostname = "spenser"
+		svc2.Ports = []service.Port{{Type: "tcp", Port: 10001, ServicePort: 8080, IP: "127.0.0.2"}}
+		state.AddServiceEntry(svc1)
+		state.AddServiceEntry(svc2)
+
+		endpointsFor := func(resources EnvoyResources) []*endpoint.LbEndpoint {
+			So(len(resources.Endpoints), ShouldEqual, 1)
+			return resources.Endpoints[0].(*api.ClusterLoadAssignment).Endpoints[0].LbEndpoints
+		}
+
+		Convey("leaves healthy services alone", func() {
+			endpoints := endpointsFor(EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, guard, nil, InlineRoutes, ConnectionTimeouts{}))
+			So(len(endpoints), ShouldEqual, 2)
+			So(endpoints[0].HealthStatus, ShouldEqual, core.HealthStatus_UNKNOWN)
+		})
+
+		Convey("marks the last good endpoints as degraded below the minimum", func() {
+			EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, guard, nil, InlineRoutes, ConnectionTimeouts{})
+
+			svc2.Status = service.UNHEALTHY
+			svc2.Updated = baseTime.Add(time.Second)
+			state.AddServiceEntry(svc2)
+
+			endpoints := endpointsFor(EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, guard, nil, InlineRoutes, ConnectionTimeouts{}))
+			So(len(endpoints), ShouldEqual, 2)
+			for _, lbEndpoint := range endpoints {
+				So(lbEndpoint.HealthStatus, ShouldEqual, core.HealthStatus_DEGRADED)
+			}
+		})
+	})
+}
+
+func Test_AliasClusters(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with aliases", t, func() {
+		state := catalog.NewServicesState()
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http", Aliases: []string{"grendel"},
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"}},
+		}
+		state.AddServiceEntry(svc)
+
+		resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+		Convey("doesn't add clusters or listeners for aliases", func() {
+			So(len(resources.Clusters), ShouldEqual, 1)
+			So(len(resources.Endpoints), ShouldEqual, 1)
+			So(resources.Endpoints[0].(*api.ClusterLoadAssignment).ClusterName, ShouldEqual, "beowulf:8080")
+			So(len(resources.Listeners), ShouldEqual, 1)
+		})
+	})
+}
+
+func Test_PortProxyModes(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with a mode on a port", t, func() {
+		state := catalog.NewServicesState()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http",
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"},
+				{Type: "tcp", Port: 10000, ServicePort: 9090, IP: "127.0.0.1", ProxyMode: "tcp"},
+			},
+		})
+
+		resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, RDSRoutes, ConnectionTimeouts{})
+
+		filterFor := func(name string) string {
+			for _, resource := range resources.Listeners {
+				envoyListener := resource.(*api.Listener)
+				if envoyListener.Name == name {
+					return envoyListener.FilterChains[0].Filters[0].Name
+				}
+			}
+			return ""
+		}
+
+		Convey("proxies each ServicePort in its own mode", func() {
+			So(filterFor("beowulf:8080"), ShouldEqual, wellknown.HTTPConnectionManager)
+			So(filterFor("beowulf:9090"), ShouldEqual, wellknown.TCPProxy)
+		})
+
+		Convey("only routes the HTTP port", func() {
+			So(len(resources.Routes), ShouldEqual, 1)
+			So(resources.Routes[0].(*api.RouteConfiguration).Name, ShouldEqual, "beowulf:8080")
+		})
+
+		Convey("sends both ports to the same instance", func() {
+			So(len(resources.Endpoints), ShouldEqual, 2)
+			So(len(resources.Clusters), ShouldEqual, 2)
+		})
+	})
+}
+
+func Test_RouteDiscovery(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with RDS", t, func() {
+		state := catalog.NewServicesState()
+
+		httpSvc := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http",
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"}},
+		}
+		tcpSvc := service.Service{
+			ID: "deadbeef456", Name: "grendel", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "tcp",
+			Ports: []service.Port{{Type: "tcp", Port: 10001, ServicePort: 9090, IP: "127.0.0.1"}},
+		}
+		state.AddServiceEntry(httpSvc)
+		state.AddServiceEntry(tcpSvc)
+
+		managerFor := func(resources EnvoyResources, name string) *hcm.HttpConnectionManager {
+			for _, resource := range resources.Listeners {
+				envoyListener := resource.(*api.Listener)
+				if envoyListener.Name != name {
+					continue
+				}
+				var manager hcm.HttpConnectionManager
+				err := ptypes.UnmarshalAny(envoyListener.FilterChains[0].Filters[0].GetTypedConfig(), &manager)
+				So(err, ShouldBeNil)
+				return &manager
+			}
+			return nil
+		}
+
+		Convey("inlines the routes by default", func() {
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+			So(resources.Routes, ShouldBeEmpty)
+			manager := managerFor(resources, "beowulf:8080")
+			So(manager, ShouldNotBeNil)
+			So(manager.GetRouteConfig().GetName(), ShouldEqual, "beowulf:8080")
+		})
+
+		Convey("sends a route configuration for each HTTP service", func() {
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, RDSRoutes, ConnectionTimeouts{})
+
+			So(len(resources.Routes), ShouldEqual, 1)
+			routes := resources.Routes[0].(*api.RouteConfiguration)
+			So(routes.Name, ShouldEqual, "beowulf:8080")
+			So(routes.VirtualHosts[0].Routes[0].GetRoute().GetCluster(), ShouldEqual, "beowulf:8080")
+
+			manager := managerFor(resources, "beowulf:8080")
+			So(manager, ShouldNotBeNil)
+			So(manager.GetRouteConfig(), ShouldBeNil)
+			So(manager.GetRds().GetRouteConfigName(), ShouldEqual, "beowulf:8080")
+			So(manager.GetRds().GetConfigSource().GetAds(), ShouldNotBeNil)
+		})
+
+		Convey("picks the route discovery from the settings", func() {
+			So(RouteDiscoveryFor(false), ShouldEqual, InlineRoutes)
+			So(RouteDiscoveryFor(true), ShouldEqual, RDSRoutes)
+		})
+	})
+}
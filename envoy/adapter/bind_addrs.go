@@ -0,0 +1,91 @@
+package adapter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	// ListenerInterfaceSeparator joins a listener's service name to the
+	// interface it's bound to
+	ListenerInterfaceSeparator = "@"
+)
+
+// BindAddrs maps interface names, as services list them in ListenOn, to the
+// addresses Envoy binds to for them. This lets a service listen only on
+// localhost for egress, or on every address for ingress, without moving all
+// the other services off the default BindIP.
+type BindAddrs map[string]string
+
+// A bindAddr is one address a service's listeners are bound to. Name is
+// empty for the default BindIP.
+type bindAddr struct {
+	Name string
+	IP   string
+}
+
+// ParseBindAddrs parses a list of "name=address" entries, as given in the
+// config, e.g. "local=127.0.0.1"
+func ParseBindAddrs(entries []string) (BindAddrs, error) {
+	addrs := make(BindAddrs, len(entries))
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bind address %q is not in the form name=address", entry)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		ip := strings.TrimSpace(parts[1])
+		if name == "" || strings.Contains(name, ListenerInterfaceSeparator) {
+			return nil, fmt.Errorf("bind address %q has an invalid name", entry)
+		}
+
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("bind address %q has an invalid IP address", entry)
+		}
+
+		addrs[name] = ip
+	}
+
+	return addrs, nil
+}
+
+// forService returns the addresses the service's listeners should be bound
+// to. Services that don't name any interfaces get the default BindIP. Names
+// we don't know about are skipped, rather than falling back to the default,
+// so that a service meant to be local doesn't end up listening everywhere.
+func (b BindAddrs) forService(svc *service.Service, defaultIP string) []bindAddr {
+	if len(svc.ListenOn) == 0 {
+		return []bindAddr{{IP: defaultIP}}
+	}
+
+	binds := make([]bindAddr, 0, len(svc.ListenOn))
+	for _, name := range svc.ListenOn {
+		ip, ok := b[name]
+		if !ok {
+			logLimiter.Warnf("bind-addr:"+svc.Name+":"+name,
+				"Service %s wants to listen on unknown interface %q, skipping it", svc.Name, name,
+			)
+			continue
+		}
+
+		binds = append(binds, bindAddr{Name: name, IP: ip})
+	}
+
+	return binds
+}
+
+// ListenerName formats an Envoy listener name from the Envoy service name
+// and the interface it's bound to. Listeners on the default BindIP are named
+// after the service alone.
+func ListenerName(envoyServiceName string, iface string) string {
+	if iface == "" {
+		return envoyServiceName
+	}
+
+	return envoyServiceName + ListenerInterfaceSeparator + iface
+}
@@ -0,0 +1,75 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ParseBindAddrs(t *testing.T) {
+	Convey("ParseBindAddrs()", t, func() {
+		Convey("parses name=address entries", func() {
+			addrs, err := ParseBindAddrs([]string{"local=127.0.0.1", " public = 0.0.0.0", "v6=::1"})
+			So(err, ShouldBeNil)
+			So(addrs, ShouldResemble, BindAddrs{"local": "127.0.0.1", "public": "0.0.0.0", "v6": "::1"})
+		})
+
+		Convey("rejects bad entries", func() {
+			for _, entry := range []string{"127.0.0.1", "=127.0.0.1", "local=localhost", "lo@cal=127.0.0.1"} {
+				_, err := ParseBindAddrs([]string{entry})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_ListenerBindAddrs(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with named bind addresses", t, func() {
+		state := catalog.NewServicesState()
+		bindAddrs := BindAddrs{"local": "127.0.0.1", "public": "0.0.0.0"}
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http",
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"}},
+		}
+
+		listenersFor := func(svc service.Service) map[string]string {
+			state.AddServiceEntry(svc)
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, bindAddrs, InlineRoutes, ConnectionTimeouts{})
+
+			addrs := make(map[string]string)
+			for _, resource := range resources.Listeners {
+				listener := resource.(*api.Listener)
+				addrs[listener.Name] = listener.Address.GetSocketAddress().Address
+			}
+			return addrs
+		}
+
+		Convey("binds to the default BindIP when no interfaces are named", func() {
+			So(listenersFor(svc), ShouldResemble, map[string]string{"beowulf:8080": "192.168.168.168"})
+		})
+
+		Convey("adds a listener for each named interface", func() {
+			svc.ListenOn = []string{"local", "public"}
+			So(listenersFor(svc), ShouldResemble, map[string]string{
+				"beowulf:8080@local":  "127.0.0.1",
+				"beowulf:8080@public": "0.0.0.0",
+			})
+		})
+
+		Convey("skips interfaces that aren't configured", func() {
+			svc.ListenOn = []string{"local", "garbage"}
+			So(listenersFor(svc), ShouldResemble, map[string]string{"beowulf:8080@local": "127.0.0.1"})
+		})
+
+		Convey("doesn't fall back to the default BindIP for unknown interfaces", func() {
+			svc.ListenOn = []string{"garbage"}
+			So(listenersFor(svc), ShouldBeEmpty)
+		})
+	})
+}
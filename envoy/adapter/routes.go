@@ -0,0 +1,91 @@
+package adapter
+
+import (
+	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	route "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// RouteDiscovery is how HTTP listeners get their routes
+type RouteDiscovery int
+
+const (
+	InlineRoutes RouteDiscovery = iota // Each listener carries its routes
+	RDSRoutes                          // Each listener names a route configuration, fetched over RDS
+)
+
+// RouteDiscoveryFor returns the RouteDiscovery the Envoy settings ask for
+func RouteDiscoveryFor(useRDS bool) RouteDiscovery {
+	if useRDS {
+		return RDSRoutes
+	}
+
+	return InlineRoutes
+}
+
+// usesRoutes returns true when listeners in this proxy mode route HTTP requests
+func usesRoutes(mode string) bool {
+	return mode == "http" || mode == "ws"
+}
+
+// routeConfigForService returns the routes for an HTTP service, which send
+// everything to its cluster. It's named after the cluster.
+func routeConfigForService(svc *service.Service, envoyServiceName string) *api.RouteConfiguration {
+	return &api.RouteConfiguration{
+		Name:             envoyServiceName,
+		ValidateClusters: &wrappers.BoolValue{Value: false},
+		VirtualHosts: []*route.VirtualHost{{
+			Name:    svc.Name,
+			Domains: []string{"*"},
+			Routes: []*route.Route{{
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{
+						Prefix: "/",
+					},
+				},
+				Action: &route.Route_Route{
+					Route: &route.RouteAction{
+						ClusterSpecifier: &route.RouteAction_Cluster{
+							Cluster: envoyServiceName,
+						},
+						Timeout: &duration.Duration{},
+					},
+				},
+			}},
+		}},
+	}
+}
+
+// adsConfigSource fetches resources over the ADS stream Envoy already has open
+func adsConfigSource() *core.ConfigSource {
+	return &core.ConfigSource{
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{
+			Ads: &core.AggregatedConfigSource{},
+		},
+	}
+}
+
+// setRoutes tells the HTTP connection manager where to get its routes:
+// inline, or by name from RDS, which lets Envoy update the routes without
+// replacing the listener
+func setRoutes(manager *hcm.HttpConnectionManager, svc *service.Service, envoyServiceName string,
+	routes RouteDiscovery) {
+
+	switch routes {
+	case InlineRoutes:
+		manager.RouteSpecifier = &hcm.HttpConnectionManager_RouteConfig{
+			RouteConfig: routeConfigForService(svc, envoyServiceName),
+		}
+	default:
+		manager.RouteSpecifier = &hcm.HttpConnectionManager_Rds{
+			Rds: &hcm.Rds{
+				RouteConfigName: envoyServiceName,
+				ConfigSource:    adsConfigSource(),
+			},
+		}
+	}
+}
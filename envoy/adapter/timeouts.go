@@ -0,0 +1,70 @@
+package adapter
+
+import (
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// The service tags that override the ConnectionTimeouts for a service, as
+// durations like "30s"
+const (
+	IdleTimeoutTag           = "envoy_idle_timeout"
+	MaxConnectionDurationTag = "envoy_max_connection_duration"
+)
+
+// ConnectionTimeouts limit how long Envoy keeps its upstream connections to
+// the instances of a service open. Without them, keepalive connections to an
+// instance that's been drained can stay open long after it stopped getting
+// new ones. Zero leaves Envoy's own default in place: an hour for the idle
+// timeout, and no limit on the duration. They only apply to HTTP services.
+type ConnectionTimeouts struct {
+	Idle        time.Duration // Close connections that have had no requests for this long
+	MaxDuration time.Duration // Close connections once they've been open this long
+}
+
+// forService returns the timeouts with any the service overrides with tags
+func (t ConnectionTimeouts) forService(svc *service.Service) ConnectionTimeouts {
+	t.Idle = durationTag(svc, IdleTimeoutTag, t.Idle)
+	t.MaxDuration = durationTag(svc, MaxConnectionDurationTag, t.MaxDuration)
+	return t
+}
+
+// protocolOptions returns the options for a cluster, nil when there's nothing
+// to set
+func (t ConnectionTimeouts) protocolOptions() *core.HttpProtocolOptions {
+	if t.Idle <= 0 && t.MaxDuration <= 0 {
+		return nil
+	}
+
+	options := &core.HttpProtocolOptions{}
+	if t.Idle > 0 {
+		options.IdleTimeout = ptypes.DurationProto(t.Idle)
+	}
+	if t.MaxDuration > 0 {
+		options.MaxConnectionDuration = ptypes.DurationProto(t.MaxDuration)
+	}
+
+	return options
+}
+
+// durationTag parses a duration from a service tag, falling back to the
+// default when it's missing or invalid
+func durationTag(svc *service.Service, tag string, defaultValue time.Duration) time.Duration {
+	value, ok := svc.Tags[tag]
+	if !ok {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		logLimiter.Warnf("tag:"+svc.Name+":"+tag,
+			"Ignoring %s tag on %s, %q is not a duration", tag, svc.Name, value,
+		)
+		return defaultValue
+	}
+
+	return parsed
+}
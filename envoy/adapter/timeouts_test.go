@@ -0,0 +1,65 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/ptypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ConnectionTimeouts(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with ConnectionTimeouts", t, func() {
+		state := catalog.NewServicesState()
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "beowulf", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http",
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "127.0.0.1"}},
+		}
+
+		clusterFor := func(timeouts ConnectionTimeouts) *api.Cluster {
+			state.AddServiceEntry(svc)
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, InlineRoutes, timeouts)
+			So(len(resources.Clusters), ShouldEqual, 1)
+			return resources.Clusters[0].(*api.Cluster)
+		}
+
+		Convey("leaves Envoy's defaults alone without any", func() {
+			So(clusterFor(ConnectionTimeouts{}).CommonHttpProtocolOptions, ShouldBeNil)
+		})
+
+		Convey("sets the defaults on the cluster", func() {
+			options := clusterFor(ConnectionTimeouts{Idle: time.Minute}).CommonHttpProtocolOptions
+			So(options, ShouldNotBeNil)
+
+			idle, _ := ptypes.Duration(options.IdleTimeout)
+			So(idle, ShouldEqual, time.Minute)
+			So(options.MaxConnectionDuration, ShouldBeNil)
+		})
+
+		Convey("lets the service override them with tags", func() {
+			svc.Tags = map[string]string{
+				IdleTimeoutTag:           "10s",
+				MaxConnectionDurationTag: "5m",
+			}
+			options := clusterFor(ConnectionTimeouts{Idle: time.Minute}).CommonHttpProtocolOptions
+
+			idle, _ := ptypes.Duration(options.IdleTimeout)
+			maxDuration, _ := ptypes.Duration(options.MaxConnectionDuration)
+			So(idle, ShouldEqual, 10*time.Second)
+			So(maxDuration, ShouldEqual, 5*time.Minute)
+		})
+
+		Convey("ignores tags that aren't durations", func() {
+			svc.Tags = map[string]string{IdleTimeoutTag: "soon"}
+			options := clusterFor(ConnectionTimeouts{Idle: time.Minute}).CommonHttpProtocolOptions
+
+			idle, _ := ptypes.Duration(options.IdleTimeout)
+			So(idle, ShouldEqual, time.Minute)
+		})
+	})
+}
@@ -0,0 +1,148 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/ptypes"
+)
+
+const (
+	CertFileSuffix = ".crt" // Certificate chains in the cert directory are named <cert>.crt
+	KeyFileSuffix  = ".key" // and their private keys <cert>.key
+)
+
+var validCertName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// A CertSource tells Envoy where to get the certificates that services ask
+// for with their TLSCert. Either we read them from CertDir and send them to
+// Envoy ourselves over ADS, or Envoy fetches them by name from an external
+// SDS server, reached through the Envoy cluster SdsCluster. A nil CertSource
+// disables TLS termination.
+type CertSource struct {
+	CertDir    string
+	SdsCluster string
+}
+
+// NewCertSource returns a CertSource for the config, or nil when neither
+// a directory nor an SDS cluster is configured.
+func NewCertSource(certDir string, sdsCluster string) *CertSource {
+	if certDir == "" && sdsCluster == "" {
+		return nil
+	}
+
+	return &CertSource{CertDir: certDir, SdsCluster: sdsCluster}
+}
+
+// secretConfig returns the reference to the named certificate that goes in
+// a listener's TLS context
+func (c *CertSource) secretConfig(name string) *auth.SdsSecretConfig {
+	if c.SdsCluster != "" {
+		return &auth.SdsSecretConfig{
+			Name: name,
+			SdsConfig: &core.ConfigSource{
+				ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+					ApiConfigSource: &core.ApiConfigSource{
+						ApiType: core.ApiConfigSource_GRPC,
+						GrpcServices: []*core.GrpcService{{
+							TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+								EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: c.SdsCluster},
+							},
+						}},
+					},
+				},
+			},
+		}
+	}
+
+	return &auth.SdsSecretConfig{
+		Name: name,
+		SdsConfig: &core.ConfigSource{
+			ConfigSourceSpecifier: &core.ConfigSource_Ads{
+				Ads: &core.AggregatedConfigSource{},
+			},
+		},
+	}
+}
+
+// loadSecret reads the named certificate and key from the cert directory.
+// Returns nil without an error when they come from an external SDS server.
+func (c *CertSource) loadSecret(name string) (cache_types.Resource, error) {
+	if c.SdsCluster != "" {
+		return nil, nil
+	}
+
+	// The name comes from the service, so don't let it wander off elsewhere
+	if !validCertName.MatchString(name) {
+		return nil, fmt.Errorf("invalid certificate name %q", name)
+	}
+
+	chain, err := ioutil.ReadFile(filepath.Join(c.CertDir, name+CertFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate %q: %w", name, err)
+	}
+
+	key, err := ioutil.ReadFile(filepath.Join(c.CertDir, name+KeyFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key for certificate %q: %w", name, err)
+	}
+
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_TlsCertificate{
+			TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{InlineBytes: chain},
+				},
+				PrivateKey: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{InlineBytes: key},
+				},
+			},
+		},
+	}, nil
+}
+
+// transportSocketFor returns the transport socket that terminates TLS with
+// the named certificate. HTTP listeners offer HTTP/2 as well as HTTP/1.1.
+func (c *CertSource) transportSocketFor(name string, proxyMode string) (*core.TransportSocket, error) {
+	tlsContext := &auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{c.secretConfig(name)},
+		},
+	}
+
+	if proxyMode == "http" {
+		tlsContext.CommonTlsContext.AlpnProtocols = []string{"h2", "http/1.1"}
+	}
+
+	serialized, err := ptypes.MarshalAny(tlsContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize the TLS context: %w", err)
+	}
+
+	return &core.TransportSocket{
+		Name:       wellknown.TransportSocketTls,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: serialized},
+	}, nil
+}
+
+// addTLS terminates TLS on all of the listener's filter chains
+func (c *CertSource) addTLS(chains []*listener.FilterChain, name string, proxyMode string) error {
+	transportSocket, err := c.transportSocketFor(name, proxyMode)
+	if err != nil {
+		return err
+	}
+
+	for _, chain := range chains {
+		chain.TransportSocket = transportSocket
+	}
+
+	return nil
+}
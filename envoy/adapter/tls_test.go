@@ -0,0 +1,120 @@
+package adapter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/ptypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TLSTermination(t *testing.T) {
+	Convey("EnvoyResourcesFromState() with TLS", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-certs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ioutil.WriteFile(filepath.Join(dir, "bocaccio.crt"), []byte("CERT"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "bocaccio.key"), []byte("KEY"), 0600)
+
+		state := catalog.NewServicesState()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "http", TLSCert: "bocaccio",
+			Ports: []service.Port{{Type: "tcp", Port: 10000, ServicePort: 443, IP: "127.0.0.1"}},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "dante", Hostname: "chaucer",
+			Updated: time.Now().UTC(), ProxyMode: "tcp",
+			Ports: []service.Port{{Type: "tcp", Port: 10001, ServicePort: 8080, IP: "127.0.0.1"}},
+		})
+
+		listenerFor := func(resources EnvoyResources, name string) *api.Listener {
+			for _, resource := range resources.Listeners {
+				if l := resource.(*api.Listener); l.Name == name {
+					return l
+				}
+			}
+			return nil
+		}
+
+		tlsContextFor := func(l *api.Listener) *auth.DownstreamTlsContext {
+			transportSocket := l.FilterChains[0].TransportSocket
+			if transportSocket == nil {
+				return nil
+			}
+			So(transportSocket.Name, ShouldEqual, wellknown.TransportSocketTls)
+
+			var tlsContext auth.DownstreamTlsContext
+			err := ptypes.UnmarshalAny(transportSocket.GetTypedConfig(), &tlsContext)
+			So(err, ShouldBeNil)
+			return &tlsContext
+		}
+
+		Convey("leaves TLS off without a CertSource", func() {
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, nil, nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+			So(tlsContextFor(listenerFor(resources, "bocaccio:443")), ShouldBeNil)
+			So(resources.Secrets, ShouldBeEmpty)
+		})
+
+		Convey("sends certificates from the cert directory over ADS", func() {
+			certs := NewCertSource(dir, "")
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, certs, nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+			tlsContext := tlsContextFor(listenerFor(resources, "bocaccio:443"))
+			So(tlsContext, ShouldNotBeNil)
+			sds := tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs[0]
+			So(sds.Name, ShouldEqual, "bocaccio")
+			So(sds.SdsConfig.GetAds(), ShouldNotBeNil)
+			So(tlsContext.CommonTlsContext.AlpnProtocols, ShouldResemble, []string{"h2", "http/1.1"})
+
+			So(tlsContextFor(listenerFor(resources, "dante:8080")), ShouldBeNil)
+
+			So(len(resources.Secrets), ShouldEqual, 1)
+			secret := resources.Secrets[0].(*auth.Secret)
+			So(secret.Name, ShouldEqual, "bocaccio")
+			So(string(secret.GetTlsCertificate().CertificateChain.GetInlineBytes()), ShouldEqual, "CERT")
+			So(string(secret.GetTlsCertificate().PrivateKey.GetInlineBytes()), ShouldEqual, "KEY")
+		})
+
+		Convey("refers Envoy to an external SDS server", func() {
+			certs := NewCertSource("", "sds-server")
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, certs, nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+			tlsContext := tlsContextFor(listenerFor(resources, "bocaccio:443"))
+			sds := tlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs[0]
+			So(sds.Name, ShouldEqual, "bocaccio")
+			grpcService := sds.SdsConfig.GetApiConfigSource().GrpcServices[0]
+			So(grpcService.GetEnvoyGrpc().ClusterName, ShouldEqual, "sds-server")
+
+			So(resources.Secrets, ShouldBeEmpty)
+		})
+
+		Convey("skips the listener when the certificate is missing", func() {
+			os.Remove(filepath.Join(dir, "bocaccio.key"))
+
+			resources := EnvoyResourcesFromState(state, "192.168.168.168", false, nil, NewCertSource(dir, ""), nil, nil, InlineRoutes, ConnectionTimeouts{})
+
+			So(listenerFor(resources, "bocaccio:443"), ShouldBeNil)
+			So(listenerFor(resources, "dante:8080"), ShouldNotBeNil)
+		})
+
+		Convey("doesn't allow certificate names outside the directory", func() {
+			_, err := NewCertSource(dir, "").loadSecret("../etc/passwd")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is nil without any configuration", func() {
+			So(NewCertSource("", ""), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,190 @@
+package envoy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/logging"
+	"github.com/armon/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var authLogs = logging.NewLimiter(logging.DEFAULT_LIMIT_INTERVAL).WithLogger(log)
+
+// ServerAuth decides which Envoys may connect to the xDS server. Without it
+// anyone who can reach the gRPC port can pull the whole service topology.
+type ServerAuth struct {
+	tlsConfig *tls.Config // nil to serve plaintext
+	tokens    [][]byte    // The bearer tokens we accept, none to skip the check
+}
+
+// LoadServerAuth reads the certificates and tokens named in the config.
+// Returns nil when none are configured, which leaves the server open.
+func LoadServerAuth(config config.EnvoyConfig) (*ServerAuth, error) {
+	if config.GRPCTLSCert == "" && config.GRPCTLSKey == "" &&
+		config.GRPCClientCA == "" && config.GRPCTokenFile == "" {
+		return nil, nil
+	}
+
+	auth := &ServerAuth{}
+
+	if config.GRPCTLSCert != "" || config.GRPCTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.GRPCTLSCert, config.GRPCTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the Envoy gRPC certificate: %w", err)
+		}
+
+		auth.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	if config.GRPCClientCA != "" {
+		if auth.tlsConfig == nil {
+			return nil, errors.New("verifying Envoy client certificates needs a server certificate and key")
+		}
+
+		pem, err := ioutil.ReadFile(config.GRPCClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the Envoy client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the Envoy client CA %s", config.GRPCClientCA)
+		}
+
+		auth.tlsConfig.ClientCAs = pool
+		auth.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if config.GRPCTokenFile != "" {
+		tokens, err := readTokens(config.GRPCTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.tokens = tokens
+	}
+
+	return auth, nil
+}
+
+// readTokens reads one token per line, skipping blank lines and comments
+func readTokens(filename string) ([][]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the Envoy gRPC tokens: %w", err)
+	}
+	defer file.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the Envoy gRPC tokens: %w", err)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", filename)
+	}
+
+	return tokens, nil
+}
+
+// serverOptions returns the options that make the gRPC server enforce the
+// auth. Safe to call on a nil ServerAuth.
+func (a *ServerAuth) serverOptions() []grpc.ServerOption {
+	if a == nil {
+		return nil
+	}
+
+	var opts []grpc.ServerOption
+	if a.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(a.tlsConfig)))
+	}
+
+	if len(a.tokens) > 0 {
+		opts = append(opts,
+			grpc.UnaryInterceptor(a.unaryInterceptor),
+			grpc.StreamInterceptor(a.streamInterceptor),
+		)
+	}
+
+	return opts
+}
+
+func (a *ServerAuth) unaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := a.checkToken(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *ServerAuth) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	if err := a.checkToken(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// checkToken makes sure the caller sent one of our tokens in the
+// "authorization" metadata, as "Bearer <token>"
+func (a *ServerAuth) checkToken(ctx context.Context) error {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("authorization") {
+			if strings.HasPrefix(value, "Bearer ") {
+				token = strings.TrimPrefix(value, "Bearer ")
+				break
+			}
+		}
+	}
+
+	if token != "" {
+		for _, accepted := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), accepted) == 1 {
+				return nil
+			}
+		}
+	}
+
+	addr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+	}
+
+	metrics.IncrCounter([]string{"envoy", "grpc", "rejected"}, 1)
+	authLogs.Warnf("grpc-auth:"+addr, "Rejected Envoy gRPC connection from %s: missing or invalid token", addr)
+
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
@@ -0,0 +1,194 @@
+package envoy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testCert is a certificate and key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	So(err, ShouldBeNil)
+
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and key as PEM files, returning their paths
+func (c *testCert) write(dir string, name string) (string, string) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	So(err, ShouldBeNil)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serveHealth runs a gRPC health server with the auth, returning its address
+func serveHealth(auth *ServerAuth) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	server := grpc.NewServer(auth.serverOptions()...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+
+	return listener.Addr().String(), server.Stop
+}
+
+// checkHealth calls the health server, returning the gRPC status code
+func checkHealth(addr string, ctx context.Context, opts ...grpc.DialOption) codes.Code {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	So(err, ShouldBeNil)
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return status.Code(err)
+}
+
+func Test_ServerAuth(t *testing.T) {
+	Convey("ServerAuth", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-grpc-auth")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca := newTestCert("sidecar-ca", nil)
+		caFile, _ := ca.write(dir, "ca")
+		serverCertFile, serverKeyFile := newTestCert("sidecar", ca).write(dir, "server")
+
+		tokenFile := filepath.Join(dir, "tokens")
+		ioutil.WriteFile(tokenFile, []byte("# Envoys in us-east-1\nsekrit\n\nother-sekrit\n"), 0600)
+
+		Convey("is nil when nothing is configured", func() {
+			auth, err := LoadServerAuth(config.EnvoyConfig{})
+			So(err, ShouldBeNil)
+			So(auth, ShouldBeNil)
+			So(auth.serverOptions(), ShouldBeEmpty)
+		})
+
+		Convey("returns errors for bad configs", func() {
+			_, err := LoadServerAuth(config.EnvoyConfig{GRPCTLSCert: serverCertFile})
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadServerAuth(config.EnvoyConfig{GRPCClientCA: caFile})
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadServerAuth(config.EnvoyConfig{
+				GRPCTLSCert: serverCertFile, GRPCTLSKey: serverKeyFile, GRPCClientCA: tokenFile,
+			})
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadServerAuth(config.EnvoyConfig{GRPCTokenFile: filepath.Join(dir, "missing")})
+			So(err, ShouldNotBeNil)
+
+			emptyFile := filepath.Join(dir, "empty")
+			ioutil.WriteFile(emptyFile, []byte("# nothing here\n"), 0600)
+			_, err = LoadServerAuth(config.EnvoyConfig{GRPCTokenFile: emptyFile})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("checks the bearer token", func() {
+			auth, err := LoadServerAuth(config.EnvoyConfig{GRPCTokenFile: tokenFile})
+			So(err, ShouldBeNil)
+			So(len(auth.tokens), ShouldEqual, 2)
+
+			addr, stop := serveHealth(auth)
+			defer stop()
+
+			withToken := func(token string) context.Context {
+				return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+			}
+
+			So(checkHealth(addr, context.Background(), grpc.WithInsecure()), ShouldEqual, codes.Unauthenticated)
+			So(checkHealth(addr, withToken("wrong"), grpc.WithInsecure()), ShouldEqual, codes.Unauthenticated)
+			So(checkHealth(addr, withToken("sekrit"), grpc.WithInsecure()), ShouldEqual, codes.OK)
+			So(checkHealth(addr, withToken("other-sekrit"), grpc.WithInsecure()), ShouldEqual, codes.OK)
+		})
+
+		Convey("requires client certificates signed by the CA", func() {
+			auth, err := LoadServerAuth(config.EnvoyConfig{
+				GRPCTLSCert: serverCertFile, GRPCTLSKey: serverKeyFile, GRPCClientCA: caFile,
+			})
+			So(err, ShouldBeNil)
+
+			addr, stop := serveHealth(auth)
+			defer stop()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+
+			dialWith := func(certs ...tls.Certificate) grpc.DialOption {
+				return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+					RootCAs: roots, Certificates: certs,
+				}))
+			}
+
+			So(checkHealth(addr, context.Background(), dialWith(newTestCert("envoy", ca).tlsCert())), ShouldEqual, codes.OK)
+			So(checkHealth(addr, context.Background(), dialWith()), ShouldEqual, codes.Unavailable)
+
+			stranger := newTestCert("stranger", newTestCert("other-ca", nil))
+			So(checkHealth(addr, context.Background(), dialWith(stranger.tlsCert())), ShouldEqual, codes.Unavailable)
+		})
+	})
+}
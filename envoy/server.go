@@ -2,39 +2,67 @@ package envoy
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
 	"github.com/NinesStack/sidecar/config"
 	"github.com/NinesStack/sidecar/envoy/adapter"
+	"github.com/NinesStack/sidecar/logging"
 	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 	xds "github.com/envoyproxy/go-control-plane/pkg/server/v2"
 	"github.com/relistan/go-director"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 )
 
 const (
 	// LooperUpdateInterval indicates how often to check if the state has changed
 	LooperUpdateInterval = 1 * time.Second
+
+	// DefaultShutdownTimeout is how long we wait for the gRPC server to stop
+	// when the config doesn't say
+	DefaultShutdownTimeout = 10 * time.Second
+
+	// What we leave Envoy with when we shut down
+	ShutdownKeep  = "keep"  // The last resources we sent, so it carries on as it was
+	ShutdownDrain = "drain" // The same clusters, but without any endpoints
 )
 
-type xdsCallbacks struct{}
+// CheckShutdownMode returns an error unless the mode is ShutdownKeep or
+// ShutdownDrain. Empty means ShutdownKeep.
+func CheckShutdownMode(mode string) error {
+	switch mode {
+	case "", ShutdownKeep, ShutdownDrain:
+		return nil
+	default:
+		return fmt.Errorf("unknown Envoy shutdown mode %q", mode)
+	}
+}
+
+var log = logging.Module("envoy")
+
+type xdsCallbacks struct {
+	status *statusTracker // Where we record errors reported by Envoy
+}
 
 func (*xdsCallbacks) OnStreamOpen(context.Context, int64, string) error  { return nil }
 func (*xdsCallbacks) OnStreamClosed(int64)                               {}
 func (*xdsCallbacks) OnStreamRequest(int64, *api.DiscoveryRequest) error { return nil }
-func (*xdsCallbacks) OnStreamResponse(_ int64, req *api.DiscoveryRequest, _ *api.DiscoveryResponse) {
+func (c *xdsCallbacks) OnStreamResponse(_ int64, req *api.DiscoveryRequest, _ *api.DiscoveryResponse) {
 	if req.GetErrorDetail().GetCode() != 0 {
-		log.Errorf("Received Envoy error code %d: %s",
+		message := fmt.Sprintf("Received Envoy error code %d: %s",
 			req.GetErrorDetail().GetCode(),
 			strings.ReplaceAll(req.GetErrorDetail().GetMessage(), "\n", ""),
 		)
+		log.Error(message)
+		c.status.error(message)
 	}
 }
 func (*xdsCallbacks) OnFetchRequest(context.Context, *api.DiscoveryRequest) error   { return nil }
@@ -47,6 +75,16 @@ type Server struct {
 	state         *catalog.ServicesState
 	snapshotCache cache.SnapshotCache
 	xdsServer     xds.Server
+	closeStreams  context.CancelFunc // Ends the streams to Envoy, nil if they end with Run()'s context
+	lastResources *adapter.EnvoyResources
+	shuttingDown  bool                      // No more snapshots from the looper once set
+	sendLock      sync.Mutex                // Protects lastResources and shuttingDown
+	Weights       *catalog.WeightController // Load-aware weights, nil to use Envoy's defaults
+	Guard         *catalog.InstanceGuard    // Keeps endpoints for services below their minimum, nil to disable
+	BindAddrs     adapter.BindAddrs         // Named interfaces that services can listen on besides BindIP
+	status        *statusTracker
+	certs         *adapter.CertSource // nil when TLS termination is off
+	Auth          *ServerAuth         // Who may connect, nil to let anyone
 }
 
 // newSnapshotVersion returns a unique version for Envoy cache snapshots
@@ -70,46 +108,32 @@ func (s *Server) Run(ctx context.Context, looper director.Looper, grpcListener n
 	go looper.Loop(func() error {
 		s.state.RLock()
 		lastChanged := s.state.LastChanged
+		s.state.RUnlock()
 
 		// Do nothing if the state hasn't changed
 		if lastChanged == prevStateLastChanged {
-			s.state.RUnlock()
 			return nil
 		}
-		resources := adapter.EnvoyResourcesFromState(s.state, s.config.BindIP, s.config.UseHostnames)
-		s.state.RUnlock()
 
-		prevStateLastChanged = lastChanged
-
-		// Set the computed listeners and clusters in the current snapshot to
-		// send them to Envoy.
-		// See the eventual consistency considerations in the documentation for
-		// details about how Envoy updates these resources:
-		// https://www.envoyproxy.io/docs/envoy/latest/api-docs/xds_protocol#eventual-consistency-considerations
-
-		// Create a new snapshot version and send the listeners and clusters to Envoy
-		snapshotVersion := newSnapshotVersion()
-		err := s.snapshotCache.SetSnapshot(hostname, cache.NewSnapshot(
-			snapshotVersion,
-			resources.Endpoints,
-			resources.Clusters,
-			nil,
-			resources.Listeners,
-			nil,
-		))
-		if err != nil {
-			log.Errorf("Failed to set new Envoy cache snapshot: %s", err)
-			return nil
-		}
-
-		log.Infof("Sent %d endpoints, %d listeners and %d clusters to Envoy with version %s",
-			len(resources.Endpoints), len(resources.Listeners), len(resources.Clusters), snapshotVersion,
+		// Build the resources from the shared view so we don't hold the state lock
+		state := s.state.ServicesView()
+		resources := adapter.EnvoyResourcesFromState(
+			state, s.config.BindIP, s.config.UseHostnames, s.Weights, s.certs, s.Guard, s.BindAddrs,
+			adapter.RouteDiscoveryFor(s.config.UseRDS),
+			adapter.ConnectionTimeouts{
+				Idle:        s.config.IdleTimeout,
+				MaxDuration: s.config.MaxConnectionDuration,
+			},
 		)
 
+		prevStateLastChanged = state.LastChanged
+
+		s.sendResources(hostname, resources)
+
 		return nil
 	})
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(s.Auth.serverOptions()...)
 	envoy_discovery.RegisterAggregatedDiscoveryServiceServer(grpcServer, s.xdsServer)
 
 	go func() {
@@ -118,22 +142,115 @@ func (s *Server) Run(ctx context.Context, looper director.Looper, grpcListener n
 		}
 	}()
 
-	// Currently, this will block forever
 	<-ctx.Done()
-	grpcServer.GracefulStop()
+	looper.Quit()
+	s.shutdown(hostname, grpcServer)
+}
+
+// sendResources sends Envoy the resources, unless we're shutting down
+func (s *Server) sendResources(hostname string, resources adapter.EnvoyResources) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	if s.shuttingDown {
+		return
+	}
+	s.setSnapshot(hostname, resources)
+}
+
+// setSnapshot sets the computed listeners and clusters in a new snapshot to
+// send them to Envoy. See the eventual consistency considerations in the
+// documentation for details about how Envoy updates these resources:
+// https://www.envoyproxy.io/docs/envoy/latest/api-docs/xds_protocol#eventual-consistency-considerations
+// Expects the sendLock to be held.
+func (s *Server) setSnapshot(hostname string, resources adapter.EnvoyResources) {
+	snapshotVersion := newSnapshotVersion()
+	snapshot := cache.NewSnapshot(
+		snapshotVersion,
+		resources.Endpoints,
+		resources.Clusters,
+		resources.Routes,
+		resources.Listeners,
+		nil,
+	)
+	// NewSnapshot doesn't take secrets in this version of the control plane
+	snapshot.Resources[types.Secret] = cache.NewResources(snapshotVersion, resources.Secrets)
+
+	err := s.snapshotCache.SetSnapshot(hostname, snapshot)
+	if err != nil {
+		log.Errorf("Failed to set new Envoy cache snapshot: %s", err)
+		s.status.error(fmt.Sprintf("Failed to set new Envoy cache snapshot: %s", err))
+		return
+	}
+	s.status.snapshot(snapshotVersion)
+	s.lastResources = &resources
+
+	log.Infof("Sent %d endpoints, %d listeners, %d routes and %d clusters to Envoy with version %s",
+		len(resources.Endpoints), len(resources.Listeners), len(resources.Routes), len(resources.Clusters),
+		snapshotVersion,
+	)
+}
+
+// shutdown leaves Envoy as the ShutdownMode says, then closes the streams
+// to it and stops the gRPC server. Closing the streams ourselves, rather
+// than dropping the connections, lets Envoy keep what it has and reconnect
+// calmly, e.g. to the Sidecar that replaces us in a rolling upgrade.
+func (s *Server) shutdown(hostname string, grpcServer *grpc.Server) {
+	s.sendLock.Lock()
+	s.shuttingDown = true
+	drain := s.config.ShutdownMode == ShutdownDrain && s.lastResources != nil
+	if drain {
+		log.Infof("Draining Envoy endpoints before shutting down")
+		s.setSnapshot(hostname, s.lastResources.Drained())
+	}
+	s.sendLock.Unlock()
+
+	if drain {
+		// Give Envoy the chance to pick up the drained endpoints
+		time.Sleep(s.config.ShutdownDrainDelay)
+	}
+
+	if s.closeStreams != nil {
+		s.closeStreams()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	timeout := s.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Warnf("Envoy gRPC server didn't stop within %s, closing its connections", timeout)
+		grpcServer.Stop()
+	}
 }
 
-// NewServer creates a new Server instance
-func NewServer(ctx context.Context, state *catalog.ServicesState, config config.EnvoyConfig) *Server {
+// NewServer creates a new Server instance. The streams to Envoy stay open
+// until Run() has shut down, so that it can send Envoy a last snapshot.
+func NewServer(state *catalog.ServicesState, config config.EnvoyConfig) *Server {
 	// Instruct the snapshot cache to use Aggregated Discovery Service (ADS)
 	// The third parameter can contain a logger instance, but I didn't find
 	// those logs particularly useful.
 	snapshotCache := cache.NewSnapshotCache(true, cache.IDHash{}, nil)
+	status := &statusTracker{}
+	streamCtx, closeStreams := context.WithCancel(context.Background())
 
 	return &Server{
 		config:        config,
 		state:         state,
 		snapshotCache: snapshotCache,
-		xdsServer:     xds.NewServer(ctx, snapshotCache, &xdsCallbacks{}),
+		xdsServer:     xds.NewServer(streamCtx, snapshotCache, &xdsCallbacks{status: status}),
+		closeStreams:  closeStreams,
+		status:        status,
+		certs:         adapter.NewCertSource(config.TLSCertDir, config.TLSSdsCluster),
+		Guard:         catalog.NewInstanceGuard(),
 	}
 }
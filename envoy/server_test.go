@@ -20,6 +20,7 @@ import (
 	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	tcpp "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	envoy_discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v2"
 	xds "github.com/envoyproxy/go-control-plane/pkg/server/v2"
@@ -30,7 +31,6 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/relistan/go-director"
-	log "github.com/sirupsen/logrus"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -403,3 +403,109 @@ func Test_PortForServicePort(t *testing.T) {
 		})
 	})
 }
+
+func Test_Shutdown(t *testing.T) {
+	Convey("shutdown()", t, func() {
+		log.SetOutput(ioutil.Discard)
+
+		state := catalog.NewServicesState()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "carcasone",
+			Updated: time.Now().UTC(), Status: service.ALIVE, ProxyMode: "http",
+			Ports: []service.Port{{IP: "127.0.0.1", Port: 9990, ServicePort: 10100}},
+		})
+		resources := adapter.EnvoyResourcesFromState(
+			state, bindIP, false, nil, nil, nil, nil, adapter.InlineRoutes, adapter.ConnectionTimeouts{},
+		)
+
+		server := NewServer(state, config.EnvoyConfig{BindIP: bindIP, ShutdownTimeout: time.Second})
+		server.sendResources(state.Hostname, resources)
+
+		endpointsSent := func() []*api.ClusterLoadAssignment {
+			snapshot, err := server.snapshotCache.GetSnapshot(state.Hostname)
+			So(err, ShouldBeNil)
+
+			var assignments []*api.ClusterLoadAssignment
+			for _, item := range snapshot.Resources[types.Endpoint].Items {
+				assignments = append(assignments, item.(*api.ClusterLoadAssignment))
+			}
+			return assignments
+		}
+
+		Convey("leaves Envoy with the last resources by default", func() {
+			server.shutdown(state.Hostname, grpc.NewServer())
+
+			assignments := endpointsSent()
+			So(assignments, ShouldHaveLength, 1)
+			So(assignments[0].GetEndpoints(), ShouldHaveLength, 1)
+		})
+
+		Convey("sends Envoy the clusters without endpoints when draining", func() {
+			server.config.ShutdownMode = ShutdownDrain
+			server.shutdown(state.Hostname, grpc.NewServer())
+
+			assignments := endpointsSent()
+			So(assignments, ShouldHaveLength, 1)
+			So(assignments[0].GetClusterName(), ShouldEqual, "bocaccio:10100")
+			So(assignments[0].GetEndpoints(), ShouldBeEmpty)
+
+			Convey("and doesn't send anything afterwards", func() {
+				server.sendResources(state.Hostname, resources)
+				So(endpointsSent()[0].GetEndpoints(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func Test_Endpoints(t *testing.T) {
+	Convey("Endpoints()", t, func() {
+		state := catalog.NewServicesState()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "carcasone",
+			Updated: time.Now().UTC(), Status: service.ALIVE, ProxyMode: "http",
+			Ports: []service.Port{{IP: "127.0.0.1", Port: 9990, ServicePort: 10100}},
+		})
+
+		server := NewServer(state, config.EnvoyConfig{BindIP: bindIP})
+
+		Convey("is empty before anything is sent", func() {
+			So(server.Endpoints("bocaccio:10100"), ShouldBeEmpty)
+		})
+
+		Convey("returns the addresses last sent for the cluster", func() {
+			server.sendResources(state.Hostname, adapter.EnvoyResourcesFromState(
+				state, bindIP, false, nil, nil, nil, nil, adapter.InlineRoutes, adapter.ConnectionTimeouts{},
+			))
+
+			So(server.Endpoints("bocaccio:10100"), ShouldResemble, []string{"127.0.0.1:9990"})
+			So(server.Endpoints("bocaccio:10101"), ShouldBeEmpty)
+		})
+
+		Convey("matches EndpointFor(), even with UseHostnames", func() {
+			svc := service.Service{
+				ID: "deadbeef456", Name: "petrarch", Hostname: "localhost",
+				Updated: time.Now().UTC(), Status: service.ALIVE, ProxyMode: "http",
+				Ports: []service.Port{{IP: "10.3.3.3", Port: 9991, ServicePort: 10101}},
+			}
+			state.AddServiceEntry(svc)
+
+			server := NewServer(state, config.EnvoyConfig{BindIP: bindIP, UseHostnames: true})
+			server.sendResources(state.Hostname, adapter.EnvoyResourcesFromState(
+				state, bindIP, true, nil, nil, nil, nil, adapter.InlineRoutes, adapter.ConnectionTimeouts{},
+			))
+
+			address := server.EndpointFor(&svc, svc.Ports[0])
+			So(address, ShouldNotEqual, "10.3.3.3:9991")
+			So(server.Endpoints("petrarch:10101"), ShouldResemble, []string{address})
+		})
+	})
+}
+
+func Test_CheckShutdownMode(t *testing.T) {
+	Convey("CheckShutdownMode()", t, func() {
+		So(CheckShutdownMode(""), ShouldBeNil)
+		So(CheckShutdownMode(ShutdownKeep), ShouldBeNil)
+		So(CheckShutdownMode(ShutdownDrain), ShouldBeNil)
+		So(CheckShutdownMode("explode"), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,110 @@
+package envoy
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/envoy/adapter"
+	"github.com/NinesStack/sidecar/service"
+	api "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+)
+
+// Status describes how recent updates to Envoy have gone
+type Status struct {
+	LastSnapshot    time.Time // When we last sent Envoy new resources
+	SnapshotVersion string    // The version of that snapshot
+	LastError       string    // The most recent error, from us or from Envoy
+	LastErrorTime   time.Time
+}
+
+// statusTracker keeps the Status up to date. A nil tracker ignores updates.
+type statusTracker struct {
+	status Status
+	sync.Mutex
+}
+
+func (t *statusTracker) snapshot(version string) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	t.status.LastSnapshot = time.Now().UTC()
+	t.status.SnapshotVersion = version
+	t.Unlock()
+}
+
+func (t *statusTracker) error(message string) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	t.status.LastError = message
+	t.status.LastErrorTime = time.Now().UTC()
+	t.Unlock()
+}
+
+func (t *statusTracker) get() Status {
+	if t == nil {
+		return Status{}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	return t.status
+}
+
+// Status returns how recent updates to Envoy have gone
+func (s *Server) Status() Status {
+	return s.status.get()
+}
+
+// Endpoints returns the addresses, as "host:port", that we last sent Envoy
+// for the cluster, which is named by adapter.SvcName(). Empty when we haven't
+// sent any.
+func (s *Server) Endpoints(clusterName string) []string {
+	return s.AllEndpoints()[clusterName]
+}
+
+// EndpointFor returns the address, as "host:port", that we send Envoy for a
+// port on the service, in the same form as Endpoints(). It follows the
+// UseHostnames setting like the resources we send.
+func (s *Server) EndpointFor(svc *service.Service, port service.Port) string {
+	return net.JoinHostPort(
+		adapter.EndpointAddress(svc, port, s.config.UseHostnames), strconv.FormatInt(port.Port, 10),
+	)
+}
+
+// AllEndpoints returns the addresses we last sent Envoy for every cluster,
+// by cluster name. See Endpoints().
+func (s *Server) AllEndpoints() map[string][]string {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	addresses := make(map[string][]string)
+	if s.lastResources == nil {
+		return addresses
+	}
+
+	for _, resource := range s.lastResources.Endpoints {
+		assignment, ok := resource.(*api.ClusterLoadAssignment)
+		if !ok {
+			continue
+		}
+
+		for _, locality := range assignment.Endpoints {
+			for _, lbEndpoint := range locality.LbEndpoints {
+				socket := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()
+				addresses[assignment.ClusterName] = append(addresses[assignment.ClusterName],
+					net.JoinHostPort(socket.GetAddress(), strconv.Itoa(int(socket.GetPortValue()))),
+				)
+			}
+		}
+	}
+
+	return addresses
+}
@@ -0,0 +1,32 @@
+package envoy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_statusTracker(t *testing.T) {
+	Convey("statusTracker", t, func() {
+		Convey("ignores updates when nil", func() {
+			var tracker *statusTracker
+
+			So(func() { tracker.snapshot("1") }, ShouldNotPanic)
+			So(func() { tracker.error("oops") }, ShouldNotPanic)
+			So(tracker.get(), ShouldResemble, Status{})
+		})
+
+		Convey("records snapshots and errors", func() {
+			tracker := &statusTracker{}
+
+			tracker.snapshot("42")
+			tracker.error("NACK from envoy")
+
+			status := tracker.get()
+			So(status.SnapshotVersion, ShouldEqual, "42")
+			So(status.LastSnapshot.IsZero(), ShouldBeFalse)
+			So(status.LastError, ShouldEqual, "NACK from envoy")
+			So(status.LastErrorTime.IsZero(), ShouldBeFalse)
+		})
+	})
+}
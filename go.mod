@@ -1,6 +1,6 @@
 module github.com/NinesStack/sidecar
 
-go 1.12
+go 1.16
 
 require (
 	github.com/NinesStack/memberlist v0.0.0-20170522194404-cfac2b5cf519
@@ -11,10 +11,13 @@ require (
 	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
 	github.com/armon/go-metrics v0.0.0-20190430140413-ec5e00d3c878
 	github.com/containerd/continuity v0.0.0-20181203112020-004b46473808 // indirect
+	github.com/docker/distribution v2.7.1+incompatible // indirect
+	github.com/docker/docker v0.7.3-0.20180827131323-0c5f8d2b9b23
 	github.com/envoyproxy/go-control-plane v0.9.6
 	github.com/fsouza/go-dockerclient v1.3.1
 	github.com/gogo/protobuf v1.2.1
 	github.com/golang/protobuf v1.4.2
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/mux v1.6.2
 	github.com/hashicorp/go-cleanhttp v0.5.0
 	github.com/hashicorp/go-msgpack v0.5.5 // indirect
@@ -34,12 +37,13 @@ require (
 	github.com/sergi/go-diff v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.0.6
 	github.com/smartystreets/goconvey v1.7.2
-	golang.org/x/net v0.2.0 // indirect
+	golang.org/x/net v0.2.0
 	golang.org/x/sync v0.1.0 // indirect
 	google.golang.org/grpc v1.27.0
 	google.golang.org/protobuf v1.23.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.5
 	gopkg.in/jarcoal/httpmock.v1 v1.0.0-20170412085702-cf52904a3cf0
 	gopkg.in/relistan/rubberneck.v1 v1.0.1
+	gopkg.in/yaml.v2 v2.4.0
 	gotest.tools v2.2.0+incompatible // indirect
 )
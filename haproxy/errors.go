@@ -0,0 +1,43 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoConfigFile is returned when asked to write the config without a
+// ConfigFile to write it to
+var ErrNoConfigFile = errors.New("no HAproxy config file specified")
+
+// A CommandError is returned when the verify or reload command fails
+type CommandError struct {
+	Action  string // "verify" or "reload"
+	Command string
+	Stdout  string
+	Stderr  string
+	Err     error // What running the command returned, e.g. an *exec.ExitError
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("Error running '%s': %s\n%s\n%s", e.Command, e.Err, e.Stdout, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// A VerifyFailedError is returned when HAproxy rejects the config we wrote.
+// Stderr has what HAproxy said about it.
+type VerifyFailedError struct {
+	ConfigFile string
+	Stderr     string
+	Err        error // The *CommandError from the verify command
+}
+
+func (e *VerifyFailedError) Error() string {
+	return fmt.Sprintf("Failed to verify HAproxy config! (%s)", e.Err)
+}
+
+func (e *VerifyFailedError) Unwrap() error {
+	return e.Err
+}
@@ -2,43 +2,72 @@ package haproxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path"
+	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/logging"
 	"github.com/NinesStack/sidecar/service"
-	log "github.com/sirupsen/logrus"
+	"github.com/NinesStack/sidecar/templates"
+	"github.com/NinesStack/sidecar/views"
+	metrics "github.com/armon/go-metrics"
+)
+
+var (
+	log = logging.Module("haproxy")
+
+	// The template is rendered for every state change, so its complaints repeat
+	logLimiter = logging.NewLimiter(logging.DEFAULT_LIMIT_INTERVAL).WithLogger(log)
 )
 
 type portset map[string]string
 type portmap map[string]portset
 
+// The sections of the template that can each be replaced by a file in the
+// TemplateDir, named after the section with a ".cfg" extension
+var TemplateSections = []string{"global", "defaults", "stats", "frontend", "backend"}
+
+// A portSection is what the frontend and backend sections are rendered with
+type portSection struct {
+	Name string // The service name
+	Port string // The ServicePort
+}
+
 // Configuration and state for the HAproxy management module
 type HAproxy struct {
-	ReloadCmd      string `toml:"reload_cmd"`
-	VerifyCmd      string `toml:"verify_cmd"`
-	BindIP         string `toml:"bind_ip"`
-	Template       string `toml:"template"`
-	ConfigFile     string `toml:"config_file"`
-	PidFile        string `toml:"pid_file"`
-	User           string `toml:"user"`
-	Group          string `toml:"group"`
-	UseHostnames   bool   `toml:"use_hostnames"`
+	ReloadCmd      string                    `toml:"reload_cmd"`
+	VerifyCmd      string                    `toml:"verify_cmd"`
+	BindIP         string                    `toml:"bind_ip"`
+	Template       string                    `toml:"template"`     // Override for the embedded template
+	TemplateDir    string                    `toml:"template_dir"` // Files that override sections of the template
+	ConfigFile     string                    `toml:"config_file"`
+	PidFile        string                    `toml:"pid_file"`
+	User           string                    `toml:"user"`
+	Group          string                    `toml:"group"`
+	UseHostnames   bool                      `toml:"use_hostnames"`
+	Weights        *catalog.WeightController // Load-aware weights, nil to use HAproxy's defaults
+	Guard          *catalog.InstanceGuard    // Keeps backends for services below their minimum, nil to disable
+	OnResult       func(ReloadResult)        // Called after every verify and reload, may be nil
 	eventChannel   chan catalog.ChangeEvent
 	signalsHandled bool
 	sigLock        sync.Mutex
 	sigStopChan    chan struct{}
+	results        *ResultsHistory
+	writeLock      sync.Mutex // Only one writer of the config file at a time
+	conflictLock   sync.Mutex
+	lastConflicts  string // The port conflicts we last warned about
 }
 
 // Constructs a properly configured HAProxy and returns a pointer to it
@@ -49,9 +78,10 @@ func New(configFile string, pidFile string) *HAproxy {
 	proxy := HAproxy{
 		ReloadCmd:  reloadCmd,
 		VerifyCmd:  verifyCmd,
-		Template:   "views/haproxy.cfg",
 		ConfigFile: configFile,
 		PidFile:    pidFile,
+		results:    NewResultsHistory(RESULTS_HISTORY_SIZE),
+		Guard:      catalog.NewInstanceGuard(),
 	}
 
 	return &proxy
@@ -92,7 +122,9 @@ func sanitizeName(image string) string {
 func findPortForService(svcPort string, svc *service.Service) string {
 	matchPort, err := strconv.ParseInt(svcPort, 10, 64)
 	if err != nil {
-		log.Errorf("Invalid value from template ('%s') can't parse as int64: %s", svcPort, err.Error())
+		logLimiter.Errorf("template-port:"+svcPort,
+			"Invalid value from template ('%s') can't parse as int64: %s", svcPort, err.Error(),
+		)
 		return "-1"
 	}
 
@@ -116,7 +148,9 @@ func (h *HAproxy) findIpForService(svcPort string, svc *service.Service) string
 
 	matchPort, err := strconv.ParseInt(svcPort, 10, 64)
 	if err != nil {
-		log.Errorf("Invalid value from template ('%s') can't parse as int64: %s", svcPort, err.Error())
+		logLimiter.Errorf("template-port:"+svcPort,
+			"Invalid value from template ('%s') can't parse as int64: %s", svcPort, err.Error(),
+		)
 		return "-1"
 	}
 
@@ -138,12 +172,19 @@ func (h *HAproxy) findIpForService(svcPort string, svc *service.Service) string
 // builds a list of unique ports for all services, then passes these to the
 // template. Ports are looked up by the func getPorts().
 func (h *HAproxy) WriteConfig(state *catalog.ServicesState, output io.Writer) error {
-
-	state.RLock()
-	services := servicesWithPorts(state)
+	// Everything below works on the shared view, so we don't hold the state
+	// lock while building and rendering the config
+	snapshot := state.ServicesView()
+
+	services := servicesWithPorts(snapshot)
+	byPort := servicesByPort(snapshot)
+	degraded := h.Guard.Degraded(snapshot)
+	applyDegraded(services, byPort, degraded)
 	ports := h.makePortmap(services)
-	modes := getModes(state)
-	state.RUnlock()
+	modes := getModes(snapshot)
+	portModes := getPortModes(snapshot)
+
+	h.reportConflicts(catalog.PortConflicts(byPort))
 
 	data := struct {
 		Services map[string][]*service.Service
@@ -157,46 +198,186 @@ func (h *HAproxy) WriteConfig(state *catalog.ServicesState, output io.Writer) er
 
 	funcMap := template.FuncMap{
 		"now": time.Now().UTC,
-		"getMode": func(k string) string {
+		// The port is optional, for templates written before ports had modes
+		"getMode": func(k string, svcPort ...string) string {
+			if len(svcPort) > 0 {
+				port, _ := strconv.ParseInt(svcPort[0], 10, 64)
+				if mode, ok := portModes[catalog.ServicePortKey{Name: k, ServicePort: port}]; ok {
+					return mode
+				}
+			}
 			return modes[k]
 		},
 		"getPorts": func(k string) map[string]string {
 			return ports[k]
 		},
+		"servicesOn": func(k string, svcPort string) []*service.Service {
+			port, _ := strconv.ParseInt(svcPort, 10, 64)
+			return byPort[catalog.ServicePortKey{Name: k, ServicePort: port}]
+		},
 		"portFor":      findPortForService,
 		"ipFor":        h.findIpForService,
 		"bindIP":       func() string { return h.BindIP },
 		"sanitizeName": sanitizeName,
+		"weightFor":    h.Weights.Weight,
+		"degraded": func(k string) bool {
+			_, ok := degraded[k]
+			return ok
+		},
 	}
 
-	t, err := template.New("haproxy").Funcs(funcMap).ParseFiles(h.Template)
+	t, err := h.parseTemplate(funcMap)
 	if err != nil {
-		return fmt.Errorf("Error Parsing template '%s': %s", h.Template, err.Error())
+		return err
 	}
 
-	// We write into a buffer so disk IO doesn't hold up the whole state lock
+	// We write into a buffer so a template error doesn't leave a partial config
 	buf := bytes.NewBuffer(make([]byte, 0, 65535))
-	state.RLock()
-	err = t.ExecuteTemplate(buf, path.Base(h.Template), data)
-	state.RUnlock()
+	err = t.Execute(buf, data)
 	if err != nil {
-		return fmt.Errorf("Error executing template '%s': %s", h.Template, err.Error())
+		return fmt.Errorf("Error executing template '%s': %w", h.templateName(), err)
 	}
 
-	// This is the potentially slowest bit, do it outside the critical section
 	_, err = io.Copy(output, buf)
 	if err != nil {
-		return fmt.Errorf("Error writing template '%s': %s", h.Template, err.Error())
+		return fmt.Errorf("Error writing template '%s': %w", h.templateName(), err)
 	}
 
 	return nil
 }
 
+// templateName returns a name for the template we're using, for logging
+func (h *HAproxy) templateName() string {
+	name := h.Template
+	if len(name) == 0 {
+		name = "embedded"
+	}
+
+	if len(h.TemplateDir) > 0 {
+		name += " with sections from " + h.TemplateDir
+	}
+
+	return name
+}
+
+// sectionFile returns the path of the file that overrides a section
+func (h *HAproxy) sectionFile(section string) string {
+	return filepath.Join(h.TemplateDir, section+".cfg")
+}
+
+// parseTemplate parses the override template from disk if we have one, or
+// else the default template that was compiled into the binary. The embedded
+// template is always parsed, so that its sections are there for an override
+// to use. Any section files in the TemplateDir then replace those sections.
+func (h *HAproxy) parseTemplate(funcMap template.FuncMap) (*template.Template, error) {
+	funcMap["portSection"] = func(name string, port string) portSection {
+		return portSection{Name: name, Port: port}
+	}
+
+	t, err := template.New("haproxy").Funcs(funcMap).Parse(views.HAproxyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Error Parsing embedded template: %w", err)
+	}
+
+	if len(h.Template) > 0 {
+		contents, err := ioutil.ReadFile(h.Template)
+		if err == nil {
+			t, err = t.New(h.Template).Parse(string(contents))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error Parsing template '%s': %w", h.Template, err)
+		}
+	}
+
+	if len(h.TemplateDir) == 0 {
+		return t, nil
+	}
+
+	for _, section := range TemplateSections {
+		filename := h.sectionFile(section)
+		contents, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue // We keep the embedded section
+		}
+		if err == nil {
+			_, err = t.New(section).Parse(string(contents))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error Parsing template section '%s': %w", filename, err)
+		}
+	}
+
+	return t, nil
+}
+
+// validateTemplateDir makes sure the TemplateDir exists and only has files
+// for sections that we know about, so that a typo doesn't go unnoticed
+func (h *HAproxy) validateTemplateDir() error {
+	files, err := ioutil.ReadDir(h.TemplateDir)
+	if err != nil {
+		return fmt.Errorf("HAproxy template directory '%s' is not usable: %w", h.TemplateDir, err)
+	}
+
+	known := make(map[string]bool, len(TemplateSections))
+	for _, section := range TemplateSections {
+		known[section+".cfg"] = true
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".cfg" || known[file.Name()] {
+			continue
+		}
+		return fmt.Errorf("HAproxy template directory '%s' has '%s', which is not one of the sections %s",
+			h.TemplateDir, file.Name(), strings.Join(TemplateSections, ", "),
+		)
+	}
+
+	return nil
+}
+
+// ValidateTemplate makes sure that the template we're configured with exists
+// and parses. Intended to be called at startup so that we fail fast rather
+// than on the first state change.
+func (h *HAproxy) ValidateTemplate() error {
+	if len(h.Template) > 0 {
+		if _, err := os.Stat(h.Template); err != nil {
+			return fmt.Errorf("HAproxy template override '%s' is not usable: %w", h.Template, err)
+		}
+	}
+
+	if len(h.TemplateDir) > 0 {
+		if err := h.validateTemplateDir(); err != nil {
+			return err
+		}
+	}
+
+	// The functions are only called at execution time, so stubs are fine
+	funcMap := template.FuncMap{
+		"now":          time.Now().UTC,
+		"getMode":      func(string) string { return "" },
+		"getPorts":     func(string) map[string]string { return nil },
+		"servicesOn":   func(string, string) []*service.Service { return nil },
+		"portFor":      findPortForService,
+		"ipFor":        h.findIpForService,
+		"bindIP":       func() string { return h.BindIP },
+		"sanitizeName": sanitizeName,
+		"weightFor":    h.Weights.Weight,
+		"degraded":     func(string) bool { return false },
+	}
+
+	_, err := h.parseTemplate(funcMap)
+	return err
+}
+
 // notifySignals swallows a bunch of signals that get sent to us when running into
 // an error from HAproxy. If we didn't swallow these, the process would potentially
 // stop when the signals are propagated by the sub-shell.
 func (h *HAproxy) swallowSignals() {
 	// from HAproxy which propagate.
+	if len(swallowedSignals) == 0 {
+		return
+	}
+
 	sigChan := make(chan os.Signal, 10)
 
 	// Used to stop the goroutine
@@ -213,15 +394,18 @@ func (h *HAproxy) swallowSignals() {
 		}
 	}()
 
-	signal.Notify(sigChan, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	signal.Notify(sigChan, swallowedSignals...)
 }
 
 // ResetSignals unhooks our signal handler from the signals the sub-commands
 // initiate. This is potentially destructive if other places in the program have
 // hooked to the same signals! Affected signals are SIGTSTP, SIGTTIN, SIGTTOU.
+// Does nothing on platforms without them.
 func (h *HAproxy) ResetSignals() {
 	h.sigLock.Lock()
-	signal.Reset(syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	if len(swallowedSignals) > 0 {
+		signal.Reset(swallowedSignals...)
+	}
 	select {
 	case h.sigStopChan <- struct{}{}: // nothing
 	default:
@@ -231,10 +415,11 @@ func (h *HAproxy) ResetSignals() {
 }
 
 // Execute a command and bubble up the error. Includes locking behavior which means
-// that only one of these can be running at once.
-func (h *HAproxy) run(command string) error {
+// that only one of these can be running at once. The outcome is recorded in the
+// results history under the name passed in action.
+func (h *HAproxy) run(action string, command string) error {
 
-	cmd := exec.Command("/bin/bash", "-c", command)
+	cmd := templates.ShellCommand(command)
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 	cmd.Stdout = stdout
@@ -251,41 +436,98 @@ func (h *HAproxy) run(command string) error {
 		h.signalsHandled = true
 	}
 
+	startTime := time.Now().UTC()
 	err := cmd.Run()
 
+	result := ReloadResult{
+		Action:     action,
+		Command:    command,
+		Time:       startTime,
+		Duration:   time.Since(startTime),
+		ExitStatus: exitStatusFor(err),
+		Stderr:     excerpt(stderr.String()),
+	}
+
 	if err != nil {
-		err = fmt.Errorf("Error running '%s': %s\n%s\n%s", command, err, stdout, stderr)
+		result.Error = err.Error()
+		err = &CommandError{
+			Action:  action,
+			Command: command,
+			Stdout:  stdout.String(),
+			Stderr:  stderr.String(),
+			Err:     err,
+		}
+	}
+
+	if h.results != nil {
+		h.results.Add(result)
+	}
+
+	if h.OnResult != nil {
+		h.OnResult(result)
 	}
 
 	return err
 }
 
+// Results returns the most recent verify and reload results, oldest first.
+func (h *HAproxy) Results() []ReloadResult {
+	if h.results == nil {
+		return []ReloadResult{}
+	}
+
+	return h.results.Results()
+}
+
+// LastResult returns the most recent result for the action ("verify" or
+// "reload"), or nil if it has never been run.
+func (h *HAproxy) LastResult(action string) *ReloadResult {
+	if h.results == nil {
+		return nil
+	}
+
+	return h.results.Last(action)
+}
+
 // Run the HAproxy reload command to load the new config and restart.
 // Best to use a command with -sf specified to keep the connections up.
 func (h *HAproxy) Reload() error {
-	return h.run(h.ReloadCmd)
+	return h.run("reload", h.ReloadCmd)
 }
 
 // Run HAproxy with the verify command that will check the validity of
 // the current config. Used to gate a Reload() so we don't load a bad
 // config and tear everything down.
 func (h *HAproxy) Verify() error {
-	return h.run(h.VerifyCmd)
+	return h.run("verify", h.VerifyCmd)
 }
 
 // Watch the state of a ServicesState struct and generate a new proxy
 // config file (haproxy.ConfigFile) when the state changes. Also notifies
 // the service that it needs to reload once the new file has been written
-// and verified.
-func (h *HAproxy) Watch(state *catalog.ServicesState) {
+// and verified. Returns when the context is cancelled.
+func (h *HAproxy) Watch(ctx context.Context, state *catalog.ServicesState) {
 	h.eventChannel = make(chan catalog.ChangeEvent, 2)
-	state.AddListener(h)
 
-	for event := range h.eventChannel {
-		log.Println("State change event from " + event.Service.Hostname)
-		err := h.WriteAndReload(state)
-		if err != nil {
-			log.Error(err.Error())
+	// We rewrite the whole config from the state on every event, so we don't
+	// care about missing events, only that we see the latest one.
+	state.AddListener(h, catalog.WithOverflowResync())
+
+OUTER:
+	for {
+		select {
+		case event, ok := <-h.eventChannel:
+			if !ok {
+				break OUTER
+			}
+
+			log.Println("State change event from " + event.Service.Hostname)
+			err := h.WriteAndReload(state)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		case <-ctx.Done():
+			break OUTER
 		}
 	}
 
@@ -298,12 +540,15 @@ func (h *HAproxy) Watch(state *catalog.ServicesState) {
 // Write out the the HAproxy config and reload the service.
 func (h *HAproxy) WriteAndReload(state *catalog.ServicesState) error {
 	if h.ConfigFile == "" {
-		return fmt.Errorf("Trying to write HAproxy config, but no filename specified!")
+		return fmt.Errorf("Trying to write HAproxy config: %w", ErrNoConfigFile)
 	}
 
+	h.writeLock.Lock()
+	defer h.writeLock.Unlock()
+
 	outfile, err := os.Create(h.ConfigFile)
 	if err != nil {
-		return fmt.Errorf("Unable to write to %s! (%s)", h.ConfigFile, err.Error())
+		return fmt.Errorf("Unable to write to %s! (%w)", h.ConfigFile, err)
 	}
 
 	if err := h.WriteConfig(state, outfile); err != nil {
@@ -311,12 +556,49 @@ func (h *HAproxy) WriteAndReload(state *catalog.ServicesState) error {
 	}
 
 	if err = h.Verify(); err != nil {
-		return fmt.Errorf("Failed to verify HAproxy config! (%s)", err.Error())
+		verifyErr := &VerifyFailedError{ConfigFile: h.ConfigFile, Err: err}
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			verifyErr.Stderr = cmdErr.Stderr
+		}
+		return verifyErr
 	}
 
 	return h.Reload()
 }
 
+// ReadConfig returns the contents of the config file we last wrote
+func (h *HAproxy) ReadConfig() ([]byte, error) {
+	h.writeLock.Lock()
+	defer h.writeLock.Unlock()
+
+	contents, err := ioutil.ReadFile(h.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", h.ConfigFile, err)
+	}
+
+	return contents, nil
+}
+
+// ConfigHasServer tells whether the config file we last wrote has a backend
+// server for the service. See HasServer().
+func (h *HAproxy) ConfigHasServer(svc *service.Service) (bool, error) {
+	contents, err := h.ReadConfig()
+	if err != nil {
+		return false, err
+	}
+
+	return HasServer(contents, svc), nil
+}
+
+// HasServer tells whether the config has a backend server for the service.
+// It looks for the "<hostname>-<ID>" server name that the embedded backend
+// section uses, so a TemplateDir backend that names its servers differently
+// will never match.
+func HasServer(config []byte, svc *service.Service) bool {
+	return bytes.Contains(config, []byte("server "+svc.Hostname+"-"+svc.ID+" "))
+}
+
 // Name is part of the catalog.Listener interface. Returns the listener name.
 func (h *HAproxy) Name() string {
 	return "HAproxy"
@@ -337,75 +619,148 @@ func getModes(state *catalog.ServicesState) map[string]string {
 	modeMap := make(map[string]string)
 	state.EachService(
 		func(hostname *string, serviceId *string, svc *service.Service) {
-			mode := svc.ProxyMode
-			// Treat websockets like HTTP
-			if mode == "ws" {
-				mode = "http"
+			modeMap[svc.Name] = haproxyMode(svc.ProxyMode)
+		},
+	)
+	return modeMap
+}
+
+// getPortModes returns the modes of the ServicePorts that have their own,
+// which override the mode of their service
+func getPortModes(state *catalog.ServicesState) map[catalog.ServicePortKey]string {
+	modeMap := make(map[catalog.ServicePortKey]string)
+	state.EachService(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			for _, port := range svc.Ports {
+				if port.ProxyMode == "" || port.ServicePort < 1 {
+					continue
+				}
+				key := catalog.ServicePortKey{Name: svc.Name, ServicePort: port.ServicePort}
+				modeMap[key] = haproxyMode(port.ProxyMode)
 			}
-			modeMap[svc.Name] = mode
 		},
 	)
 	return modeMap
 }
 
+// haproxyMode returns the HAproxy mode for a proxy mode
+func haproxyMode(mode string) string {
+	// Treat websockets like HTTP
+	if mode == "ws" {
+		return "http"
+	}
+	return mode
+}
+
 // Like state.ByService() but only stores information for services which
-// actually have public ports. Only matches services that have the same name
-// and the same ports. Otherwise log an error.
+// are alive and actually have public ports. Instances of a service don't have
+// to agree on their ServicePorts, e.g. while a port is being migrated, so
+// templates should use servicesOn to find the instances on each port.
 func servicesWithPorts(state *catalog.ServicesState) map[string][]*service.Service {
 	serviceMap := make(map[string][]*service.Service)
 
-	state.EachService(
+	state.EachServiceSorted(
 		func(hostname *string, serviceId *string, svc *service.Service) {
 			if len(svc.Ports) < 1 {
 				return
 			}
 
 			// We only want things that are alive and healthy!
-			if !svc.IsAlive() {
+			if !state.IsServable(svc) {
 				return
 			}
 
-			// If this is the first one, just set it
-			if _, ok := serviceMap[svc.Name]; !ok {
-				serviceMap[svc.Name] = []*service.Service{svc}
-				return
+			serviceMap[svc.Name] = append(serviceMap[svc.Name], svc)
+		},
+	)
+
+	return serviceMap
+}
+
+// Like state.ByServiceAndPort() but only for services that are alive
+func servicesByPort(state *catalog.ServicesState) map[catalog.ServicePortKey][]*service.Service {
+	byPort := state.ByServiceAndPort()
+
+	for key, instances := range byPort {
+		alive := instances[:0:0]
+		for _, svc := range instances {
+			if state.IsServable(svc) {
+				alive = append(alive, svc)
 			}
+		}
 
-			// Otherwise we need to make sure the ServicePorts match
-			match := serviceMap[svc.Name][0] // Get the first entry for comparison
+		if len(alive) == 0 {
+			delete(byPort, key)
+			continue
+		}
+		byPort[key] = alive
+	}
 
-			// Build up a sorted list of ServicePorts from the existing service
-			portsToMatch := getSortedServicePorts(match)
+	return byPort
+}
 
-			// Get the list of our ports
-			portsWeHave := getSortedServicePorts(svc)
+// applyDegraded swaps in the last good instances for services that have
+// fallen below their minimum
+func applyDegraded(services map[string][]*service.Service,
+	byPort map[catalog.ServicePortKey][]*service.Service, degraded map[string][]*service.Service) {
 
-			// Compare the two sorted lists
-			for i, port := range portsToMatch {
-				if portsWeHave[i] != port {
-					// TODO should we just add another service with this port added
-					// to the name? We have to find out which port.
-					log.Warnf("%s service from %s not added: non-matching ports! (%v vs %v)",
-						svc.Name, svc.Hostname, port, portsWeHave[i])
-					return
-				}
+	for key := range byPort {
+		if _, ok := degraded[key.Name]; ok {
+			delete(byPort, key)
+		}
+	}
+
+	for name, instances := range degraded {
+		delete(services, name)
+
+		for _, svc := range instances {
+			if len(svc.Ports) < 1 {
+				continue
 			}
+			services[name] = append(services[name], svc)
 
-			// It was a match! Append to the list.
-			serviceMap[svc.Name] = append(serviceMap[svc.Name], svc)
-		},
-	)
+			seen := make(map[int64]bool, len(svc.Ports))
+			for _, port := range svc.Ports {
+				if port.ServicePort == 0 || seen[port.ServicePort] {
+					continue
+				}
+				seen[port.ServicePort] = true
 
-	return serviceMap
+				key := catalog.ServicePortKey{Name: name, ServicePort: port.ServicePort}
+				byPort[key] = append(byPort[key], svc)
+			}
+		}
+	}
 }
 
-func getSortedServicePorts(svc *service.Service) []string {
-	// Allocate once, with exact length
-	portList := make([]string, len(svc.Ports))
-	for i, port := range svc.Ports {
-		portList[i] = strconv.FormatInt(port.ServicePort, 10)
+// reportConflicts warns about ServicePorts that more than one service wants.
+// HAproxy can't tell which one a connection is meant for. We only complain
+// when the conflicts change, since the config is written on every change.
+func (h *HAproxy) reportConflicts(conflicts []catalog.PortConflict) {
+	metrics.SetGauge([]string{"haproxy", "port_conflicts"}, float32(len(conflicts)))
+
+	description := fmt.Sprintf("%v", conflicts)
+
+	h.conflictLock.Lock()
+	defer h.conflictLock.Unlock()
+
+	previous := h.lastConflicts
+	h.lastConflicts = description
+
+	if description == previous {
+		return
 	}
 
-	sort.Strings(portList)
-	return portList
+	if len(conflicts) == 0 {
+		if previous == "" {
+			return // Nothing has ever conflicted
+		}
+
+		log.Info("HAproxy port conflicts resolved")
+		return
+	}
+
+	for _, conflict := range conflicts {
+		log.Warnf("HAproxy port conflict: %s", conflict)
+	}
 }
@@ -2,16 +2,18 @@ package haproxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
 	"github.com/NinesStack/sidecar/service"
-	log "github.com/sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -107,7 +109,7 @@ func Test_HAproxy(t *testing.T) {
 			p := New("tmpConfig", "tmpPid")
 			So([]byte(p.ReloadCmd), ShouldMatch, "^haproxy .*")
 			So([]byte(p.VerifyCmd), ShouldMatch, "^haproxy .*")
-			So([]byte(p.Template), ShouldMatch, "views/haproxy.cfg")
+			So(p.Template, ShouldBeEmpty)
 		})
 
 		Convey("makePortmap() generates a properly formatted list", func() {
@@ -127,6 +129,28 @@ func Test_HAproxy(t *testing.T) {
 			So(result["some-websock-svc"], ShouldEqual, "http")
 		})
 
+		Convey("getPortModes() picks up the ports with their own mode", func() {
+			dual := services[1]
+			dual.ID = "deadbeef321"
+			dual.Ports = []service.Port{
+				{Type: "tcp", Port: 10450, ServicePort: 8080},
+				{Type: "tcp", Port: 10450, ServicePort: 9091, ProxyMode: "tcp"},
+			}
+			state.AddServiceEntry(dual)
+
+			result := getPortModes(state)
+			So(result, ShouldResemble, map[catalog.ServicePortKey]string{
+				{Name: "awesome-svc", ServicePort: 9091}: "tcp",
+			})
+
+			Convey("and the template uses them", func() {
+				buf := bytes.NewBuffer(make([]byte, 0, 8192))
+				So(proxy.WriteConfig(state, buf), ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, "frontend awesome-svc-9091\n\tmode tcp")
+				So(buf.String(), ShouldContainSubstring, "frontend awesome-svc-8080\n\tmode http")
+			})
+		})
+
 		Convey("findIpForService() returns hostnames when UseHostnames is set", func() {
 			proxy.UseHostnames = true
 			svc := services[0]
@@ -159,11 +183,68 @@ func Test_HAproxy(t *testing.T) {
 			svcList := servicesWithPorts(state)
 			So(len(svcList[badSvc.Name]), ShouldEqual, 1)
 
-			// We add an entry with mismatching ports and should get no more added
+			// We add an entry with different ports and it's kept too, on its own port
 			state.AddServiceEntry(badSvc)
 
 			svcList = servicesWithPorts(state)
-			So(len(svcList[badSvc.Name]), ShouldEqual, 1)
+			So(len(svcList[badSvc.Name]), ShouldEqual, 2)
+
+			byPort := servicesByPort(state)
+			So(len(byPort[catalog.ServicePortKey{Name: "some-svc", ServicePort: 8090}]), ShouldEqual, 1)
+			So(len(byPort[catalog.ServicePortKey{Name: "some-svc", ServicePort: 6666}]), ShouldEqual, 1)
+		})
+
+		Convey("servicesWithPorts() leaves out services whose last check is too old", func() {
+			staleSvc := service.Service{
+				ID:        "0000bad00000",
+				Name:      "some-svc",
+				Image:     "some-svc",
+				Hostname:  "titanic",
+				Updated:   baseTime.Add(5 * time.Second),
+				Ports:     []service.Port{{Type: "tcp", Port: 666, ServicePort: 6666, IP: "127.0.0.1"}},
+				LastCheck: &service.CheckInfo{Time: baseTime.Add(-10 * time.Minute)},
+			}
+			state.AddServiceEntry(staleSvc)
+			state.CheckMaxAge = 2 * time.Minute
+
+			So(len(servicesWithPorts(state)[staleSvc.Name]), ShouldEqual, 1)
+			So(servicesByPort(state), ShouldNotContainKey, catalog.ServicePortKey{Name: "some-svc", ServicePort: 6666})
+		})
+
+		Convey("WriteConfig() routes each port to the instances exposing it", func() {
+			state.AddServiceEntry(service.Service{
+				ID:       "deadbeef777",
+				Name:     "some-svc",
+				Image:    "some-svc",
+				Hostname: hostname1,
+				Updated:  baseTime.Add(5 * time.Second),
+				Ports: []service.Port{
+					{Type: "tcp", Port: 7777, ServicePort: 8090, IP: ip},
+					{Type: "tcp", Port: 7778, ServicePort: 8091, IP: ip},
+				},
+			})
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+
+			output := buf.String()
+			So(output, ShouldContainSubstring, "server indefatigable-deadbeef105 127.0.0.3:9999")
+			So(output, ShouldContainSubstring, "server indomitable-deadbeef777 127.0.0.1:7777")
+			So(output, ShouldContainSubstring, "backend some-svc-8091")
+			So(output, ShouldContainSubstring, "server indomitable-deadbeef777 127.0.0.1:7778")
+			So(output, ShouldNotContainSubstring, ":-1")
+		})
+
+		Convey("reportConflicts() remembers what it warned about", func() {
+			conflicts := catalog.PortConflicts(servicesByPort(state))
+			So(len(conflicts), ShouldEqual, 2) // awesome-svc and some-websock-svc
+
+			proxy.reportConflicts(conflicts)
+			So(proxy.lastConflicts, ShouldContainSubstring, "port 8080 claimed by [awesome-svc some-websock-svc]")
+
+			proxy.reportConflicts(nil)
+			So(proxy.lastConflicts, ShouldEqual, "[]")
 		})
 
 		Convey("WriteConfig() writes a template from a file", func() {
@@ -184,6 +265,91 @@ func Test_HAproxy(t *testing.T) {
 			So(output, ShouldMatch, "server indefatigable-deadbeef105 127.0.0.3:9999 cookie indefatigable-9999")
 		})
 
+		Convey("WriteConfig() only adds weights when load weighting is on", func() {
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, " weight ")
+
+			proxy.Weights = catalog.NewWeightController(catalog.CPULoad)
+			buf.Reset()
+			err = proxy.WriteConfig(state, buf)
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "cookie indefatigable-9999 weight 100")
+		})
+
+		Convey("WriteConfig() uses the embedded template without an override", func() {
+			proxy.Template = ""
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			err := proxy.WriteConfig(state, buf)
+
+			So(err, ShouldBeNil)
+			So(buf.Bytes(), ShouldMatch, "frontend awesome-svc-8080")
+			So(buf.Bytes(), ShouldMatch, "bind 192.168.168.168:9000")
+		})
+
+		Convey("ValidateTemplate() accepts the embedded and file templates", func() {
+			So(proxy.ValidateTemplate(), ShouldBeNil)
+			proxy.Template = ""
+			So(proxy.ValidateTemplate(), ShouldBeNil)
+		})
+
+		Convey("WriteConfig() replaces sections from the template directory", func() {
+			dir, err := ioutil.TempDir("", "sidecar-haproxy-sections")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			backend := "backend {{ sanitizeName .Name }}-{{ .Port }}\n\tmode {{ getMode .Name }}\n\toption custom-check\n"
+			So(ioutil.WriteFile(filepath.Join(dir, "backend.cfg"), []byte(backend), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "global.cfg"), []byte("global\n\tmaxconn 8192\n"), 0644), ShouldBeNil)
+
+			proxy.Template = ""
+			proxy.TemplateDir = dir
+			So(proxy.ValidateTemplate(), ShouldBeNil)
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+
+			output := buf.String()
+			So(output, ShouldContainSubstring, "\tmaxconn 8192\n")
+			So(output, ShouldNotContainSubstring, "stats   socket")
+			So(output, ShouldContainSubstring, "backend awesome-svc-8080\n\tmode http\n\toption custom-check\n")
+			So(output, ShouldNotContainSubstring, "server indefatigable-deadbeef105")
+
+			// The sections that weren't replaced come from the embedded template
+			So(output, ShouldContainSubstring, "frontend awesome-svc-8080")
+			So(output, ShouldContainSubstring, "balance  roundrobin")
+
+			Convey("and refuses files that aren't sections", func() {
+				So(ioutil.WriteFile(filepath.Join(dir, "backends.cfg"), []byte(""), 0644), ShouldBeNil)
+
+				err := proxy.ValidateTemplate()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "backends.cfg")
+			})
+
+			Convey("and reports broken sections", func() {
+				So(ioutil.WriteFile(filepath.Join(dir, "frontend.cfg"), []byte("{{ broken"), 0644), ShouldBeNil)
+
+				err := proxy.WriteConfig(state, buf)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "frontend.cfg")
+			})
+		})
+
+		Convey("ValidateTemplate() fails on a missing template directory", func() {
+			proxy.TemplateDir = "/does/not/exist"
+			So(proxy.ValidateTemplate(), ShouldNotBeNil)
+		})
+
+		Convey("ValidateTemplate() fails on a missing override", func() {
+			proxy.Template = "/does/not/exist.cfg"
+			err := proxy.ValidateTemplate()
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "/does/not/exist.cfg")
+		})
+
 		Convey("WriteConfig() bubbles up templater errors", func() {
 			proxy.Template = "/"
 			buf := bytes.NewBuffer(make([]byte, 0, 2048))
@@ -228,6 +394,62 @@ func Test_HAproxy(t *testing.T) {
 			So(output, ShouldNotMatch, "0000bad00001")
 		})
 
+		Convey("WriteConfig() doesn't add frontends for aliases", func() {
+			aliased := service.Service{
+				ID:       "deadbeef444",
+				Name:     "aliased-svc",
+				Image:    "aliased-svc",
+				Hostname: hostname1,
+				Updated:  baseTime.Add(5 * time.Second),
+				Aliases:  []string{"old-svc", "api-v1"},
+				Ports:    []service.Port{{Type: "tcp", Port: 4444, ServicePort: 8444, IP: ip}},
+			}
+			state.AddServiceEntry(aliased)
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+
+			output := buf.String()
+			So(output, ShouldContainSubstring, "frontend aliased-svc-8444")
+			So(output, ShouldNotContainSubstring, "old-svc")
+			So(output, ShouldNotContainSubstring, "api-v1")
+			So(output, ShouldNotContainSubstring, "frontend old-svc")
+		})
+
+		Convey("WriteConfig() keeps the last good backends below the minimum", func() {
+			guarded := service.Service{
+				ID:           "deadbeef222",
+				Name:         "guarded-svc",
+				Image:        "guarded-svc",
+				Hostname:     hostname1,
+				Updated:      baseTime.Add(5 * time.Second),
+				MinInstances: 2,
+				Ports:        []service.Port{{Type: "tcp", Port: 2222, ServicePort: 8222, IP: ip}},
+			}
+			guarded2 := guarded
+			guarded2.ID = "deadbeef333"
+			guarded2.Hostname = hostname2
+			guarded2.Ports = []service.Port{{Type: "tcp", Port: 3333, ServicePort: 8222, IP: ip3}}
+			state.AddServiceEntry(guarded)
+			state.AddServiceEntry(guarded2)
+
+			buf := bytes.NewBuffer(make([]byte, 0, 2048))
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, "Degraded")
+
+			guarded2.Status = service.TOMBSTONE
+			guarded2.Updated = baseTime.Add(6 * time.Second)
+			state.AddServiceEntry(guarded2)
+
+			buf.Reset()
+			So(proxy.WriteConfig(state, buf), ShouldBeNil)
+
+			output := buf.String()
+			So(output, ShouldContainSubstring, "# Degraded")
+			So(output, ShouldContainSubstring, "server indomitable-deadbeef222 127.0.0.1:2222")
+			So(output, ShouldContainSubstring, "server indefatigable-deadbeef333 127.0.0.3:3333")
+		})
+
 		Convey("Reload() doesn't return an error when it works", func() {
 			proxy.ReloadCmd = "sh -c 'exit 0'"
 			err := proxy.Reload()
@@ -256,6 +478,29 @@ func Test_HAproxy(t *testing.T) {
 
 		})
 
+		Convey("WriteAndReload() returns the verify output when HAproxy rejects the config", func() {
+			proxy.VerifyCmd = "sh -c 'echo bad config >&2; exit 1'"
+			tmpfile, _ := ioutil.TempFile("", "WriteAndReload")
+			proxy.ConfigFile = tmpfile.Name()
+
+			err := proxy.WriteAndReload(state)
+			os.Remove(tmpfile.Name())
+
+			var verifyErr *VerifyFailedError
+			So(errors.As(err, &verifyErr), ShouldBeTrue)
+			So(verifyErr.ConfigFile, ShouldEqual, tmpfile.Name())
+			So(verifyErr.Stderr, ShouldContainSubstring, "bad config")
+
+			var cmdErr *CommandError
+			So(errors.As(err, &cmdErr), ShouldBeTrue)
+			So(cmdErr.Action, ShouldEqual, "verify")
+		})
+
+		Convey("WriteAndReload() needs a config file", func() {
+			proxy.ConfigFile = ""
+			So(errors.Is(proxy.WriteAndReload(state), ErrNoConfigFile), ShouldBeTrue)
+		})
+
 		Convey("sanitizeName() fixes crazy image names", func() {
 			image := "public/something-longish:latest"
 			So(sanitizeName(image), ShouldEqual, "public-something-longish-latest")
@@ -267,7 +512,7 @@ func Test_HAproxy(t *testing.T) {
 			proxy.ConfigFile = config
 			proxy.ReloadCmd = "/usr/bin/false"
 
-			go proxy.Watch(state)
+			go proxy.Watch(context.Background(), state)
 			newTime := time.Now().UTC()
 
 			svc := service.Service{
@@ -322,6 +567,15 @@ func Test_HAproxy(t *testing.T) {
 			os.Remove(config)
 			os.Remove(tmpDir)
 		})
+
+		Convey("Watch() removes its listener when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			proxy.Watch(ctx, state)
+
+			So(state.GetListeners(), ShouldBeEmpty)
+		})
 	})
 }
 
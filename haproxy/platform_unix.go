@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package haproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// Supported is true when Sidecar can manage HAproxy on this platform
+const Supported = true
+
+// The job control signals HAproxy's sub-shell can send us when it fails
+var swallowedSignals = []os.Signal{syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU}
@@ -0,0 +1,12 @@
+package haproxy
+
+import (
+	"os"
+)
+
+// Supported is false because there's no HAproxy build for Windows that we
+// can manage. Sidecar only does discovery there.
+const Supported = false
+
+// Windows has no job control signals to swallow
+var swallowedSignals []os.Signal
@@ -0,0 +1,125 @@
+package haproxy
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	RESULTS_HISTORY_SIZE = 20  // How many verify/reload results we keep around
+	STDERR_EXCERPT_SIZE  = 512 // How much of stderr we keep for each result
+)
+
+// A ReloadResult records the outcome of one invocation of either the verify
+// or the reload command. These are kept in memory so that we can tell when
+// reloads have been failing without having to go look at the logs.
+type ReloadResult struct {
+	Action     string // Either "verify" or "reload"
+	Command    string
+	Time       time.Time
+	Duration   time.Duration
+	ExitStatus int
+	Stderr     string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+// Success is true if the command ran and exited cleanly
+func (r *ReloadResult) Success() bool {
+	return r.ExitStatus == 0 && r.Error == ""
+}
+
+// A ResultsHistory is a fixed size, synchronized record of the most recent
+// ReloadResults, oldest first.
+type ResultsHistory struct {
+	results []ReloadResult
+	size    int
+	sync.RWMutex
+}
+
+// NewResultsHistory returns a ResultsHistory that will hold at most size
+// results.
+func NewResultsHistory(size int) *ResultsHistory {
+	return &ResultsHistory{
+		results: make([]ReloadResult, 0, size),
+		size:    size,
+	}
+}
+
+// Add records a new result, dropping the oldest one if we're full. It also
+// reports the result to the metrics sink.
+func (r *ResultsHistory) Add(result ReloadResult) {
+	metrics.AddSample([]string{"haproxy", result.Action, "duration"}, float32(result.Duration/time.Millisecond))
+	if result.Success() {
+		metrics.IncrCounter([]string{"haproxy", result.Action, "success"}, 1)
+	} else {
+		metrics.IncrCounter([]string{"haproxy", result.Action, "failure"}, 1)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.size < 1 {
+		return
+	}
+
+	if len(r.results) >= r.size {
+		r.results = append(r.results[:0], r.results[len(r.results)-r.size+1:]...)
+	}
+	r.results = append(r.results, result)
+}
+
+// Results returns a copy of the current results, oldest first
+func (r *ResultsHistory) Results() []ReloadResult {
+	r.RLock()
+	defer r.RUnlock()
+
+	results := make([]ReloadResult, len(r.results))
+	copy(results, r.results)
+
+	return results
+}
+
+// Last returns the most recent result for the named action, or nil when we
+// have never run it.
+func (r *ResultsHistory) Last(action string) *ReloadResult {
+	r.RLock()
+	defer r.RUnlock()
+
+	for i := len(r.results) - 1; i >= 0; i-- {
+		if r.results[i].Action == action {
+			result := r.results[i]
+			return &result
+		}
+	}
+
+	return nil
+}
+
+// exitStatusFor extracts the exit status from the error returned by
+// exec.Cmd.Run(). Returns -1 when the command didn't run to completion.
+func exitStatusFor(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+// excerpt trims a string to at most STDERR_EXCERPT_SIZE bytes, keeping the
+// end, which is where the useful bit of an error usually is.
+func excerpt(output string) string {
+	if len(output) <= STDERR_EXCERPT_SIZE {
+		return output
+	}
+
+	return output[len(output)-STDERR_EXCERPT_SIZE:]
+}
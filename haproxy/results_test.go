@@ -0,0 +1,50 @@
+package haproxy
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ResultsHistory(t *testing.T) {
+	Convey("ResultsHistory", t, func() {
+		history := NewResultsHistory(3)
+
+		Convey("keeps only the most recent results", func() {
+			for i := 0; i < 5; i++ {
+				history.Add(ReloadResult{Action: "reload", ExitStatus: i})
+			}
+
+			results := history.Results()
+			So(len(results), ShouldEqual, 3)
+			So(results[0].ExitStatus, ShouldEqual, 2)
+			So(results[2].ExitStatus, ShouldEqual, 4)
+		})
+
+		Convey("finds the last result for an action", func() {
+			So(history.Last("verify"), ShouldBeNil)
+
+			history.Add(ReloadResult{Action: "verify", ExitStatus: 1})
+			history.Add(ReloadResult{Action: "reload", ExitStatus: 0})
+
+			So(history.Last("verify").ExitStatus, ShouldEqual, 1)
+			So(history.Last("reload").Success(), ShouldBeTrue)
+		})
+	})
+
+	Convey("exitStatusFor()", t, func() {
+		So(exitStatusFor(nil), ShouldEqual, 0)
+		So(exitStatusFor(errors.New("boom")), ShouldEqual, -1)
+		So(exitStatusFor(exec.Command("/bin/sh", "-c", "exit 4").Run()), ShouldEqual, 4)
+	})
+
+	Convey("excerpt() keeps the tail of long output", t, func() {
+		long := strings.Repeat("a", STDERR_EXCERPT_SIZE) + "the end"
+		So(len(excerpt(long)), ShouldEqual, STDERR_EXCERPT_SIZE)
+		So(excerpt(long), ShouldEndWith, "the end")
+		So(excerpt("short"), ShouldEqual, "short")
+	})
+}
@@ -0,0 +1,110 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	director "github.com/relistan/go-director"
+)
+
+// templateStamp identifies a version of the template files on disk
+type templateStamp struct {
+	modTime time.Time // The latest of the files
+	size    int64     // Of all the files together
+	files   int
+}
+
+// add includes a file in the stamp
+func (s *templateStamp) add(info os.FileInfo) {
+	if info.ModTime().After(s.modTime) {
+		s.modTime = info.ModTime()
+	}
+	s.size += info.Size()
+	s.files++
+}
+
+// statTemplate returns the stamp for the template override and any section
+// files. Section files are optional, so they only change the stamp when
+// they are added or removed.
+func (h *HAproxy) statTemplate() (templateStamp, error) {
+	var stamp templateStamp
+
+	if len(h.Template) > 0 {
+		info, err := os.Stat(h.Template)
+		if err != nil {
+			return templateStamp{}, err
+		}
+		stamp.add(info)
+	}
+
+	if len(h.TemplateDir) > 0 {
+		for _, section := range TemplateSections {
+			info, err := os.Stat(h.sectionFile(section))
+			if err != nil {
+				continue
+			}
+			stamp.add(info)
+		}
+	}
+
+	return stamp, nil
+}
+
+// ReloadTemplate checks that the template still parses and, if it does,
+// rewrites the config from it and reloads HAproxy. A broken template is
+// reported without touching the running config.
+func (h *HAproxy) ReloadTemplate(state *catalog.ServicesState) error {
+	err := h.ValidateTemplate()
+	if err != nil {
+		return fmt.Errorf("not reloading HAproxy template: %w", err)
+	}
+
+	log.Infof("Reloading HAproxy template '%s'", h.templateName())
+
+	return h.WriteAndReload(state)
+}
+
+// WatchTemplate looks at the template override and section files on each
+// loop and reloads them when they have changed on disk, so templates can be
+// iterated on without restarting Sidecar. Does nothing when we're using the
+// embedded template as it is. Returns when the looper quits or the context
+// is cancelled.
+func (h *HAproxy) WatchTemplate(ctx context.Context, state *catalog.ServicesState, looper director.Looper) {
+	if len(h.Template) == 0 && len(h.TemplateDir) == 0 {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		looper.Quit()
+	}()
+
+	last, err := h.statTemplate()
+	if err != nil {
+		log.Warnf("Unable to stat HAproxy template '%s': %s", h.templateName(), err)
+	}
+
+	looper.Loop(func() error {
+		current, err := h.statTemplate()
+		if err != nil {
+			// Editors often replace the file, so it may briefly be missing
+			log.Debugf("Unable to stat HAproxy template '%s': %s", h.templateName(), err)
+			return nil
+		}
+
+		if current == last {
+			return nil
+		}
+		last = current
+
+		err = h.ReloadTemplate(state)
+		if err != nil {
+			log.Error(err.Error())
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package haproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	director "github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TemplateReloading(t *testing.T) {
+	Convey("Reloading the HAproxy template", t, func() {
+		log.SetOutput(ioutil.Discard)
+
+		dir, err := ioutil.TempDir("", "sidecar-haproxy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		templateFile := filepath.Join(dir, "haproxy.tmpl")
+		configFile := filepath.Join(dir, "haproxy.cfg")
+		So(ioutil.WriteFile(templateFile, []byte("first\n"), 0644), ShouldBeNil)
+
+		state := catalog.NewServicesState()
+
+		proxy := New(configFile, filepath.Join(dir, "haproxy.pid"))
+		proxy.Template = templateFile
+		proxy.VerifyCmd = "true"
+		proxy.ReloadCmd = "true"
+		proxy.ResetSignals()
+
+		readConfig := func() string {
+			contents, _ := ioutil.ReadFile(configFile)
+			return string(contents)
+		}
+
+		Convey("ReloadTemplate() rewrites the config", func() {
+			So(ioutil.WriteFile(templateFile, []byte("second\n"), 0644), ShouldBeNil)
+
+			So(proxy.ReloadTemplate(state), ShouldBeNil)
+			So(readConfig(), ShouldEqual, "second\n")
+			So(proxy.LastResult("reload"), ShouldNotBeNil)
+		})
+
+		Convey("ReloadTemplate() leaves the config alone when the template is broken", func() {
+			So(proxy.ReloadTemplate(state), ShouldBeNil)
+			So(ioutil.WriteFile(templateFile, []byte("{{ broken\n"), 0644), ShouldBeNil)
+
+			err := proxy.ReloadTemplate(state)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not reloading")
+			So(readConfig(), ShouldEqual, "first\n")
+		})
+
+		Convey("WatchTemplate() reloads when the template changes", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			looper := director.NewTimedLooper(director.FOREVER, 5*time.Millisecond, nil)
+
+			done := make(chan struct{})
+			go func() {
+				proxy.WatchTemplate(ctx, state, looper)
+				close(done)
+			}()
+
+			// Nothing happens until the template changes
+			time.Sleep(20 * time.Millisecond)
+			So(readConfig(), ShouldEqual, "")
+
+			So(ioutil.WriteFile(templateFile, []byte("a longer template\n"), 0644), ShouldBeNil)
+
+			deadline := time.Now().Add(time.Second)
+			for readConfig() == "" && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			So(readConfig(), ShouldEqual, "a longer template\n")
+		})
+
+		Convey("The stamp changes when a section file is added", func() {
+			proxy.TemplateDir = dir
+			before, err := proxy.statTemplate()
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(filepath.Join(dir, "backend.cfg"), []byte("backend\n"), 0644), ShouldBeNil)
+			after, err := proxy.statTemplate()
+			So(err, ShouldBeNil)
+			So(after, ShouldNotResemble, before)
+			So(after.files, ShouldEqual, 2)
+		})
+
+		Convey("WatchTemplate() does nothing with the embedded template", func() {
+			proxy.Template = ""
+			looper := director.NewFreeLooper(director.FOREVER, nil)
+
+			// Would never return if it started looping
+			proxy.WatchTemplate(context.Background(), state, looper)
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package healthy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/NinesStack/sidecar/service"
+)
+
+// A CheckDefault is the health check for services whose image matches a
+// pattern, used when discovery doesn't have a check for them, e.g. because
+// the container has no HealthCheck label. This lets the platform set checks
+// for whole families of images without every team adding labels.
+type CheckDefault struct {
+	Image    string // The pattern, where * matches any run of characters
+	Type     string // e.g. "HttpGet"
+	Args     string // Templated like the HealthCheckArgs label
+	Endpoint string // For HttpGet checks without Args, on the first TCP port
+
+	matcher *regexp.Regexp
+}
+
+// Matches tells whether the service's image matches the pattern
+func (d *CheckDefault) Matches(svc *service.Service) bool {
+	return d.matcher != nil && d.matcher.MatchString(svc.Image)
+}
+
+// compile turns the image pattern into a regexp
+func (d *CheckDefault) compile() error {
+	if d.Image == "" {
+		return fmt.Errorf("check default for %s has no image pattern", d.Type)
+	}
+
+	if d.Type == "" {
+		return fmt.Errorf("check default for %s has no check type", d.Image)
+	}
+
+	parts := strings.Split(d.Image, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	var err error
+	d.matcher, err = regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	return err
+}
+
+// LoadCheckDefaults reads a JSON list of CheckDefaults from a file. They are
+// tried in order, and the first one that matches is used.
+func LoadCheckDefaults(filename string) ([]*CheckDefault, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read check defaults: %s", err)
+	}
+
+	var defaults []*CheckDefault
+	err = json.Unmarshal(data, &defaults)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse check defaults from %s: %s", filename, err)
+	}
+
+	for _, checkDefault := range defaults {
+		err := checkDefault.compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return defaults, nil
+}
+
+// checkDefaultFor returns the first CheckDefault matching the service, or
+// nil when there isn't one
+func (m *Monitor) checkDefaultFor(svc *service.Service) *CheckDefault {
+	for _, checkDefault := range m.CheckDefaults {
+		if checkDefault.Matches(svc) {
+			return checkDefault
+		}
+	}
+	return nil
+}
+
+// checkFromDefault configures a check for a service from a CheckDefault.
+// Returns nil when it's an HTTP or TCP check without args and the service
+// has no TCP port to check.
+func (m *Monitor) checkFromDefault(svc *service.Service, checkDefault *CheckDefault) *Check {
+	args := checkDefault.Args
+	if args == "" && (checkDefault.Type == "HttpGet" || checkDefault.Type == "TcpConnect") {
+		port := findFirstTCPPort(svc)
+		if port == nil {
+			return nil
+		}
+
+		endpoint := checkDefault.Endpoint
+		if endpoint == "" {
+			endpoint = m.defaultCheckEndpoint()
+		}
+		args = fmt.Sprintf("http://{{ host }}:%d%s", port.Port, endpoint)
+		if checkDefault.Type == "TcpConnect" {
+			args = fmt.Sprintf("{{ host }}:%d", port.Port)
+		}
+	}
+
+	return &Check{
+		ID:      svc.ID,
+		Type:    checkDefault.Type,
+		Args:    args,
+		Status:  FAILED,
+		Command: m.GetCommandNamed(checkDefault.Type),
+	}
+}
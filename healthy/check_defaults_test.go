@@ -0,0 +1,87 @@
+package healthy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CheckDefaults(t *testing.T) {
+	Convey("Health check defaults by image", t, func() {
+		dir, err := ioutil.TempDir("", "check-defaults")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, "defaults.json")
+		writeDefaults := func(contents string) {
+			So(ioutil.WriteFile(filename, []byte(contents), 0644), ShouldBeNil)
+		}
+
+		writeDefaults(`[
+			{"Image": "*-java-*", "Type": "HttpGet", "Endpoint": "/health"},
+			{"Image": "registry.example.com/workers/*", "Type": "External", "Args": "check-worker {{ tcp 8081 }}"}
+		]`)
+
+		ports := []service.Port{
+			{Type: "udp", Port: 11234, ServicePort: 8080, IP: "127.0.0.1"},
+			{Type: "tcp", Port: 1234, ServicePort: 8081, IP: "127.0.0.1"},
+		}
+		svc := service.Service{ID: "deadbeef123", Name: "beowulf", Hostname: hostname, Ports: ports}
+
+		monitor := NewMonitor(hostname, "/")
+		monitor.CheckDefaults, err = LoadCheckDefaults(filename)
+		So(err, ShouldBeNil)
+
+		Convey("uses the endpoint on the first TCP port", func() {
+			svc.Image = "registry.example.com/team/billing-java-app:1.2.3"
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Type, ShouldEqual, "HttpGet")
+			So(check.Args, ShouldEqual, "http://indefatigable:1234/health")
+			So(check.Command, ShouldResemble, &HttpGetCmd{})
+		})
+
+		Convey("templates the args", func() {
+			svc.Image = "registry.example.com/workers/mailer:2"
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Type, ShouldEqual, "External")
+			So(check.Args, ShouldEqual, "check-worker 1234")
+			So(check.Command, ShouldResemble, &ExternalCmd{})
+		})
+
+		Convey("lets discovery override the defaults", func() {
+			svc.Image = "billing-java-app"
+			svc.Name = "hasCheck"
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Args, ShouldEqual, "http://indefatigable:1234/status/check")
+		})
+
+		Convey("falls back to the default check for other images", func() {
+			svc.Image = "javascript-app"
+			check := monitor.CheckForService(&svc, &mockDiscoverer{})
+
+			So(check.Args, ShouldEqual, "http://indefatigable:1234/")
+		})
+
+		Convey("returns an error for defaults without a type", func() {
+			writeDefaults(`[{"Image": "*"}]`)
+			_, err := LoadCheckDefaults(filename)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error for a bad file", func() {
+			writeDefaults(`{"Image": "*"}`)
+			_, err := LoadCheckDefaults(filename)
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadCheckDefaults(filepath.Join(dir, "missing.json"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
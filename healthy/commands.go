@@ -5,31 +5,319 @@ package healthy
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/NinesStack/sidecar/discovery"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	MAX_CHECK_BODY_BYTES = 64 * 1024 // How much of the body we read when matching it
+
+	DEFAULT_TCP_CHECK_TIMEOUT = 2 * time.Second        // How long a TcpConnectCmd waits to connect and for the banner
+	DEFAULT_HALF_OPEN_WAIT    = 100 * time.Millisecond // How long a TcpConnectCmd watches for the peer hanging up
+)
+
+// A StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
 // A Checker that makes an HTTP get call and expects to get
 // a 200-299 back as success. Anything else is considered
 // a failure. The URL to hit is passed as the args to the
-// Run method.
-type HttpGetCmd struct{}
+// Run method. The zero value uses the defaults, but the
+// healthy status codes, a pattern the body must match, and
+// a response time budget can be configured.
+type HttpGetCmd struct {
+	// Status codes that count as healthy. 200-299 when empty.
+	StatusCodes []StatusRange
+
+	// When set, the response body must match
+	BodyMatch *regexp.Regexp
+
+	// When set, slower responses are SICKLY
+	MaxLatency time.Duration
+}
+
+// NewHttpGetCmd returns an HttpGetCmd configured from the options supplied
+// by discovery. Empty options get the defaults.
+func NewHttpGetCmd(opts discovery.CheckOptions) (*HttpGetCmd, error) {
+	cmd := &HttpGetCmd{}
+
+	if opts.StatusCodes != "" {
+		codes, err := ParseStatusCodes(opts.StatusCodes)
+		if err != nil {
+			return nil, err
+		}
+		cmd.StatusCodes = codes
+	}
+
+	if opts.BodyMatch != "" {
+		re, err := regexp.Compile(opts.BodyMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body match %q: %s", opts.BodyMatch, err)
+		}
+		cmd.BodyMatch = re
+	}
+
+	if opts.MaxLatency != "" {
+		latency, err := time.ParseDuration(opts.MaxLatency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max latency %q: %s", opts.MaxLatency, err)
+		}
+		cmd.MaxLatency = latency
+	}
+
+	return cmd, nil
+}
+
+// ParseStatusCodes parses a comma separated list of status codes and
+// ranges, e.g. "200,204" or "200-299,301"
+func ParseStatusCodes(spec string) ([]StatusRange, error) {
+	var codes []StatusRange
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", part)
+		}
+
+		max := min
+		if len(bounds) == 2 {
+			max, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid status code %q", part)
+			}
+		}
+
+		if min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("invalid status code range %q", part)
+		}
+
+		codes = append(codes, StatusRange{Min: min, Max: max})
+	}
+
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no status codes in %q", spec)
+	}
+
+	return codes, nil
+}
 
 func (h *HttpGetCmd) Run(args string) (int, error) {
+	start := time.Now()
 	resp, err := http.Get(args)
 	if resp == nil {
 		return UNKNOWN, errors.New("No body from HTTP response!")
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+	if !h.healthyStatus(resp.StatusCode) {
+		// Read the body so the connection can be reused
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MAX_CHECK_BODY_BYTES))
+		return SICKLY, err
+	}
+
+	if h.BodyMatch != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_CHECK_BODY_BYTES))
+		if err != nil {
+			return UNKNOWN, err
+		}
+
+		if !h.BodyMatch.Match(body) {
+			log.Debugf("Check response from %s didn't match %s", args, h.BodyMatch)
+			return SICKLY, nil
+		}
+	}
+
+	if h.MaxLatency > 0 {
+		if elapsed := time.Since(start); elapsed > h.MaxLatency {
+			log.Debugf("Check response from %s took %s, more than %s", args, elapsed, h.MaxLatency)
+			return SICKLY, nil
+		}
+	}
+
+	return HEALTHY, nil
+}
+
+// healthyStatus tells us whether the status code counts as healthy
+func (h *HttpGetCmd) healthyStatus(code int) bool {
+	if len(h.StatusCodes) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, r := range h.StatusCodes {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A Checker for services that don't speak HTTP, like databases and message
+// brokers. It connects to the host:port passed as the args to the Run
+// method, and is healthy when the connection is accepted and stays up. When
+// BannerMatch is set, what the service sends first must match it, e.g. the
+// greeting from an SMTP server or a MySQL handshake.
+//
+// Without a banner to wait for, the connection is watched for HalfOpenWait.
+// A peer that accepts and then closes or resets the connection straight
+// away, e.g. a proxy with no backends or a server out of connections, is
+// half-open: the port is up but the service isn't. So is one that hangs up
+// before sending the banner. After HalfOpenThreshold of those in a row, the
+// check is SICKLY.
+type TcpConnectCmd struct {
+	// How long to wait to connect, and for the banner. DEFAULT_TCP_CHECK_TIMEOUT when zero.
+	Timeout time.Duration
+
+	// When set, the first data from the service must match
+	BannerMatch *regexp.Regexp
+
+	// How long to watch for the peer hanging up. Zero disables detection.
+	HalfOpenWait time.Duration
+
+	// How many half-open connections in a row make the check SICKLY.
+	// Values below 1 count as 1.
+	HalfOpenThreshold int
+
+	halfOpenCount int
+	lock          sync.Mutex
+}
+
+// ApplyOptions configures the check from the options supplied by discovery.
+// BodyMatch is the banner to match and MaxLatency is the timeout. The HTTP
+// status codes don't apply and are ignored.
+func (t *TcpConnectCmd) ApplyOptions(opts discovery.CheckOptions) error {
+	var banner *regexp.Regexp
+	if opts.BodyMatch != "" {
+		re, err := regexp.Compile(opts.BodyMatch)
+		if err != nil {
+			return fmt.Errorf("invalid banner match %q: %s", opts.BodyMatch, err)
+		}
+		banner = re
+	}
+
+	var timeout time.Duration
+	if opts.MaxLatency != "" {
+		latency, err := time.ParseDuration(opts.MaxLatency)
+		if err != nil {
+			return fmt.Errorf("invalid max latency %q: %s", opts.MaxLatency, err)
+		}
+		timeout = latency
+	}
+
+	if banner != nil {
+		t.BannerMatch = banner
+	}
+	if timeout > 0 {
+		t.Timeout = timeout
+	}
+
+	return nil
+}
+
+func (t *TcpConnectCmd) Run(args string) (int, error) {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TCP_CHECK_TIMEOUT
+	}
+
+	conn, err := net.DialTimeout("tcp", args, timeout)
+	if err != nil {
+		return SICKLY, err
+	}
+	defer conn.Close()
+
+	if t.BannerMatch != nil {
+		return t.matchBanner(conn, args, timeout)
+	}
+
+	if t.HalfOpenWait <= 0 {
 		return HEALTHY, nil
 	}
 
-	return SICKLY, err
+	_ = conn.SetReadDeadline(time.Now().Add(t.HalfOpenWait))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		// Still connected, the service is waiting for us to talk first
+		return t.halfOpen(false, args), nil
+	}
+
+	// Either it sent us something, or it hung up on us
+	return t.halfOpen(err != nil, args), nil
+}
+
+// matchBanner reads what the service sends when we connect until it matches
+// the BannerMatch, or we run out of time or data
+func (t *TcpConnectCmd) matchBanner(conn net.Conn, args string, timeout time.Duration) (int, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var banner []byte
+	buf := make([]byte, 4096)
+	for len(banner) < MAX_CHECK_BODY_BYTES {
+		n, err := conn.Read(buf)
+		banner = append(banner, buf[:n]...)
+		if t.BannerMatch.Match(banner) {
+			return t.halfOpen(false, args), nil
+		}
+
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Debugf("Banner from %s didn't match %s in %s", args, t.BannerMatch, timeout)
+				return SICKLY, nil
+			}
+
+			// The connection closed before we saw the banner
+			return t.halfOpen(true, args), nil
+		}
+	}
+
+	log.Debugf("Banner from %s didn't match %s", args, t.BannerMatch)
+	return SICKLY, nil
+}
+
+// halfOpen counts the half-open connections in a row and returns the status
+// for this run
+func (t *TcpConnectCmd) halfOpen(detected bool, args string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !detected {
+		t.halfOpenCount = 0
+		return HEALTHY
+	}
+
+	t.halfOpenCount++
+	log.Debugf("Connection to %s was closed by the peer (%d in a row)", args, t.halfOpenCount)
+
+	threshold := t.HalfOpenThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	if t.halfOpenCount >= threshold {
+		return SICKLY
+	}
+
+	return HEALTHY
 }
 
 // A Checker that works with Nagios checks or other simple
@@ -62,3 +350,76 @@ type AlwaysSuccessfulCmd struct{}
 func (a *AlwaysSuccessfulCmd) Run(args string) (int, error) {
 	return HEALTHY, nil
 }
+
+// A SubCheck is one of the checks run by a MultiCmd
+type SubCheck struct {
+	Port    int64 // The ServicePort being checked, zero for the main check
+	Type    string
+	Args    string
+	Command Checker
+}
+
+// A Checker that runs several checks for a service, usually one per
+// port, and combines their results. With RequireAll, the result is the
+// worst of them, otherwise it's the best. Each check carries its own args,
+// so the args passed to the Run method are ignored.
+type MultiCmd struct {
+	Checks     []*SubCheck
+	RequireAll bool
+}
+
+func (c *MultiCmd) Run(args string) (int, error) {
+	chosen := combineResults(c.RunEach(), c.RequireAll)
+	return chosen.Status, chosen.Err
+}
+
+// RunEach runs all the checks in parallel and returns their results, in the
+// same order as the checks. Checks that return an error are UNKNOWN.
+func (c *MultiCmd) RunEach() []RunResult {
+	results := make([]RunResult, len(c.Checks))
+	var wg sync.WaitGroup
+	wg.Add(len(c.Checks))
+	for i, check := range c.Checks {
+		go func(i int, check *SubCheck) {
+			defer wg.Done()
+			status, err := check.Command.Run(check.Args)
+			if err != nil {
+				status = UNKNOWN
+			}
+			results[i] = RunResult{status, err}
+		}(i, check)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// A RunResult is what one run of a Checker came back with
+type RunResult struct {
+	Status int
+	Err    error
+}
+
+// combineResults picks the worst of the results when they must all pass,
+// otherwise the best
+func combineResults(results []RunResult, requireAll bool) RunResult {
+	if len(results) == 0 {
+		return RunResult{UNKNOWN, errors.New("No checks to run!")}
+	}
+
+	// Statuses are ordered from best to worst
+	chosen := results[0]
+	for _, r := range results[1:] {
+		if (requireAll && r.Status > chosen.Status) || (!requireAll && r.Status < chosen.Status) {
+			chosen = r
+		}
+	}
+
+	return chosen
+}
+
+// RequireAllChecks tells us whether an aggregation mode needs every check
+// to pass. Anything other than "any" does.
+func RequireAllChecks(aggregation string) bool {
+	return strings.ToLower(strings.TrimSpace(aggregation)) != "any"
+}
@@ -0,0 +1,245 @@
+package healthy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/discovery"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ParseStatusCodes(t *testing.T) {
+	Convey("ParseStatusCodes()", t, func() {
+		Convey("parses codes and ranges", func() {
+			codes, err := ParseStatusCodes("204, 200-299,301")
+			So(err, ShouldBeNil)
+			So(codes, ShouldResemble, []StatusRange{
+				{Min: 204, Max: 204}, {Min: 200, Max: 299}, {Min: 301, Max: 301},
+			})
+		})
+
+		Convey("rejects junk", func() {
+			for _, spec := range []string{"", "abc", "200-", "299-200", "42", "200-700"} {
+				_, err := ParseStatusCodes(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_HttpGetCmd(t *testing.T) {
+	Convey("HttpGetCmd", t, func() {
+		status := 200
+		body := `{"status": "ok"}`
+		delay := time.Duration(0)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+		defer server.Close()
+
+		Convey("treats 2xx as healthy by default", func() {
+			result, err := (&HttpGetCmd{}).Run(server.URL)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, HEALTHY)
+
+			status = 503
+			result, _ = (&HttpGetCmd{}).Run(server.URL)
+			So(result, ShouldEqual, SICKLY)
+		})
+
+		Convey("uses the configured status codes", func() {
+			cmd, err := NewHttpGetCmd(discovery.CheckOptions{StatusCodes: "204,418"})
+			So(err, ShouldBeNil)
+
+			result, _ := cmd.Run(server.URL)
+			So(result, ShouldEqual, SICKLY)
+
+			status = 418
+			result, _ = cmd.Run(server.URL)
+			So(result, ShouldEqual, HEALTHY)
+		})
+
+		Convey("matches the body", func() {
+			cmd, err := NewHttpGetCmd(discovery.CheckOptions{BodyMatch: `"status":\s*"ok"`})
+			So(err, ShouldBeNil)
+
+			result, _ := cmd.Run(server.URL)
+			So(result, ShouldEqual, HEALTHY)
+
+			body = `{"status": "degraded"}`
+			result, _ = cmd.Run(server.URL)
+			So(result, ShouldEqual, SICKLY)
+		})
+
+		Convey("enforces the response time budget", func() {
+			cmd, err := NewHttpGetCmd(discovery.CheckOptions{MaxLatency: "20ms"})
+			So(err, ShouldBeNil)
+
+			result, _ := cmd.Run(server.URL)
+			So(result, ShouldEqual, HEALTHY)
+
+			delay = 50 * time.Millisecond
+			result, _ = cmd.Run(server.URL)
+			So(result, ShouldEqual, SICKLY)
+		})
+
+		Convey("rejects bad options", func() {
+			_, err := NewHttpGetCmd(discovery.CheckOptions{BodyMatch: "("})
+			So(err, ShouldNotBeNil)
+
+			_, err = NewHttpGetCmd(discovery.CheckOptions{MaxLatency: "soon"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_TcpConnectCmd(t *testing.T) {
+	Convey("TcpConnectCmd", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		address := listener.Addr().String()
+
+		// What the server does with each connection
+		var lock sync.Mutex
+		handle := func(conn net.Conn) { time.Sleep(100 * time.Millisecond) }
+		setHandler := func(fn func(net.Conn)) {
+			lock.Lock()
+			defer lock.Unlock()
+			handle = fn
+		}
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				lock.Lock()
+				fn := handle
+				lock.Unlock()
+				go func() {
+					fn(conn)
+					conn.Close()
+				}()
+			}
+		}()
+
+		cmd := &TcpConnectCmd{Timeout: 50 * time.Millisecond, HalfOpenWait: 20 * time.Millisecond}
+
+		Convey("is healthy when the connection stays up", func() {
+			result, err := cmd.Run(address)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is sickly when it can't connect", func() {
+			listener.Close()
+			result, err := cmd.Run(address)
+			So(err, ShouldNotBeNil)
+			So(result, ShouldEqual, SICKLY)
+		})
+
+		Convey("detects half-open connections", func() {
+			setHandler(func(conn net.Conn) {})
+
+			result, _ := cmd.Run(address)
+			So(result, ShouldEqual, SICKLY)
+
+			Convey("after the threshold is reached", func() {
+				cmd.HalfOpenThreshold = 2
+				cmd.halfOpenCount = 0
+
+				result, _ := cmd.Run(address)
+				So(result, ShouldEqual, HEALTHY)
+				result, _ = cmd.Run(address)
+				So(result, ShouldEqual, SICKLY)
+			})
+
+			Convey("unless it's disabled", func() {
+				cmd.HalfOpenWait = 0
+				result, _ := cmd.Run(address)
+				So(result, ShouldEqual, HEALTHY)
+			})
+		})
+
+		Convey("matches the banner", func() {
+			So(cmd.ApplyOptions(discovery.CheckOptions{BodyMatch: `^220 `}), ShouldBeNil)
+
+			setHandler(func(conn net.Conn) {
+				_, _ = conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+				time.Sleep(100 * time.Millisecond)
+			})
+			result, _ := cmd.Run(address)
+			So(result, ShouldEqual, HEALTHY)
+
+			setHandler(func(conn net.Conn) {
+				_, _ = conn.Write([]byte("421 too busy\r\n"))
+				time.Sleep(100 * time.Millisecond)
+			})
+			result, _ = cmd.Run(address)
+			So(result, ShouldEqual, SICKLY)
+		})
+
+		Convey("rejects bad options", func() {
+			So(cmd.ApplyOptions(discovery.CheckOptions{BodyMatch: "("}), ShouldNotBeNil)
+			So(cmd.ApplyOptions(discovery.CheckOptions{MaxLatency: "soon"}), ShouldNotBeNil)
+			So(cmd.BannerMatch, ShouldBeNil)
+		})
+	})
+}
+
+func Test_MultiCmd(t *testing.T) {
+	Convey("MultiCmd", t, func() {
+		passing := &SubCheck{Port: 8080, Args: "healthy", Command: &mockCommand{DesiredResult: HEALTHY}}
+		sickly := &SubCheck{Port: 9090, Args: "sickly", Command: &mockCommand{DesiredResult: SICKLY}}
+		broken := &SubCheck{Port: 9091, Command: &mockCommand{DesiredResult: HEALTHY, Error: errors.New("oh no")}}
+
+		Convey("runs every check with its own args", func() {
+			cmd := &MultiCmd{Checks: []*SubCheck{passing, sickly}, RequireAll: true}
+			_, _ = cmd.Run("ignored")
+
+			So(passing.Command.(*mockCommand).LastArgs, ShouldEqual, "healthy")
+			So(sickly.Command.(*mockCommand).LastArgs, ShouldEqual, "sickly")
+		})
+
+		Convey("takes the worst result when all checks must pass", func() {
+			cmd := &MultiCmd{Checks: []*SubCheck{passing, sickly}, RequireAll: true}
+			result, err := cmd.Run("")
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, SICKLY)
+
+			cmd.Checks = append(cmd.Checks, broken)
+			result, err = cmd.Run("")
+			So(err, ShouldNotBeNil)
+			So(result, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("takes the best result when any check can pass", func() {
+			cmd := &MultiCmd{Checks: []*SubCheck{sickly, broken, passing}}
+			result, err := cmd.Run("")
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is unknown without any checks", func() {
+			result, err := (&MultiCmd{}).Run("")
+			So(err, ShouldNotBeNil)
+			So(result, ShouldEqual, UNKNOWN)
+		})
+	})
+
+	Convey("RequireAllChecks()", t, func() {
+		So(RequireAllChecks("all"), ShouldBeTrue)
+		So(RequireAllChecks(""), ShouldBeTrue)
+		So(RequireAllChecks(" Any"), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,70 @@
+package healthy
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// dependencyHealthy tells whether the service a check depends on is up. A
+// check without a dependency always goes ahead, and so does one whose
+// dependencies loop back on themselves, since otherwise none of them would
+// ever be checked. A dependency we have no check for is down.
+func dependencyHealthy(check *Check, checks map[string]*Check) bool {
+	if check.DependsOn == "" {
+		return true
+	}
+
+	if dependencyCycle(check, checks) {
+		if check.warnedCycle {
+			return true
+		}
+		check.warnedCycle = true
+		log.Warnf("Ignoring health check dependency of %s (id: %s) on %s: it depends on itself",
+			check.ServiceName, check.ID, check.DependsOn,
+		)
+		return true
+	}
+
+	for _, other := range checks {
+		if other.ServiceName != check.DependsOn || other.ID == check.ID {
+			continue
+		}
+
+		if other.Status == HEALTHY || other.Status == SICKLY {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dependencyCycle tells whether following the dependencies from this check
+// leads back to the service it's checking
+func dependencyCycle(check *Check, checks map[string]*Check) bool {
+	dependsOn := make(map[string][]string)
+	for _, other := range checks {
+		if other.DependsOn != "" {
+			dependsOn[other.ServiceName] = append(dependsOn[other.ServiceName], other.DependsOn)
+		}
+	}
+
+	visited := make(map[string]bool)
+	pending := []string{check.DependsOn}
+
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if name == check.ServiceName {
+			return true
+		}
+
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		pending = append(pending, dependsOn[name]...)
+	}
+
+	return false
+}
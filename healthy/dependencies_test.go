@@ -0,0 +1,64 @@
+package healthy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_dependencyHealthy(t *testing.T) {
+	Convey("When checking dependencies", t, func() {
+		database := &Check{ID: "db1", ServiceName: "database", Status: HEALTHY}
+		web := &Check{ID: "web1", ServiceName: "web", DependsOn: "database"}
+		checks := map[string]*Check{"db1": database, "web1": web}
+
+		Convey("checks without a dependency always run", func() {
+			So(dependencyHealthy(database, checks), ShouldBeTrue)
+		})
+
+		Convey("runs checks when the dependency is healthy", func() {
+			So(dependencyHealthy(web, checks), ShouldBeTrue)
+		})
+
+		Convey("runs checks when the dependency is sickly", func() {
+			database.Status = SICKLY
+			So(dependencyHealthy(web, checks), ShouldBeTrue)
+		})
+
+		Convey("skips checks when the dependency is down", func() {
+			database.Status = FAILED
+			So(dependencyHealthy(web, checks), ShouldBeFalse)
+
+			database.Status = UNKNOWN
+			So(dependencyHealthy(web, checks), ShouldBeFalse)
+		})
+
+		Convey("runs checks when any instance of the dependency is up", func() {
+			database.Status = FAILED
+			checks["db2"] = &Check{ID: "db2", ServiceName: "database", Status: HEALTHY}
+			So(dependencyHealthy(web, checks), ShouldBeTrue)
+		})
+
+		Convey("skips checks when the dependency is missing", func() {
+			delete(checks, "db1")
+			So(dependencyHealthy(web, checks), ShouldBeFalse)
+		})
+
+		Convey("ignores dependencies that loop back on themselves", func() {
+			database.Status = FAILED
+			database.DependsOn = "cache"
+			checks["cache1"] = &Check{
+				ID: "cache1", ServiceName: "cache", DependsOn: "web", Status: FAILED,
+			}
+
+			So(dependencyCycle(web, checks), ShouldBeTrue)
+			So(dependencyHealthy(web, checks), ShouldBeTrue)
+			So(web.warnedCycle, ShouldBeTrue)
+		})
+
+		Convey("doesn't mistake a shared dependency for a loop", func() {
+			checks["api1"] = &Check{ID: "api1", ServiceName: "api", DependsOn: "database"}
+			So(dependencyCycle(web, checks), ShouldBeFalse)
+		})
+	})
+}
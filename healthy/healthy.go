@@ -6,10 +6,14 @@
 package healthy
 
 import (
+	"context"
 	"errors"
+	"hash/fnv"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
@@ -38,6 +42,13 @@ type Monitor struct {
 	DefaultCheckHost     string
 	DiscoveryFn          func() []service.Service
 	DefaultCheckEndpoint string
+	CheckAggregation     string          // How to combine port checks when discovery doesn't say
+	CheckDefaults        []*CheckDefault // Checks by image when discovery doesn't have one
+	StatusPolicy         StatusPolicy    // How check results become statuses when discovery doesn't say. nil for ThresholdPolicy.
+	Clock                clock.Clock     // Times the checks and their timeouts. nil for the wall clock.
+	TcpCheckTimeout      time.Duration   // Settings for TcpConnect checks, see TcpConnectCmd
+	HalfOpenWait         time.Duration
+	HalfOpenThreshold    int
 	sync.RWMutex
 }
 
@@ -71,6 +82,38 @@ type Check struct {
 
 	// The last recorded error on this check
 	LastError error
+
+	// How long the last successful run of the check took
+	LastLatency time.Duration
+
+	// When the last run finished, how long it took and what it said,
+	// whatever the result. Zero until the check has run.
+	LastRun service.CheckInfo
+
+	// The name of the service being checked
+	ServiceName string
+
+	// The name of another local service that must be healthy before we
+	// bother running this check. Empty for no dependency.
+	DependsOn string
+
+	// How the results of each run become the Status. nil for ThresholdPolicy.
+	Policy StatusPolicy
+
+	// When the check was added to the Monitor
+	Added time.Time
+
+	// Until when the check is silenced, and why. A disabled check isn't run
+	// and doesn't hold its service back from being ALIVE.
+	DisabledUntil  time.Time
+	DisabledReason string
+
+	// Whether we've complained about DependsOn leading back to this check
+	warnedCycle bool
+
+	// Guards the results of runs, which are applied from the check's own
+	// goroutine while MarkService() and the API read them
+	lock sync.Mutex
 }
 
 type Checker interface {
@@ -113,6 +156,25 @@ func (check *Check) UpdateStatus(status int, err error) {
 	}
 }
 
+// applyResults updates the status of the check from the results of a run,
+// according to its policy
+func (check *Check) applyResults(results []RunResult, now time.Time) {
+	policy := check.Policy
+	if policy == nil {
+		policy = ThresholdPolicy{}
+	}
+	policy.Apply(check, results, now)
+}
+
+// requiresAll tells whether all of a MultiCmd's checks must pass for the
+// run to be healthy. Checks with a single command always do.
+func (check *Check) requiresAll() bool {
+	if multi, ok := check.Command.(*MultiCmd); ok {
+		return multi.RequireAll
+	}
+	return true
+}
+
 func (check *Check) ServiceStatus() int {
 	switch check.Status {
 	case HEALTHY:
@@ -133,6 +195,10 @@ func NewMonitor(defaultCheckHost string, defaultCheckEndpoint string) *Monitor {
 		CheckInterval:        HEALTH_INTERVAL,
 		DefaultCheckHost:     defaultCheckHost,
 		DefaultCheckEndpoint: defaultCheckEndpoint,
+		Clock:                clock.Real,
+		TcpCheckTimeout:      DEFAULT_TCP_CHECK_TIMEOUT,
+		HalfOpenWait:         DEFAULT_HALF_OPEN_WAIT,
+		HalfOpenThreshold:    1,
 	}
 	return &monitor
 }
@@ -142,6 +208,12 @@ func (m *Monitor) AddCheck(check *Check) {
 	m.Lock()
 	defer m.Unlock()
 	log.Printf("Adding health check: %s (ID: %s), Args: %s", check.Type, check.ID, check.Args)
+	if check.Policy == nil {
+		check.Policy = m.StatusPolicy
+	}
+	if check.Added.IsZero() {
+		check.Added = m.clock().Now()
+	}
 	m.Checks[check.ID] = check
 }
 
@@ -154,16 +226,48 @@ func (m *Monitor) MarkService(svc *service.Service) {
 	// this is the best signal we'll get that a check is no longer
 	// needed. Assumes we're only health checking _our own_ services.
 	m.RLock()
-	if _, ok := m.Checks[svc.ID]; ok {
-		svc.Status = m.Checks[svc.ID].ServiceStatus()
+	if check, ok := m.Checks[svc.ID]; ok {
+		check.lock.Lock()
+		svc.Status = check.ServiceStatus()
+		disabled := check.Disabled(m.clock().Now())
+		if disabled {
+			svc.Status = service.ALIVE
+		}
+		if check.LastLatency > 0 {
+			// Don't modify the discoverer's copy of the Resources
+			var resources service.Resources
+			if svc.Resources != nil {
+				resources = *svc.Resources
+			}
+			resources.CheckLatency = check.LastLatency
+			svc.Resources = &resources
+		}
+		// A disabled check isn't run, so its last run only gets older. Leaving
+		// it out keeps CHECK_MAX_AGE from pulling the service we vouch for.
+		if !disabled && !check.LastRun.Time.IsZero() {
+			lastRun := check.LastRun
+			svc.LastCheck = &lastRun
+		}
+		check.lock.Unlock()
 	} else {
 		svc.Status = service.UNKNOWN
 	}
 	m.RUnlock()
 }
 
+// clock returns where the Monitor gets the time from
+func (m *Monitor) clock() clock.Clock {
+	if m.Clock == nil {
+		return clock.Real
+	}
+	return m.Clock
+}
+
 // Run runs the main monitoring loop. The looper controls the actual run behavior.
-func (m *Monitor) Run(looper director.Looper) {
+// It returns when the looper quits or the context is cancelled.
+func (m *Monitor) Run(ctx context.Context, looper director.Looper) {
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
 		log.Debugf("Running checks")
 
@@ -177,14 +281,40 @@ func (m *Monitor) Run(looper director.Looper) {
 		}
 		m.RUnlock()
 
+		// Checks whose dependencies are down don't get run this time around.
+		// Decide on all of them before changing any statuses.
+		var skipped []*Check
+		for _, check := range checks {
+			if !dependencyHealthy(check, checks) {
+				skipped = append(skipped, check)
+			}
+		}
+		for _, check := range skipped {
+			log.Debugf("Skipping check %s, %s is not healthy", check.ID, check.DependsOn)
+			check.lock.Lock()
+			check.Status = UNKNOWN
+			check.lock.Unlock()
+			delete(checks, check.ID)
+		}
+
+		// Disabled checks keep their last status, which is what checks that
+		// depend on them go by
+		now := m.clock().Now()
+		for id, check := range checks {
+			if check.Disabled(now) {
+				delete(checks, id)
+			}
+		}
+
 		wg.Add(len(checks))
 		for _, check := range checks {
 			// Run all checks in parallel in goroutines
 			resultChan := make(chan checkResult, 1)
 
 			go func(check *Check, resultChan chan checkResult) {
-				result, err := check.Command.Run(check.Args)
-				resultChan <- checkResult{result, err}
+				start := m.clock().Now()
+				results := runChecker(check.Command, check.Args)
+				resultChan <- checkResult{results, m.clock().Now().Sub(start)}
 			}(check, resultChan) // copy check pointer for the goroutine
 
 			go func(check *Check, resultChan chan checkResult) {
@@ -194,10 +324,22 @@ func (m *Monitor) Run(looper director.Looper) {
 				// m.CheckInterval.
 				select {
 				case result := <-resultChan:
-					check.UpdateStatus(result.status, result.err)
-				case <-time.After(m.CheckInterval - 1*time.Millisecond):
+					now := m.clock().Now()
+					check.lock.Lock()
+					check.applyResults(result.results, now)
+					check.LastRun = newCheckInfo(result.results, result.latency, now)
+					if check.Status == HEALTHY {
+						check.LastLatency = result.latency
+					}
+					check.lock.Unlock()
+				case <-m.clock().After(m.CheckInterval - 1*time.Millisecond):
 					log.Errorf("Error, check %s timed out! (%v)", check.ID, check.Args)
-					check.UpdateStatus(UNKNOWN, errors.New("Timed out!"))
+					now := m.clock().Now()
+					results := []RunResult{{UNKNOWN, errors.New("Timed out!")}}
+					check.lock.Lock()
+					check.applyResults(results, now)
+					check.LastRun = newCheckInfo(results, m.CheckInterval, now)
+					check.lock.Unlock()
 				}
 			}(check, resultChan) // copy check pointer for the goroutine
 		}
@@ -212,7 +354,45 @@ func (m *Monitor) Run(looper director.Looper) {
 	})
 }
 
+// quitOnDone stops the looper when the context is cancelled
+func quitOnDone(ctx context.Context, looper director.Looper) {
+	<-ctx.Done()
+	looper.Quit()
+}
+
 type checkResult struct {
-	status int
-	err    error
+	results []RunResult
+	latency time.Duration
+}
+
+// newCheckInfo describes a run of a check for the services it's on. The
+// output we hash is the errors, which is all the Checkers tell us about.
+func newCheckInfo(results []RunResult, latency time.Duration, now time.Time) service.CheckInfo {
+	info := service.CheckInfo{Time: now.UTC(), Latency: latency}
+
+	hash := fnv.New64a()
+	var hasOutput bool
+	for _, result := range results {
+		if result.Err != nil {
+			hash.Write([]byte(result.Err.Error()))
+			hash.Write([]byte{0})
+			hasOutput = true
+		}
+	}
+	if hasOutput {
+		info.OutputHash = strconv.FormatUint(hash.Sum64(), 16)
+	}
+
+	return info
+}
+
+// runChecker runs the command, with a result for each of the checks when
+// it's a MultiCmd
+func runChecker(command Checker, args string) []RunResult {
+	if multi, ok := command.(*MultiCmd); ok && len(multi.Checks) > 0 {
+		return multi.RunEach()
+	}
+
+	status, err := command.Run(args)
+	return []RunResult{{status, err}}
 }
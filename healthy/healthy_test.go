@@ -1,10 +1,12 @@
 package healthy
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
@@ -40,6 +42,18 @@ func Test_AddCheck(t *testing.T) {
 		monitor.AddCheck(&Check{ID: "234"})
 		So(len(monitor.Checks), ShouldEqual, 2)
 	})
+
+	Convey("Gives checks the default policy unless they have one", t, func() {
+		monitor := NewMonitor(hostname, "/")
+		monitor.StatusPolicy = StrictPolicy{}
+
+		monitor.AddCheck(&Check{ID: "123"})
+		monitor.AddCheck(&Check{ID: "234", Policy: QuorumPolicy{}})
+
+		So(monitor.Checks["123"].Policy, ShouldResemble, StrictPolicy{})
+		So(monitor.Checks["234"].Policy, ShouldResemble, QuorumPolicy{})
+		So(monitor.Checks["123"].Added.IsZero(), ShouldBeFalse)
+	})
 }
 
 type mockCommand struct {
@@ -62,6 +76,17 @@ func (s *slowCommand) Run(args string) (int, error) {
 	return HEALTHY, nil
 }
 
+// A command that takes a while on a frozen clock
+type frozenCommand struct {
+	clock *clock.Frozen
+	took  time.Duration
+}
+
+func (f *frozenCommand) Run(args string) (int, error) {
+	f.clock.Advance(f.took)
+	return HEALTHY, nil
+}
+
 func Test_RunningChecks(t *testing.T) {
 	Convey("Working with health checks", t, func() {
 		monitor := NewMonitor(hostname, "/")
@@ -76,14 +101,22 @@ func Test_RunningChecks(t *testing.T) {
 		looper := director.NewFreeLooper(director.ONCE, nil)
 
 		Convey("The Check Command gets evaluated", func() {
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 			So(cmd.CallCount, ShouldEqual, 1)
 			So(cmd.LastArgs, ShouldEqual, "testing")
 			So(cmd.DesiredResult, ShouldEqual, HEALTHY) // We know it's our cmd
 		})
 
+		Convey("Stops running when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			monitor.Run(ctx, director.NewTimedLooper(director.FOREVER, 1*time.Hour, nil))
+			So(cmd.CallCount, ShouldEqual, 0)
+		})
+
 		Convey("Healthy Checks are marked healthy", func() {
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 			So(cmd.CallCount, ShouldEqual, 1)
 			So(cmd.LastArgs, ShouldEqual, "testing")
 			So(check.Status, ShouldEqual, HEALTHY)
@@ -98,7 +131,7 @@ func Test_RunningChecks(t *testing.T) {
 				MaxCount: 3,
 			}
 			monitor.AddCheck(badCheck)
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 
 			So(fail.CallCount, ShouldEqual, 1)
 			So(badCheck.Status, ShouldEqual, SICKLY)
@@ -113,7 +146,7 @@ func Test_RunningChecks(t *testing.T) {
 				MaxCount: 3,
 			}
 			monitor.AddCheck(badCheck)
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 
 			So(fail.CallCount, ShouldEqual, 1)
 			So(badCheck.Status, ShouldEqual, UNKNOWN)
@@ -129,7 +162,7 @@ func Test_RunningChecks(t *testing.T) {
 				MaxCount: maxCount,
 			}
 			monitor.AddCheck(badCheck)
-			monitor.Run(director.NewFreeLooper(maxCount, nil))
+			monitor.Run(context.Background(), director.NewFreeLooper(maxCount, nil))
 			So(fail.CallCount, ShouldEqual, maxCount)
 			So(badCheck.Count, ShouldEqual, maxCount)
 			So(badCheck.Status, ShouldEqual, FAILED)
@@ -145,7 +178,7 @@ func Test_RunningChecks(t *testing.T) {
 				Count:   2,
 			}
 			monitor.AddCheck(badCheck)
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 			So(badCheck.Count, ShouldEqual, 0)
 			So(badCheck.Status, ShouldEqual, HEALTHY)
 
@@ -162,12 +195,115 @@ func Test_RunningChecks(t *testing.T) {
 			}
 			monitor.AddCheck(check)
 			monitor.CheckInterval = 1 * time.Millisecond
-			monitor.Run(looper)
+			monitor.Run(context.Background(), looper)
 
 			So(check.Status, ShouldEqual, UNKNOWN)
 			So(check.LastError.Error(), ShouldEqual, "Timed out!")
 		})
 
+		Convey("Checks are timed by the Monitor's Clock", func() {
+			frozen := clock.NewFrozen(time.Now())
+			monitor.Clock = frozen
+			check := &Check{
+				ID:      "test",
+				Type:    "mock",
+				Status:  FAILED,
+				Command: &frozenCommand{clock: frozen, took: 250 * time.Millisecond},
+			}
+			monitor.AddCheck(check)
+			monitor.Run(context.Background(), looper)
+
+			So(check.Status, ShouldEqual, HEALTHY)
+			So(check.LastLatency, ShouldEqual, 250*time.Millisecond)
+			So(check.LastRun.Time, ShouldEqual, frozen.Now().UTC())
+			So(check.LastRun.Latency, ShouldEqual, 250*time.Millisecond)
+			So(check.LastRun.OutputHash, ShouldBeEmpty)
+		})
+
+		Convey("Records the last run whatever the result", func() {
+			check := &Check{
+				ID:      "test",
+				Type:    "mock",
+				Status:  HEALTHY,
+				Command: &mockCommand{DesiredResult: FAILED, Error: errors.New("connection refused")},
+			}
+			monitor.AddCheck(check)
+			monitor.Run(context.Background(), looper)
+
+			So(check.LastRun.Time.IsZero(), ShouldBeFalse)
+			So(check.LastRun.OutputHash, ShouldNotBeEmpty)
+
+			first := check.LastRun.OutputHash
+			check.Command = &mockCommand{DesiredResult: FAILED, Error: errors.New("timed out")}
+			monitor.Run(context.Background(), director.NewTimedLooper(1, time.Nanosecond, nil))
+			So(check.LastRun.OutputHash, ShouldNotEqual, first)
+		})
+
+		Convey("Services can be marked while the checks run", func() {
+			check := &Check{
+				ID:      "test",
+				Type:    "mock",
+				Status:  FAILED,
+				Command: &mockCommand{DesiredResult: HEALTHY},
+			}
+			monitor.AddCheck(check)
+
+			done := make(chan struct{})
+			go func() {
+				monitor.Run(context.Background(), director.NewTimedLooper(20, time.Millisecond, nil))
+				close(done)
+			}()
+
+			svc := service.Service{ID: "test"}
+			for running := true; running; {
+				select {
+				case <-done:
+					running = false
+				default:
+					monitor.MarkService(&svc)
+				}
+			}
+
+			monitor.MarkService(&svc)
+			So(svc.Status, ShouldEqual, service.ALIVE)
+			So(svc.LastCheck, ShouldNotBeNil)
+		})
+
+		Convey("Checks whose dependency is down are skipped and marked UNKNOWN", func() {
+			fail := mockCommand{DesiredResult: FAILED}
+			database := &Check{
+				ID:          "db",
+				Type:        "mock",
+				Status:      FAILED,
+				Command:     &fail,
+				ServiceName: "database",
+				MaxCount:    3,
+			}
+			dependent := mockCommand{DesiredResult: HEALTHY}
+			web := &Check{
+				ID:          "web",
+				Type:        "mock",
+				Status:      HEALTHY,
+				Command:     &dependent,
+				ServiceName: "web",
+				DependsOn:   "database",
+			}
+			monitor.AddCheck(database)
+			monitor.AddCheck(web)
+			monitor.Run(context.Background(), looper)
+
+			So(fail.CallCount, ShouldEqual, 1)
+			So(dependent.CallCount, ShouldEqual, 0)
+			So(web.Status, ShouldEqual, UNKNOWN)
+
+			database.Status = HEALTHY
+			fail.DesiredResult = HEALTHY
+			monitor.Run(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+
+			So(dependent.CallCount, ShouldEqual, 1)
+			So(web.Status, ShouldEqual, HEALTHY)
+		})
+
 		Convey("Checks that had an error become UNKNOWN on first pass", func() {
 			check := NewCheck("test")
 			check.Command = &slowCommand{}
@@ -233,7 +369,7 @@ func Test_MarkingServices(t *testing.T) {
 			},
 		)
 
-		monitor.Run(looper)
+		monitor.Run(context.Background(), looper)
 
 		svcList := monitor.Services()
 
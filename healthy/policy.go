@@ -0,0 +1,119 @@
+package healthy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_GRACE_PERIOD = 2 * time.Minute
+)
+
+// A StatusPolicy decides what a check's status is after each run. It gets
+// the results of the run, one for each check of a MultiCmd or just the one
+// otherwise, and updates the check's Status and Count.
+type StatusPolicy interface {
+	Apply(check *Check, results []RunResult, now time.Time)
+}
+
+// ParseStatusPolicy returns the named policy: "threshold", "strict",
+// "quorum", or "grace". A grace period other than the default can be given
+// after a colon, e.g. "grace:5m". Empty is the default, threshold.
+func ParseStatusPolicy(spec string) (StatusPolicy, error) {
+	name, arg := strings.ToLower(strings.TrimSpace(spec)), ""
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, arg = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+	}
+
+	if name != "grace" && arg != "" {
+		return nil, fmt.Errorf("check policy %q takes no arguments", name)
+	}
+
+	switch name {
+	case "", "threshold":
+		return ThresholdPolicy{}, nil
+	case "strict":
+		return StrictPolicy{}, nil
+	case "quorum":
+		return QuorumPolicy{}, nil
+	case "grace":
+		period := DEFAULT_GRACE_PERIOD
+		if arg != "" {
+			var err error
+			period, err = time.ParseDuration(arg)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid grace period %q", arg)
+			}
+		}
+		return GracePolicy{Period: period}, nil
+	default:
+		return nil, fmt.Errorf("unknown check policy %q", spec)
+	}
+}
+
+// ThresholdPolicy combines the results the way the check's MultiCmd says
+// to, and fails the check once it hasn't been healthy MaxCount times in a
+// row. This is the default.
+type ThresholdPolicy struct{}
+
+func (ThresholdPolicy) Apply(check *Check, results []RunResult, now time.Time) {
+	result := combineResults(results, check.requiresAll())
+	check.UpdateStatus(result.Status, result.Err)
+}
+
+// StrictPolicy fails the check as soon as any one of its results isn't
+// healthy, whatever the aggregation or MaxCount
+type StrictPolicy struct{}
+
+func (StrictPolicy) Apply(check *Check, results []RunResult, now time.Time) {
+	result := combineResults(results, true)
+	check.UpdateStatus(result.Status, result.Err)
+
+	if check.Status != HEALTHY {
+		check.Status = FAILED
+	}
+}
+
+// QuorumPolicy counts a run as healthy when more than half of its results
+// are. Otherwise it takes the worst result, and fails the check like
+// ThresholdPolicy.
+type QuorumPolicy struct{}
+
+func (QuorumPolicy) Apply(check *Check, results []RunResult, now time.Time) {
+	healthy := 0
+	for _, result := range results {
+		if result.Err == nil && result.Status == HEALTHY {
+			healthy++
+		}
+	}
+
+	if healthy*2 > len(results) {
+		check.UpdateStatus(HEALTHY, nil)
+		return
+	}
+
+	result := combineResults(results, true)
+	check.UpdateStatus(result.Status, result.Err)
+}
+
+// GracePolicy gives services time to start up after a deploy. Until Period
+// has passed since the check was added, a failed check is UNKNOWN rather
+// than FAILED, so the service isn't announced as unhealthy. Otherwise it
+// works like Policy.
+type GracePolicy struct {
+	Period time.Duration
+	Policy StatusPolicy // nil for ThresholdPolicy
+}
+
+func (g GracePolicy) Apply(check *Check, results []RunResult, now time.Time) {
+	policy := g.Policy
+	if policy == nil {
+		policy = ThresholdPolicy{}
+	}
+	policy.Apply(check, results, now)
+
+	if check.Status == FAILED && now.Sub(check.Added) < g.Period {
+		check.Status = UNKNOWN
+	}
+}
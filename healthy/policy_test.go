@@ -0,0 +1,99 @@
+package healthy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ParseStatusPolicy(t *testing.T) {
+	Convey("ParseStatusPolicy()", t, func() {
+		Convey("knows the built in policies", func() {
+			for spec, expected := range map[string]StatusPolicy{
+				"":          ThresholdPolicy{},
+				"threshold": ThresholdPolicy{},
+				"Strict":    StrictPolicy{},
+				" quorum ":  QuorumPolicy{},
+				"grace":     GracePolicy{Period: DEFAULT_GRACE_PERIOD},
+				"grace:30s": GracePolicy{Period: 30 * time.Second},
+			} {
+				policy, err := ParseStatusPolicy(spec)
+				So(err, ShouldBeNil)
+				So(policy, ShouldResemble, expected)
+			}
+		})
+
+		Convey("rejects junk", func() {
+			for _, spec := range []string{"lenient", "quorum:3", "grace:soon", "grace:-1m"} {
+				_, err := ParseStatusPolicy(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_StatusPolicies(t *testing.T) {
+	Convey("Status policies", t, func() {
+		now := time.Now().UTC()
+		check := NewCheck("deadbeef")
+		check.MaxCount = 2
+		check.Added = now
+
+		healthy := RunResult{Status: HEALTHY}
+		sickly := RunResult{Status: SICKLY}
+		broken := RunResult{Status: UNKNOWN, Err: errors.New("oh no")}
+
+		Convey("ThresholdPolicy fails the check after MaxCount runs", func() {
+			ThresholdPolicy{}.Apply(check, []RunResult{healthy, sickly}, now)
+			So(check.Status, ShouldEqual, SICKLY)
+
+			ThresholdPolicy{}.Apply(check, []RunResult{healthy, sickly}, now)
+			So(check.Status, ShouldEqual, FAILED)
+		})
+
+		Convey("ThresholdPolicy uses the aggregation of the MultiCmd", func() {
+			check.Command = &MultiCmd{RequireAll: false}
+
+			ThresholdPolicy{}.Apply(check, []RunResult{healthy, sickly}, now)
+			So(check.Status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("StrictPolicy fails the check on any failing result", func() {
+			check.Command = &MultiCmd{RequireAll: false}
+
+			StrictPolicy{}.Apply(check, []RunResult{healthy, sickly}, now)
+			So(check.Status, ShouldEqual, FAILED)
+
+			StrictPolicy{}.Apply(check, []RunResult{healthy, healthy}, now)
+			So(check.Status, ShouldEqual, HEALTHY)
+			So(check.Count, ShouldEqual, 0)
+		})
+
+		Convey("QuorumPolicy needs more than half of the results to be healthy", func() {
+			QuorumPolicy{}.Apply(check, []RunResult{healthy, healthy, broken}, now)
+			So(check.Status, ShouldEqual, HEALTHY)
+
+			QuorumPolicy{}.Apply(check, []RunResult{healthy, sickly}, now)
+			So(check.Status, ShouldEqual, SICKLY)
+
+			QuorumPolicy{}.Apply(check, []RunResult{healthy, broken, broken}, now)
+			So(check.Status, ShouldEqual, FAILED)
+			So(check.LastError, ShouldNotBeNil)
+		})
+
+		Convey("GracePolicy doesn't fail the check during the grace period", func() {
+			policy := GracePolicy{Period: time.Minute, Policy: StrictPolicy{}}
+
+			policy.Apply(check, []RunResult{sickly}, now.Add(59*time.Second))
+			So(check.Status, ShouldEqual, UNKNOWN)
+
+			policy.Apply(check, []RunResult{healthy}, now.Add(59*time.Second))
+			So(check.Status, ShouldEqual, HEALTHY)
+
+			policy.Apply(check, []RunResult{sickly}, now.Add(time.Minute))
+			So(check.Status, ShouldEqual, FAILED)
+		})
+	})
+}
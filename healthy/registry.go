@@ -0,0 +1,156 @@
+package healthy
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrNoSuchCheck = errors.New("no such check")
+
+// A CheckStatus is a copy of where a Check stands, for reporting
+type CheckStatus struct {
+	ID             string
+	ServiceName    string
+	Type           string
+	Args           string
+	Status         string
+	Count          int // Runs in a row that weren't healthy
+	MaxCount       int
+	LastError      string             `json:",omitempty"`
+	LastLatency    time.Duration      // Of the last healthy run
+	LastRun        *service.CheckInfo `json:",omitempty"` // nil until it has run
+	DependsOn      string             `json:",omitempty"`
+	Added          time.Time
+	DisabledUntil  *time.Time `json:",omitempty"` // nil when it's enabled
+	DisabledReason string     `json:",omitempty"`
+}
+
+// StatusName returns the name of a check status
+func StatusName(status int) string {
+	switch status {
+	case HEALTHY:
+		return "HEALTHY"
+	case SICKLY:
+		return "SICKLY"
+	case FAILED:
+		return "FAILED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Disabled tells whether the check is silenced at the time passed
+func (check *Check) Disabled(now time.Time) bool {
+	return now.Before(check.DisabledUntil)
+}
+
+// CheckStatuses returns where each check stands, sorted by ID. Handles
+// synchronization.
+func (m *Monitor) CheckStatuses() []CheckStatus {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := m.clock().Now()
+	statuses := make([]CheckStatus, 0, len(m.Checks))
+	for _, check := range m.Checks {
+		statuses = append(statuses, check.statusAt(now))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+
+	return statuses
+}
+
+// DisableCheck silences a check for the TTL, e.g. while a known issue is
+// worked on. The check isn't run, and its service is announced as ALIVE
+// whatever the check said last. Disabling it again replaces the TTL and
+// reason. Handles synchronization.
+func (m *Monitor) DisableCheck(id string, ttl time.Duration, reason string) (CheckStatus, error) {
+	if ttl <= 0 {
+		return CheckStatus{}, errors.New("the TTL must be above 0")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	check, ok := m.Checks[id]
+	if !ok {
+		return CheckStatus{}, ErrNoSuchCheck
+	}
+
+	now := m.clock().Now()
+	check.DisabledUntil = now.Add(ttl)
+	check.DisabledReason = reason
+
+	log.Warnf("Disabled health check for %s (id: %s) for %s: %s", check.ServiceName, check.ID, ttl, reason)
+
+	return check.statusAt(now), nil
+}
+
+// EnableCheck turns a disabled check back on before its TTL runs out. It is
+// run again on the next round. Its last run is from before it was disabled,
+// so we forget it rather than announce it as too old to serve. Handles
+// synchronization.
+func (m *Monitor) EnableCheck(id string) (CheckStatus, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	check, ok := m.Checks[id]
+	if !ok {
+		return CheckStatus{}, ErrNoSuchCheck
+	}
+
+	wasDisabled := check.Disabled(m.clock().Now())
+	check.DisabledUntil = time.Time{}
+	check.DisabledReason = ""
+
+	if wasDisabled {
+		check.lock.Lock()
+		check.LastRun = service.CheckInfo{}
+		check.lock.Unlock()
+	}
+
+	log.Infof("Enabled health check for %s (id: %s)", check.ServiceName, check.ID)
+
+	return check.statusAt(m.clock().Now()), nil
+}
+
+// statusAt copies where the check stands at the time passed
+func (check *Check) statusAt(now time.Time) CheckStatus {
+	check.lock.Lock()
+	defer check.lock.Unlock()
+
+	status := CheckStatus{
+		ID:          check.ID,
+		ServiceName: check.ServiceName,
+		Type:        check.Type,
+		Args:        check.Args,
+		Status:      StatusName(check.Status),
+		Count:       check.Count,
+		MaxCount:    check.MaxCount,
+		LastLatency: check.LastLatency,
+		DependsOn:   check.DependsOn,
+		Added:       check.Added,
+	}
+
+	if check.LastError != nil {
+		status.LastError = check.LastError.Error()
+	}
+
+	if !check.LastRun.Time.IsZero() {
+		lastRun := check.LastRun
+		status.LastRun = &lastRun
+	}
+
+	if check.Disabled(now) {
+		disabledUntil := check.DisabledUntil
+		status.DisabledUntil = &disabledUntil
+		status.DisabledReason = check.DisabledReason
+	}
+
+	return status
+}
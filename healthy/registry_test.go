@@ -0,0 +1,113 @@
+package healthy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/clock"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CheckRegistry(t *testing.T) {
+	Convey("The check registry", t, func() {
+		frozen := clock.NewFrozen(time.Now())
+		monitor := NewMonitor(hostname, "/")
+		monitor.Clock = frozen
+
+		cmd := mockCommand{DesiredResult: SICKLY}
+		check := &Check{
+			ID:          "deadbeef123",
+			ServiceName: "bocaccio",
+			Type:        "mock",
+			Args:        "testing",
+			Command:     &cmd,
+			MaxCount:    1,
+			LastError:   errors.New("oh no"),
+		}
+		monitor.AddCheck(check)
+		monitor.AddCheck(&Check{ID: "abba", Type: "mock", Command: &mockCommand{DesiredResult: HEALTHY}})
+
+		looper := director.NewFreeLooper(director.ONCE, nil)
+
+		Convey("lists the checks by ID", func() {
+			monitor.Run(context.Background(), looper)
+
+			statuses := monitor.CheckStatuses()
+			So(len(statuses), ShouldEqual, 2)
+			So(statuses[0].ID, ShouldEqual, "abba")
+			So(statuses[1].ID, ShouldEqual, "deadbeef123")
+			So(statuses[1].ServiceName, ShouldEqual, "bocaccio")
+			So(statuses[1].Status, ShouldEqual, "FAILED")
+			So(statuses[1].Count, ShouldEqual, 1)
+			So(statuses[1].LastError, ShouldEqual, "oh no")
+			So(statuses[1].LastRun, ShouldNotBeNil)
+			So(statuses[1].DisabledUntil, ShouldBeNil)
+		})
+
+		Convey("disables checks", func() {
+			status, err := monitor.DisableCheck("deadbeef123", time.Hour, "known issue")
+			So(err, ShouldBeNil)
+			So(*status.DisabledUntil, ShouldEqual, frozen.Now().Add(time.Hour))
+			So(status.DisabledReason, ShouldEqual, "known issue")
+
+			Convey("which aren't run", func() {
+				monitor.Run(context.Background(), looper)
+				So(cmd.CallCount, ShouldEqual, 0)
+			})
+
+			Convey("and don't hold their service back", func() {
+				check.Status = FAILED
+				svc := service.Service{ID: "deadbeef123"}
+				monitor.MarkService(&svc)
+				So(svc.Status, ShouldEqual, service.ALIVE)
+			})
+
+			Convey("and don't announce a last check that only gets older", func() {
+				check.LastRun = service.CheckInfo{Time: frozen.Now().Add(-time.Hour)}
+				svc := service.Service{ID: "deadbeef123"}
+				monitor.MarkService(&svc)
+				So(svc.LastCheck, ShouldBeNil)
+			})
+
+			Convey("until the TTL runs out", func() {
+				frozen.Advance(time.Hour)
+
+				monitor.Run(context.Background(), looper)
+				So(cmd.CallCount, ShouldEqual, 1)
+				So(monitor.CheckStatuses()[1].DisabledUntil, ShouldBeNil)
+			})
+
+			Convey("until they're enabled again", func() {
+				check.LastRun = service.CheckInfo{Time: frozen.Now().Add(-time.Hour)}
+				status, err := monitor.EnableCheck("deadbeef123")
+				So(err, ShouldBeNil)
+				So(status.DisabledUntil, ShouldBeNil)
+
+				// Its last run is from before it was disabled
+				svc := service.Service{ID: "deadbeef123"}
+				monitor.MarkService(&svc)
+				So(svc.LastCheck, ShouldBeNil)
+
+				monitor.Run(context.Background(), looper)
+				So(cmd.CallCount, ShouldEqual, 1)
+			})
+		})
+
+		Convey("needs a TTL to disable a check", func() {
+			_, err := monitor.DisableCheck("deadbeef123", 0, "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("doesn't find checks it doesn't have", func() {
+			_, err := monitor.DisableCheck("missing", time.Hour, "")
+			So(err, ShouldEqual, ErrNoSuchCheck)
+
+			_, err = monitor.EnableCheck("missing")
+			So(err, ShouldEqual, ErrNoSuchCheck)
+		})
+	})
+}
@@ -2,7 +2,10 @@ package healthy
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"text/template"
 
 	"github.com/NinesStack/sidecar/discovery"
@@ -47,19 +50,25 @@ func findFirstTCPPort(svc *service.Service) *service.Port {
 
 // Configure a default check for a service. The default is to return an HTTP
 // check on the first TCP port on the endpoint set in DEFAULT_STATUS_ENDPOINT.
+// Services proxied in TCP mode don't speak HTTP, so they get a TcpConnect
+// check on that port instead.
 func (m *Monitor) defaultCheckForService(svc *service.Service) *Check {
 	port := findFirstTCPPort(svc)
 	if port == nil {
 		return &Check{ID: svc.ID, Command: &AlwaysSuccessfulCmd{}}
 	}
 
-	// Use the const default unless we've been provided something else
-	defaultCheckEndpoint := DEFAULT_STATUS_ENDPOINT
-	if len(m.DefaultCheckEndpoint) != 0 {
-		defaultCheckEndpoint = m.DefaultCheckEndpoint
+	if svc.ProxyMode == "tcp" {
+		return &Check{
+			ID:      svc.ID,
+			Type:    "TcpConnect",
+			Args:    net.JoinHostPort(m.DefaultCheckHost, strconv.FormatInt(port.Port, 10)),
+			Status:  FAILED,
+			Command: m.newTcpConnectCmd(),
+		}
 	}
 
-	url := fmt.Sprintf("http://%v:%v%v", m.DefaultCheckHost, port.Port, defaultCheckEndpoint)
+	url := fmt.Sprintf("http://%v:%v%v", m.DefaultCheckHost, port.Port, m.defaultCheckEndpoint())
 	return &Check{
 		ID:      svc.ID,
 		Type:    "HttpGet",
@@ -77,6 +86,8 @@ func (m *Monitor) GetCommandNamed(name string) Checker {
 		return &ExternalCmd{}
 	case "AlwaysSuccessful":
 		return &AlwaysSuccessfulCmd{}
+	case "TcpConnect":
+		return m.newTcpConnectCmd()
 	default:
 		return &HttpGetCmd{}
 	}
@@ -87,22 +98,139 @@ func (m *Monitor) fetchCheckForService(svc *service.Service, disco discovery.Dis
 
 	check := &Check{}
 	check.Type, check.Args = disco.HealthCheck(svc)
-	if check.Type == "" {
+
+	var portChecks []discovery.PortCheck
+	if provider, ok := disco.(discovery.PortCheckProvider); ok {
+		portChecks = provider.PortHealthChecks(svc)
+	}
+
+	if check.Type == "" && len(portChecks) == 0 {
 		log.Warnf("Got empty check type for service %s (id: %s) with args: %s!", svc.Name, svc.ID, check.Args)
 		return nil
 	}
 
 	// Setup some other parts of the check that don't come from discovery
 	check.ID = svc.ID
-	check.Command = m.GetCommandNamed(check.Type)
 	check.Status = FAILED
 
+	var opts discovery.CheckOptions
+	if provider, ok := disco.(discovery.CheckOptionsProvider); ok {
+		opts = provider.HealthCheckOptions(svc)
+	}
+	check.DependsOn = opts.DependsOn
+
+	aggregation := opts.Aggregation
+	if aggregation == "" {
+		aggregation = m.CheckAggregation
+	}
+
+	if opts.Policy != "" {
+		policy, err := ParseStatusPolicy(opts.Policy)
+		if err != nil {
+			log.Errorf("Bad check policy for service %s (id: %s), using the default: %s",
+				svc.Name, svc.ID, err,
+			)
+		} else {
+			check.Policy = policy
+		}
+	}
+
+	// Only the HTTP settings are left for the commands
+	opts.DependsOn = ""
+	opts.Aggregation = ""
+	opts.Policy = ""
+
+	if len(portChecks) == 0 {
+		check.Command = m.commandForService(check.Type, opts, svc)
+		return check
+	}
+
+	// Several checks, which are combined into one
+	multi := &MultiCmd{RequireAll: RequireAllChecks(aggregation)}
+	if check.Type != "" {
+		multi.Checks = append(multi.Checks, &SubCheck{
+			Type:    check.Type,
+			Args:    check.Args,
+			Command: m.commandForService(check.Type, opts, svc),
+		})
+	}
+
+	for _, portCheck := range portChecks {
+		args := portCheck.Args
+		if args == "" && portCheck.Type == "HttpGet" {
+			args = fmt.Sprintf("http://{{ host }}:{{ tcp %d }}%s", portCheck.Port, m.defaultCheckEndpoint())
+		}
+		if args == "" && portCheck.Type == "TcpConnect" {
+			args = fmt.Sprintf("{{ host }}:{{ tcp %d }}", portCheck.Port)
+		}
+
+		multi.Checks = append(multi.Checks, &SubCheck{
+			Port:    portCheck.Port,
+			Type:    portCheck.Type,
+			Args:    args,
+			Command: m.commandForService(portCheck.Type, opts, svc),
+		})
+	}
+
+	check.Type = "Multi"
+	check.Args = ""
+	check.Command = multi
+
 	return check
 }
 
+// commandForService returns the named Checker, applying any extra settings
+// to HTTP and TCP checks
+func (m *Monitor) commandForService(name string, opts discovery.CheckOptions, svc *service.Service) Checker {
+	command := m.GetCommandNamed(name)
+
+	if cmd, ok := command.(*TcpConnectCmd); ok {
+		err := cmd.ApplyOptions(opts)
+		if err != nil {
+			log.Errorf("Bad check options for service %s (id: %s), using defaults: %s",
+				svc.Name, svc.ID, err,
+			)
+			return m.newTcpConnectCmd()
+		}
+		return cmd
+	}
+
+	if _, ok := command.(*HttpGetCmd); ok && opts != (discovery.CheckOptions{}) {
+		cmd, err := NewHttpGetCmd(opts)
+		if err != nil {
+			log.Errorf("Bad check options for service %s (id: %s), using defaults: %s",
+				svc.Name, svc.ID, err,
+			)
+		} else {
+			command = cmd
+		}
+	}
+
+	return command
+}
+
+// newTcpConnectCmd returns a TcpConnectCmd with the monitor's settings
+func (m *Monitor) newTcpConnectCmd() *TcpConnectCmd {
+	return &TcpConnectCmd{
+		Timeout:           m.TcpCheckTimeout,
+		HalfOpenWait:      m.HalfOpenWait,
+		HalfOpenThreshold: m.HalfOpenThreshold,
+	}
+}
+
+// defaultCheckEndpoint returns the endpoint for HTTP checks that weren't
+// given a URL. Uses the const default unless we've been provided something
+// else.
+func (m *Monitor) defaultCheckEndpoint() string {
+	if len(m.DefaultCheckEndpoint) != 0 {
+		return m.DefaultCheckEndpoint
+	}
+	return DEFAULT_STATUS_ENDPOINT
+}
+
 // Use templating to substitute in some info about the service.  Important because
 // we won't know the actual Port that the container will bind to, for example.
-func (m *Monitor) templateCheckArgs(check *Check, svc *service.Service) string {
+func (m *Monitor) templateCheckArgs(args string, svc *service.Service) string {
 	funcMap := template.FuncMap{
 		"tcp":       func(p int64) int64 { return svc.PortForServicePort(p, "tcp") },
 		"udp":       func(p int64) int64 { return svc.PortForServicePort(p, "udp") },
@@ -110,17 +238,17 @@ func (m *Monitor) templateCheckArgs(check *Check, svc *service.Service) string {
 		"container": func() string { return svc.Hostname },
 	}
 
-	t, err := template.New("check").Funcs(funcMap).Parse(check.Args)
+	t, err := template.New("check").Funcs(funcMap).Parse(args)
 	if err != nil {
-		log.Errorf("Unable to parse check Args: '%s'", check.Args)
-		return check.Args
+		log.Errorf("Unable to parse check Args: '%s'", args)
+		return args
 	}
 
 	var output bytes.Buffer
 	err = t.Execute(&output, svc)
 	if err != nil {
-		log.Errorf("Unable to execute template: '%s'", check.Args)
-		return check.Args
+		log.Errorf("Unable to execute template: '%s'", args)
+		return args
 	}
 
 	return output.String()
@@ -130,12 +258,25 @@ func (m *Monitor) templateCheckArgs(check *Check, svc *service.Service) string {
 // particular service.
 func (m *Monitor) CheckForService(svc *service.Service, disco discovery.Discoverer) *Check {
 	check := m.fetchCheckForService(svc, disco)
-	if check == nil { // We got nothing
+	if check == nil { // We got nothing, try the defaults for the image
+		if checkDefault := m.checkDefaultFor(svc); checkDefault != nil {
+			log.Infof("Using the %s check default for service %s (id: %s).", checkDefault.Image, svc.Name, svc.ID)
+			check = m.checkFromDefault(svc, checkDefault)
+		}
+	}
+
+	if check == nil { // Still nothing
 		log.Warnf("Using default check for service %s (id: %s).", svc.Name, svc.ID)
 		check = m.defaultCheckForService(svc)
 	}
 
-	check.Args = m.templateCheckArgs(check, svc)
+	check.Args = m.templateCheckArgs(check.Args, svc)
+	if multi, ok := check.Command.(*MultiCmd); ok {
+		for _, subCheck := range multi.Checks {
+			subCheck.Args = m.templateCheckArgs(subCheck.Args, svc)
+		}
+	}
+	check.ServiceName = svc.Name
 
 	return check
 }
@@ -143,9 +284,11 @@ func (m *Monitor) CheckForService(svc *service.Service, disco discovery.Discover
 // Watch loops over a list of services and adds checks for services we don't already
 // know about. It then removes any checks for services which have gone away. All
 // services are expected to be local to this node.
-func (m *Monitor) Watch(disco discovery.Discoverer, looper director.Looper) {
+func (m *Monitor) Watch(ctx context.Context, disco discovery.Discoverer, looper director.Looper) {
 	m.DiscoveryFn = disco.Services // Store this so we can use it from Services()
 
+	go quitOnDone(ctx, looper)
+
 	looper.Loop(func() error {
 		services := disco.Services()
 
@@ -185,3 +328,73 @@ func (m *Monitor) Watch(disco discovery.Discoverer, looper director.Looper) {
 		return nil
 	})
 }
+
+// remoteCheckForService configures a check for a service that is hosted on
+// another node. We can't ask discovery about it, so this is always an HTTP
+// check on the first TCP port, using the address the service was announced
+// with. Returns nil when the service can't be checked remotely.
+func (m *Monitor) remoteCheckForService(svc *service.Service) *Check {
+	port := findFirstTCPPort(svc)
+	if port == nil {
+		return nil
+	}
+
+	host := port.IP
+	if len(host) == 0 {
+		host = svc.Hostname
+	}
+
+	check := NewCheck(svc.ID)
+	check.Type = "HttpGet"
+	check.Args = fmt.Sprintf("http://%v:%v%v", host, port.Port, m.defaultCheckEndpoint())
+
+	return check
+}
+
+// WatchRemote is the checker node counterpart to Watch(). Rather than talking
+// to discovery, it loops over the services returned by fn, which are expected
+// to be running on other nodes, and keeps an HTTP check configured for each of
+// them. Checks start out UNKNOWN so we never report on a remote service before
+// we've actually checked it. Checks for services which have gone away are
+// removed.
+func (m *Monitor) WatchRemote(ctx context.Context, fn func() []service.Service, looper director.Looper) {
+	m.DiscoveryFn = fn // Store this so we can use it from Services()
+
+	go quitOnDone(ctx, looper)
+
+	looper.Loop(func() error {
+		services := fn()
+
+		for _, svc := range services {
+			m.RLock()
+			_, ok := m.Checks[svc.ID]
+			m.RUnlock()
+
+			if ok {
+				continue
+			}
+
+			check := m.remoteCheckForService(&svc)
+			if check == nil {
+				log.Debugf("Unable to remotely check %s (id: %s), no TCP port", svc.Name, svc.ID)
+				continue
+			}
+			m.AddCheck(check)
+		}
+
+		m.Lock()
+		defer m.Unlock()
+	OUTER:
+		for _, check := range m.Checks {
+			for _, svc := range services {
+				if svc.ID == check.ID {
+					continue OUTER
+				}
+			}
+
+			delete(m.Checks, check.ID)
+		}
+
+		return nil
+	})
+}
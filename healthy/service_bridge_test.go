@@ -1,9 +1,11 @@
 package healthy
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/NinesStack/sidecar/clock"
 	"github.com/NinesStack/sidecar/discovery"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/relistan/go-director"
@@ -33,10 +35,43 @@ func (m *mockDiscoverer) HealthCheck(svc *service.Service) (string, string) {
 		return "HttpGet", "http://{{ container }}:{{ tcp 8081 }}/status/check"
 	}
 
+	if svc.Name == "hasOptions" || svc.Name == "badOptions" || svc.Name == "hasDependency" || svc.Name == "multiPort" {
+		return "HttpGet", "http://{{ host }}:{{ tcp 8081 }}/status/check"
+	}
+
 	return "", ""
 }
 
-func (m *mockDiscoverer) Run(director.Looper) {}
+func (m *mockDiscoverer) HealthCheckOptions(svc *service.Service) discovery.CheckOptions {
+	switch svc.Name {
+	case "hasOptions":
+		return discovery.CheckOptions{StatusCodes: "204", BodyMatch: "OK"}
+	case "badOptions":
+		return discovery.CheckOptions{StatusCodes: "bogus"}
+	case "hasDependency":
+		return discovery.CheckOptions{DependsOn: "database"}
+	case "multiPort":
+		return discovery.CheckOptions{Policy: "quorum"}
+	case "onlyPorts":
+		return discovery.CheckOptions{Aggregation: "any"}
+	}
+
+	return discovery.CheckOptions{}
+}
+
+func (m *mockDiscoverer) PortHealthChecks(svc *service.Service) []discovery.PortCheck {
+	switch svc.Name {
+	case "multiPort", "onlyPorts":
+		return []discovery.PortCheck{
+			{Port: 8080, Type: "External", Args: "check-udp {{ udp 8080 }}"},
+			{Port: 8081, Type: "HttpGet"},
+		}
+	}
+
+	return nil
+}
+
+func (m *mockDiscoverer) Run(context.Context, director.Looper) {}
 
 func Test_ServicesBridge(t *testing.T) {
 	Convey("The services bridge", t, func() {
@@ -104,6 +139,37 @@ func Test_ServicesBridge(t *testing.T) {
 			So(found, ShouldBeTrue)
 		})
 
+		Convey("Attaches the latency of the last health check", func() {
+			check1.LastLatency = 25 * time.Millisecond
+			services[0].Resources = &service.Resources{CPUPercent: 12.5}
+
+			var marked service.Service
+			for _, svc := range monitor.Services() {
+				if svc.ID == svcId1 {
+					marked = svc
+				}
+			}
+
+			So(marked.Resources, ShouldNotBeNil)
+			So(marked.Resources.CheckLatency, ShouldEqual, 25*time.Millisecond)
+			So(marked.Resources.CPUPercent, ShouldEqual, 12.5)
+			// The discoverer's copy is left alone
+			So(services[0].Resources.CheckLatency, ShouldEqual, 0)
+		})
+
+		Convey("Attaches the last check, once there is one", func() {
+			marked := services[0]
+			monitor.MarkService(&marked)
+			So(marked.LastCheck, ShouldBeNil)
+
+			ranAt := time.Now().UTC()
+			check1.LastRun = service.CheckInfo{Time: ranAt, Latency: 5 * time.Millisecond}
+			monitor.MarkService(&marked)
+			So(marked.LastCheck, ShouldNotBeNil)
+			So(marked.LastCheck.Time, ShouldEqual, ranAt)
+			So(marked.LastCheck.Latency, ShouldEqual, 5*time.Millisecond)
+		})
+
 		Convey("Returns services that are sickly", func() {
 			svcList := monitor.Services()
 
@@ -146,17 +212,22 @@ func Test_ServicesBridge(t *testing.T) {
 
 			disco := &mockDiscoverer{listFn: func() []service.Service { return svcList }}
 
+			now := time.Now().UTC()
+			monitor.Clock = clock.NewFrozen(now)
+
 			cmd := HttpGetCmd{}
 			check := &Check{
-				ID:      svc.ID,
-				Command: &cmd,
-				Type:    "HttpGet",
-				Args:    "http://" + hostname + ":1234/",
-				Status:  FAILED,
+				ID:          svc.ID,
+				Command:     &cmd,
+				Type:        "HttpGet",
+				Args:        "http://" + hostname + ":1234/",
+				Status:      FAILED,
+				ServiceName: svc.Name,
+				Added:       now,
 			}
 			looper := director.NewTimedLooper(5, 5*time.Nanosecond, nil)
 
-			monitor.Watch(disco, looper)
+			monitor.Watch(context.Background(), disco, looper)
 
 			So(len(monitor.Checks), ShouldEqual, 1)
 			So(monitor.Checks[svc.ID], ShouldResemble, check)
@@ -200,6 +271,81 @@ func Test_CheckForService(t *testing.T) {
 			So(check.Args, ShouldEqual, "http://indefatigable:1234/status/check")
 		})
 
+		Convey("Applies the check options from discovery", func() {
+			monitor := NewMonitor(hostname, "/")
+			service1.Name = "hasOptions"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+
+			cmd, ok := check.Command.(*HttpGetCmd)
+			So(ok, ShouldBeTrue)
+			So(cmd.StatusCodes, ShouldResemble, []StatusRange{{Min: 204, Max: 204}})
+			So(cmd.BodyMatch.String(), ShouldEqual, "OK")
+		})
+
+		Convey("Falls back to the defaults when the options are bad", func() {
+			monitor := NewMonitor(hostname, "/")
+			service1.Name = "badOptions"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+
+			So(check.Command, ShouldResemble, &HttpGetCmd{})
+		})
+
+		Convey("Picks up the dependency from discovery", func() {
+			monitor := NewMonitor(hostname, "/")
+			service1.Name = "hasDependency"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+
+			So(check.ServiceName, ShouldEqual, "hasDependency")
+			So(check.DependsOn, ShouldEqual, "database")
+			So(check.Command, ShouldResemble, &HttpGetCmd{})
+		})
+
+		Convey("Combines the checks for each port", func() {
+			monitor := NewMonitor(hostname, "/status")
+			service1.Name = "multiPort"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+
+			So(check.Type, ShouldEqual, "Multi")
+			cmd, ok := check.Command.(*MultiCmd)
+			So(ok, ShouldBeTrue)
+			So(cmd.RequireAll, ShouldBeTrue)
+			So(len(cmd.Checks), ShouldEqual, 3)
+
+			So(cmd.Checks[0].Port, ShouldEqual, 0)
+			So(cmd.Checks[0].Args, ShouldEqual, "http://indefatigable:1234/status/check")
+			So(cmd.Checks[1].Command, ShouldResemble, &ExternalCmd{})
+			So(cmd.Checks[1].Args, ShouldEqual, "check-udp 11234")
+			So(cmd.Checks[2].Command, ShouldResemble, &HttpGetCmd{})
+			So(cmd.Checks[2].Args, ShouldEqual, "http://indefatigable:1234/status")
+			So(check.Policy, ShouldResemble, QuorumPolicy{})
+		})
+
+		Convey("Uses port checks without a main check", func() {
+			monitor := NewMonitor(hostname, "/")
+			monitor.CheckAggregation = "all"
+			service1.Name = "onlyPorts"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+
+			cmd, ok := check.Command.(*MultiCmd)
+			So(ok, ShouldBeTrue)
+			So(cmd.RequireAll, ShouldBeFalse)
+			So(len(cmd.Checks), ShouldEqual, 2)
+		})
+
+		Convey("Uses a TCP check by default for services proxied in TCP mode", func() {
+			monitor := NewMonitor(hostname, "/")
+			monitor.HalfOpenThreshold = 3
+			service1.ProxyMode = "tcp"
+
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+			So(check.Type, ShouldEqual, "TcpConnect")
+			So(check.Args, ShouldEqual, "indefatigable:1234")
+
+			cmd, ok := check.Command.(*TcpConnectCmd)
+			So(ok, ShouldBeTrue)
+			So(cmd.HalfOpenThreshold, ShouldEqual, 3)
+		})
+
 		Convey("Uses the right default endpoint when it's configured", func() {
 			monitor := NewMonitor(hostname, "/something/else")
 			check := monitor.CheckForService(&service1, &mockDiscoverer{})
@@ -224,6 +370,16 @@ func Test_GetCommandNamed(t *testing.T) {
 			)
 		})
 
+		Convey("When asked for a TcpConnect", func() {
+			So(monitor.GetCommandNamed("TcpConnect"), ShouldResemble,
+				&TcpConnectCmd{
+					Timeout:           DEFAULT_TCP_CHECK_TIMEOUT,
+					HalfOpenWait:      DEFAULT_HALF_OPEN_WAIT,
+					HalfOpenThreshold: 1,
+				},
+			)
+		})
+
 		Convey("When asked for an invalid type", func() {
 			So(monitor.GetCommandNamed("Awesome-sauce"), ShouldResemble,
 				&HttpGetCmd{},
@@ -231,3 +387,43 @@ func Test_GetCommandNamed(t *testing.T) {
 		})
 	})
 }
+
+func Test_WatchRemote(t *testing.T) {
+	Convey("When watching remote services", t, func() {
+		ports := []service.Port{
+			{Type: "udp", Port: 11234, ServicePort: 8080, IP: "10.0.0.1"},
+			{Type: "tcp", Port: 1234, ServicePort: 8081, IP: "10.0.0.1"},
+		}
+		svc := service.Service{ID: "babbacabba", Name: "remote-svc", Hostname: "chaucer", Ports: ports}
+		noPorts := service.Service{ID: "deadbeef101", Name: "no-ports", Hostname: "chaucer"}
+		svcList := []service.Service{svc, noPorts}
+
+		monitor := NewMonitor(hostname, "/status")
+		looper := director.NewFreeLooper(director.ONCE, nil)
+
+		monitor.WatchRemote(context.Background(), func() []service.Service { return svcList }, looper)
+
+		Convey("Adds an UNKNOWN check against the announced address", func() {
+			So(len(monitor.Checks), ShouldEqual, 1)
+			check := monitor.Checks[svc.ID]
+			So(check, ShouldNotBeNil)
+			So(check.Args, ShouldEqual, "http://10.0.0.1:1234/status")
+			So(check.Status, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("Reports remote services as UNKNOWN until checked", func() {
+			services := monitor.Services()
+			So(len(services), ShouldEqual, 2)
+			So(services[0].Status, ShouldEqual, service.UNKNOWN)
+		})
+
+		Convey("Removes checks for services that went away", func() {
+			svcList = []service.Service{}
+			monitor.WatchRemote(context.Background(),
+				func() []service.Service { return svcList },
+				director.NewFreeLooper(director.ONCE, nil),
+			)
+			So(len(monitor.Checks), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	JOURNALD_SOCKET = "/run/systemd/journal/socket"
+)
+
+var invalidFieldChars = regexp.MustCompile("[^A-Z0-9_]")
+
+// A JournaldHook sends log entries to journald using its native protocol,
+// so that the level and any fields are kept as structured journal fields
+// rather than being flattened into text.
+type JournaldHook struct {
+	Identifier string // Sent as SYSLOG_IDENTIFIER
+	conn       net.Conn
+}
+
+// NewJournaldHook connects to the local journald socket
+func NewJournaldHook(identifier string) (*JournaldHook, error) {
+	return newJournaldHook(JOURNALD_SOCKET, identifier)
+}
+
+func newJournaldHook(socket string, identifier string) (*JournaldHook, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to journald: %s", err)
+	}
+
+	return &JournaldHook{Identifier: identifier, conn: conn}, nil
+}
+
+// Fire sends one entry to the journal
+func (h *JournaldHook) Fire(entry *log.Entry) error {
+	_, err := h.conn.Write(h.encode(entry))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to send log entry to journald: %s\n", err)
+	}
+
+	return err
+}
+
+// Levels returns the levels this hook fires for, which is all of them
+func (h *JournaldHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Close disconnects from journald
+func (h *JournaldHook) Close() error {
+	return h.conn.Close()
+}
+
+// encode turns an entry into a journald datagram. Entry fields are sent as
+// upper case journal fields.
+func (h *JournaldHook) encode(entry *log.Entry) []byte {
+	var buf bytes.Buffer
+
+	writeJournalField(&buf, "MESSAGE", entry.Message)
+	writeJournalField(&buf, "PRIORITY", fmt.Sprintf("%d", journalPriority(entry.Level)))
+	if h.Identifier != "" {
+		writeJournalField(&buf, "SYSLOG_IDENTIFIER", h.Identifier)
+	}
+
+	// Stable order makes the journal easier to read, and this easier to test
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeJournalField(&buf, journalFieldName(key), fmt.Sprint(entry.Data[key]))
+	}
+
+	return buf.Bytes()
+}
+
+// writeJournalField appends a field in the journald native format. Values
+// with newlines need the binary form, which is length prefixed.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName turns a logrus field name into a valid journal field
+// name: upper case letters, digits and underscores, not starting with an
+// underscore, which is reserved for journald itself.
+func journalFieldName(key string) string {
+	name := invalidFieldChars.ReplaceAllString(strings.ToUpper(key), "_")
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "FIELD_" + name
+	}
+
+	return name
+}
+
+// journalPriority maps logrus levels to syslog priorities
+func journalPriority(level log.Level) int {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return 2 // crit
+	case log.ErrorLevel:
+		return 3 // err
+	case log.WarnLevel:
+		return 4 // warning
+	case log.InfoLevel:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
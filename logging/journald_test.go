@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_JournaldHook(t *testing.T) {
+	Convey("JournaldHook", t, func() {
+		hook := &JournaldHook{Identifier: "sidecar"}
+
+		Convey("encodes entries as journal fields", func() {
+			entry := &log.Entry{
+				Message: "Something happened",
+				Level:   log.WarnLevel,
+				Data:    log.Fields{"service": "bocaccio", "_private": 1},
+			}
+
+			So(string(hook.encode(entry)), ShouldEqual,
+				"MESSAGE=Something happened\n"+
+					"PRIORITY=4\n"+
+					"SYSLOG_IDENTIFIER=sidecar\n"+
+					"PRIVATE=1\n"+
+					"SERVICE=bocaccio\n",
+			)
+		})
+
+		Convey("length prefixes values with newlines", func() {
+			entry := &log.Entry{Message: "one\ntwo", Level: log.InfoLevel}
+
+			var expected bytes.Buffer
+			expected.WriteString("MESSAGE\n")
+			binary.Write(&expected, binary.LittleEndian, uint64(7))
+			expected.WriteString("one\ntwo\n")
+
+			So(string(hook.encode(entry)), ShouldStartWith, expected.String())
+		})
+
+		Convey("cleans up field names", func() {
+			So(journalFieldName("service-name"), ShouldEqual, "SERVICE_NAME")
+			So(journalFieldName("__hidden"), ShouldEqual, "HIDDEN")
+			So(journalFieldName("1st"), ShouldEqual, "FIELD_1ST")
+		})
+
+		Convey("sends entries to the socket", func() {
+			dir, err := ioutil.TempDir("", "sidecar-journald")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			socket := filepath.Join(dir, "journal.sock")
+			listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+			So(err, ShouldBeNil)
+			defer listener.Close()
+
+			hook, err := newJournaldHook(socket, "sidecar")
+			So(err, ShouldBeNil)
+			defer hook.Close()
+
+			err = hook.Fire(&log.Entry{Message: "hello", Level: log.ErrorLevel})
+			So(err, ShouldBeNil)
+
+			buf := make([]byte, 1024)
+			n, _, err := listener.ReadFrom(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldContainSubstring, "MESSAGE=hello\nPRIORITY=3\n")
+		})
+
+		Convey("returns an error when journald isn't there", func() {
+			_, err := newJournaldHook("/nonexistent/journal.sock", "sidecar")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_LIMIT_INTERVAL = 1 * time.Minute // How often a repeated message is logged
+)
+
+// A Limiter keeps repeated log messages from drowning out everything else.
+// Messages are grouped by a key chosen by the caller, e.g. the service and
+// port involved. The first message for a key is logged, then the rest are
+// suppressed until Interval has passed. The next one to get through reports
+// how many were suppressed in the meantime.
+type Limiter struct {
+	Interval time.Duration
+	Logger   *log.Logger // Where the messages go, nil for the standard logger
+
+	entries   map[string]*limitEntry
+	lastPrune time.Time
+	now       func() time.Time
+	sync.Mutex
+}
+
+type limitEntry struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// NewLimiter returns a Limiter that logs each key at most once per interval
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		Interval: interval,
+		entries:  make(map[string]*limitEntry),
+		now:      time.Now,
+	}
+}
+
+// WithLogger sends the messages to a logger other than the standard one,
+// e.g. one from Module()
+func (l *Limiter) WithLogger(logger *log.Logger) *Limiter {
+	l.Logger = logger
+	return l
+}
+
+// Errorf logs at error level, unless the key was logged recently
+func (l *Limiter) Errorf(key string, format string, args ...interface{}) {
+	l.logf(log.ErrorLevel, key, format, args...)
+}
+
+// Warnf logs at warning level, unless the key was logged recently
+func (l *Limiter) Warnf(key string, format string, args ...interface{}) {
+	l.logf(log.WarnLevel, key, format, args...)
+}
+
+// Infof logs at info level, unless the key was logged recently
+func (l *Limiter) Infof(key string, format string, args ...interface{}) {
+	l.logf(log.InfoLevel, key, format, args...)
+}
+
+func (l *Limiter) logf(level log.Level, key string, format string, args ...interface{}) {
+	suppressed, ok := l.allow(key)
+	if !ok {
+		metrics.IncrCounter([]string{"logging", "suppressed"}, 1)
+		return
+	}
+
+	logger := l.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	entry := log.NewEntry(logger)
+	if suppressed > 0 {
+		entry = entry.WithField("suppressed", suppressed)
+	}
+
+	message := fmt.Sprintf(format, args...)
+	switch level {
+	case log.ErrorLevel:
+		entry.Error(message)
+	case log.WarnLevel:
+		entry.Warn(message)
+	default:
+		entry.Info(message)
+	}
+}
+
+// allow decides whether a message for this key gets logged. When it does,
+// it also returns how many were suppressed since the last one.
+func (l *Limiter) allow(key string) (int, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	entry, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &limitEntry{lastLogged: now}
+		return 0, true
+	}
+
+	if now.Sub(entry.lastLogged) < l.Interval {
+		entry.suppressed++
+		return 0, false
+	}
+
+	suppressed := entry.suppressed
+	entry.lastLogged = now
+	entry.suppressed = 0
+
+	return suppressed, true
+}
+
+// prune forgets keys that haven't been seen for a whole interval, so that
+// keys made from things like service IDs don't pile up forever. Keys with
+// suppressed messages are kept so the count is reported next time around.
+// Note: not synchronized!
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.Interval {
+		return
+	}
+	l.lastPrune = now
+
+	for key, entry := range l.entries {
+		if entry.suppressed == 0 && now.Sub(entry.lastLogged) >= l.Interval {
+			delete(l.entries, key)
+		}
+	}
+}
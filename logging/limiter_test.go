@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Limiter(t *testing.T) {
+	Convey("Limiter", t, func() {
+		now := time.Unix(1500000000, 0)
+		limiter := NewLimiter(1 * time.Minute)
+		limiter.now = func() time.Time { return now }
+
+		output := &bytes.Buffer{}
+		log.SetOutput(output)
+		defer log.SetOutput(os.Stderr)
+
+		Convey("logs the first message for a key", func() {
+			limiter.Warnf("some-key", "something %s", "happened")
+
+			So(output.String(), ShouldContainSubstring, "something happened")
+		})
+
+		Convey("suppresses repeats within the interval", func() {
+			limiter.Warnf("some-key", "first")
+			limiter.Warnf("some-key", "second")
+			limiter.Warnf("some-key", "third")
+
+			So(output.String(), ShouldContainSubstring, "first")
+			So(output.String(), ShouldNotContainSubstring, "second")
+			So(output.String(), ShouldNotContainSubstring, "third")
+		})
+
+		Convey("keeps keys apart", func() {
+			limiter.Warnf("some-key", "first")
+			limiter.Warnf("other-key", "second")
+
+			So(output.String(), ShouldContainSubstring, "first")
+			So(output.String(), ShouldContainSubstring, "second")
+		})
+
+		Convey("reports the suppressed count after the interval", func() {
+			limiter.Errorf("some-key", "first")
+			limiter.Errorf("some-key", "second")
+			limiter.Errorf("some-key", "third")
+
+			now = now.Add(61 * time.Second)
+			limiter.Errorf("some-key", "fourth")
+
+			So(output.String(), ShouldContainSubstring, "fourth")
+			So(output.String(), ShouldContainSubstring, "suppressed=2")
+		})
+
+		Convey("forgets quiet keys", func() {
+			limiter.Infof("some-key", "first")
+
+			now = now.Add(2 * time.Minute)
+			limiter.Infof("other-key", "second")
+
+			So(limiter.entries, ShouldNotContainKey, "some-key")
+			So(limiter.entries, ShouldContainKey, "other-key")
+		})
+
+		Convey("holds on to keys with suppressed messages", func() {
+			limiter.Infof("some-key", "first")
+			limiter.Infof("some-key", "second")
+
+			now = now.Add(2 * time.Minute)
+			limiter.Infof("other-key", "third")
+
+			So(limiter.entries, ShouldContainKey, "some-key")
+		})
+	})
+}
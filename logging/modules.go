@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	modules     = make(map[string]*moduleLogger)
+	modulesLock sync.Mutex
+)
+
+// A moduleLogger is the logger for one part of Sidecar. It follows the
+// global level unless it has been given one of its own.
+type moduleLogger struct {
+	logger     *log.Logger
+	level      log.Level // Its own level, when overridden
+	overridden bool
+}
+
+// Module returns the logger for a part of Sidecar, e.g. "catalog". It writes
+// wherever the standard logrus logger does, in the same format and with the
+// same hooks, but its level can be changed on its own with SetModuleLevel().
+// Asking for the same module again returns the same logger.
+func Module(name string) *log.Logger {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	if module, ok := modules[name]; ok {
+		return module.logger
+	}
+
+	logger := &log.Logger{
+		Out:       stdWriter{},
+		Formatter: stdFormatter{},
+		Hooks:     log.StandardLogger().Hooks,
+		Level:     log.GetLevel(),
+	}
+	modules[name] = &moduleLogger{logger: logger}
+
+	return logger
+}
+
+// Modules returns the names of the modules that have loggers, sorted
+func Modules() []string {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SetLevel sets the global level, along with that of every module that
+// hasn't been given its own
+func SetLevel(level log.Level) {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	log.SetLevel(level)
+	for _, module := range modules {
+		if !module.overridden {
+			module.logger.SetLevel(level)
+		}
+	}
+}
+
+// SetModuleLevel gives a module its own level, which sticks when the global
+// level changes
+func SetModuleLevel(name string, level log.Level) error {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	module, ok := modules[name]
+	if !ok {
+		return fmt.Errorf("unknown logging module %q", name)
+	}
+
+	module.logger.SetLevel(level)
+	module.level = level
+	module.overridden = true
+
+	return nil
+}
+
+// ResetModuleLevel puts a module back on the global level
+func ResetModuleLevel(name string) error {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	module, ok := modules[name]
+	if !ok {
+		return fmt.Errorf("unknown logging module %q", name)
+	}
+
+	module.logger.SetLevel(log.GetLevel())
+	module.overridden = false
+
+	return nil
+}
+
+// ModuleLevels returns the level of each module that has one of its own
+func ModuleLevels() map[string]log.Level {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	levels := make(map[string]log.Level)
+	for name, module := range modules {
+		if module.overridden {
+			levels[name] = module.level
+		}
+	}
+
+	return levels
+}
+
+// stdWriter writes to wherever the standard logger is writing at the time
+type stdWriter struct{}
+
+func (stdWriter) Write(data []byte) (int, error) {
+	return log.StandardLogger().Out.Write(data)
+}
+
+// stdFormatter formats entries however the standard logger is
+type stdFormatter struct{}
+
+func (stdFormatter) Format(entry *log.Entry) ([]byte, error) {
+	return log.StandardLogger().Formatter.Format(entry)
+}
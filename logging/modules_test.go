@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Module(t *testing.T) {
+	Convey("Module loggers", t, func() {
+		output := &bytes.Buffer{}
+		log.SetOutput(output)
+
+		SetLevel(log.InfoLevel)
+		logger := Module("beowulf")
+
+		Reset(func() {
+			log.SetOutput(os.Stderr)
+			ResetModuleLevel("beowulf")
+			SetLevel(log.InfoLevel)
+		})
+
+		Convey("write wherever the standard logger does", func() {
+			logger.Info("hwaet")
+			So(output.String(), ShouldContainSubstring, "hwaet")
+		})
+
+		Convey("are the same logger for the same module", func() {
+			So(Module("beowulf"), ShouldEqual, logger)
+			So(Modules(), ShouldContain, "beowulf")
+		})
+
+		Convey("follow the global level", func() {
+			logger.Debug("hidden")
+			SetLevel(log.DebugLevel)
+			logger.Debug("shown")
+
+			So(output.String(), ShouldNotContainSubstring, "hidden")
+			So(output.String(), ShouldContainSubstring, "shown")
+		})
+
+		Convey("keep a level of their own until it's reset", func() {
+			So(SetModuleLevel("beowulf", log.DebugLevel), ShouldBeNil)
+			SetLevel(log.WarnLevel)
+
+			logger.Debug("module debug")
+			log.Info("global info")
+			So(output.String(), ShouldContainSubstring, "module debug")
+			So(output.String(), ShouldNotContainSubstring, "global info")
+			So(ModuleLevels(), ShouldResemble, map[string]log.Level{"beowulf": log.DebugLevel})
+
+			So(ResetModuleLevel("beowulf"), ShouldBeNil)
+			logger.Debug("after reset")
+			So(output.String(), ShouldNotContainSubstring, "after reset")
+			So(ModuleLevels(), ShouldBeEmpty)
+		})
+
+		Convey("refuse modules that don't exist", func() {
+			So(SetModuleLevel("grendel", log.DebugLevel), ShouldNotBeNil)
+			So(ResetModuleLevel("grendel"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ROTATED_TIME_FORMAT = "20060102-150405" // Suffix added to rotated log files
+)
+
+// A RotatingFile is an io.Writer that appends to a log file and moves it out
+// of the way when it gets too big or too old. Rotated files are named after
+// the original with a timestamp suffix, and only the newest MaxBackups of
+// them are kept.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64         // Rotate when the file would grow past this many bytes. 0 disables.
+	MaxAge     time.Duration // Rotate when the file was opened longer ago than this. 0 disables.
+	MaxBackups int           // How many rotated files to keep. 0 keeps them all.
+
+	file   *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+	sync.Mutex
+}
+
+// NewRotatingFile opens the log file at path, creating it if needed
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+		now:        time.Now,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Write appends to the log file, rotating it first if required. Each call
+// is written to a single file, so log lines are never split.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.shouldRotate(int64(len(data))) {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the log file
+func (r *RotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// shouldRotate tells whether writing this many more bytes needs a new file.
+// An empty file is never rotated, so oversized writes still go somewhere.
+func (r *RotatingFile) shouldRotate(length int64) bool {
+	if r.size == 0 {
+		return false
+	}
+
+	if r.MaxSize > 0 && r.size+length > r.MaxSize {
+		return true
+	}
+
+	return r.MaxAge > 0 && r.now().Sub(r.opened) >= r.MaxAge
+}
+
+// open opens the log file for appending and picks up where it left off
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %s", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to stat log file: %s", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.opened = r.now()
+
+	return nil
+}
+
+// rotate moves the current file out of the way, starts a new one, and
+// removes any backups we no longer want. Expects the caller to hold the lock.
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return fmt.Errorf("unable to close log file: %s", err)
+	}
+
+	err = os.Rename(r.Path, r.backupName())
+	if err != nil {
+		return fmt.Errorf("unable to rotate log file: %s", err)
+	}
+
+	err = r.open()
+	if err != nil {
+		return err
+	}
+
+	r.prune()
+
+	return nil
+}
+
+// prune removes the oldest backups, keeping MaxBackups of them
+func (r *RotatingFile) prune() {
+	if r.MaxBackups <= 0 {
+		return
+	}
+
+	backups := r.backups()
+	if len(backups) <= r.MaxBackups {
+		return
+	}
+
+	for _, backup := range backups[:len(backups)-r.MaxBackups] {
+		// Nowhere to log this, we are the log
+		_ = os.Remove(backup)
+	}
+}
+
+// backupName returns the name for the next rotated file. When we rotate more
+// than once a second, a counter keeps the names unique and in order.
+func (r *RotatingFile) backupName() string {
+	stamp := r.now().UTC().Format(ROTATED_TIME_FORMAT)
+	name := r.Path + "." + stamp
+
+	counter := 0
+	for _, backup := range r.findBackups() {
+		if backup.stamp == stamp && backup.counter >= counter {
+			counter = backup.counter + 1
+		}
+	}
+
+	if counter > 0 {
+		name = fmt.Sprintf("%s.%d", name, counter)
+	}
+
+	return name
+}
+
+// backups returns the rotated files, oldest first
+func (r *RotatingFile) backups() []string {
+	found := r.findBackups()
+
+	backups := make([]string, 0, len(found))
+	for _, b := range found {
+		backups = append(backups, b.path)
+	}
+
+	return backups
+}
+
+type backupFile struct {
+	path    string
+	stamp   string
+	counter int
+}
+
+// findBackups returns what we know about each rotated file, oldest first
+func (r *RotatingFile) findBackups() []backupFile {
+	matches, err := filepath.Glob(r.Path + ".*")
+	if err != nil {
+		return nil
+	}
+
+	prefix := r.Path + "."
+	var found []backupFile
+	for _, match := range matches {
+		parts := strings.SplitN(strings.TrimPrefix(match, prefix), ".", 2)
+		if _, err := time.Parse(ROTATED_TIME_FORMAT, parts[0]); err != nil {
+			continue
+		}
+
+		b := backupFile{path: match, stamp: parts[0]}
+		if len(parts) > 1 {
+			b.counter, err = strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+		}
+		found = append(found, b)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].stamp == found[j].stamp {
+			return found[i].counter < found[j].counter
+		}
+		return found[i].stamp < found[j].stamp
+	})
+
+	return found
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RotatingFile(t *testing.T) {
+	Convey("RotatingFile", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-logging")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "sidecar.log")
+
+		Convey("appends to an existing file", func() {
+			So(ioutil.WriteFile(path, []byte("before\n"), 0644), ShouldBeNil)
+
+			file, err := NewRotatingFile(path, 0, 0, 0)
+			So(err, ShouldBeNil)
+			defer file.Close()
+
+			_, err = file.Write([]byte("after\n"))
+			So(err, ShouldBeNil)
+
+			contents, _ := ioutil.ReadFile(path)
+			So(string(contents), ShouldEqual, "before\nafter\n")
+			So(file.size, ShouldEqual, 13)
+		})
+
+		Convey("rotates when the file gets too big", func() {
+			file, err := NewRotatingFile(path, 10, 0, 0)
+			So(err, ShouldBeNil)
+			defer file.Close()
+
+			file.Write([]byte("12345678\n"))
+			file.Write([]byte("abcdefgh\n"))
+
+			contents, _ := ioutil.ReadFile(path)
+			So(string(contents), ShouldEqual, "abcdefgh\n")
+
+			backups := file.backups()
+			So(len(backups), ShouldEqual, 1)
+			contents, _ = ioutil.ReadFile(backups[0])
+			So(string(contents), ShouldEqual, "12345678\n")
+		})
+
+		Convey("doesn't rotate an empty file, even for a long line", func() {
+			file, err := NewRotatingFile(path, 5, 0, 0)
+			So(err, ShouldBeNil)
+			defer file.Close()
+
+			file.Write([]byte("much too long\n"))
+
+			So(file.backups(), ShouldBeEmpty)
+		})
+
+		Convey("rotates when the file gets too old", func() {
+			file, err := NewRotatingFile(path, 0, time.Hour, 0)
+			So(err, ShouldBeNil)
+			defer file.Close()
+
+			now := time.Now()
+			file.now = func() time.Time { return now }
+
+			file.Write([]byte("old\n"))
+			So(file.backups(), ShouldBeEmpty)
+
+			now = now.Add(2 * time.Hour)
+			file.Write([]byte("new\n"))
+
+			So(len(file.backups()), ShouldEqual, 1)
+			contents, _ := ioutil.ReadFile(path)
+			So(string(contents), ShouldEqual, "new\n")
+		})
+
+		Convey("keeps only the newest backups", func() {
+			file, err := NewRotatingFile(path, 2, 0, 2)
+			So(err, ShouldBeNil)
+			defer file.Close()
+
+			for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+				file.Write([]byte(line))
+			}
+
+			backups := file.backups()
+			So(len(backups), ShouldEqual, 2)
+
+			first, _ := ioutil.ReadFile(backups[0])
+			second, _ := ioutil.ReadFile(backups[1])
+			So(string(first), ShouldEqual, "3\n")
+			So(string(second), ShouldEqual, "4\n")
+		})
+
+		Convey("refuses to write once closed", func() {
+			file, err := NewRotatingFile(path, 0, 0, 0)
+			So(err, ShouldBeNil)
+			file.Close()
+
+			_, err = file.Write([]byte("nope\n"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error when it can't open the file", func() {
+			_, err := NewRotatingFile(filepath.Join(dir, "missing", "sidecar.log"), 0, 0, 0)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
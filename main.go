@@ -2,43 +2,22 @@ package main // import "github.com/NinesStack/sidecar"
 
 import (
 	"context"
-	"net"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
-	"time"
 
-	"github.com/NinesStack/memberlist"
-	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/agent"
 	"github.com/NinesStack/sidecar/config"
-	"github.com/NinesStack/sidecar/discovery"
-	"github.com/NinesStack/sidecar/envoy"
-	"github.com/NinesStack/sidecar/haproxy"
-	"github.com/NinesStack/sidecar/healthy"
-	"github.com/NinesStack/sidecar/service"
-	"github.com/NinesStack/sidecar/sidecarhttp"
+	"github.com/NinesStack/sidecar/logging"
 	"github.com/armon/go-metrics"
-	"github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/relistan/rubberneck.v1"
 )
 
-func announceMembers(list *memberlist.Memberlist, state *catalog.ServicesState) {
-	for {
-		// Ask for members of the cluster
-		for _, member := range list.Members() {
-			log.Debugf("Member: %s %s", member.Name, member.Addr)
-			log.Debugf("Meta: %s", string(member.Meta))
-		}
-
-		state.RLock()
-		log.Debug(state.Format(list))
-		state.RUnlock()
-
-		time.Sleep(2 * time.Second)
-	}
-}
-
 // configureOverrides takes CLI opts and applies them over the top of settings
 // taken from the environment variables and stored in config.
 func configureOverrides(config *config.Config, opts *CliOpts) {
@@ -57,100 +36,12 @@ func configureOverrides(config *config.Config, opts *CliOpts) {
 	if len(*opts.LoggingLevel) > 0 {
 		config.Sidecar.LoggingLevel = *opts.LoggingLevel
 	}
-}
-
-func configureHAproxy(config *config.Config) *haproxy.HAproxy {
-	proxy := haproxy.New(config.HAproxy.ConfigFile, config.HAproxy.PidFile)
-
-	if len(config.HAproxy.BindIP) > 0 {
-		proxy.BindIP = config.HAproxy.BindIP
-	}
-
-	if len(config.HAproxy.ReloadCmd) > 0 {
-		proxy.ReloadCmd = config.HAproxy.ReloadCmd
-	}
-
-	if len(config.HAproxy.VerifyCmd) > 0 {
-		proxy.VerifyCmd = config.HAproxy.VerifyCmd
-	}
-
-	if len(config.HAproxy.TemplateFile) > 0 {
-		proxy.Template = config.HAproxy.TemplateFile
-	}
-
-	if len(config.HAproxy.User) > 0 {
-		proxy.User = config.HAproxy.User
-	}
-
-	if len(config.HAproxy.Group) > 0 {
-		proxy.Group = config.HAproxy.Group
-	}
-
-	proxy.UseHostnames = config.HAproxy.UseHostnames
-
-	return proxy
-}
-
-func configureDiscovery(config *config.Config, publishedIP string, localNode *memberlist.Node) discovery.Discoverer {
-	disco := new(discovery.MultiDiscovery)
-
-	var svcNamer discovery.ServiceNamer
-	var usingDocker bool
-	var err error
-
-	if len(config.Sidecar.Discovery) < 1 {
-		log.Warn("No discovery method configured! Sidecar running in passive mode")
-	}
-
-	for _, method := range config.Sidecar.Discovery {
-		if method == "docker" {
-			usingDocker = true
-		}
+	if len(*opts.Hostname) > 0 {
+		config.Sidecar.Hostname = *opts.Hostname
 	}
-
-	switch config.Services.ServiceNamer {
-	case "docker_label":
-		svcNamer = &discovery.DockerLabelNamer{
-			Label: config.Services.NameLabel,
-		}
-	case "regex":
-		svcNamer, err = discovery.NewRegexpNamer(config.Services.NameMatch)
-		if err != nil {
-			log.Fatalf("Unable to use RegexpNamer: %s", err)
-		}
-	default:
-		if usingDocker {
-			log.Fatalf("Unable to configure service namer! Not a valid entry.")
-		}
-	}
-
-	for _, method := range config.Sidecar.Discovery {
-		switch method {
-		case "docker":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewDockerDiscovery(config.DockerDiscovery.DockerURL, svcNamer, publishedIP),
-			)
-		case "static":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP),
-			)
-		case "kubernetes_api":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewK8sAPIDiscoverer(
-					config.K8sAPIDiscovery.KubeAPIIP, config.K8sAPIDiscovery.KubeAPIPort,
-					config.K8sAPIDiscovery.Namespace, config.K8sAPIDiscovery.KubeTimeout,
-					config.K8sAPIDiscovery.CredsPath, config.K8sAPIDiscovery.AnnounceAllNodes,
-					localNode.Name,
-				),
-			)
-		default:
-		}
+	if *opts.Dev {
+		config.Sidecar.Dev = true
 	}
-
-	return disco
 }
 
 // configureMetrics sets up remote performance metrics if we're asked to send them (statsd)
@@ -165,17 +56,42 @@ func configureMetrics(config *config.Config) {
 	}
 }
 
-// configureDelegate sets up the Memberlist delegate we'll use
-func configureDelegate(state *catalog.ServicesState, config *config.Config) *servicesDelegate {
-	delegate := NewServicesDelegate(state)
-	delegate.Metadata = NodeMetadata{
-		ClusterName: config.Sidecar.ClusterName,
-		State:       "Running",
+const (
+	// Roughly what each service costs on the heap at its peak: the record,
+	// plus its share of the full-state copies made to marshal and merge.
+	ballastBytesPerService = 4 * 1024
+)
+
+// heapBallast is never used, it's only there to make the heap look bigger.
+// The GC then runs less often on nodes whose live heap is mostly the state,
+// which otherwise churn through collections during full-state marshals and
+// gossip bursts. The pages are never touched, so they cost address space but
+// not memory.
+var heapBallast []byte
+
+// configureGC applies the GOGC setting and allocates the heap ballast, if
+// either is configured, and logs the settings in effect
+func configureGC(config *config.Config) {
+	if config.Sidecar.GOGC > 0 {
+		debug.SetGCPercent(config.Sidecar.GOGC)
 	}
 
-	delegate.Start()
+	ballastBytes := config.Sidecar.HeapBallastMB * 1024 * 1024
+	if ballastBytes == 0 && config.Sidecar.ExpectedServices > 0 {
+		ballastBytes = config.Sidecar.ExpectedServices * ballastBytesPerService
+	}
 
-	return delegate
+	if ballastBytes > 0 {
+		heapBallast = make([]byte, ballastBytes)
+	}
+
+	// There's no way to read the GC percentage without setting it
+	gcPercent := debug.SetGCPercent(100)
+	debug.SetGCPercent(gcPercent)
+
+	log.Infof("GC settings: GOGC=%d, heap ballast %d MB, GOMAXPROCS=%d",
+		gcPercent, len(heapBallast)/(1024*1024), runtime.GOMAXPROCS(0),
+	)
 }
 
 // configureCpuProfiler sets of the CPU profiler and a signal handler to
@@ -214,15 +130,15 @@ func configureLoggingLevel(config *config.Config) {
 
 	switch {
 	case len(level) == 0:
-		log.SetLevel(log.InfoLevel)
+		logging.SetLevel(log.InfoLevel)
 	case level == "info":
-		log.SetLevel(log.InfoLevel)
+		logging.SetLevel(log.InfoLevel)
 	case level == "warn":
-		log.SetLevel(log.WarnLevel)
+		logging.SetLevel(log.WarnLevel)
 	case level == "error":
-		log.SetLevel(log.ErrorLevel)
+		logging.SetLevel(log.ErrorLevel)
 	case level == "debug":
-		log.SetLevel(log.DebugLevel)
+		logging.SetLevel(log.DebugLevel)
 	}
 }
 
@@ -236,179 +152,83 @@ func configureLoggingFormat(config *config.Config) {
 	}
 }
 
-func configureMemberlist(config *config.Config, state *catalog.ServicesState) *memberlist.Config {
-	delegate := configureDelegate(state, config)
-
-	// Use a LAN config but add our delegate
-	mlConfig := memberlist.DefaultLANConfig()
-	mlConfig.Delegate = delegate
-	mlConfig.Events = delegate
-
-	// Set some memberlist settings
-	mlConfig.LogOutput = &LoggingBridge{} // Use logrus as backend for Memberlist
-	mlConfig.PreferTCPDNS = false
-
-	// Set up the push pull interval for Memberlist
-	if config.Sidecar.PushPullInterval == 0 {
-		mlConfig.PushPullInterval = catalog.ALIVE_LIFESPAN - 1*time.Second
-	} else {
-		mlConfig.PushPullInterval = config.Sidecar.PushPullInterval
-	}
-	if config.Sidecar.GossipMessages != 0 {
-		mlConfig.GossipMessages = config.Sidecar.GossipMessages
+// configureLoggingOutput sends the logs to each of the configured outputs.
+// This includes what Memberlist logs, since that goes through logrus.
+func configureLoggingOutput(config *config.Config) {
+	var writers []io.Writer
+
+	for _, output := range config.Sidecar.LoggingOutput {
+		switch output {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "file":
+			file, err := logging.NewRotatingFile(
+				config.Sidecar.LoggingFile, config.Sidecar.LoggingFileMaxSize,
+				config.Sidecar.LoggingFileMaxAge, config.Sidecar.LoggingFileMaxBackups,
+			)
+			exitWithError(err, "Can't log to file")
+			writers = append(writers, file)
+		case "syslog":
+			hook, err := newSyslogHook(config.Sidecar.LoggingSyslogAddr)
+			exitWithError(err, "Can't log to syslog")
+			log.AddHook(hook)
+		case "journald":
+			hook, err := logging.NewJournaldHook("sidecar")
+			exitWithError(err, "Can't log to journald")
+			log.AddHook(hook)
+		default:
+			log.Fatalf("Unknown logging output %q", output)
+		}
 	}
-	mlConfig.GossipInterval = config.Sidecar.GossipInterval
-	mlConfig.HandoffQueueDepth = config.Sidecar.HandoffQueueDepth
-
-	// Make sure we pass on the cluster name to Memberlist
-	mlConfig.ClusterName = config.Sidecar.ClusterName
 
-	// Figure out our IP address from the CLI or by inspecting the network interfaces
-	publishedIP, err := getPublishedIP(config.Sidecar.ExcludeIPs, config.Sidecar.AdvertiseIP)
-	exitWithError(err, "Failed to find private IP address")
-	mlConfig.BindPort = config.Sidecar.BindPort
-	mlConfig.AdvertiseAddr = publishedIP
-	mlConfig.AdvertisePort = config.Sidecar.BindPort
-
-	return mlConfig
-}
-
-// configureListeners sets up any statically configured state change event listeners.
-func configureListeners(config *config.Config, state *catalog.ServicesState) {
-	for _, url := range config.Listeners.Urls {
-		listener := catalog.NewUrlListener(url, false)
-		listener.Watch(state)
+	switch len(writers) {
+	case 0:
+		log.SetOutput(ioutil.Discard)
+	case 1:
+		log.SetOutput(writers[0])
+	default:
+		log.SetOutput(io.MultiWriter(writers...))
 	}
 }
 
 func main() {
 	config := config.ParseConfig()
 	opts := parseCommandLine()
+
+	switch opts.Command {
+	case "state export":
+		err := exportState(*opts.StateAddr, *opts.StateFile)
+		exitWithError(err, "Failed to export the state")
+		return
+	case "state import":
+		err := importState(*opts.StateAddr, *opts.StateFile)
+		exitWithError(err, "Failed to import the state")
+		return
+	case "state graph":
+		err := graphState(*opts.StateAddr, *opts.StateFile, *opts.GraphFormat, *opts.GraphDependencies, os.Stdout)
+		exitWithError(err, "Failed to draw the state")
+		return
+	case "render":
+		configureLoggingLevel(config)
+		err := renderProxyConfig(config, *opts.StateFile, *opts.RenderTemplate, *opts.RenderEnvoy, os.Stdout)
+		exitWithError(err, "Failed to render the proxy config")
+		return
+	}
+
 	configureOverrides(config, opts)
 	configureCpuProfiler(opts)
 	configureLoggingLevel(config)
 	configureLoggingFormat(config)
+	configureLoggingOutput(config)
 	configureMetrics(config)
+	configureGC(config)
 
-	// Create a new state instance and fire up the processor. We need
-	// this to happen early in the startup.
-	state := catalog.NewServicesState()
-	svcMsgLooper := director.NewFreeLooper(
-		director.FOREVER, make(chan error),
-	)
-	go state.ProcessServiceMsgs(svcMsgLooper)
-
-	configureListeners(config, state)
-
-	mlConfig := configureMemberlist(config, state)
+	sidecar, err := agent.New(config)
+	exitWithError(err, "Failed to configure Sidecar")
 
 	printer := rubberneck.NewPrinter(log.Infof, rubberneck.NoAddLineFeed)
 	printer.PrintWithLabel("Sidecar", config)
 
-	list, err := memberlist.Create(mlConfig)
-	exitWithError(err, "Failed to create memberlist")
-
-	// Join an existing cluster by specifying at least one known member.
-	nodeCount, err := list.Join(config.Sidecar.Seeds)
-	exitWithError(err, "Failed to join cluster")
-	log.Infof("Joined cluster with %d nodes contacted", nodeCount)
-
-	// Set up a bunch of go-director Loopers to run our
-	// background goroutines
-	servicesLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
-	)
-	tombstoneLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.TOMBSTONE_SLEEP_INTERVAL, nil,
-	)
-	trackingLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
-	)
-	discoLooper := director.NewTimedLooper(
-		director.FOREVER, config.Sidecar.DiscoverySleepInterval, make(chan error),
-	)
-	listenLooper := director.NewTimedLooper(
-		director.FOREVER, discovery.DefaultSleepInterval, make(chan error),
-	)
-	healthWatchLooper := director.NewTimedLooper(
-		director.FOREVER, healthy.WATCH_INTERVAL, make(chan error),
-	)
-	healthLooper := director.NewTimedLooper(
-		director.FOREVER, healthy.HEALTH_INTERVAL, make(chan error),
-	)
-
-	// Register the cluster name with the state object
-	state.ClusterName = config.Sidecar.ClusterName
-
-	disco := configureDiscovery(config, mlConfig.AdvertiseAddr, list.LocalNode())
-	go disco.Run(discoLooper)
-
-	// Configure the monitor and use the public address as the default
-	// check address.
-	monitor := healthy.NewMonitor(mlConfig.AdvertiseAddr, config.Sidecar.DefaultCheckEndpoint)
-
-	// Wrap the monitor Services function as a simple func without the receiver
-	serviceFunc := func() []service.Service { return monitor.Services() }
-
-	// Wrap the discovery Listeners output in something the state can handle
-	listenFunc := func() []catalog.Listener {
-		listeners := disco.Listeners()
-		var result []catalog.Listener
-		for _, discovered := range listeners {
-			newLstnr := catalog.NewUrlListener(discovered.Url, true)
-			newLstnr.SetName(discovered.Name)
-			result = append(result, newLstnr)
-		}
-		return result
-	}
-
-	// Need to call HAproxy first, otherwise won't see first events from
-	// discovered services, and then won't write them out.
-	var proxy *haproxy.HAproxy
-
-	if !config.HAproxy.Disable {
-		proxy = configureHAproxy(config)
-		go proxy.Watch(state)
-	}
-
-	// This is kind of expensive because it looks at the state and formats text
-	// output on an ongoing basis. Only run in debug mode.
-	if config.Sidecar.Debug {
-		go announceMembers(list, state)
-	}
-
-	go state.BroadcastServices(serviceFunc, servicesLooper)
-	go state.BroadcastTombstones(serviceFunc, tombstoneLooper)
-	go state.TrackNewServices(serviceFunc, trackingLooper)
-	go state.TrackLocalListeners(listenFunc, listenLooper)
-	go monitor.Watch(disco, healthWatchLooper)
-	go monitor.Run(healthLooper)
-
-	go sidecarhttp.ServeHttp(list, state, &sidecarhttp.HttpConfig{
-		BindIP:       config.HAproxy.BindIP,
-		UseHostnames: config.HAproxy.UseHostnames,
-	})
-
-	if !config.HAproxy.Disable {
-		err := proxy.WriteAndReload(state)
-		exitWithError(err, "Failed to reload HAProxy config")
-	}
-
-	if config.Envoy.UseGRPCAPI {
-		ctx := context.Background()
-		envoyServer := envoy.NewServer(ctx, state, config.Envoy)
-		envoyServerLooper := director.NewTimedLooper(
-			director.FOREVER, envoy.LooperUpdateInterval, make(chan error),
-		)
-
-		// This listener will be owned and managed by the gRPC server
-		grpcListener, err := net.Listen("tcp", ":"+config.Envoy.GRPCPort)
-		if err != nil {
-			log.Fatalf("Failed to listen on port %q: %s", config.Envoy.GRPCPort, err)
-		}
-
-		go envoyServer.Run(ctx, envoyServerLooper, grpcListener)
-	}
-
-	select {}
+	err = sidecar.Run(context.Background())
+	exitWithError(err, "Sidecar exited with an error")
 }
@@ -0,0 +1,404 @@
+// Package notify tells people about trouble in the cluster without them having
+// to watch it: every instance of a service going down, a host being expired,
+// or HAproxy failing to reload over and over. Notifications go to pluggable
+// Sinks, e.g. a generic webhook, Slack, or PagerDuty.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	metrics "github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	QUEUE_LENGTH   = 100              // Notifications waiting to be sent before we drop them
+	EVENTS_LENGTH  = 100              // State change events waiting to be looked at
+	DefaultTimeout = 10 * time.Second // How long a sink gets to send one notification
+
+	// Notification kinds
+	KindServiceDown      = "service_down"
+	KindServiceRecovered = "service_recovered"
+	KindHostExpired      = "host_expired"
+	KindHostRejoined     = "host_rejoined"
+	KindHAproxyFailing   = "haproxy_failing"
+	KindHAproxyRecovered = "haproxy_recovered"
+)
+
+// A Notification is one thing worth telling people about
+type Notification struct {
+	Kind     string
+	Critical bool   // False when something has recovered
+	Key      string // The same for the trouble and its recovery
+	Subject  string // The service or host it's about
+	Message  string
+	Cluster  string
+	Source   string // The Sidecar host that sent it
+	Time     time.Time
+}
+
+// Summary is a one line description, for places that only show one
+func (n Notification) Summary() string {
+	return fmt.Sprintf("[%s] %s", n.Cluster, n.Message)
+}
+
+// A Notifier watches the state, the cluster members, and HAproxy, and sends
+// Notifications to its Sinks. Every Sidecar sees the same cluster-wide
+// changes, so those are only sent when IsSender says so. HAproxy trouble is
+// local and always sent.
+type Notifier struct {
+	Sinks            []Sink
+	ClusterName      string
+	Hostname         string
+	ServiceDownDelay time.Duration            // How long a service has to stay down before we say so
+	HAproxyFailures  int                      // Failed runs in a row before we say so, 0 to never
+	Timeout          time.Duration            // How long a sink gets to send one notification
+	IsSender         func() bool              // Whether we send cluster-wide notifications, nil for always
+	Next             memberlist.EventDelegate // Where member events go after we've seen them, may be nil
+
+	state          *catalog.ServicesState
+	events         chan catalog.ChangeEvent
+	checks         chan string // Services to check once the delay is up
+	queue          chan queued
+	pending        map[string]bool // Services waiting out the delay
+	down           map[string]bool // Services we've said are down
+	expired        map[string]bool // Hosts we've said were expired
+	haproxyFailed  int             // Failed runs in a row
+	haproxyFailing bool            // Whether we've said so
+	now            func() time.Time
+	sync.Mutex
+}
+
+// NewNotifier returns a Notifier that sends to the sinks, and expects to
+// watch the state
+func NewNotifier(state *catalog.ServicesState, sinks ...Sink) *Notifier {
+	return &Notifier{
+		Sinks:           sinks,
+		ClusterName:     state.ClusterName,
+		Hostname:        state.Hostname,
+		HAproxyFailures: 3,
+		Timeout:         DefaultTimeout,
+		state:           state,
+		events:          make(chan catalog.ChangeEvent, EVENTS_LENGTH),
+		checks:          make(chan string, EVENTS_LENGTH),
+		queue:           make(chan queued, QUEUE_LENGTH),
+		pending:         make(map[string]bool),
+		down:            make(map[string]bool),
+		expired:         make(map[string]bool),
+		now:             func() time.Time { return time.Now().UTC() },
+	}
+}
+
+func (n *Notifier) Name() string {
+	return "Notifier"
+}
+
+func (n *Notifier) Managed() bool {
+	return false
+}
+
+func (n *Notifier) Chan() chan catalog.ChangeEvent {
+	return n.events
+}
+
+// Run watches the state and sends notifications until the context is
+// cancelled
+func (n *Notifier) Run(ctx context.Context) {
+	n.state.AddListener(n)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n.sendQueued(ctx)
+	}()
+
+OUTER:
+	for {
+		select {
+		case event, ok := <-n.events:
+			if !ok {
+				break OUTER
+			}
+			n.serviceChanged(ctx, event)
+		case name := <-n.checks:
+			n.checkService(name)
+		case <-ctx.Done():
+			break OUTER
+		}
+	}
+
+	err := n.state.RemoveListener(n.Name())
+	if err != nil {
+		log.Warnf("Failed to remove notifier listener: %s", err)
+	}
+
+	wg.Wait()
+}
+
+// serviceChanged looks for a service losing its last live instance, waiting
+// out the delay first, or getting one back
+func (n *Notifier) serviceChanged(ctx context.Context, event catalog.ChangeEvent) {
+	name := event.Service.Name
+
+	if event.Service.IsAlive() {
+		n.Lock()
+		wasDown := n.down[name]
+		delete(n.down, name)
+		n.Unlock()
+
+		if wasDown {
+			n.send(true, Notification{
+				Kind:    KindServiceRecovered,
+				Key:     "service:" + n.ClusterName + ":" + name,
+				Subject: name,
+				Message: fmt.Sprintf("%s has an ALIVE instance again, on %s", name, event.Service.Hostname),
+			})
+		}
+		return
+	}
+
+	if event.PreviousStatus != service.ALIVE {
+		return
+	}
+
+	if n.ServiceDownDelay <= 0 {
+		n.checkService(name)
+		return
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.pending[name] {
+		return
+	}
+	n.pending[name] = true
+
+	time.AfterFunc(n.ServiceDownDelay, func() {
+		select {
+		case n.checks <- name:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// checkService sends a notification if none of the service's instances are
+// ALIVE and we haven't already said so
+func (n *Notifier) checkService(name string) {
+	var instances, alive int
+	var statuses []string
+
+	n.state.RLock()
+	n.state.EachService(func(_ *string, _ *string, svc *service.Service) {
+		if svc.Name != name {
+			return
+		}
+		instances++
+		if svc.IsAlive() {
+			alive++
+		} else if !svc.IsTombstone() {
+			statuses = append(statuses, svc.Hostname+" "+svc.StatusString())
+		}
+	})
+	n.state.RUnlock()
+
+	n.Lock()
+	delete(n.pending, name)
+	if instances == 0 || alive > 0 || n.down[name] {
+		n.Unlock()
+		return
+	}
+	n.down[name] = true
+	n.Unlock()
+
+	message := fmt.Sprintf("%s has no ALIVE instances", name)
+	if len(statuses) > 0 {
+		message = fmt.Sprintf("%s (%v)", message, statuses)
+	}
+
+	n.send(true, Notification{
+		Kind:     KindServiceDown,
+		Critical: true,
+		Key:      "service:" + n.ClusterName + ":" + name,
+		Subject:  name,
+		Message:  message,
+	})
+}
+
+// ServerExpired is called from the state when a host leaves the cluster and
+// its services are tombstoned. The state is locked, so we can't look at it.
+func (n *Notifier) ServerExpired(hostname string, services int) {
+	if services < 1 || hostname == n.Hostname {
+		return
+	}
+
+	n.Lock()
+	n.expired[hostname] = true
+	n.Unlock()
+
+	n.send(true, Notification{
+		Kind:     KindHostExpired,
+		Critical: true,
+		Key:      "host:" + n.ClusterName + ":" + hostname,
+		Subject:  hostname,
+		Message:  fmt.Sprintf("%s left the cluster, expiring %d services", hostname, services),
+	})
+}
+
+// HAproxyResult counts HAproxy's failed verifies and reloads since the last
+// successful reload, and says so once there have been too many, and again
+// when it recovers. Every reload is verified first, so a successful verify
+// doesn't mean HAproxy is working again.
+func (n *Notifier) HAproxyResult(result haproxy.ReloadResult) {
+	if n.HAproxyFailures < 1 {
+		return
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	if result.Success() {
+		if result.Action != "reload" {
+			return
+		}
+
+		n.haproxyFailed = 0
+		if n.haproxyFailing {
+			n.haproxyFailing = false
+			n.send(false, Notification{
+				Kind:    KindHAproxyRecovered,
+				Key:     "haproxy:" + n.ClusterName + ":" + n.Hostname,
+				Subject: n.Hostname,
+				Message: fmt.Sprintf("HAproxy on %s is reloading again", n.Hostname),
+			})
+		}
+		return
+	}
+
+	n.haproxyFailed++
+	if n.haproxyFailing || n.haproxyFailed < n.HAproxyFailures {
+		return
+	}
+
+	n.haproxyFailing = true
+	n.send(false, Notification{
+		Kind:     KindHAproxyFailing,
+		Critical: true,
+		Key:      "haproxy:" + n.ClusterName + ":" + n.Hostname,
+		Subject:  n.Hostname,
+		Message: fmt.Sprintf("HAproxy on %s has failed %d times in a row, last %s: %s",
+			n.Hostname, n.haproxyFailed, result.Action, firstNonEmpty(result.Stderr, result.Error)),
+	})
+}
+
+// NotifyJoin is part of the memberlist.EventDelegate interface. It lets us
+// say when an expired host comes back.
+func (n *Notifier) NotifyJoin(node *memberlist.Node) {
+	n.Lock()
+	wasExpired := n.expired[node.Name]
+	delete(n.expired, node.Name)
+	n.Unlock()
+
+	if wasExpired {
+		n.send(true, Notification{
+			Kind:    KindHostRejoined,
+			Key:     "host:" + n.ClusterName + ":" + node.Name,
+			Subject: node.Name,
+			Message: fmt.Sprintf("%s rejoined the cluster", node.Name),
+		})
+	}
+
+	if n.Next != nil {
+		n.Next.NotifyJoin(node)
+	}
+}
+
+// NotifyLeave is part of the memberlist.EventDelegate interface
+func (n *Notifier) NotifyLeave(node *memberlist.Node) {
+	if n.Next != nil {
+		n.Next.NotifyLeave(node)
+	}
+}
+
+// NotifyUpdate is part of the memberlist.EventDelegate interface
+func (n *Notifier) NotifyUpdate(node *memberlist.Node) {
+	if n.Next != nil {
+		n.Next.NotifyUpdate(node)
+	}
+}
+
+// A queued notification waiting to be sent
+type queued struct {
+	Notification
+	clusterWide bool
+}
+
+// send queues the notification. It's called from Memberlist and the state
+// with their locks held, so it never blocks, and the cluster-wide check is
+// left to sendQueued.
+func (n *Notifier) send(clusterWide bool, notification Notification) {
+	notification.Cluster = n.ClusterName
+	notification.Source = n.Hostname
+	notification.Time = n.now()
+
+	select {
+	case n.queue <- queued{Notification: notification, clusterWide: clusterWide}:
+	default:
+		metrics.IncrCounter([]string{"notify", "dropped"}, 1)
+		log.Warnf("Notification queue is full, dropping: %s", notification.Message)
+	}
+}
+
+// sendQueued sends each queued notification to every sink
+func (n *Notifier) sendQueued(ctx context.Context) {
+	for {
+		select {
+		case next := <-n.queue:
+			if next.clusterWide && n.IsSender != nil && !n.IsSender() {
+				continue
+			}
+			n.deliver(ctx, next.Notification)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, notification Notification) {
+	log.Infof("Sending notification: %s", notification.Message)
+
+	for _, sink := range n.Sinks {
+		sendCtx, cancel := context.WithTimeout(ctx, n.Timeout)
+		err := sink.Send(sendCtx, notification)
+		cancel()
+
+		if err != nil {
+			metrics.IncrCounterWithLabels([]string{"notify", "failures"}, 1,
+				[]metrics.Label{{Name: "sink", Value: sink.Name()}},
+			)
+			log.Errorf("Failed to send notification to %s: %s", sink.Name(), err)
+			continue
+		}
+
+		metrics.IncrCounterWithLabels([]string{"notify", "sent"}, 1,
+			[]metrics.Label{{Name: "sink", Value: sink.Name()}},
+		)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
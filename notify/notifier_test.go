@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A recordingSink keeps everything it's sent
+type recordingSink struct {
+	sent []Notification
+	sync.Mutex
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Send(ctx context.Context, n Notification) error {
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, n)
+	return nil
+}
+
+func (s *recordingSink) kinds() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var kinds []string
+	for _, n := range s.sent {
+		kinds = append(kinds, n.Kind)
+	}
+	return kinds
+}
+
+// waitForKinds waits a little for the sink to have been sent count notifications
+func (s *recordingSink) waitForKinds(count int) []string {
+	for i := 0; i < 100; i++ {
+		if kinds := s.kinds(); len(kinds) >= count {
+			return kinds
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.kinds()
+}
+
+func Test_Notifier(t *testing.T) {
+	Convey("Notifier", t, func() {
+		state := catalog.NewServicesState()
+		state.ClusterName = "default"
+		state.Hostname = "shakespeare"
+
+		sink := &recordingSink{}
+		notifier := NewNotifier(state, sink)
+
+		// Starts the notifier once it's configured, returning a func to stop it
+		start := func() func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				notifier.Run(ctx)
+				close(done)
+			}()
+
+			// Wait for the listener to be registered
+			for i := 0; i < 100 && len(state.GetListeners()) == 0; i++ {
+				time.Sleep(time.Millisecond)
+			}
+
+			return func() {
+				cancel()
+				<-done
+			}
+		}
+
+		baseTime := time.Now().UTC()
+		svc := func(id string, hostname string, status int, offset time.Duration) service.Service {
+			return service.Service{
+				ID: id, Name: "chaucer", Hostname: hostname,
+				Status: status, Updated: baseTime.Add(offset),
+			}
+		}
+
+		state.AddServiceEntry(svc("deadbeef1231", "shakespeare", service.ALIVE, 0))
+		state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.ALIVE, 0))
+
+		Convey("says when every instance of a service is down, and when it recovers", func() {
+			defer start()()
+
+			state.AddServiceEntry(svc("deadbeef1231", "shakespeare", service.UNHEALTHY, time.Second))
+			time.Sleep(50 * time.Millisecond)
+			So(sink.kinds(), ShouldBeEmpty)
+
+			state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.UNHEALTHY, time.Second))
+			So(sink.waitForKinds(1), ShouldResemble, []string{KindServiceDown})
+			So(sink.sent[0].Subject, ShouldEqual, "chaucer")
+			So(sink.sent[0].Critical, ShouldBeTrue)
+			So(sink.sent[0].Source, ShouldEqual, "shakespeare")
+			So(sink.sent[0].Cluster, ShouldEqual, "default")
+
+			// Only once
+			state.AddServiceEntry(svc("deadbeef1231", "shakespeare", service.UNKNOWN, 2*time.Second))
+			time.Sleep(50 * time.Millisecond)
+			So(len(sink.kinds()), ShouldEqual, 1)
+
+			state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.ALIVE, 3*time.Second))
+			So(sink.waitForKinds(2), ShouldResemble, []string{KindServiceDown, KindServiceRecovered})
+			So(sink.sent[1].Key, ShouldEqual, sink.sent[0].Key)
+		})
+
+		Convey("waits out the delay before saying a service is down", func() {
+			notifier.ServiceDownDelay = 100 * time.Millisecond
+			defer start()()
+
+			state.AddServiceEntry(svc("deadbeef1231", "shakespeare", service.UNHEALTHY, time.Second))
+			state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.UNHEALTHY, time.Second))
+			state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.ALIVE, 2*time.Second))
+
+			time.Sleep(200 * time.Millisecond)
+			So(sink.kinds(), ShouldBeEmpty)
+		})
+
+		Convey("only sends cluster-wide notifications from the sender", func() {
+			notifier.IsSender = func() bool { return false }
+			notifier.HAproxyFailures = 1
+			defer start()()
+
+			state.AddServiceEntry(svc("deadbeef1231", "shakespeare", service.UNHEALTHY, time.Second))
+			state.AddServiceEntry(svc("deadbeef1232", "marlowe", service.UNHEALTHY, time.Second))
+			notifier.HAproxyResult(haproxy.ReloadResult{Action: "reload", ExitStatus: 1})
+
+			So(sink.waitForKinds(1), ShouldResemble, []string{KindHAproxyFailing})
+		})
+
+		Convey("says when a host is expired, and when it comes back", func() {
+			defer start()()
+
+			state.OnServerExpired = notifier.ServerExpired
+			state.ExpireServer("marlowe")
+
+			So(sink.waitForKinds(1), ShouldResemble, []string{KindHostExpired})
+			So(sink.sent[0].Message, ShouldEqual, "marlowe left the cluster, expiring 1 services")
+
+			// Nothing left to expire
+			state.ExpireServer("marlowe")
+			notifier.NotifyJoin(&memberlist.Node{Name: "marlowe"})
+			So(sink.waitForKinds(2), ShouldResemble, []string{KindHostExpired, KindHostRejoined})
+		})
+
+		Convey("says when HAproxy keeps failing, and when it recovers", func() {
+			defer start()()
+
+			failed := haproxy.ReloadResult{Action: "reload", ExitStatus: 1, Stderr: "bad config"}
+			verified := haproxy.ReloadResult{Action: "verify"}
+			reloaded := haproxy.ReloadResult{Action: "reload"}
+
+			notifier.HAproxyResult(failed)
+			notifier.HAproxyResult(failed)
+			notifier.HAproxyResult(reloaded)
+			notifier.HAproxyResult(failed)
+			time.Sleep(50 * time.Millisecond)
+			So(sink.kinds(), ShouldBeEmpty)
+
+			// Every reload is verified first, which doesn't reset the count
+			notifier.HAproxyResult(verified)
+			notifier.HAproxyResult(failed)
+			notifier.HAproxyResult(verified)
+			notifier.HAproxyResult(failed)
+			So(sink.waitForKinds(1), ShouldResemble, []string{KindHAproxyFailing})
+			So(sink.sent[0].Message, ShouldContainSubstring, "bad config")
+
+			notifier.HAproxyResult(verified)
+			notifier.HAproxyResult(reloaded)
+			So(sink.waitForKinds(2), ShouldResemble, []string{KindHAproxyFailing, KindHAproxyRecovered})
+		})
+	})
+}
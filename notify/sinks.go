@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+)
+
+const (
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// A Sink delivers Notifications somewhere that people will see them
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+}
+
+// A Formatter turns a Notification into the JSON body for a webhook
+type Formatter func(n Notification) interface{}
+
+// A WebhookSink POSTs each Notification as JSON to a URL, in whatever shape
+// the Formatter makes it
+type WebhookSink struct {
+	SinkName  string
+	URL       string
+	Formatter Formatter
+	client    *http.Client
+}
+
+// NewWebhookSink returns a sink that POSTs the Notification as it is
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		SinkName:  "webhook",
+		URL:       url,
+		Formatter: FormatGeneric,
+		client:    cleanhttp.DefaultClient(),
+	}
+}
+
+// NewSlackSink returns a sink for a Slack incoming webhook
+func NewSlackSink(url string) *WebhookSink {
+	return &WebhookSink{
+		SinkName:  "slack",
+		URL:       url,
+		Formatter: FormatSlack,
+		client:    cleanhttp.DefaultClient(),
+	}
+}
+
+// NewPagerDutySink returns a sink that triggers PagerDuty incidents on the
+// service with the routing key, and resolves them on recovery
+func NewPagerDutySink(routingKey string) *WebhookSink {
+	return &WebhookSink{
+		SinkName:  "pagerduty",
+		URL:       PagerDutyEventsURL,
+		Formatter: PagerDutyFormatter(routingKey),
+		client:    cleanhttp.DefaultClient(),
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return s.SinkName
+}
+
+// Send POSTs the Notification, failing on anything other than a 2xx
+func (s *WebhookSink) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(s.Formatter(n))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s returned %d: %s", s.SinkName, resp.StatusCode, strings.TrimSpace(string(excerpt)))
+	}
+
+	// Read the rest so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// FormatGeneric sends the Notification as it is
+func FormatGeneric(n Notification) interface{} {
+	return n
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Title  string       `json:"title"`
+	Text   string       `json:"text"`
+	Fields []slackField `json:"fields"`
+	Ts     int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// FormatSlack makes a Slack message, red for trouble and green for recovery
+func FormatSlack(n Notification) interface{} {
+	color := "good"
+	if n.Critical {
+		color = "danger"
+	}
+
+	return slackMessage{
+		Text: n.Summary(),
+		Attachments: []slackAttachment{{
+			Color: color,
+			Title: n.Subject,
+			Text:  n.Message,
+			Fields: []slackField{
+				{Title: "Cluster", Value: n.Cluster, Short: true},
+				{Title: "Event", Value: n.Kind, Short: true},
+			},
+			Ts: n.Time.Unix(),
+		}},
+	}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details"`
+}
+
+// PagerDutyFormatter makes PagerDuty Events v2 API events. Trouble triggers
+// an incident, and recovery resolves the same one.
+func PagerDutyFormatter(routingKey string) Formatter {
+	return func(n Notification) interface{} {
+		event := pagerDutyEvent{
+			RoutingKey:  routingKey,
+			EventAction: "resolve",
+			DedupKey:    n.Key,
+		}
+
+		if n.Critical {
+			event.EventAction = "trigger"
+			event.Payload = &pagerDutyPayload{
+				Summary:   n.Summary(),
+				Source:    n.Source,
+				Severity:  "critical",
+				Timestamp: n.Time.Format("2006-01-02T15:04:05Z07:00"),
+				Component: n.Subject,
+				Class:     n.Kind,
+				CustomDetails: map[string]string{
+					"cluster": n.Cluster,
+					"message": n.Message,
+				},
+			}
+		}
+
+		return event
+	}
+}
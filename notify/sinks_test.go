@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_WebhookSink(t *testing.T) {
+	Convey("WebhookSink", t, func() {
+		var received map[string]interface{}
+		status := 200
+
+		server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(body, &received)
+			response.WriteHeader(status)
+			response.Write([]byte("no such hook"))
+		}))
+		defer server.Close()
+
+		notification := Notification{
+			Kind:     KindServiceDown,
+			Critical: true,
+			Key:      "service:default:chaucer",
+			Subject:  "chaucer",
+			Message:  "chaucer has no ALIVE instances",
+			Cluster:  "default",
+			Source:   "shakespeare",
+			Time:     time.Unix(1500000000, 0).UTC(),
+		}
+
+		Convey("posts the notification as it is", func() {
+			sink := NewWebhookSink(server.URL)
+			So(sink.Send(context.Background(), notification), ShouldBeNil)
+			So(received["Kind"], ShouldEqual, KindServiceDown)
+			So(received["Subject"], ShouldEqual, "chaucer")
+			So(received["Critical"], ShouldBeTrue)
+		})
+
+		Convey("formats messages for Slack", func() {
+			sink := NewSlackSink(server.URL)
+			So(sink.Send(context.Background(), notification), ShouldBeNil)
+			So(received["text"], ShouldEqual, "[default] chaucer has no ALIVE instances")
+
+			attachment := received["attachments"].([]interface{})[0].(map[string]interface{})
+			So(attachment["color"], ShouldEqual, "danger")
+			So(attachment["title"], ShouldEqual, "chaucer")
+		})
+
+		Convey("triggers and resolves PagerDuty incidents", func() {
+			sink := NewPagerDutySink("sekrit")
+			sink.URL = server.URL
+
+			So(sink.Send(context.Background(), notification), ShouldBeNil)
+			So(received["routing_key"], ShouldEqual, "sekrit")
+			So(received["event_action"], ShouldEqual, "trigger")
+			So(received["dedup_key"], ShouldEqual, "service:default:chaucer")
+
+			payload := received["payload"].(map[string]interface{})
+			So(payload["severity"], ShouldEqual, "critical")
+			So(payload["source"], ShouldEqual, "shakespeare")
+
+			received = nil
+			notification.Kind = KindServiceRecovered
+			notification.Critical = false
+			So(sink.Send(context.Background(), notification), ShouldBeNil)
+			So(received["event_action"], ShouldEqual, "resolve")
+			So(received["dedup_key"], ShouldEqual, "service:default:chaucer")
+			So(received["payload"], ShouldBeNil)
+		})
+
+		Convey("fails when the hook does", func() {
+			status = 404
+			err := NewWebhookSink(server.URL).Send(context.Background(), notification)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "404: no such hook")
+		})
+	})
+}
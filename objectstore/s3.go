@@ -0,0 +1,222 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+)
+
+const (
+	DefaultRegion = "us-east-1"
+	GCSEndpoint   = "https://storage.googleapis.com"
+)
+
+// Credentials sign the requests to an S3 compatible store
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // For temporary credentials, may be empty
+}
+
+// Validate makes sure there's a key to sign with. Without one, every upload
+// would be turned away.
+func (c Credentials) Validate() error {
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return fmt.Errorf("missing object store credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	return nil
+}
+
+// EnvCredentials returns the credentials from the standard AWS environment
+// variables. GCS HMAC keys go in the same variables.
+func EnvCredentials() Credentials {
+	return Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// An S3Store writes objects to an S3 bucket, or to anything that speaks the
+// S3 API and accepts its signatures, like GCS in interoperability mode or
+// MinIO. Objects are addressed by path, as Endpoint/Bucket/Key.
+type S3Store struct {
+	Endpoint    string // e.g. https://s3.us-west-2.amazonaws.com
+	Region      string
+	Bucket      string
+	Credentials Credentials
+	now         func() time.Time
+	client      *http.Client
+}
+
+// NewS3Store returns a store for the bucket. An empty endpoint means AWS S3
+// in the region.
+func NewS3Store(endpoint string, region string, bucket string, creds Credentials) *S3Store {
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	return &S3Store{
+		Endpoint:    strings.TrimRight(endpoint, "/"),
+		Region:      region,
+		Bucket:      bucket,
+		Credentials: creds,
+		now:         func() time.Time { return time.Now().UTC() },
+		client:      cleanhttp.DefaultClient(),
+	}
+}
+
+// Name is part of the Store interface
+func (s *S3Store) Name() string {
+	return s.Endpoint + "/" + s.Bucket
+}
+
+// Put uploads the object, failing on anything other than a 2xx
+func (s *S3Store) Put(ctx context.Context, object Object) error {
+	objectURL := s.Endpoint + "/" + escapePath(s.Bucket) + "/" + escapePath(object.Key)
+
+	req, err := http.NewRequest("PUT", objectURL, bytes.NewReader(object.Data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if object.ContentType != "" {
+		req.Header.Set("Content-Type", object.ContentType)
+	}
+	if object.ContentEncoding != "" {
+		req.Header.Set("Content-Encoding", object.ContentEncoding)
+	}
+
+	sum := sha256.Sum256(object.Data)
+	signV4(req, hex.EncodeToString(sum[:]), s.Credentials, s.Region, "s3", s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s returned %d: %s", s.Name(), resp.StatusCode, strings.TrimSpace(string(excerpt)))
+	}
+
+	// Read the rest so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to the
+// request, signing the host and every header already set on it. The
+// payloadHash is the hex SHA256 of the body.
+func signV4(req *http.Request, payloadHash string, creds Credentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes the query the way signatures need
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// escapePath encodes each segment of an object path, keeping the slashes
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape encodes everything but the unreserved characters, as S3 expects
+func escape(str string) string {
+	return strings.ReplaceAll(url.QueryEscape(str), "+", "%20")
+}
@@ -0,0 +1,63 @@
+// Package objectstore writes snapshots of the catalog to an object store, so
+// batch jobs and disaster recovery tooling can read the cluster topology
+// without talking to live nodes.
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// An Object is what gets written to a Store
+type Object struct {
+	Key             string
+	Data            []byte
+	ContentType     string
+	ContentEncoding string
+}
+
+// A Store is somewhere we can write objects
+type Store interface {
+	Name() string
+	Put(ctx context.Context, object Object) error
+}
+
+// ParseURL works out the store and the object key from a URL like
+// s3://bucket/path/state.json.gz or gs://bucket/path/state.json.gz. The
+// endpoint overrides where the requests go, e.g. for MinIO. GCS is used
+// through its S3 compatible API, so the credentials must be HMAC keys.
+// Returns an error when the credentials are missing.
+func ParseURL(storeURL string, endpoint string, region string, creds Credentials) (Store, string, error) {
+	parsed, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid object store URL %q: %w", storeURL, err)
+	}
+
+	bucket := parsed.Host
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, "", fmt.Errorf("object store URL %q needs both a bucket and a path", storeURL)
+	}
+
+	switch parsed.Scheme {
+	case "s3":
+	case "gs":
+		if endpoint == "" {
+			endpoint = GCSEndpoint
+		}
+		if region == "" || region == DefaultRegion {
+			region = "auto"
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown object store %q, expected s3:// or gs://", parsed.Scheme)
+	}
+
+	err = creds.Validate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return NewS3Store(endpoint, region, bucket, creds), key, nil
+}
@@ -0,0 +1,201 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/logging"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	DefaultMinInterval     = 1 * time.Minute  // The least time between uploads
+	DefaultRefreshInterval = 10 * time.Minute // Upload this often even without changes
+	DefaultTimeout         = 30 * time.Second // How long one upload can take
+)
+
+var log = logging.Module("objectstore")
+
+// An Uploader writes a gzipped JSON catalog.Snapshot of the state to a Store
+// when the state changes, but no more often than MinInterval. It uploads
+// again every RefreshInterval even when nothing changes, so readers can tell
+// the snapshot is current from its Taken time. Snapshots can be loaded into
+// a cluster with the /state/import endpoint. Every node sees the same state,
+// so only the node that IsSender picks uploads.
+type Uploader struct {
+	Store           Store
+	Key             string
+	MinInterval     time.Duration
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+	IsSender        func() bool // Whether we upload, nil for always
+
+	eventChannel chan catalog.ChangeEvent
+	lastUpload   time.Time
+	lastVersion  uint64
+}
+
+// NewUploader returns an Uploader for the key in the store, with the default
+// intervals
+func NewUploader(store Store, key string) *Uploader {
+	return &Uploader{
+		Store:           store,
+		Key:             key,
+		MinInterval:     DefaultMinInterval,
+		RefreshInterval: DefaultRefreshInterval,
+		Timeout:         DefaultTimeout,
+		eventChannel:    make(chan catalog.ChangeEvent, 2),
+	}
+}
+
+// Watch uploads the state, then uploads it again whenever it changes, until
+// the context is cancelled
+func (u *Uploader) Watch(ctx context.Context, state *catalog.ServicesState) {
+	// Each upload is the whole state, so we only need to know that something
+	// changed, not what
+	state.AddListener(u, catalog.WithOverflowResync())
+
+	// Checks for anything we held back, and for the refresh
+	ticker := time.NewTicker(u.checkInterval())
+	defer ticker.Stop()
+
+	pending := true
+	u.maybeUpload(ctx, state, &pending)
+
+OUTER:
+	for {
+		select {
+		case _, ok := <-u.eventChannel:
+			if !ok {
+				break OUTER
+			}
+			pending = true
+			u.maybeUpload(ctx, state, &pending)
+		case <-ticker.C:
+			u.maybeUpload(ctx, state, &pending)
+		case <-ctx.Done():
+			break OUTER
+		}
+	}
+
+	err := state.RemoveListener(u.Name())
+	if err != nil {
+		log.Warnf("Failed to remove state uploader listener: %s", err)
+	}
+}
+
+// checkInterval is how often we need to wake up to honor the intervals
+func (u *Uploader) checkInterval() time.Duration {
+	interval := u.MinInterval
+	if interval <= 0 || (u.RefreshInterval > 0 && u.RefreshInterval < interval) {
+		interval = u.RefreshInterval
+	}
+	if interval <= 0 {
+		interval = DefaultMinInterval
+	}
+	return interval
+}
+
+// maybeUpload uploads if there's a change waiting and it's been long enough
+// since the last upload, or if it's time for a refresh. Clears pending once
+// the change is uploaded.
+func (u *Uploader) maybeUpload(ctx context.Context, state *catalog.ServicesState, pending *bool) {
+	if u.IsSender != nil && !u.IsSender() {
+		return
+	}
+
+	since := time.Since(u.lastUpload)
+	refresh := u.RefreshInterval > 0 && since >= u.RefreshInterval
+	changed := *pending && state.Version() != u.lastVersion
+
+	if !refresh && (!changed || since < u.MinInterval) {
+		if *pending && !changed {
+			*pending = false
+		}
+		return
+	}
+
+	err := u.Upload(ctx, state)
+	if err != nil {
+		metrics.IncrCounter([]string{"objectstore", "errors"}, 1)
+		log.Errorf("Failed to upload the state to %s: %s", u.Store.Name(), err)
+		// Try again on the next check, rather than right away
+		u.lastUpload = time.Now()
+		return
+	}
+
+	*pending = false
+}
+
+// Upload writes a snapshot of the state to the store now
+func (u *Uploader) Upload(ctx context.Context, state *catalog.ServicesState) error {
+	defer metrics.MeasureSince([]string{"objectstore", "upload"}, time.Now())
+
+	// Read the version first, so a change while we snapshot is uploaded next
+	// time rather than missed
+	version := state.Version()
+	data, err := encodeSnapshot(state.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	uploadCtx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	err = u.Store.Put(uploadCtx, Object{
+		Key:             u.Key,
+		Data:            data,
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+	})
+	if err != nil {
+		return err
+	}
+
+	u.lastUpload = time.Now()
+	u.lastVersion = version
+
+	metrics.IncrCounter([]string{"objectstore", "uploads"}, 1)
+	metrics.SetGauge([]string{"objectstore", "bytes"}, float32(len(data)))
+	log.Debugf("Uploaded %d bytes of state to %s/%s", len(data), u.Store.Name(), u.Key)
+
+	return nil
+}
+
+// encodeSnapshot turns the snapshot into gzipped JSON
+func encodeSnapshot(snapshot *catalog.Snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+
+	err := json.NewEncoder(writer).Encode(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Name is part of the catalog.Listener interface
+func (u *Uploader) Name() string {
+	return "StateUploader"
+}
+
+// Managed is part of the catalog.Listener interface. We add and remove
+// ourselves.
+func (u *Uploader) Managed() bool {
+	return false
+}
+
+// Chan is part of the catalog.Listener interface
+func (u *Uploader) Chan() chan catalog.ChangeEvent {
+	return u.eventChannel
+}
@@ -0,0 +1,136 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeStore struct {
+	objects []Object
+	err     error
+}
+
+func (f *fakeStore) Name() string { return "fake" }
+
+func (f *fakeStore) Put(ctx context.Context, object Object) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.objects = append(f.objects, object)
+	return nil
+}
+
+func Test_Uploader(t *testing.T) {
+	Convey("Uploader", t, func() {
+		state := catalog.NewServicesState()
+		state.ClusterName = "cantebury"
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Updated: time.Now().UTC(), Status: service.ALIVE,
+		})
+
+		store := &fakeStore{}
+		uploader := NewUploader(store, "prod/state.json.gz")
+		ctx := context.Background()
+
+		Convey("uploads a gzipped JSON snapshot", func() {
+			So(uploader.Upload(ctx, state), ShouldBeNil)
+			So(len(store.objects), ShouldEqual, 1)
+
+			object := store.objects[0]
+			So(object.Key, ShouldEqual, "prod/state.json.gz")
+			So(object.ContentEncoding, ShouldEqual, "gzip")
+
+			reader, err := gzip.NewReader(bytes.NewReader(object.Data))
+			So(err, ShouldBeNil)
+
+			var snapshot catalog.Snapshot
+			So(json.NewDecoder(reader).Decode(&snapshot), ShouldBeNil)
+			So(snapshot.ClusterName, ShouldEqual, "cantebury")
+			So(snapshot.Servers["chaucer"].Services["deadbeef123"].Name, ShouldEqual, "bocaccio")
+		})
+
+		Convey("uploads a change right away the first time", func() {
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 1)
+			So(pending, ShouldBeFalse)
+		})
+
+		Convey("holds back changes until MinInterval has passed", func() {
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef456", Name: "dante", Hostname: "chaucer",
+				Updated: time.Now().UTC(), Status: service.ALIVE,
+			})
+			pending = true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 1)
+			So(pending, ShouldBeTrue)
+
+			uploader.lastUpload = time.Now().Add(-2 * uploader.MinInterval)
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 2)
+			So(pending, ShouldBeFalse)
+		})
+
+		Convey("doesn't upload when nothing changed", func() {
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			uploader.lastUpload = time.Now().Add(-2 * uploader.MinInterval)
+			pending = true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 1)
+			So(pending, ShouldBeFalse)
+		})
+
+		Convey("uploads again after RefreshInterval", func() {
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			uploader.lastUpload = time.Now().Add(-2 * uploader.RefreshInterval)
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 2)
+		})
+
+		Convey("only uploads from the sender", func() {
+			uploader.IsSender = func() bool { return false }
+
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 0)
+		})
+
+		Convey("waits before trying again after a failure", func() {
+			store.err = errors.New("oh no")
+
+			pending := true
+			uploader.maybeUpload(ctx, state, &pending)
+			So(pending, ShouldBeTrue)
+
+			store.err = nil
+			uploader.maybeUpload(ctx, state, &pending)
+
+			So(len(store.objects), ShouldEqual, 0)
+			So(pending, ShouldBeTrue)
+		})
+	})
+}
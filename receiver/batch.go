@@ -0,0 +1,121 @@
+package receiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	log "github.com/sirupsen/logrus"
+)
+
+// payloads returns the payload shapes we take from UrlListeners, by preference
+func (rcvr *Receiver) payloads() []string {
+	if len(rcvr.Payloads) > 0 {
+		return rcvr.Payloads
+	}
+	return []string{catalog.PayloadDelta, catalog.PayloadFull}
+}
+
+// batchHandler applies a StateBatch posted by an UrlListener that did the
+// handshake. When we have no state to apply a delta to, we reply with a 409
+// and the listener sends the whole state instead.
+func batchHandler(response http.ResponseWriter, data []byte, payload string, rcvr *Receiver) {
+	var batch catalog.StateBatch
+	err := json.Unmarshal(data, &batch)
+	if err == nil && payload == catalog.PayloadFull && batch.State == nil {
+		err = errors.New("full payload is missing the state")
+	}
+	if err != nil {
+		replyError(response, http.StatusBadRequest, err)
+		return
+	}
+
+	rcvr.StateLock.Lock()
+	defer rcvr.StateLock.Unlock()
+
+	rcvr.lastUpdated = time.Now().UTC()
+
+	switch payload {
+	case catalog.PayloadFull:
+		if rcvr.CurrentState != nil && !rcvr.CurrentState.LastChanged.Before(batch.State.LastChanged) {
+			return
+		}
+		rcvr.CurrentState = batch.State
+
+	case catalog.PayloadDelta:
+		if rcvr.CurrentState == nil {
+			replyError(response, http.StatusConflict, errors.New("no state to apply the delta to"))
+			return
+		}
+		if !rcvr.CurrentState.LastChanged.Before(batch.LastChanged) {
+			return
+		}
+		applyChanges(rcvr.CurrentState, batch.Changes, batch.LastChanged)
+
+	default:
+		replyError(response, http.StatusBadRequest, fmt.Errorf("unknown payload %q", payload))
+		return
+	}
+
+	rcvr.notifyChanges(batch.Changes)
+}
+
+// applyChanges updates the state with the services from a delta payload,
+// skipping any that are older than the records we already have
+func applyChanges(state *catalog.ServicesState, changes []catalog.ChangeEvent, lastChanged time.Time) {
+	for _, change := range changes {
+		svc := change.Service
+
+		server, ok := state.Servers[svc.Hostname]
+		if !ok {
+			server = catalog.NewServer(svc.Hostname)
+			state.Servers[svc.Hostname] = server
+		}
+
+		if existing, ok := server.Services[svc.ID]; ok && !svc.Updated.After(existing.Updated) {
+			continue
+		}
+
+		server.Services[svc.ID] = &svc
+		server.LastUpdated = svc.Updated
+		server.LastChanged = change.Time
+	}
+
+	state.LastChanged = lastChanged
+}
+
+// notifyChanges enqueues a single update if any of the changes would
+// affect service availability for our subscriptions. Expects the caller to
+// hold the StateLock.
+func (rcvr *Receiver) notifyChanges(changes []catalog.ChangeEvent) {
+	var notify bool
+	for i, change := range changes {
+		rcvr.LastSvcChanged = &changes[i].Service
+
+		if ShouldNotify(change.PreviousStatus, change.Service.Status) && rcvr.IsSubscribed(change.Service.Name) {
+			notify = true
+		}
+	}
+
+	if !notify {
+		return
+	}
+
+	if rcvr.OnUpdate == nil {
+		log.Errorf("No OnUpdate() callback registered!")
+		return
+	}
+	rcvr.EnqueueUpdate()
+}
+
+func replyError(response http.ResponseWriter, status int, err error) {
+	message, _ := json.Marshal(ApiErrors{[]string{err.Error()}})
+	response.WriteHeader(status)
+	_, err = response.Write(message)
+	if err != nil {
+		log.Errorf("Error replying to client: %s", err)
+	}
+}
@@ -0,0 +1,184 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/mohae/deepcopy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_batchHandler(t *testing.T) {
+	Convey("UpdateHandler() with batches", t, func() {
+		hostname := "chaucer"
+		baseTime := time.Now().UTC().Add(-time.Minute)
+
+		state := catalog.NewServicesState()
+		state.Servers[hostname] = catalog.NewServer(hostname)
+		state.AddServiceEntry(service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: hostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		})
+
+		rcvr := NewReceiver(10, func(state *catalog.ServicesState) {})
+		recorder := httptest.NewRecorder()
+
+		post := func(payload string, batch catalog.StateBatch, gzipped bool) int {
+			encoded, _ := json.Marshal(batch)
+			if gzipped {
+				var buf bytes.Buffer
+				zipper := gzip.NewWriter(&buf)
+				zipper.Write(encoded)
+				zipper.Close()
+				encoded = buf.Bytes()
+			}
+
+			req := httptest.NewRequest("POST", "/update", bytes.NewBuffer(encoded))
+			req.Header.Set(catalog.PayloadHeader, payload)
+			if gzipped {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			UpdateHandler(recorder, req, rcvr)
+			return recorder.Result().StatusCode
+		}
+
+		tombstone := catalog.ChangeEvent{
+			Service: service.Service{
+				ID:       "deadbeef123",
+				Name:     "bocaccio",
+				Hostname: hostname,
+				Updated:  baseTime.Add(time.Second),
+				Status:   service.TOMBSTONE,
+			},
+			PreviousStatus: service.ALIVE,
+			Time:           baseTime.Add(time.Second),
+		}
+
+		Convey("answers the handshake", func() {
+			req := httptest.NewRequest("OPTIONS", "/update", nil)
+			UpdateHandler(recorder, req, rcvr)
+
+			resp := recorder.Result()
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.Header.Get(catalog.PayloadsHeader), ShouldEqual, "delta, full")
+			So(resp.Header.Get("Accept-Encoding"), ShouldEqual, "gzip")
+
+			rcvr.Payloads = []string{catalog.PayloadFull}
+			recorder = httptest.NewRecorder()
+			UpdateHandler(recorder, req, rcvr)
+			So(recorder.Result().Header.Get(catalog.PayloadsHeader), ShouldEqual, "full")
+		})
+
+		Convey("takes the whole state, gzipped", func() {
+			evtState := deepcopy.Copy(state).(*catalog.ServicesState)
+			evtState.LastChanged = time.Now().UTC()
+
+			status := post(catalog.PayloadFull, catalog.StateBatch{
+				State:       evtState,
+				Changes:     []catalog.ChangeEvent{tombstone},
+				LastChanged: evtState.LastChanged,
+			}, true)
+
+			So(status, ShouldEqual, 200)
+			So(rcvr.CurrentState.LastChanged, ShouldResemble, evtState.LastChanged)
+			So(rcvr.LastSvcChanged.ID, ShouldEqual, "deadbeef123")
+			So(len(rcvr.ReloadChan), ShouldEqual, 1)
+		})
+
+		Convey("gunzip() refuses updates that unzip to too much", func() {
+			var buf bytes.Buffer
+			zipper := gzip.NewWriter(&buf)
+			zipper.Write(make([]byte, 1025))
+			zipper.Close()
+
+			_, err := gunzip(buf.Bytes(), 1024)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "more than 1024 bytes")
+
+			unzipped, err := gunzip(buf.Bytes(), 1025)
+			So(err, ShouldBeNil)
+			So(unzipped, ShouldHaveLength, 1025)
+		})
+
+		Convey("rejects a full payload without a state", func() {
+			So(post(catalog.PayloadFull, catalog.StateBatch{}, false), ShouldEqual, 400)
+		})
+
+		Convey("asks for the whole state when it has nothing to apply a delta to", func() {
+			status := post(catalog.PayloadDelta, catalog.StateBatch{
+				Changes:     []catalog.ChangeEvent{tombstone},
+				LastChanged: time.Now().UTC(),
+			}, false)
+
+			So(status, ShouldEqual, 409)
+			So(rcvr.CurrentState, ShouldBeNil)
+		})
+
+		Convey("applies deltas to the state", func() {
+			rcvr.CurrentState = state
+			lastChanged := time.Now().UTC()
+
+			added := catalog.ChangeEvent{
+				Service: service.Service{
+					ID:       "cafebabe456",
+					Name:     "shakespeare",
+					Hostname: "marlowe",
+					Updated:  baseTime,
+					Status:   service.ALIVE,
+				},
+				PreviousStatus: service.UNKNOWN,
+				Time:           baseTime,
+			}
+
+			stale := tombstone
+			stale.Service.Updated = baseTime.Add(-time.Hour)
+			stale.Service.Status = service.UNHEALTHY
+
+			status := post(catalog.PayloadDelta, catalog.StateBatch{
+				Changes:     []catalog.ChangeEvent{tombstone, added, stale},
+				LastChanged: lastChanged,
+			}, false)
+
+			So(status, ShouldEqual, 200)
+			So(rcvr.CurrentState.LastChanged, ShouldResemble, lastChanged)
+			So(rcvr.CurrentState.Servers[hostname].Services["deadbeef123"].Status, ShouldEqual, service.TOMBSTONE)
+			So(rcvr.CurrentState.Servers["marlowe"].Services["cafebabe456"].Name, ShouldEqual, "shakespeare")
+			So(len(rcvr.ReloadChan), ShouldEqual, 1)
+
+			Convey("and ignores old ones", func() {
+				rcvr.CurrentState.Servers[hostname].Services = nil
+
+				status := post(catalog.PayloadDelta, catalog.StateBatch{
+					Changes:     []catalog.ChangeEvent{tombstone},
+					LastChanged: lastChanged,
+				}, false)
+
+				So(status, ShouldEqual, 200)
+				So(rcvr.CurrentState.Servers[hostname].Services, ShouldBeNil)
+			})
+		})
+
+		Convey("only notifies for subscribed services", func() {
+			rcvr.CurrentState = state
+			rcvr.Subscribe("another-service")
+
+			status := post(catalog.PayloadDelta, catalog.StateBatch{
+				Changes:     []catalog.ChangeEvent{tombstone},
+				LastChanged: time.Now().UTC(),
+			}, false)
+
+			So(status, ShouldEqual, 200)
+			So(len(rcvr.ReloadChan), ShouldEqual, 0)
+		})
+	})
+}
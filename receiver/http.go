@@ -1,9 +1,15 @@
 package receiver
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
 	log "github.com/sirupsen/logrus"
@@ -13,9 +19,25 @@ type ApiErrors struct {
 	Errors []string `json:"errors"`
 }
 
-// Receives POSTed state updates from a Sidecar instance
+// Receives POSTed state updates from a Sidecar instance. Also answers the
+// UrlListener's OPTIONS handshake, and takes batched and gzipped updates.
 func UpdateHandler(response http.ResponseWriter, req *http.Request, rcvr *Receiver) {
 	defer req.Body.Close()
+
+	err := rcvr.checkPeer(req)
+	if err != nil {
+		log.Warnf("Rejected an update from %s: %s", rcvr.identity(req), err)
+		replyError(response, http.StatusForbidden, err)
+		return
+	}
+
+	if req.Method == http.MethodOptions {
+		response.Header().Set(catalog.PayloadsHeader, strings.Join(rcvr.payloads(), ", "))
+		response.Header().Set("Accept-Encoding", "gzip")
+		response.WriteHeader(http.StatusOK)
+		return
+	}
+
 	response.Header().Set("Content-Type", "application/json")
 
 	data, err := ioutil.ReadAll(req.Body)
@@ -29,6 +51,31 @@ func UpdateHandler(response http.ResponseWriter, req *http.Request, rcvr *Receiv
 		return
 	}
 
+	// The signature covers the body as it was sent, so we check it first
+	if rcvr.Verifier != nil {
+		err = rcvr.Verifier.Verify(req, data)
+		if err != nil {
+			log.Warnf("Rejected an update from %s: %s", rcvr.identity(req), err)
+			replyError(response, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	log.Debugf("Took an update from %s", rcvr.identity(req))
+
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		data, err = gunzip(data, MAX_UPDATE_BYTES)
+		if err != nil {
+			replyError(response, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if payload := req.Header.Get(catalog.PayloadHeader); payload != "" {
+		batchHandler(response, data, payload, rcvr)
+		return
+	}
+
 	var evt catalog.StateChangedEvent
 	err = json.Unmarshal(data, &evt)
 	if err != nil {
@@ -44,6 +91,8 @@ func UpdateHandler(response http.ResponseWriter, req *http.Request, rcvr *Receiv
 	rcvr.StateLock.Lock()
 	defer rcvr.StateLock.Unlock()
 
+	rcvr.lastUpdated = time.Now().UTC()
+
 	if rcvr.CurrentState == nil || rcvr.CurrentState.LastChanged.Before(evt.State.LastChanged) {
 		rcvr.CurrentState = evt.State
 		rcvr.LastSvcChanged = &evt.ChangeEvent.Service
@@ -61,3 +110,51 @@ func UpdateHandler(response http.ResponseWriter, req *http.Request, rcvr *Receiv
 		}
 	}
 }
+
+// LastUpdatedResponse tells monitoring how fresh the receiver's state is
+type LastUpdatedResponse struct {
+	LastUpdated time.Time // When we last took an update from Sidecar, zero if we haven't yet
+	LastChanged time.Time // When the state last changed, zero if we have none
+}
+
+// LastUpdatedHandler reports when we last heard from Sidecar, and when the
+// state we have last changed. Mount it wherever suits, e.g. /last_updated.
+func LastUpdatedHandler(response http.ResponseWriter, req *http.Request, rcvr *Receiver) {
+	defer req.Body.Close()
+
+	rcvr.StateLock.Lock()
+	status := LastUpdatedResponse{LastUpdated: rcvr.lastUpdated}
+	if rcvr.CurrentState != nil {
+		status.LastChanged = rcvr.CurrentState.LastChanged
+	}
+	rcvr.StateLock.Unlock()
+
+	message, _ := json.Marshal(status)
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	_, err := response.Write(message)
+	if err != nil {
+		log.Errorf("Error replying to client: %s", err)
+	}
+}
+
+// gunzip unzips a gzipped update, refusing any that unzip to more than
+// limit bytes, so that a small payload can't fill up the memory
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	unzipper, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer unzipper.Close()
+
+	unzipped, err := ioutil.ReadAll(io.LimitReader(unzipper, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(unzipped)) > limit {
+		return nil, fmt.Errorf("update unzips to more than %d bytes", limit)
+	}
+
+	return unzipped, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
@@ -105,6 +106,33 @@ func Test_updateHandler(t *testing.T) {
 			So(received, ShouldBeTrue)
 		})
 
+		Convey("checks the signature when it has a verifier", func() {
+			keysFile, err := ioutil.TempFile("", "keys")
+			So(err, ShouldBeNil)
+			defer os.Remove(keysFile.Name())
+			keysFile.WriteString("current s3cret\n")
+			keysFile.Close()
+
+			rcvr.Verifier, err = catalog.NewListenerSigner(catalog.SigningJWT, keysFile.Name(), "")
+			So(err, ShouldBeNil)
+
+			evtState := deepcopy.Copy(state).(*catalog.ServicesState)
+			evtState.LastChanged = time.Now().UTC()
+			encoded, _ := json.Marshal(catalog.StateChangedEvent{State: evtState})
+
+			req := httptest.NewRequest("POST", "/update", bytes.NewBuffer(encoded))
+			UpdateHandler(recorder, req, rcvr)
+			So(recorder.Result().StatusCode, ShouldEqual, 401)
+			So(rcvr.CurrentState.LastChanged, ShouldNotResemble, evtState.LastChanged)
+
+			req = httptest.NewRequest("POST", "/update", bytes.NewBuffer(encoded))
+			So(rcvr.Verifier.Sign(req, encoded), ShouldBeNil)
+			recorder = httptest.NewRecorder()
+			UpdateHandler(recorder, req, rcvr)
+			So(recorder.Result().StatusCode, ShouldEqual, 200)
+			So(rcvr.CurrentState.LastChanged, ShouldResemble, evtState.LastChanged)
+		})
+
 		Convey("enqueues all updates if no Subscriptions are provided", func() {
 			evtState := deepcopy.Copy(state).(*catalog.ServicesState)
 			evtState.LastChanged = time.Now().UTC()
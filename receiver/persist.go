@@ -0,0 +1,104 @@
+package receiver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadState restores the last state we handed to OnUpdate from the StateFile.
+// After a restart, that lets us apply deltas straight away, and skip the
+// update in FetchInitialState when nothing we're subscribed to has changed
+// since. Doesn't call OnUpdate. A missing file is not an error, nor is an
+// empty StateFile.
+func (rcvr *Receiver) LoadState() error {
+	if rcvr.StateFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(rcvr.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the saved state: %w", err)
+	}
+
+	state, err := catalog.Decode(data)
+	if err != nil {
+		return fmt.Errorf("unable to decode the saved state in %s: %w", rcvr.StateFile, err)
+	}
+
+	rcvr.StateLock.Lock()
+	defer rcvr.StateLock.Unlock()
+
+	rcvr.CurrentState = state
+	log.Infof("Restored state last changed at %s from %s", state.LastChanged, rcvr.StateFile)
+
+	return nil
+}
+
+// saveState writes the state to the StateFile, if there is one. It's written
+// to a temporary file first so that a crash can't leave a partial state.
+func (rcvr *Receiver) saveState(state *catalog.ServicesState) {
+	if rcvr.StateFile == "" {
+		return
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(rcvr.StateFile), filepath.Base(rcvr.StateFile)+".tmp")
+	if err != nil {
+		log.Errorf("Unable to save the state: %s", err)
+		return
+	}
+	defer os.Remove(tmpFile.Name()) // Fails harmlessly once it's renamed
+
+	_, err = tmpFile.Write(state.Encode())
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), rcvr.StateFile)
+	}
+	if err != nil {
+		log.Errorf("Unable to save the state to %s: %s", rcvr.StateFile, err)
+	}
+}
+
+// subscriptionsChanged tells whether any of the services we're subscribed to
+// differ between the two states in a way that matters to a proxy
+func (rcvr *Receiver) subscriptionsChanged(oldState, newState *catalog.ServicesState) bool {
+	oldServices := rcvr.subscribedServices(oldState)
+	newServices := rcvr.subscribedServices(newState)
+
+	if len(oldServices) != len(newServices) {
+		return true
+	}
+
+	for key, newSvc := range newServices {
+		oldSvc, ok := oldServices[key]
+		if !ok || oldSvc.Status != newSvc.Status || oldSvc.ProxyMode != newSvc.ProxyMode ||
+			!reflect.DeepEqual(oldSvc.Ports, newSvc.Ports) || !reflect.DeepEqual(oldSvc.Tags, newSvc.Tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscribedServices returns the services we're subscribed to, by hostname
+// and ID
+func (rcvr *Receiver) subscribedServices(state *catalog.ServicesState) map[string]*service.Service {
+	services := make(map[string]*service.Service)
+	state.EachService(func(hostname *string, serviceId *string, svc *service.Service) {
+		if rcvr.IsSubscribed(svc.Name) {
+			services[*hostname+"/"+*serviceId] = svc
+		}
+	})
+	return services
+}
@@ -0,0 +1,118 @@
+package receiver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+func Test_StatePersistence(t *testing.T) {
+	Convey("Persisting the state", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-receiver")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		stateUrl := "http://localhost:7777/api/state.json"
+		httpmock.Activate()
+		Reset(func() { httpmock.DeactivateAndReset() })
+
+		baseTime := time.Now().UTC().Round(time.Second)
+		state := catalog.NewServicesState()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Status: service.ALIVE,
+			Ports:  []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "10.0.0.1"}},
+		})
+
+		serveState := func(state *catalog.ServicesState) {
+			httpmock.RegisterResponder("GET", stateUrl,
+				func(req *http.Request) (*http.Response, error) {
+					return httpmock.NewStringResponse(200, string(state.Encode())), nil
+				},
+			)
+		}
+
+		var updates int
+		newReceiver := func() *Receiver {
+			rcvr := NewReceiver(10, func(state *catalog.ServicesState) { updates++ })
+			rcvr.StateFile = filepath.Join(dir, "state.json")
+			return rcvr
+		}
+
+		Convey("LoadState() is fine without a saved state", func() {
+			rcvr := newReceiver()
+			So(rcvr.LoadState(), ShouldBeNil)
+			So(rcvr.CurrentState, ShouldBeNil)
+		})
+
+		Convey("LoadState() returns an error for a broken file", func() {
+			rcvr := newReceiver()
+			So(ioutil.WriteFile(rcvr.StateFile, []byte("so bad!"), 0644), ShouldBeNil)
+			So(rcvr.LoadState(), ShouldNotBeNil)
+		})
+
+		Convey("after a restart", func() {
+			serveState(state)
+			So(newReceiver().FetchInitialState(stateUrl), ShouldBeNil)
+			So(updates, ShouldEqual, 1)
+
+			rcvr := newReceiver()
+			So(rcvr.LoadState(), ShouldBeNil)
+			So(rcvr.CurrentState, ShouldNotBeNil)
+			So(rcvr.CurrentState.LastChanged, ShouldEqual, state.LastChanged)
+
+			Convey("skips the update when nothing changed", func() {
+				So(rcvr.FetchInitialState(stateUrl), ShouldBeNil)
+				So(updates, ShouldEqual, 1)
+			})
+
+			Convey("updates when a service we're subscribed to changed", func() {
+				state.AddServiceEntry(service.Service{
+					ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime.Add(time.Second),
+					Status: service.UNHEALTHY,
+					Ports:  []service.Port{{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "10.0.0.1"}},
+				})
+
+				So(rcvr.FetchInitialState(stateUrl), ShouldBeNil)
+				So(updates, ShouldEqual, 2)
+			})
+
+			Convey("ignores changes to services we're not subscribed to", func() {
+				rcvr.Subscribe("dante")
+				state.AddServiceEntry(service.Service{
+					ID: "deadbeef456", Name: "petrarch", Hostname: "chaucer", Updated: baseTime,
+					Status: service.ALIVE,
+				})
+
+				So(rcvr.FetchInitialState(stateUrl), ShouldBeNil)
+				So(updates, ShouldEqual, 1)
+			})
+		})
+
+		Convey("LastUpdatedHandler() reports how fresh the state is", func() {
+			rcvr := newReceiver()
+			serveState(state)
+			So(rcvr.FetchInitialState(stateUrl), ShouldBeNil)
+
+			recorder := httptest.NewRecorder()
+			LastUpdatedHandler(recorder, httptest.NewRequest("GET", "/last_updated", nil), rcvr)
+
+			var status LastUpdatedResponse
+			So(recorder.Code, ShouldEqual, 200)
+			So(json.Unmarshal(recorder.Body.Bytes(), &status), ShouldBeNil)
+			So(status.LastUpdated, ShouldEqual, rcvr.LastUpdated())
+			So(status.LastUpdated.IsZero(), ShouldBeFalse)
+			So(status.LastChanged.Equal(state.LastChanged), ShouldBeTrue)
+		})
+	})
+}
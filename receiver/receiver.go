@@ -16,6 +16,7 @@ import (
 
 const (
 	RELOAD_HOLD_DOWN = 5 * time.Second // Reload at worst every 5 seconds
+	MAX_UPDATE_BYTES = 64 << 20        // The most a gzipped update may unzip to
 )
 
 type Receiver struct {
@@ -26,6 +27,21 @@ type Receiver struct {
 	OnUpdate       func(state *catalog.ServicesState)
 	Looper         director.Looper
 	Subscriptions  []string
+	Payloads       []string // Payload shapes we take from UrlListeners, by preference. Delta, then full, if empty.
+
+	// Checks the signature on each update, nil to take unsigned updates
+	Verifier *catalog.ListenerSigner
+
+	// The client certificate names, common or DNS, that may post updates.
+	// Empty to take them from anyone, or anyone the TLS config lets in.
+	// See LoadServerTLS().
+	AllowedPeers []string
+
+	// Where to keep the last state we handed to OnUpdate across restarts,
+	// empty to keep it only in memory. See LoadState().
+	StateFile string
+
+	lastUpdated time.Time // When we last took an update from Sidecar
 }
 
 func NewReceiver(capacity int, onUpdate func(state *catalog.ServicesState)) *Receiver {
@@ -151,6 +167,7 @@ func (rcvr *Receiver) ProcessUpdates() {
 			rcvr.StateLock.Unlock()
 
 			rcvr.OnUpdate(tmpState)
+			rcvr.saveState(tmpState)
 		}
 
 		// We just flushed the most recent state, dump all the
@@ -180,6 +197,8 @@ func (rcvr *Receiver) EnqueueUpdate() {
 }
 
 // FetchInitialState is used at startup to bootstrap initial state from Sidecar.
+// When LoadState() restored a state, we only call OnUpdate if any of the
+// services we're subscribed to changed while we were down.
 func (rcvr *Receiver) FetchInitialState(stateUrl string) error {
 	rcvr.StateLock.Lock()
 	defer rcvr.StateLock.Unlock()
@@ -190,13 +209,28 @@ func (rcvr *Receiver) FetchInitialState(stateUrl string) error {
 		return err
 	} else {
 		log.Info("Successfully retrieved state")
+		previous := rcvr.CurrentState
 		rcvr.CurrentState = state
-		if rcvr.OnUpdate == nil {
+		rcvr.lastUpdated = time.Now().UTC()
+
+		if previous != nil && !rcvr.subscriptionsChanged(previous, state) {
+			log.Info("Nothing changed since the saved state, skipping the update")
+		} else if rcvr.OnUpdate == nil {
 			log.Error("OnUpdate() callback not defined!")
 		} else {
 			rcvr.OnUpdate(state)
 		}
+		rcvr.saveState(state)
 	}
 
 	return nil
 }
+
+// LastUpdated returns when we last took an update from Sidecar, zero if we
+// haven't since we started
+func (rcvr *Receiver) LastUpdated() time.Time {
+	rcvr.StateLock.Lock()
+	defer rcvr.StateLock.Unlock()
+
+	return rcvr.lastUpdated
+}
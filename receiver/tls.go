@@ -0,0 +1,109 @@
+package receiver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// LoadServerTLS returns the TLS config for serving the UpdateHandler with the
+// certificate and key. When clientCAFile is set, Sidecars have to present a
+// client certificate signed by it, so nothing else can post updates. Use
+// AllowedPeers to narrow it down further.
+func LoadServerTLS(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("serving updates over TLS needs a certificate and key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the receiver certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the receiver client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the receiver client CA %s", clientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// peerNames returns the common name and DNS names of the verified client
+// certificate, none when the client didn't present one
+func peerNames(req *http.Request) []string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return append(names, cert.DNSNames...)
+}
+
+// checkPeer makes sure the client is one of the AllowedPeers, when there
+// are any
+func (rcvr *Receiver) checkPeer(req *http.Request) error {
+	if len(rcvr.AllowedPeers) == 0 {
+		return nil
+	}
+
+	names := peerNames(req)
+	if len(names) == 0 {
+		return errors.New("no verified client certificate")
+	}
+
+	for _, name := range names {
+		for _, allowed := range rcvr.AllowedPeers {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("client certificate for %s is not allowed", strings.Join(names, ", "))
+}
+
+// identity describes who sent the request, for the logs: the client
+// certificate's name and the signing key, when there are any, and where it
+// came from
+func (rcvr *Receiver) identity(req *http.Request) string {
+	var parts []string
+
+	if names := peerNames(req); len(names) > 0 {
+		parts = append(parts, "cert "+names[0])
+	}
+
+	if rcvr.Verifier != nil {
+		if keyID := rcvr.Verifier.KeyID(req); keyID != "" {
+			parts = append(parts, "key "+keyID)
+		}
+	}
+
+	if len(parts) == 0 {
+		return req.RemoteAddr
+	}
+
+	return fmt.Sprintf("%s (%s)", req.RemoteAddr, strings.Join(parts, ", "))
+}
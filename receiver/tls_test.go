@@ -0,0 +1,150 @@
+package receiver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCert writes a certificate and key for the name, signed by the
+// parent, or self-signed as a CA when it's nil
+func writeTestCert(dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (
+	*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name + ".example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), ShouldBeNil)
+
+	return cert, key, certFile, keyFile
+}
+
+func Test_ServerTLS(t *testing.T) {
+	Convey("Serving updates over TLS", t, func() {
+		dir, err := ioutil.TempDir("", "receiver-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca, caKey, caFile, _ := writeTestCert(dir, "ca", nil, nil)
+		_, _, serverCert, serverKey := writeTestCert(dir, "receiver", ca, caKey)
+		client, _, clientCert, clientKey := writeTestCert(dir, "sidecar", ca, caKey)
+		writeTestCert(dir, "rogue", nil, nil) // Not signed by the CA
+
+		rcvr := NewReceiver(10, func(state *catalog.ServicesState) {})
+
+		Convey("rejects bad config", func() {
+			_, err := LoadServerTLS(serverCert, "", caFile)
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadServerTLS(serverCert, serverKey, filepath.Join(dir, "missing"))
+			So(err, ShouldNotBeNil)
+
+			_, err = LoadServerTLS(serverCert, serverKey, serverKey)
+			So(err.Error(), ShouldContainSubstring, "no certificates found")
+		})
+
+		Convey("only takes updates from clients with a certificate from the CA", func() {
+			tlsConfig, err := LoadServerTLS(serverCert, serverKey, caFile)
+			So(err, ShouldBeNil)
+
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				UpdateHandler(w, r, rcvr)
+			}))
+			server.TLS = tlsConfig
+			server.StartTLS()
+			defer server.Close()
+
+			post := func(certFile string, keyFile string) (*http.Response, error) {
+				listenerTLS, err := catalog.LoadListenerTLS(certFile, keyFile, caFile)
+				So(err, ShouldBeNil)
+				httpClient := &http.Client{Transport: catalog.NewListenerTransport(listenerTLS)}
+				return httpClient.Post(server.URL, "application/json", nil)
+			}
+
+			resp, err := post(clientCert, clientKey)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			// Let in, but the body is empty
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+			_, err = post(filepath.Join(dir, "rogue.crt"), filepath.Join(dir, "rogue.key"))
+			So(err, ShouldNotBeNil)
+
+			_, err = post("", "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("checks the AllowedPeers", func() {
+			newRequest := func(cert *x509.Certificate) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/update", nil)
+				if cert != nil {
+					req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca}}}
+				}
+				return req
+			}
+
+			update := func(req *http.Request) int {
+				recorder := httptest.NewRecorder()
+				UpdateHandler(recorder, req, rcvr)
+				return recorder.Result().StatusCode
+			}
+
+			rcvr.AllowedPeers = []string{"sidecar"}
+			So(update(newRequest(client)), ShouldEqual, http.StatusInternalServerError)
+			So(update(newRequest(ca)), ShouldEqual, http.StatusForbidden)
+			So(update(newRequest(nil)), ShouldEqual, http.StatusForbidden)
+
+			rcvr.AllowedPeers = []string{"sidecar.example.com"}
+			So(update(newRequest(client)), ShouldEqual, http.StatusInternalServerError)
+
+			Convey("and names the client in the logs", func() {
+				So(rcvr.identity(newRequest(client)), ShouldEqual, "192.0.2.1:1234 (cert sidecar)")
+				So(rcvr.identity(newRequest(nil)), ShouldEqual, "192.0.2.1:1234")
+			})
+		})
+	})
+}
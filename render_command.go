@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/envoy/adapter"
+	"github.com/NinesStack/sidecar/haproxy"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/golang/protobuf/jsonpb"
+	log "github.com/sirupsen/logrus"
+)
+
+// renderedEnvoy is what the render command prints for Envoy: every
+// resource we would send over the gRPC API, by type
+type renderedEnvoy struct {
+	Listeners []json.RawMessage
+	Clusters  []json.RawMessage
+	Endpoints []json.RawMessage
+	Routes    []json.RawMessage
+	Secrets   []json.RawMessage
+}
+
+// loadStateFile reads a snapshot saved by exportState, or the output of
+// /state.json, into a ServicesState that the proxies can be rendered from
+func loadStateFile(filename string) (*catalog.ServicesState, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	input, err := maybeGunzip(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both formats have the same Servers, which is all we need
+	var snapshot catalog.Snapshot
+	err = json.NewDecoder(input).Decode(&snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state in %s: %w", filename, err)
+	}
+
+	state := catalog.NewServicesState()
+	state.Hostname = snapshot.Hostname
+	state.ClusterName = snapshot.ClusterName
+	for hostname, server := range snapshot.Servers {
+		if server != nil {
+			state.Servers[hostname] = server
+		}
+	}
+
+	if len(state.Servers) == 0 {
+		log.Warnf("No servers found in %s", filename)
+	}
+
+	return state, nil
+}
+
+// renderProxyConfig renders the HAproxy config, or the Envoy resources, for
+// the state saved in a file. Nothing is written unless it all renders. The
+// proxies are configured from the environment, as for a running Sidecar,
+// except that template overrides the HAproxy template when it's set.
+func renderProxyConfig(config *config.Config, stateFile string, template string,
+	envoy bool, output io.Writer) error {
+
+	if envoy && template != "" {
+		return fmt.Errorf("a template can only be given for HAproxy")
+	}
+
+	state, err := loadStateFile(stateFile)
+	if err != nil {
+		return err
+	}
+
+	if envoy {
+		return renderEnvoy(config, state, output)
+	}
+
+	return renderHAproxy(config, state, template, output)
+}
+
+func renderHAproxy(config *config.Config, state *catalog.ServicesState,
+	template string, output io.Writer) error {
+
+	proxy := haproxy.New(config.HAproxy.ConfigFile, config.HAproxy.PidFile)
+	proxy.BindIP = config.HAproxy.BindIP
+	proxy.Template = config.HAproxy.TemplateFile
+	proxy.TemplateDir = config.HAproxy.TemplateDir
+	proxy.User = config.HAproxy.User
+	proxy.Group = config.HAproxy.Group
+	proxy.UseHostnames = config.HAproxy.UseHostnames
+
+	if template != "" {
+		proxy.Template = template
+	}
+
+	err := proxy.ValidateTemplate()
+	if err != nil {
+		return err
+	}
+
+	return proxy.WriteConfig(state, output)
+}
+
+func renderEnvoy(config *config.Config, state *catalog.ServicesState, output io.Writer) error {
+	bindAddrs, err := adapter.ParseBindAddrs(config.Envoy.BindAddrs)
+	if err != nil {
+		return fmt.Errorf("invalid Envoy bind addresses: %w", err)
+	}
+
+	resources := adapter.EnvoyResourcesFromState(
+		state, config.Envoy.BindIP, config.Envoy.UseHostnames, nil,
+		adapter.NewCertSource(config.Envoy.TLSCertDir, config.Envoy.TLSSdsCluster),
+		catalog.NewInstanceGuard(), bindAddrs,
+		adapter.RouteDiscoveryFor(config.Envoy.UseRDS),
+		adapter.ConnectionTimeouts{
+			Idle:        config.Envoy.IdleTimeout,
+			MaxDuration: config.Envoy.MaxConnectionDuration,
+		},
+	)
+
+	var rendered renderedEnvoy
+	for _, kind := range []struct {
+		resources []cache_types.Resource
+		into      *[]json.RawMessage
+	}{
+		{resources.Listeners, &rendered.Listeners},
+		{resources.Clusters, &rendered.Clusters},
+		{resources.Endpoints, &rendered.Endpoints},
+		{resources.Routes, &rendered.Routes},
+		{resources.Secrets, &rendered.Secrets},
+	} {
+		*kind.into, err = marshalEnvoyResources(kind.resources)
+		if err != nil {
+			return err
+		}
+	}
+
+	jsonBytes, err := json.MarshalIndent(&rendered, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(append(jsonBytes, '\n'))
+	return err
+}
+
+// marshalEnvoyResources validates each resource the way Envoy would, then
+// encodes it as JSON
+func marshalEnvoyResources(resources []cache_types.Resource) ([]json.RawMessage, error) {
+	marshaler := jsonpb.Marshaler{}
+	encoded := make([]json.RawMessage, 0, len(resources))
+
+	for _, resource := range resources {
+		if validator, ok := resource.(interface{ Validate() error }); ok {
+			err := validator.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("invalid Envoy resource: %w", err)
+			}
+		}
+
+		jsonString, err := marshaler.MarshalToString(resource)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode Envoy resource: %w", err)
+		}
+		encoded = append(encoded, json.RawMessage(jsonString))
+	}
+
+	return encoded, nil
+}
@@ -0,0 +1,181 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// Format an APIContainers struct into a more compact struct we
+// can ship over the wire in a broadcast.
+func ToService(container *docker.APIContainers, ip string) Service {
+	return ToServiceOnNetworks(container, ip, nil)
+}
+
+// ToServiceOnNetworks is like ToService, but when the container is attached
+// to one of the named Docker networks, the service is advertised on its
+// address in the first of them. Its ports are then the container ports,
+// published or not, so that other containers on the network can reach it
+// directly rather than through the host.
+func ToServiceOnNetworks(container *docker.APIContainers, ip string, networks []string) Service {
+	var svc Service
+	hostname, _ := os.Hostname()
+
+	svc.ID = container.ID[0:12]   // Use short IDs
+	svc.Name = container.Names[0] // Use the first name
+	svc.Image = container.Image
+	svc.Created = time.Unix(container.Created, 0).UTC()
+	svc.Updated = time.Now().UTC()
+	svc.Hostname = hostname
+	svc.Status = ALIVE
+
+	if _, ok := container.Labels["ProxyMode"]; ok {
+		svc.ProxyMode = container.Labels["ProxyMode"]
+	} else {
+		svc.ProxyMode = "http"
+	}
+
+	svc.TLSCert = container.Labels[TLS_CERT_LABEL]
+
+	if minStr, ok := container.Labels[MIN_INSTANCES_LABEL]; ok {
+		min, err := strconv.Atoi(minStr)
+		if err != nil || min < 0 {
+			log.Warnf("Ignoring %s label on %s, %q is not a count", MIN_INSTANCES_LABEL, svc.ID, minStr)
+		} else {
+			svc.MinInstances = min
+		}
+	}
+
+	svc.Aliases = parseAliases(container.Labels[ALIASES_LABEL], svc.Name)
+	svc.ListenOn = splitList(container.Labels[LISTEN_ON_LABEL])
+
+	// We look up tags by convention in the format "SidecarTag_env=staging"
+	for label, value := range container.Labels {
+		if !strings.HasPrefix(label, TAG_LABEL_PREFIX) || len(label) == len(TAG_LABEL_PREFIX) {
+			continue
+		}
+
+		if svc.Tags == nil {
+			svc.Tags = make(map[string]string)
+		}
+		svc.Tags[strings.TrimPrefix(label, TAG_LABEL_PREFIX)] = value
+	}
+
+	svc.Ports = make([]Port, 0)
+
+	if networkIP := NetworkIP(container, networks); networkIP != "" {
+		svc.Ports = buildNetworkPorts(container, networkIP)
+		return svc
+	}
+
+	for _, port := range container.Ports {
+		if port.PublicPort != 0 {
+			svc.Ports = append(svc.Ports, buildPortsFor(&port, container, ip)...)
+		}
+	}
+
+	return svc
+}
+
+// NetworkIP returns the container's address on the first of the networks
+// that it's attached to, or "" when it's on none of them
+func NetworkIP(container *docker.APIContainers, networks []string) string {
+	for _, name := range networks {
+		network, ok := container.Networks.Networks[name]
+		if ok && network.IPAddress != "" {
+			return network.IPAddress
+		}
+	}
+
+	return ""
+}
+
+// buildNetworkPorts returns a port for each port the container exposes, on
+// its network address. Docker lists a port once for each address it's
+// published on, so they are only added once.
+func buildNetworkPorts(container *docker.APIContainers, networkIP string) []Port {
+	ports := make([]Port, 0, len(container.Ports))
+	seen := make(map[string]bool, len(container.Ports))
+
+	for _, port := range container.Ports {
+		key := fmt.Sprintf("%d/%s", port.PrivatePort, port.Type)
+		if port.PrivatePort == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		internal := docker.APIPort{PrivatePort: port.PrivatePort, PublicPort: port.PrivatePort, Type: port.Type}
+		ports = append(ports, buildPortsFor(&internal, container, networkIP)...)
+	}
+
+	return ports
+}
+
+// Figure out the correct port configuration for a service. Only the first
+// of its ServicePorts, see buildPortsFor().
+func buildPortFor(port *docker.APIPort, container *docker.APIContainers, ip string) Port {
+	return buildPortsFor(port, container, ip)[0]
+}
+
+// buildPortsFor returns a Port for each ServicePort the container port is
+// announced on. The ServicePort label can list more than one, e.g.
+// "ServicePort_80=8080,9090", so that one container can be reached on both
+// while clients move from one protocol to another. Each ServicePort can have
+// its own proxy mode, set with e.g. "ProxyMode_9090=tcp".
+func buildPortsFor(port *docker.APIPort, container *docker.APIContainers, ip string) []Port {
+	// We look up service port labels by convention in the format "ServicePort_80=8080"
+	svcPortLabel := fmt.Sprintf("ServicePort_%d", port.PrivatePort)
+
+	// You can override the default IP by binding your container on a specific IP
+	if port.IP != "0.0.0.0" && port.IP != "" {
+		ip = port.IP
+	}
+
+	basePort := Port{Port: port.PublicPort, Type: port.Type, IP: ip}
+
+	svcPorts, ok := container.Labels[svcPortLabel]
+	if !ok {
+		return []Port{basePort}
+	}
+
+	var ports []Port
+	for _, svcPort := range strings.Split(svcPorts, ",") {
+		svcPort = strings.TrimSpace(svcPort)
+		returnPort := basePort
+
+		if svcPort == "auto" {
+			returnPort.ServicePort = AutoServicePort(port.PrivatePort)
+			ports = append(ports, returnPort)
+			continue
+		}
+
+		svcPortInt, err := strconv.Atoi(svcPort)
+		if err != nil {
+			log.Errorf("Error converting label value for %s to integer: %s",
+				svcPortLabel,
+				err,
+			)
+			continue
+		}
+
+		// Everything was good, set the service port
+		returnPort.ServicePort = int64(svcPortInt)
+		returnPort.ProxyMode = container.Labels[fmt.Sprintf("%s%d", PORT_PROXY_MODE_LABEL_PREFIX, svcPortInt)]
+		ports = append(ports, returnPort)
+	}
+
+	// Without a good ServicePort, the port is still announced
+	if len(ports) == 0 {
+		return []Port{basePort}
+	}
+
+	return ports
+}
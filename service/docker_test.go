@@ -0,0 +1,234 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"os"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_buildPortFor(t *testing.T) {
+	Convey("buildPortFor()", t, func() {
+		dPort := docker.APIPort{
+			PrivatePort: 80,
+			PublicPort:  8723,
+			Type:        "tcp",
+		}
+
+		ip := "127.0.0.1"
+
+		container := &docker.APIContainers{
+			Ports: []docker.APIPort{dPort},
+			Labels: map[string]string{
+				"ServicePort_80": "8080",
+			},
+		}
+
+		Convey("Maps service ports to internal ports", func() {
+			port := buildPortFor(&dPort, container, ip)
+
+			So(port.ServicePort, ShouldEqual, 8080)
+			So(port.Port, ShouldEqual, 8723)
+			So(port.Type, ShouldEqual, "tcp")
+		})
+
+		Convey("Adds the default IP address", func() {
+			port := buildPortFor(&dPort, container, ip)
+
+			So(port.IP, ShouldEqual, ip)
+		})
+
+		Convey("Skips the service port when there is none", func() {
+			delete(container.Labels, "ServicePort_80")
+			port := buildPortFor(&dPort, container, ip)
+
+			So(port.ServicePort, ShouldEqual, 0)
+			So(port.Port, ShouldEqual, 8723)
+			So(port.Type, ShouldEqual, "tcp")
+		})
+
+		Convey("Asks for a ServicePort from the pool when it's auto", func() {
+			container.Labels["ServicePort_80"] = "auto"
+			port := buildPortFor(&dPort, container, ip)
+
+			So(port.ServicePort, ShouldEqual, AutoServicePort(80))
+			So(port.ServicePort, ShouldBeLessThan, 0)
+			So(port.Port, ShouldEqual, 8723)
+		})
+
+		Convey("Announces the port on each ServicePort, with its own mode", func() {
+			container.Labels["ServicePort_80"] = "8080, 9090"
+			container.Labels["ProxyMode_9090"] = "tcp"
+			ports := buildPortsFor(&dPort, container, ip)
+
+			So(ports, ShouldResemble, []Port{
+				{Type: "tcp", Port: 8723, ServicePort: 8080, IP: ip},
+				{Type: "tcp", Port: 8723, ServicePort: 9090, IP: ip, ProxyMode: "tcp"},
+			})
+		})
+
+		Convey("Skips the service port when there is a conversion error", func() {
+			container.Labels["ServicePort_80"] = "not a number"
+			port := buildPortFor(&dPort, container, ip)
+
+			So(port.ServicePort, ShouldEqual, 0)
+			So(port.Port, ShouldEqual, 8723)
+			So(port.Type, ShouldEqual, "tcp")
+		})
+	})
+}
+
+func Test_ToService(t *testing.T) {
+	sampleAPIContainer := &docker.APIContainers{
+		ID:      "88862023487fa0ae043c47d7b441f684fc39145d1d9fa398450e4da2e53af5e8",
+		Image:   "example.com/docker/fabulous-container:latest",
+		Command: "/fabulous_app",
+		Created: 1457144774,
+		Status:  "Up 34 seconds",
+		Ports: []docker.APIPort{
+			{
+				PrivatePort: 9990,
+				PublicPort:  0,
+				Type:        "tcp",
+				IP:          "",
+			},
+			{
+				PrivatePort: 8080,
+				PublicPort:  31355,
+				Type:        "tcp",
+				IP:          "192.168.77.13",
+			},
+		},
+		SizeRw:     0,
+		SizeRootFs: 0,
+		Names:      []string{"/sample-app-go-worker-eebb5aad1a17ee"},
+		Labels: map[string]string{
+			"ServicePort_8080":    "17010",
+			"ProxyMode":           "tcp",
+			"HealthCheck":         "HttpGet",
+			"HealthCheckArgs":     "http://127.0.0.1:39519/status/check",
+			"SidecarTag_env":      "staging",
+			"SidecarTLSCert":      "worker",
+			"SidecarMinInstances": "2",
+			"SidecarAliases":      "old-worker, api-v1,,old-worker",
+			"SidecarListenOn":     "local, public",
+		},
+	}
+
+	samplePorts := []Port{
+		{
+			Type:        "tcp",
+			Port:        31355,
+			ServicePort: 17010,
+			IP:          "192.168.77.13",
+		},
+	}
+
+	sampleHostname, _ := os.Hostname()
+
+	Convey("ToService()", t, func() {
+		Convey("Decodes service correctly", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.ID, ShouldEqual, sampleAPIContainer.ID[:12])
+			So(service.Image, ShouldEqual, sampleAPIContainer.Image)
+			So(service.Name, ShouldEqual, sampleAPIContainer.Names[0])
+			So(service.Created.String(), ShouldEqual, "2016-03-05 02:26:14 +0000 UTC")
+			So(service.Hostname, ShouldEqual, sampleHostname)
+			So(samplePorts, ShouldResemble, service.Ports)
+			So(service.Updated, ShouldNotBeNil)
+			So(service.ProxyMode, ShouldEqual, "tcp")
+			So(service.Status, ShouldEqual, 0)
+		})
+
+		Convey("Advertises the address on the first configured network it's on", func() {
+			onNetwork := *sampleAPIContainer
+			onNetwork.Ports = append(onNetwork.Ports, docker.APIPort{
+				PrivatePort: 8080, PublicPort: 31355, Type: "tcp", IP: "::",
+			})
+			onNetwork.Networks = docker.NetworkList{Networks: map[string]docker.ContainerNetwork{
+				"bridge":  {IPAddress: "172.17.0.4"},
+				"backend": {IPAddress: "10.11.0.7"},
+			}}
+
+			service := ToServiceOnNetworks(&onNetwork, "127.0.0.1", []string{"frontend", "backend", "bridge"})
+			So(service.Ports, ShouldResemble, []Port{
+				{Type: "tcp", Port: 9990, IP: "10.11.0.7"},
+				{Type: "tcp", Port: 8080, ServicePort: 17010, IP: "10.11.0.7"},
+			})
+
+			Convey("and the host address when it isn't on any of them", func() {
+				service := ToServiceOnNetworks(&onNetwork, "127.0.0.1", []string{"frontend"})
+				So(service.Ports[0].IP, ShouldEqual, "192.168.77.13")
+				So(service.Ports[0].Port, ShouldEqual, 31355)
+			})
+		})
+
+		Convey("Picks up tags from the labels", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.Tags, ShouldResemble, map[string]string{"env": "staging"})
+		})
+
+		Convey("Picks up the TLS certificate from the labels", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.TLSCert, ShouldEqual, "worker")
+		})
+
+		Convey("Picks up the minimum instances from the labels", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.MinInstances, ShouldEqual, 2)
+
+			encoded, err := service.Encode()
+			So(err, ShouldBeNil)
+
+			var decoded Service
+			So(decoded.UnmarshalJSON(encoded), ShouldBeNil)
+			So(decoded.MinInstances, ShouldEqual, 2)
+		})
+
+		Convey("Picks up the aliases from the labels", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.Aliases, ShouldResemble, []string{"old-worker", "api-v1"})
+
+			encoded, err := service.Encode()
+			So(err, ShouldBeNil)
+
+			var decoded Service
+			So(decoded.UnmarshalJSON(encoded), ShouldBeNil)
+			So(decoded.Aliases, ShouldResemble, []string{"old-worker", "api-v1"})
+		})
+
+		Convey("Picks up the interfaces to listen on from the labels", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			So(service.ListenOn, ShouldResemble, []string{"local", "public"})
+
+			encoded, err := service.Encode()
+			So(err, ShouldBeNil)
+
+			var decoded Service
+			So(decoded.UnmarshalJSON(encoded), ShouldBeNil)
+			So(decoded.ListenOn, ShouldResemble, []string{"local", "public"})
+		})
+
+		Convey("Encodes and decodes the source", func() {
+			service := ToService(sampleAPIContainer, "127.0.0.1")
+			service.Source = "docker"
+
+			encoded, err := service.Encode()
+			So(err, ShouldBeNil)
+			So(string(encoded), ShouldContainSubstring, `"Source":"docker"`)
+
+			var decoded Service
+			So(decoded.UnmarshalJSON(encoded), ShouldBeNil)
+			So(decoded.Source, ShouldEqual, "docker")
+		})
+
+		Convey("Doesn't alias a service to its own name", func() {
+			So(parseAliases("beowulf,grendel", "beowulf"), ShouldResemble, []string{"grendel"})
+			So(parseAliases("", "beowulf"), ShouldBeEmpty)
+		})
+	})
+}
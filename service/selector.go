@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Selector picks out services by their tags. Every requirement must match.
+type Selector []SelectorRequirement
+
+// A SelectorRequirement is a single "key=value" or "key!=value" term. A bare
+// "key" requires the tag to be present with any value.
+type SelectorRequirement struct {
+	Key     string
+	Value   string
+	Exists  bool // Only require the key to be present
+	Negated bool // The tag must not have this value
+}
+
+// ParseSelector parses a comma separated list of requirements, e.g.
+// "env=staging,team=search". Empty selectors are rejected, because they
+// would match everything.
+func ParseSelector(spec string) (Selector, error) {
+	var selector Selector
+
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req SelectorRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			req = SelectorRequirement{Key: parts[0], Value: parts[1], Negated: true}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			req = SelectorRequirement{Key: parts[0], Value: parts[1]}
+		default:
+			req = SelectorRequirement{Key: term, Exists: true}
+		}
+
+		req.Key = strings.TrimSpace(req.Key)
+		req.Value = strings.TrimSpace(req.Value)
+		if req.Key == "" {
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+
+		selector = append(selector, req)
+	}
+
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("empty selector %q", spec)
+	}
+
+	return selector, nil
+}
+
+// Matches tells us whether the service's tags satisfy the selector
+func (s Selector) Matches(svc *Service) bool {
+	for _, req := range s {
+		value, ok := svc.Tags[req.Key]
+
+		switch {
+		case req.Exists:
+			if !ok {
+				return false
+			}
+		case req.Negated:
+			if ok && value == req.Value {
+				return false
+			}
+		default:
+			if !ok || value != req.Value {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// String returns the selector in a canonical form
+func (s Selector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, req := range s {
+		switch {
+		case req.Exists:
+			terms = append(terms, req.Key)
+		case req.Negated:
+			terms = append(terms, req.Key+"!="+req.Value)
+		default:
+			terms = append(terms, req.Key+"="+req.Value)
+		}
+	}
+	sort.Strings(terms)
+
+	return strings.Join(terms, ",")
+}
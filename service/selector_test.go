@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Selector(t *testing.T) {
+	Convey("Selectors", t, func() {
+		svc := &Service{
+			ID:   "deadbeef123",
+			Tags: map[string]string{"env": "staging", "team": "search"},
+		}
+
+		Convey("parse all the kinds of term", func() {
+			selector, err := ParseSelector("env=staging, team!=ads,canary")
+			So(err, ShouldBeNil)
+			So(selector, ShouldResemble, Selector{
+				{Key: "env", Value: "staging"},
+				{Key: "team", Value: "ads", Negated: true},
+				{Key: "canary", Exists: true},
+			})
+			So(selector.String(), ShouldEqual, "canary,env=staging,team!=ads")
+		})
+
+		Convey("reject empty selectors and keys", func() {
+			_, err := ParseSelector("")
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseSelector(" , ")
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseSelector("=staging")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("match when every term matches", func() {
+			selector, _ := ParseSelector("env=staging,team=search")
+			So(selector.Matches(svc), ShouldBeTrue)
+
+			selector, _ = ParseSelector("env=staging,team=ads")
+			So(selector.Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("handle existence and negation", func() {
+			selector, _ := ParseSelector("team")
+			So(selector.Matches(svc), ShouldBeTrue)
+
+			selector, _ = ParseSelector("canary")
+			So(selector.Matches(svc), ShouldBeFalse)
+
+			selector, _ = ParseSelector("env!=production")
+			So(selector.Matches(svc), ShouldBeTrue)
+
+			selector, _ = ParseSelector("env!=staging")
+			So(selector.Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("don't match services without tags", func() {
+			selector, _ := ParseSelector("env=staging")
+			So(selector.Matches(&Service{}), ShouldBeFalse)
+		})
+	})
+}
@@ -4,13 +4,10 @@ package service
 
 import (
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NinesStack/sidecar/output"
-	docker "github.com/fsouza/go-dockerclient"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,11 +19,67 @@ const (
 	DRAINING  = iota
 )
 
+const (
+	TAG_LABEL_PREFIX    = "SidecarTag_"         // Docker labels that become service tags
+	TLS_CERT_LABEL      = "SidecarTLSCert"      // Docker label naming the certificate for TLS termination
+	MIN_INSTANCES_LABEL = "SidecarMinInstances" // Docker label with the minimum healthy instances
+	ALIASES_LABEL       = "SidecarAliases"      // Docker label with other names for the service
+	LISTEN_ON_LABEL     = "SidecarListenOn"     // Docker label naming the interfaces the proxy listens on
+
+	PORT_PROXY_MODE_LABEL_PREFIX = "ProxyMode_" // Docker labels setting the proxy mode of one ServicePort
+)
+
+const (
+	MAX_ANNOTATIONS       = 5   // The most annotations a service keeps, newest first
+	MAX_ANNOTATION_LENGTH = 280 // The longest note an annotation may have
+)
+
+// AutoServicePort is the ServicePort that discovery gives a port when
+// Sidecar should allocate one from its pool. It's the container port,
+// negated, so that the allocation can be kept for that port of the service.
+func AutoServicePort(containerPort int64) int64 {
+	return 0 - containerPort
+}
+
 type Port struct {
 	Type        string
 	Port        int64
 	ServicePort int64
 	IP          string
+	ProxyMode   string `json:",omitempty"` // Overrides the service's ProxyMode on this ServicePort
+
+	// The container port a ServicePort from the pool was allocated for, so
+	// other nodes can hand out the same one
+	ContainerPort int64 `json:",omitempty"`
+}
+
+// Resources is a snapshot of the load on a service: the resources it is using,
+// when the discovery mechanism knows about them, and how quickly it answered
+// its last health check.
+type Resources struct {
+	CPUPercent   float64       // Percent of a single CPU, so can exceed 100
+	MemoryUsage  uint64        // Bytes
+	MemoryLimit  uint64        // Bytes, zero when unlimited or unknown
+	Sampled      time.Time     // When the snapshot was taken
+	CheckLatency time.Duration // How long the last health check took, zero if unknown
+}
+
+// CheckInfo describes the last health check run on a service by the Monitor
+// on its host. It travels with the service so that everyone judges how fresh
+// the Status is the same way.
+type CheckInfo struct {
+	Time       time.Time     // When the check finished, on the checking host's clock
+	Latency    time.Duration // How long it took, whatever the result
+	OutputHash string        `json:",omitempty"` // Changes when the output does, empty when there was none
+}
+
+// An Annotation is a short note an operator left on a service, e.g. "under
+// investigation, do not restart". It expires on its own.
+type Annotation struct {
+	Note    string
+	Author  string `json:",omitempty"`
+	Created time.Time
+	Expires time.Time
 }
 
 type Service struct {
@@ -39,6 +92,27 @@ type Service struct {
 	Updated   time.Time
 	ProxyMode string
 	Status    int
+	Resources *Resources        `json:",omitempty"`
+	Tags      map[string]string `json:",omitempty"`
+	TLSCert   string            `json:",omitempty"` // Certificate the proxy should terminate TLS with
+
+	// How many instances must be ALIVE before the proxies drop any backends
+	MinInstances int `json:",omitempty"`
+
+	// Other names the service can be found by, e.g. while it's being renamed
+	Aliases []string `json:",omitempty"`
+
+	// Named interfaces the proxy should listen on, rather than its default
+	ListenOn []string `json:",omitempty"`
+
+	// Which discoverer found the service, e.g. "docker" or "static"
+	Source string `json:",omitempty"`
+
+	// Notes from operators, newest first. Discovery doesn't know about these.
+	Annotations []Annotation `json:",omitempty"`
+
+	// The last health check run on the service, nil when it hasn't had one
+	LastCheck *CheckInfo `json:",omitempty"`
 }
 
 func (svc *Service) Encode() ([]byte, error) {
@@ -65,8 +139,23 @@ func (svc *Service) Invalidates(otherSvc *Service) bool {
 	return otherSvc != nil && svc.Updated.After(otherSvc.Updated)
 }
 
+// CheckIsFresh tells whether the last health check on the service ran within
+// maxAge of now. Services we have no check information for, e.g. from older
+// peers, are always fresh, as is everything when maxAge is zero.
+func (svc *Service) CheckIsFresh(maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 || svc.LastCheck == nil {
+		return true
+	}
+	return now.Sub(svc.LastCheck.Time) <= maxAge
+}
+
 func (svc *Service) IsStale(lifespan time.Duration) bool {
-	oldestAllowed := time.Now().UTC().Add(0 - lifespan)
+	return svc.IsStaleAt(lifespan, time.Now().UTC())
+}
+
+// IsStaleAt is IsStale as of the given time
+func (svc *Service) IsStaleAt(lifespan time.Duration, now time.Time) bool {
+	oldestAllowed := now.Add(0 - lifespan)
 	// We add a fudge factor for clock drift
 	return svc.Updated.Before(oldestAllowed.Add(0 - 1*time.Minute))
 }
@@ -89,8 +178,40 @@ func (svc *Service) Format() string {
 }
 
 func (svc *Service) Tombstone() {
+	svc.TombstoneAt(time.Now().UTC())
+}
+
+// TombstoneAt tombstones the service as of the given time
+func (svc *Service) TombstoneAt(now time.Time) {
 	svc.Status = TOMBSTONE
-	svc.Updated = time.Now().UTC()
+	svc.Updated = now
+}
+
+// Annotate adds an annotation to the front of the list, dropping any that
+// have expired and the oldest beyond MAX_ANNOTATIONS. The list is replaced
+// rather than changed in place, because copies of the service share it.
+func (svc *Service) Annotate(annotation Annotation) {
+	annotations := make([]Annotation, 0, MAX_ANNOTATIONS)
+	annotations = append(annotations, annotation)
+	annotations = append(annotations, ActiveAnnotations(svc.Annotations, annotation.Created)...)
+
+	if len(annotations) > MAX_ANNOTATIONS {
+		annotations = annotations[:MAX_ANNOTATIONS]
+	}
+
+	svc.Annotations = annotations
+}
+
+// ActiveAnnotations returns the annotations that haven't expired by now, or
+// nil when there are none
+func ActiveAnnotations(annotations []Annotation, now time.Time) []Annotation {
+	var active []Annotation
+	for _, annotation := range annotations {
+		if now.Before(annotation.Expires) {
+			active = append(active, annotation)
+		}
+	}
+	return active
 }
 
 // Look up a (usually Docker) mapped Port for a service by ServicePort
@@ -105,6 +226,18 @@ func (svc *Service) PortForServicePort(findPort int64, pType string) int64 {
 	return -1
 }
 
+// ProxyModeFor returns the proxy mode for one of the service's ServicePorts.
+// That's the service's ProxyMode, unless the port has its own.
+func (svc *Service) ProxyModeFor(servicePort int64) string {
+	for _, port := range svc.Ports {
+		if port.ServicePort == servicePort && port.ProxyMode != "" {
+			return port.ProxyMode
+		}
+	}
+
+	return svc.ProxyMode
+}
+
 // ListenerName returns the string name this service should be identified
 // by as a listener to Sidecar state
 func (svc *Service) ListenerName() string {
@@ -134,35 +267,35 @@ func Decode(data []byte) (*Service, error) {
 	return &svc, nil
 }
 
-// Format an APIContainers struct into a more compact struct we
-// can ship over the wire in a broadcast.
-func ToService(container *docker.APIContainers, ip string) Service {
-	var svc Service
-	hostname, _ := os.Hostname()
-
-	svc.ID = container.ID[0:12]   // Use short IDs
-	svc.Name = container.Names[0] // Use the first name
-	svc.Image = container.Image
-	svc.Created = time.Unix(container.Created, 0).UTC()
-	svc.Updated = time.Now().UTC()
-	svc.Hostname = hostname
-	svc.Status = ALIVE
-
-	if _, ok := container.Labels["ProxyMode"]; ok {
-		svc.ProxyMode = container.Labels["ProxyMode"]
-	} else {
-		svc.ProxyMode = "http"
+// parseAliases splits a comma separated list of aliases, dropping the
+// service's own name
+func parseAliases(label string, name string) []string {
+	var aliases []string
+	for _, alias := range splitList(label) {
+		if alias != name {
+			aliases = append(aliases, alias)
+		}
 	}
 
-	svc.Ports = make([]Port, 0)
+	return aliases
+}
+
+// splitList splits a comma separated label value, dropping blanks and
+// duplicates
+func splitList(label string) []string {
+	var items []string
+	seen := make(map[string]bool)
 
-	for _, port := range container.Ports {
-		if port.PublicPort != 0 {
-			svc.Ports = append(svc.Ports, buildPortFor(&port, container, ip))
+	for _, item := range strings.Split(label, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
 		}
+		seen[item] = true
+		items = append(items, item)
 	}
 
-	return svc
+	return items
 }
 
 func StatusString(status int) string {
@@ -179,32 +312,3 @@ func StatusString(status int) string {
 		return "Tombstone"
 	}
 }
-
-// Figure out the correct port configuration for a service
-func buildPortFor(port *docker.APIPort, container *docker.APIContainers, ip string) Port {
-	// We look up service port labels by convention in the format "ServicePort_80=8080"
-	svcPortLabel := fmt.Sprintf("ServicePort_%d", port.PrivatePort)
-
-	// You can override the default IP by binding your container on a specific IP
-	if port.IP != "0.0.0.0" && port.IP != "" {
-		ip = port.IP
-	}
-
-	returnPort := Port{Port: port.PublicPort, Type: port.Type, IP: ip}
-
-	if svcPort, ok := container.Labels[svcPortLabel]; ok {
-		svcPortInt, err := strconv.Atoi(svcPort)
-		if err != nil {
-			log.Errorf("Error converting label value for %s to integer: %s",
-				svcPortLabel,
-				err,
-			)
-			return returnPort
-		}
-
-		// Everything was good, set the service port
-		returnPort.ServicePort = int64(svcPortInt)
-	}
-
-	return returnPort
-}
@@ -1,7 +1,5 @@
-// DO NOT EDIT!
-// Code generated by ffjson <https://github.com/pquerna/ffjson>
+// Code generated by ffjson <https://github.com/pquerna/ffjson>. DO NOT EDIT.
 // source: service.go
-// DO NOT EDIT!
 
 package service
 
@@ -9,22 +7,26 @@ import (
 	"bytes"
 	"fmt"
 	fflib "github.com/pquerna/ffjson/fflib/v1"
+	"time"
 )
 
-func (mj *Port) MarshalJSON() ([]byte, error) {
+// MarshalJSON marshal bytes to json - template
+func (j *Annotation) MarshalJSON() ([]byte, error) {
 	var buf fflib.Buffer
-	if mj == nil {
+	if j == nil {
 		buf.WriteString("null")
 		return buf.Bytes(), nil
 	}
-	err := mj.MarshalJSONBuf(&buf)
+	err := j.MarshalJSONBuf(&buf)
 	if err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
-func (mj *Port) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
-	if mj == nil {
+
+// MarshalJSONBuf marshal buff to json - template
+func (j *Annotation) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if j == nil {
 		buf.WriteString("null")
 		return nil
 	}
@@ -32,47 +34,71 @@ func (mj *Port) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	var obj []byte
 	_ = obj
 	_ = err
-	buf.WriteString(`{"Type":`)
-	fflib.WriteJsonString(buf, string(mj.Type))
-	buf.WriteString(`,"Port":`)
-	fflib.FormatBits2(buf, uint64(mj.Port), 10, mj.Port < 0)
-	buf.WriteString(`,"ServicePort":`)
-	fflib.FormatBits2(buf, uint64(mj.ServicePort), 10, mj.ServicePort < 0)
-	buf.WriteString(`,"IP":`)
-	fflib.WriteJsonString(buf, string(mj.IP))
+	buf.WriteString(`{"Note":`)
+	fflib.WriteJsonString(buf, string(j.Note))
+	buf.WriteByte(',')
+	if len(j.Author) != 0 {
+		buf.WriteString(`"Author":`)
+		fflib.WriteJsonString(buf, string(j.Author))
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"Created":`)
+
+	{
+
+		obj, err = j.Created.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(obj)
+
+	}
+	buf.WriteString(`,"Expires":`)
+
+	{
+
+		obj, err = j.Expires.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(obj)
+
+	}
 	buf.WriteByte('}')
 	return nil
 }
 
 const (
-	ffj_t_Portbase = iota
-	ffj_t_Portno_such_key
+	ffjtAnnotationbase = iota
+	ffjtAnnotationnosuchkey
 
-	ffj_t_Port_Type
+	ffjtAnnotationNote
 
-	ffj_t_Port_Port
+	ffjtAnnotationAuthor
 
-	ffj_t_Port_ServicePort
+	ffjtAnnotationCreated
 
-	ffj_t_Port_IP
+	ffjtAnnotationExpires
 )
 
-var ffj_key_Port_Type = []byte("Type")
+var ffjKeyAnnotationNote = []byte("Note")
 
-var ffj_key_Port_Port = []byte("Port")
+var ffjKeyAnnotationAuthor = []byte("Author")
 
-var ffj_key_Port_ServicePort = []byte("ServicePort")
+var ffjKeyAnnotationCreated = []byte("Created")
 
-var ffj_key_Port_IP = []byte("IP")
+var ffjKeyAnnotationExpires = []byte("Expires")
 
-func (uj *Port) UnmarshalJSON(input []byte) error {
+// UnmarshalJSON umarshall json - template of ffjson
+func (j *Annotation) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
-	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
 }
 
-func (uj *Port) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
-	var err error = nil
-	currentKey := ffj_t_Portbase
+// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
+func (j *Annotation) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error
+	currentKey := ffjtAnnotationbase
 	_ = currentKey
 	tok := fflib.FFTok_init
 	wantedTok := fflib.FFTok_init
@@ -118,71 +144,71 @@ mainparse:
 			kn := fs.Output.Bytes()
 			if len(kn) <= 0 {
 				// "" case. hrm.
-				currentKey = ffj_t_Portno_such_key
+				currentKey = ffjtAnnotationnosuchkey
 				state = fflib.FFParse_want_colon
 				goto mainparse
 			} else {
 				switch kn[0] {
 
-				case 'I':
+				case 'A':
 
-					if bytes.Equal(ffj_key_Port_IP, kn) {
-						currentKey = ffj_t_Port_IP
+					if bytes.Equal(ffjKeyAnnotationAuthor, kn) {
+						currentKey = ffjtAnnotationAuthor
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
-				case 'P':
+				case 'C':
 
-					if bytes.Equal(ffj_key_Port_Port, kn) {
-						currentKey = ffj_t_Port_Port
+					if bytes.Equal(ffjKeyAnnotationCreated, kn) {
+						currentKey = ffjtAnnotationCreated
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
-				case 'S':
+				case 'E':
 
-					if bytes.Equal(ffj_key_Port_ServicePort, kn) {
-						currentKey = ffj_t_Port_ServicePort
+					if bytes.Equal(ffjKeyAnnotationExpires, kn) {
+						currentKey = ffjtAnnotationExpires
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
-				case 'T':
+				case 'N':
 
-					if bytes.Equal(ffj_key_Port_Type, kn) {
-						currentKey = ffj_t_Port_Type
+					if bytes.Equal(ffjKeyAnnotationNote, kn) {
+						currentKey = ffjtAnnotationNote
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
 				}
 
-				if fflib.SimpleLetterEqualFold(ffj_key_Port_IP, kn) {
-					currentKey = ffj_t_Port_IP
+				if fflib.EqualFoldRight(ffjKeyAnnotationExpires, kn) {
+					currentKey = ffjtAnnotationExpires
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				if fflib.EqualFoldRight(ffj_key_Port_ServicePort, kn) {
-					currentKey = ffj_t_Port_ServicePort
+				if fflib.SimpleLetterEqualFold(ffjKeyAnnotationCreated, kn) {
+					currentKey = ffjtAnnotationCreated
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				if fflib.SimpleLetterEqualFold(ffj_key_Port_Port, kn) {
-					currentKey = ffj_t_Port_Port
+				if fflib.SimpleLetterEqualFold(ffjKeyAnnotationAuthor, kn) {
+					currentKey = ffjtAnnotationAuthor
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				if fflib.SimpleLetterEqualFold(ffj_key_Port_Type, kn) {
-					currentKey = ffj_t_Port_Type
+				if fflib.SimpleLetterEqualFold(ffjKeyAnnotationNote, kn) {
+					currentKey = ffjtAnnotationNote
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				currentKey = ffj_t_Portno_such_key
+				currentKey = ffjtAnnotationnosuchkey
 				state = fflib.FFParse_want_colon
 				goto mainparse
 			}
@@ -199,19 +225,19 @@ mainparse:
 			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
 				switch currentKey {
 
-				case ffj_t_Port_Type:
-					goto handle_Type
+				case ffjtAnnotationNote:
+					goto handle_Note
 
-				case ffj_t_Port_Port:
-					goto handle_Port
+				case ffjtAnnotationAuthor:
+					goto handle_Author
 
-				case ffj_t_Port_ServicePort:
-					goto handle_ServicePort
+				case ffjtAnnotationCreated:
+					goto handle_Created
 
-				case ffj_t_Port_IP:
-					goto handle_IP
+				case ffjtAnnotationExpires:
+					goto handle_Expires
 
-				case ffj_t_Portno_such_key:
+				case ffjtAnnotationnosuchkey:
 					err = fs.SkipField(tok)
 					if err != nil {
 						return fs.WrapErr(err)
@@ -225,9 +251,9 @@ mainparse:
 		}
 	}
 
-handle_Type:
+handle_Note:
 
-	/* handler: uj.Type type=string kind=string quoted=false*/
+	/* handler: j.Note type=string kind=string quoted=false*/
 
 	{
 
@@ -243,7 +269,7 @@ handle_Type:
 
 			outBuf := fs.Output.Bytes()
 
-			uj.Type = string(string(outBuf))
+			j.Note = string(string(outBuf))
 
 		}
 	}
@@ -251,29 +277,25 @@ handle_Type:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Port:
+handle_Author:
 
-	/* handler: uj.Port type=int64 kind=int64 quoted=false*/
+	/* handler: j.Author type=string kind=string quoted=false*/
 
 	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
 
-	{
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
 
 		if tok == fflib.FFTok_null {
 
 		} else {
 
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
-			if err != nil {
-				return fs.WrapErr(err)
-			}
+			outBuf := fs.Output.Bytes()
 
-			uj.Port = int64(tval)
+			j.Author = string(string(outBuf))
 
 		}
 	}
@@ -281,57 +303,51 @@ handle_Port:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_ServicePort:
-
-	/* handler: uj.ServicePort type=int64 kind=int64 quoted=false*/
+handle_Created:
 
-	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
-		}
-	}
+	/* handler: j.Created type=time.Time kind=struct quoted=false*/
 
 	{
-
 		if tok == fflib.FFTok_null {
 
 		} else {
 
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
-
+			tbuf, err := fs.CaptureField(tok)
 			if err != nil {
 				return fs.WrapErr(err)
 			}
 
-			uj.ServicePort = int64(tval)
-
+			err = j.Created.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 		}
+		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_IP:
+handle_Expires:
 
-	/* handler: uj.IP type=string kind=string quoted=false*/
+	/* handler: j.Expires type=time.Time kind=struct quoted=false*/
 
 	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
 		if tok == fflib.FFTok_null {
 
 		} else {
 
-			outBuf := fs.Output.Bytes()
-
-			uj.IP = string(string(outBuf))
+			tbuf, err := fs.CaptureField(tok)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 
+			err = j.Expires.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 		}
+		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
@@ -355,20 +371,23 @@ done:
 	return nil
 }
 
-func (mj *Service) MarshalJSON() ([]byte, error) {
+// MarshalJSON marshal bytes to json - template
+func (j *CheckInfo) MarshalJSON() ([]byte, error) {
 	var buf fflib.Buffer
-	if mj == nil {
+	if j == nil {
 		buf.WriteString("null")
 		return buf.Bytes(), nil
 	}
-	err := mj.MarshalJSONBuf(&buf)
+	err := j.MarshalJSONBuf(&buf)
 	if err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
-func (mj *Service) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
-	if mj == nil {
+
+// MarshalJSONBuf marshal buff to json - template
+func (j *CheckInfo) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if j == nil {
 		buf.WriteString("null")
 		return nil
 	}
@@ -376,114 +395,57 @@ func (mj *Service) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	var obj []byte
 	_ = obj
 	_ = err
-	buf.WriteString(`{"ID":`)
-	fflib.WriteJsonString(buf, string(mj.ID))
-	buf.WriteString(`,"Name":`)
-	fflib.WriteJsonString(buf, string(mj.Name))
-	buf.WriteString(`,"Image":`)
-	fflib.WriteJsonString(buf, string(mj.Image))
-	buf.WriteString(`,"Created":`)
+	buf.WriteString(`{ "Time":`)
 
 	{
 
-		obj, err = mj.Created.MarshalJSON()
+		obj, err = j.Time.MarshalJSON()
 		if err != nil {
 			return err
 		}
 		buf.Write(obj)
 
 	}
-	buf.WriteString(`,"Hostname":`)
-	fflib.WriteJsonString(buf, string(mj.Hostname))
-	buf.WriteString(`,"Ports":`)
-	if mj.Ports != nil {
-		buf.WriteString(`[`)
-		for i, v := range mj.Ports {
-			if i != 0 {
-				buf.WriteString(`,`)
-			}
-
-			{
-
-				err = v.MarshalJSONBuf(buf)
-				if err != nil {
-					return err
-				}
-
-			}
-		}
-		buf.WriteString(`]`)
-	} else {
-		buf.WriteString(`null`)
-	}
-	buf.WriteString(`,"Updated":`)
-
-	{
-
-		obj, err = mj.Updated.MarshalJSON()
-		if err != nil {
-			return err
-		}
-		buf.Write(obj)
-
+	buf.WriteString(`,"Latency":`)
+	fflib.FormatBits2(buf, uint64(j.Latency), 10, j.Latency < 0)
+	buf.WriteByte(',')
+	if len(j.OutputHash) != 0 {
+		buf.WriteString(`"OutputHash":`)
+		fflib.WriteJsonString(buf, string(j.OutputHash))
+		buf.WriteByte(',')
 	}
-	buf.WriteString(`,"ProxyMode":`)
-	fflib.WriteJsonString(buf, string(mj.ProxyMode))
-	buf.WriteString(`,"Status":`)
-	fflib.FormatBits2(buf, uint64(mj.Status), 10, mj.Status < 0)
+	buf.Rewind(1)
 	buf.WriteByte('}')
 	return nil
 }
 
 const (
-	ffj_t_Servicebase = iota
-	ffj_t_Serviceno_such_key
-
-	ffj_t_Service_ID
-
-	ffj_t_Service_Name
-
-	ffj_t_Service_Image
+	ffjtCheckInfobase = iota
+	ffjtCheckInfonosuchkey
 
-	ffj_t_Service_Created
+	ffjtCheckInfoTime
 
-	ffj_t_Service_Hostname
+	ffjtCheckInfoLatency
 
-	ffj_t_Service_Ports
-
-	ffj_t_Service_Updated
-
-	ffj_t_Service_ProxyMode
-
-	ffj_t_Service_Status
+	ffjtCheckInfoOutputHash
 )
 
-var ffj_key_Service_ID = []byte("ID")
-
-var ffj_key_Service_Name = []byte("Name")
+var ffjKeyCheckInfoTime = []byte("Time")
 
-var ffj_key_Service_Image = []byte("Image")
+var ffjKeyCheckInfoLatency = []byte("Latency")
 
-var ffj_key_Service_Created = []byte("Created")
+var ffjKeyCheckInfoOutputHash = []byte("OutputHash")
 
-var ffj_key_Service_Hostname = []byte("Hostname")
-
-var ffj_key_Service_Ports = []byte("Ports")
-
-var ffj_key_Service_Updated = []byte("Updated")
-
-var ffj_key_Service_ProxyMode = []byte("ProxyMode")
-
-var ffj_key_Service_Status = []byte("Status")
-
-func (uj *Service) UnmarshalJSON(input []byte) error {
+// UnmarshalJSON umarshall json - template of ffjson
+func (j *CheckInfo) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
-	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
 }
 
-func (uj *Service) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
-	var err error = nil
-	currentKey := ffj_t_Servicebase
+// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
+func (j *CheckInfo) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error
+	currentKey := ffjtCheckInfobase
 	_ = currentKey
 	tok := fflib.FFTok_init
 	wantedTok := fflib.FFTok_init
@@ -529,135 +491,57 @@ mainparse:
 			kn := fs.Output.Bytes()
 			if len(kn) <= 0 {
 				// "" case. hrm.
-				currentKey = ffj_t_Serviceno_such_key
+				currentKey = ffjtCheckInfonosuchkey
 				state = fflib.FFParse_want_colon
 				goto mainparse
 			} else {
 				switch kn[0] {
 
-				case 'C':
-
-					if bytes.Equal(ffj_key_Service_Created, kn) {
-						currentKey = ffj_t_Service_Created
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'H':
-
-					if bytes.Equal(ffj_key_Service_Hostname, kn) {
-						currentKey = ffj_t_Service_Hostname
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'I':
-
-					if bytes.Equal(ffj_key_Service_ID, kn) {
-						currentKey = ffj_t_Service_ID
-						state = fflib.FFParse_want_colon
-						goto mainparse
-
-					} else if bytes.Equal(ffj_key_Service_Image, kn) {
-						currentKey = ffj_t_Service_Image
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'N':
-
-					if bytes.Equal(ffj_key_Service_Name, kn) {
-						currentKey = ffj_t_Service_Name
-						state = fflib.FFParse_want_colon
-						goto mainparse
-					}
-
-				case 'P':
-
-					if bytes.Equal(ffj_key_Service_Ports, kn) {
-						currentKey = ffj_t_Service_Ports
-						state = fflib.FFParse_want_colon
-						goto mainparse
+				case 'L':
 
-					} else if bytes.Equal(ffj_key_Service_ProxyMode, kn) {
-						currentKey = ffj_t_Service_ProxyMode
+					if bytes.Equal(ffjKeyCheckInfoLatency, kn) {
+						currentKey = ffjtCheckInfoLatency
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
-				case 'S':
+				case 'O':
 
-					if bytes.Equal(ffj_key_Service_Status, kn) {
-						currentKey = ffj_t_Service_Status
+					if bytes.Equal(ffjKeyCheckInfoOutputHash, kn) {
+						currentKey = ffjtCheckInfoOutputHash
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
-				case 'U':
+				case 'T':
 
-					if bytes.Equal(ffj_key_Service_Updated, kn) {
-						currentKey = ffj_t_Service_Updated
+					if bytes.Equal(ffjKeyCheckInfoTime, kn) {
+						currentKey = ffjtCheckInfoTime
 						state = fflib.FFParse_want_colon
 						goto mainparse
 					}
 
 				}
 
-				if fflib.EqualFoldRight(ffj_key_Service_Status, kn) {
-					currentKey = ffj_t_Service_Status
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_ProxyMode, kn) {
-					currentKey = ffj_t_Service_ProxyMode
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_Updated, kn) {
-					currentKey = ffj_t_Service_Updated
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffj_key_Service_Ports, kn) {
-					currentKey = ffj_t_Service_Ports
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.EqualFoldRight(ffj_key_Service_Hostname, kn) {
-					currentKey = ffj_t_Service_Hostname
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_Created, kn) {
-					currentKey = ffj_t_Service_Created
-					state = fflib.FFParse_want_colon
-					goto mainparse
-				}
-
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_Image, kn) {
-					currentKey = ffj_t_Service_Image
+				if fflib.EqualFoldRight(ffjKeyCheckInfoOutputHash, kn) {
+					currentKey = ffjtCheckInfoOutputHash
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_Name, kn) {
-					currentKey = ffj_t_Service_Name
+				if fflib.SimpleLetterEqualFold(ffjKeyCheckInfoLatency, kn) {
+					currentKey = ffjtCheckInfoLatency
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				if fflib.SimpleLetterEqualFold(ffj_key_Service_ID, kn) {
-					currentKey = ffj_t_Service_ID
+				if fflib.SimpleLetterEqualFold(ffjKeyCheckInfoTime, kn) {
+					currentKey = ffjtCheckInfoTime
 					state = fflib.FFParse_want_colon
 					goto mainparse
 				}
 
-				currentKey = ffj_t_Serviceno_such_key
+				currentKey = ffjtCheckInfonosuchkey
 				state = fflib.FFParse_want_colon
 				goto mainparse
 			}
@@ -674,34 +558,16 @@ mainparse:
 			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
 				switch currentKey {
 
-				case ffj_t_Service_ID:
-					goto handle_ID
-
-				case ffj_t_Service_Name:
-					goto handle_Name
-
-				case ffj_t_Service_Image:
-					goto handle_Image
-
-				case ffj_t_Service_Created:
-					goto handle_Created
-
-				case ffj_t_Service_Hostname:
-					goto handle_Hostname
-
-				case ffj_t_Service_Ports:
-					goto handle_Ports
-
-				case ffj_t_Service_Updated:
-					goto handle_Updated
+				case ffjtCheckInfoTime:
+					goto handle_Time
 
-				case ffj_t_Service_ProxyMode:
-					goto handle_ProxyMode
+				case ffjtCheckInfoLatency:
+					goto handle_Latency
 
-				case ffj_t_Service_Status:
-					goto handle_Status
+				case ffjtCheckInfoOutputHash:
+					goto handle_OutputHash
 
-				case ffj_t_Serviceno_such_key:
+				case ffjtCheckInfonosuchkey:
 					err = fs.SkipField(tok)
 					if err != nil {
 						return fs.WrapErr(err)
@@ -715,51 +581,54 @@ mainparse:
 		}
 	}
 
-handle_ID:
+handle_Time:
 
-	/* handler: uj.ID type=string kind=string quoted=false*/
+	/* handler: j.Time type=time.Time kind=struct quoted=false*/
 
 	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
-		}
-
 		if tok == fflib.FFTok_null {
 
 		} else {
 
-			outBuf := fs.Output.Bytes()
-
-			uj.ID = string(string(outBuf))
+			tbuf, err := fs.CaptureField(tok)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 
+			err = j.Time.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 		}
+		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Name:
+handle_Latency:
 
-	/* handler: uj.Name type=string kind=string quoted=false*/
+	/* handler: j.Latency type=time.Duration kind=int64 quoted=false*/
 
 	{
-
-		{
-			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
-				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
-			}
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for Duration", tok))
 		}
+	}
+
+	{
 
 		if tok == fflib.FFTok_null {
 
 		} else {
 
-			outBuf := fs.Output.Bytes()
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
 
-			uj.Name = string(string(outBuf))
+			j.Latency = time.Duration(tval)
 
 		}
 	}
@@ -767,9 +636,9 @@ handle_Name:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Image:
+handle_OutputHash:
 
-	/* handler: uj.Image type=string kind=string quoted=false*/
+	/* handler: j.OutputHash type=string kind=string quoted=false*/
 
 	{
 
@@ -785,7 +654,7 @@ handle_Image:
 
 			outBuf := fs.Output.Bytes()
 
-			uj.Image = string(string(outBuf))
+			j.OutputHash = string(string(outBuf))
 
 		}
 	}
@@ -793,35 +662,1961 @@ handle_Image:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Created:
+wantedvalue:
+	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+wrongtokenerror:
+	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
+tokerror:
+	if fs.BigError != nil {
+		return fs.WrapErr(fs.BigError)
+	}
+	err = fs.Error.ToError()
+	if err != nil {
+		return fs.WrapErr(err)
+	}
+	panic("ffjson-generated: unreachable, please report bug.")
+done:
 
-	/* handler: uj.Created type=time.Time kind=struct quoted=false*/
+	return nil
+}
+
+// MarshalJSON marshal bytes to json - template
+func (j *Port) MarshalJSON() ([]byte, error) {
+	var buf fflib.Buffer
+	if j == nil {
+		buf.WriteString("null")
+		return buf.Bytes(), nil
+	}
+	err := j.MarshalJSONBuf(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSONBuf marshal buff to json - template
+func (j *Port) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if j == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	var err error
+	var obj []byte
+	_ = obj
+	_ = err
+	buf.WriteString(`{ "Type":`)
+	fflib.WriteJsonString(buf, string(j.Type))
+	buf.WriteString(`,"Port":`)
+	fflib.FormatBits2(buf, uint64(j.Port), 10, j.Port < 0)
+	buf.WriteString(`,"ServicePort":`)
+	fflib.FormatBits2(buf, uint64(j.ServicePort), 10, j.ServicePort < 0)
+	buf.WriteString(`,"IP":`)
+	fflib.WriteJsonString(buf, string(j.IP))
+	buf.WriteByte(',')
+	if len(j.ProxyMode) != 0 {
+		buf.WriteString(`"ProxyMode":`)
+		fflib.WriteJsonString(buf, string(j.ProxyMode))
+		buf.WriteByte(',')
+	}
+	if j.ContainerPort != 0 {
+		buf.WriteString(`"ContainerPort":`)
+		fflib.FormatBits2(buf, uint64(j.ContainerPort), 10, j.ContainerPort < 0)
+		buf.WriteByte(',')
+	}
+	buf.Rewind(1)
+	buf.WriteByte('}')
+	return nil
+}
+
+const (
+	ffjtPortbase = iota
+	ffjtPortnosuchkey
+
+	ffjtPortType
+
+	ffjtPortPort
+
+	ffjtPortServicePort
+
+	ffjtPortIP
+
+	ffjtPortProxyMode
+
+	ffjtPortContainerPort
+)
+
+var ffjKeyPortType = []byte("Type")
+
+var ffjKeyPortPort = []byte("Port")
+
+var ffjKeyPortServicePort = []byte("ServicePort")
+
+var ffjKeyPortIP = []byte("IP")
+
+var ffjKeyPortProxyMode = []byte("ProxyMode")
+
+var ffjKeyPortContainerPort = []byte("ContainerPort")
+
+// UnmarshalJSON umarshall json - template of ffjson
+func (j *Port) UnmarshalJSON(input []byte) error {
+	fs := fflib.NewFFLexer(input)
+	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+}
+
+// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
+func (j *Port) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error
+	currentKey := ffjtPortbase
+	_ = currentKey
+	tok := fflib.FFTok_init
+	wantedTok := fflib.FFTok_init
+
+mainparse:
+	for {
+		tok = fs.Scan()
+		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
+		if tok == fflib.FFTok_error {
+			goto tokerror
+		}
+
+		switch state {
+
+		case fflib.FFParse_map_start:
+			if tok != fflib.FFTok_left_bracket {
+				wantedTok = fflib.FFTok_left_bracket
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_key
+			continue
+
+		case fflib.FFParse_after_value:
+			if tok == fflib.FFTok_comma {
+				state = fflib.FFParse_want_key
+			} else if tok == fflib.FFTok_right_bracket {
+				goto done
+			} else {
+				wantedTok = fflib.FFTok_comma
+				goto wrongtokenerror
+			}
+
+		case fflib.FFParse_want_key:
+			// json {} ended. goto exit. woo.
+			if tok == fflib.FFTok_right_bracket {
+				goto done
+			}
+			if tok != fflib.FFTok_string {
+				wantedTok = fflib.FFTok_string
+				goto wrongtokenerror
+			}
+
+			kn := fs.Output.Bytes()
+			if len(kn) <= 0 {
+				// "" case. hrm.
+				currentKey = ffjtPortnosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			} else {
+				switch kn[0] {
+
+				case 'C':
+
+					if bytes.Equal(ffjKeyPortContainerPort, kn) {
+						currentKey = ffjtPortContainerPort
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'I':
+
+					if bytes.Equal(ffjKeyPortIP, kn) {
+						currentKey = ffjtPortIP
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'P':
+
+					if bytes.Equal(ffjKeyPortPort, kn) {
+						currentKey = ffjtPortPort
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyPortProxyMode, kn) {
+						currentKey = ffjtPortProxyMode
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'S':
+
+					if bytes.Equal(ffjKeyPortServicePort, kn) {
+						currentKey = ffjtPortServicePort
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'T':
+
+					if bytes.Equal(ffjKeyPortType, kn) {
+						currentKey = ffjtPortType
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyPortContainerPort, kn) {
+					currentKey = ffjtPortContainerPort
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyPortProxyMode, kn) {
+					currentKey = ffjtPortProxyMode
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyPortIP, kn) {
+					currentKey = ffjtPortIP
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyPortServicePort, kn) {
+					currentKey = ffjtPortServicePort
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyPortPort, kn) {
+					currentKey = ffjtPortPort
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyPortType, kn) {
+					currentKey = ffjtPortType
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				currentKey = ffjtPortnosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			}
+
+		case fflib.FFParse_want_colon:
+			if tok != fflib.FFTok_colon {
+				wantedTok = fflib.FFTok_colon
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_value
+			continue
+		case fflib.FFParse_want_value:
+
+			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
+				switch currentKey {
+
+				case ffjtPortType:
+					goto handle_Type
+
+				case ffjtPortPort:
+					goto handle_Port
+
+				case ffjtPortServicePort:
+					goto handle_ServicePort
+
+				case ffjtPortIP:
+					goto handle_IP
+
+				case ffjtPortProxyMode:
+					goto handle_ProxyMode
+
+				case ffjtPortContainerPort:
+					goto handle_ContainerPort
+
+				case ffjtPortnosuchkey:
+					err = fs.SkipField(tok)
+					if err != nil {
+						return fs.WrapErr(err)
+					}
+					state = fflib.FFParse_after_value
+					goto mainparse
+				}
+			} else {
+				goto wantedvalue
+			}
+		}
+	}
+
+handle_Type:
+
+	/* handler: j.Type type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.Type = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Port:
+
+	/* handler: j.Port type=int64 kind=int64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.Port = int64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ServicePort:
+
+	/* handler: j.ServicePort type=int64 kind=int64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.ServicePort = int64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_IP:
+
+	/* handler: j.IP type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.IP = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ProxyMode:
+
+	/* handler: j.ProxyMode type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.ProxyMode = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ContainerPort:
+
+	/* handler: j.ContainerPort type=int64 kind=int64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.ContainerPort = int64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+wantedvalue:
+	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+wrongtokenerror:
+	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
+tokerror:
+	if fs.BigError != nil {
+		return fs.WrapErr(fs.BigError)
+	}
+	err = fs.Error.ToError()
+	if err != nil {
+		return fs.WrapErr(err)
+	}
+	panic("ffjson-generated: unreachable, please report bug.")
+done:
+
+	return nil
+}
+
+// MarshalJSON marshal bytes to json - template
+func (j *Resources) MarshalJSON() ([]byte, error) {
+	var buf fflib.Buffer
+	if j == nil {
+		buf.WriteString("null")
+		return buf.Bytes(), nil
+	}
+	err := j.MarshalJSONBuf(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSONBuf marshal buff to json - template
+func (j *Resources) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if j == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	var err error
+	var obj []byte
+	_ = obj
+	_ = err
+	buf.WriteString(`{"CPUPercent":`)
+	fflib.AppendFloat(buf, float64(j.CPUPercent), 'g', -1, 64)
+	buf.WriteString(`,"MemoryUsage":`)
+	fflib.FormatBits2(buf, uint64(j.MemoryUsage), 10, false)
+	buf.WriteString(`,"MemoryLimit":`)
+	fflib.FormatBits2(buf, uint64(j.MemoryLimit), 10, false)
+	buf.WriteString(`,"Sampled":`)
+
+	{
+
+		obj, err = j.Sampled.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(obj)
+
+	}
+	buf.WriteString(`,"CheckLatency":`)
+	fflib.FormatBits2(buf, uint64(j.CheckLatency), 10, j.CheckLatency < 0)
+	buf.WriteByte('}')
+	return nil
+}
+
+const (
+	ffjtResourcesbase = iota
+	ffjtResourcesnosuchkey
+
+	ffjtResourcesCPUPercent
+
+	ffjtResourcesMemoryUsage
+
+	ffjtResourcesMemoryLimit
+
+	ffjtResourcesSampled
+
+	ffjtResourcesCheckLatency
+)
+
+var ffjKeyResourcesCPUPercent = []byte("CPUPercent")
+
+var ffjKeyResourcesMemoryUsage = []byte("MemoryUsage")
+
+var ffjKeyResourcesMemoryLimit = []byte("MemoryLimit")
+
+var ffjKeyResourcesSampled = []byte("Sampled")
+
+var ffjKeyResourcesCheckLatency = []byte("CheckLatency")
+
+// UnmarshalJSON umarshall json - template of ffjson
+func (j *Resources) UnmarshalJSON(input []byte) error {
+	fs := fflib.NewFFLexer(input)
+	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+}
+
+// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
+func (j *Resources) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error
+	currentKey := ffjtResourcesbase
+	_ = currentKey
+	tok := fflib.FFTok_init
+	wantedTok := fflib.FFTok_init
+
+mainparse:
+	for {
+		tok = fs.Scan()
+		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
+		if tok == fflib.FFTok_error {
+			goto tokerror
+		}
+
+		switch state {
+
+		case fflib.FFParse_map_start:
+			if tok != fflib.FFTok_left_bracket {
+				wantedTok = fflib.FFTok_left_bracket
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_key
+			continue
+
+		case fflib.FFParse_after_value:
+			if tok == fflib.FFTok_comma {
+				state = fflib.FFParse_want_key
+			} else if tok == fflib.FFTok_right_bracket {
+				goto done
+			} else {
+				wantedTok = fflib.FFTok_comma
+				goto wrongtokenerror
+			}
+
+		case fflib.FFParse_want_key:
+			// json {} ended. goto exit. woo.
+			if tok == fflib.FFTok_right_bracket {
+				goto done
+			}
+			if tok != fflib.FFTok_string {
+				wantedTok = fflib.FFTok_string
+				goto wrongtokenerror
+			}
+
+			kn := fs.Output.Bytes()
+			if len(kn) <= 0 {
+				// "" case. hrm.
+				currentKey = ffjtResourcesnosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			} else {
+				switch kn[0] {
+
+				case 'C':
+
+					if bytes.Equal(ffjKeyResourcesCPUPercent, kn) {
+						currentKey = ffjtResourcesCPUPercent
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyResourcesCheckLatency, kn) {
+						currentKey = ffjtResourcesCheckLatency
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'M':
+
+					if bytes.Equal(ffjKeyResourcesMemoryUsage, kn) {
+						currentKey = ffjtResourcesMemoryUsage
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyResourcesMemoryLimit, kn) {
+						currentKey = ffjtResourcesMemoryLimit
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'S':
+
+					if bytes.Equal(ffjKeyResourcesSampled, kn) {
+						currentKey = ffjtResourcesSampled
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.EqualFoldRight(ffjKeyResourcesCheckLatency, kn) {
+					currentKey = ffjtResourcesCheckLatency
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyResourcesSampled, kn) {
+					currentKey = ffjtResourcesSampled
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyResourcesMemoryLimit, kn) {
+					currentKey = ffjtResourcesMemoryLimit
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyResourcesMemoryUsage, kn) {
+					currentKey = ffjtResourcesMemoryUsage
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyResourcesCPUPercent, kn) {
+					currentKey = ffjtResourcesCPUPercent
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				currentKey = ffjtResourcesnosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			}
+
+		case fflib.FFParse_want_colon:
+			if tok != fflib.FFTok_colon {
+				wantedTok = fflib.FFTok_colon
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_value
+			continue
+		case fflib.FFParse_want_value:
+
+			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
+				switch currentKey {
+
+				case ffjtResourcesCPUPercent:
+					goto handle_CPUPercent
+
+				case ffjtResourcesMemoryUsage:
+					goto handle_MemoryUsage
+
+				case ffjtResourcesMemoryLimit:
+					goto handle_MemoryLimit
+
+				case ffjtResourcesSampled:
+					goto handle_Sampled
+
+				case ffjtResourcesCheckLatency:
+					goto handle_CheckLatency
+
+				case ffjtResourcesnosuchkey:
+					err = fs.SkipField(tok)
+					if err != nil {
+						return fs.WrapErr(err)
+					}
+					state = fflib.FFParse_after_value
+					goto mainparse
+				}
+			} else {
+				goto wantedvalue
+			}
+		}
+	}
+
+handle_CPUPercent:
+
+	/* handler: j.CPUPercent type=float64 kind=float64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_double && tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for float64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseFloat(fs.Output.Bytes(), 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.CPUPercent = float64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MemoryUsage:
+
+	/* handler: j.MemoryUsage type=uint64 kind=uint64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for uint64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseUint(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.MemoryUsage = uint64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_MemoryLimit:
+
+	/* handler: j.MemoryLimit type=uint64 kind=uint64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for uint64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseUint(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.MemoryLimit = uint64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Sampled:
+
+	/* handler: j.Sampled type=time.Time kind=struct quoted=false*/
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tbuf, err := fs.CaptureField(tok)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			err = j.Sampled.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+		}
+		state = fflib.FFParse_after_value
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_CheckLatency:
+
+	/* handler: j.CheckLatency type=time.Duration kind=int64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for Duration", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.CheckLatency = time.Duration(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+wantedvalue:
+	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+wrongtokenerror:
+	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
+tokerror:
+	if fs.BigError != nil {
+		return fs.WrapErr(fs.BigError)
+	}
+	err = fs.Error.ToError()
+	if err != nil {
+		return fs.WrapErr(err)
+	}
+	panic("ffjson-generated: unreachable, please report bug.")
+done:
+
+	return nil
+}
+
+// MarshalJSON marshal bytes to json - template
+func (j *Service) MarshalJSON() ([]byte, error) {
+	var buf fflib.Buffer
+	if j == nil {
+		buf.WriteString("null")
+		return buf.Bytes(), nil
+	}
+	err := j.MarshalJSONBuf(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSONBuf marshal buff to json - template
+func (j *Service) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if j == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	var err error
+	var obj []byte
+	_ = obj
+	_ = err
+	buf.WriteString(`{ "ID":`)
+	fflib.WriteJsonString(buf, string(j.ID))
+	buf.WriteString(`,"Name":`)
+	fflib.WriteJsonString(buf, string(j.Name))
+	buf.WriteString(`,"Image":`)
+	fflib.WriteJsonString(buf, string(j.Image))
+	buf.WriteString(`,"Created":`)
+
+	{
+
+		obj, err = j.Created.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(obj)
+
+	}
+	buf.WriteString(`,"Hostname":`)
+	fflib.WriteJsonString(buf, string(j.Hostname))
+	buf.WriteString(`,"Ports":`)
+	if j.Ports != nil {
+		buf.WriteString(`[`)
+		for i, v := range j.Ports {
+			if i != 0 {
+				buf.WriteString(`,`)
+			}
+
+			{
+
+				err = v.MarshalJSONBuf(buf)
+				if err != nil {
+					return err
+				}
+
+			}
+		}
+		buf.WriteString(`]`)
+	} else {
+		buf.WriteString(`null`)
+	}
+	buf.WriteString(`,"Updated":`)
+
+	{
+
+		obj, err = j.Updated.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(obj)
+
+	}
+	buf.WriteString(`,"ProxyMode":`)
+	fflib.WriteJsonString(buf, string(j.ProxyMode))
+	buf.WriteString(`,"Status":`)
+	fflib.FormatBits2(buf, uint64(j.Status), 10, j.Status < 0)
+	buf.WriteByte(',')
+	if j.Resources != nil {
+		if true {
+			buf.WriteString(`"Resources":`)
+
+			{
+
+				err = j.Resources.MarshalJSONBuf(buf)
+				if err != nil {
+					return err
+				}
+
+			}
+			buf.WriteByte(',')
+		}
+	}
+	if len(j.Tags) != 0 {
+		if j.Tags == nil {
+			buf.WriteString(`"Tags":null`)
+		} else {
+			buf.WriteString(`"Tags":{ `)
+			for key, value := range j.Tags {
+				fflib.WriteJsonString(buf, key)
+				buf.WriteString(`:`)
+				fflib.WriteJsonString(buf, string(value))
+				buf.WriteByte(',')
+			}
+			buf.Rewind(1)
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(',')
+	}
+	if len(j.TLSCert) != 0 {
+		buf.WriteString(`"TLSCert":`)
+		fflib.WriteJsonString(buf, string(j.TLSCert))
+		buf.WriteByte(',')
+	}
+	if j.MinInstances != 0 {
+		buf.WriteString(`"MinInstances":`)
+		fflib.FormatBits2(buf, uint64(j.MinInstances), 10, j.MinInstances < 0)
+		buf.WriteByte(',')
+	}
+	if len(j.Aliases) != 0 {
+		buf.WriteString(`"Aliases":`)
+		if j.Aliases != nil {
+			buf.WriteString(`[`)
+			for i, v := range j.Aliases {
+				if i != 0 {
+					buf.WriteString(`,`)
+				}
+				fflib.WriteJsonString(buf, string(v))
+			}
+			buf.WriteString(`]`)
+		} else {
+			buf.WriteString(`null`)
+		}
+		buf.WriteByte(',')
+	}
+	if len(j.ListenOn) != 0 {
+		buf.WriteString(`"ListenOn":`)
+		if j.ListenOn != nil {
+			buf.WriteString(`[`)
+			for i, v := range j.ListenOn {
+				if i != 0 {
+					buf.WriteString(`,`)
+				}
+				fflib.WriteJsonString(buf, string(v))
+			}
+			buf.WriteString(`]`)
+		} else {
+			buf.WriteString(`null`)
+		}
+		buf.WriteByte(',')
+	}
+	if len(j.Source) != 0 {
+		buf.WriteString(`"Source":`)
+		fflib.WriteJsonString(buf, string(j.Source))
+		buf.WriteByte(',')
+	}
+	if len(j.Annotations) != 0 {
+		buf.WriteString(`"Annotations":`)
+		if j.Annotations != nil {
+			buf.WriteString(`[`)
+			for i, v := range j.Annotations {
+				if i != 0 {
+					buf.WriteString(`,`)
+				}
+
+				{
+
+					err = v.MarshalJSONBuf(buf)
+					if err != nil {
+						return err
+					}
+
+				}
+			}
+			buf.WriteString(`]`)
+		} else {
+			buf.WriteString(`null`)
+		}
+		buf.WriteByte(',')
+	}
+	if j.LastCheck != nil {
+		if true {
+			buf.WriteString(`"LastCheck":`)
+
+			{
+
+				err = j.LastCheck.MarshalJSONBuf(buf)
+				if err != nil {
+					return err
+				}
+
+			}
+			buf.WriteByte(',')
+		}
+	}
+	buf.Rewind(1)
+	buf.WriteByte('}')
+	return nil
+}
+
+const (
+	ffjtServicebase = iota
+	ffjtServicenosuchkey
+
+	ffjtServiceID
+
+	ffjtServiceName
+
+	ffjtServiceImage
+
+	ffjtServiceCreated
+
+	ffjtServiceHostname
+
+	ffjtServicePorts
+
+	ffjtServiceUpdated
+
+	ffjtServiceProxyMode
+
+	ffjtServiceStatus
+
+	ffjtServiceResources
+
+	ffjtServiceTags
+
+	ffjtServiceTLSCert
+
+	ffjtServiceMinInstances
+
+	ffjtServiceAliases
+
+	ffjtServiceListenOn
+
+	ffjtServiceSource
+
+	ffjtServiceAnnotations
+
+	ffjtServiceLastCheck
+)
+
+var ffjKeyServiceID = []byte("ID")
+
+var ffjKeyServiceName = []byte("Name")
+
+var ffjKeyServiceImage = []byte("Image")
+
+var ffjKeyServiceCreated = []byte("Created")
+
+var ffjKeyServiceHostname = []byte("Hostname")
+
+var ffjKeyServicePorts = []byte("Ports")
+
+var ffjKeyServiceUpdated = []byte("Updated")
+
+var ffjKeyServiceProxyMode = []byte("ProxyMode")
+
+var ffjKeyServiceStatus = []byte("Status")
+
+var ffjKeyServiceResources = []byte("Resources")
+
+var ffjKeyServiceTags = []byte("Tags")
+
+var ffjKeyServiceTLSCert = []byte("TLSCert")
+
+var ffjKeyServiceMinInstances = []byte("MinInstances")
+
+var ffjKeyServiceAliases = []byte("Aliases")
+
+var ffjKeyServiceListenOn = []byte("ListenOn")
+
+var ffjKeyServiceSource = []byte("Source")
+
+var ffjKeyServiceAnnotations = []byte("Annotations")
+
+var ffjKeyServiceLastCheck = []byte("LastCheck")
+
+// UnmarshalJSON umarshall json - template of ffjson
+func (j *Service) UnmarshalJSON(input []byte) error {
+	fs := fflib.NewFFLexer(input)
+	return j.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+}
+
+// UnmarshalJSONFFLexer fast json unmarshall - template ffjson
+func (j *Service) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error
+	currentKey := ffjtServicebase
+	_ = currentKey
+	tok := fflib.FFTok_init
+	wantedTok := fflib.FFTok_init
+
+mainparse:
+	for {
+		tok = fs.Scan()
+		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
+		if tok == fflib.FFTok_error {
+			goto tokerror
+		}
+
+		switch state {
+
+		case fflib.FFParse_map_start:
+			if tok != fflib.FFTok_left_bracket {
+				wantedTok = fflib.FFTok_left_bracket
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_key
+			continue
+
+		case fflib.FFParse_after_value:
+			if tok == fflib.FFTok_comma {
+				state = fflib.FFParse_want_key
+			} else if tok == fflib.FFTok_right_bracket {
+				goto done
+			} else {
+				wantedTok = fflib.FFTok_comma
+				goto wrongtokenerror
+			}
+
+		case fflib.FFParse_want_key:
+			// json {} ended. goto exit. woo.
+			if tok == fflib.FFTok_right_bracket {
+				goto done
+			}
+			if tok != fflib.FFTok_string {
+				wantedTok = fflib.FFTok_string
+				goto wrongtokenerror
+			}
+
+			kn := fs.Output.Bytes()
+			if len(kn) <= 0 {
+				// "" case. hrm.
+				currentKey = ffjtServicenosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			} else {
+				switch kn[0] {
+
+				case 'A':
+
+					if bytes.Equal(ffjKeyServiceAliases, kn) {
+						currentKey = ffjtServiceAliases
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceAnnotations, kn) {
+						currentKey = ffjtServiceAnnotations
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'C':
+
+					if bytes.Equal(ffjKeyServiceCreated, kn) {
+						currentKey = ffjtServiceCreated
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'H':
+
+					if bytes.Equal(ffjKeyServiceHostname, kn) {
+						currentKey = ffjtServiceHostname
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'I':
+
+					if bytes.Equal(ffjKeyServiceID, kn) {
+						currentKey = ffjtServiceID
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceImage, kn) {
+						currentKey = ffjtServiceImage
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'L':
+
+					if bytes.Equal(ffjKeyServiceListenOn, kn) {
+						currentKey = ffjtServiceListenOn
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceLastCheck, kn) {
+						currentKey = ffjtServiceLastCheck
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'M':
+
+					if bytes.Equal(ffjKeyServiceMinInstances, kn) {
+						currentKey = ffjtServiceMinInstances
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'N':
+
+					if bytes.Equal(ffjKeyServiceName, kn) {
+						currentKey = ffjtServiceName
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'P':
+
+					if bytes.Equal(ffjKeyServicePorts, kn) {
+						currentKey = ffjtServicePorts
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceProxyMode, kn) {
+						currentKey = ffjtServiceProxyMode
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'R':
+
+					if bytes.Equal(ffjKeyServiceResources, kn) {
+						currentKey = ffjtServiceResources
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'S':
+
+					if bytes.Equal(ffjKeyServiceStatus, kn) {
+						currentKey = ffjtServiceStatus
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceSource, kn) {
+						currentKey = ffjtServiceSource
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'T':
+
+					if bytes.Equal(ffjKeyServiceTags, kn) {
+						currentKey = ffjtServiceTags
+						state = fflib.FFParse_want_colon
+						goto mainparse
+
+					} else if bytes.Equal(ffjKeyServiceTLSCert, kn) {
+						currentKey = ffjtServiceTLSCert
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'U':
+
+					if bytes.Equal(ffjKeyServiceUpdated, kn) {
+						currentKey = ffjtServiceUpdated
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceLastCheck, kn) {
+					currentKey = ffjtServiceLastCheck
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceAnnotations, kn) {
+					currentKey = ffjtServiceAnnotations
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceSource, kn) {
+					currentKey = ffjtServiceSource
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceListenOn, kn) {
+					currentKey = ffjtServiceListenOn
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceAliases, kn) {
+					currentKey = ffjtServiceAliases
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceMinInstances, kn) {
+					currentKey = ffjtServiceMinInstances
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceTLSCert, kn) {
+					currentKey = ffjtServiceTLSCert
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceTags, kn) {
+					currentKey = ffjtServiceTags
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceResources, kn) {
+					currentKey = ffjtServiceResources
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceStatus, kn) {
+					currentKey = ffjtServiceStatus
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceProxyMode, kn) {
+					currentKey = ffjtServiceProxyMode
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceUpdated, kn) {
+					currentKey = ffjtServiceUpdated
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServicePorts, kn) {
+					currentKey = ffjtServicePorts
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffjKeyServiceHostname, kn) {
+					currentKey = ffjtServiceHostname
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceCreated, kn) {
+					currentKey = ffjtServiceCreated
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceImage, kn) {
+					currentKey = ffjtServiceImage
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceName, kn) {
+					currentKey = ffjtServiceName
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffjKeyServiceID, kn) {
+					currentKey = ffjtServiceID
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				currentKey = ffjtServicenosuchkey
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			}
+
+		case fflib.FFParse_want_colon:
+			if tok != fflib.FFTok_colon {
+				wantedTok = fflib.FFTok_colon
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_value
+			continue
+		case fflib.FFParse_want_value:
+
+			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
+				switch currentKey {
+
+				case ffjtServiceID:
+					goto handle_ID
+
+				case ffjtServiceName:
+					goto handle_Name
+
+				case ffjtServiceImage:
+					goto handle_Image
+
+				case ffjtServiceCreated:
+					goto handle_Created
+
+				case ffjtServiceHostname:
+					goto handle_Hostname
+
+				case ffjtServicePorts:
+					goto handle_Ports
+
+				case ffjtServiceUpdated:
+					goto handle_Updated
+
+				case ffjtServiceProxyMode:
+					goto handle_ProxyMode
+
+				case ffjtServiceStatus:
+					goto handle_Status
+
+				case ffjtServiceResources:
+					goto handle_Resources
+
+				case ffjtServiceTags:
+					goto handle_Tags
+
+				case ffjtServiceTLSCert:
+					goto handle_TLSCert
+
+				case ffjtServiceMinInstances:
+					goto handle_MinInstances
+
+				case ffjtServiceAliases:
+					goto handle_Aliases
+
+				case ffjtServiceListenOn:
+					goto handle_ListenOn
+
+				case ffjtServiceSource:
+					goto handle_Source
+
+				case ffjtServiceAnnotations:
+					goto handle_Annotations
+
+				case ffjtServiceLastCheck:
+					goto handle_LastCheck
+
+				case ffjtServicenosuchkey:
+					err = fs.SkipField(tok)
+					if err != nil {
+						return fs.WrapErr(err)
+					}
+					state = fflib.FFParse_after_value
+					goto mainparse
+				}
+			} else {
+				goto wantedvalue
+			}
+		}
+	}
+
+handle_ID:
+
+	/* handler: j.ID type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.ID = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Name:
+
+	/* handler: j.Name type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.Name = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Image:
+
+	/* handler: j.Image type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.Image = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Created:
+
+	/* handler: j.Created type=time.Time kind=struct quoted=false*/
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tbuf, err := fs.CaptureField(tok)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			err = j.Created.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+		}
+		state = fflib.FFParse_after_value
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Hostname:
+
+	/* handler: j.Hostname type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.Hostname = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Ports:
+
+	/* handler: j.Ports type=[]service.Port kind=slice quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_left_brace && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+			j.Ports = nil
+		} else {
+
+			j.Ports = []Port{}
+
+			wantVal := true
+
+			for {
+
+				var tmpJPorts Port
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_brace {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: tmpJPorts type=service.Port kind=struct quoted=false*/
+
+				{
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						err = tmpJPorts.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
+						if err != nil {
+							return err
+						}
+					}
+					state = fflib.FFParse_after_value
+				}
+
+				j.Ports = append(j.Ports, tmpJPorts)
+
+				wantVal = false
+			}
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Updated:
+
+	/* handler: j.Updated type=time.Time kind=struct quoted=false*/
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tbuf, err := fs.CaptureField(tok)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			err = j.Updated.UnmarshalJSON(tbuf)
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+		}
+		state = fflib.FFParse_after_value
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_ProxyMode:
+
+	/* handler: j.ProxyMode type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			j.ProxyMode = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Status:
+
+	/* handler: j.Status type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.Status = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Resources:
+
+	/* handler: j.Resources type=service.Resources kind=struct quoted=false*/
 
 	{
 		if tok == fflib.FFTok_null {
 
-			state = fflib.FFParse_after_value
-			goto mainparse
+			j.Resources = nil
+
+		} else {
+
+			if j.Resources == nil {
+				j.Resources = new(Resources)
+			}
+
+			err = j.Resources.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
+			if err != nil {
+				return err
+			}
 		}
+		state = fflib.FFParse_after_value
+	}
 
-		tbuf, err := fs.CaptureField(tok)
-		if err != nil {
-			return fs.WrapErr(err)
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Tags:
+
+	/* handler: j.Tags type=map[string]string kind=map quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_left_bracket && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
 		}
 
-		err = uj.Created.UnmarshalJSON(tbuf)
-		if err != nil {
-			return fs.WrapErr(err)
+		if tok == fflib.FFTok_null {
+			j.Tags = nil
+		} else {
+
+			j.Tags = make(map[string]string, 0)
+
+			wantVal := true
+
+			for {
+
+				var k string
+
+				var tmpJTags string
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_bracket {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: k type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						k = string(string(outBuf))
+
+					}
+				}
+
+				// Expect ':' after key
+				tok = fs.Scan()
+				if tok != fflib.FFTok_colon {
+					return fs.WrapErr(fmt.Errorf("wanted colon token, but got token: %v", tok))
+				}
+
+				tok = fs.Scan()
+				/* handler: tmpJTags type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						tmpJTags = string(string(outBuf))
+
+					}
+				}
+
+				j.Tags[k] = tmpJTags
+
+				wantVal = false
+			}
+
 		}
-		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Hostname:
+handle_TLSCert:
 
-	/* handler: uj.Hostname type=string kind=string quoted=false*/
+	/* handler: j.TLSCert type=string kind=string quoted=false*/
 
 	{
 
@@ -837,7 +2632,7 @@ handle_Hostname:
 
 			outBuf := fs.Output.Bytes()
 
-			uj.Hostname = string(string(outBuf))
+			j.TLSCert = string(string(outBuf))
 
 		}
 	}
@@ -845,9 +2640,39 @@ handle_Hostname:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Ports:
+handle_MinInstances:
+
+	/* handler: j.MinInstances type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.MinInstances = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
 
-	/* handler: uj.Ports type=[]service.Port kind=slice quoted=false*/
+handle_Aliases:
+
+	/* handler: j.Aliases type=[]string kind=slice quoted=false*/
 
 	{
 
@@ -858,16 +2683,16 @@ handle_Ports:
 		}
 
 		if tok == fflib.FFTok_null {
-			uj.Ports = nil
+			j.Aliases = nil
 		} else {
 
-			uj.Ports = []Port{}
+			j.Aliases = []string{}
 
 			wantVal := true
 
 			for {
 
-				var tmp_uj__Ports Port
+				var tmpJAliases string
 
 				tok = fs.Scan()
 				if tok == fflib.FFTok_error {
@@ -888,23 +2713,28 @@ handle_Ports:
 					wantVal = true
 				}
 
-				/* handler: tmp_uj__Ports type=service.Port kind=struct quoted=false*/
+				/* handler: tmpJAliases type=string kind=string quoted=false*/
 
 				{
-					if tok == fflib.FFTok_null {
 
-						state = fflib.FFParse_after_value
-						goto mainparse
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
 					}
 
-					err = tmp_uj__Ports.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
-					if err != nil {
-						return err
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						tmpJAliases = string(string(outBuf))
+
 					}
-					state = fflib.FFParse_after_value
 				}
 
-				uj.Ports = append(uj.Ports, tmp_uj__Ports)
+				j.Aliases = append(j.Aliases, tmpJAliases)
 
 				wantVal = false
 			}
@@ -914,35 +2744,83 @@ handle_Ports:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Updated:
+handle_ListenOn:
 
-	/* handler: uj.Updated type=time.Time kind=struct quoted=false*/
+	/* handler: j.ListenOn type=[]string kind=slice quoted=false*/
 
 	{
-		if tok == fflib.FFTok_null {
 
-			state = fflib.FFParse_after_value
-			goto mainparse
+		{
+			if tok != fflib.FFTok_left_brace && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
 		}
 
-		tbuf, err := fs.CaptureField(tok)
-		if err != nil {
-			return fs.WrapErr(err)
-		}
+		if tok == fflib.FFTok_null {
+			j.ListenOn = nil
+		} else {
 
-		err = uj.Updated.UnmarshalJSON(tbuf)
-		if err != nil {
-			return fs.WrapErr(err)
+			j.ListenOn = []string{}
+
+			wantVal := true
+
+			for {
+
+				var tmpJListenOn string
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_brace {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: tmpJListenOn type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						tmpJListenOn = string(string(outBuf))
+
+					}
+				}
+
+				j.ListenOn = append(j.ListenOn, tmpJListenOn)
+
+				wantVal = false
+			}
 		}
-		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_ProxyMode:
+handle_Source:
 
-	/* handler: uj.ProxyMode type=string kind=string quoted=false*/
+	/* handler: j.Source type=string kind=string quoted=false*/
 
 	{
 
@@ -958,7 +2836,7 @@ handle_ProxyMode:
 
 			outBuf := fs.Output.Bytes()
 
-			uj.ProxyMode = string(string(outBuf))
+			j.Source = string(string(outBuf))
 
 		}
 	}
@@ -966,31 +2844,95 @@ handle_ProxyMode:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
-handle_Status:
+handle_Annotations:
 
-	/* handler: uj.Status type=int kind=int quoted=false*/
+	/* handler: j.Annotations type=[]service.Annotation kind=slice quoted=false*/
 
 	{
-		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
-			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+
+		{
+			if tok != fflib.FFTok_left_brace && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+			j.Annotations = nil
+		} else {
+
+			j.Annotations = []Annotation{}
+
+			wantVal := true
+
+			for {
+
+				var tmpJAnnotations Annotation
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_brace {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: tmpJAnnotations type=service.Annotation kind=struct quoted=false*/
+
+				{
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						err = tmpJAnnotations.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
+						if err != nil {
+							return err
+						}
+					}
+					state = fflib.FFParse_after_value
+				}
+
+				j.Annotations = append(j.Annotations, tmpJAnnotations)
+
+				wantVal = false
+			}
 		}
 	}
 
-	{
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_LastCheck:
+
+	/* handler: j.LastCheck type=service.CheckInfo kind=struct quoted=false*/
 
+	{
 		if tok == fflib.FFTok_null {
 
+			j.LastCheck = nil
+
 		} else {
 
-			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+			if j.LastCheck == nil {
+				j.LastCheck = new(CheckInfo)
+			}
 
+			err = j.LastCheck.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
 			if err != nil {
-				return fs.WrapErr(err)
+				return err
 			}
-
-			uj.Status = int(tval)
-
 		}
+		state = fflib.FFParse_after_value
 	}
 
 	state = fflib.FFParse_after_value
@@ -1,11 +1,10 @@
 package service
 
 import (
-	"os"
+	"strings"
 	"testing"
 	"time"
 
-	docker "github.com/fsouza/go-dockerclient"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -14,8 +13,8 @@ func Test_PortForServicePort(t *testing.T) {
 		svc := &Service{
 			ID: "deadbeef001",
 			Ports: []Port{
-				{"tcp", 8173, 8080, "127.0.0.1"},
-				{"udp", 8172, 8080, "127.0.0.1"},
+				{Type: "tcp", Port: 8173, ServicePort: 8080, IP: "127.0.0.1"},
+				{Type: "udp", Port: 8172, ServicePort: 8080, IP: "127.0.0.1"},
 			},
 		}
 
@@ -29,132 +28,149 @@ func Test_PortForServicePort(t *testing.T) {
 	})
 }
 
-func Test_buildPortFor(t *testing.T) {
-	Convey("buildPortFor()", t, func() {
-		dPort := docker.APIPort{
-			PrivatePort: 80,
-			PublicPort:  8723,
-			Type:        "tcp",
+func Test_ProxyModeFor(t *testing.T) {
+	Convey("ProxyModeFor()", t, func() {
+		svc := &Service{
+			ID:        "deadbeef001",
+			ProxyMode: "http",
+			Ports: []Port{
+				{Type: "tcp", Port: 8173, ServicePort: 8080, IP: "127.0.0.1"},
+				{Type: "tcp", Port: 8173, ServicePort: 9090, IP: "127.0.0.1", ProxyMode: "tcp"},
+			},
 		}
 
-		ip := "127.0.0.1"
+		Convey("uses the mode of the port when it has one", func() {
+			So(svc.ProxyModeFor(9090), ShouldEqual, "tcp")
+		})
 
-		container := &docker.APIContainers{
-			Ports: []docker.APIPort{dPort},
-			Labels: map[string]string{
-				"ServicePort_80": "8080",
-			},
-		}
+		Convey("falls back to the mode of the service", func() {
+			So(svc.ProxyModeFor(8080), ShouldEqual, "http")
+			So(svc.ProxyModeFor(1234), ShouldEqual, "http")
+		})
+	})
+}
+
+func Test_IsStale(t *testing.T) {
+	Convey("IsStale()", t, func() {
+		Convey("identifies records that are too old to process", func() {
+			lifespan := 1 * time.Hour
+			lastUpdated := time.Now().UTC().Add(0-lifespan).Add(0-2 * time.Minute)
 
-		Convey("Maps service ports to internal ports", func() {
-			port := buildPortFor(&dPort, container, ip)
+			svc := &Service{
+				Name:     "hrunting",
+				Updated:  lastUpdated,
+				Hostname: "beowulf",
+			}
+
+			So(svc.IsStale(lifespan), ShouldBeTrue)
 
-			So(port.ServicePort, ShouldEqual, 8080)
-			So(port.Port, ShouldEqual, 8723)
-			So(port.Type, ShouldEqual, "tcp")
+			svc.Updated = time.Now().UTC().Add(0-lifespan)
+			So(svc.IsStale(62*time.Minute), ShouldBeFalse)
 		})
+	})
+}
+
+func Test_CheckIsFresh(t *testing.T) {
+	Convey("CheckIsFresh()", t, func() {
+		now := time.Now().UTC()
+		svc := &Service{Name: "hrunting", Hostname: "beowulf"}
 
-		Convey("Adds the default IP address", func() {
-			port := buildPortFor(&dPort, container, ip)
+		Convey("is fresh when there's nothing to go on", func() {
+			So(svc.CheckIsFresh(time.Minute, now), ShouldBeTrue)
 
-			So(port.IP, ShouldEqual, ip)
+			svc.LastCheck = &CheckInfo{Time: now.Add(-time.Hour)}
+			So(svc.CheckIsFresh(0, now), ShouldBeTrue)
 		})
 
-		Convey("Skips the service port when there is none", func() {
-			delete(container.Labels, "ServicePort_80")
-			port := buildPortFor(&dPort, container, ip)
+		Convey("compares the time of the last check to the max age", func() {
+			svc.LastCheck = &CheckInfo{Time: now.Add(-30 * time.Second)}
+			So(svc.CheckIsFresh(time.Minute, now), ShouldBeTrue)
 
-			So(port.ServicePort, ShouldEqual, 0)
-			So(port.Port, ShouldEqual, 8723)
-			So(port.Type, ShouldEqual, "tcp")
+			svc.LastCheck.Time = now.Add(-2 * time.Minute)
+			So(svc.CheckIsFresh(time.Minute, now), ShouldBeFalse)
 		})
 
-		Convey("Skips the service port when there is a conversion error", func() {
-			container.Labels["ServicePort_80"] = "not a number"
-			port := buildPortFor(&dPort, container, ip)
+		Convey("survives encoding", func() {
+			svc.LastCheck = &CheckInfo{Time: now, Latency: time.Second, OutputHash: "abc123"}
+			encoded, err := svc.Encode()
+			So(err, ShouldBeNil)
 
-			So(port.ServicePort, ShouldEqual, 0)
-			So(port.Port, ShouldEqual, 8723)
-			So(port.Type, ShouldEqual, "tcp")
+			decoded, err := Decode(encoded)
+			So(err, ShouldBeNil)
+			So(*decoded.LastCheck, ShouldResemble, *svc.LastCheck)
 		})
 	})
 }
 
-func Test_ToService(t *testing.T) {
-	sampleAPIContainer := &docker.APIContainers{
-		ID:      "88862023487fa0ae043c47d7b441f684fc39145d1d9fa398450e4da2e53af5e8",
-		Image:   "example.com/docker/fabulous-container:latest",
-		Command: "/fabulous_app",
-		Created: 1457144774,
-		Status:  "Up 34 seconds",
-		Ports: []docker.APIPort{
-			{
-				PrivatePort: 9990,
-				PublicPort:  0,
-				Type:        "tcp",
-				IP:          "",
-			},
-			{
-				PrivatePort: 8080,
-				PublicPort:  31355,
-				Type:        "tcp",
-				IP:          "192.168.77.13",
+func Test_PortEncoding(t *testing.T) {
+	Convey("Ports survive encoding", t, func() {
+		svc := &Service{
+			Name: "hrunting", Hostname: "beowulf",
+			Ports: []Port{
+				{Type: "tcp", Port: 32768, ServicePort: 9000, ContainerPort: 8080},
+				{Type: "tcp", Port: 32769, ServicePort: 10000, ProxyMode: "tcp"},
 			},
-		},
-		SizeRw:     0,
-		SizeRootFs: 0,
-		Names:      []string{"/sample-app-go-worker-eebb5aad1a17ee"},
-		Labels: map[string]string{
-			"ServicePort_8080": "17010",
-			"ProxyMode":        "tcp",
-			"HealthCheck":      "HttpGet",
-			"HealthCheckArgs":  "http://127.0.0.1:39519/status/check",
-		},
-	}
-
-	samplePorts := []Port{
-		{
-			Type:        "tcp",
-			Port:        31355,
-			ServicePort: 17010,
-			IP:          "192.168.77.13",
-		},
-	}
-
-	sampleHostname, _ := os.Hostname()
-
-	Convey("ToService()", t, func() {
-		Convey("Decodes service correctly", func() {
-			service := ToService(sampleAPIContainer, "127.0.0.1")
-			So(service.ID, ShouldEqual, sampleAPIContainer.ID[:12])
-			So(service.Image, ShouldEqual, sampleAPIContainer.Image)
-			So(service.Name, ShouldEqual, sampleAPIContainer.Names[0])
-			So(service.Created.String(), ShouldEqual, "2016-03-05 02:26:14 +0000 UTC")
-			So(service.Hostname, ShouldEqual, sampleHostname)
-			So(samplePorts, ShouldResemble, service.Ports)
-			So(service.Updated, ShouldNotBeNil)
-			So(service.ProxyMode, ShouldEqual, "tcp")
-			So(service.Status, ShouldEqual, 0)
-		})
+		}
+
+		encoded, err := svc.Encode()
+		So(err, ShouldBeNil)
+		So(string(encoded), ShouldContainSubstring, `"ContainerPort":8080`)
+		So(strings.Count(string(encoded), "ContainerPort"), ShouldEqual, 1)
+
+		decoded, err := Decode(encoded)
+		So(err, ShouldBeNil)
+		So(decoded.Ports, ShouldResemble, svc.Ports)
 	})
 }
 
-func Test_IsStale(t *testing.T) {
-	Convey("IsStale()", t, func() {
-		Convey("identifies records that are too old to process", func() {
-			lifespan := 1 * time.Hour
-			lastUpdated := time.Now().UTC().Add(0-lifespan).Add(0-2 * time.Minute)
+func Test_Annotate(t *testing.T) {
+	Convey("Annotate()", t, func() {
+		now := time.Now().UTC()
+		svc := &Service{Name: "hrunting", Hostname: "beowulf"}
 
-			svc := &Service{
-				Name:     "hrunting",
-				Updated:  lastUpdated,
-				Hostname: "beowulf",
+		annotation := func(note string, expires time.Time) Annotation {
+			return Annotation{Note: note, Created: now, Expires: expires}
+		}
+
+		Convey("puts the newest annotation first", func() {
+			svc.Annotate(annotation("first", now.Add(time.Hour)))
+			svc.Annotate(annotation("second", now.Add(time.Hour)))
+
+			So(len(svc.Annotations), ShouldEqual, 2)
+			So(svc.Annotations[0].Note, ShouldEqual, "second")
+		})
+
+		Convey("drops expired annotations", func() {
+			svc.Annotations = []Annotation{annotation("old", now.Add(0-time.Minute))}
+			svc.Annotate(annotation("new", now.Add(time.Hour)))
+
+			So(len(svc.Annotations), ShouldEqual, 1)
+			So(svc.Annotations[0].Note, ShouldEqual, "new")
+		})
+
+		Convey("keeps no more than MAX_ANNOTATIONS", func() {
+			for i := 0; i < MAX_ANNOTATIONS+2; i++ {
+				svc.Annotate(annotation("note", now.Add(time.Hour)))
 			}
 
-			So(svc.IsStale(lifespan), ShouldBeTrue)
+			So(len(svc.Annotations), ShouldEqual, MAX_ANNOTATIONS)
+		})
 
-			svc.Updated = time.Now().UTC().Add(0-lifespan)
-			So(svc.IsStale(62*time.Minute), ShouldBeFalse)
+		Convey("doesn't change copies of the service", func() {
+			svc.Annotate(annotation("first", now.Add(time.Hour)))
+			other := *svc
+			svc.Annotate(annotation("second", now.Add(time.Hour)))
+
+			So(len(other.Annotations), ShouldEqual, 1)
+		})
+	})
+
+	Convey("ActiveAnnotations()", t, func() {
+		now := time.Now().UTC()
+
+		Convey("returns nil when everything has expired", func() {
+			annotations := []Annotation{{Note: "old", Expires: now}}
+			So(ActiveAnnotations(annotations, now), ShouldBeNil)
 		})
 	})
 }
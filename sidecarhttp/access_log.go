@@ -0,0 +1,143 @@
+package sidecarhttp
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	metrics "github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// statusRecorder keeps track of what a handler sent back, for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	streaming bool // The handler flushed part way through
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush passes through to the underlying writer, so that /watch still works
+func (r *statusRecorder) Flush() {
+	r.streaming = true
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// accessLogger logs each request to the API and, separately, the ones that
+// take longer than SlowThreshold along with some diagnostics to help figure
+// out why. Streaming responses like /watch are expected to take a long time,
+// so they are never counted as slow.
+type accessLogger struct {
+	next          http.Handler
+	state         *catalog.ServicesState
+	logRequests   bool
+	slowThreshold time.Duration // 0 disables slow request logging
+	inFlight      int64
+}
+
+// newAccessLogger wraps the handler with request logging when either access
+// logging or slow request logging is enabled.
+func newAccessLogger(next http.Handler, state *catalog.ServicesState, config *HttpConfig) http.Handler {
+	if !config.AccessLog && config.SlowRequestThreshold <= 0 {
+		return next
+	}
+
+	return &accessLogger{
+		next:          next,
+		state:         state,
+		logRequests:   config.AccessLog,
+		slowThreshold: config.SlowRequestThreshold,
+	}
+}
+
+func (l *accessLogger) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	inFlight := atomic.AddInt64(&l.inFlight, 1)
+	defer atomic.AddInt64(&l.inFlight, -1)
+
+	recorder := &statusRecorder{ResponseWriter: response}
+	start := time.Now()
+
+	l.next.ServeHTTP(recorder, req)
+
+	duration := time.Since(start)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	fields := log.Fields{
+		"method":      req.Method,
+		"path":        req.URL.Path,
+		"status":      recorder.status,
+		"duration_ms": float64(duration) / float64(time.Millisecond),
+		"bytes":       recorder.bytes,
+		"client":      clientAddr(req),
+		"user_agent":  req.UserAgent(),
+	}
+
+	if l.logRequests {
+		log.WithFields(fields).Info("HTTP request")
+	}
+
+	if l.slowThreshold <= 0 || duration < l.slowThreshold || recorder.streaming {
+		return
+	}
+
+	metrics.IncrCounter([]string{"http", "slow_requests"}, 1)
+
+	fields["query"] = req.URL.RawQuery
+	fields["in_flight"] = inFlight
+	if l.state != nil {
+		fields["state_lock_wait_ms"] = float64(stateLockWait(l.state)) / float64(time.Millisecond)
+	}
+
+	log.WithFields(fields).Warn("Slow HTTP request")
+}
+
+// stateLockWait measures how long it takes to get a read lock on the state
+// right now. A long wait means something is holding the write lock, which
+// is the usual reason for slow API requests.
+func stateLockWait(state *catalog.ServicesState) time.Duration {
+	start := time.Now()
+	state.RLock()
+	wait := time.Since(start)
+	state.RUnlock()
+
+	return wait
+}
+
+// clientAddr returns the address of the client, without the port. Requests
+// that came through a proxy are identified by the address it forwarded.
+func clientAddr(req *http.Request) string {
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return forwarded
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,93 @@
+package sidecarhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_accessLogger(t *testing.T) {
+	Convey("accessLogger", t, func() {
+		state := catalog.NewServicesState()
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		handler := http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/slow" {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if req.URL.Path == "/watch" {
+				time.Sleep(5 * time.Millisecond)
+				response.(http.Flusher).Flush()
+			}
+			response.WriteHeader(201)
+			response.Write([]byte("hello"))
+		})
+
+		serve := func(wrapped http.Handler, path string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", path, nil)
+			req.RemoteAddr = "10.0.0.1:12345"
+			wrapped.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		Convey("stays out of the way when disabled", func() {
+			wrapped := newAccessLogger(handler, state, &HttpConfig{})
+			So(wrapped, ShouldHaveSameTypeAs, handler)
+		})
+
+		Convey("logs each request", func() {
+			wrapped := newAccessLogger(handler, state, &HttpConfig{AccessLog: true})
+			recorder := serve(wrapped, "/api/services.json")
+
+			So(recorder.Code, ShouldEqual, 201)
+			So(recorder.Body.String(), ShouldEqual, "hello")
+
+			So(len(hook.Entries), ShouldEqual, 1)
+			entry := hook.LastEntry()
+			So(entry.Level, ShouldEqual, log.InfoLevel)
+			So(entry.Data["method"], ShouldEqual, "GET")
+			So(entry.Data["path"], ShouldEqual, "/api/services.json")
+			So(entry.Data["status"], ShouldEqual, 201)
+			So(entry.Data["bytes"], ShouldEqual, 5)
+			So(entry.Data["client"], ShouldEqual, "10.0.0.1")
+		})
+
+		Convey("warns about slow requests", func() {
+			wrapped := newAccessLogger(handler, state, &HttpConfig{SlowRequestThreshold: time.Millisecond})
+
+			serve(wrapped, "/fast")
+			So(hook.Entries, ShouldBeEmpty)
+
+			serve(wrapped, "/slow")
+			So(len(hook.Entries), ShouldEqual, 1)
+			entry := hook.LastEntry()
+			So(entry.Level, ShouldEqual, log.WarnLevel)
+			So(entry.Data["path"], ShouldEqual, "/slow")
+			So(entry.Data, ShouldContainKey, "state_lock_wait_ms")
+			So(entry.Data["in_flight"], ShouldEqual, 1)
+		})
+
+		Convey("doesn't count streaming responses as slow", func() {
+			wrapped := newAccessLogger(handler, state, &HttpConfig{SlowRequestThreshold: time.Millisecond})
+
+			recorder := serve(wrapped, "/watch")
+			So(recorder.Flushed, ShouldBeTrue)
+			So(hook.Entries, ShouldBeEmpty)
+		})
+
+		Convey("uses the forwarded client address", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Forwarded-For", "192.168.1.1")
+
+			So(clientAddr(req), ShouldEqual, "192.168.1.1")
+		})
+	})
+}
@@ -0,0 +1,379 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/logging"
+	"github.com/armon/go-metrics"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AntiEntropyDefaultPeers = 3                // How many peers we sync with when not told
+	AntiEntropyMaxPeers     = 10               // The most peers one request may sync with
+	AntiEntropyMinInterval  = 10 * time.Second // How often we allow a forced sync
+)
+
+// ApiAntiEntropyResult describes a forced push-pull sync with other members
+type ApiAntiEntropyResult struct {
+	Requested int
+	Peers     []string // The members we tried to sync with
+	Synced    int      // How many of them we actually synced with
+	Error     string   `json:",omitempty"`
+}
+
+// ApiLogLevels are the logging levels, globally and for each module. When
+// setting them, modules that are left out keep their level, and an empty
+// level puts a module back on the global one. With a Duration, e.g. "10m",
+// the levels go back to what they were once it's up.
+type ApiLogLevels struct {
+	Level    string            `json:",omitempty"`
+	Modules  map[string]string `json:",omitempty"`
+	Duration string            `json:",omitempty"`
+	RevertAt *time.Time        `json:",omitempty"` // When a Duration will put the levels back
+}
+
+// savedLogLevels are the levels to put back when a Duration is up
+type savedLogLevels struct {
+	level   log.Level
+	modules map[string]log.Level
+}
+
+type AdminApi struct {
+	list  *memberlist.Memberlist
+	state *catalog.ServicesState
+
+	lock            sync.Mutex
+	lastAntiEntropy time.Time
+
+	logRevert   *time.Timer     // Puts the log levels back, nil when nothing is pending
+	logRevertAt time.Time       // When it will
+	logSaved    *savedLogLevels // What it will put back
+
+	// These are the Memberlist calls, replaceable in tests
+	members  func() []*memberlist.Node
+	pushPull func(addrs []string) (int, error)
+}
+
+func newAdminApi(list *memberlist.Memberlist, state *catalog.ServicesState) *AdminApi {
+	api := &AdminApi{list: list, state: state}
+	if list != nil {
+		api.members = list.Members
+		// Join does a full push-pull with each of the addresses it's given
+		api.pushPull = list.Join
+	}
+
+	return api
+}
+
+func (a *AdminApi) HttpMux() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/antientropy", wrap(a.antiEntropyHandler)).Methods("POST")
+	router.HandleFunc("/loglevel", wrap(a.logLevelHandler)).Methods("GET")
+	router.HandleFunc("/loglevel", wrap(a.setLogLevelHandler)).Methods("PUT")
+
+	return router
+}
+
+// antiEntropyHandler does an immediate push-pull state sync with some random
+// peers, rather than waiting for the next scheduled one. That forces the
+// cluster to converge when we suspect gossip messages have been lost. Takes
+// an optional "peers" parameter, how many peers to sync with. We only allow
+// one of these every AntiEntropyMinInterval.
+func (a *AdminApi) antiEntropyHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if a.members == nil || a.pushPull == nil {
+		sendJsonError(response, 503, "Service Unavailable - Not connected to a cluster")
+		return
+	}
+
+	count := AntiEntropyDefaultPeers
+	if value := req.URL.Query().Get("peers"); value != "" {
+		var err error
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 1 || count > AntiEntropyMaxPeers {
+			sendJsonError(response, 400,
+				fmt.Sprintf("Bad request - peers must be between 1 and %d", AntiEntropyMaxPeers),
+			)
+			return
+		}
+	}
+
+	wait := a.reserveAntiEntropy(time.Now().UTC())
+	if wait > 0 {
+		metrics.IncrCounter([]string{"admin", "antientropy", "throttled"}, 1)
+		response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		sendJsonError(response, 429, fmt.Sprintf("Too Many Requests - Try again in %s", wait.Round(time.Second)))
+		return
+	}
+
+	peers := pickPeers(a.members(), a.state.Hostname, count)
+	result := ApiAntiEntropyResult{Requested: count, Peers: make([]string, 0, len(peers))}
+
+	addrs := make([]string, 0, len(peers))
+	for _, node := range peers {
+		result.Peers = append(result.Peers, node.Name)
+		addrs = append(addrs, net.JoinHostPort(node.Addr.String(), strconv.Itoa(int(node.Port))))
+	}
+
+	if len(addrs) > 0 {
+		log.Infof("Forcing anti-entropy sync with %d peers: %v", len(addrs), result.Peers)
+		synced, err := a.pushPull(addrs)
+		result.Synced = synced
+		if err != nil {
+			log.Warnf("Anti-entropy sync failed: %s", err)
+			result.Error = err.Error()
+		}
+	}
+	metrics.IncrCounter([]string{"admin", "antientropy", "synced"}, float32(result.Synced))
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling anti-entropy result: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing anti-entropy response to client: %s", err)
+	}
+}
+
+// reserveAntiEntropy records a sync starting now, unless one ran too recently.
+// Returns how long the caller has to wait, or zero when it may go ahead.
+func (a *AdminApi) reserveAntiEntropy(now time.Time) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	next := a.lastAntiEntropy.Add(AntiEntropyMinInterval)
+	if now.Before(next) {
+		return next.Sub(now)
+	}
+
+	a.lastAntiEntropy = now
+	return 0
+}
+
+// pickPeers returns up to count random members, never including ourselves
+func pickPeers(members []*memberlist.Node, hostname string, count int) []*memberlist.Node {
+	candidates := make([]*memberlist.Node, 0, len(members))
+	for _, node := range members {
+		if node.Name == hostname {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+
+	return candidates
+}
+
+// logLevelHandler reports the global logging level and that of each module
+func (a *AdminApi) logLevelHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	a.sendLogLevels(response)
+}
+
+// setLogLevelHandler changes the logging levels without a restart, e.g. to
+// turn on debug logging for the catalog for a few minutes during an incident
+func (a *AdminApi) setLogLevelHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	var request ApiLogLevels
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		sendJsonError(response, 400, "Bad request - "+err.Error())
+		return
+	}
+
+	var duration time.Duration
+	if request.Duration != "" {
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - invalid duration %q", request.Duration))
+			return
+		}
+	}
+
+	// Validate everything before we change anything
+	var level log.Level
+	if request.Level != "" {
+		level, err = log.ParseLevel(request.Level)
+		if err != nil {
+			sendJsonError(response, 400, "Bad request - "+err.Error())
+			return
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, name := range logging.Modules() {
+		known[name] = true
+	}
+
+	moduleLevels := make(map[string]log.Level, len(request.Modules))
+	for name, value := range request.Modules {
+		if !known[name] {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - unknown module %q", name))
+			return
+		}
+		if value == "" {
+			continue
+		}
+		moduleLevels[name], err = log.ParseLevel(value)
+		if err != nil {
+			sendJsonError(response, 400, "Bad request - "+err.Error())
+			return
+		}
+	}
+
+	a.lock.Lock()
+	a.scheduleLogRevert(duration)
+	a.lock.Unlock()
+
+	if request.Level != "" {
+		logging.SetLevel(level)
+	}
+	for name, value := range request.Modules {
+		if value == "" {
+			logging.ResetModuleLevel(name)
+		} else {
+			logging.SetModuleLevel(name, moduleLevels[name])
+		}
+	}
+
+	log.Warnf("Logging levels changed by %s: %s", req.RemoteAddr, formatLogLevels())
+
+	a.sendLogLevels(response)
+}
+
+// scheduleLogRevert saves the current levels and arranges for them to be put
+// back after the duration. A zero duration makes the next change permanent,
+// cancelling any revert that's pending. When one is already pending, we keep
+// the levels it saved, since the current ones are only temporary. Expects
+// the caller to hold the lock.
+func (a *AdminApi) scheduleLogRevert(duration time.Duration) {
+	saved := a.logSaved
+	if a.logRevert != nil {
+		a.logRevert.Stop()
+		a.logRevert = nil
+		a.logSaved = nil
+	}
+
+	if duration == 0 {
+		return
+	}
+
+	if saved == nil {
+		saved = &savedLogLevels{level: log.GetLevel(), modules: logging.ModuleLevels()}
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+
+		// Replaced by a later change
+		if a.logRevert != timer {
+			return
+		}
+
+		restoreLogLevels(saved)
+		log.Warnf("Logging levels reverted: %s", formatLogLevels())
+
+		a.logRevert = nil
+		a.logSaved = nil
+	})
+
+	a.logRevert = timer
+	a.logRevertAt = time.Now().UTC().Add(duration)
+	a.logSaved = saved
+}
+
+// restoreLogLevels puts back the levels saved before a temporary change
+func restoreLogLevels(saved *savedLogLevels) {
+	logging.SetLevel(saved.level)
+	for _, name := range logging.Modules() {
+		if level, ok := saved.modules[name]; ok {
+			logging.SetModuleLevel(name, level)
+		} else {
+			logging.ResetModuleLevel(name)
+		}
+	}
+}
+
+// currentLogLevels returns the global level and the effective level of each
+// module
+func currentLogLevels() ApiLogLevels {
+	global := log.GetLevel()
+	overrides := logging.ModuleLevels()
+
+	levels := ApiLogLevels{Level: global.String(), Modules: make(map[string]string)}
+	for _, name := range logging.Modules() {
+		level, ok := overrides[name]
+		if !ok {
+			level = global
+		}
+		levels.Modules[name] = level.String()
+	}
+
+	return levels
+}
+
+// formatLogLevels describes the levels for the logs
+func formatLogLevels() string {
+	levels := currentLogLevels()
+
+	var parts []string
+	for _, name := range logging.Modules() {
+		parts = append(parts, name+"="+levels.Modules[name])
+	}
+
+	return fmt.Sprintf("level=%s %s", levels.Level, strings.Join(parts, " "))
+}
+
+func (a *AdminApi) sendLogLevels(response http.ResponseWriter) {
+	levels := currentLogLevels()
+
+	a.lock.Lock()
+	if a.logRevert != nil {
+		revertAt := a.logRevertAt
+		levels.RevertAt = &revertAt
+	}
+	a.lock.Unlock()
+
+	jsonBytes, err := json.MarshalIndent(&levels, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling log levels: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing log levels response to client: %s", err)
+	}
+}
@@ -0,0 +1,207 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/logging"
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_AntiEntropyHandler(t *testing.T) {
+	Convey("antiEntropyHandler", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+
+		nodes := []*memberlist.Node{
+			{Name: "chaucer", Addr: net.ParseIP("10.0.0.1"), Port: 7946},
+			{Name: "shakespeare", Addr: net.ParseIP("10.0.0.2"), Port: 7946},
+			{Name: "marlowe", Addr: net.ParseIP("10.0.0.3"), Port: 7946},
+		}
+
+		var synced []string
+		api := newAdminApi(nil, state)
+		api.members = func() []*memberlist.Node { return nodes }
+		api.pushPull = func(addrs []string) (int, error) {
+			synced = append(synced, addrs...)
+			return len(addrs), nil
+		}
+
+		recorder := httptest.NewRecorder()
+
+		Convey("syncs with the other members", func() {
+			req := httptest.NewRequest("POST", "/antientropy", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+			status, headers, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+			So(synced, ShouldContain, "10.0.0.2:7946")
+			So(synced, ShouldContain, "10.0.0.3:7946")
+			So(synced, ShouldNotContain, "10.0.0.1:7946")
+
+			var result ApiAntiEntropyResult
+			err := json.Unmarshal([]byte(body), &result)
+			So(err, ShouldBeNil)
+			So(result.Requested, ShouldEqual, AntiEntropyDefaultPeers)
+			So(result.Synced, ShouldEqual, 2)
+			So(result.Peers, ShouldNotContain, "chaucer")
+		})
+
+		Convey("limits the sync to the requested number of peers", func() {
+			req := httptest.NewRequest("POST", "/antientropy?peers=1", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(len(synced), ShouldEqual, 1)
+		})
+
+		Convey("rejects peer counts out of bounds", func() {
+			for _, peers := range []string{"0", "11", "junk"} {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", "/antientropy?peers="+peers, nil)
+				api.antiEntropyHandler(recorder, req, nil)
+				status, _, body := getResult(recorder)
+
+				So(status, ShouldEqual, 400)
+				So(body, ShouldContainSubstring, "peers must be")
+			}
+			So(synced, ShouldBeEmpty)
+		})
+
+		Convey("throttles repeated requests", func() {
+			req := httptest.NewRequest("POST", "/antientropy", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+
+			again := httptest.NewRecorder()
+			api.antiEntropyHandler(again, httptest.NewRequest("POST", "/antientropy", nil), nil)
+			status, headers, _ := getResult(again)
+
+			So(status, ShouldEqual, 429)
+			So(headers.Get("Retry-After"), ShouldEqual, "10")
+			So(len(synced), ShouldEqual, 2)
+		})
+
+		Convey("allows another sync once the interval has passed", func() {
+			api.lastAntiEntropy = time.Now().UTC().Add(-AntiEntropyMinInterval)
+			req := httptest.NewRequest("POST", "/antientropy", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+		})
+
+		Convey("reports sync errors", func() {
+			api.pushPull = func(addrs []string) (int, error) {
+				return 0, errors.New("no route to host")
+			}
+			req := httptest.NewRequest("POST", "/antientropy", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "no route to host")
+		})
+
+		Convey("returns a 503 without a cluster", func() {
+			api := newAdminApi(nil, state)
+			req := httptest.NewRequest("POST", "/antientropy", nil)
+			api.antiEntropyHandler(recorder, req, nil)
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 503)
+		})
+	})
+}
+
+func Test_LogLevelHandlers(t *testing.T) {
+	Convey("Log level handlers", t, func() {
+		logging.Module("catalog")
+		logging.SetLevel(log.InfoLevel)
+		Reset(func() {
+			logging.ResetModuleLevel("catalog")
+			logging.SetLevel(log.InfoLevel)
+		})
+
+		api := newAdminApi(nil, catalog.NewServicesState())
+		recorder := httptest.NewRecorder()
+
+		put := func(body string) (int, ApiLogLevels) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/loglevel", strings.NewReader(body))
+			api.setLogLevelHandler(recorder, req, nil)
+			status, _, respBody := getResult(recorder)
+
+			var levels ApiLogLevels
+			json.Unmarshal([]byte(respBody), &levels)
+			return status, levels
+		}
+
+		Convey("report the levels", func() {
+			req := httptest.NewRequest("GET", "/loglevel", nil)
+			api.logLevelHandler(recorder, req, nil)
+			status, _, body := getResult(recorder)
+
+			var levels ApiLogLevels
+			So(status, ShouldEqual, 200)
+			So(json.Unmarshal([]byte(body), &levels), ShouldBeNil)
+			So(levels.Level, ShouldEqual, "info")
+			So(levels.Modules["catalog"], ShouldEqual, "info")
+		})
+
+		Convey("set the global level and module levels", func() {
+			status, levels := put(`{"Level": "warning", "Modules": {"catalog": "debug"}}`)
+
+			So(status, ShouldEqual, 200)
+			So(log.GetLevel(), ShouldEqual, log.WarnLevel)
+			So(levels.Level, ShouldEqual, "warning")
+			So(levels.Modules["catalog"], ShouldEqual, "debug")
+			So(levels.RevertAt, ShouldBeNil)
+
+			Convey("and put a module back on the global level", func() {
+				_, levels := put(`{"Modules": {"catalog": ""}}`)
+				So(levels.Modules["catalog"], ShouldEqual, "warning")
+			})
+		})
+
+		Convey("put the levels back after the duration", func() {
+			status, levels := put(`{"Modules": {"catalog": "debug"}, "Duration": "50ms"}`)
+			So(status, ShouldEqual, 200)
+			So(levels.RevertAt, ShouldNotBeNil)
+			So(logging.ModuleLevels(), ShouldContainKey, "catalog")
+
+			time.Sleep(100 * time.Millisecond)
+			So(logging.ModuleLevels(), ShouldBeEmpty)
+		})
+
+		Convey("don't revert when a later change is permanent", func() {
+			put(`{"Level": "debug", "Duration": "50ms"}`)
+			put(`{"Level": "error"}`)
+
+			time.Sleep(100 * time.Millisecond)
+			So(log.GetLevel(), ShouldEqual, log.ErrorLevel)
+		})
+
+		Convey("reject bad requests without changing anything", func() {
+			for _, body := range []string{
+				`{"Level": "loud"}`,
+				`{"Level": "debug", "Modules": {"grendel": "debug"}}`,
+				`{"Level": "debug", "Duration": "soon"}`,
+				`not json`,
+			} {
+				status, _ := put(body)
+				So(status, ShouldEqual, 400)
+			}
+			So(log.GetLevel(), ShouldEqual, log.InfoLevel)
+		})
+	})
+}
@@ -0,0 +1,469 @@
+package sidecarhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	V2ContentType  = "application/vnd.sidecar.v2+json" // Ask for this to pin the v2 response shapes
+	V2DefaultLimit = 100                               // Items per page when no "limit" is given
+	V2MaxLimit     = 1000                              // The most items we'll send in one page
+)
+
+// ApiV2Envelope wraps every v2 response, so that clients always find the
+// data, the paging, and any error in the same place
+type ApiV2Envelope struct {
+	Data  interface{} `json:",omitempty"`
+	Meta  *ApiV2Meta  `json:",omitempty"`
+	Error *ApiV2Error `json:",omitempty"`
+}
+
+type ApiV2Meta struct {
+	Version     uint64 // The state version the data was taken from
+	ClusterName string `json:",omitempty"`
+	Total       int    `json:",omitempty"` // How many items matched, over all the pages
+	Next        string `json:",omitempty"` // Pass as "cursor" to get the next page
+}
+
+type ApiV2Error struct {
+	Status  int
+	Code    string // e.g. "bad_request"
+	Message string
+}
+
+type ApiV2Service struct {
+	Name      string
+	Instances []map[string]interface{}
+	Health    *catalog.ServiceHealth
+}
+
+// V2HttpMux serves the /api/v2 endpoints. Unlike the older ones, they all
+// return an ApiV2Envelope, take the same paging and "fields" parameters, and
+// pick the response type from the Accept header instead of an extension.
+func (s *SidecarApi) V2HttpMux() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/services", wrap(s.v2ServicesHandler)).Methods("GET")
+	router.HandleFunc("/services/{name}", wrap(s.v2OneServiceHandler)).Methods("GET")
+	router.HandleFunc("/servers", wrap(s.v2ServersHandler)).Methods("GET")
+	router.HandleFunc("/state/version", wrap(s.v2StateVersionHandler)).Methods("GET")
+	router.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	router.NotFoundHandler = http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		sendV2Error(response, 404, "no such endpoint")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		sendV2Error(response, 405, fmt.Sprintf("%s isn't supported here", req.Method))
+	})
+	router.Use(s.cors.middleware)
+	router.Use(negotiateV2)
+
+	return router
+}
+
+// v2ServicesHandler returns a page of service instances, sorted by name,
+// hostname, and ID. Takes the "status" parameter like the older endpoints,
+// and "name", "hostname" and "selector" (e.g. "env=prod") to narrow it down.
+// Passing "include=history" adds each instance's status history. Passing
+// "since=<version>" leaves out the servers that haven't changed since that
+// state version, so that clients can fetch only what's new.
+func (s *SidecarApi) v2ServicesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	query := req.URL.Query()
+
+	filter, err := parseStatusFilter(query.Get("status"), s.hideTombstones)
+	if err != nil {
+		sendV2Error(response, 400, err.Error())
+		return
+	}
+
+	var selector service.Selector
+	if spec := query.Get("selector"); spec != "" {
+		selector, err = service.ParseSelector(spec)
+		if err != nil {
+			sendV2Error(response, 400, err.Error())
+			return
+		}
+	}
+
+	page, err := parsePage(req)
+	if err != nil {
+		sendV2Error(response, 400, err.Error())
+		return
+	}
+
+	var serverVersions map[string]uint64
+	var since uint64
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		since, err = strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			sendV2Error(response, 400, fmt.Sprintf("invalid version %q", sinceStr))
+			return
+		}
+		serverVersions = s.state.ServerVersions()
+	}
+
+	names := listParam(query.Get("name"))
+	hostname := query.Get("hostname")
+
+	version := s.state.Version()
+	state := s.state.SnapshotServices()
+	filter.apply(state)
+
+	var matched []*service.Service
+	state.EachService(func(_ *string, _ *string, svc *service.Service) {
+		if (len(names) > 0 && !names[svc.Name]) ||
+			(hostname != "" && svc.Hostname != hostname) ||
+			(selector != nil && !selector.Matches(svc)) ||
+			(serverVersions != nil && serverVersions[svc.Hostname] <= since) {
+			return
+		}
+		matched = append(matched, svc)
+	})
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		return a.ID < b.ID
+	})
+
+	start, end, next := page.bounds(len(matched))
+	fields := parseFields(query.Get("fields"))
+	withHistory := includes(query.Get("include"), "history")
+	proxies := s.conditionsProxyView(fields)
+
+	items := make([]map[string]interface{}, 0, end-start)
+	for _, svc := range matched[start:end] {
+		item, err := s.v2Service(svc, fields, withHistory, proxies)
+		if err != nil {
+			log.Errorf("Error encoding service %s for the v2 API: %s", svc.ID, err)
+			sendV2Error(response, 500, "unable to encode the services")
+			return
+		}
+		items = append(items, item)
+	}
+
+	_, clusterName := s.listMembers()
+	sendV2(response, 200, ApiV2Envelope{
+		Data: items,
+		Meta: &ApiV2Meta{Version: version, ClusterName: clusterName, Total: len(matched), Next: next},
+	})
+}
+
+// v2OneServiceHandler returns every instance of one service, looked up by
+// name or alias, along with its health
+func (s *SidecarApi) v2OneServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	query := req.URL.Query()
+	name := params["name"]
+
+	filter, err := parseStatusFilter(query.Get("status"), s.hideTombstones)
+	if err != nil {
+		sendV2Error(response, 400, err.Error())
+		return
+	}
+
+	version := s.state.Version()
+	state := s.state.SnapshotServices()
+	filter.apply(state)
+
+	instances := state.ByService()[name]
+	if len(instances) == 0 {
+		sendV2Error(response, 404, fmt.Sprintf("no instances of %s found", name))
+		return
+	}
+
+	fields := parseFields(query.Get("fields"))
+	withHistory := includes(query.Get("include"), "history")
+	proxies := s.conditionsProxyView(fields)
+
+	result := ApiV2Service{
+		Name:      name,
+		Instances: make([]map[string]interface{}, 0, len(instances)),
+		Health:    catalog.SummarizeHealth(map[string][]*service.Service{name: instances})[name],
+	}
+
+	for _, svc := range instances {
+		item, err := s.v2Service(svc, fields, withHistory, proxies)
+		if err != nil {
+			log.Errorf("Error encoding service %s for the v2 API: %s", svc.ID, err)
+			sendV2Error(response, 500, "unable to encode the service")
+			return
+		}
+		result.Instances = append(result.Instances, item)
+	}
+
+	_, clusterName := s.listMembers()
+	sendV2(response, 200, ApiV2Envelope{
+		Data: result,
+		Meta: &ApiV2Meta{Version: version, ClusterName: clusterName, Total: len(instances)},
+	})
+}
+
+// v2ServersHandler returns a page of the cluster members, sorted by name
+func (s *SidecarApi) v2ServersHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	page, err := parsePage(req)
+	if err != nil {
+		sendV2Error(response, 400, err.Error())
+		return
+	}
+
+	listMembers, clusterName := s.listMembers()
+	version := s.state.Version()
+	members := s.clusterMembers(s.state.SnapshotServices(), listMembers, s.state.ClockSkews())
+
+	servers := make([]*ApiServer, 0, len(members))
+	for _, member := range listMembers {
+		servers = append(servers, members[member.Name])
+	}
+
+	start, end, next := page.bounds(len(servers))
+	sendV2(response, 200, ApiV2Envelope{
+		Data: servers[start:end],
+		Meta: &ApiV2Meta{Version: version, ClusterName: clusterName, Total: len(servers), Next: next},
+	})
+}
+
+// v2StateVersionHandler returns the state version and when it last changed
+func (s *SidecarApi) v2StateVersionHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	result := ApiStateVersion{
+		Version: s.state.Version(),
+		Servers: s.state.ServerVersions(),
+	}
+
+	s.state.RLock()
+	result.LastChanged = s.state.LastChanged
+	s.state.RUnlock()
+
+	sendV2(response, 200, ApiV2Envelope{Data: result, Meta: &ApiV2Meta{Version: result.Version}})
+}
+
+// conditionsProxyView loads what the proxies were given when the client wants
+// the conditions, nil when it doesn't. Checking the proxies isn't free, so
+// it's done once for the whole request.
+func (s *SidecarApi) conditionsProxyView(fields fieldSet) *proxyView {
+	if fields == nil || fields["conditions"] {
+		return s.loadProxyView()
+	}
+	return nil
+}
+
+// v2Service turns a service into a map of its fields, so that we can add
+// the history and conditions, and leave out the fields that weren't asked
+// for. The conditions are only added when there's a proxyView for them.
+func (s *SidecarApi) v2Service(svc *service.Service, fields fieldSet, withHistory bool, proxies *proxyView) (map[string]interface{}, error) {
+	encoded, err := svc.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var item map[string]interface{}
+	err = json.Unmarshal(encoded, &item)
+	if err != nil {
+		return nil, err
+	}
+
+	if withHistory {
+		s.state.RLock()
+		item["History"] = s.state.ServiceHistory(svc.ID)
+		s.state.RUnlock()
+	}
+
+	if proxies != nil {
+		item["Conditions"] = s.serviceConditions(svc, proxies)
+	}
+
+	return fields.apply(item), nil
+}
+
+// A v2Page is the part of a list that a client asked for
+type v2Page struct {
+	offset int
+	limit  int
+}
+
+// parsePage reads the "limit" and "cursor" parameters
+func parsePage(req *http.Request) (v2Page, error) {
+	page := v2Page{limit: V2DefaultLimit}
+	query := req.URL.Query()
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("invalid limit %q", limitStr)
+		}
+		if limit > V2MaxLimit {
+			limit = V2MaxLimit
+		}
+		page.limit = limit
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return page, fmt.Errorf("invalid cursor %q", cursor)
+		}
+		page.offset, err = strconv.Atoi(string(decoded))
+		if err != nil || page.offset < 0 {
+			return page, fmt.Errorf("invalid cursor %q", cursor)
+		}
+	}
+
+	return page, nil
+}
+
+// bounds returns the slice of a list of length total that's on the page, and
+// the cursor for the next page, if there is one
+func (p v2Page) bounds(total int) (int, int, string) {
+	start := p.offset
+	if start > total {
+		start = total
+	}
+
+	end := start + p.limit
+	if end >= total {
+		return start, total, ""
+	}
+
+	return start, end, base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+}
+
+// A fieldSet is the fields a client wants in each item, by lower case name.
+// A nil set keeps them all.
+type fieldSet map[string]bool
+
+// parseFields reads a csv list of field names, in any case
+func parseFields(value string) fieldSet {
+	if value == "" {
+		return nil
+	}
+
+	fields := make(fieldSet)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fields[strings.ToLower(name)] = true
+		}
+	}
+	return fields
+}
+
+func (f fieldSet) apply(item map[string]interface{}) map[string]interface{} {
+	if f == nil {
+		return item
+	}
+
+	for key := range item {
+		if !f[strings.ToLower(key)] {
+			delete(item, key)
+		}
+	}
+	return item
+}
+
+// listParam splits a csv parameter into a set
+func listParam(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		set[strings.TrimSpace(item)] = true
+	}
+	return set
+}
+
+// includes returns true when the csv "include" parameter names the option
+func includes(value string, option string) bool {
+	return listParam(value)[option]
+}
+
+// negotiateV2 picks the response Content-Type from the Accept header, and
+// refuses requests for anything we can't send
+func negotiateV2(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		contentType, ok := v2ContentTypeFor(req.Header.Get("Accept"))
+		if !ok {
+			sendV2Error(response, 406,
+				fmt.Sprintf("only application/json and %s are supported", V2ContentType),
+			)
+			return
+		}
+
+		response.Header().Set("Content-Type", contentType)
+		next.ServeHTTP(response, req)
+	})
+}
+
+// v2ContentTypeFor returns the first type in the Accept header that we can
+// send. Without one, or with a wildcard, it's plain JSON.
+func v2ContentTypeFor(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "application/json", true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+
+		switch mediaType {
+		case V2ContentType:
+			return V2ContentType, true
+		case "application/json", "application/*", "*/*":
+			return "application/json", true
+		}
+	}
+
+	return "", false
+}
+
+// sendV2 writes the envelope with the status
+func sendV2(response http.ResponseWriter, status int, envelope ApiV2Envelope) {
+	jsonBytes, err := json.MarshalIndent(&envelope, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling v2 API response: %s", err)
+		status = 500
+		jsonBytes = []byte(`{"Error": {"Status": 500, "Code": "internal_server_error", "Message": "unable to encode the response"}}`)
+	}
+
+	if response.Header().Get("Content-Type") == "" {
+		response.Header().Set("Content-Type", "application/json")
+	}
+
+	response.WriteHeader(status)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing v2 API response to client: %s", err)
+	}
+}
+
+// sendV2Error writes an envelope with just the error
+func sendV2Error(response http.ResponseWriter, status int, message string) {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	sendV2(response, status, ApiV2Envelope{
+		Error: &ApiV2Error{Status: status, Code: code, Message: message},
+	})
+}
@@ -0,0 +1,254 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_V2Api(t *testing.T) {
+	Convey("The v2 API", t, func() {
+		state := catalog.NewServicesState()
+		baseTime := time.Now().UTC()
+
+		for i, name := range []string{"chaucer", "bocaccio", "chaucer", "bocaccio", "dante"} {
+			state.AddServiceEntry(service.Service{
+				ID:       fmt.Sprintf("deadbeef%03d", i),
+				Name:     name,
+				Image:    name + ":latest",
+				Hostname: fmt.Sprintf("host%d", i%2),
+				Updated:  baseTime,
+				Status:   service.ALIVE,
+				Tags:     map[string]string{"env": []string{"prod", "staging"}[i%2]},
+			})
+		}
+
+		api := &SidecarApi{state: state}
+		mux := api.V2HttpMux()
+
+		get := func(url string, accept string) (int, string, ApiV2Envelope, map[string]interface{}) {
+			req := httptest.NewRequest("GET", url, nil)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, req)
+
+			var envelope ApiV2Envelope
+			var raw map[string]interface{}
+			So(json.Unmarshal(recorder.Body.Bytes(), &envelope), ShouldBeNil)
+			So(json.Unmarshal(recorder.Body.Bytes(), &raw), ShouldBeNil)
+
+			return recorder.Code, recorder.Header().Get("Content-Type"), envelope, raw
+		}
+
+		items := func(envelope ApiV2Envelope) []map[string]interface{} {
+			var result []map[string]interface{}
+			for _, item := range envelope.Data.([]interface{}) {
+				result = append(result, item.(map[string]interface{}))
+			}
+			return result
+		}
+
+		Convey("lists services in a stable order", func() {
+			status, contentType, envelope, _ := get("/services", "")
+
+			So(status, ShouldEqual, 200)
+			So(contentType, ShouldEqual, "application/json")
+			So(envelope.Meta.Total, ShouldEqual, 5)
+			So(envelope.Meta.Version, ShouldEqual, state.Version())
+			So(envelope.Meta.Next, ShouldBeEmpty)
+
+			var ids []interface{}
+			for _, item := range items(envelope) {
+				ids = append(ids, item["ID"])
+			}
+			So(ids, ShouldResemble, []interface{}{
+				"deadbeef001", "deadbeef003", "deadbeef000", "deadbeef002", "deadbeef004",
+			})
+		})
+
+		Convey("pages through the services", func() {
+			_, _, first, _ := get("/services?limit=2", "")
+			So(len(items(first)), ShouldEqual, 2)
+			So(first.Meta.Next, ShouldNotBeEmpty)
+
+			_, _, second, _ := get("/services?limit=2&cursor="+first.Meta.Next, "")
+			So(items(second)[0]["ID"], ShouldEqual, "deadbeef000")
+
+			_, _, last, _ := get("/services?limit=2&cursor="+second.Meta.Next, "")
+			So(len(items(last)), ShouldEqual, 1)
+			So(last.Meta.Next, ShouldBeEmpty)
+		})
+
+		Convey("filters the services and their fields", func() {
+			_, _, envelope, _ := get("/services?name=chaucer,dante&selector=env=prod&fields=id,name", "")
+
+			So(envelope.Meta.Total, ShouldEqual, 3)
+			for _, item := range items(envelope) {
+				So(len(item), ShouldEqual, 2)
+				So(item["Name"], ShouldBeIn, "chaucer", "dante")
+			}
+
+			_, _, envelope, _ = get("/services?hostname=host1", "")
+			So(envelope.Meta.Total, ShouldEqual, 2)
+		})
+
+		Convey("includes the history when asked", func() {
+			_, _, envelope, _ := get("/services?include=history&fields=ID,History", "")
+			So(items(envelope)[0], ShouldContainKey, "History")
+		})
+
+		Convey("only lists the servers that changed since a version", func() {
+			version := state.Version()
+
+			_, _, envelope, _ := get(fmt.Sprintf("/services?since=%d", version), "")
+			So(envelope.Meta.Total, ShouldEqual, 0)
+			So(envelope.Meta.Version, ShouldEqual, version)
+
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef000", Name: "chaucer", Hostname: "host0",
+				Updated: baseTime.Add(time.Second), Status: service.UNHEALTHY,
+			})
+
+			_, _, envelope, _ = get(fmt.Sprintf("/services?since=%d", version), "")
+			So(envelope.Meta.Total, ShouldEqual, 3)
+			So(envelope.Meta.Version, ShouldBeGreaterThan, version)
+			for _, item := range items(envelope) {
+				So(item["Hostname"], ShouldEqual, "host0")
+			}
+
+			status, _, _, _ := get("/services?since=yesterday", "")
+			So(status, ShouldEqual, 400)
+		})
+
+		Convey("includes the conditions of each service", func() {
+			_, _, envelope, _ := get("/services?fields=ID,Conditions", "")
+
+			conditions := make(map[string]map[string]interface{})
+			for _, condition := range items(envelope)[0]["Conditions"].([]interface{}) {
+				condition := condition.(map[string]interface{})
+				conditions[condition["Type"].(string)] = condition
+			}
+
+			So(conditions, ShouldHaveLength, 4)
+			So(conditions[catalog.ConditionReady]["Status"], ShouldEqual, catalog.ConditionTrue)
+			So(conditions[catalog.ConditionReady]["LastTransitionTime"], ShouldNotBeEmpty)
+			So(conditions[catalog.ConditionDraining]["Status"], ShouldEqual, catalog.ConditionFalse)
+			So(conditions[catalog.ConditionProxyPublished]["Status"], ShouldEqual, catalog.ConditionUnknown)
+			So(conditions[catalog.ConditionProxyPublished]["Reason"], ShouldEqual, "NoProxies")
+
+			_, _, envelope, _ = get("/services?fields=ID", "")
+			So(items(envelope)[0], ShouldNotContainKey, "Conditions")
+		})
+
+		Convey("reads the proxies once for the whole list", func() {
+			tmpDir, err := ioutil.TempDir("", "sidecar-v2-conditions")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(tmpDir) })
+
+			configFile := filepath.Join(tmpDir, "haproxy.cfg")
+			api.haproxy = haproxy.New(configFile, "/dev/null")
+			So(ioutil.WriteFile(configFile, []byte("\tserver host0-deadbeef000 127.0.0.1:31234\n"), 0644), ShouldBeNil)
+
+			proxies := api.loadProxyView()
+			So(proxies.haproxyErr, ShouldBeNil)
+
+			// Later changes to the config don't show up in the same request
+			So(os.Remove(configFile), ShouldBeNil)
+			svc := service.Service{
+				ID:       "deadbeef000",
+				Hostname: "host0",
+				Ports:    []service.Port{{IP: "127.0.0.1", Port: 31234, ServicePort: 10100}},
+			}
+			condition := api.proxyCondition(&svc, proxies)
+			So(condition.Status, ShouldEqual, catalog.ConditionTrue)
+
+			_, _, envelope, _ := get("/services?fields=ID,Conditions", "")
+			for _, item := range items(envelope) {
+				for _, condition := range item["Conditions"].([]interface{}) {
+					condition := condition.(map[string]interface{})
+					if condition["Type"] == catalog.ConditionProxyPublished {
+						So(condition["Reason"], ShouldEqual, "CheckFailed")
+					}
+				}
+			}
+
+			Convey("and not at all when the conditions aren't wanted", func() {
+				So(api.conditionsProxyView(fieldSet{"id": true}), ShouldBeNil)
+				So(api.conditionsProxyView(nil), ShouldNotBeNil)
+			})
+		})
+
+		Convey("remembers when the proxies' status changed", func() {
+			var transitions proxyTransitions
+
+			So(transitions.observe("deadbeef001", catalog.ConditionFalse, baseTime), ShouldEqual, baseTime)
+			later := baseTime.Add(time.Minute)
+			So(transitions.observe("deadbeef001", catalog.ConditionFalse, later), ShouldEqual, baseTime)
+			So(transitions.observe("deadbeef001", catalog.ConditionTrue, later), ShouldEqual, later)
+
+			// Forgets services nobody asks about
+			muchLater := later.Add(2 * ProxyTransitionTTL)
+			transitions.observe("deadbeef002", catalog.ConditionTrue, muchLater)
+			So(transitions.seen, ShouldNotContainKey, "deadbeef001")
+		})
+
+		Convey("returns one service with its health", func() {
+			status, _, envelope, _ := get("/services/chaucer", "")
+
+			So(status, ShouldEqual, 200)
+			data := envelope.Data.(map[string]interface{})
+			So(data["Name"], ShouldEqual, "chaucer")
+			So(len(data["Instances"].([]interface{})), ShouldEqual, 2)
+			So(data["Health"].(map[string]interface{})["Alive"], ShouldEqual, 2)
+		})
+
+		Convey("returns the state version", func() {
+			_, _, envelope, _ := get("/state/version", "")
+			So(envelope.Data.(map[string]interface{})["Version"], ShouldEqual, state.Version())
+		})
+
+		Convey("wraps errors in the envelope", func() {
+			status, _, envelope, raw := get("/services/petrarch", "")
+			So(status, ShouldEqual, 404)
+			So(envelope.Error.Code, ShouldEqual, "not_found")
+			So(raw, ShouldNotContainKey, "Data")
+
+			status, _, envelope, _ = get("/services?limit=zero", "")
+			So(status, ShouldEqual, 400)
+			So(envelope.Error.Message, ShouldContainSubstring, "limit")
+
+			status, _, envelope, _ = get("/services?cursor=!!!", "")
+			So(status, ShouldEqual, 400)
+
+			req := httptest.NewRequest("POST", "/services", nil)
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, req)
+			So(recorder.Code, ShouldEqual, 405)
+			So(recorder.Body.String(), ShouldContainSubstring, "method_not_allowed")
+		})
+
+		Convey("negotiates the content type", func() {
+			_, contentType, _, _ := get("/services", V2ContentType)
+			So(contentType, ShouldEqual, V2ContentType)
+
+			_, contentType, _, _ = get("/services", "text/html, */*;q=0.8")
+			So(contentType, ShouldEqual, "application/json")
+
+			status, _, envelope, _ := get("/services", "text/html")
+			So(status, ShouldEqual, 406)
+			So(envelope.Error.Code, ShouldEqual, "not_acceptable")
+		})
+	})
+}
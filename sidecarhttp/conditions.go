@@ -0,0 +1,122 @@
+package sidecarhttp
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+)
+
+const (
+	// How long we remember the ProxyPublished status of a service that
+	// nobody has asked about
+	ProxyTransitionTTL = time.Hour
+)
+
+// proxyTransitions remembers when each service's ProxyPublished condition
+// last changed. Nothing else records it, so it's when we first saw the
+// status we have now.
+type proxyTransitions struct {
+	sync.Mutex
+	seen      map[string]proxyTransition // By service ID
+	lastPrune time.Time
+}
+
+type proxyTransition struct {
+	status   string
+	since    time.Time
+	lastSeen time.Time
+}
+
+// observe records the status of the service, returning when it changed to it
+func (p *proxyTransitions) observe(id string, status string, now time.Time) time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.seen == nil {
+		p.seen = make(map[string]proxyTransition)
+	}
+
+	if now.Sub(p.lastPrune) > ProxyTransitionTTL {
+		for seenID, seen := range p.seen {
+			if now.Sub(seen.lastSeen) > ProxyTransitionTTL {
+				delete(p.seen, seenID)
+			}
+		}
+		p.lastPrune = now
+	}
+
+	transition, ok := p.seen[id]
+	if !ok || transition.status != status {
+		transition = proxyTransition{status: status, since: now}
+	}
+	transition.lastSeen = now
+	p.seen[id] = transition
+
+	return transition.since
+}
+
+// serviceConditions returns the catalog's conditions for the service, and
+// whether this node's proxies have published it
+func (s *SidecarApi) serviceConditions(svc *service.Service, proxies *proxyView) []catalog.ServiceCondition {
+	s.state.RLock()
+	conditions := s.state.ServiceConditions(svc)
+	s.state.RUnlock()
+
+	return append(conditions, s.proxyCondition(svc, proxies))
+}
+
+// proxyCondition works out the ProxyPublished condition. It's only True when
+// every proxy this node manages has every ServicePort of the service.
+func (s *SidecarApi) proxyCondition(svc *service.Service, proxies *proxyView) catalog.ServiceCondition {
+	condition := catalog.ServiceCondition{Type: catalog.ConditionProxyPublished}
+
+	var servicePorts []service.Port
+	for _, port := range svc.Ports {
+		if port.ServicePort > 0 {
+			servicePorts = append(servicePorts, port)
+		}
+	}
+
+	inHAproxy, inEnvoy, err := s.proxyPresence(svc, servicePorts, proxies)
+
+	var missing []string
+	if inHAproxy != nil && !*inHAproxy {
+		missing = append(missing, "HAproxy")
+	}
+	for clusterName, sent := range inEnvoy {
+		if !sent {
+			missing = append(missing, "Envoy cluster "+clusterName)
+		}
+	}
+	sort.Strings(missing)
+
+	switch {
+	case s.haproxy == nil && s.envoy == nil:
+		condition.Status = catalog.ConditionUnknown
+		condition.Reason = "NoProxies"
+		condition.Message = "This node doesn't manage HAproxy or Envoy"
+	case err != nil:
+		condition.Status = catalog.ConditionUnknown
+		condition.Reason = "CheckFailed"
+		condition.Message = err.Error()
+	case len(servicePorts) == 0:
+		condition.Status = catalog.ConditionFalse
+		condition.Reason = "NoServicePorts"
+		condition.Message = "The proxies only route to ServicePorts"
+	case len(missing) > 0:
+		condition.Status = catalog.ConditionFalse
+		condition.Reason = "NotPublished"
+		condition.Message = "Missing from " + strings.Join(missing, ", ")
+	default:
+		condition.Status = catalog.ConditionTrue
+		condition.Reason = "Published"
+	}
+
+	condition.LastTransitionTime = s.proxyTransitions.observe(svc.ID, condition.Status, time.Now().UTC())
+
+	return condition
+}
@@ -0,0 +1,84 @@
+package sidecarhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// A CORSConfig controls the CORS headers the APIs send, so that browsers on
+// other origins can call them. A nil CORSConfig allows GET requests from any
+// origin, which is what Sidecar has always done.
+type CORSConfig struct {
+	AllowedOrigins []string // "*" allows any origin
+	AllowedMethods []string
+	AllowedHeaders []string
+}
+
+// DefaultCORSConfig is used when there's no CORSConfig
+var DefaultCORSConfig = &CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET"},
+}
+
+// setHeaders sets the CORS headers for the request's origin. Origins that
+// aren't allowed get no Access-Control-Allow-Origin header at all, which the
+// browser treats as a refusal.
+func (c *CORSConfig) setHeaders(response http.ResponseWriter, req *http.Request) {
+	if c == nil {
+		c = DefaultCORSConfig
+	}
+
+	origin := c.allowedOrigin(req.Header.Get("Origin"))
+	if origin != "*" {
+		// The answer depends on the origin, so caches have to keep them apart
+		response.Header().Add("Vary", "Origin")
+	}
+
+	if origin == "" {
+		return
+	}
+
+	response.Header().Set("Access-Control-Allow-Origin", origin)
+	response.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+
+	if len(c.AllowedHeaders) > 0 {
+		response.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	}
+}
+
+// allowedOrigin returns what to send in Access-Control-Allow-Origin for a
+// request from the origin, or an empty string if it's not allowed
+func (c *CORSConfig) allowedOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// corsFor returns the CORS settings from the config, or nil for the defaults
+func corsFor(config *HttpConfig) *CORSConfig {
+	if config == nil {
+		return nil
+	}
+
+	return config.CORS
+}
+
+// middleware sets the CORS headers on every response from the handler
+func (c *CORSConfig) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		c.setHeaders(response, req)
+		next.ServeHTTP(response, req)
+	})
+}
+
+// optionsHandler answers CORS preflight requests. The headers themselves are
+// set by the middleware.
+func optionsHandler(response http.ResponseWriter, req *http.Request) {}
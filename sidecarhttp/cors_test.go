@@ -0,0 +1,82 @@
+package sidecarhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NinesStack/sidecar/catalog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CORS(t *testing.T) {
+	Convey("CORS headers", t, func() {
+		state := catalog.NewServicesState()
+
+		serve := func(handler http.Handler, method string, path string, origin string) http.Header {
+			req := httptest.NewRequest(method, path, nil)
+			if origin != "" {
+				req.Header.Set("Origin", origin)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			return recorder.Result().Header
+		}
+
+		cors := &CORSConfig{
+			AllowedOrigins: []string{"https://ui.example.com"},
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"Content-Type", "Authorization"},
+		}
+
+		Convey("allow GETs from anywhere by default", func() {
+			api := &SidecarApi{state: state}
+			headers := serve(api.HttpMux(), "GET", "/services.json", "https://example.com")
+
+			So(headers.Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+			So(headers.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET")
+			So(headers.Get("Access-Control-Allow-Headers"), ShouldBeEmpty)
+			So(headers.Get("Vary"), ShouldBeEmpty)
+		})
+
+		Convey("echo back an allowed origin", func() {
+			api := &SidecarApi{state: state, cors: cors}
+			headers := serve(api.HttpMux(), "GET", "/services.json", "https://ui.example.com")
+
+			So(headers.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+			So(headers.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST")
+			So(headers.Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, Authorization")
+			So(headers.Get("Vary"), ShouldEqual, "Origin")
+		})
+
+		Convey("leave out the headers for other origins", func() {
+			api := &SidecarApi{state: state, cors: cors}
+			headers := serve(api.HttpMux(), "GET", "/services.json", "https://evil.example.com")
+
+			So(headers.Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+			So(headers.Get("Access-Control-Allow-Methods"), ShouldBeEmpty)
+			So(headers.Get("Vary"), ShouldEqual, "Origin")
+		})
+
+		Convey("answer preflight requests on any path", func() {
+			api := &SidecarApi{state: state, cors: cors}
+			headers := serve(api.HttpMux(), "OPTIONS", "/services/drain", "https://ui.example.com")
+
+			So(headers.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+			So(headers.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST")
+		})
+
+		Convey("apply the same settings to the Envoy and status APIs", func() {
+			config := &HttpConfig{CORS: cors}
+
+			envoyApi := &EnvoyApi{state: state, config: config}
+			headers := serve(envoyApi.HttpMux(), "GET", "/clusters", "https://ui.example.com")
+			So(headers.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+
+			statusApi := &StatusApi{state: state, config: config}
+			headers = serve(statusApi.HttpMux(), "OPTIONS", "/info.json", "https://ui.example.com")
+			So(headers.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST")
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package sidecarhttp
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultDiagnosticsIP = "127.0.0.1"
+)
+
+// DiagnosticsConfig says where the diagnostics server listens and what it
+// serves. It's kept off the API port so that profiling isn't exposed to the
+// network.
+type DiagnosticsConfig struct {
+	ListenIP   string // Defaults to 127.0.0.1
+	ListenPort int
+
+	Pprof                bool // Serve the pprof handlers on /debug/pprof/
+	Expvar               bool // Serve expvar on /debug/vars
+	MutexProfileFraction int  // Passed to runtime.SetMutexProfileFraction, 0 leaves it off
+	BlockProfileRate     int  // Passed to runtime.SetBlockProfileRate, 0 leaves it off
+}
+
+// diagnosticsMux builds the handlers for the diagnostics server. A dump of
+// every goroutine's stack is always available on /debug/goroutines.
+func diagnosticsMux(config *DiagnosticsConfig) *http.ServeMux {
+	serveMux := http.NewServeMux()
+
+	serveMux.HandleFunc("/debug/goroutines", goroutinesHandler)
+
+	if config.Pprof {
+		serveMux.HandleFunc("/debug/pprof/", pprof.Index)
+		serveMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		serveMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		serveMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		serveMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	if config.Expvar {
+		serveMux.Handle("/debug/vars", expvar.Handler())
+	}
+
+	return serveMux
+}
+
+func goroutinesHandler(response http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	err := runtimepprof.Lookup("goroutine").WriteTo(response, 2)
+	if err != nil {
+		log.Errorf("Error writing goroutine dump to client: %s", err)
+	}
+}
+
+// ServeDiagnostics runs the diagnostics server until the context is
+// cancelled. The mutex and block profile rates are process-wide, and are
+// left as they are when they are zero.
+func ServeDiagnostics(ctx context.Context, config *DiagnosticsConfig) {
+	if config.MutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(config.MutexProfileFraction)
+	}
+	if config.BlockProfileRate > 0 {
+		runtime.SetBlockProfileRate(config.BlockProfileRate)
+	}
+
+	listenIP := config.ListenIP
+	if listenIP == "" {
+		listenIP = DefaultDiagnosticsIP
+	}
+
+	if ip := net.ParseIP(listenIP); ip == nil || !ip.IsLoopback() {
+		log.Warnf("Diagnostics server is listening on %s, which exposes profiling beyond this host", listenIP)
+	}
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(listenIP, strconv.Itoa(config.ListenPort)),
+		Handler: diagnosticsMux(config),
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warnf("Failed to shut down diagnostics server cleanly: %s", err)
+		}
+	}()
+
+	log.Infof("Starting diagnostics server on %s", server.Addr)
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("Can't start diagnostics server: %s", err)
+	}
+}
@@ -0,0 +1,49 @@
+package sidecarhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_diagnosticsMux(t *testing.T) {
+	Convey("diagnosticsMux()", t, func() {
+		get := func(config *DiagnosticsConfig, path string) (int, string) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", path, nil)
+			diagnosticsMux(config).ServeHTTP(recorder, req)
+
+			status, _, body := getResult(recorder)
+			return status, body
+		}
+
+		Convey("always dumps the goroutines", func() {
+			status, body := get(&DiagnosticsConfig{}, "/debug/goroutines")
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "goroutine")
+			So(body, ShouldContainSubstring, "Test_diagnosticsMux")
+		})
+
+		Convey("serves pprof and expvar when they are enabled", func() {
+			config := &DiagnosticsConfig{Pprof: true, Expvar: true}
+
+			status, body := get(config, "/debug/pprof/")
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "goroutine")
+
+			status, body = get(config, "/debug/vars")
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "memstats")
+		})
+
+		Convey("leaves out pprof and expvar when they are disabled", func() {
+			status, _ := get(&DiagnosticsConfig{}, "/debug/pprof/")
+			So(status, ShouldEqual, 404)
+
+			status, _ = get(&DiagnosticsConfig{}, "/debug/vars")
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
@@ -5,7 +5,6 @@ package sidecarhttp
 import (
 	"fmt"
 	"net/http"
-	_ "net/http/pprof"
 
 	"github.com/NinesStack/memberlist"
 	"github.com/NinesStack/sidecar/catalog"
@@ -97,12 +96,6 @@ type EnvoyApi struct {
 	config *HttpConfig
 }
 
-// optionsHandler sends CORS headers
-func (s *EnvoyApi) optionsHandler(response http.ResponseWriter, req *http.Request) {
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	response.Header().Set("Access-Control-Allow-Methods", "GET")
-}
-
 type SDSResult struct {
 	Env     string          `json:"env"`
 	Hosts   []*EnvoyService `json:"hosts"`
@@ -131,19 +124,15 @@ func (s *EnvoyApi) registrationHandler(response http.ResponseWriter, req *http.R
 	}
 
 	instances := make([]*EnvoyService, 0)
-	// Enter critical section
-	func() {
-		s.state.RLock()
-		defer s.state.RUnlock()
-		s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
-			if svc.Name == svcName && svc.IsAlive() {
-				newInstance := s.EnvoyServiceFromService(svc, svcPort)
-				if newInstance != nil {
-					instances = append(instances, newInstance)
-				}
+	// Look the name up in ByService() so that aliases work too
+	for _, svc := range s.state.SnapshotServices().ByService()[svcName] {
+		if svc.IsAlive() {
+			newInstance := s.EnvoyServiceFromService(svc, svcPort)
+			if newInstance != nil {
+				instances = append(instances, newInstance)
 			}
-		})
-	}()
+		}
+	}
 
 	clusterName := ""
 	if s.list != nil {
@@ -275,10 +264,7 @@ func (s *EnvoyApi) EnvoyServiceFromService(svc *service.Service, svcPort int64)
 func (s *EnvoyApi) EnvoyClustersFromState() []*EnvoyCluster {
 	clusters := make([]*EnvoyCluster, 0)
 
-	s.state.RLock()
-	defer s.state.RUnlock()
-
-	svcs := s.state.ByService()
+	svcs := s.state.SnapshotServices().ByService()
 	for svcName, endpoints := range svcs {
 		if len(endpoints) < 1 {
 			continue
@@ -324,7 +310,7 @@ func (s *EnvoyApi) EnvoyListenerFromService(svc *service.Service, port int64) *E
 		Address: fmt.Sprintf("tcp://%s:%d", s.config.BindIP, port),
 	}
 
-	if svc.ProxyMode == "http" {
+	if svc.ProxyModeFor(port) == "http" {
 		listener.Filters = []*EnvoyFilter{
 			{
 				Name: "envoy.http_connection_manager",
@@ -384,10 +370,8 @@ func (s *EnvoyApi) EnvoyListenerFromService(svc *service.Service, port int64) *E
 func (s *EnvoyApi) EnvoyListenersFromState() []*EnvoyListener {
 	listeners := make([]*EnvoyListener, 0)
 
-	s.state.RLock()
-	defer s.state.RUnlock()
-
-	svcs := s.state.ByService()
+	// Aliases share their service's ports, so they don't get listeners
+	svcs := s.state.SnapshotServices().ByServiceWithoutAliases()
 	// Loop over all the services by service name
 	for _, endpoints := range svcs {
 		if len(endpoints) < 1 {
@@ -432,7 +416,8 @@ func (s *EnvoyApi) HttpMux() http.Handler {
 	router.HandleFunc("/clusters", wrap(s.clustersHandler)).Methods("GET")
 	router.HandleFunc("/listeners/{service_cluster}/{service_node}", wrap(s.listenersHandler)).Methods("GET")
 	router.HandleFunc("/listeners", wrap(s.listenersHandler)).Methods("GET")
-	router.HandleFunc("/{path}", s.optionsHandler).Methods("OPTIONS")
+	router.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	router.Use(corsFor(s.config).middleware)
 
 	return router
 }
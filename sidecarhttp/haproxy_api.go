@@ -0,0 +1,109 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// HAproxyStatus is the payload we return describing how recent HAproxy
+// verify and reload runs have gone.
+type HAproxyStatus struct {
+	LastVerify *haproxy.ReloadResult
+	LastReload *haproxy.ReloadResult
+	Results    []haproxy.ReloadResult
+}
+
+type HAproxyApi struct {
+	proxy *haproxy.HAproxy
+	state *catalog.ServicesState
+}
+
+func (h *HAproxyApi) HttpMux() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/status.{extension}", wrap(h.statusHandler)).Methods("GET")
+	router.HandleFunc("/reload-template", wrap(h.reloadTemplateHandler)).Methods("POST")
+
+	return router
+}
+
+// statusHandler returns the history of recent verify and reload invocations
+// so that we can tell when reloads have been silently failing.
+func (h *HAproxyApi) statusHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if h.proxy == nil {
+		sendJsonError(response, 404, "Not Found - HAproxy management is disabled")
+		return
+	}
+
+	status := HAproxyStatus{
+		LastVerify: h.proxy.LastResult("verify"),
+		LastReload: h.proxy.LastResult("reload"),
+		Results:    h.proxy.Results(),
+	}
+
+	jsonBytes, err := json.MarshalIndent(&status, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling HAproxy status: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing HAproxy status response to client: %s", err)
+	}
+}
+
+// reloadTemplateHandler re-reads the HAproxy template, then rewrites the
+// config and reloads HAproxy. A template that doesn't parse is rejected
+// without touching the running config.
+func (h *HAproxyApi) reloadTemplateHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if h.proxy == nil {
+		sendJsonError(response, 404, "Not Found - HAproxy management is disabled")
+		return
+	}
+
+	if h.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	err := h.proxy.ReloadTemplate(h.state)
+	if err != nil {
+		sendJsonError(response, 500, fmt.Sprintf("Internal Server Error - %s", err))
+		return
+	}
+
+	result := struct {
+		Message string
+	}{
+		Message: "HAproxy template reloaded",
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing template reload response to client: %s", err)
+	}
+}
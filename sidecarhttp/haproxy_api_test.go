@@ -0,0 +1,110 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_HAproxyStatusHandler(t *testing.T) {
+	Convey("statusHandler", t, func() {
+		proxy := haproxy.New("/dev/null", "/dev/null")
+		proxy.VerifyCmd = "sh -c 'exit 0'"
+		proxy.ReloadCmd = "sh -c 'echo failed >&2; exit 3'"
+
+		api := &HAproxyApi{proxy: proxy}
+		params := map[string]string{"extension": "json"}
+
+		req := httptest.NewRequest("GET", "/status.json", nil)
+		recorder := httptest.NewRecorder()
+
+		Convey("returns the recent results", func() {
+			_ = proxy.Verify()
+			_ = proxy.Reload()
+
+			api.statusHandler(recorder, req, params)
+			status, headers, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var result HAproxyStatus
+			err := json.Unmarshal([]byte(body), &result)
+			So(err, ShouldBeNil)
+			So(len(result.Results), ShouldEqual, 2)
+			So(result.LastVerify.ExitStatus, ShouldEqual, 0)
+			So(result.LastReload.ExitStatus, ShouldEqual, 3)
+			So(result.LastReload.Stderr, ShouldContainSubstring, "failed")
+		})
+
+		Convey("returns a 404 when HAproxy is disabled", func() {
+			api.proxy = nil
+			api.statusHandler(recorder, req, params)
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+		})
+
+		Convey("only returns JSON", func() {
+			api.statusHandler(recorder, req, map[string]string{"extension": "asdf"})
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "Invalid content")
+		})
+	})
+}
+
+func Test_HAproxyReloadTemplateHandler(t *testing.T) {
+	Convey("reloadTemplateHandler", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-haproxy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		templateFile := filepath.Join(dir, "haproxy.tmpl")
+		So(ioutil.WriteFile(templateFile, []byte("global\n"), 0644), ShouldBeNil)
+
+		proxy := haproxy.New(filepath.Join(dir, "haproxy.cfg"), filepath.Join(dir, "haproxy.pid"))
+		proxy.Template = templateFile
+		proxy.VerifyCmd = "true"
+		proxy.ReloadCmd = "true"
+
+		api := &HAproxyApi{proxy: proxy, state: catalog.NewServicesState()}
+
+		req := httptest.NewRequest("POST", "/reload-template", nil)
+		recorder := httptest.NewRecorder()
+
+		Convey("reloads the template", func() {
+			api.reloadTemplateHandler(recorder, req, nil)
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, "reloaded")
+		})
+
+		Convey("reports a broken template", func() {
+			So(ioutil.WriteFile(templateFile, []byte("{{ broken"), 0644), ShouldBeNil)
+
+			api.reloadTemplateHandler(recorder, req, nil)
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 500)
+			So(body, ShouldContainSubstring, "not reloading")
+		})
+
+		Convey("returns a 404 when HAproxy is disabled", func() {
+			api.proxy = nil
+			api.reloadTemplateHandler(recorder, req, nil)
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
@@ -1,19 +1,93 @@
 package sidecarhttp
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/NinesStack/memberlist"
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/envoy"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/healthy"
+	"github.com/NinesStack/sidecar/ui"
+	"github.com/NinesStack/sidecar/views"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	ShutdownTimeout    = 5 * time.Second // How long we wait for open requests on shutdown
+	DefaultListenIP    = "0.0.0.0"
+	DefaultPort        = 7777
+	RemoteDrainTimeout = 10 * time.Second // How long we wait on other members to drain services
+
+	DefaultAnnotationTTL = 24 * time.Hour // How long annotations last when the client doesn't say
+	MaxAnnotationBytes   = 4096           // The biggest annotation request we'll read
+
+	DefaultCheckDisableTTL = 1 * time.Hour // How long checks stay disabled when the client doesn't say
 )
 
 type HttpConfig struct {
 	BindIP       string
 	UseHostnames bool
+	HAproxy      *haproxy.HAproxy // nil when we're not managing HAproxy
+
+	// Where the web UI and API listen. Defaults to 0.0.0.0:7777.
+	ListenIP   string
+	ListenPort int
+
+	// Server tuning. Zero values leave the Go defaults in place, which means
+	// no timeouts at all. Note that ReadTimeout and WriteTimeout apply to the
+	// whole connection, so they will cut off long-running /watch clients.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// Serve HTTP/2 without TLS, so streaming clients can multiplex
+	EnableH2C bool
+
+	// Log every request, and/or the ones slower than the threshold. A zero
+	// threshold disables slow request logging.
+	AccessLog            bool
+	SlowRequestThreshold time.Duration
+
+	// Reported by the status endpoint
+	Build             BuildInfo
+	Started           time.Time
+	ConfigFingerprint string
+	Discovery         []string
+	DiscoveryStatus   discovery.StatusReporter // How each discovery backend is doing, may be nil
+	Envoy             *envoy.Server            // nil when the Envoy API is disabled
+	Cluster           *cluster.Reporter        // Where member events come from, may be nil
+	Gossip            *cluster.GossipTuner     // The gossip settings in effect, may be nil
+	Monitor           *healthy.Monitor         // The local health checks, may be nil
+
+	// Which origins, methods, and headers browsers may use. nil for the defaults.
+	CORS *CORSConfig
+
+	// Leave tombstones out of service API responses unless the client asks
+	// for them with the "status" parameter
+	HideTombstones bool
+
+	// Hands out ServicePorts from the pool, nil when there isn't one
+	PortAllocator *discovery.PortAllocator
+
+	// Serve the web UI and the static files from these directories, rather
+	// than the copies compiled into the binary. Empty for the compiled ones.
+	UIDir     string
+	StaticDir string
 }
 
 func makeHandler(fn func(http.ResponseWriter, *http.Request,
@@ -29,15 +103,13 @@ func serversHandler(response http.ResponseWriter, req *http.Request, list *membe
 	defer req.Body.Close()
 
 	response.Header().Set("Content-Type", "text/html")
-	state.RLock()
-	defer state.RUnlock()
 
 	_, err := response.Write(
 		[]byte(`
  			<head>
  			<meta http-equiv="refresh" content="4">
  			</head>
-	    	<pre>` + state.Format(list) + "</pre>"))
+	    	<pre>` + state.SnapshotServices().Format(list) + "</pre>"))
 
 	if err != nil {
 		log.Errorf("Error writing servers response to client: %s", err)
@@ -53,32 +125,121 @@ func uiRedirectHandler(response http.ResponseWriter, req *http.Request) {
 	http.Redirect(response, req, "/ui/", 301)
 }
 
-func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, config *HttpConfig) {
+// fileServer serves the files in the directory when there is one, or else
+// the ones compiled into the binary
+func fileServer(dir string, compiled fs.FS) http.Handler {
+	if dir != "" {
+		return http.FileServer(http.Dir(dir))
+	}
+
+	return http.FileServer(http.FS(compiled))
+}
+
+// ValidateFileDir makes sure that a directory we've been asked to serve the
+// UI or static files from is there. Intended to be called at startup, so
+// that we fail fast rather than serve 404s. Empty is fine, for the files
+// compiled into the binary.
+func ValidateFileDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("unable to serve files from %s: %w", dir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("unable to serve files from %s: not a directory", dir)
+	}
+
+	return nil
+}
+
+// ServeHttp runs the Sidecar web UI and API until the context is cancelled
+func ServeHttp(ctx context.Context, list *memberlist.Memberlist, state *catalog.ServicesState, config *HttpConfig) {
 	srvrsHandle := makeHandler(serversHandler, list, state)
-	staticFs := http.FileServer(http.Dir("views/static"))
-	uiFs := http.FileServer(http.Dir("ui/app"))
+	staticFs := fileServer(config.StaticDir, views.StaticFiles())
+	uiFs := fileServer(config.UIDir, ui.Files())
 
-	api := &SidecarApi{state: state, list: list}
+	api := &SidecarApi{
+		state: state, list: list, port: config.ListenPort, cors: config.CORS, reporter: config.Cluster,
+		hideTombstones: config.HideTombstones, allocator: config.PortAllocator,
+		haproxy: config.HAproxy, envoy: config.Envoy, monitor: config.Monitor,
+	}
 	envoyApi := &EnvoyApi{state: state, list: list, config: config}
+	haproxyApi := &HAproxyApi{proxy: config.HAproxy, state: state}
+	statusApi := &StatusApi{list: list, state: state, config: config}
+	adminApi := newAdminApi(list, state)
+	prometheusApi := &PrometheusApi{state: state}
 
 	router := mux.NewRouter()
 	router.HandleFunc("/", uiRedirectHandler).Methods("GET")
 	router.HandleFunc("/servers", srvrsHandle).Methods("GET")
 	router.PathPrefix("/static").Handler(http.StripPrefix("/static", staticFs))
 	router.PathPrefix("/ui").Handler(http.StripPrefix("/ui", uiFs))
+	router.PathPrefix("/api/v2").Handler(http.StripPrefix("/api/v2", api.V2HttpMux()))
 	router.PathPrefix("/api").Handler(http.StripPrefix("/api", api.HttpMux()))
 	router.PathPrefix("/v1").Handler(http.StripPrefix("/v1", envoyApi.HttpMux()))
+	router.PathPrefix("/haproxy").Handler(http.StripPrefix("/haproxy", haproxyApi.HttpMux()))
+	router.PathPrefix("/status").Handler(http.StripPrefix("/status", statusApi.HttpMux()))
+	router.PathPrefix("/admin").Handler(http.StripPrefix("/admin", adminApi.HttpMux()))
+	router.PathPrefix("/prometheus").Handler(http.StripPrefix("/prometheus", prometheusApi.HttpMux()))
 
 	// DEPRECATED - to be removed once common clients are updated
-	router.HandleFunc("/services.{extension}", wrap(api.servicesHandler)).Methods("GET")
-	router.HandleFunc("/state.{extension}", wrap(api.stateHandler)).Methods("GET")
-	router.HandleFunc("/watch", wrap(api.watchHandler)).Methods("GET")
+	router.Handle("/services.{extension}", config.CORS.middleware(wrap(api.servicesHandler))).Methods("GET")
+	router.Handle("/state.{extension}", config.CORS.middleware(wrap(api.stateHandler))).Methods("GET")
+	router.Handle("/watch", config.CORS.middleware(wrap(api.watchHandler))).Methods("GET")
 	// ------------------------------------------------------------
 
-	http.Handle("/", router)
+	// Profiling lives on the diagnostics server, see ServeDiagnostics()
+	server := newServer(newAccessLogger(router, state, config), config)
 
-	err := http.ListenAndServe("0.0.0.0:7777", nil)
-	if err != nil {
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warnf("Failed to shut down HTTP server cleanly: %s", err)
+		}
+	}()
+
+	log.Infof("Starting HTTP server on %s", server.Addr)
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Can't start HTTP server: %s", err)
 	}
 }
+
+// newServer builds an http.Server for the handler from the config
+func newServer(handler http.Handler, config *HttpConfig) *http.Server {
+	listenIP := config.ListenIP
+	if listenIP == "" {
+		listenIP = DefaultListenIP
+	}
+
+	listenPort := config.ListenPort
+	if listenPort == 0 {
+		listenPort = DefaultPort
+	}
+
+	if config.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{
+			IdleTimeout: config.IdleTimeout,
+		})
+	}
+
+	return &http.Server{
+		Addr:              net.JoinHostPort(listenIP, strconv.Itoa(listenPort)),
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+}
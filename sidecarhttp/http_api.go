@@ -1,15 +1,28 @@
 package sidecarhttp
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	neturl "net/url"
 	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/NinesStack/memberlist"
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/envoy"
+	"github.com/NinesStack/sidecar/envoy/adapter"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/healthy"
 	"github.com/NinesStack/sidecar/service"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -19,44 +32,156 @@ type ApiServer struct {
 	Name         string
 	LastUpdated  time.Time
 	ServiceCount int
+	ClockSkewMs  *int64            `json:",omitempty"` // Estimated, nil when we don't know
+	Version      string            `json:",omitempty"` // The Sidecar version the member advertises
+	Labels       map[string]string `json:",omitempty"` // The node labels the member advertises
+}
+
+type ApiMembers struct {
+	ClusterMembers map[string]*ApiServer
+	ClusterName    string
+	Events         []cluster.MemberEvent `json:",omitempty"` // Only when asked for
 }
 
 type ApiServices struct {
 	Services       map[string][]*service.Service
 	ClusterMembers map[string]*ApiServer `json:",omitempty"`
 	ClusterName    string
+	History        map[string][]catalog.StatusTransition `json:",omitempty"` // By service ID
+	Health         map[string]*catalog.ServiceHealth     `json:",omitempty"` // By service name
+}
+
+type ApiStateVersion struct {
+	Version     uint64
+	LastChanged time.Time
+	Servers     map[string]uint64 // The version at which each server last changed
+	Changed     *bool             `json:",omitempty"` // Only set when "since" was passed
+}
+
+// ApiServiceInspection is everything we know about one service instance
+// that bears on whether it gets traffic
+type ApiServiceInspection struct {
+	Service           *service.Service
+	Hostname          string                           // The host that owns it
+	Local             bool                             // Whether that's this node
+	Member            bool                             // Whether the host is a live cluster member
+	Servable          bool                             // Whether the proxies would send it traffic
+	LastCheck         *service.CheckInfo               `json:",omitempty"` // nil when nothing has checked it
+	ServerLastUpdated time.Time                        // When we last heard from its host
+	LastBroadcast     *time.Time                       `json:",omitempty"` // When we last gossiped it, nil if we haven't
+	Listeners         map[string]catalog.ListenerStats `json:",omitempty"` // Delivery counts of every listener on this node
+	HAproxy           *bool                            `json:",omitempty"` // Whether our HAproxy config has it, nil when not managed
+	Envoy             map[string]bool                  `json:",omitempty"` // Whether we sent it to Envoy, by cluster, nil when the API is off
+	Problems          []string                         `json:",omitempty"` // Why it might not be getting traffic
+}
+
+type ApiDrainResult struct {
+	Selector string
+	Hosts    map[string]*ApiHostDrainResult
+}
+
+type ApiHostDrainResult struct {
+	Drained   []string `json:",omitempty"` // Service IDs
+	Undrained []string `json:",omitempty"` // Service IDs
+	Error     string   `json:",omitempty"`
 }
 
 type SidecarApi struct {
 	list  *memberlist.Memberlist
 	state *catalog.ServicesState
+	port  int         // Where the API listens on the other cluster members
+	cors  *CORSConfig // nil for the defaults
+
+	reporter *cluster.Reporter // nil when we don't keep member events
+
+	hideTombstones bool // Leave out tombstones unless "status" asks for them
+
+	allocator *discovery.PortAllocator // nil when there's no ServicePort pool
+
+	haproxy *haproxy.HAproxy // nil when HAproxy isn't managed
+	envoy   *envoy.Server    // nil when the Envoy API is off
+
+	monitor *healthy.Monitor // The local health checks, nil when there are none
+
+	rollingDrains    rollingDrains
+	proxyTransitions proxyTransitions
 }
 
 func (s *SidecarApi) HttpMux() http.Handler {
 	router := mux.NewRouter()
+	router.HandleFunc("/services/id/{id}.{extension}", wrap(s.inspectServiceHandler)).Methods("GET")
 	router.HandleFunc("/services/{name}.{extension}", wrap(s.oneServiceHandler)).Methods("GET")
+	router.HandleFunc("/services/drain", wrap(s.drainSelectedHandler)).Methods("POST")
 	router.HandleFunc("/services/{id}/drain", wrap(s.drainServiceHandler)).Methods("POST")
+	router.HandleFunc("/services/undrain", wrap(s.undrainSelectedHandler)).Methods("POST")
+	router.HandleFunc("/services/{id}/undrain", wrap(s.undrainServiceHandler)).Methods("POST")
+	router.HandleFunc("/rolling-drain", wrap(s.rollingDrainHandler)).Methods("POST")
+	router.HandleFunc("/rolling-drain.{extension}", wrap(s.rollingDrainStatusHandler)).Methods("GET")
+	router.HandleFunc("/services/{id}/annotate", wrap(s.annotateServiceHandler)).Methods("POST")
 	router.HandleFunc("/services.{extension}", wrap(s.servicesHandler)).Methods("GET")
 	router.HandleFunc("/state.{extension}", wrap(s.stateHandler)).Methods("GET")
+	router.HandleFunc("/state/version", wrap(s.stateVersionHandler)).Methods("GET")
+	router.HandleFunc("/state/graph.{extension}", wrap(s.stateGraphHandler)).Methods("GET")
+	router.HandleFunc("/state/export", wrap(s.stateExportHandler)).Methods("GET")
+	router.HandleFunc("/state/import", wrap(s.stateImportHandler)).Methods("POST")
+	router.HandleFunc("/service_ports.{extension}", wrap(s.servicePortsHandler)).Methods("GET")
+	router.HandleFunc("/lifetimes.{extension}", wrap(s.lifetimesHandler)).Methods("GET")
+	router.HandleFunc("/members.{extension}", wrap(s.membersHandler)).Methods("GET")
+	router.HandleFunc("/servers/renames.{extension}", wrap(s.hostRenamesHandler)).Methods("GET")
+	router.HandleFunc("/servers/{hostname}/migrate", wrap(s.migrateServerHandler)).Methods("POST")
+	router.HandleFunc("/checks.{extension}", wrap(s.checksHandler)).Methods("GET")
+	router.HandleFunc("/checks/{id}/disable", wrap(s.disableCheckHandler)).Methods("POST")
+	router.HandleFunc("/checks/{id}/enable", wrap(s.enableCheckHandler)).Methods("POST")
 	router.HandleFunc("/watch", wrap(s.watchHandler)).Methods("GET")
-	router.HandleFunc("/{path}", s.optionsHandler).Methods("OPTIONS")
+	router.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	router.Use(s.cors.middleware)
 
 	return router
 }
 
-// optionsHandler sends CORS headers
-func (s *SidecarApi) optionsHandler(response http.ResponseWriter, req *http.Request) {
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	response.Header().Set("Access-Control-Allow-Methods", "GET")
-}
-
 // watchHandler takes an optional GET parameter, "by_service"
 // By default, watchHandler returns `json.Marshal(state.ByService())` payloads
 // If the client passes "by_service=false", watchHandler returns `json.Marshal(state)` payloads
+// If the client passes "versioned=true", each payload is wrapped in an object
+// along with the state version. If the client passes "since=<version>" and
+// nothing has changed since then, the first payload is skipped so that
+// reconnecting clients don't get a full resend. By default the payloads are
+// written back to back with nothing between them. Passing "format=ndjson"
+// ends each one with a newline, and "format=proto" sends length-prefixed
+// protobuf messages as described in watch.proto, which always carry the
+// version. Like the other service endpoints, it takes a "status" parameter
+// to pick the services by status, e.g. "alive,draining" or "all".
 func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	response.Header().Set("Content-Type", "application/json")
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = WatchFormatJSON
+	}
+
+	contentType, ok := watchContentTypes[format]
+	if !ok {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid format %q", format))
+		return
+	}
+	response.Header().Set("Content-Type", contentType)
+
+	filter, err := parseStatusFilter(req.URL.Query().Get("status"), s.hideTombstones)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	var since uint64
+	sinceStr := req.URL.Query().Get("since")
+	if sinceStr != "" {
+		var err error
+		since, err = strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid version %q", sinceStr))
+			return
+		}
+	}
 
 	listener := NewHttpListener()
 
@@ -75,27 +200,51 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 		byService = false
 	}
 
+	versioned := req.URL.Query().Get("versioned") == "true"
+
+	// Sent as a header too, so that unversioned clients can still resume
+	response.Header().Set("X-Sidecar-State-Version", strconv.FormatUint(s.state.Version(), 10))
+
 	pushUpdate := func() error {
-		var jsonBytes []byte
-		if byService {
-			s.state.RLock()
-			var err error
-			jsonBytes, err = json.Marshal(s.state.ByService())
-			s.state.RUnlock()
+		// We read the version before the state, so the payload is never
+		// older than the version we report with it
+		version := s.state.Version()
 
+		var payload []byte
+		state := s.state.SnapshotServices()
+		filter.apply(state)
+		if format == WatchFormatProto {
+			payload = encodeWatchProto(version, state, byService)
+		} else if byService {
+			var err error
+			payload, err = json.Marshal(state.ByService())
 			if err != nil {
 				return err
 			}
 		} else {
-			s.state.RLock()
-			jsonBytes = s.state.Encode()
-			s.state.RUnlock()
+			payload = state.Encode()
+		}
+
+		if versioned && format != WatchFormatProto {
+			var err error
+			payload, err = json.Marshal(struct {
+				Version uint64
+				State   json.RawMessage
+			}{version, payload})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if format == WatchFormatNDJSON {
+			payload = append(payload, '\n')
 		}
 
 		// In order to flush immediately, we have to cast to a Flusher.
 		// The normal HTTP library supports this but not all do, so we
 		// check just in case.
-		_, err := response.Write(jsonBytes)
+		_, err := response.Write(payload)
 		if err != nil {
 			log.Errorf("Unable to write watchHandler response: %s", err)
 		}
@@ -106,11 +255,16 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 		return nil
 	}
 
-	// Push the first update right away
-	err := pushUpdate()
-	if err != nil {
-		log.Errorf("Error marshaling state in watchHandler: %s", err.Error())
-		return
+	// Push the first update right away, unless the client already has it
+	if sinceStr == "" || s.state.Version() != since {
+		err = pushUpdate()
+		if err != nil {
+			log.Errorf("Error marshaling state in watchHandler: %s", err.Error())
+			return
+		}
+	} else if f, ok := response.(http.Flusher); ok {
+		// Send the headers, so the client knows we're listening
+		f.Flush()
 	}
 
 	// Watch for further updates on the channel
@@ -131,12 +285,10 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 }
 
 // oneServiceHandler takes the name of a single service and returns results for just
-// that service.
+// that service. Takes the same "status" parameter as servicesHandler.
 func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	response.Header().Set("Access-Control-Allow-Methods", "GET")
 	response.Header().Set("Content-Type", "application/json")
 
 	if params["extension"] != "json" {
@@ -155,15 +307,17 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 		return
 	}
 
-	var instances []*service.Service
-	// Enter critical section
-	s.state.RLock()
-	defer s.state.RUnlock()
-	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
-		if svc.Name == name {
-			instances = append(instances, svc)
-		}
-	})
+	filter, err := parseStatusFilter(req.URL.Query().Get("status"), s.hideTombstones)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	state := s.state.SnapshotServices()
+	filter.apply(state)
+
+	// The name may also be an alias for another service
+	instances := state.ByService()[name]
 
 	// Did we have any entries for this service in the catalog?
 	if len(instances) == 0 {
@@ -183,6 +337,16 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 	result := ApiServices{
 		Services:    svcInstances,
 		ClusterName: clusterName,
+		Health:      catalog.SummarizeHealth(svcInstances),
+	}
+
+	if req.URL.Query().Get("history") == "true" {
+		result.History = make(map[string][]catalog.StatusTransition, len(instances))
+		s.state.RLock()
+		for _, svc := range instances {
+			result.History[svc.ID] = s.state.ServiceHistory(svc.ID)
+		}
+		s.state.RUnlock()
 	}
 
 	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
@@ -198,62 +362,213 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 	}
 }
 
-// serviceHandler returns the results for all the services we know about
-func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+// inspectServiceHandler returns everything we know about a single service
+// instance, by ID, to help work out why it isn't getting traffic
+func (s *SidecarApi) inspectServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	response.Header().Set("Access-Control-Allow-Methods", "GET")
+	response.Header().Set("Content-Type", "application/json")
 
-	// We only support JSON
 	if params["extension"] != "json" {
 		sendJsonError(response, 404, "Not Found - Invalid content type extension")
 		return
 	}
 
-	response.Header().Set("Content-Type", "application/json")
+	serviceID, ok := params["id"]
+	if !ok {
+		sendJsonError(response, 404, "Not Found - No service ID provided")
+		return
+	}
 
-	var listMembers []*memberlist.Node
-	var clusterName string
-	if s.list != nil {
-		listMembers = s.list.Members()
-		sort.Sort(catalog.ListByName(listMembers))
-		clusterName = s.list.ClusterName()
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
 	}
 
-	members := make(map[string]*ApiServer, len(listMembers))
+	svc, err := s.state.GetServiceByID(serviceID)
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", serviceID))
+		return
+	}
+
+	result := s.inspectService(&svc, s.loadProxyView())
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling service in inspectServiceHandler: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing service inspection response to client: %s", err)
+	}
+}
+
+// inspectService gathers what the state and the proxies say about the
+// service, and lists anything that would keep traffic from it
+func (s *SidecarApi) inspectService(svc *service.Service, proxies *proxyView) ApiServiceInspection {
+	result := ApiServiceInspection{
+		Service:   svc,
+		Hostname:  svc.Hostname,
+		Member:    true,
+		LastCheck: svc.LastCheck,
+		Listeners: s.state.AllListenerStats(),
+	}
+
+	var problems []string
+
+	s.state.RLock()
+	result.Servable = s.state.IsServable(svc)
+	result.Local = svc.Hostname == s.state.Hostname
+	if server, ok := s.state.Servers[svc.Hostname]; ok {
+		result.ServerLastUpdated = server.LastUpdated
+	}
+	s.state.RUnlock()
+
+	if s.state.Members != nil && !result.Local {
+		result.Member = false
+		for _, member := range s.state.Members() {
+			if member == svc.Hostname {
+				result.Member = true
+				break
+			}
+		}
+	}
+	if !result.Member {
+		problems = append(problems, fmt.Sprintf("Host %s is not a cluster member", svc.Hostname))
+	}
+
+	if broadcast, ok := s.state.LastBroadcast(svc.ID); ok {
+		result.LastBroadcast = &broadcast
+	}
+
+	switch {
+	case !svc.IsAlive():
+		problems = append(problems, fmt.Sprintf("Status is %s", svc.StatusString()))
+	case !result.Servable:
+		problems = append(problems, "The last health check is too old for it to be served")
+	}
+
+	var servicePorts []service.Port
+	for _, port := range svc.Ports {
+		if port.ServicePort > 0 {
+			servicePorts = append(servicePorts, port)
+		}
+	}
+	if len(servicePorts) == 0 {
+		problems = append(problems, "It has no ServicePorts, so the proxies don't route to it")
+	}
 
-	var jsonBytes []byte
 	var err error
+	result.HAproxy, result.Envoy, err = s.proxyPresence(svc, servicePorts, proxies)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("Unable to check the HAproxy config: %s", err))
+	}
+	if result.HAproxy != nil && !*result.HAproxy && len(servicePorts) > 0 {
+		problems = append(problems, "It is not in the HAproxy config")
+	}
+	for _, port := range servicePorts {
+		clusterName := adapter.SvcName(svc.Name, port.ServicePort)
+		if result.Envoy != nil && !result.Envoy[clusterName] {
+			address := s.envoy.EndpointFor(svc, port)
+			problems = append(problems, fmt.Sprintf("Envoy wasn't sent %s for %s", address, clusterName))
+		}
+	}
 
-	func() { // Wrap critical section
-		s.state.RLock()
-		defer s.state.RUnlock()
-
-		for _, member := range listMembers {
-			if s.state.HasServer(member.Name) {
-				members[member.Name] = &ApiServer{
-					Name:         member.Name,
-					LastUpdated:  s.state.Servers[member.Name].LastUpdated,
-					ServiceCount: len(s.state.Servers[member.Name].Services),
-				}
-			} else {
-				members[member.Name] = &ApiServer{
-					Name:         member.Name,
-					LastUpdated:  time.Unix(0, 0),
-					ServiceCount: 0,
+	result.Problems = problems
+
+	return result
+}
+
+// proxyView is what this node's proxies were last given. We load it once
+// per request, so that looking at many services doesn't read the HAproxy
+// config, under its write lock, for each one.
+type proxyView struct {
+	haproxyConfig  []byte
+	haproxyErr     error
+	envoyEndpoints map[string][]string // By cluster name, nil when the API is off
+}
+
+// loadProxyView reads what the proxies this node manages were last given
+func (s *SidecarApi) loadProxyView() *proxyView {
+	view := &proxyView{}
+	if s.haproxy != nil {
+		view.haproxyConfig, view.haproxyErr = s.haproxy.ReadConfig()
+	}
+	if s.envoy != nil {
+		view.envoyEndpoints = s.envoy.AllEndpoints()
+	}
+
+	return view
+}
+
+// proxyPresence tells whether this node's proxies have the service. The
+// HAproxy result is nil when it isn't managed, or we couldn't read its
+// config, and the Envoy one, by cluster, is nil when the API is off.
+func (s *SidecarApi) proxyPresence(svc *service.Service, servicePorts []service.Port, proxies *proxyView) (*bool, map[string]bool, error) {
+	var inHAproxy *bool
+	if s.haproxy != nil && proxies.haproxyErr == nil {
+		present := haproxy.HasServer(proxies.haproxyConfig, svc)
+		inHAproxy = &present
+	}
+
+	var inEnvoy map[string]bool
+	if s.envoy != nil {
+		inEnvoy = make(map[string]bool, len(servicePorts))
+		for _, port := range servicePorts {
+			clusterName := adapter.SvcName(svc.Name, port.ServicePort)
+			address := s.envoy.EndpointFor(svc, port)
+
+			inEnvoy[clusterName] = false
+			for _, endpoint := range proxies.envoyEndpoints[clusterName] {
+				if endpoint == address {
+					inEnvoy[clusterName] = true
+					break
 				}
 			}
 		}
+	}
 
-		result := ApiServices{
-			Services:       s.state.ByService(),
-			ClusterMembers: members,
-			ClusterName:    clusterName,
-		}
+	return inHAproxy, inEnvoy, proxies.haproxyErr
+}
 
-		jsonBytes, err = json.MarshalIndent(&result, "", "  ")
-	}()
+// serviceHandler returns the results for all the services we know about.
+// Takes an optional GET parameter, "status", a csv list of the statuses to
+// include, e.g. "alive,draining", or "all". Without it, tombstones are only
+// included when they aren't hidden by default.
+func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	// We only support JSON
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	filter, err := parseStatusFilter(req.URL.Query().Get("status"), s.hideTombstones)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	listMembers, clusterName := s.listMembers()
+	skews := s.state.ClockSkews()
+
+	state := s.state.SnapshotServices()
+	filter.apply(state)
+	services := state.ByService()
+	result := ApiServices{
+		Services:       services,
+		ClusterMembers: s.clusterMembers(state, listMembers, skews),
+		ClusterName:    clusterName,
+		Health:         catalog.SummarizeHealth(services),
+	}
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
 
 	if err != nil {
 		log.Errorf("Error marshaling state in servicesHandler: %s", err.Error())
@@ -267,78 +582,907 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 	}
 }
 
+// membersHandler returns the cluster members along with what we know about
+// them, including the estimated skew of their clocks. Passing "events=true"
+// adds the recent member joins, leaves, and updates.
+func (s *SidecarApi) membersHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	listMembers, clusterName := s.listMembers()
+	skews := s.state.ClockSkews()
+
+	result := ApiMembers{
+		ClusterMembers: s.clusterMembers(s.state.SnapshotServices(), listMembers, skews),
+		ClusterName:    clusterName,
+	}
+
+	if req.URL.Query().Get("events") == "true" && s.reporter != nil {
+		result.Events = s.reporter.Events()
+	}
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+
+	if err != nil {
+		log.Errorf("Error marshaling state in membersHandler: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing members response to client: %s", err)
+	}
+}
+
+// listMembers returns the Memberlist members, sorted, and the cluster name
+func (s *SidecarApi) listMembers() ([]*memberlist.Node, string) {
+	if s.list == nil {
+		return nil, ""
+	}
+
+	listMembers := s.list.Members()
+	sort.Sort(catalog.ListByName(listMembers))
+
+	return listMembers, s.list.ClusterName()
+}
+
+// clusterMembers combines the Memberlist members with what the state knows
+// about them. Expects a snapshot, or the caller to hold the state lock.
+func (s *SidecarApi) clusterMembers(state *catalog.ServicesState, listMembers []*memberlist.Node,
+	skews map[string]time.Duration) map[string]*ApiServer {
+
+	members := make(map[string]*ApiServer, len(listMembers))
+
+	for _, member := range listMembers {
+		if state.HasServer(member.Name) {
+			members[member.Name] = &ApiServer{
+				Name:         member.Name,
+				LastUpdated:  state.Servers[member.Name].LastUpdated,
+				ServiceCount: len(state.Servers[member.Name].Services),
+			}
+		} else {
+			members[member.Name] = &ApiServer{
+				Name:         member.Name,
+				LastUpdated:  time.Unix(0, 0),
+				ServiceCount: 0,
+			}
+		}
+
+		if skew, ok := skews[member.Name]; ok {
+			skewMs := int64(skew / time.Millisecond)
+			members[member.Name].ClockSkewMs = &skewMs
+		}
+
+		meta := parseMemberMetadata(member)
+		members[member.Name].Version = meta.Version
+		members[member.Name].Labels = meta.Labels
+	}
+
+	return members
+}
+
+// The parts of a member's metadata that we report
+type memberMetadata struct {
+	Version string
+	Labels  map[string]string
+}
+
+// parseMemberMetadata returns the Sidecar version and node labels a member
+// advertises in its metadata. Older members may have neither.
+func parseMemberMetadata(member *memberlist.Node) memberMetadata {
+	var meta memberMetadata
+	if len(member.Meta) < 1 || json.Unmarshal(member.Meta, &meta) != nil {
+		return memberMetadata{}
+	}
+
+	return meta
+}
+
 // stateHandler simply dumps the JSON output of the whole state object. This is
 // useful for listeners or other clients that need a full state dump on startup.
 func (s *SidecarApi) stateHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	s.state.RLock()
-	defer s.state.RUnlock()
-
 	if params["extension"] != "json" {
 		sendJsonError(response, 404, "Not Found - Invalid content type extension")
 		return
 	}
 
 	response.Header().Set("Content-Type", "application/json")
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	response.Header().Set("Access-Control-Allow-Methods", "GET")
 
-	_, err := response.Write(s.state.Encode())
+	_, err := response.Write(s.state.SnapshotServices().Encode())
 	if err != nil {
 		log.Errorf("Error writing state response to client: %s", err)
 	}
 }
 
-// drainServiceHandler instructs Sidecar to set the status of a given service
-// instance to DRAINING. This allows us to decomission the given service
-// instance and let it sit around for a short amount of time, so it can finish
-// processing the requests that are still in flight.
-func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+// stateGraphHandler renders the hosts and their services as a diagram, in
+// Graphviz DOT for the "dot" extension, or D2 for "d2". Passing
+// "dependencies=true" adds the dependencies the services declare.
+func (s *SidecarApi) stateGraphHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
-	if req.Method != http.MethodPost {
-		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+	var contentType string
+	switch params["extension"] {
+	case catalog.GraphDot:
+		contentType = "text/vnd.graphviz"
+	case catalog.GraphD2:
+		contentType = "text/plain; charset=utf-8"
+	default:
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
 		return
 	}
 
-	serviceID, ok := params["id"]
-	if !ok {
-		sendJsonError(response, 404, "Not Found - No service ID provided")
+	var output bytes.Buffer
+	err := s.state.WriteGraph(&output, params["extension"], req.URL.Query().Get("dependencies") == "true")
+	if err != nil {
+		log.Errorf("Error rendering the state graph: %s", err)
+		sendJsonError(response, 500, "Internal Server Error - Unable to render the graph")
 		return
 	}
 
+	response.Header().Set("Content-Type", contentType)
+	_, err = response.Write(output.Bytes())
+	if err != nil {
+		log.Errorf("Error writing state graph response to client: %s", err)
+	}
+}
+
+// stateVersionHandler returns the state version, so that polling clients can
+// cheaply find out whether anything has changed. Takes an optional GET
+// parameter, "since", and reports whether the state changed after that
+// version.
+func (s *SidecarApi) stateVersionHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
 	if s.state == nil {
 		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
 		return
 	}
 
-	svc, err := s.state.GetLocalServiceByID(serviceID)
-	if err != nil {
-		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", serviceID))
-		return
+	result := ApiStateVersion{
+		Version: s.state.Version(),
+		Servers: s.state.ServerVersions(),
 	}
 
-	svc.Updated = time.Now()
-	svc.Status = service.DRAINING
-	s.state.UpdateService(svc)
+	s.state.RLock()
+	result.LastChanged = s.state.LastChanged
+	s.state.RUnlock()
 
-	result := struct {
-		Message string
-	}{
-		Message: fmt.Sprintf("Service %q instance %q set to DRAINING", svc.Name, svc.ID),
+	if sinceStr := req.URL.Query().Get("since"); sinceStr != "" {
+		since, err := strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid version %q", sinceStr))
+			return
+		}
+		changed := result.Version > since
+		result.Changed = &changed
 	}
+
 	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
 	if err != nil {
-		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		log.Errorf("Error marshaling state version: %s", err)
+		sendJsonError(response, 500, "Internal server error")
 		return
 	}
 
 	response.Header().Set("Content-Type", "application/json")
-	response.WriteHeader(202)
 	_, err = response.Write(jsonBytes)
 	if err != nil {
-		log.Errorf("Error writing drain service response to client: %s", err)
+		log.Errorf("Error writing state version response to client: %s", err)
+	}
+}
+
+// stateExportHandler returns a snapshot of the whole catalog, for restoring
+// into a replacement cluster with stateImportHandler
+func (s *SidecarApi) stateExportHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(s.state.Snapshot())
+	if err != nil {
+		log.Errorf("Error marshaling state snapshot: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing state export response to client: %s", err)
+	}
+}
+
+// stateImportHandler adds the services from a snapshot made by
+// stateExportHandler to the catalog, from where they are announced to the
+// rest of the cluster
+func (s *SidecarApi) stateImportHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	var snapshot catalog.Snapshot
+	err := json.NewDecoder(req.Body).Decode(&snapshot)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid snapshot: %s", err))
+		return
+	}
+
+	imported, err := s.state.ImportSnapshot(&snapshot)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	result := struct {
+		Message  string
+		Imported int
+	}{
+		Message:  fmt.Sprintf("Imported %d services from %q", imported, snapshot.Hostname),
+		Imported: imported,
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing state import response to client: %s", err)
+	}
+}
+
+// drainServiceHandler instructs Sidecar to set the status of a given service
+// instance to DRAINING. This allows us to decomission the given service
+// instance and let it sit around for a short amount of time, so it can finish
+// processing the requests that are still in flight.
+func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	serviceID, ok := params["id"]
+	if !ok {
+		sendJsonError(response, 404, "Not Found - No service ID provided")
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	ttl, err := parseDrainTTL(req)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	svc, err := s.state.GetLocalServiceByID(serviceID)
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", serviceID))
+		return
+	}
+
+	svc.Updated = time.Now()
+	svc.Status = service.DRAINING
+	s.state.SetDrainDeadline(svc.ID, ttl)
+	s.state.UpdateService(svc)
+
+	result := struct {
+		Message string
+	}{
+		Message: fmt.Sprintf("Service %q instance %q set to DRAINING", svc.Name, svc.ID),
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing drain service response to client: %s", err)
+	}
+}
+
+// ApiAnnotation is what clients send to annotate a service. TTL is a
+// duration like "2h", and defaults to DefaultAnnotationTTL.
+type ApiAnnotation struct {
+	Note   string
+	Author string
+	TTL    string
+}
+
+// annotateServiceHandler leaves an operator's note on a local service, which
+// is gossiped to the rest of the cluster along with the service. Like
+// draining, only the node running the service can annotate it.
+func (s *SidecarApi) annotateServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	var annotation ApiAnnotation
+	err := json.NewDecoder(io.LimitReader(req.Body, MaxAnnotationBytes)).Decode(&annotation)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid annotation: %s", err))
+		return
+	}
+
+	if annotation.Note == "" || len(annotation.Note) > service.MAX_ANNOTATION_LENGTH {
+		sendJsonError(response, 400,
+			fmt.Sprintf("Bad request - Note must be 1 to %d characters", service.MAX_ANNOTATION_LENGTH),
+		)
+		return
+	}
+
+	ttl := DefaultAnnotationTTL
+	if annotation.TTL != "" {
+		ttl, err = time.ParseDuration(annotation.TTL)
+		if err != nil || ttl <= 0 {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid TTL %q", annotation.TTL))
+			return
+		}
+	}
+
+	svc, err := s.state.AnnotateLocalService(params["id"], annotation.Note, annotation.Author, ttl)
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", params["id"]))
+		return
+	}
+
+	result := struct {
+		Message     string
+		Annotations []service.Annotation
+	}{
+		Message:     fmt.Sprintf("Service %q instance %q annotated", svc.Name, svc.ID),
+		Annotations: svc.Annotations,
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing annotate service response to client: %s", err)
+	}
+}
+
+// drainSelectedHandler sets all the local services whose tags match the
+// "selector" query parameter to DRAINING. With "cluster=true" it also asks
+// every other cluster member to do the same for its own services.
+func (s *SidecarApi) drainSelectedHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	selector, err := service.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	ttl, err := parseDrainTTL(req)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	result := ApiDrainResult{
+		Selector: selector.String(),
+		Hosts:    make(map[string]*ApiHostDrainResult),
+	}
+
+	if req.URL.Query().Get("cluster") == "true" {
+		for hostname, hostResult := range s.drainRemote(req.Context(), "drain", selector, ttl) {
+			result.Hosts[hostname] = hostResult
+		}
+	}
+
+	hostResult := &ApiHostDrainResult{Drained: []string{}}
+	for _, svc := range s.state.DrainLocalServices(selector, ttl) {
+		hostResult.Drained = append(hostResult.Drained, svc.ID)
+	}
+	result.Hosts[s.state.Hostname] = hostResult
+
+	log.Infof("Drained %d local services matching %q", len(hostResult.Drained), result.Selector)
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing drain services response to client: %s", err)
+	}
+}
+
+// undrainServiceHandler sets a DRAINING local service back to ALIVE and
+// cancels its drain deadline, e.g. when a drain was started by mistake
+func (s *SidecarApi) undrainServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	serviceID, ok := params["id"]
+	if !ok {
+		sendJsonError(response, 404, "Not Found - No service ID provided")
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	svc, err := s.state.UndrainLocalService(serviceID)
+	if errors.Is(err, catalog.ErrServiceNotFound) {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", serviceID))
+		return
+	}
+	if err != nil {
+		sendJsonError(response, 409, fmt.Sprintf("Conflict - %s", err))
+		return
+	}
+
+	result := struct {
+		Message string
+	}{
+		Message: fmt.Sprintf("Service %q instance %q set to ALIVE", svc.Name, svc.ID),
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(200)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing undrain service response to client: %s", err)
+	}
+}
+
+// undrainSelectedHandler sets all the DRAINING local services whose tags
+// match the "selector" query parameter back to ALIVE. With "cluster=true" it
+// also asks every other cluster member to do the same for its own services.
+func (s *SidecarApi) undrainSelectedHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	selector, err := service.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	result := ApiDrainResult{
+		Selector: selector.String(),
+		Hosts:    make(map[string]*ApiHostDrainResult),
+	}
+
+	if req.URL.Query().Get("cluster") == "true" {
+		for hostname, hostResult := range s.drainRemote(req.Context(), "undrain", selector, 0) {
+			result.Hosts[hostname] = hostResult
+		}
+	}
+
+	hostResult := &ApiHostDrainResult{Undrained: []string{}}
+	for _, svc := range s.state.UndrainLocalServices(selector) {
+		hostResult.Undrained = append(hostResult.Undrained, svc.ID)
+	}
+	result.Hosts[s.state.Hostname] = hostResult
+
+	log.Infof("Undrained %d local services matching %q", len(hostResult.Undrained), result.Selector)
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(200)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing undrain services response to client: %s", err)
+	}
+}
+
+// drainRemote asks each of the other cluster members to drain, or with the
+// "undrain" action undrain, their services that match the selector. Only the
+// owner of a service can drain it reliably, because the owner keeps
+// announcing it. Returns the results by hostname.
+func (s *SidecarApi) drainRemote(ctx context.Context, action string, selector service.Selector, ttl time.Duration) map[string]*ApiHostDrainResult {
+	results := make(map[string]*ApiHostDrainResult)
+	if s.list == nil {
+		return results
+	}
+
+	port := s.port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RemoteDrainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	for _, node := range s.list.Members() {
+		if node.Name == s.state.Hostname {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, addr string) {
+			defer wg.Done()
+
+			url := fmt.Sprintf("http://%s/api/services/%s?selector=%s",
+				net.JoinHostPort(addr, strconv.Itoa(port)), action, neturl.QueryEscape(selector.String()),
+			)
+			if ttl > 0 {
+				url += "&ttl=" + ttl.String()
+			}
+			hostResult, err := postDrain(ctx, url)
+			if err != nil {
+				log.Warnf("Unable to %s services on %s: %s", action, name, err)
+				hostResult = &ApiHostDrainResult{Error: err.Error()}
+			}
+
+			lock.Lock()
+			results[name] = hostResult
+			lock.Unlock()
+		}(node.Name, node.Addr.String())
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// parseDrainTTL reads the "ttl" query parameter, how long a drain lasts
+// before the service is tombstoned, e.g. "30m". Zero when it's missing.
+func parseDrainTTL(req *http.Request) (time.Duration, error) {
+	value := req.URL.Query().Get("ttl")
+	if value == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid ttl %q", value)
+	}
+
+	return ttl, nil
+}
+
+// postDrain sends a drain or undrain request to another member and picks
+// its own result out of the response
+func postDrain(ctx context.Context, url string) (*ApiHostDrainResult, error) {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Drains are accepted, undrains are done
+	if resp.StatusCode != 202 && resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+
+	var remote ApiDrainResult
+	err = json.NewDecoder(resp.Body).Decode(&remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// The member only reports on itself, since we didn't ask it to go
+	// cluster-wide
+	for _, hostResult := range remote.Hosts {
+		return hostResult, nil
+	}
+
+	return &ApiHostDrainResult{}, nil
+}
+
+// hostRenamesHandler returns the servers that look like they are the same
+// host under two different names
+func (s *SidecarApi) hostRenamesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(s.state.DetectHostRenames(), "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling host renames: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing host renames response to client: %s", err)
+	}
+}
+
+// lifetimesHandler returns how long the recent instances of each service
+// took to start and how long they lived
+func (s *SidecarApi) lifetimesHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(s.state.Lifetimes(), "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling service lifetimes: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing service lifetimes response to client: %s", err)
+	}
+}
+
+// ServicePortsResponse is the ServicePort pool and what has been handed out
+// from it on this node
+type ServicePortsResponse struct {
+	Pool        string
+	Assignments []discovery.PortAssignment
+}
+
+// servicePortsHandler returns the ServicePorts allocated to services on this
+// node. 404 when there's no pool to allocate from.
+func (s *SidecarApi) servicePortsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if s.allocator == nil {
+		sendJsonError(response, 404, "Not Found - No ServicePort pool is configured")
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(ServicePortsResponse{
+		Pool:        s.allocator.Pool.String(),
+		Assignments: s.allocator.Assignments(),
+	}, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling ServicePort assignments: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing ServicePort assignments response to client: %s", err)
+	}
+}
+
+// checksHandler lists the health checks on this node, with where each one
+// stands
+func (s *SidecarApi) checksHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if s.monitor == nil {
+		sendJsonError(response, 404, "Not Found - Health checks are not running")
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(s.monitor.CheckStatuses(), "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling health checks: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing health checks response to client: %s", err)
+	}
+}
+
+// disableCheckHandler silences a health check for the duration in the "ttl"
+// query parameter, DefaultCheckDisableTTL if there isn't one. The "reason"
+// parameter says why, for whoever looks at the checks next.
+func (s *SidecarApi) disableCheckHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.monitor == nil {
+		sendJsonError(response, 404, "Not Found - Health checks are not running")
+		return
+	}
+
+	ttl := DefaultCheckDisableTTL
+	if value := req.URL.Query().Get("ttl"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid TTL %q", value))
+			return
+		}
+	}
+
+	status, err := s.monitor.DisableCheck(params["id"], ttl, req.URL.Query().Get("reason"))
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Check %q not found", params["id"]))
+		return
+	}
+
+	sendCheckStatus(response, status)
+}
+
+// enableCheckHandler turns a disabled health check back on
+func (s *SidecarApi) enableCheckHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.monitor == nil {
+		sendJsonError(response, 404, "Not Found - Health checks are not running")
+		return
+	}
+
+	status, err := s.monitor.EnableCheck(params["id"])
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Check %q not found", params["id"]))
+		return
+	}
+
+	sendCheckStatus(response, status)
+}
+
+func sendCheckStatus(response http.ResponseWriter, status healthy.CheckStatus) {
+	jsonBytes, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing health check response to client: %s", err)
+	}
+}
+
+// migrateServerHandler moves the services announced under one hostname to
+// the hostname passed in the "to" query parameter
+func (s *SidecarApi) migrateServerHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if req.Method != http.MethodPost {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Method %q not allowed", req.Method))
+		return
+	}
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	from := params["hostname"]
+	to := req.URL.Query().Get("to")
+	if to == "" {
+		sendJsonError(response, 400, "Bad request - No destination hostname provided")
+		return
+	}
+
+	copied, err := s.state.MigrateServer(from, to)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	result := struct {
+		Message string
+	}{
+		Message: fmt.Sprintf("Migrated server %q to %q, copied %d services", from, to, copied),
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing migrate server response to client: %s", err)
 	}
 }
 
@@ -1,19 +1,30 @@
 package sidecarhttp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/NinesStack/memberlist"
 	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/config"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/envoy"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/healthy"
 	"github.com/NinesStack/sidecar/service"
 	director "github.com/relistan/go-director"
 	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func Test_oneServiceHandler(t *testing.T) {
@@ -35,6 +46,7 @@ func Test_oneServiceHandler(t *testing.T) {
 			Hostname: hostname,
 			Updated:  baseTime,
 			Status:   service.ALIVE,
+			Aliases:  []string{"decameron"},
 		}
 
 		svc2 := service.Service{
@@ -73,8 +85,8 @@ func Test_oneServiceHandler(t *testing.T) {
 		})
 
 		Convey("has CORS headers", func() {
-			delete(params, "name")
-			api.oneServiceHandler(recorder, req, params)
+			req := httptest.NewRequest("GET", "/services/garbage.json", nil)
+			api.HttpMux().ServeHTTP(recorder, req)
 
 			status, headers, _ := getResult(recorder)
 
@@ -103,6 +115,48 @@ func Test_oneServiceHandler(t *testing.T) {
 			So(body, ShouldNotContainSubstring, `"shakespeare"`)
 		})
 
+		Convey("finds the service by one of its aliases", func() {
+			params["name"] = "decameron"
+			api.oneServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"decameron": [`)
+			So(body, ShouldContainSubstring, `"Name": "bocaccio"`)
+		})
+
+		Convey("includes the status history when asked", func() {
+			req := httptest.NewRequest("GET", "/services/bocaccio.json?history=true", nil)
+			api.oneServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"History": {`)
+			So(body, ShouldContainSubstring, `"deadbeef123": [`)
+			So(body, ShouldContainSubstring, `"Hostname": "chaucer"`)
+			So(body, ShouldNotContainSubstring, `"deadbeef456"`)
+		})
+
+		Convey("leaves out the history by default", func() {
+			api.oneServiceHandler(recorder, req, params)
+
+			_, _, body := getResult(recorder)
+			So(body, ShouldNotContainSubstring, `"History"`)
+		})
+
+		Convey("filters the instances by status", func() {
+			req := httptest.NewRequest("GET", "/services/bocaccio.json?status=unhealthy", nil)
+			api.oneServiceHandler(recorder, req, params)
+			So(recorder.Code, ShouldEqual, 404)
+
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/services/bocaccio.json?status=alive,draining", nil)
+			api.oneServiceHandler(recorder, req, params)
+			So(recorder.Code, ShouldEqual, 200)
+		})
+
 		Convey("sends a 404 for unknown services", func() {
 			params["name"] = "garbage"
 			api.oneServiceHandler(recorder, req, params)
@@ -254,6 +308,51 @@ func Test_servicesHandler(t *testing.T) {
 			err := json.Unmarshal(bodyBytes, &result)
 			So(err, ShouldBeNil)
 			So(len(result.Services), ShouldEqual, 2)
+			So(len(result.Health), ShouldEqual, 2)
+		})
+
+		Convey("with a tombstoned service", func() {
+			state.Servers[hostname].Services[svcId2].Status = service.TOMBSTONE
+
+			getServices := func(query string) (int, *ApiServices) {
+				req := httptest.NewRequest("GET", "/services.json"+query, nil)
+				recorder := httptest.NewRecorder()
+				api.servicesHandler(recorder, req, params)
+
+				var result ApiServices
+				json.Unmarshal(recorder.Body.Bytes(), &result)
+				return recorder.Code, &result
+			}
+
+			Convey("includes tombstones by default", func() {
+				_, result := getServices("")
+				So(len(result.Services), ShouldEqual, 2)
+			})
+
+			Convey("leaves out tombstones when they are hidden", func() {
+				api.hideTombstones = true
+
+				_, result := getServices("")
+				So(len(result.Services), ShouldEqual, 1)
+				So(result.Services["bocaccio"], ShouldNotBeEmpty)
+
+				_, result = getServices("?status=all")
+				So(len(result.Services), ShouldEqual, 2)
+			})
+
+			Convey("returns only the statuses asked for", func() {
+				_, result := getServices("?status=Tombstone")
+				So(len(result.Services), ShouldEqual, 1)
+				So(result.Services["shakespeare"], ShouldNotBeEmpty)
+
+				_, result = getServices("?status=alive,tombstone")
+				So(len(result.Services), ShouldEqual, 2)
+			})
+
+			Convey("rejects unknown statuses", func() {
+				status, _ := getServices("?status=zombie")
+				So(status, ShouldEqual, 400)
+			})
 		})
 	})
 }
@@ -311,6 +410,265 @@ func Test_watchHandler(t *testing.T) {
 
 			So(dummyResp.Body.String(), ShouldEqual, string(expectedPayload))
 		})
+
+		Convey("Returns the version with the state", func() {
+			q := dummyReq.URL.Query()
+			q.Add("versioned", "true")
+			dummyReq.URL.RawQuery = q.Encode()
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			var result struct {
+				Version uint64
+				State   map[string][]*service.Service
+			}
+			err := json.Unmarshal(dummyResp.Body.Bytes(), &result)
+			So(err, ShouldBeNil)
+			So(result.Version, ShouldEqual, dummyState.Version())
+			So(result.State, ShouldContainKey, "dummy_service")
+			So(dummyResp.Header().Get("X-Sidecar-State-Version"), ShouldEqual,
+				fmt.Sprintf("%d", dummyState.Version()),
+			)
+		})
+
+		Convey("Ends each update with a newline for ndjson", func() {
+			q := dummyReq.URL.Query()
+			q.Add("format", "ndjson")
+			dummyReq.URL.RawQuery = q.Encode()
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			expectedPayload, err := json.Marshal(dummyState.ByService())
+			So(err, ShouldBeNil)
+			So(dummyResp.Body.String(), ShouldEqual, string(expectedPayload)+"\n")
+			So(dummyResp.Header().Get("Content-Type"), ShouldEqual, "application/x-ndjson")
+		})
+
+		Convey("Sends length-prefixed protobuf", func() {
+			q := dummyReq.URL.Query()
+			q.Add("format", "proto")
+			dummyReq.URL.RawQuery = q.Encode()
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+			So(dummyResp.Header().Get("Content-Type"), ShouldEqual, "application/x-protobuf")
+
+			body := dummyResp.Body.Bytes()
+			length, n := protowire.ConsumeVarint(body)
+			So(n, ShouldBeGreaterThan, 0)
+			So(len(body)-n, ShouldEqual, int(length))
+
+			// Pick out the version and the service names in the map
+			var version uint64
+			var names []string
+			msg := body[n:]
+			for len(msg) > 0 {
+				num, typ, n := protowire.ConsumeTag(msg)
+				msg = msg[n:]
+
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					version, n = protowire.ConsumeVarint(msg)
+				case num == 2 && typ == protowire.BytesType:
+					var entry []byte
+					entry, n = protowire.ConsumeBytes(msg)
+					_, _, keyLen := protowire.ConsumeTag(entry)
+					name, _ := protowire.ConsumeString(entry[keyLen:])
+					names = append(names, name)
+				default:
+					n = protowire.ConsumeFieldValue(num, typ, msg)
+				}
+				So(n, ShouldBeGreaterThan, 0)
+				msg = msg[n:]
+			}
+
+			So(version, ShouldEqual, dummyState.Version())
+			So(names, ShouldResemble, []string{"dummy_service"})
+		})
+
+		Convey("Rejects unknown formats", func() {
+			q := dummyReq.URL.Query()
+			q.Add("format", "xml")
+			dummyReq.URL.RawQuery = q.Encode()
+
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			So(dummyResp.Code, ShouldEqual, 400)
+		})
+
+		Convey("Rejects unknown statuses", func() {
+			q := dummyReq.URL.Query()
+			q.Add("status", "zombie")
+			dummyReq.URL.RawQuery = q.Encode()
+
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			So(dummyResp.Code, ShouldEqual, 400)
+		})
+
+		Convey("Leaves out hidden tombstones", func() {
+			dummyState.Servers["dummy_host"].Services["42"].Status = service.TOMBSTONE
+			api.hideTombstones = true
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			So(dummyResp.Body.String(), ShouldEqual, "{}")
+		})
+
+		Convey("Skips the first update when the client is up to date", func() {
+			q := dummyReq.URL.Query()
+			q.Add("since", fmt.Sprintf("%d", dummyState.Version()))
+			dummyReq.URL.RawQuery = q.Encode()
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			So(dummyResp.Body.String(), ShouldBeEmpty)
+		})
+
+		Convey("Sends the first update when the client is behind", func() {
+			q := dummyReq.URL.Query()
+			q.Add("since", fmt.Sprintf("%d", dummyState.Version()-1))
+			dummyReq.URL.RawQuery = q.Encode()
+
+			cancel()
+			api.watchHandler(dummyResp, dummyReq, nil)
+
+			So(dummyResp.Body.String(), ShouldNotBeEmpty)
+		})
+	})
+}
+
+func Test_stateVersionHandler(t *testing.T) {
+	Convey("stateVersionHandler", t, func() {
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+
+		state.AddServiceEntry(
+			service.Service{
+				ID:       "42",
+				Name:     "dummy_service",
+				Hostname: "dummy_host",
+				Updated:  time.Now().UTC(),
+				Status:   service.ALIVE,
+			},
+		)
+
+		recorder := httptest.NewRecorder()
+
+		Convey("returns the version", func() {
+			req := httptest.NewRequest("GET", "/state/version", nil)
+			api.stateVersionHandler(recorder, req, nil)
+
+			status, headers, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var result ApiStateVersion
+			err := json.Unmarshal([]byte(body), &result)
+			So(err, ShouldBeNil)
+			So(result.Version, ShouldEqual, state.Version())
+			So(result.Servers["dummy_host"], ShouldEqual, state.Version())
+			So(result.Changed, ShouldBeNil)
+		})
+
+		Convey("reports whether anything changed since a version", func() {
+			version := state.Version()
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/state/version?since=%d", version), nil)
+			api.stateVersionHandler(recorder, req, nil)
+
+			var result ApiStateVersion
+			_, _, body := getResult(recorder)
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(*result.Changed, ShouldBeFalse)
+
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/state/version?since=%d", version-1), nil)
+			api.stateVersionHandler(recorder, req, nil)
+
+			_, _, body = getResult(recorder)
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(*result.Changed, ShouldBeTrue)
+		})
+
+		Convey("rejects a bad version", func() {
+			req := httptest.NewRequest("GET", "/state/version?since=bogus", nil)
+			api.stateVersionHandler(recorder, req, nil)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 400)
+		})
+	})
+}
+
+func Test_stateExportImport(t *testing.T) {
+	Convey("stateExportHandler and stateImportHandler", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+		api := &SidecarApi{state: state}
+
+		state.AddServiceEntry(
+			service.Service{
+				ID:       "42",
+				Name:     "dummy_service",
+				Hostname: "dummy_host",
+				Updated:  time.Now().UTC(),
+				Status:   service.ALIVE,
+			},
+		)
+
+		recorder := httptest.NewRecorder()
+
+		Convey("exports a snapshot of the state", func() {
+			req := httptest.NewRequest("GET", "/state/export", nil)
+			api.stateExportHandler(recorder, req, nil)
+
+			status, headers, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var snapshot catalog.Snapshot
+			So(json.Unmarshal([]byte(body), &snapshot), ShouldBeNil)
+			So(snapshot.Hostname, ShouldEqual, "chaucer")
+			So(snapshot.Servers["dummy_host"].Services["42"].Name, ShouldEqual, "dummy_service")
+		})
+
+		Convey("imports an exported snapshot", func() {
+			req := httptest.NewRequest("GET", "/state/export", nil)
+			api.stateExportHandler(recorder, req, nil)
+			_, _, exported := getResult(recorder)
+
+			replacement := catalog.NewServicesState()
+			replacementApi := &SidecarApi{state: replacement}
+
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", "/state/import", bytes.NewBufferString(exported))
+			replacementApi.stateImportHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, `"Imported": 1`)
+			So(replacement.Servers["dummy_host"].HasService("42"), ShouldBeTrue)
+		})
+
+		Convey("rejects a bad snapshot", func() {
+			req := httptest.NewRequest("POST", "/state/import", bytes.NewBufferString("{}"))
+			api.stateImportHandler(recorder, req, nil)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 400)
+
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", "/state/import", bytes.NewBufferString("junk"))
+			api.stateImportHandler(recorder, req, nil)
+
+			status, _, _ = getResult(recorder)
+			So(status, ShouldEqual, 400)
+		})
 	})
 }
 
@@ -349,7 +707,7 @@ func Test_drainServiceHandler(t *testing.T) {
 			api.drainServiceHandler(recorder, req, params)
 
 			// Make sure we merge the state update
-			state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+			state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 
 			status, _, body := getResult(recorder)
 			So(status, ShouldEqual, 202)
@@ -364,13 +722,30 @@ func Test_drainServiceHandler(t *testing.T) {
 				state.UpdateService(svc)
 
 				// Make sure we merge the state update
-				state.ProcessServiceMsgs(director.NewFreeLooper(director.ONCE, nil))
+				state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
 
 				So(state.Servers[hostname].HasService(svcId), ShouldBeTrue)
 				So(state.Servers[hostname].Services[svcId].Status, ShouldEqual, service.DRAINING)
 			})
 		})
 
+		Convey("Takes a TTL for the drain", func() {
+			req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/services/%s/drain?ttl=30m", svcId), nil)
+			api.drainServiceHandler(recorder, req, params)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 202)
+		})
+
+		Convey("Returns an error for a bad TTL", func() {
+			req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/services/%s/drain?ttl=soon", svcId), nil)
+			api.drainServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "invalid ttl")
+		})
+
 		Convey("Returns an error for non-POST requests", func() {
 			req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/services/%s/drain", svcId), nil)
 
@@ -409,3 +784,704 @@ func Test_drainServiceHandler(t *testing.T) {
 		})
 	})
 }
+
+func Test_annotateServiceHandler(t *testing.T) {
+	Convey("When invoking the annotateService handler", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(0 - 1*time.Minute)
+
+		svcId := "deadbeef123"
+		svc := service.Service{
+			ID:       svcId,
+			Name:     "bocaccio",
+			Image:    "101deadbeef",
+			Created:  baseTime,
+			Hostname: hostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(svc)
+
+		recorder := httptest.NewRecorder()
+		api := &SidecarApi{state: state}
+		params := map[string]string{"id": svcId}
+
+		annotate := func(body string) {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/services/%s/annotate", svcId),
+				bytes.NewBufferString(body))
+			api.annotateServiceHandler(recorder, req, params)
+		}
+
+		Convey("Adds the annotation to the service", func() {
+			annotate(`{"Note": "under investigation, do not restart", "Author": "chaucer", "TTL": "2h"}`)
+
+			// Make sure we merge the state update
+			state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, "annotated")
+
+			annotations := state.Servers[hostname].Services[svcId].Annotations
+			So(len(annotations), ShouldEqual, 1)
+			So(annotations[0].Note, ShouldEqual, "under investigation, do not restart")
+			So(annotations[0].Author, ShouldEqual, "chaucer")
+			So(annotations[0].Expires, ShouldHappenWithin, time.Minute, time.Now().UTC().Add(2*time.Hour))
+
+			Convey("and keeps it when discovery updates the service", func() {
+				svc.Updated = time.Now().UTC().Add(time.Second)
+				state.UpdateService(svc)
+				state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(director.ONCE, nil))
+
+				So(len(state.Servers[hostname].Services[svcId].Annotations), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Returns an error for a bad annotation", func() {
+			for _, body := range []string{`junk`, `{"Note": ""}`, `{"Note": "hi", "TTL": "soon"}`} {
+				recorder = httptest.NewRecorder()
+				annotate(body)
+
+				status, _, _ := getResult(recorder)
+				So(status, ShouldEqual, 400)
+			}
+			So(state.Servers[hostname].Services[svcId].Annotations, ShouldBeEmpty)
+		})
+
+		Convey("Returns an error for services on other hosts", func() {
+			params["id"] = "cafebabe"
+			annotate(`{"Note": "hi"}`)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
+
+func Test_undrainServiceHandler(t *testing.T) {
+	Convey("When invoking the undrainService handler", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(0 - 1*time.Minute)
+
+		svcId := "deadbeef123"
+		state.AddServiceEntry(service.Service{
+			ID:       svcId,
+			Name:     "bocaccio",
+			Hostname: hostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		})
+
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/services/%s/undrain", svcId), nil)
+		recorder := httptest.NewRecorder()
+
+		api := &SidecarApi{state: state}
+		params := map[string]string{"id": svcId}
+
+		Convey("Sets a DRAINING service back to ALIVE", func() {
+			_, err := state.DrainLocalService(svcId, time.Hour)
+			So(err, ShouldBeNil)
+
+			api.undrainServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "set to ALIVE")
+			So(state.Servers[hostname].Services[svcId].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("Returns a conflict when the service isn't draining", func() {
+			api.undrainServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 409)
+			So(body, ShouldContainSubstring, "not Draining")
+		})
+
+		Convey("Returns an error if no service is found for the received ID", func() {
+			params["id"] = "missing"
+			api.undrainServiceHandler(recorder, req, params)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "not found")
+		})
+
+		Convey("Returns an error if the state is nil", func() {
+			api.state = nil
+			api.undrainServiceHandler(recorder, req, params)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 500)
+		})
+	})
+}
+
+func Test_drainSelectedHandler(t *testing.T) {
+	Convey("When invoking the drainSelected handler", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(0 - 1*time.Minute)
+
+		for i, env := range []string{"staging", "staging", "production"} {
+			state.AddServiceEntry(service.Service{
+				ID:       fmt.Sprintf("deadbeef%d", i),
+				Name:     "bocaccio",
+				Hostname: hostname,
+				Updated:  baseTime,
+				Status:   service.ALIVE,
+				Tags:     map[string]string{"env": env},
+			})
+		}
+
+		// Someone else's service, which we must leave alone
+		state.AddServiceEntry(service.Service{
+			ID:       "abba",
+			Name:     "bocaccio",
+			Hostname: "petrarch",
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+			Tags:     map[string]string{"env": "staging"},
+		})
+
+		recorder := httptest.NewRecorder()
+		api := &SidecarApi{state: state}
+
+		Convey("Drains the matching local services", func() {
+			req := httptest.NewRequest(http.MethodPost, "/services/drain?selector=env=staging", nil)
+			api.drainSelectedHandler(recorder, req, nil)
+
+			looper := director.NewFreeLooper(2, nil)
+			state.ProcessServiceMsgs(context.Background(), looper)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+
+			var result ApiDrainResult
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Selector, ShouldEqual, "env=staging")
+			So(result.Hosts[hostname].Drained, ShouldResemble, []string{"deadbeef0", "deadbeef1"})
+
+			services := state.Servers[hostname].Services
+			So(services["deadbeef0"].Status, ShouldEqual, service.DRAINING)
+			So(services["deadbeef1"].Status, ShouldEqual, service.DRAINING)
+			So(services["deadbeef2"].Status, ShouldEqual, service.ALIVE)
+			So(state.Servers["petrarch"].Services["abba"].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("Undrains the matching local services", func() {
+			everything, err := service.ParseSelector("env")
+			So(err, ShouldBeNil)
+			state.DrainLocalServices(everything, 0)
+			state.ProcessServiceMsgs(context.Background(), director.NewFreeLooper(3, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/services/undrain?selector=env=staging", nil)
+			api.undrainSelectedHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ApiDrainResult
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Hosts[hostname].Undrained, ShouldResemble, []string{"deadbeef0", "deadbeef1"})
+
+			services := state.Servers[hostname].Services
+			So(services["deadbeef0"].Status, ShouldEqual, service.ALIVE)
+			So(services["deadbeef1"].Status, ShouldEqual, service.ALIVE)
+			So(services["deadbeef2"].Status, ShouldEqual, service.DRAINING)
+		})
+
+		Convey("Picks out a remote member's result", func() {
+			var gotSelector string
+			remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotSelector = r.URL.Query().Get("selector")
+				w.WriteHeader(202)
+				_, _ = w.Write([]byte(`{"Selector": "env=staging", "Hosts": {"petrarch": {"Drained": ["abba"]}}}`))
+			}))
+			defer remote.Close()
+
+			hostResult, err := postDrain(context.Background(), remote.URL+"/api/services/drain?selector=env%3Dstaging")
+			So(err, ShouldBeNil)
+			So(gotSelector, ShouldEqual, "env=staging")
+			So(hostResult.Drained, ShouldResemble, []string{"abba"})
+		})
+
+		Convey("Returns an error for a bad TTL", func() {
+			req := httptest.NewRequest(http.MethodPost, "/services/drain?selector=env=staging&ttl=-5m", nil)
+			api.drainSelectedHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "invalid ttl")
+		})
+
+		Convey("Requires a selector", func() {
+			req := httptest.NewRequest(http.MethodPost, "/services/drain", nil)
+			api.drainSelectedHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "empty selector")
+		})
+
+		Convey("Returns an error if the state is nil", func() {
+			api.state = nil
+			req := httptest.NewRequest(http.MethodPost, "/services/drain?selector=env=staging", nil)
+			api.drainSelectedHandler(recorder, req, nil)
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 500)
+		})
+	})
+}
+
+func Test_membersHandler(t *testing.T) {
+	Convey("membersHandler", t, func() {
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+
+		req := httptest.NewRequest("GET", "/members.json", nil)
+		recorder := httptest.NewRecorder()
+
+		Convey("only returns JSON", func() {
+			api.membersHandler(recorder, req, map[string]string{"extension": "asdf"})
+
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "Invalid content")
+		})
+
+		Convey("returns the members", func() {
+			api.membersHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, headers, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var result ApiMembers
+			err := json.Unmarshal([]byte(body), &result)
+			So(err, ShouldBeNil)
+			So(result.ClusterMembers, ShouldBeEmpty)
+			So(result.Events, ShouldBeEmpty)
+		})
+
+		Convey("includes the member events when asked", func() {
+			api.reporter, _ = cluster.NewReporter(cluster.VerbosityNone)
+			api.reporter.NotifyJoin(&memberlist.Node{Name: "chaucer"})
+
+			req := httptest.NewRequest("GET", "/members.json?events=true", nil)
+			api.membersHandler(recorder, req, map[string]string{"extension": "json"})
+
+			_, _, body := getResult(recorder)
+
+			var result ApiMembers
+			err := json.Unmarshal([]byte(body), &result)
+			So(err, ShouldBeNil)
+			So(len(result.Events), ShouldEqual, 1)
+			So(result.Events[0].Name, ShouldEqual, "chaucer")
+			So(result.Events[0].Type, ShouldEqual, cluster.MemberJoin)
+		})
+
+		Convey("includes the version each member advertises", func() {
+			members := api.clusterMembers(state, []*memberlist.Node{
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default","Version":"v1.9.0"}`)},
+				{Name: "petrarch", Meta: []byte(`{"ClusterName":"default"}`)},
+				{Name: "bocaccio", Meta: []byte(`garbage`)},
+			}, nil)
+
+			So(members["chaucer"].Version, ShouldEqual, "v1.9.0")
+			So(members["petrarch"].Version, ShouldBeEmpty)
+			So(members["bocaccio"].Version, ShouldBeEmpty)
+		})
+
+		Convey("includes the labels each member advertises", func() {
+			members := api.clusterMembers(state, []*memberlist.Node{
+				{Name: "chaucer", Meta: []byte(`{"ClusterName":"default","Labels":{"rack":"r12"}}`)},
+				{Name: "petrarch", Meta: []byte(`{"ClusterName":"default"}`)},
+			}, nil)
+
+			So(members["chaucer"].Labels, ShouldResemble, map[string]string{"rack": "r12"})
+			So(members["petrarch"].Labels, ShouldBeNil)
+		})
+	})
+}
+
+func Test_migrateServerHandler(t *testing.T) {
+	Convey("When invoking the migrateServer handler", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: "shakespeare.example.com",
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+			Ports:    []service.Port{{Type: "tcp", Port: 1234}},
+		}
+		state.AddServiceEntry(svc)
+
+		svc.Hostname = "shakespeare"
+		state.AddServiceEntry(svc)
+
+		recorder := httptest.NewRecorder()
+		api := &SidecarApi{state: state}
+
+		Convey("lists the renamed hosts", func() {
+			req := httptest.NewRequest(http.MethodGet, "/servers/renames.json", nil)
+			api.hostRenamesHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"ServiceIDs": [`)
+			So(body, ShouldContainSubstring, "shakespeare.example.com")
+		})
+
+		Convey("migrates a server", func() {
+			req := httptest.NewRequest(http.MethodPost, "/servers/shakespeare.example.com/migrate?to=shakespeare", nil)
+			api.migrateServerHandler(recorder, req, map[string]string{"hostname": "shakespeare.example.com"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 202)
+			So(body, ShouldContainSubstring, "copied 0 services")
+			So(state.Servers["shakespeare.example.com"].Services[svc.ID].IsTombstone(), ShouldBeTrue)
+		})
+
+		Convey("requires a destination", func() {
+			req := httptest.NewRequest(http.MethodPost, "/servers/shakespeare.example.com/migrate", nil)
+			api.migrateServerHandler(recorder, req, map[string]string{"hostname": "shakespeare.example.com"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "No destination")
+		})
+
+		Convey("returns an error for an unknown server", func() {
+			req := httptest.NewRequest(http.MethodPost, "/servers/marlowe/migrate?to=shakespeare", nil)
+			api.migrateServerHandler(recorder, req, map[string]string{"hostname": "marlowe"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "no such server")
+		})
+	})
+}
+
+func Test_ServicePortsHandler(t *testing.T) {
+	Convey("servicePortsHandler()", t, func() {
+		recorder := httptest.NewRecorder()
+		api := &SidecarApi{state: catalog.NewServicesState()}
+		req := httptest.NewRequest(http.MethodGet, "/service_ports.json", nil)
+
+		Convey("returns 404 without a pool", func() {
+			api.servicePortsHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "No ServicePort pool")
+		})
+
+		Convey("lists the assignments", func() {
+			allocator, err := discovery.NewPortAllocator(discovery.PortRanges{{Min: 9000, Max: 9000}}, "")
+			So(err, ShouldBeNil)
+			allocator.Allocate([]service.Service{{
+				ID: "deadbeef123", Name: "bocaccio",
+				Ports: []service.Port{{Type: "tcp", Port: 1234, ServicePort: service.AutoServicePort(80)}},
+			}})
+			api.allocator = allocator
+
+			api.servicePortsHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"Pool": "9000"`)
+			So(body, ShouldContainSubstring, `"Service": "bocaccio"`)
+			So(body, ShouldContainSubstring, `"ServicePort": 9000`)
+		})
+	})
+}
+
+func Test_LifetimesHandler(t *testing.T) {
+	Convey("lifetimesHandler()", t, func() {
+		recorder := httptest.NewRecorder()
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+
+		baseTime := time.Now().UTC()
+		svc := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Created: baseTime.Add(-time.Minute), Updated: baseTime, Status: service.UNKNOWN,
+			Ports: []service.Port{{Type: "tcp", Port: 1234}},
+		}
+		state.AddServiceEntry(svc)
+		svc.Status = service.ALIVE
+		svc.Updated = baseTime.Add(5 * time.Second)
+		state.AddServiceEntry(svc)
+
+		Convey("returns the timings for each service", func() {
+			req := httptest.NewRequest(http.MethodGet, "/lifetimes.json", nil)
+			api.lifetimesHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var lifetimes map[string]catalog.ServiceLifetimes
+			So(json.Unmarshal([]byte(body), &lifetimes), ShouldBeNil)
+			So(lifetimes["bocaccio"].Startup.Count, ShouldEqual, 1)
+			So(lifetimes["bocaccio"].Startup.Max, ShouldEqual, 5*time.Second)
+		})
+
+		Convey("only returns JSON", func() {
+			req := httptest.NewRequest(http.MethodGet, "/lifetimes.txt", nil)
+			api.lifetimesHandler(recorder, req, map[string]string{"extension": "txt"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
+
+func Test_InspectServiceHandler(t *testing.T) {
+	Convey("inspectServiceHandler()", t, func() {
+		recorder := httptest.NewRecorder()
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+		api := &SidecarApi{state: state}
+
+		svc := service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Updated: time.Now().UTC(), Status: service.ALIVE,
+			Ports: []service.Port{{Type: "tcp", IP: "127.0.0.1", Port: 31234, ServicePort: 10100}},
+		}
+		state.AddServiceEntry(svc)
+
+		inspect := func(id string) (int, ApiServiceInspection) {
+			req := httptest.NewRequest(http.MethodGet, "/services/id/"+id+".json", nil)
+			api.inspectServiceHandler(recorder, req, map[string]string{"id": id, "extension": "json"})
+
+			status, _, body := getResult(recorder)
+			var result ApiServiceInspection
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		Convey("returns the record and where it lives", func() {
+			status, result := inspect(svc.ID)
+
+			So(status, ShouldEqual, 200)
+			So(result.Service.Name, ShouldEqual, "bocaccio")
+			So(result.Hostname, ShouldEqual, "chaucer")
+			So(result.Local, ShouldBeTrue)
+			So(result.Servable, ShouldBeTrue)
+			So(result.HAproxy, ShouldBeNil)
+			So(result.Envoy, ShouldBeNil)
+			So(result.Problems, ShouldBeEmpty)
+		})
+
+		Convey("explains why a service isn't getting traffic", func() {
+			svc.Status = service.UNHEALTHY
+			svc.Ports = []service.Port{{Type: "tcp", Port: 31234}}
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			_, result := inspect(svc.ID)
+
+			So(result.Servable, ShouldBeFalse)
+			So(result.Problems, ShouldResemble, []string{
+				"Status is Unhealthy",
+				"It has no ServicePorts, so the proxies don't route to it",
+			})
+		})
+
+		Convey("notices when the host has left the cluster", func() {
+			svc.Hostname = "petrarch"
+			state.AddServiceEntry(svc)
+			state.Servers["chaucer"].Services = map[string]*service.Service{}
+			state.Members = func() []string { return []string{"boccaccio"} }
+
+			_, result := inspect(svc.ID)
+
+			So(result.Local, ShouldBeFalse)
+			So(result.Member, ShouldBeFalse)
+			So(result.Problems, ShouldContain, "Host petrarch is not a cluster member")
+		})
+
+		Convey("checks the HAproxy config", func() {
+			tmpDir, err := ioutil.TempDir("", "sidecar-inspect")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(tmpDir) })
+
+			configFile := filepath.Join(tmpDir, "haproxy.cfg")
+			api.haproxy = haproxy.New(configFile, "/dev/null")
+
+			Convey("when the service is in it", func() {
+				So(ioutil.WriteFile(configFile, []byte("\tserver chaucer-deadbeef123 127.0.0.1:31234 cookie chaucer-31234\n"), 0644), ShouldBeNil)
+
+				_, result := inspect(svc.ID)
+				So(*result.HAproxy, ShouldBeTrue)
+				So(result.Problems, ShouldBeEmpty)
+			})
+
+			Convey("when the service is missing", func() {
+				So(ioutil.WriteFile(configFile, []byte("\tserver chaucer-deadbeef456 127.0.0.1:31235\n"), 0644), ShouldBeNil)
+
+				_, result := inspect(svc.ID)
+				So(*result.HAproxy, ShouldBeFalse)
+				So(result.Problems, ShouldContain, "It is not in the HAproxy config")
+			})
+		})
+
+		Convey("checks what was sent to Envoy", func() {
+			api.envoy = envoy.NewServer(state, config.EnvoyConfig{})
+
+			_, result := inspect(svc.ID)
+			So(result.Envoy, ShouldResemble, map[string]bool{"bocaccio:10100": false})
+			So(result.Problems, ShouldContain, "Envoy wasn't sent 127.0.0.1:31234 for bocaccio:10100")
+		})
+
+		Convey("returns a 404 for unknown IDs", func() {
+			status, _ := inspect("missing")
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
+
+func Test_StateGraphHandler(t *testing.T) {
+	Convey("stateGraphHandler()", t, func() {
+		recorder := httptest.NewRecorder()
+		state := catalog.NewServicesState()
+		api := &SidecarApi{state: state}
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Image: "bocaccio:1.2",
+			Updated: time.Now().UTC(), Tags: map[string]string{catalog.DependsOnTag: "petrarch"},
+			Ports: []service.Port{{Type: "tcp", Port: 1234}},
+		})
+
+		Convey("draws the state in DOT", func() {
+			req := httptest.NewRequest(http.MethodGet, "/state/graph.dot?dependencies=true", nil)
+			api.stateGraphHandler(recorder, req, map[string]string{"extension": "dot"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, "text/vnd.graphviz")
+			So(body, ShouldContainSubstring, `label="chaucer"`)
+			So(body, ShouldContainSubstring, `"deadbeef123" -> "service:petrarch"`)
+		})
+
+		Convey("draws the state in D2", func() {
+			req := httptest.NewRequest(http.MethodGet, "/state/graph.d2", nil)
+			api.stateGraphHandler(recorder, req, map[string]string{"extension": "d2"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"chaucer": {`)
+			So(body, ShouldNotContainSubstring, "petrarch")
+		})
+
+		Convey("rejects other formats", func() {
+			req := httptest.NewRequest(http.MethodGet, "/state/graph.png", nil)
+			api.stateGraphHandler(recorder, req, map[string]string{"extension": "png"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
+
+func Test_checksHandlers(t *testing.T) {
+	Convey("The health check handlers", t, func() {
+		recorder := httptest.NewRecorder()
+		api := &SidecarApi{state: catalog.NewServicesState()}
+
+		monitor := healthy.NewMonitor("127.0.0.1", "/")
+		monitor.AddCheck(&healthy.Check{
+			ID: "deadbeef123", ServiceName: "bocaccio", Type: "mock",
+			Command: &healthy.AlwaysSuccessfulCmd{}, Status: healthy.FAILED,
+		})
+
+		Convey("return 404 without a monitor", func() {
+			req := httptest.NewRequest(http.MethodGet, "/checks.json", nil)
+			api.checksHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "Health checks are not running")
+		})
+
+		Convey("list the checks", func() {
+			api.monitor = monitor
+			req := httptest.NewRequest(http.MethodGet, "/checks.json", nil)
+			api.checksHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"ID": "deadbeef123"`)
+			So(body, ShouldContainSubstring, `"Status": "FAILED"`)
+		})
+
+		Convey("disable a check", func() {
+			api.monitor = monitor
+			req := httptest.NewRequest(http.MethodPost, "/checks/deadbeef123/disable?ttl=2h&reason=flaky+endpoint", nil)
+			api.disableCheckHandler(recorder, req, map[string]string{"id": "deadbeef123"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"DisabledReason": "flaky endpoint"`)
+
+			disabledUntil := monitor.CheckStatuses()[0].DisabledUntil
+			So(disabledUntil, ShouldNotBeNil)
+			So(*disabledUntil, ShouldHappenWithin, time.Minute, time.Now().Add(2*time.Hour))
+
+			Convey("and enable it again", func() {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/checks/deadbeef123/enable", nil)
+				api.enableCheckHandler(recorder, req, map[string]string{"id": "deadbeef123"})
+
+				status, _, _ := getResult(recorder)
+				So(status, ShouldEqual, 200)
+				So(monitor.CheckStatuses()[0].DisabledUntil, ShouldBeNil)
+			})
+		})
+
+		Convey("disable a check for the default TTL", func() {
+			api.monitor = monitor
+			req := httptest.NewRequest(http.MethodPost, "/checks/deadbeef123/disable", nil)
+			api.disableCheckHandler(recorder, req, map[string]string{"id": "deadbeef123"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(*monitor.CheckStatuses()[0].DisabledUntil, ShouldHappenWithin,
+				time.Minute, time.Now().Add(DefaultCheckDisableTTL))
+		})
+
+		Convey("reject a bad TTL", func() {
+			api.monitor = monitor
+			req := httptest.NewRequest(http.MethodPost, "/checks/deadbeef123/disable?ttl=-1h", nil)
+			api.disableCheckHandler(recorder, req, map[string]string{"id": "deadbeef123"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 400)
+			So(body, ShouldContainSubstring, "Invalid TTL")
+		})
+
+		Convey("return 404 for checks we don't have", func() {
+			api.monitor = monitor
+			req := httptest.NewRequest(http.MethodPost, "/checks/missing/disable", nil)
+			api.disableCheckHandler(recorder, req, map[string]string{"id": "missing"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
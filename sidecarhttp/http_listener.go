@@ -2,7 +2,6 @@ package sidecarhttp
 
 import (
 	"fmt"
-	_ "net/http/pprof"
 	"time"
 
 	"github.com/NinesStack/sidecar/catalog"
@@ -4,6 +4,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/ui"
+	"github.com/NinesStack/sidecar/views"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 // getResult fetches the status code, headers, and body from a recorder
@@ -14,3 +22,81 @@ func getResult(recorder *httptest.ResponseRecorder) (code int, headers *http.Hea
 
 	return resp.StatusCode, &resp.Header, body
 }
+
+func Test_newServer(t *testing.T) {
+	Convey("newServer()", t, func() {
+		handler := http.NewServeMux()
+
+		Convey("listens on the default address", func() {
+			server := newServer(handler, &HttpConfig{})
+
+			So(server.Addr, ShouldEqual, "0.0.0.0:7777")
+			So(server.Handler, ShouldEqual, handler)
+		})
+
+		Convey("applies the config", func() {
+			server := newServer(handler, &HttpConfig{
+				ListenIP:          "127.0.0.1",
+				ListenPort:        8888,
+				ReadHeaderTimeout: 3 * time.Second,
+				IdleTimeout:       time.Minute,
+				MaxHeaderBytes:    4096,
+			})
+
+			So(server.Addr, ShouldEqual, "127.0.0.1:8888")
+			So(server.ReadHeaderTimeout, ShouldEqual, 3*time.Second)
+			So(server.IdleTimeout, ShouldEqual, time.Minute)
+			So(server.MaxHeaderBytes, ShouldEqual, 4096)
+		})
+
+		Convey("wraps the handler for h2c", func() {
+			server := newServer(handler, &HttpConfig{EnableH2C: true})
+
+			So(server.Handler, ShouldNotEqual, handler)
+
+			// Plain HTTP/1.1 requests still get through
+			handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(204)
+			})
+			recorder := httptest.NewRecorder()
+			server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			So(recorder.Code, ShouldEqual, 204)
+		})
+	})
+}
+
+func Test_fileServer(t *testing.T) {
+	Convey("fileServer()", t, func() {
+		get := func(handler http.Handler, path string) (int, string) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+			code, _, body := getResult(recorder)
+			return code, body
+		}
+
+		Convey("serves the files compiled into the binary", func() {
+			code, body := get(fileServer("", ui.Files()), "/")
+			So(code, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, "app.js")
+
+			code, _ = get(fileServer("", views.StaticFiles()), "/Sidecar.png")
+			So(code, ShouldEqual, 200)
+		})
+
+		Convey("serves a directory on disk instead, when there is one", func() {
+			dir, err := ioutil.TempDir("", "sidecar-ui")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(dir) })
+			So(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("overridden"), 0644), ShouldBeNil)
+
+			code, body := get(fileServer(dir, ui.Files()), "/")
+			So(code, ShouldEqual, 200)
+			So(body, ShouldEqual, "overridden")
+
+			So(ValidateFileDir(dir), ShouldBeNil)
+			So(ValidateFileDir(""), ShouldBeNil)
+			So(ValidateFileDir(filepath.Join(dir, "missing")), ShouldNotBeNil)
+			So(ValidateFileDir(filepath.Join(dir, "index.html")).Error(), ShouldContainSubstring, "not a directory")
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrometheusApi serves targets to Prometheus' HTTP service discovery
+type PrometheusApi struct {
+	state *catalog.ServicesState
+}
+
+func (p *PrometheusApi) HttpMux() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/targets.{extension}", wrap(p.targetsHandler)).Methods("GET")
+
+	return router
+}
+
+// targetsHandler returns the services that ask to be scraped, in the format
+// of Prometheus' http_sd_config
+func (p *PrometheusApi) targetsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if p.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(p.state.PrometheusTargets())
+	if err != nil {
+		log.Errorf("Error marshaling Prometheus targets: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing Prometheus targets response to client: %s", err)
+	}
+}
@@ -0,0 +1,54 @@
+package sidecarhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PrometheusTargetsHandler(t *testing.T) {
+	Convey("targetsHandler", t, func() {
+		state := catalog.NewServicesState()
+		api := &PrometheusApi{state: state}
+		params := map[string]string{"extension": "json"}
+
+		req := httptest.NewRequest("GET", "/targets.json", nil)
+		recorder := httptest.NewRecorder()
+
+		Convey("returns an empty list without any targets", func() {
+			api.targetsHandler(recorder, req, params)
+			status, headers, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+			So(body, ShouldEqual, "[]")
+		})
+
+		Convey("returns the targets", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+				Updated: time.Now().UTC(), Status: service.ALIVE,
+				Tags:  map[string]string{catalog.PrometheusPortTag: "9090"},
+				Ports: []service.Port{{Type: "tcp", Port: 10001, ServicePort: 9090, IP: "10.0.0.1"}},
+			})
+
+			api.targetsHandler(recorder, req, params)
+			status, _, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"targets":["10.0.0.1:10001"]`)
+			So(body, ShouldContainSubstring, `"job":"bocaccio"`)
+		})
+
+		Convey("returns 404 for other extensions", func() {
+			api.targetsHandler(recorder, req, map[string]string{"extension": "yaml"})
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
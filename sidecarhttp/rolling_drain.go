@@ -0,0 +1,342 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRollingDrainDelay   = 30 * time.Second       // How long we wait after each wave when the client doesn't say
+	DefaultRollingDrainTimeout = 2 * time.Minute        // How long we wait on the proxies after each wave
+	RollingDrainPollInterval   = 500 * time.Millisecond // How often we look at the proxies and listeners while we wait
+
+	RollingDrainRunning = "running"
+	RollingDrainDone    = "done"
+	RollingDrainFailed  = "failed"
+)
+
+// ApiRollingDrain is the progress of a rolling drain
+type ApiRollingDrain struct {
+	Selector    string
+	Concurrency int    // How many services are drained in each wave
+	Wait        string // "proxies", or how long we wait after each wave
+	Status      string // running, done, or failed
+	Error       string `json:",omitempty"`
+	Started     time.Time
+	Finished    *time.Time `json:",omitempty"`
+	Pending     []string   // Service IDs still to drain
+	Waves       []*ApiDrainWave
+}
+
+// ApiDrainWave is one batch of services in a rolling drain
+type ApiDrainWave struct {
+	Drained  []string // Service IDs
+	Skipped  []string `json:",omitempty"` // Gone, or already draining, by the time we got to them
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"` // When we were done waiting on it
+}
+
+// rollingDrains holds the rolling drain that is running, or the last one
+type rollingDrains struct {
+	sync.Mutex
+	current *ApiRollingDrain
+}
+
+// rollingDrainPlan is how a rolling drain was asked to go
+type rollingDrainPlan struct {
+	ttl            time.Duration
+	concurrency    int
+	delay          time.Duration
+	waitForProxies bool
+	timeout        time.Duration
+}
+
+// rollingDrainHandler drains the local services that match the "selector"
+// query parameter a few at a time, so deployment tools don't have to
+// sequence the drains themselves. "concurrency" is how many go in each wave,
+// 1 by default. After each wave we wait for "wait", a duration, or with
+// "wait=proxies" until the HAproxy and Envoy we manage have dropped them, and
+// every listener that acks has passed the drain on, up to "timeout". "ttl"
+// works as on the other drain endpoints. The drain runs
+// in the background, and its progress is on /rolling-drain.json. Only one
+// runs at a time.
+func (s *SidecarApi) rollingDrainHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.state == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	selector, err := service.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	plan, err := s.parseRollingDrainPlan(req)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	wait := plan.delay.String()
+	if plan.waitForProxies {
+		wait = "proxies"
+	}
+
+	s.rollingDrains.Lock()
+	defer s.rollingDrains.Unlock()
+
+	if s.rollingDrains.current != nil && s.rollingDrains.current.Status == RollingDrainRunning {
+		sendJsonError(response, 409,
+			fmt.Sprintf("Conflict - A rolling drain of %q is already running", s.rollingDrains.current.Selector),
+		)
+		return
+	}
+
+	drain := &ApiRollingDrain{
+		Selector:    selector.String(),
+		Concurrency: plan.concurrency,
+		Wait:        wait,
+		Status:      RollingDrainRunning,
+		Started:     time.Now().UTC(),
+		Pending:     s.state.DrainableLocalServices(selector),
+		Waves:       []*ApiDrainWave{},
+	}
+	if drain.Pending == nil {
+		drain.Pending = []string{}
+	}
+	s.rollingDrains.current = drain
+
+	log.Infof("Starting a rolling drain of %d local services matching %q, %d at a time",
+		len(drain.Pending), drain.Selector, plan.concurrency,
+	)
+
+	go s.rollingDrain(drain, plan)
+
+	jsonBytes, err := json.MarshalIndent(drain, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(202)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing rolling drain response to client: %s", err)
+	}
+}
+
+// rollingDrainStatusHandler returns the progress of the rolling drain that is
+// running, or of the last one
+func (s *SidecarApi) rollingDrainStatusHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	s.rollingDrains.Lock()
+	if s.rollingDrains.current == nil {
+		s.rollingDrains.Unlock()
+		sendJsonError(response, 404, "Not Found - No rolling drain has been started")
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(s.rollingDrains.current, "", "  ")
+	s.rollingDrains.Unlock()
+
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing rolling drain status to client: %s", err)
+	}
+}
+
+// parseRollingDrainPlan reads the query parameters of a rolling drain
+func (s *SidecarApi) parseRollingDrainPlan(req *http.Request) (rollingDrainPlan, error) {
+	query := req.URL.Query()
+	plan := rollingDrainPlan{
+		concurrency: 1,
+		delay:       DefaultRollingDrainDelay,
+		timeout:     DefaultRollingDrainTimeout,
+	}
+
+	var err error
+	plan.ttl, err = parseDrainTTL(req)
+	if err != nil {
+		return plan, err
+	}
+
+	if value := query.Get("concurrency"); value != "" {
+		plan.concurrency, err = strconv.Atoi(value)
+		if err != nil || plan.concurrency < 1 {
+			return plan, fmt.Errorf("invalid concurrency %q", value)
+		}
+	}
+
+	switch value := query.Get("wait"); value {
+	case "":
+	case "proxies":
+		if s.haproxy == nil && s.envoy == nil && s.state.AckingListeners() == 0 {
+			return plan, fmt.Errorf("can't wait for proxies, this node doesn't manage any, and no listeners ack")
+		}
+		plan.waitForProxies = true
+	default:
+		plan.delay, err = time.ParseDuration(value)
+		if err != nil || plan.delay < 0 {
+			return plan, fmt.Errorf("invalid wait %q", value)
+		}
+	}
+
+	if value := query.Get("timeout"); value != "" {
+		plan.timeout, err = time.ParseDuration(value)
+		if err != nil || plan.timeout <= 0 {
+			return plan, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+
+	return plan, nil
+}
+
+// rollingDrain drains the pending services in waves, waiting after each one,
+// and records how it went. Stops when the proxies don't drop a wave, or the
+// listeners don't pass it on, in time, rather than take more instances out of
+// service.
+func (s *SidecarApi) rollingDrain(drain *ApiRollingDrain, plan rollingDrainPlan) {
+	for {
+		s.rollingDrains.Lock()
+		if len(drain.Pending) == 0 {
+			s.finishRollingDrain(drain, nil)
+			s.rollingDrains.Unlock()
+			return
+		}
+
+		count := plan.concurrency
+		if count > len(drain.Pending) {
+			count = len(drain.Pending)
+		}
+		ids := drain.Pending[:count]
+		drain.Pending = drain.Pending[count:]
+
+		wave := &ApiDrainWave{Drained: []string{}, Started: time.Now().UTC()}
+		drain.Waves = append(drain.Waves, wave)
+		s.rollingDrains.Unlock()
+
+		var drained []service.Service
+		var skipped []string
+		for _, id := range ids {
+			svc, err := s.state.DrainLocalService(id, plan.ttl)
+			if err != nil {
+				log.Warnf("Rolling drain skipped %s: %s", id, err)
+				skipped = append(skipped, id)
+				continue
+			}
+			drained = append(drained, svc)
+		}
+
+		s.rollingDrains.Lock()
+		for _, svc := range drained {
+			wave.Drained = append(wave.Drained, svc.ID)
+		}
+		wave.Skipped = skipped
+		s.rollingDrains.Unlock()
+
+		log.Infof("Rolling drain of %q drained %s", drain.Selector, strings.Join(wave.Drained, ", "))
+
+		// DrainLocalService() has applied the drains, so this includes them
+		err := s.awaitDrainWave(drained, s.state.Version(), plan)
+
+		s.rollingDrains.Lock()
+		finished := time.Now().UTC()
+		wave.Finished = &finished
+		if err != nil {
+			s.finishRollingDrain(drain, err)
+			s.rollingDrains.Unlock()
+			return
+		}
+		s.rollingDrains.Unlock()
+	}
+}
+
+// finishRollingDrain records the end of the drain. Expects the lock to be
+// held.
+func (s *SidecarApi) finishRollingDrain(drain *ApiRollingDrain, err error) {
+	finished := time.Now().UTC()
+	drain.Finished = &finished
+	drain.Status = RollingDrainDone
+
+	if err != nil {
+		drain.Status = RollingDrainFailed
+		drain.Error = err.Error()
+		log.Errorf("Rolling drain of %q stopped with %d services left: %s", drain.Selector, len(drain.Pending), err)
+		return
+	}
+
+	log.Infof("Rolling drain of %q finished after %d waves", drain.Selector, len(drain.Waves))
+}
+
+// awaitDrainWave waits after a wave, either for the delay, or until none of
+// our proxies send traffic to the drained services, and the listeners that
+// ack, which tell the rest of the world, have passed on the state version
+// with the drains in it
+func (s *SidecarApi) awaitDrainWave(drained []service.Service, version uint64, plan rollingDrainPlan) error {
+	if !plan.waitForProxies {
+		time.Sleep(plan.delay)
+		return nil
+	}
+
+	deadline := time.Now().Add(plan.timeout)
+	for {
+		proxied := s.proxiedServices(drained)
+		unacked := s.state.UnackedListeners(version)
+		if len(proxied) == 0 && len(unacked) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if len(proxied) > 0 {
+				return fmt.Errorf("the proxies still had %s after %s", strings.Join(proxied, ", "), plan.timeout)
+			}
+			return fmt.Errorf("%s didn't pass on the drain within %s", strings.Join(unacked, ", "), plan.timeout)
+		}
+
+		time.Sleep(RollingDrainPollInterval)
+	}
+}
+
+// proxiedServices returns the IDs of the services that are still in the
+// HAproxy config or were last sent to Envoy
+func (s *SidecarApi) proxiedServices(services []service.Service) []string {
+	var proxied []string
+	proxies := s.loadProxyView()
+	for i := range services {
+		inspection := s.inspectService(&services[i], proxies)
+
+		present := inspection.HAproxy != nil && *inspection.HAproxy
+		for _, sent := range inspection.Envoy {
+			present = present || sent
+		}
+
+		if present {
+			proxied = append(proxied, services[i].ID)
+		}
+	}
+
+	return proxied
+}
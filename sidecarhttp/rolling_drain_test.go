@@ -0,0 +1,223 @@
+package sidecarhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_rollingDrainHandler(t *testing.T) {
+	Convey("When running a rolling drain", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+
+		baseTime := time.Now().UTC().Add(0 - 1*time.Minute)
+
+		for i, env := range []string{"staging", "staging", "staging", "production"} {
+			state.AddServiceEntry(service.Service{
+				ID:       fmt.Sprintf("deadbeef%d", i),
+				Name:     "bocaccio",
+				Hostname: hostname,
+				Updated:  baseTime,
+				Status:   service.ALIVE,
+				Tags:     map[string]string{"env": env},
+			})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		Reset(cancel)
+		go state.ProcessServiceMsgs(ctx, director.NewFreeLooper(director.FOREVER, nil))
+
+		api := &SidecarApi{state: state}
+
+		start := func(query string) (int, ApiRollingDrain) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/rolling-drain?"+query, nil)
+			api.rollingDrainHandler(recorder, req, nil)
+
+			status, _, body := getResult(recorder)
+			var result ApiRollingDrain
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		progress := func() (int, ApiRollingDrain) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/rolling-drain.json", nil)
+			api.rollingDrainStatusHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			var result ApiRollingDrain
+			_ = json.Unmarshal([]byte(body), &result)
+			return status, result
+		}
+
+		finished := func() ApiRollingDrain {
+			var result ApiRollingDrain
+			for i := 0; i < 200; i++ {
+				_, result = progress()
+				if result.Status != RollingDrainRunning {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return result
+		}
+
+		statusOf := func(id string) int {
+			svc, err := state.GetLocalServiceByID(id)
+			So(err, ShouldBeNil)
+			return svc.Status
+		}
+
+		Convey("drains the matching services in waves", func() {
+			status, result := start("selector=env=staging&concurrency=2&wait=10ms")
+			So(status, ShouldEqual, 202)
+			So(result.Selector, ShouldEqual, "env=staging")
+			So(result.Concurrency, ShouldEqual, 2)
+			So(result.Wait, ShouldEqual, "10ms")
+			So(result.Pending, ShouldResemble, []string{"deadbeef0", "deadbeef1", "deadbeef2"})
+
+			result = finished()
+			So(result.Status, ShouldEqual, RollingDrainDone)
+			So(result.Finished, ShouldNotBeNil)
+			So(result.Pending, ShouldBeEmpty)
+			So(len(result.Waves), ShouldEqual, 2)
+			So(result.Waves[0].Drained, ShouldResemble, []string{"deadbeef0", "deadbeef1"})
+			So(result.Waves[1].Drained, ShouldResemble, []string{"deadbeef2"})
+
+			So(statusOf("deadbeef0"), ShouldEqual, service.DRAINING)
+			So(statusOf("deadbeef2"), ShouldEqual, service.DRAINING)
+			So(statusOf("deadbeef3"), ShouldEqual, service.ALIVE)
+		})
+
+		Convey("refuses to start a second one while the first runs", func() {
+			status, _ := start("selector=env=staging&wait=200ms")
+			So(status, ShouldEqual, 202)
+
+			status, _ = start("selector=env=production")
+			So(status, ShouldEqual, 409)
+		})
+
+		Convey("waits for the proxies to drop each wave", func() {
+			tmpDir, err := ioutil.TempDir("", "sidecar-rolling-drain")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(tmpDir) })
+
+			configFile := filepath.Join(tmpDir, "haproxy.cfg")
+			api.haproxy = haproxy.New(configFile, "/dev/null")
+
+			writeConfig := func(ids ...string) {
+				var config string
+				for _, id := range ids {
+					config += fmt.Sprintf("\tserver chaucer-%s 127.0.0.1:31234\n", id)
+				}
+				So(ioutil.WriteFile(configFile, []byte(config), 0644), ShouldBeNil)
+			}
+			writeConfig("deadbeef0", "deadbeef1", "deadbeef2")
+
+			status, _ := start("selector=env=staging&wait=proxies")
+			So(status, ShouldEqual, 202)
+
+			// Until HAproxy drops the first one, we don't go on
+			time.Sleep(2 * RollingDrainPollInterval)
+			_, result := progress()
+			So(result.Status, ShouldEqual, RollingDrainRunning)
+			So(len(result.Waves), ShouldEqual, 1)
+			So(result.Waves[0].Finished, ShouldBeNil)
+
+			writeConfig()
+
+			result = finished()
+			So(result.Status, ShouldEqual, RollingDrainDone)
+			So(result.Wait, ShouldEqual, "proxies")
+			So(len(result.Waves), ShouldEqual, 3)
+		})
+
+		Convey("stops when the proxies don't drop a wave in time", func() {
+			tmpDir, err := ioutil.TempDir("", "sidecar-rolling-drain")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(tmpDir) })
+
+			configFile := filepath.Join(tmpDir, "haproxy.cfg")
+			api.haproxy = haproxy.New(configFile, "/dev/null")
+			So(ioutil.WriteFile(configFile, []byte("\tserver chaucer-deadbeef0 127.0.0.1:31234\n"), 0644), ShouldBeNil)
+
+			status, _ := start("selector=env=staging&wait=proxies&timeout=10ms")
+			So(status, ShouldEqual, 202)
+
+			result := finished()
+			So(result.Status, ShouldEqual, RollingDrainFailed)
+			So(result.Error, ShouldContainSubstring, "deadbeef0")
+			So(result.Pending, ShouldResemble, []string{"deadbeef1", "deadbeef2"})
+			So(statusOf("deadbeef1"), ShouldEqual, service.ALIVE)
+		})
+
+		Convey("waits for the listeners that ack to pass each wave on", func() {
+			listener := "UrlListener(http://chaucer:7777/)"
+			state.AckListener(listener, state.Version())
+
+			status, _ := start("selector=env=staging&wait=proxies")
+			So(status, ShouldEqual, 202)
+
+			// Until the listener acks the first drain, we don't go on
+			time.Sleep(2 * RollingDrainPollInterval)
+			_, result := progress()
+			So(result.Status, ShouldEqual, RollingDrainRunning)
+			So(len(result.Waves), ShouldEqual, 1)
+			So(result.Waves[0].Finished, ShouldBeNil)
+
+			state.AckListener(listener, math.MaxUint64)
+
+			result = finished()
+			So(result.Status, ShouldEqual, RollingDrainDone)
+			So(len(result.Waves), ShouldEqual, 3)
+		})
+
+		Convey("stops when a listener doesn't pass a wave on in time", func() {
+			listener := "UrlListener(http://chaucer:7777/)"
+			state.AckListener(listener, state.Version())
+
+			status, _ := start("selector=env=staging&wait=proxies&timeout=10ms")
+			So(status, ShouldEqual, 202)
+
+			result := finished()
+			So(result.Status, ShouldEqual, RollingDrainFailed)
+			So(result.Error, ShouldContainSubstring, listener)
+			So(result.Pending, ShouldResemble, []string{"deadbeef1", "deadbeef2"})
+		})
+
+		Convey("rejects bad requests", func() {
+			for _, query := range []string{
+				"selector=",
+				"selector=env=staging&concurrency=0",
+				"selector=env=staging&wait=soon",
+				"selector=env=staging&wait=proxies",
+				"selector=env=staging&timeout=-1s",
+			} {
+				status, _ := start(query)
+				So(status, ShouldEqual, 400)
+			}
+		})
+
+		Convey("returns a 404 for the progress before any have started", func() {
+			status, _ := progress()
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
@@ -0,0 +1,173 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/envoy"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// BuildInfo describes the Sidecar binary
+type BuildInfo struct {
+	Version   string
+	Commit    string `json:",omitempty"`
+	GoVersion string
+}
+
+// ApiStatusInfo is everything we report about this node in one place, so
+// that fleet inventory tools only need one request.
+type ApiStatusInfo struct {
+	Build             BuildInfo
+	Hostname          string
+	ClusterName       string
+	Started           time.Time
+	UptimeSeconds     int64
+	ConfigFingerprint string
+	Discovery         []string
+	DiscoveryBackends []discovery.BackendStatus `json:",omitempty"` // nil when we don't know
+	Capabilities      ApiCapabilities
+	Members           int
+	Gossip            *cluster.GossipSettings `json:",omitempty"` // nil when we don't know
+	State             ApiStateSummary
+	Memory            catalog.MemoryReport
+	HAproxy           *ApiHAproxyInfo `json:",omitempty"` // nil when HAproxy isn't managed
+	Envoy             *envoy.Status   `json:",omitempty"` // nil when the Envoy API is off
+}
+
+// ApiCapabilities says what this platform lets Sidecar do. Windows nodes,
+// for instance, can only run discovery.
+type ApiCapabilities struct {
+	OS        string
+	Arch      string
+	Discovery []string // The discovery methods that work here
+	HAproxy   bool     // Whether we can manage HAproxy here
+}
+
+type ApiStateSummary struct {
+	Servers     int
+	Services    int // Not counting tombstones
+	Tombstones  int
+	Version     uint64
+	LastChanged time.Time
+}
+
+type ApiHAproxyInfo struct {
+	LastVerify *haproxy.ReloadResult
+	LastReload *haproxy.ReloadResult
+}
+
+type StatusApi struct {
+	list   *memberlist.Memberlist
+	state  *catalog.ServicesState
+	config *HttpConfig
+}
+
+func (s *StatusApi) HttpMux() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/info.{extension}", wrap(s.infoHandler)).Methods("GET")
+	router.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	router.Use(corsFor(s.config).middleware)
+
+	return router
+}
+
+// infoHandler returns the build, configuration, and health of the subsystems
+// on this node
+func (s *StatusApi) infoHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if s.state == nil || s.config == nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	info := ApiStatusInfo{
+		Build:             s.config.Build,
+		Started:           s.config.Started,
+		ConfigFingerprint: s.config.ConfigFingerprint,
+		Discovery:         s.config.Discovery,
+		Capabilities: ApiCapabilities{
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			Discovery: discovery.SupportedMethods,
+			HAproxy:   haproxy.Supported,
+		},
+	}
+
+	if !info.Started.IsZero() {
+		info.UptimeSeconds = int64(time.Since(info.Started) / time.Second)
+	}
+
+	if s.config.DiscoveryStatus != nil {
+		info.DiscoveryBackends = s.config.DiscoveryStatus.BackendStatuses()
+	}
+
+	if s.list != nil {
+		info.Members = s.list.NumMembers()
+	}
+
+	if s.config.Gossip != nil {
+		settings := s.config.Gossip.Settings()
+		if settings.Members == 0 {
+			settings.Members = info.Members
+		}
+		info.Gossip = &settings
+	}
+
+	info.State.Version = s.state.Version()
+	info.Memory = s.state.MemoryReport()
+
+	s.state.RLock()
+	info.Hostname = s.state.Hostname
+	info.ClusterName = s.state.ClusterName
+	info.State.Servers = len(s.state.Servers)
+	info.State.LastChanged = s.state.LastChanged
+	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
+		if svc.IsTombstone() {
+			info.State.Tombstones++
+		} else {
+			info.State.Services++
+		}
+	})
+	s.state.RUnlock()
+
+	if s.config.HAproxy != nil {
+		info.HAproxy = &ApiHAproxyInfo{
+			LastVerify: s.config.HAproxy.LastResult("verify"),
+			LastReload: s.config.HAproxy.LastResult("reload"),
+		}
+	}
+
+	if s.config.Envoy != nil {
+		status := s.config.Envoy.Status()
+		info.Envoy = &status
+	}
+
+	jsonBytes, err := json.MarshalIndent(&info, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling status info: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing status info response to client: %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/memberlist"
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/cluster"
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/haproxy"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_StatusInfoHandler(t *testing.T) {
+	Convey("infoHandler", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+		state.ClusterName = "default"
+
+		baseTime := time.Now().UTC()
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer",
+			Updated: baseTime, Status: service.ALIVE,
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "bocaccio", Hostname: "petrarch",
+			Updated: baseTime, Status: service.TOMBSTONE,
+		})
+
+		config := &HttpConfig{
+			Build:             BuildInfo{Version: "v1.2.3", GoVersion: "go1.16"},
+			Started:           baseTime.Add(-1 * time.Minute),
+			ConfigFingerprint: "abcdef123456",
+			Discovery:         []string{"docker", "static"},
+		}
+		api := &StatusApi{state: state, config: config}
+
+		req := httptest.NewRequest("GET", "/info.json", nil)
+		recorder := httptest.NewRecorder()
+
+		Convey("returns the status of the node", func() {
+			api.infoHandler(recorder, req, map[string]string{"extension": "json"})
+			status, headers, body := getResult(recorder)
+
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var info ApiStatusInfo
+			So(json.Unmarshal([]byte(body), &info), ShouldBeNil)
+			So(info.Build.Version, ShouldEqual, "v1.2.3")
+			So(info.Hostname, ShouldEqual, "chaucer")
+			So(info.ClusterName, ShouldEqual, "default")
+			So(info.UptimeSeconds, ShouldBeGreaterThanOrEqualTo, 60)
+			So(info.ConfigFingerprint, ShouldEqual, "abcdef123456")
+			So(info.Discovery, ShouldResemble, []string{"docker", "static"})
+			So(info.Capabilities.OS, ShouldEqual, runtime.GOOS)
+			So(info.Capabilities.Discovery, ShouldContain, "static")
+			So(info.Capabilities.HAproxy, ShouldEqual, haproxy.Supported)
+			So(info.State.Servers, ShouldEqual, 2)
+			So(info.State.Services, ShouldEqual, 1)
+			So(info.State.Tombstones, ShouldEqual, 1)
+			So(info.State.Version, ShouldEqual, state.Version())
+			So(info.Memory.Services, ShouldEqual, 2)
+			So(info.Memory.InternedStrings, ShouldEqual, 3)
+			So(info.Memory.HeapAlloc, ShouldBeGreaterThan, 0)
+			So(info.HAproxy, ShouldBeNil)
+			So(info.Envoy, ShouldBeNil)
+			So(info.Gossip, ShouldBeNil)
+		})
+
+		Convey("includes the gossip settings in effect", func() {
+			mlConfig := memberlist.DefaultLANConfig()
+			mlConfig.GossipMessages = 15
+			config.Gossip = cluster.NewGossipTuner(mlConfig, state)
+
+			api.infoHandler(recorder, req, map[string]string{"extension": "json"})
+			_, _, body := getResult(recorder)
+
+			var info ApiStatusInfo
+			So(json.Unmarshal([]byte(body), &info), ShouldBeNil)
+			So(info.Gossip, ShouldNotBeNil)
+			So(info.Gossip.Scale, ShouldEqual, 1)
+			So(info.Gossip.GossipMessages, ShouldEqual, 15)
+			So(info.Gossip.TombstoneRetransmits, ShouldEqual, catalog.TOMBSTONE_COUNT)
+		})
+
+		Convey("includes how the discovery backends are doing", func() {
+			disco := &discovery.MultiDiscovery{}
+			disco.Add("static", discovery.NewStaticDiscovery("/nonexistent.json", "127.0.0.1"))
+			config.DiscoveryStatus = disco
+
+			api.infoHandler(recorder, req, map[string]string{"extension": "json"})
+			_, _, body := getResult(recorder)
+
+			var info ApiStatusInfo
+			So(json.Unmarshal([]byte(body), &info), ShouldBeNil)
+			So(len(info.DiscoveryBackends), ShouldEqual, 1)
+			So(info.DiscoveryBackends[0].Name, ShouldEqual, "static")
+		})
+
+		Convey("includes HAproxy when it's managed", func() {
+			proxy := haproxy.New("/dev/null", "/dev/null")
+			proxy.VerifyCmd = "true"
+			_ = proxy.Verify()
+			config.HAproxy = proxy
+
+			api.infoHandler(recorder, req, map[string]string{"extension": "json"})
+			_, _, body := getResult(recorder)
+
+			var info ApiStatusInfo
+			So(json.Unmarshal([]byte(body), &info), ShouldBeNil)
+			So(info.HAproxy, ShouldNotBeNil)
+			So(info.HAproxy.LastVerify.ExitStatus, ShouldEqual, 0)
+			So(info.HAproxy.LastReload, ShouldBeNil)
+		})
+
+		Convey("only returns JSON", func() {
+			api.infoHandler(recorder, req, map[string]string{"extension": "asdf"})
+			status, _, _ := getResult(recorder)
+
+			So(status, ShouldEqual, 404)
+		})
+	})
+}
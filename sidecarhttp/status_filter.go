@@ -0,0 +1,71 @@
+package sidecarhttp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+)
+
+// A statusFilter picks the services to include in API responses by their
+// status. A nil filter includes them all.
+type statusFilter map[int]bool
+
+// parseStatusFilter reads the "status" query parameter, a csv list of
+// statuses like "alive,draining", or "all". Without one, tombstones are left
+// out when hideTombstones is set, and everything is included otherwise.
+func parseStatusFilter(value string, hideTombstones bool) (statusFilter, error) {
+	if value == "" {
+		if !hideTombstones {
+			return nil, nil
+		}
+
+		filter := make(statusFilter)
+		for status := service.ALIVE; status <= service.DRAINING; status++ {
+			filter[status] = status != service.TOMBSTONE
+		}
+		return filter, nil
+	}
+
+	if value == "all" {
+		return nil, nil
+	}
+
+	filter := make(statusFilter)
+	for _, name := range strings.Split(value, ",") {
+		status, ok := parseStatus(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("invalid status %q", name)
+		}
+		filter[status] = true
+	}
+
+	return filter, nil
+}
+
+// parseStatus looks up a status by the name StatusString() gives it, in any case
+func parseStatus(name string) (int, bool) {
+	for status := service.ALIVE; status <= service.DRAINING; status++ {
+		if strings.EqualFold(name, service.StatusString(status)) {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
+// apply removes the services the filter doesn't include from a state. It
+// changes the state, so only use it on one from SnapshotServices().
+func (f statusFilter) apply(state *catalog.ServicesState) {
+	if f == nil {
+		return
+	}
+
+	for _, server := range state.Servers {
+		for id, svc := range server.Services {
+			if !f[svc.Status] {
+				delete(server.Services, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sidecarhttp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_statusFilter(t *testing.T) {
+	Convey("statusFilter", t, func() {
+		Convey("includes everything without a status", func() {
+			filter, err := parseStatusFilter("", false)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeNil)
+		})
+
+		Convey("leaves out only tombstones when they are hidden", func() {
+			filter, err := parseStatusFilter("", true)
+			So(err, ShouldBeNil)
+			So(filter[service.TOMBSTONE], ShouldBeFalse)
+			So(filter[service.ALIVE], ShouldBeTrue)
+			So(filter[service.DRAINING], ShouldBeTrue)
+		})
+
+		Convey("includes everything for 'all'", func() {
+			filter, err := parseStatusFilter("all", true)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeNil)
+		})
+
+		Convey("parses a list of statuses in any case", func() {
+			filter, err := parseStatusFilter("alive, UNHEALTHY", false)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, statusFilter{service.ALIVE: true, service.UNHEALTHY: true})
+		})
+
+		Convey("rejects unknown statuses", func() {
+			_, err := parseStatusFilter("alive,zombie", false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "zombie")
+		})
+
+		Convey("removes the other services from a state", func() {
+			now := time.Now().UTC()
+			state := catalog.NewServicesState()
+			state.AddServiceEntry(service.Service{ID: "1", Name: "beowulf", Hostname: "heorot", Updated: now, Status: service.ALIVE})
+			state.AddServiceEntry(service.Service{ID: "2", Name: "grendel", Hostname: "heorot", Updated: now, Status: service.UNHEALTHY})
+
+			snapshot := state.SnapshotServices()
+			statusFilter{service.ALIVE: true}.apply(snapshot)
+
+			So(len(snapshot.Servers["heorot"].Services), ShouldEqual, 1)
+			So(snapshot.Servers["heorot"].Services["1"], ShouldNotBeNil)
+			So(len(state.Servers["heorot"].Services), ShouldEqual, 2)
+		})
+	})
+}
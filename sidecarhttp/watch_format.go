@@ -0,0 +1,185 @@
+package sidecarhttp
+
+import (
+	"sort"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The output formats supported by /watch
+const (
+	WatchFormatJSON   = "json"   // Concatenated JSON documents, the default
+	WatchFormatNDJSON = "ndjson" // One JSON document per line
+	WatchFormatProto  = "proto"  // Length-prefixed protobuf, see watch.proto
+)
+
+var watchContentTypes = map[string]string{
+	WatchFormatJSON:   "application/json",
+	WatchFormatNDJSON: "application/x-ndjson",
+	WatchFormatProto:  "application/x-protobuf",
+}
+
+// encodeWatchProto encodes a WatchUpdate message from watch.proto, preceded
+// by its length. There are no generated types for it, so we write out the
+// wire format ourselves. The state should be from SnapshotServices().
+func encodeWatchProto(version uint64, state *catalog.ServicesState, byService bool) []byte {
+	var msg []byte
+	msg = appendProtoUint(msg, 1, version)
+
+	if byService {
+		services := state.ByService()
+
+		names := make([]string, 0, len(services))
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var list []byte
+			for _, svc := range services[name] {
+				list = appendProtoMessage(list, 1, encodeServiceProto(svc))
+			}
+
+			var entry []byte
+			entry = appendProtoString(entry, 1, name)
+			entry = appendProtoMessage(entry, 2, list)
+			msg = appendProtoMessage(msg, 2, entry)
+		}
+	} else {
+		var stateMsg []byte
+		for _, server := range state.SortedServers() {
+			var serverMsg []byte
+			serverMsg = appendProtoString(serverMsg, 1, server.Name)
+			for _, svc := range server.SortedServices() {
+				serverMsg = appendProtoMessage(serverMsg, 2, encodeServiceProto(svc))
+			}
+			serverMsg = appendProtoInt(serverMsg, 3, protoTime(server.LastUpdated))
+			serverMsg = appendProtoInt(serverMsg, 4, protoTime(server.LastChanged))
+
+			stateMsg = appendProtoMessage(stateMsg, 1, serverMsg)
+		}
+		stateMsg = appendProtoInt(stateMsg, 2, protoTime(state.LastChanged))
+		stateMsg = appendProtoString(stateMsg, 3, state.ClusterName)
+		stateMsg = appendProtoString(stateMsg, 4, state.Hostname)
+
+		msg = appendProtoMessage(msg, 3, stateMsg)
+	}
+
+	framed := protowire.AppendVarint(nil, uint64(len(msg)))
+	return append(framed, msg...)
+}
+
+// encodeServiceProto encodes a Service message from watch.proto
+func encodeServiceProto(svc *service.Service) []byte {
+	var msg []byte
+	msg = appendProtoString(msg, 1, svc.ID)
+	msg = appendProtoString(msg, 2, svc.Name)
+	msg = appendProtoString(msg, 3, svc.Image)
+	msg = appendProtoInt(msg, 4, protoTime(svc.Created))
+	msg = appendProtoString(msg, 5, svc.Hostname)
+
+	for _, port := range svc.Ports {
+		var portMsg []byte
+		portMsg = appendProtoString(portMsg, 1, port.Type)
+		portMsg = appendProtoInt(portMsg, 2, port.Port)
+		portMsg = appendProtoInt(portMsg, 3, port.ServicePort)
+		portMsg = appendProtoString(portMsg, 4, port.IP)
+		portMsg = appendProtoString(portMsg, 5, port.ProxyMode)
+		msg = appendProtoMessage(msg, 6, portMsg)
+	}
+
+	msg = appendProtoInt(msg, 7, protoTime(svc.Updated))
+	msg = appendProtoString(msg, 8, svc.ProxyMode)
+	msg = appendProtoInt(msg, 9, int64(svc.Status))
+
+	keys := make([]string, 0, len(svc.Tags))
+	for key := range svc.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var entry []byte
+		entry = appendProtoString(entry, 1, key)
+		entry = appendProtoString(entry, 2, svc.Tags[key])
+		msg = appendProtoMessage(msg, 10, entry)
+	}
+
+	msg = appendProtoString(msg, 11, svc.TLSCert)
+	msg = appendProtoInt(msg, 12, int64(svc.MinInstances))
+
+	for _, alias := range svc.Aliases {
+		msg = protowire.AppendTag(msg, 13, protowire.BytesType)
+		msg = protowire.AppendString(msg, alias)
+	}
+
+	for _, iface := range svc.ListenOn {
+		msg = protowire.AppendTag(msg, 14, protowire.BytesType)
+		msg = protowire.AppendString(msg, iface)
+	}
+
+	msg = appendProtoString(msg, 15, svc.Source)
+
+	if svc.LastCheck != nil {
+		var checkMsg []byte
+		checkMsg = appendProtoInt(checkMsg, 1, protoTime(svc.LastCheck.Time))
+		checkMsg = appendProtoInt(checkMsg, 2, int64(svc.LastCheck.Latency))
+		checkMsg = appendProtoString(checkMsg, 3, svc.LastCheck.OutputHash)
+		msg = appendProtoMessage(msg, 16, checkMsg)
+	}
+
+	for _, annotation := range svc.Annotations {
+		var annotationMsg []byte
+		annotationMsg = appendProtoString(annotationMsg, 1, annotation.Note)
+		annotationMsg = appendProtoString(annotationMsg, 2, annotation.Author)
+		annotationMsg = appendProtoInt(annotationMsg, 3, protoTime(annotation.Created))
+		annotationMsg = appendProtoInt(annotationMsg, 4, protoTime(annotation.Expires))
+		msg = appendProtoMessage(msg, 17, annotationMsg)
+	}
+
+	return msg
+}
+
+// protoTime converts a time to Unix nanoseconds, leaving zero times as zero
+func protoTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// The appendProto functions leave out zero values, as proto3 does, except
+// for messages, which may be repeated or map entries
+
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendProtoInt(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendProtoUint(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
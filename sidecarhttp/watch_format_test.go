@@ -0,0 +1,87 @@
+package sidecarhttp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields splits a message into the raw values of its fields, by number
+func protoFields(msg []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		So(n, ShouldBeGreaterThan, 0)
+		msg = msg[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		So(n, ShouldBeGreaterThan, 0)
+		fields[num] = append(fields[num], msg[:n])
+		msg = msg[n:]
+	}
+
+	return fields
+}
+
+func Test_encodeServiceProto(t *testing.T) {
+	Convey("encodeServiceProto()", t, func() {
+		checked := time.Now().UTC()
+		svc := &service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: "heorot",
+			Status:   service.ALIVE,
+		}
+
+		Convey("includes the last check", func() {
+			svc.LastCheck = &service.CheckInfo{Time: checked, Latency: 20 * time.Millisecond, OutputHash: "abc"}
+
+			fields := protoFields(encodeServiceProto(svc))
+			So(fields[16], ShouldHaveLength, 1)
+
+			checkMsg, _ := protowire.ConsumeBytes(fields[16][0])
+			check := protoFields(checkMsg)
+
+			checkTime, _ := protowire.ConsumeVarint(check[1][0])
+			So(int64(checkTime), ShouldEqual, checked.UnixNano())
+			latency, _ := protowire.ConsumeVarint(check[2][0])
+			So(time.Duration(latency), ShouldEqual, 20*time.Millisecond)
+			hash, _ := protowire.ConsumeString(check[3][0])
+			So(hash, ShouldEqual, "abc")
+		})
+
+		Convey("leaves out a last check we don't have", func() {
+			fields := protoFields(encodeServiceProto(svc))
+			So(fields, ShouldNotContainKey, protowire.Number(16))
+		})
+
+		Convey("includes the annotations in order", func() {
+			svc.Annotations = []service.Annotation{
+				{Note: "rolling back", Author: "ops", Created: checked, Expires: checked.Add(time.Hour)},
+				{Note: "canary"},
+			}
+
+			fields := protoFields(encodeServiceProto(svc))
+			So(fields[17], ShouldHaveLength, 2)
+
+			first, _ := protowire.ConsumeBytes(fields[17][0])
+			annotation := protoFields(first)
+
+			note, _ := protowire.ConsumeString(annotation[1][0])
+			So(note, ShouldEqual, "rolling back")
+			author, _ := protowire.ConsumeString(annotation[2][0])
+			So(author, ShouldEqual, "ops")
+			created, _ := protowire.ConsumeVarint(annotation[3][0])
+			So(int64(created), ShouldEqual, checked.UnixNano())
+			expires, _ := protowire.ConsumeVarint(annotation[4][0])
+			So(int64(expires), ShouldEqual, checked.Add(time.Hour).UnixNano())
+
+			second, _ := protowire.ConsumeBytes(fields[17][1])
+			note, _ = protowire.ConsumeString(protoFields(second)[1][0])
+			So(note, ShouldEqual, "canary")
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package sidecartest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/service"
+	director "github.com/relistan/go-director"
+)
+
+// A HealthCheck is the check type and arguments a Discoverer hands out
+type HealthCheck struct {
+	Type string // e.g. "HttpGet"
+	Args string
+}
+
+// A Discoverer is a discovery.Discoverer that returns whatever the test has
+// given it, and records how it was called
+type Discoverer struct {
+	services  []service.Service
+	listeners []discovery.ChangeListener
+	checks    map[string]HealthCheck // By service name
+
+	servicesCalls int
+	runContext    context.Context
+
+	sync.Mutex
+}
+
+// NewDiscoverer returns a Discoverer that finds the services
+func NewDiscoverer(services ...service.Service) *Discoverer {
+	return &Discoverer{
+		services: services,
+		checks:   make(map[string]HealthCheck),
+	}
+}
+
+// SetServices replaces the services that are found from now on
+func (d *Discoverer) SetServices(services ...service.Service) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.services = services
+}
+
+// SetListeners replaces the listeners that are found from now on
+func (d *Discoverer) SetListeners(listeners ...discovery.ChangeListener) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.listeners = listeners
+}
+
+// SetHealthCheck sets the check handed out for services with the name
+func (d *Discoverer) SetHealthCheck(name string, checkType string, args string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.checks[name] = HealthCheck{Type: checkType, Args: args}
+}
+
+// Services is part of the discovery.Discoverer interface. Returns a copy of
+// the services, so callers can't change ours.
+func (d *Discoverer) Services() []service.Service {
+	d.Lock()
+	defer d.Unlock()
+
+	d.servicesCalls++
+	return append([]service.Service(nil), d.services...)
+}
+
+// Listeners is part of the discovery.Discoverer interface
+func (d *Discoverer) Listeners() []discovery.ChangeListener {
+	d.Lock()
+	defer d.Unlock()
+
+	return append([]discovery.ChangeListener(nil), d.listeners...)
+}
+
+// HealthCheck is part of the discovery.Discoverer interface. Returns the
+// check set for the service's name, or empty strings.
+func (d *Discoverer) HealthCheck(svc *service.Service) (string, string) {
+	d.Lock()
+	defer d.Unlock()
+
+	check := d.checks[svc.Name]
+	return check.Type, check.Args
+}
+
+// Run is part of the discovery.Discoverer interface. It doesn't discover
+// anything, it just records the context it was started with.
+func (d *Discoverer) Run(ctx context.Context, looper director.Looper) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.runContext = ctx
+}
+
+// ServicesCalls returns how many times Services() has been called
+func (d *Discoverer) ServicesCalls() int {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.servicesCalls
+}
+
+// RunContext returns the context Run() was called with, nil before then
+func (d *Discoverer) RunContext() context.Context {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.runContext
+}
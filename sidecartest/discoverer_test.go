@@ -0,0 +1,54 @@
+package sidecartest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/NinesStack/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var _ discovery.Discoverer = (*Discoverer)(nil)
+
+func Test_Discoverer(t *testing.T) {
+	Convey("Discoverer", t, func() {
+		disco := NewDiscoverer(service.Service{ID: "deadbeef123", Name: "bocaccio"})
+		disco.SetHealthCheck("bocaccio", "HttpGet", "http://{{ host }}:{{ tcp 8080 }}/")
+
+		multi := &discovery.MultiDiscovery{}
+		multi.Add("fake", disco)
+
+		Convey("hands out what it was given", func() {
+			services := multi.Services()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldEqual, "deadbeef123")
+			So(disco.ServicesCalls(), ShouldEqual, 1)
+
+			check, args := multi.HealthCheck(&services[0])
+			So(check, ShouldEqual, "HttpGet")
+			So(args, ShouldContainSubstring, "tcp 8080")
+		})
+
+		Convey("picks up changes", func() {
+			disco.SetServices()
+			disco.SetListeners(discovery.ChangeListener{Name: "listener", Url: "http://localhost/update"})
+
+			So(multi.Services(), ShouldBeEmpty)
+			So(multi.Listeners(), ShouldResemble, []discovery.ChangeListener{
+				{Name: "listener", Url: "http://localhost/update"},
+			})
+		})
+
+		Convey("records the context it was run with", func() {
+			So(disco.RunContext(), ShouldBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			multi.Run(ctx, director.NewFreeLooper(director.ONCE, nil))
+
+			So(disco.RunContext(), ShouldNotBeNil)
+		})
+	})
+}
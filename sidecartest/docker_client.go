@@ -0,0 +1,153 @@
+//go:build !windows
+// +build !windows
+
+package sidecartest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// A DockerClient is a discovery.DockerClient backed by containers the test
+// adds, rather than a Docker daemon. Hand it to discovery.DockerDiscovery
+// through ClientProvider.
+type DockerClient struct {
+	containers []docker.APIContainers
+	details    map[string]*docker.Container // What InspectContainer() returns, by full ID
+	listeners  map[chan<- *docker.APIEvents]bool
+	pingErr    error
+
+	sync.Mutex
+}
+
+// NewDockerClient returns a DockerClient with no containers running
+func NewDockerClient() *DockerClient {
+	return &DockerClient{
+		details:   make(map[string]*docker.Container),
+		listeners: make(map[chan<- *docker.APIEvents]bool),
+	}
+}
+
+// AddContainer starts a container. The details are what InspectContainer()
+// returns for it. When they're nil, they are made up from the container's ID,
+// image, and labels.
+func (c *DockerClient) AddContainer(container docker.APIContainers, details *docker.Container) {
+	if details == nil {
+		details = &docker.Container{
+			ID:     container.ID,
+			Image:  container.Image,
+			Config: &docker.Config{Image: container.Image, Labels: container.Labels},
+			State:  docker.State{Running: true},
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.removeContainer(container.ID)
+	c.containers = append(c.containers, container)
+	c.details[container.ID] = details
+}
+
+// RemoveContainer stops the container with the ID. It doesn't send an event,
+// see SendEvent() for that.
+func (c *DockerClient) RemoveContainer(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.removeContainer(id)
+}
+
+// removeContainer expects the lock to be held
+func (c *DockerClient) removeContainer(id string) {
+	for i, container := range c.containers {
+		if container.ID == id {
+			c.containers = append(c.containers[:i], c.containers[i+1:]...)
+			break
+		}
+	}
+	delete(c.details, id)
+}
+
+// SendEvent delivers the event to every listener, waiting for each to take
+// it
+func (c *DockerClient) SendEvent(event docker.APIEvents) {
+	c.Lock()
+	listeners := make([]chan<- *docker.APIEvents, 0, len(c.listeners))
+	for listener := range c.listeners {
+		listeners = append(listeners, listener)
+	}
+	c.Unlock()
+
+	for _, listener := range listeners {
+		eventCopy := event
+		listener <- &eventCopy
+	}
+}
+
+// SetPingError makes Ping() return the error, or succeed again when it's nil
+func (c *DockerClient) SetPingError(err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.pingErr = err
+}
+
+// InspectContainer is part of the discovery.DockerClient interface. Like
+// Docker, it accepts a prefix of the ID, e.g. the 12 characters Sidecar
+// uses for service IDs.
+func (c *DockerClient) InspectContainer(id string) (*docker.Container, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	for fullID, details := range c.details {
+		if id != "" && strings.HasPrefix(fullID, id) {
+			return details, nil
+		}
+	}
+
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+// ListContainers is part of the discovery.DockerClient interface. It returns
+// every container that was added, since they are all running.
+func (c *DockerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]docker.APIContainers(nil), c.containers...), nil
+}
+
+// AddEventListener is part of the discovery.DockerClient interface
+func (c *DockerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.listeners[listener] {
+		return docker.ErrListenerAlreadyExists
+	}
+	c.listeners[listener] = true
+
+	return nil
+}
+
+// RemoveEventListener is part of the discovery.DockerClient interface. The
+// listener is left open, so that an event already being sent doesn't panic.
+func (c *DockerClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.listeners, listener)
+
+	return nil
+}
+
+// Ping is part of the discovery.DockerClient interface
+func (c *DockerClient) Ping() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.pingErr
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package sidecartest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/discovery"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var _ discovery.DockerClient = (*DockerClient)(nil)
+
+func Test_DockerClient(t *testing.T) {
+	Convey("DockerClient", t, func() {
+		client := NewDockerClient()
+		client.AddContainer(docker.APIContainers{
+			ID:     "deadbeef1234567890",
+			Names:  []string{"/bocaccio"},
+			Image:  "bocaccio:latest",
+			Labels: map[string]string{"ServiceName": "bocaccio"},
+		}, nil)
+
+		Convey("inspects containers by a prefix of their ID", func() {
+			container, err := client.InspectContainer("deadbeef1234")
+			So(err, ShouldBeNil)
+			So(container.Config.Labels["ServiceName"], ShouldEqual, "bocaccio")
+
+			client.RemoveContainer("deadbeef1234567890")
+			_, err = client.InspectContainer("deadbeef1234")
+			var missing *docker.NoSuchContainer
+			So(errors.As(err, &missing), ShouldBeTrue)
+		})
+
+		Convey("fails pings when asked", func() {
+			So(client.Ping(), ShouldBeNil)
+			client.SetPingError(errors.New("down"))
+			So(client.Ping(), ShouldNotBeNil)
+		})
+
+		Convey("drives Docker discovery", func() {
+			disco := discovery.NewDockerDiscovery("", &discovery.DockerLabelNamer{Label: "ServiceName"}, "127.0.0.1")
+			disco.ClientProvider = func() (discovery.DockerClient, error) { return client, nil }
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			disco.Run(ctx, director.NewFreeLooper(director.FOREVER, nil))
+
+			waitFor := func(count int) int {
+				for i := 0; i < 300 && len(disco.Services()) != count; i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+				return len(disco.Services())
+			}
+
+			So(waitFor(1), ShouldEqual, 1)
+			So(disco.Services()[0].Name, ShouldEqual, "bocaccio")
+
+			client.RemoveContainer("deadbeef1234567890")
+			client.SendEvent(docker.APIEvents{ID: "deadbeef1234567890", Status: "die"})
+			So(waitFor(0), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+// Package sidecartest provides test doubles for the interfaces that programs
+// embedding or extending Sidecar implement or consume: catalog.Listener,
+// discovery.Discoverer, and discovery.DockerClient. They are safe for
+// concurrent use, since Sidecar calls them from its own goroutines.
+package sidecartest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+)
+
+const DefaultListenerBuffer = 100 // How many events a Listener holds before the state drops them
+
+// ErrNoEvent is returned by Listener.Next() when nothing arrives in time
+var ErrNoEvent = errors.New("no event received")
+
+// A Listener is a catalog.Listener that holds on to the events it is sent
+// until the test reads them
+type Listener struct {
+	ListenerName string
+	IsManaged    bool
+	Events       chan catalog.ChangeEvent
+}
+
+// NewListener returns an unmanaged Listener with room for
+// DefaultListenerBuffer events
+func NewListener(name string) *Listener {
+	return &Listener{
+		ListenerName: name,
+		Events:       make(chan catalog.ChangeEvent, DefaultListenerBuffer),
+	}
+}
+
+// Name is part of the catalog.Listener interface
+func (l *Listener) Name() string {
+	return l.ListenerName
+}
+
+// Chan is part of the catalog.Listener interface
+func (l *Listener) Chan() chan catalog.ChangeEvent {
+	return l.Events
+}
+
+// Managed is part of the catalog.Listener interface
+func (l *Listener) Managed() bool {
+	return l.IsManaged
+}
+
+// Next waits up to timeout for the next event
+func (l *Listener) Next(timeout time.Duration) (catalog.ChangeEvent, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case event := <-l.Events:
+		return event, nil
+	case <-timer.C:
+		return catalog.ChangeEvent{}, ErrNoEvent
+	}
+}
+
+// Received returns the events that have arrived so far, without waiting
+func (l *Listener) Received() []catalog.ChangeEvent {
+	var events []catalog.ChangeEvent
+	for {
+		select {
+		case event := <-l.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package sidecartest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var _ catalog.Listener = (*Listener)(nil)
+
+func Test_Listener(t *testing.T) {
+	Convey("Listener", t, func() {
+		state := catalog.NewServicesState()
+		state.Hostname = "chaucer"
+
+		listener := NewListener("tester")
+		state.AddListener(listener)
+
+		Convey("receives the state's events", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: time.Now().UTC(),
+			})
+
+			event, err := listener.Next(time.Second)
+			So(err, ShouldBeNil)
+			So(event.Service.ID, ShouldEqual, "deadbeef123")
+			So(listener.Received(), ShouldBeEmpty)
+		})
+
+		Convey("times out when nothing happens", func() {
+			_, err := listener.Next(time.Millisecond)
+			So(err, ShouldEqual, ErrNoEvent)
+		})
+
+		Convey("is unmanaged unless asked", func() {
+			So(listener.Name(), ShouldEqual, "tester")
+			So(listener.Managed(), ShouldBeFalse)
+		})
+	})
+}
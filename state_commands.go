@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StateCommandTimeout = 30 * time.Second
+)
+
+// exportState fetches a snapshot of the catalog from a running Sidecar and
+// saves it to a file, gzipped if the filename ends in .gz
+func exportState(addr string, filename string) error {
+	client := &http.Client{Timeout: StateCommandTimeout}
+
+	resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/api/state/export")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("got status %d: %s", resp.StatusCode, body)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var output io.Writer = file
+	if strings.HasSuffix(filename, ".gz") {
+		zipped := gzip.NewWriter(file)
+		defer zipped.Close()
+		output = zipped
+	}
+
+	written, err := io.Copy(output, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Exported %d bytes of state to %s", written, filename)
+	return nil
+}
+
+// importState sends a snapshot saved by exportState to a running Sidecar.
+// Gzipped files are recognized by their contents, not their name.
+func importState(addr string, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	input, err := maybeGunzip(file)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: StateCommandTimeout}
+
+	resp, err := client.Post(
+		strings.TrimSuffix(addr, "/")+"/api/state/import", "application/json", input,
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("got status %d: %s", resp.StatusCode, body)
+	}
+
+	log.Infof("Imported state from %s: %s", filename, body)
+	return nil
+}
+
+// maybeGunzip unzips the input if it's gzipped, going by its contents
+func maybeGunzip(input io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(input)
+
+	magic, err := reader.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(reader)
+	}
+
+	return reader, nil
+}
+
+// graphState draws the hosts and services of a running Sidecar, or of a
+// state snapshot when stateFile is set, in DOT or D2
+func graphState(addr string, stateFile string, format string, dependencies bool, output io.Writer) error {
+	if stateFile != "" {
+		state, err := loadStateFile(stateFile)
+		if err != nil {
+			return err
+		}
+		return state.WriteGraph(output, format, dependencies)
+	}
+
+	client := &http.Client{Timeout: StateCommandTimeout}
+
+	url := strings.TrimSuffix(addr, "/") + "/api/state/graph." + format
+	if dependencies {
+		url += "?dependencies=true"
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("got status %d: %s", resp.StatusCode, body)
+	}
+
+	_, err = io.Copy(output, resp.Body)
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log/syslog"
+
+	log "github.com/sirupsen/logrus"
+	logsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// newSyslogHook logs to the local syslog, or to a remote one over UDP when
+// an address is given
+func newSyslogHook(addr string) (log.Hook, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+	}
+	return logsyslog.NewSyslogHook(network, addr, syslog.LOG_DAEMON, "sidecar")
+}
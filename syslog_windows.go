@@ -0,0 +1,12 @@
+package main
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newSyslogHook fails, because Go has no syslog client on Windows
+func newSyslogHook(addr string) (log.Hook, error) {
+	return nil, errors.New("syslog isn't supported on windows")
+}
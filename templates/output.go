@@ -0,0 +1,332 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+)
+
+// An Output is a file generated from the state with a Go template, like the
+// HAproxy config but for anything else: Prometheus targets, an Nginx
+// upstream include, and so on. After the file changes, VerifyCmd is run to
+// check it, and if that fails the old file is put back. ReloadCmd is then run
+// to tell whatever reads the file about it. Both commands are optional.
+type Output struct {
+	Name      string // For logging, defaults to the File
+	Template  string // The template file, read again each time we render
+	Text      string // The template itself, used when there's no Template file
+	File      string // Where the output is written
+	VerifyCmd string
+	ReloadCmd string
+}
+
+// The Data a template is rendered with
+type Data struct {
+	ClusterName string
+	Hostname    string                        // Of this node
+	Services    map[string][]*service.Service // The alive services by name, oldest first
+}
+
+// PrometheusOutput returns an Output that writes the services that ask to be
+// scraped to a file, for Prometheus' file_sd_config
+func PrometheusOutput(filename string) *Output {
+	return &Output{
+		Name: "prometheus",
+		Text: "{{ prometheusTargets | json }}\n",
+		File: filename,
+	}
+}
+
+// LoadOutputs reads a JSON list of Outputs from a file, and makes sure that
+// their templates parse
+func LoadOutputs(filename string) ([]*Output, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template outputs: %w", err)
+	}
+
+	var outputs []*Output
+	err = json.Unmarshal(data, &outputs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template outputs from %s: %w", filename, err)
+	}
+
+	files := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		err := output.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if files[output.File] {
+			return nil, fmt.Errorf("more than one template output writes %s", output.File)
+		}
+		files[output.File] = true
+	}
+
+	return outputs, nil
+}
+
+// Validate makes sure the Output has somewhere to write to and a template
+// that parses
+func (o *Output) Validate() error {
+	if (o.Template == "" && o.Text == "") || o.File == "" {
+		return fmt.Errorf("template output %q needs both a Template and a File", o.name())
+	}
+
+	_, err := o.parseTemplate(templateFuncs(&catalog.ServicesState{}))
+	return err
+}
+
+func (o *Output) name() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return o.File
+}
+
+func (o *Output) parseTemplate(funcMap template.FuncMap) (*template.Template, error) {
+	contents := o.Text
+	if o.Template != "" {
+		data, err := ioutil.ReadFile(o.Template)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read template for %s: %w", o.name(), err)
+		}
+		contents = string(data)
+	}
+
+	t, err := template.New(o.name()).Funcs(funcMap).Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template for %s: %w", o.name(), err)
+	}
+
+	return t, nil
+}
+
+// Render writes the output for the state to the io.Writer
+func (o *Output) Render(state *catalog.ServicesState, output io.Writer) error {
+	// Work on the shared view, so we don't hold the state lock while rendering
+	snapshot := state.ServicesView()
+
+	t, err := o.parseTemplate(templateFuncs(snapshot))
+	if err != nil {
+		return err
+	}
+
+	data := Data{
+		ClusterName: snapshot.ClusterName,
+		Hostname:    snapshot.Hostname,
+		Services:    aliveByName(snapshot),
+	}
+
+	// We render into a buffer so a template error doesn't leave a partial file
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return fmt.Errorf("error executing template for %s: %w", o.name(), err)
+	}
+
+	_, err = io.Copy(output, &buf)
+	return err
+}
+
+// Write renders the output to its File. When the contents have changed, the
+// new file is verified, and the reload command run. Returns whether the file
+// changed.
+func (o *Output) Write(state *catalog.ServicesState) (bool, error) {
+	var buf bytes.Buffer
+	err := o.Render(state, &buf)
+	if err != nil {
+		return false, err
+	}
+
+	previous, err := ioutil.ReadFile(o.File)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("unable to read %s: %w", o.File, err)
+	}
+	existed := err == nil
+
+	if existed && bytes.Equal(previous, buf.Bytes()) {
+		return false, nil
+	}
+
+	err = writeFile(o.File, buf.Bytes())
+	if err != nil {
+		return false, fmt.Errorf("unable to write %s: %w", o.File, err)
+	}
+
+	if o.VerifyCmd != "" {
+		err = run(o.VerifyCmd)
+		if err != nil {
+			// Put back what was there, so the next reload doesn't pick it up
+			if existed {
+				writeFile(o.File, previous)
+			} else {
+				os.Remove(o.File)
+			}
+			return false, fmt.Errorf("failed to verify %s: %w", o.name(), err)
+		}
+	}
+
+	if o.ReloadCmd != "" {
+		err = run(o.ReloadCmd)
+		if err != nil {
+			return true, fmt.Errorf("failed to reload %s: %w", o.name(), err)
+		}
+	}
+
+	return true, nil
+}
+
+// writeFile writes the data to a temporary file and moves it into place, so
+// nothing ever reads half a file
+func writeFile(filename string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// run executes a command line, returning its output with any error
+func run(command string) error {
+	cmd := ShellCommand(command)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("error running '%s': %w\n%s", command, err, output.String())
+	}
+	return nil
+}
+
+// aliveByName returns the services that are alive, by name, oldest first
+func aliveByName(state *catalog.ServicesState) map[string][]*service.Service {
+	byName := make(map[string][]*service.Service)
+	state.EachServiceSorted(
+		func(hostname *string, serviceId *string, svc *service.Service) {
+			if state.IsServable(svc) {
+				byName[svc.Name] = append(byName[svc.Name], svc)
+			}
+		},
+	)
+	return byName
+}
+
+// templateFuncs are the functions templates can use to look things up in
+// the state
+func templateFuncs(state *catalog.ServicesState) template.FuncMap {
+	byPort := state.ByServiceAndPort()
+
+	servicesOn := func(name string, servicePort int64) []*service.Service {
+		var alive []*service.Service
+		for _, svc := range byPort[catalog.ServicePortKey{Name: name, ServicePort: servicePort}] {
+			if state.IsServable(svc) {
+				alive = append(alive, svc)
+			}
+		}
+		return alive
+	}
+
+	return template.FuncMap{
+		"now": time.Now().UTC,
+		// The names of the services alive on any ServicePort, sorted
+		"serviceNames": func() []string {
+			seen := make(map[string]bool)
+			var names []string
+			for key := range byPort {
+				if !seen[key.Name] && len(servicesOn(key.Name, key.ServicePort)) > 0 {
+					seen[key.Name] = true
+					names = append(names, key.Name)
+				}
+			}
+			sort.Strings(names)
+			return names
+		},
+		// The ServicePorts a service is alive on, sorted
+		"servicePorts": func(name string) []int64 {
+			var ports []int64
+			for key := range byPort {
+				if key.Name == name && len(servicesOn(key.Name, key.ServicePort)) > 0 {
+					ports = append(ports, key.ServicePort)
+				}
+			}
+			sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+			return ports
+		},
+		"servicesOn": servicesOn,
+		// The services that ask to be scraped, for Prometheus file_sd
+		"prometheusTargets": state.PrometheusTargets,
+		// The "address:port" of each alive instance on a ServicePort
+		"targets": func(name string, servicePort int64) []string {
+			var targets []string
+			for _, svc := range servicesOn(name, servicePort) {
+				targets = append(targets, targetFor(svc, servicePort))
+			}
+			return targets
+		},
+		"portFor": func(svc *service.Service, servicePort int64) int64 {
+			return findPort(svc, servicePort).Port
+		},
+		"ipFor": func(svc *service.Service, servicePort int64) string {
+			return addressFor(svc, servicePort)
+		},
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"join": strings.Join,
+	}
+}
+
+// findPort returns the port of the service with the ServicePort
+func findPort(svc *service.Service, servicePort int64) service.Port {
+	for _, port := range svc.Ports {
+		if port.ServicePort == servicePort {
+			return port
+		}
+	}
+	return service.Port{Port: -1}
+}
+
+// addressFor returns the IP the service announced for the ServicePort, or
+// its hostname when there isn't one
+func addressFor(svc *service.Service, servicePort int64) string {
+	if ip := findPort(svc, servicePort).IP; ip != "" {
+		return ip
+	}
+	return svc.Hostname
+}
+
+func targetFor(svc *service.Service, servicePort int64) string {
+	port := findPort(svc, servicePort).Port
+	return net.JoinHostPort(addressFor(svc, servicePort), strconv.FormatInt(port, 10))
+}
@@ -0,0 +1,223 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/NinesStack/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Output(t *testing.T) {
+	Convey("Output", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-templates")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		state := catalog.NewServicesState()
+		state.ClusterName = "cantebury"
+		baseTime := time.Now().UTC()
+
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef123", Name: "bocaccio", Hostname: "chaucer", Updated: baseTime,
+			Status: service.ALIVE,
+			Ports: []service.Port{
+				{Type: "tcp", Port: 10000, ServicePort: 8080, IP: "10.0.0.1"},
+				{Type: "tcp", Port: 10001},
+			},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef456", Name: "bocaccio", Hostname: "petrarch", Updated: baseTime.Add(time.Second),
+			Status: service.ALIVE,
+			Ports:  []service.Port{{Type: "tcp", Port: 10002, ServicePort: 8080, IP: "10.0.0.2"}},
+		})
+		state.AddServiceEntry(service.Service{
+			ID: "deadbeef789", Name: "dante", Hostname: "petrarch", Updated: baseTime,
+			Status: service.UNHEALTHY,
+			Ports:  []service.Port{{Type: "tcp", Port: 10003, ServicePort: 9090, IP: "10.0.0.2"}},
+		})
+
+		writeTemplate := func(contents string) string {
+			filename := filepath.Join(dir, "output.tmpl")
+			So(ioutil.WriteFile(filename, []byte(contents), 0644), ShouldBeNil)
+			return filename
+		}
+
+		outFile := filepath.Join(dir, "output.txt")
+
+		Convey("renders the alive services", func() {
+			output := &Output{
+				Template: writeTemplate(
+					`{{ .ClusterName }}{{ range serviceNames }} {{ . }}:{{ range servicePorts . }}{{ . }}{{ end }}{{ end }}`,
+				),
+				File: outFile,
+			}
+
+			var buf bytes.Buffer
+			So(output.Render(state, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "cantebury bocaccio:8080")
+		})
+
+		Convey("renders targets for Prometheus", func() {
+			output := &Output{
+				Template: writeTemplate(`[{"targets": {{ targets "bocaccio" 8080 | json }}}]`),
+				File:     outFile,
+			}
+
+			var buf bytes.Buffer
+			So(output.Render(state, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `[{"targets": ["10.0.0.1:10000","10.0.0.2:10002"]}]`)
+		})
+
+		Convey("renders instances for an Nginx upstream", func() {
+			output := &Output{
+				Template: writeTemplate(
+					`{{ range servicesOn "bocaccio" 8080 }}server {{ ipFor . 8080 }}:{{ portFor . 8080 }};{{ end }}`,
+				),
+				File: outFile,
+			}
+
+			var buf bytes.Buffer
+			So(output.Render(state, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "server 10.0.0.1:10000;server 10.0.0.2:10002;")
+		})
+
+		Convey("writes the file, then verifies and reloads", func() {
+			reloaded := filepath.Join(dir, "reloaded")
+			output := &Output{
+				Template:  writeTemplate(`{{ len .Services }}`),
+				File:      outFile,
+				VerifyCmd: "grep -q 1 " + outFile,
+				ReloadCmd: "touch " + reloaded,
+			}
+
+			changed, err := output.Write(state)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+
+			contents, _ := ioutil.ReadFile(outFile)
+			So(string(contents), ShouldEqual, "1")
+			_, err = os.Stat(reloaded)
+			So(err, ShouldBeNil)
+
+			Convey("and leaves it alone when nothing changed", func() {
+				os.Remove(reloaded)
+
+				changed, err := output.Write(state)
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeFalse)
+				_, err = os.Stat(reloaded)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("puts the old file back when it doesn't verify", func() {
+			So(ioutil.WriteFile(outFile, []byte("previous"), 0644), ShouldBeNil)
+			output := &Output{
+				Template:  writeTemplate(`broken`),
+				File:      outFile,
+				VerifyCmd: "false",
+				ReloadCmd: "touch " + filepath.Join(dir, "reloaded"),
+			}
+
+			changed, err := output.Write(state)
+			So(err, ShouldNotBeNil)
+			So(changed, ShouldBeFalse)
+
+			contents, _ := ioutil.ReadFile(outFile)
+			So(string(contents), ShouldEqual, "previous")
+			_, err = os.Stat(filepath.Join(dir, "reloaded"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("writes Prometheus targets with the built-in output", func() {
+			state.AddServiceEntry(service.Service{
+				ID: "deadbeef999", Name: "virgil", Hostname: "petrarch", Updated: baseTime,
+				Status: service.ALIVE,
+				Tags:   map[string]string{catalog.PrometheusPortTag: "9090"},
+				Ports:  []service.Port{{Type: "tcp", Port: 10004, ServicePort: 9090, IP: "10.0.0.2"}},
+			})
+			output := PrometheusOutput(outFile)
+			So(output.Validate(), ShouldBeNil)
+
+			changed, err := output.Write(state)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+
+			contents, _ := ioutil.ReadFile(outFile)
+			So(string(contents), ShouldStartWith, `[{"targets":["10.0.0.2:10004"],"labels":{`)
+		})
+
+		Convey("doesn't write anything for a broken template", func() {
+			output := &Output{Template: writeTemplate(`{{ nonsense }}`), File: outFile}
+
+			_, err := output.Write(state)
+			So(err, ShouldNotBeNil)
+			_, err = os.Stat(outFile)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_LoadOutputs(t *testing.T) {
+	Convey("LoadOutputs()", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-templates")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		tmpl := filepath.Join(dir, "targets.tmpl")
+		So(ioutil.WriteFile(tmpl, []byte(`{{ targets "web" 80 | json }}`), 0644), ShouldBeNil)
+		config := filepath.Join(dir, "outputs.json")
+
+		Convey("reads the outputs", func() {
+			So(ioutil.WriteFile(config, []byte(`[
+				{"Name": "prometheus", "Template": "`+tmpl+`", "File": "/tmp/targets.json"},
+				{"Template": "`+tmpl+`", "File": "/tmp/other.json", "ReloadCmd": "true"}
+			]`), 0644), ShouldBeNil)
+
+			outputs, err := LoadOutputs(config)
+			So(err, ShouldBeNil)
+			So(len(outputs), ShouldEqual, 2)
+			So(outputs[0].name(), ShouldEqual, "prometheus")
+			So(outputs[1].name(), ShouldEqual, "/tmp/other.json")
+			So(outputs[1].ReloadCmd, ShouldEqual, "true")
+		})
+
+		Convey("rejects outputs without a file", func() {
+			So(ioutil.WriteFile(config, []byte(`[{"Template": "`+tmpl+`"}]`), 0644), ShouldBeNil)
+
+			_, err := LoadOutputs(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects two outputs writing the same file", func() {
+			So(ioutil.WriteFile(config, []byte(`[
+				{"Template": "`+tmpl+`", "File": "/tmp/targets.json"},
+				{"Template": "`+tmpl+`", "File": "/tmp/targets.json"}
+			]`), 0644), ShouldBeNil)
+
+			_, err := LoadOutputs(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects templates that don't parse", func() {
+			So(ioutil.WriteFile(tmpl, []byte(`{{ nonsense }}`), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(config, []byte(`[{"Template": "`+tmpl+`", "File": "/tmp/x"}]`), 0644), ShouldBeNil)
+
+			_, err := LoadOutputs(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns an error for a missing file", func() {
+			_, err := LoadOutputs(filepath.Join(dir, "missing.json"))
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, os.ErrNotExist), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package templates
+
+import (
+	"os/exec"
+)
+
+// ShellCommand runs a verify or reload command line through the shell
+func ShellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/bash", "-c", command)
+}
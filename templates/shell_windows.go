@@ -0,0 +1,10 @@
+package templates
+
+import (
+	"os/exec"
+)
+
+// ShellCommand runs a verify or reload command line through the shell
+func ShellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
@@ -0,0 +1,85 @@
+package templates
+
+import (
+	"context"
+
+	"github.com/NinesStack/sidecar/catalog"
+	"github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// A Writer keeps a set of Outputs up to date with the state. It's a
+// catalog.Listener, and writes them all on every change.
+type Writer struct {
+	Outputs      []*Output
+	eventChannel chan catalog.ChangeEvent
+}
+
+// NewWriter returns a Writer for the Outputs
+func NewWriter(outputs []*Output) *Writer {
+	return &Writer{
+		Outputs:      outputs,
+		eventChannel: make(chan catalog.ChangeEvent, 2),
+	}
+}
+
+// Watch writes the Outputs, then writes them again whenever the state
+// changes. Returns when the context is cancelled.
+func (w *Writer) Watch(ctx context.Context, state *catalog.ServicesState) {
+	// Each write renders from the whole state, so we don't care about
+	// missing events, only that we see the latest one
+	state.AddListener(w, catalog.WithOverflowResync())
+
+	w.WriteAll(state)
+
+OUTER:
+	for {
+		select {
+		case _, ok := <-w.eventChannel:
+			if !ok {
+				break OUTER
+			}
+			w.WriteAll(state)
+		case <-ctx.Done():
+			break OUTER
+		}
+	}
+
+	err := state.RemoveListener(w.Name())
+	if err != nil {
+		log.Warnf("Failed to remove template outputs listener: %s", err)
+	}
+}
+
+// WriteAll writes each of the Outputs. One failing doesn't stop the others.
+func (w *Writer) WriteAll(state *catalog.ServicesState) {
+	for _, output := range w.Outputs {
+		changed, err := output.Write(state)
+		if err != nil {
+			metrics.IncrCounter([]string{"templates", "errors"}, 1)
+			log.Errorf("Template output: %s", err)
+			continue
+		}
+
+		if changed {
+			metrics.IncrCounter([]string{"templates", "writes"}, 1)
+			log.Infof("Wrote template output %s", output.name())
+		}
+	}
+}
+
+// Name is part of the catalog.Listener interface
+func (w *Writer) Name() string {
+	return "TemplateOutputs"
+}
+
+// Managed is part of the catalog.Listener interface. We add and remove
+// ourselves.
+func (w *Writer) Managed() bool {
+	return false
+}
+
+// Chan is part of the catalog.Listener interface
+func (w *Writer) Chan() chan catalog.ChangeEvent {
+	return w.eventChannel
+}
@@ -4,10 +4,18 @@
 angular.module('sidecar', [
   'ngRoute',
   'sidecar.services',
+  'sidecar.topology',
+  'sidecar.timeline',
 //  'sidecar.version'
 ]).
 config(['$locationProvider', '$routeProvider', function($locationProvider, $routeProvider) {
   $locationProvider.hashPrefix('!');
 
   $routeProvider.otherwise({redirectTo: '/services'});
+}]).
+run(['$rootScope', '$location', function($rootScope, $location) {
+  // Highlights the page we're on in the nav
+  $rootScope.isCurrentPage = function(path) {
+    return $location.path() == path;
+  };
 }]);
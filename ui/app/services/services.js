@@ -73,6 +73,7 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 .controller('servicesCtrl', function($scope, $interval, stateService) {
     $scope.serverList = {};
 	$scope.clusterName = "";
+	$scope.hostCount = 0;
 	$scope.servicesList = {};
 	$scope.collapsed = {};
 	$scope.expandedServiceInfo = {};
@@ -120,6 +121,7 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 
 		$scope.clusterName = servicesResponse.ClusterName;
 		$scope.serverList = servicesResponse.ClusterMembers;
+		$scope.hostCount = _.size($scope.serverList);
 
 		// Haproxy
 		var haproxyResponse = stateService.getHaproxy();
@@ -207,6 +209,23 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 	}
 })
 
+.filter('statusClass', function() {
+	return function(status) {
+	    switch (status) {
+	    case 0:
+	        return "success"
+	    case 2:
+	        return "danger"
+	    case 3:
+	        return "warning"
+	    case 4:
+	        return "info"
+	    default:
+	        return "default"
+	    }
+	}
+})
+
 .filter('timeAgo', function() {
 	return function(textDate) {
 		if (textDate == null || textDate == "") {
@@ -0,0 +1,158 @@
+'use strict';
+
+// A timeline of the status changes for each service, from the history the
+// v2 API keeps for every instance
+angular.module('sidecar.timeline', ['ngRoute', 'sidecar.services'])
+
+.config(['$routeProvider', function($routeProvider) {
+  $routeProvider.when('/timeline', {
+    templateUrl: 'timeline/timeline.html',
+    controller: 'timelineCtrl'
+  });
+}])
+
+.factory('historyService', function($http, $q) {
+	var pageUrl = '/api/v2/services?include=history&status=all&limit=1000' +
+		'&fields=ID,Name,Hostname,Status,History';
+
+	// Fetches every page of instances, following the cursors. With a state
+	// version, only the servers that changed since then are sent. Resolves
+	// with the instances and the version to pass next time.
+	function getInstances(since) {
+		var instances = [];
+		var version = since;
+
+		function getPage(cursor) {
+			var url = pageUrl;
+			if (since !== null) {
+				url += '&since=' + since;
+			}
+			if (cursor) {
+				url += '&cursor=' + encodeURIComponent(cursor);
+			}
+
+			return $http({ method: 'GET', url: url, dataType: 'json' }).then(function(response) {
+				instances = instances.concat(response.data.Data || []);
+				// Later pages may be newer, so stay with the first one's version
+				if (!cursor && response.data.Meta) {
+					version = response.data.Meta.Version;
+				}
+				if (response.data.Meta && response.data.Meta.Next) {
+					return getPage(response.data.Meta.Next);
+				}
+				return { Instances: instances, Version: version };
+			});
+		};
+
+		return getPage(null);
+	};
+
+	return { getInstances: getInstances };
+})
+
+.controller('timelineCtrl', function($scope, $interval, historyService) {
+	var MIN_WINDOW_MS = 10 * 60 * 1000; // Always show at least 10 minutes
+	var MAX_CHANGES = 100;              // How many changes we list
+	var FULL_RELOAD_POLLS = 15;         // Fetch everything about once a minute
+
+	var instances = {}; // By ID
+	var version = null; // The state version we're up to date with
+	var polls = 0;
+
+	$scope.services = [];
+	$scope.changes = [];
+	$scope.windowStart = null;
+	$scope.collapsed = {};
+
+	$scope.toggleCollapse = function(svcName) {
+		$scope.collapsed[svcName] = !$scope.collapsed[svcName];
+	};
+
+	// Turns an instance's transitions into bars across the window, each
+	// colored by the status it was in
+	function segmentsFor(instance, start, end) {
+		var history = instance.History || [];
+		var span = end - start;
+		var segments = [];
+
+		var status = history.length > 0 ? history[0].PreviousStatus : instance.Status;
+		var from = start;
+		_.each(history, function(transition) {
+			var at = Math.max(Date.parse(transition.Time), start);
+			segments.push({ Status: status, From: from, To: at });
+			status = transition.Status;
+			from = at;
+		});
+		segments.push({ Status: status, From: from, To: end });
+
+		return _.map(_.filter(segments, function(s) { return s.To > s.From; }), function(s) {
+			return {
+				Status: s.Status,
+				Since: new Date(s.From).toISOString(),
+				Left: ((s.From - start) / span * 100) + '%',
+				Width: ((s.To - s.From) / span * 100) + '%'
+			};
+		});
+	};
+
+	// Replaces the instances on the servers that were sent. Each server that
+	// changed is sent with all of its instances, so any we had that weren't
+	// sent are gone.
+	function mergeInstances(fetched, full) {
+		if (full) {
+			instances = {};
+		} else {
+			var hostnames = _.indexBy(fetched, 'Hostname');
+			instances = _.omit(instances, function(instance) {
+				return _.has(hostnames, instance.Hostname);
+			});
+		}
+
+		_.each(fetched, function(instance) {
+			instances[instance.ID] = instance;
+		});
+	};
+
+	function render() {
+		var end = Date.now();
+		var start = end - MIN_WINDOW_MS;
+		var changes = [];
+
+		_.each(instances, function(instance) {
+			_.each(instance.History, function(transition) {
+				start = Math.min(start, Date.parse(transition.Time));
+				changes.push(_.extend({ Name: instance.Name, ID: instance.ID }, transition));
+			});
+		});
+
+		var byName = _.groupBy(_.values(instances), 'Name');
+		$scope.services = _.map(_.keys(byName).sort(), function(name) {
+			return {
+				Name: name,
+				Instances: _.map(_.sortBy(byName[name], 'Hostname'), function(instance) {
+					return _.extend({ Segments: segmentsFor(instance, start, end) }, instance);
+				})
+			};
+		});
+
+		$scope.windowStart = new Date(start).toISOString();
+		$scope.changes = _.sortBy(changes, function(c) { return -Date.parse(c.Time); }).slice(0, MAX_CHANGES);
+	};
+
+	function updateData() {
+		var full = version === null || polls % FULL_RELOAD_POLLS === 0;
+		polls++;
+
+		historyService.getInstances(full ? null : version).then(function(result) {
+			mergeInstances(result.Instances, full);
+			version = result.Version;
+			render();
+		});
+	};
+
+	updateData();
+	var refresh = $interval(updateData, 4000);
+	$scope.$on('$destroy', function() { $interval.cancel(refresh); });
+})
+
+;
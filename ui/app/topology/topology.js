@@ -0,0 +1,62 @@
+'use strict';
+
+// A host-centric view of the cluster: which services run where, colored by
+// their status
+angular.module('sidecar.topology', ['ngRoute', 'sidecar.services'])
+
+.config(['$routeProvider', function($routeProvider) {
+  $routeProvider.when('/topology', {
+    templateUrl: 'topology/topology.html',
+    controller: 'topologyCtrl'
+  });
+}])
+
+.controller('topologyCtrl', function($scope, $interval, stateService) {
+	$scope.clusterName = "";
+	$scope.hosts = [];
+	$scope.showTombstones = false;
+
+	$scope.toggleTombstones = function() {
+		$scope.showTombstones = !$scope.showTombstones;
+		updateData();
+	};
+
+	function updateData() {
+		var servicesResponse = stateService.getServices();
+		var members = servicesResponse.ClusterMembers || {};
+
+		// Start with every member, so hosts without services still show up
+		var hosts = {};
+		for (var hostname in members) {
+			hosts[hostname] = { Name: hostname, Member: members[hostname], Services: [] };
+		}
+
+		for (var svcName in servicesResponse.Services) {
+			_.each(servicesResponse.Services[svcName], function(svc) {
+				if (svc.Status == 1 && !$scope.showTombstones) {
+					return;
+				}
+
+				hosts[svc.Hostname] = hosts[svc.Hostname] ||
+					{ Name: svc.Hostname, Member: null, Services: [] };
+				hosts[svc.Hostname].Services.push(svc);
+			});
+		}
+
+		_.each(hosts, function(host) {
+			host.Services = _.sortBy(host.Services, 'Name');
+			host.Counts = _.countBy(host.Services, 'Status');
+		});
+
+		$scope.clusterName = servicesResponse.ClusterName;
+		$scope.hosts = _.sortBy(_.values(hosts), 'Name');
+	};
+
+	stateService.waitFirstServices.then(function() {
+		updateData();
+		var refresh = $interval(updateData, 4000);
+		$scope.$on('$destroy', function() { $interval.cancel(refresh); });
+	}, function(){});
+})
+
+;
@@ -0,0 +1,24 @@
+// Package ui holds the web UI, compiled into the Sidecar binary so that it
+// works no matter what the working directory is. The bower components are
+// only included when they were installed before the binary was built. The
+// UI can be served from disk instead, see HTTP_UI_DIR.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed app
+var app embed.FS
+
+// Files returns the web UI, as served under /ui
+func Files() fs.FS {
+	files, err := fs.Sub(app, "app")
+	if err != nil {
+		// Can't happen, the directory is embedded above
+		panic(err)
+	}
+
+	return files
+}
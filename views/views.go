@@ -0,0 +1,27 @@
+// Package views holds the default templates and static files that are
+// compiled into the Sidecar binary. This means Sidecar will work no matter
+// what the working directory is. Any of these can be overridden with files on
+// disk.
+package views
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed haproxy.cfg
+var HAproxyTemplate string // The default template used to write the HAproxy config
+
+//go:embed static
+var static embed.FS
+
+// StaticFiles returns the images and other files served under /static
+func StaticFiles() fs.FS {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// Can't happen, the directory is embedded above
+		panic(err)
+	}
+
+	return files
+}